@@ -5,27 +5,43 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"maps"
+	"math"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/mbland/elistman/db"
 	"github.com/mbland/elistman/email"
 	"github.com/mbland/elistman/ops"
+	"github.com/mbland/elistman/types"
 )
 
 // SubscriptionAgent is the interface for the core EListMan business logic.
 //
-// Subscribe validates a pending subscriber and sends a verification email.
+// Subscribe validates a pending subscriber and sends a verification email. The
+// subscriber's db.Subscriber.Tags will contain the `topics` argument, which
+// may be empty. Its db.Subscriber.Signup will contain the `signup` argument,
+// which may be nil.
 //
 // Verify marks a pending subscriber as verified.
 //
 // Unsubscribe removes a verified subscriber from the list.
 //
+// UpdateTopics replaces a verified subscriber's db.Subscriber.Tags with the
+// `topics` argument, which may be empty. Like Verify and Unsubscribe, it
+// requires the subscriber's UID.
+//
 // Import adds a new verified subscriber without sending a verification email.
 // It's intended to allow importing of an existing subscriber from another email
 // system. It still performs address validation and will refuse to import
 // addresses that fail.
 //
+// Both Subscribe and Import save the FirstName and Attributes of the `profile`
+// argument, if it isn't nil, for personalizing messages. They fail if the
+// profile is invalid.
+//
 // Remove removes a subscriber from the list. It's used by the SNS handler to
 // automatically remove addresses in response to bounces or complaints.
 //
@@ -42,76 +58,603 @@ import (
 // match verified subscribers. If `addrs` contains invalid addresses, Send will
 // still send to every valid address that it can and report the rest in an
 // error.
+//
+// Send records every delivery under the `idempotencyKey` argument, and skips
+// any subscribers who've already received a message with the same key. This
+// prevents sending the same message to the same subscriber twice, even when
+// Send is called again with the same message after a failure. It returns the
+// number of subscribers skipped this way as numSkipped. When sending to the
+// entire list, the key defaults to the hash of the message if empty. When
+// sending to specific addresses, Send records deliveries only if the key isn't
+// empty, so test sends don't prevent a later send to the entire list.
+//
+// If the `tagFilter` argument isn't empty, it's a db.TagFilter expression, and
+// Send will send the message only to the subscribers whose tags match it.
+// `addrs` must be empty in this case.
+//
+// When sending to the entire list, Send uses the idempotency key as the
+// campaign ID and saves a db.SendCheckpoint as it progresses. If the send stops
+// before reaching every subscriber, Send returns an *IncompleteSendError
+// containing the campaign ID. Calling Send again with the same key resumes the
+// send from the checkpoint, and fails if `tagFilter` doesn't match the
+// campaign's original filter.
+//
+// ResumeSend continues sending a message to the entire list from the
+// db.SendCheckpoint for the specified campaign ID. The message must match the
+// message originally passed to Send. It skips any subscribers who've already
+// received the message, and applies the same tag filter as the original send.
+//
+// Both Send and ResumeSend record the progress of sends to the entire list in
+// a db.Campaign.
+//
+// GetCampaign returns the db.Campaign with the specified ID.
+//
+// ListCampaigns returns every db.Campaign, most recently started first.
+//
+// Schedule saves a message to send to the entire list at a later time, and
+// returns the ID of the resulting db.ScheduledMessage.
+//
+// SendScheduled sends every scheduled message that's due to the entire list,
+// via Send, using each message's ID as the idempotency key. It claims each
+// message before sending it, and deletes it only once its db.Campaign is
+// complete, so overlapping or failed invocations neither send it twice nor lose
+// it. If a send is incomplete, it releases the claim, so the next invocation
+// resumes the same db.Campaign from its db.SendCheckpoint. It's invoked
+// periodically by a scheduled event.
+//
+// If the context deadline approaches, SendScheduled stops without claiming any
+// more messages and returns an error wrapping ErrSendDeadlineApproaching. The
+// messages it didn't send remain scheduled for the next invocation.
+//
+// History returns every db.AuditEvent recorded for an email address, oldest
+// first.
+//
+// ExportSubscriber returns everything stored about an email address, to fulfill
+// a data subject access request.
+//
+// Erase deletes everything stored about an email address, to fulfill a data
+// subject erasure request. It leaves behind only a salted hash of the address,
+// so that Import and Restore won't add it back by accident.
+//
+// GetWelcomeMessage returns the message that Verify sends to each newly
+// verified subscriber, or nil if there isn't one.
+//
+// SetWelcomeMessage replaces the welcome message. If msg is nil, it deletes the
+// welcome message, and Verify stops sending one.
+//
+// RotateUids replaces the UIDs of up to batchSize verified subscribers
+// following startKey with new ones. Each previous UID remains valid for
+// gracePeriod, so that links in messages already sent still work. If it stops
+// before reaching every verified subscriber, it returns the nextKey to pass as
+// startKey to continue. Otherwise nextKey is nil.
+//
+// GetSubscriber returns the db.Subscriber for an email address, or
+// db.ErrSubscriberNotFound if there isn't one.
+//
+// ListSubscribers returns up to limit subscribers with the specified status
+// following startKey. If since isn't the zero time, it skips subscribers whose
+// StatusTime is before since. If domain isn't empty, it skips subscribers whose
+// addresses don't belong to domain. Like RotateUids, it returns the nextKey to
+// pass as startKey to continue if it stops before reaching every subscriber.
 type SubscriptionAgent interface {
 	//
-	Subscribe(ctx context.Context, email string) (ops.OperationResult, error)
+	Subscribe(
+		ctx context.Context,
+		email string,
+		topics []string,
+		signup *db.SignupMetadata,
+		profile *Profile,
+	) (ops.OperationResult, error)
 	Verify(
 		ctx context.Context, email string, uid uuid.UUID,
 	) (ops.OperationResult, error)
 	Unsubscribe(
 		ctx context.Context, email string, uid uuid.UUID,
 	) (ops.OperationResult, error)
+	UpdateTopics(
+		ctx context.Context, email string, uid uuid.UUID, topics []string,
+	) (ops.OperationResult, error)
 	Validate(
 		ctx context.Context, address string,
 	) (failure *email.ValidationFailure, err error)
-	Import(ctx context.Context, address string) (err error)
+	Import(ctx context.Context, address string, profile *Profile) (err error)
 	Remove(ctx context.Context, email string, reason ops.RemoveReason) error
 	Restore(ctx context.Context, email string) error
 	Send(
-		ctx context.Context, msg *email.Message, addrs []string,
-	) (numSent int, err error)
+		ctx context.Context,
+		msg *email.Message,
+		addrs []string,
+		idempotencyKey string,
+		tagFilter string,
+	) (numSent, numSkipped int, err error)
+	ResumeSend(
+		ctx context.Context, campaignId string, msg *email.Message,
+	) (numSent, numSkipped int, err error)
+	GetCampaign(ctx context.Context, id string) (*db.Campaign, error)
+	ListCampaigns(ctx context.Context) ([]*db.Campaign, error)
+	Schedule(
+		ctx context.Context, msg *email.Message, sendAt time.Time,
+	) (id string, err error)
+	SendScheduled(ctx context.Context) (numSent int, err error)
+	History(ctx context.Context, email string) ([]*db.AuditEvent, error)
+	ExportSubscriber(ctx context.Context, email string) (*SubscriberData, error)
+	Erase(ctx context.Context, email string) error
+	GetWelcomeMessage(ctx context.Context) (*email.Message, error)
+	SetWelcomeMessage(ctx context.Context, msg *email.Message) error
+	RotateUids(
+		ctx context.Context,
+		gracePeriod time.Duration,
+		startKey *db.ScanKey,
+		batchSize int,
+	) (numRotated int, nextKey *db.ScanKey, err error)
+	GetSubscriber(ctx context.Context, email string) (*db.Subscriber, error)
+	ListSubscribers(
+		ctx context.Context,
+		status db.SubscriberStatus,
+		since time.Time,
+		domain string,
+		startKey *db.ScanKey,
+		limit int,
+	) (subs []*db.Subscriber, nextKey *db.ScanKey, err error)
+}
+
+// SubscriberData contains everything stored about an email address, as
+// returned by SubscriptionAgent.ExportSubscriber.
+//
+// List names the list the data belongs to, and is empty for the default list.
+// Subscriber and Tombstone are nil if there's no such record for the address.
+// Suppressed indicates whether the address is on the account's suppression
+// list. Erased indicates whether the address's data was erased before.
+type SubscriberData struct {
+	List       string
+	Email      string
+	Subscriber *db.Subscriber
+	Tombstone  *db.Tombstone
+	Suppressed bool
+	Erased     bool
+	History    []*db.AuditEvent
 }
 
+// Profile contains the personalization values for a subscriber, which become
+// db.Subscriber.FirstName and db.Subscriber.Attributes.
+//
+// Attribute names must satisfy email.IsAttributeName. Messages refer to them
+// as variables declared in email.Message.Defaults.
+type Profile struct {
+	FirstName  string            `json:",omitempty"`
+	Attributes map[string]string `json:",omitempty"`
+}
+
+// maxProfileValueLength and maxProfileAttributes limit the size of the
+// resulting database record.
+const maxProfileValueLength = 256
+const maxProfileAttributes = 32
+
+func (p *Profile) validate() error {
+	if p == nil {
+		return nil
+	}
+	errs := make([]error, 0, len(p.Attributes)+1)
+	checkLength := func(field, value string) {
+		if maxLen := maxProfileValueLength; len(value) > maxLen {
+			const errFmt = "%s longer than %d bytes"
+			errs = append(errs, fmt.Errorf(errFmt, field, maxLen))
+		}
+	}
+
+	checkLength("first name", p.FirstName)
+	if len(p.Attributes) > maxProfileAttributes {
+		const errFmt = "too many attributes: %d (max %d)"
+		errs = append(
+			errs, fmt.Errorf(errFmt, len(p.Attributes), maxProfileAttributes),
+		)
+	}
+	for _, name := range slices.Sorted(maps.Keys(p.Attributes)) {
+		if !email.IsAttributeName(name) {
+			errs = append(errs, fmt.Errorf("invalid attribute name %q", name))
+		}
+		checkLength("attribute "+name, p.Attributes[name])
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid profile: %w", err)
+	}
+	return nil
+}
+
+func (p *Profile) apply(sub *db.Subscriber) {
+	if p != nil {
+		sub.FirstName = p.FirstName
+		sub.Attributes = maps.Clone(p.Attributes)
+	}
+}
+
+// IncompleteSendError indicates that a send to the entire list stopped before
+// reaching every verified subscriber.
+//
+// Pass CampaignId to SubscriptionAgent.ResumeSend to resume the send.
+type IncompleteSendError struct {
+	CampaignId string
+	Err        error
+}
+
+func (e *IncompleteSendError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *IncompleteSendError) Unwrap() error {
+	return e.Err
+}
+
+// ErrSendDeadlineApproaching indicates that a send to the entire list stopped
+// because the context deadline was approaching.
+//
+// This happens when a send would otherwise exceed the Lambda timeout.
+const ErrSendDeadlineApproaching = types.SentinelError(
+	"stopped sending as the deadline approached",
+)
+
+// ErrAddressLeftList indicates that an operation didn't add an address to the
+// list because the address left the list too recently, per the
+// TombstonePolicy.
+const ErrAddressLeftList = types.SentinelError("address left the list")
+
+// ErrAddressErased indicates that Import or Restore didn't add an address to
+// the list because its data was erased upon request.
+const ErrAddressErased = types.SentinelError("address was erased")
+
+// TombstonePolicy determines how long after an address leaves the list
+// ProdAgent refuses to add it back.
+//
+// Each field is a cooldown measured from the time in the address's
+// db.Tombstone. Zero means the operation ignores tombstones, and
+// TombstoneForever means the operation never adds the address back.
+//
+// Restore only consults tombstones with the reason db.TombstoneUnsubscribe,
+// since it's meant to reverse the removal of an address due to a complaint.
+type TombstonePolicy struct {
+	Subscribe time.Duration
+	Import    time.Duration
+	Restore   time.Duration
+}
+
+// TombstoneForever is the TombstonePolicy cooldown that's never over.
+const TombstoneForever = time.Duration(math.MaxInt64)
+
 // ProdAgent is the production implementation of core EListMan business logic.
+//
+// When Subscribe finds a pending subscriber, it resends the verification email,
+// but only if VerifyResendCooldown has passed since the last one, and only if
+// the subscriber hasn't already received MaxVerifyEmails. This keeps Subscribe
+// from becoming a means to flood an address with verification emails. If
+// MaxVerifyEmails is zero or one, Subscribe never resends. Either way, it adds
+// any new topics to the pending subscriber's tags. Subscribe doesn't change the
+// tags of verified subscribers. It only records the signup metadata of new
+// subscribers, so a pending subscriber's metadata always describes the original
+// request.
+//
+// List is the name of the list the agent serves, or empty for the default list.
+// It appears in the verify and unsubscribe links the agent sends. Db,
+// Checkpoints, Campaigns, Schedules, Audit, Tombstones, and Welcome must store
+// only that list's data.
+//
+// Unsubscribe and Remove save a db.Tombstone for each address that leaves the
+// list. Subscribe, Import, and Restore consult it per the TombstonePolicy, so
+// that no one can immediately add back an address that just left. Unsubscribe
+// always replaces an existing Tombstone, but Remove keeps it, so that a
+// complaint about an earlier message can't hide the fact that the subscriber
+// chose to leave. Verify, Import, and Restore delete the Tombstone when the
+// address rejoins the list. When Subscribe refuses an address, it still
+// returns ops.VerifyLinkSent, so the response doesn't reveal that the address
+// left the list.
+//
+// If Welcome contains a welcome message for the list, Verify sends it to each
+// newly verified subscriber, with the same unsubscribe headers and footers as
+// every other message sent to the list. Verify only logs an error if the
+// welcome message fails to load or send, since the subscriber is already
+// verified by then.
+//
+// ProdAgent appends a db.AuditEvent to Audit whenever Subscribe, Verify,
+// Unsubscribe, Import, Remove, or Restore changes a subscriber. The event's
+// source and request ID come from the context passed to the operation (see
+// WithAuditInfo).
+//
+// NewUid should return cryptographically random UIDs, such as those from
+// uuid.NewRandom, since a subscriber's UID is the only secret in its verify and
+// unsubscribe links. Verify and Unsubscribe accept a subscriber's previous UID
+// until it expires after RotateUids (see db.Subscriber.MatchesUid). RotateUids
+// only rotates the UIDs of verified subscribers, since pending subscribers
+// expire soon enough anyway.
+//
+// If VerifyTokenKeys isn't empty, each verification link contains an
+// ops.VerifyToken instead of the subscriber's UID. The token expires when the
+// pending subscriber does, per its db.Subscriber.Timestamp. Checking the token
+// is up to the caller of Verify, since Verify only receives the UID.
+//
+// Erase requires ErasureSalt, the secret key for the HMAC-SHA256 hash of each
+// erased address it saves in Erasures. Import and Restore refuse any address
+// with a saved hash, but Subscribe doesn't, since the address's owner may
+// always choose to rejoin the list. Erase doesn't record a db.AuditEvent, since
+// that would preserve the address it just erased.
 type ProdAgent struct {
-	SenderAddress    string
-	EmailSiteTitle   string
-	EmailDomainName  string
-	UnsubscribeEmail string
-	UnsubscribeUrl   string
-	ApiBaseUrl       string
-	NewUid           func() (uuid.UUID, error)
-	CurrentTime      func() time.Time
-	Db               db.Database
-	Validator        email.AddressValidator
-	Mailer           email.Mailer
-	Suppressor       email.Suppressor
-	Log              *log.Logger
+	List                 string
+	SenderAddress        string
+	EmailSiteTitle       string
+	EmailDomainName      string
+	UnsubscribeEmail     string
+	UnsubscribeUrl       string
+	ApiBaseUrl           string
+	VerifyResendCooldown time.Duration
+	MaxVerifyEmails      int
+	TombstonePolicy      TombstonePolicy
+	ErasureSalt          string
+	VerifyTokenKeys      ops.VerifyTokenKeys
+	NewUid               func() (uuid.UUID, error)
+	CurrentTime          func() time.Time
+	Db                   db.Database
+	Checkpoints          db.CheckpointStore
+	Campaigns            db.CampaignStore
+	Schedules            db.ScheduleStore
+	Audit                db.AuditLog
+	Tombstones           db.TombstoneStore
+	Erasures             db.ErasureStore
+	Welcome              db.WelcomeStore
+	Validator            email.AddressValidator
+	Mailer               email.Mailer
+	Suppressor           email.Suppressor
+	Log                  *log.Logger
+}
+
+type auditInfoKey struct{}
+
+type auditInfo struct {
+	source    string
+	requestId string
+}
+
+// WithAuditInfo returns a copy of ctx that causes ProdAgent to record source
+// and requestId in the db.AuditEvent for any operation performed with it.
+func WithAuditInfo(
+	ctx context.Context, source, requestId string,
+) context.Context {
+	return context.WithValue(ctx, auditInfoKey{}, &auditInfo{source, requestId})
+}
+
+// AuditInfo returns the source and request ID added to ctx by WithAuditInfo,
+// or empty strings if there aren't any.
+func AuditInfo(ctx context.Context) (source, requestId string) {
+	if info, ok := ctx.Value(auditInfoKey{}).(*auditInfo); ok {
+		source, requestId = info.source, info.requestId
+	}
+	return
+}
+
+// recordAuditEvent appends a db.AuditEvent for an operation on address that's
+// already succeeded.
+//
+// It only logs an error if recording the event fails, rather than failing an
+// operation that's already taken effect.
+func (a *ProdAgent) recordAuditEvent(
+	ctx context.Context,
+	address string,
+	op db.AuditOperation,
+	reason ops.RemoveReason,
+) {
+	event := &db.AuditEvent{
+		Email:     address,
+		Operation: op,
+		Reason:    reason,
+		Timestamp: a.CurrentTime(),
+	}
+	event.Source, event.RequestId = AuditInfo(ctx)
+
+	if err := a.Audit.PutAuditEvent(ctx, event); err != nil {
+		const errFmt = "ERROR recording %s audit event for %s: %s"
+		a.Log.Printf(errFmt, op, address, err)
+	}
+}
+
+// recordTombstone saves a db.Tombstone for an address that's already left the
+// list.
+//
+// If replace is false, it keeps any existing Tombstone. Like recordAuditEvent,
+// it only logs an error if saving the Tombstone fails.
+func (a *ProdAgent) recordTombstone(
+	ctx context.Context,
+	address string,
+	reason db.TombstoneReason,
+	replace bool,
+) {
+	var err error
+
+	if !replace {
+		_, err = a.Tombstones.GetTombstone(ctx, address)
+		if err == nil {
+			return
+		} else if !errors.Is(err, db.ErrTombstoneNotFound) {
+			a.Log.Printf("ERROR recording tombstone for %s: %s", address, err)
+			return
+		}
+	}
+
+	tombstone := &db.Tombstone{
+		Email: address, Reason: reason, Timestamp: a.CurrentTime(),
+	}
+	if err = a.Tombstones.PutTombstone(ctx, tombstone); err != nil {
+		a.Log.Printf("ERROR recording tombstone for %s: %s", address, err)
+	}
+}
+
+// deleteTombstone deletes the db.Tombstone for an address that's already
+// rejoined the list.
+//
+// Like recordAuditEvent, it only logs an error if the deletion fails.
+func (a *ProdAgent) deleteTombstone(ctx context.Context, address string) {
+	if err := a.Tombstones.DeleteTombstone(ctx, address); err != nil {
+		a.Log.Printf("ERROR deleting tombstone for %s: %s", address, err)
+	}
+}
+
+// checkTombstone returns an error wrapping ErrAddressLeftList if address has a
+// db.Tombstone with one of the specified reasons that's more recent than
+// cooldown.
+//
+// If reasons is empty, a Tombstone with any reason applies.
+func (a *ProdAgent) checkTombstone(
+	ctx context.Context,
+	address string,
+	cooldown time.Duration,
+	reasons ...db.TombstoneReason,
+) (err error) {
+	var ts *db.Tombstone
+
+	if cooldown == 0 {
+		return
+	} else if ts, err = a.Tombstones.GetTombstone(ctx, address); err != nil {
+		if errors.Is(err, db.ErrTombstoneNotFound) {
+			err = nil
+		}
+		return
+	} else if len(reasons) != 0 && !slices.Contains(reasons, ts.Reason) {
+		return
+	} else if a.CurrentTime().Sub(ts.Timestamp) >= cooldown {
+		return
+	}
+
+	const errFmt = "%w: %s: %s at %s"
+	leftAt := ts.Timestamp.Format(time.RFC3339)
+	return fmt.Errorf(errFmt, ErrAddressLeftList, address, ts.Reason, leftAt)
 }
 
 func (a *ProdAgent) Subscribe(
-	ctx context.Context, address string,
+	ctx context.Context,
+	address string,
+	topics []string,
+	signup *db.SignupMetadata,
+	profile *Profile,
 ) (result ops.OperationResult, err error) {
 	var failure *email.ValidationFailure
 	var sub *db.Subscriber
+	var tags []string
 
-	if failure, err = a.Validate(ctx, address); err != nil {
+	if tags, err = db.NormalizeTags(topics); err != nil {
+		return
+	} else if err = profile.validate(); err != nil {
+		return
+	} else if failure, err = a.Validate(ctx, address); err != nil {
 		return
 	} else if failure != nil {
 		a.Log.Printf("validation failed: %s", failure)
 		return
 	} else if sub, err = a.Db.Get(ctx, address); err == nil {
-		switch sub.Status {
-		case db.SubscriberPending:
-			result = ops.VerifyLinkSent
-		default:
-			result = ops.AlreadySubscribed
+		if sub.Status == db.SubscriberPending {
+			return a.resendVerificationEmail(ctx, sub, tags)
 		}
+		result = ops.AlreadySubscribed
 		return
 	} else if !errors.Is(err, db.ErrSubscriberNotFound) {
 		return
 	}
 
-	sub = &db.Subscriber{Email: address, Status: db.SubscriberPending}
+	policy := a.TombstonePolicy.Subscribe
+	if err = a.checkTombstone(ctx, address, policy); err != nil {
+		if errors.Is(err, ErrAddressLeftList) {
+			a.Log.Printf("not subscribing: %s", err)
+			result, err = ops.VerifyLinkSent, nil
+		}
+		return
+	}
+
+	sub = &db.Subscriber{
+		Email:           address,
+		Status:          db.SubscriberPending,
+		Tags:            tags,
+		Signup:          signup,
+		VerifySentCount: 1,
+		VerifySentAt:    a.CurrentTime(),
+	}
+	profile.apply(sub)
+
 	if err = a.putSubscriber(ctx, sub); err != nil {
 		return
 	}
+	a.recordAuditEvent(ctx, address, db.AuditSubscribe, ops.RemoveReasonNil)
+	return a.sendVerificationEmail(ctx, sub)
+}
+
+func (a *ProdAgent) resendVerificationEmail(
+	ctx context.Context, sub *db.Subscriber, tags []string,
+) (result ops.OperationResult, err error) {
+	now := a.CurrentTime()
+	// Pending records from before VerifySentCount existed have a count of zero,
+	// though they've received one verification email already.
+	numSent := max(sub.VerifySentCount, 1)
+	nextSend := sub.VerifySentAt.Add(a.VerifyResendCooldown)
+	const logFmt = "not resending verification email to %s: %s"
+
+	// Return VerifyLinkSent either way, so the response doesn't reveal whether
+	// an email was sent.
+	if numSent >= a.MaxVerifyEmails {
+		reason := fmt.Sprintf("already sent %d", numSent)
+		a.Log.Printf(logFmt, sub.Email, reason)
+		return ops.VerifyLinkSent, a.putNewTags(ctx, sub, tags)
+	} else if now.Before(nextSend) {
+		reason := "cooldown until " + nextSend.Format(time.RFC3339)
+		a.Log.Printf(logFmt, sub.Email, reason)
+		return ops.VerifyLinkSent, a.putNewTags(ctx, sub, tags)
+	}
+
+	// Record the attempt before sending, so that it counts against
+	// MaxVerifyEmails even if the send fails. Keep the same Uid, so the link
+	// from any earlier email still works, but extend the time to live.
+	updated := *sub
+	updated.VerifySentCount = numSent + 1
+	updated.VerifySentAt = now
+	updated.Timestamp = now.Add(timeToLiveDuration)
+	updated.Tags = mergeTags(sub.Tags, tags)
+
+	// Recording the attempt fails if a concurrent request recorded one first,
+	// in which case that request sends the email instead.
+	err = a.Db.RecordVerifySent(ctx, &updated, sub.VerifySentCount)
+	if errors.Is(err, db.ErrVerifySentChanged) {
+		a.Log.Printf(logFmt, sub.Email, err)
+		return ops.VerifyLinkSent, nil
+	} else if err != nil {
+		return
+	}
+	return a.sendVerificationEmail(ctx, &updated)
+}
+
+// putNewTags adds tags to a pending subscriber who isn't receiving another
+// verification email, so the tags will take effect upon verification.
+func (a *ProdAgent) putNewTags(
+	ctx context.Context, sub *db.Subscriber, tags []string,
+) error {
+	merged := mergeTags(sub.Tags, tags)
 
+	if slices.Equal(merged, sub.Tags) {
+		return nil
+	}
+	sub.Tags = merged
+	return a.Db.Put(ctx, sub)
+}
+
+// mergeTags returns the sorted union of two sets of tags.
+func mergeTags(tags, newTags []string) []string {
+	merged := slices.Concat(tags, newTags)
+	slices.Sort(merged)
+	return slices.Compact(merged)
+}
+
+func (a *ProdAgent) sendVerificationEmail(
+	ctx context.Context, sub *db.Subscriber,
+) (result ops.OperationResult, err error) {
 	msg := a.makeVerificationEmail(sub)
 	var msgId string
 
-	if msgId, err = a.Mailer.Send(ctx, address, msg); err == nil {
-		a.Log.Printf("sent verification email to %s with ID %s", address, msgId)
+	if msgId, err = a.Mailer.Send(ctx, sub.Email, msg); err == nil {
+		const logFmt = "sent verification email to %s with ID %s"
+		a.Log.Printf(logFmt, sub.Email, msgId)
 		result = ops.VerifyLinkSent
 	}
 	return
@@ -123,6 +666,18 @@ func (a *ProdAgent) Subscribe(
 // so DynamoDB's Time To Live feature can eventually remove them.
 const timeToLiveDuration = time.Hour * 24
 
+// StatusTime returns the time at which sub's status last changed.
+//
+// For a verified Subscriber, that's its Timestamp. For a pending Subscriber,
+// it's when the most recent verification email went out, a day before the
+// Timestamp at which the Subscriber expires.
+func StatusTime(sub *db.Subscriber) time.Time {
+	if sub.Status == db.SubscriberPending {
+		return sub.Timestamp.Add(-timeToLiveDuration)
+	}
+	return sub.Timestamp
+}
+
 func (a *ProdAgent) putSubscriber(
 	ctx context.Context, sub *db.Subscriber,
 ) (err error) {
@@ -175,8 +730,15 @@ func verifyHtmlBody(siteTitle, verifyLink string) string {
 }
 
 func (a *ProdAgent) makeVerificationEmail(sub *db.Subscriber) []byte {
-	verifyLink := ops.VerifyUrl(a.ApiBaseUrl, sub.Email, sub.Uid)
-	recipient := &email.Recipient{Email: sub.Email, Uid: sub.Uid}
+	verifyLink := ops.VerifyUrl(
+		a.ApiBaseUrl,
+		a.List,
+		sub.Email,
+		sub.Uid,
+		a.VerifyTokenKeys,
+		sub.Timestamp,
+	)
+	recipient := &email.Recipient{Email: sub.Email, Uid: sub.Uid, List: a.List}
 	mt := email.NewMessageTemplate(&email.Message{
 		From:     a.SenderAddress,
 		Subject:  verifySubjectPrefix + a.EmailSiteTitle,
@@ -203,13 +765,61 @@ func (a *ProdAgent) Verify(
 
 	sub.Status = db.SubscriberVerified
 	sub.Timestamp = a.CurrentTime()
+	sub.VerifySentCount = 0
+	sub.VerifySentAt = time.Time{}
 
 	if err = a.Db.Put(ctx, sub); err == nil {
 		result = ops.Subscribed
+		a.recordAuditEvent(ctx, address, db.AuditVerify, ops.RemoveReasonNil)
+		a.deleteTombstone(ctx, address)
+		a.sendWelcomeMessage(ctx, sub)
+	}
+	return
+}
+
+func (a *ProdAgent) sendWelcomeMessage(
+	ctx context.Context, sub *db.Subscriber,
+) {
+	msg, err := a.GetWelcomeMessage(ctx)
+
+	if err != nil {
+		a.Log.Printf("ERROR getting welcome message: %s", err)
+		return
+	} else if msg == nil {
+		return
+	}
+
+	mt := email.NewMessageTemplate(msg)
+
+	if err := a.sendOneEmail(ctx, msg.Subject, mt, sub); err != nil {
+		const errFmt = "ERROR sending welcome message to %s: %s"
+		a.Log.Printf(errFmt, sub.Email, err)
+	}
+}
+
+func (a *ProdAgent) GetWelcomeMessage(
+	ctx context.Context,
+) (msg *email.Message, err error) {
+	msg, err = a.Welcome.GetWelcomeMessage(ctx)
+	if errors.Is(err, db.ErrWelcomeMessageNotFound) {
+		err = nil
 	}
 	return
 }
 
+func (a *ProdAgent) SetWelcomeMessage(
+	ctx context.Context, msg *email.Message,
+) (err error) {
+	if msg == nil {
+		return a.Welcome.DeleteWelcomeMessage(ctx)
+	} else if err = msg.Validate(
+		email.CheckDomain(a.EmailDomainName),
+	); err != nil {
+		return
+	}
+	return a.Welcome.PutWelcomeMessage(ctx, msg)
+}
+
 func (a *ProdAgent) Unsubscribe(
 	ctx context.Context, address string, uid uuid.UUID,
 ) (result ops.OperationResult, err error) {
@@ -221,6 +831,37 @@ func (a *ProdAgent) Unsubscribe(
 		result = ops.NotSubscribed
 	} else if err = a.Db.Delete(ctx, address); err == nil {
 		result = ops.Unsubscribed
+		a.recordAuditEvent(
+			ctx, address, db.AuditUnsubscribe, ops.RemoveReasonNil,
+		)
+		a.recordTombstone(ctx, address, db.TombstoneUnsubscribe, true)
+	}
+	return
+}
+
+// UpdateTopics returns ops.NotSubscribed for a pending subscriber, since the
+// topics link only appears in messages sent to verified subscribers.
+func (a *ProdAgent) UpdateTopics(
+	ctx context.Context, address string, uid uuid.UUID, topics []string,
+) (result ops.OperationResult, err error) {
+	var sub *db.Subscriber
+	var tags []string
+
+	if tags, err = db.NormalizeTags(topics); err != nil {
+		return
+	} else if sub, err = a.getSubscriber(ctx, address, uid); err != nil {
+		return
+	} else if sub == nil || sub.Status != db.SubscriberVerified {
+		result = ops.NotSubscribed
+		return
+	} else if slices.Equal(sub.Tags, tags) {
+		result = ops.TopicsUpdated
+		return
+	}
+
+	sub.Tags = tags
+	if err = a.Db.Put(ctx, sub); err == nil {
+		result = ops.TopicsUpdated
 	}
 	return
 }
@@ -234,23 +875,39 @@ func (a *ProdAgent) getSubscriber(
 		err = nil
 	} else if err != nil {
 		return
-	} else if sub.Uid != uid {
+	} else if !sub.MatchesUid(uid, a.CurrentTime()) {
 		sub = nil
 	}
 	return
 }
 
+// Validate checks address using a.Validator, after rejecting any address
+// containing "#".
+//
+// "#" separates the parts of db.DynamoDb primary keys, so an address like
+// "list#news#bob@example.com" would collide with another list's subscriber.
+// Such addresses are rare enough that rejecting them for every database is
+// simpler than escaping them.
 func (a *ProdAgent) Validate(
 	ctx context.Context, address string,
 ) (failure *email.ValidationFailure, err error) {
+	if strings.Contains(address, "#") {
+		reason := `contains "#"`
+		return &email.ValidationFailure{Address: address, Reason: reason}, nil
+	}
 	return a.Validator.ValidateAddress(ctx, address)
 }
 
-func (a *ProdAgent) Import(ctx context.Context, address string) (err error) {
+func (a *ProdAgent) Import(
+	ctx context.Context, address string, profile *Profile,
+) (err error) {
 	var failure *email.ValidationFailure
 	var sub *db.Subscriber
+	policy := a.TombstonePolicy.Import
 
-	if failure, err = a.Validate(ctx, address); err != nil {
+	if err = profile.validate(); err != nil {
+		return
+	} else if failure, err = a.Validate(ctx, address); err != nil {
 		return
 	} else if failure != nil {
 		return errors.New(failure.Reason)
@@ -260,9 +917,18 @@ func (a *ProdAgent) Import(ctx context.Context, address string) (err error) {
 		}
 	} else if !errors.Is(err, db.ErrSubscriberNotFound) {
 		return
+	} else if err = a.checkTombstone(ctx, address, policy); err != nil {
+		return
+	} else if err = a.checkErasure(ctx, address); err != nil {
+		return
 	}
 	sub = &db.Subscriber{Email: address, Status: db.SubscriberVerified}
-	err = a.putSubscriber(ctx, sub)
+	profile.apply(sub)
+
+	if err = a.putSubscriber(ctx, sub); err == nil {
+		a.recordAuditEvent(ctx, address, db.AuditImport, ops.RemoveReasonNil)
+		a.deleteTombstone(ctx, address)
+	}
 	return
 }
 
@@ -270,6 +936,8 @@ func (a *ProdAgent) Remove(
 	ctx context.Context, address string, reason ops.RemoveReason,
 ) (err error) {
 	if err = a.Db.Delete(ctx, address); err == nil {
+		a.recordAuditEvent(ctx, address, db.AuditRemove, reason)
+		a.recordTombstone(ctx, address, db.TombstoneReason(reason), false)
 		err = a.Suppressor.Suppress(ctx, address, reason)
 	}
 	return
@@ -279,45 +947,566 @@ func (a *ProdAgent) Restore(ctx context.Context, address string) (err error) {
 	// Since the SnsHandler is calling this to restore a previous subscriber,
 	// presume they're already verified.
 	sub := &db.Subscriber{Email: address, Status: db.SubscriberVerified}
-	if err = a.putSubscriber(ctx, sub); err == nil {
+	policy := a.TombstonePolicy.Restore
+	unsubscribed := db.TombstoneUnsubscribe
+
+	if err = a.checkTombstone(ctx, address, policy, unsubscribed); err != nil {
+		return
+	} else if err = a.checkErasure(ctx, address); err != nil {
+		return
+	} else if err = a.putSubscriber(ctx, sub); err == nil {
+		a.recordAuditEvent(ctx, address, db.AuditRestore, ops.RemoveReasonNil)
+		a.deleteTombstone(ctx, address)
 		err = a.Suppressor.Unsuppress(ctx, address)
 	}
 	return
 }
+
 func (a *ProdAgent) Send(
-	ctx context.Context, msg *email.Message, addrs []string,
-) (numSent int, err error) {
+	ctx context.Context,
+	msg *email.Message,
+	addrs []string,
+	idempotencyKey string,
+	tagFilter string,
+) (numSent, numSkipped int, err error) {
+	var filter *db.TagFilter
+
 	if err = msg.Validate(email.CheckDomain(a.EmailDomainName)); err != nil {
 		return
+	} else if filter, err = db.ParseTagFilter(tagFilter); err != nil {
+		return
 	}
 	mt := email.NewMessageTemplate(msg)
 
-	if len(addrs) == 0 {
-		return a.sendToEntireList(ctx, msg.Subject, mt)
+	if len(addrs) != 0 {
+		if filter != nil {
+			err = errors.New("can't apply a tag filter to specific recipients")
+			return
+		}
+		return a.sendToSpecificRecipients(
+			ctx, msg.Subject, mt, idempotencyKey, addrs,
+		)
+	} else if idempotencyKey == "" {
+		idempotencyKey = msg.Hash()
+	}
+
+	campaign, cp, err := a.startCampaign(ctx, idempotencyKey, msg, filter)
+	if err == nil {
+		numSent, numSkipped, err = a.sendToEntireList(ctx, mt, campaign, cp)
 	}
-	return a.sendToSpecificRecipients(ctx, msg.Subject, mt, addrs)
+	return
 }
 
-func (a *ProdAgent) sendToEntireList(
-	ctx context.Context, subject string, mt *email.MessageTemplate,
+// startCampaign returns a new db.Campaign and db.SendCheckpoint for the
+// campaign ID, or the existing ones if the ID's already been used.
+//
+// In the latter case, the filter must match the campaign's original filter,
+// since the campaign's receipts only cover the subscribers it selected. Sending
+// the message to a different set of subscribers requires a new idempotency key.
+// If the campaign is incomplete, sending resumes from its checkpoint.
+// Otherwise, the checkpoint is reset to start again from the first subscriber,
+// and sendToEntireList will skip every subscriber who's already received the
+// message.
+func (a *ProdAgent) startCampaign(
+	ctx context.Context,
+	campaignId string,
+	msg *email.Message,
+	filter *db.TagFilter,
+) (campaign *db.Campaign, cp *db.SendCheckpoint, err error) {
+	const errFmt = "couldn't start campaign %s: %w"
+	cs := a.Campaigns
+	hash := msg.Hash()
+	tagFilter := filter.String()
+	cp, err = a.Checkpoints.GetCheckpoint(ctx, campaignId)
+
+	if errors.Is(err, db.ErrCheckpointNotFound) {
+		err = nil
+		campaign = &db.Campaign{
+			Id:          campaignId,
+			Subject:     msg.Subject,
+			MessageHash: hash,
+			TagFilter:   tagFilter,
+			StartTime:   a.CurrentTime(),
+		}
+		cp = &db.SendCheckpoint{
+			CampaignId: campaignId, MessageHash: hash, TagFilter: tagFilter,
+		}
+	} else if err != nil {
+		err = fmt.Errorf(errFmt, campaignId, err)
+	} else if cp.MessageHash != hash {
+		err = fmt.Errorf("message doesn't match campaign %s", campaignId)
+	} else if cp.TagFilter != tagFilter {
+		const filterFmt = "tag filter %q doesn't match campaign %s filter " +
+			"%q; use a new idempotency key to send to different subscribers"
+		err = fmt.Errorf(filterFmt, tagFilter, campaignId, cp.TagFilter)
+	} else if campaign, err = cs.GetCampaign(ctx, campaignId); err != nil {
+		err = fmt.Errorf(errFmt, campaignId, err)
+	} else if cp.Complete {
+		cp.LastKey = nil
+		cp.Complete = false
+	}
+	return
+}
+
+func (a *ProdAgent) ResumeSend(
+	ctx context.Context, campaignId string, msg *email.Message,
+) (numSent, numSkipped int, err error) {
+	cps := a.Checkpoints
+	cs := a.Campaigns
+	var cp *db.SendCheckpoint
+	var campaign *db.Campaign
+	const cantResumeFmt = "can't resume campaign %s: %w"
+
+	if err = msg.Validate(email.CheckDomain(a.EmailDomainName)); err != nil {
+		return
+	} else if cp, err = cps.GetCheckpoint(ctx, campaignId); err != nil {
+		err = fmt.Errorf(cantResumeFmt, campaignId, err)
+	} else if cp.Complete {
+		err = fmt.Errorf("campaign %s is already complete", campaignId)
+	} else if cp.MessageHash != msg.Hash() {
+		err = fmt.Errorf("message doesn't match campaign %s", campaignId)
+	} else if campaign, err = cs.GetCampaign(ctx, campaignId); err != nil {
+		err = fmt.Errorf(cantResumeFmt, campaignId, err)
+	} else {
+		mt := email.NewMessageTemplate(msg)
+		numSent, numSkipped, err = a.sendToEntireList(ctx, mt, campaign, cp)
+	}
+	return
+}
+
+func (a *ProdAgent) GetCampaign(
+	ctx context.Context, id string,
+) (*db.Campaign, error) {
+	return a.Campaigns.GetCampaign(ctx, id)
+}
+
+func (a *ProdAgent) ListCampaigns(
+	ctx context.Context,
+) (campaigns []*db.Campaign, err error) {
+	if campaigns, err = a.Campaigns.ListCampaigns(ctx); err == nil {
+		slices.SortFunc(campaigns, func(lhs, rhs *db.Campaign) int {
+			return rhs.StartTime.Compare(lhs.StartTime)
+		})
+	}
+	return
+}
+
+func (a *ProdAgent) History(
+	ctx context.Context, address string,
+) (events []*db.AuditEvent, err error) {
+	if events, err = a.Audit.GetAuditEvents(ctx, address); err == nil {
+		slices.SortStableFunc(events, func(lhs, rhs *db.AuditEvent) int {
+			return lhs.Timestamp.Compare(rhs.Timestamp)
+		})
+	}
+	return
+}
+
+func (a *ProdAgent) ExportSubscriber(
+	ctx context.Context, address string,
+) (data *SubscriberData, err error) {
+	d := &SubscriberData{List: a.List, Email: address}
+
+	if d.Subscriber, err = a.Db.Get(ctx, address); err != nil {
+		if !errors.Is(err, db.ErrSubscriberNotFound) {
+			return
+		}
+	}
+	if d.Tombstone, err = a.Tombstones.GetTombstone(ctx, address); err != nil {
+		if !errors.Is(err, db.ErrTombstoneNotFound) {
+			return
+		}
+	}
+	if d.Suppressed, err = a.Suppressor.IsSuppressed(ctx, address); err != nil {
+		return
+	} else if d.Erased, err = a.isErased(ctx, address); err != nil {
+		return
+	} else if d.History, err = a.History(ctx, address); err == nil {
+		data = d
+	}
+	return
+}
+
+// Erase saves the hash of the address before deleting anything else, so that
+// Import and Restore can't add the address back even if the rest of the erasure
+// fails. It stops at the first error, and it's safe to call again to finish the
+// job.
+//
+// Erase leaves the address on the SES account suppression list, if it's there.
+// Removing it could let messages reach an address that bounced or complained
+// before.
+func (a *ProdAgent) Erase(ctx context.Context, address string) (err error) {
+	if a.ErasureSalt == "" {
+		return errors.New("can't erase " + address + ": no erasure salt set")
+	}
+	hash := a.erasureHash(address)
+
+	if err = a.Erasures.PutErasure(ctx, hash); err != nil {
+		return
+	} else if err = a.Db.Delete(ctx, address); err != nil {
+		return
+	} else if err = a.Db.DeleteReceipts(ctx, address); err != nil {
+		return
+	} else if err = a.Tombstones.DeleteTombstone(ctx, address); err != nil {
+		return
+	} else {
+		err = a.Audit.DeleteAuditEvents(ctx, address)
+	}
+	return
+}
+
+func (a *ProdAgent) erasureHash(address string) string {
+	return db.ErasureHash(a.ErasureSalt, address)
+}
+
+// isErased returns false without checking Erasures if ErasureSalt is empty,
+// since Erase can't save any hashes without it.
+func (a *ProdAgent) isErased(
+	ctx context.Context, address string,
+) (erased bool, err error) {
+	if a.ErasureSalt != "" {
+		erased, err = a.Erasures.IsErased(ctx, a.erasureHash(address))
+	}
+	return
+}
+
+// checkErasure returns an error wrapping ErrAddressErased if Erase erased the
+// address before.
+func (a *ProdAgent) checkErasure(
+	ctx context.Context, address string,
+) (err error) {
+	var erased bool
+
+	if erased, err = a.isErased(ctx, address); err == nil && erased {
+		err = fmt.Errorf("%w: %s", ErrAddressErased, address)
+	}
+	return
+}
+
+func (a *ProdAgent) RotateUids(
+	ctx context.Context,
+	gracePeriod time.Duration,
+	startKey *db.ScanKey,
+	batchSize int,
+) (numRotated int, nextKey *db.ScanKey, err error) {
+	if batchSize <= 0 {
+		err = fmt.Errorf("batch size must be positive, got %d", batchSize)
+		return
+	} else if gracePeriod < 0 {
+		err = fmt.Errorf("grace period is negative: %s", gracePeriod)
+		return
+	}
+
+	expires := a.CurrentTime().Add(gracePeriod)
+	lastKey := startKey
+	stopped := false
+	var rotateErr error
+	rotator := db.SubscriberFunc(func(sub *db.Subscriber) bool {
+		if numRotated == batchSize {
+			stopped = true
+			return false
+		} else if deadlineErr := a.checkSendDeadline(ctx); deadlineErr != nil {
+			// A nil lastKey would mean there's nothing left to rotate.
+			if stopped = lastKey != nil; !stopped {
+				rotateErr = deadlineErr
+			}
+			return false
+		}
+
+		var uid uuid.UUID
+		if uid, rotateErr = a.NewUid(); rotateErr != nil {
+			return false
+		}
+		rotateErr = a.Db.RotateUid(ctx, sub.Email, uid, expires)
+
+		// The subscriber may have unsubscribed since the scan began.
+		if errors.Is(rotateErr, db.ErrSubscriberNotFound) {
+			rotateErr = nil
+		} else if rotateErr != nil {
+			return false
+		} else {
+			numRotated++
+		}
+		lastKey = sub.ScanKey()
+		return true
+	})
+
+	err = a.Db.ProcessSubscribersFrom(
+		ctx, db.SubscriberVerified, startKey, rotator,
+	)
+	if err = errors.Join(err, rotateErr); err != nil {
+		err = fmt.Errorf("failed to rotate UIDs: %w", err)
+	}
+	if err != nil || stopped {
+		nextKey = lastKey
+	}
+	return
+}
+
+func (a *ProdAgent) GetSubscriber(
+	ctx context.Context, address string,
+) (*db.Subscriber, error) {
+	return a.Db.Get(ctx, address)
+}
+
+func (a *ProdAgent) ListSubscribers(
+	ctx context.Context,
+	status db.SubscriberStatus,
+	since time.Time,
+	domain string,
+	startKey *db.ScanKey,
+	limit int,
+) (subs []*db.Subscriber, nextKey *db.ScanKey, err error) {
+	if limit <= 0 {
+		err = fmt.Errorf("limit must be positive, got %d", limit)
+		return
+	} else if status != db.SubscriberPending &&
+		status != db.SubscriberVerified {
+		err = fmt.Errorf("invalid subscriber status: %q", status)
+		return
+	}
+
+	subs = make([]*db.Subscriber, 0, min(limit, 100))
+	lastKey := startKey
+	stopped := false
+	var deadlineErr error
+	lister := db.SubscriberFunc(func(sub *db.Subscriber) bool {
+		if len(subs) == limit {
+			stopped = true
+			return false
+		} else if deadlineErr = a.checkSendDeadline(ctx); deadlineErr != nil {
+			// A nil lastKey would mean there's nothing left to list.
+			if stopped = lastKey != nil; stopped {
+				deadlineErr = nil
+			}
+			return false
+		}
+
+		if !since.After(StatusTime(sub)) && inDomain(sub.Email, domain) {
+			subs = append(subs, sub)
+		}
+		lastKey = sub.ScanKey()
+		return true
+	})
+
+	err = a.Db.ProcessSubscribersFrom(ctx, status, startKey, lister)
+	if err = errors.Join(err, deadlineErr); err != nil {
+		err = fmt.Errorf("failed to list %s subscribers: %w", status, err)
+	}
+	if err != nil || stopped {
+		nextKey = lastKey
+	}
+	return
+}
+
+// inDomain returns true if domain is empty or address belongs to domain.
+func inDomain(address, domain string) bool {
+	addrDomain := address[strings.LastIndexByte(address, '@')+1:]
+	return domain == "" || strings.EqualFold(addrDomain, domain)
+}
+
+func (a *ProdAgent) Schedule(
+	ctx context.Context, msg *email.Message, sendAt time.Time,
+) (id string, err error) {
+	var uid uuid.UUID
+
+	if err = msg.Validate(email.CheckDomain(a.EmailDomainName)); err != nil {
+		return
+	} else if !sendAt.After(a.CurrentTime()) {
+		const errFmt = "send time %s isn't in the future"
+		err = fmt.Errorf(errFmt, sendAt.Format(time.RFC3339))
+	} else if uid, err = a.NewUid(); err != nil {
+		err = fmt.Errorf("couldn't create scheduled message ID: %w", err)
+	} else {
+		scheduled := &db.ScheduledMessage{
+			Id: uid.String(), SendAt: sendAt, Message: msg,
+		}
+		if err = a.Schedules.PutScheduledMessage(ctx, scheduled); err == nil {
+			id = scheduled.Id
+		}
+	}
+	return
+}
+
+// scheduledSendLease defines how long SendScheduled claims a
+// db.ScheduledMessage before another invocation may claim it.
+//
+// It matches the maximum AWS Lambda timeout, so that the claim can't expire
+// while the invocation that claimed the message is still sending it.
+const scheduledSendLease = 15 * time.Minute
+
+func (a *ProdAgent) SendScheduled(
+	ctx context.Context,
 ) (numSent int, err error) {
-	if err = a.Mailer.BulkCapacityAvailable(ctx); err != nil {
+	var due []*db.ScheduledMessage
+
+	if due, err = a.Schedules.GetDueMessages(ctx, a.CurrentTime()); err != nil {
+		err = fmt.Errorf("couldn't get scheduled messages: %w", err)
+		return
+	}
+	slices.SortFunc(due, func(lhs, rhs *db.ScheduledMessage) int {
+		return lhs.SendAt.Compare(rhs.SendAt)
+	})
+	errs := make([]error, 0, len(due))
+	addError := func(id string, err error) {
+		errs = append(errs, fmt.Errorf("scheduled message %s: %w", id, err))
+	}
+
+	for _, scheduled := range due {
+		// Don't start another message if there's no time left to send it.
+		// Leaving it unclaimed allows the next invocation to send it right
+		// away.
+		id := scheduled.Id
+		if err = a.checkSendDeadline(ctx); err != nil {
+			addError(id, err)
+			break
+		}
+
+		// Claim the scheduled message first, so that no concurrent invocation
+		// sends it at the same time. If another invocation already claimed it,
+		// that invocation is responsible for sending it.
+		now := a.CurrentTime()
+		claimedUntil := now.Add(scheduledSendLease)
+		err = a.Schedules.ClaimScheduledMessage(ctx, id, now, claimedUntil)
+		if errors.Is(err, db.ErrScheduledMessageClaimed) {
+			continue
+		} else if err != nil {
+			addError(id, err)
+			continue
+		}
+
+		// Using the ID as the idempotency key ensures that sending the message
+		// again later resumes the same campaign instead of sending duplicate
+		// messages.
+		n, _, sendErr := a.Send(ctx, scheduled.Message, nil, id, "")
+		numSent += n
+
+		// Delete the scheduled message only once the campaign is complete.
+		// Otherwise release the claim, so the next invocation resumes the
+		// campaign from its checkpoint instead of waiting for the claim to
+		// expire.
+		if sendErr == nil {
+			err = a.Schedules.DeleteScheduledMessage(ctx, id)
+		} else {
+			err = a.Schedules.ReleaseScheduledMessage(ctx, id, claimedUntil)
+		}
+		if sendErr = errors.Join(sendErr, err); sendErr != nil {
+			addError(id, sendErr)
+		}
+		if errors.Is(sendErr, ErrSendDeadlineApproaching) {
+			break
+		}
+	}
+	err = errors.Join(errs...)
+	return
+}
+
+// sendDeadlineMargin defines how long before the context deadline that
+// sendToEntireList stops sending.
+//
+// This leaves time to save the final db.SendCheckpoint and return a response
+// before the Lambda runtime terminates the function.
+const sendDeadlineMargin = 30 * time.Second
+
+// checkpointInterval defines how many messages sendToEntireList sends between
+// db.SendCheckpoint updates.
+//
+// Database.HasReceived prevents duplicate sends when resuming from a slightly
+// stale checkpoint, so there's no need to update the checkpoint for every
+// message. Doing so would double the number of database writes.
+const checkpointInterval = 100
+
+func (a *ProdAgent) sendToEntireList(
+	ctx context.Context,
+	mt *email.MessageTemplate,
+	campaign *db.Campaign,
+	cp *db.SendCheckpoint,
+) (numSent, numSkipped int, err error) {
+	subject := campaign.Subject
+	campaign.Status = db.CampaignSending
+	var filter *db.TagFilter
+
+	if filter, err = db.ParseTagFilter(cp.TagFilter); err != nil {
+		err = fmt.Errorf("couldn't send to subscribers: %w", err)
+		return
+	} else if err = a.Mailer.BulkCapacityAvailable(ctx); err != nil {
 		err = fmt.Errorf("couldn't send to subscribers: %w", err)
 		return
+	} else if err = a.saveProgress(ctx, campaign, cp); err != nil {
+		err = fmt.Errorf("couldn't start sending to subscribers: %w", err)
+		return
 	}
 
+	startKey := cp.LastKey
 	var sendErr error
-	sender := db.SubscriberFunc(func(sub *db.Subscriber) (ok bool) {
-		sendErr = a.sendOneEmail(ctx, subject, mt, sub)
-		if ok = sendErr == nil; ok {
-			numSent++
+	sender := db.SubscriberFunc(func(sub *db.Subscriber) bool {
+		var received bool
+		received, sendErr = a.Db.HasReceived(ctx, sub.Email, cp.CampaignId)
+
+		if sendErr != nil {
+			return false
+		} else if received {
+			numSkipped++
+			cp.LastKey = sub.ScanKey()
+			return true
+		} else if sendErr = a.checkSendDeadline(ctx); sendErr != nil {
+			return false
 		}
-		return
+
+		if sendErr = a.sendOneEmail(ctx, subject, mt, sub); sendErr != nil {
+			campaign.NumFailed++
+			return false
+		}
+		numSent++
+		cp.NumSent++
+		cp.LastKey = sub.ScanKey()
+
+		sendErr = a.Db.MarkReceived(ctx, sub.Email, cp.CampaignId)
+		if sendErr != nil {
+			return false
+		} else if cp.NumSent%checkpointInterval == 0 {
+			sendErr = a.saveProgress(ctx, campaign, cp)
+		}
+		return sendErr == nil
 	})
 
-	err = a.Db.ProcessSubscribers(ctx, db.SubscriberVerified, sender)
-	if err = errors.Join(err, sendErr); err != nil {
+	err = a.Db.ProcessTaggedSubscribersFrom(
+		ctx, db.SubscriberVerified, filter, startKey, sender,
+	)
+	err = errors.Join(err, sendErr)
+
+	if cp.Complete = err == nil; cp.Complete {
+		campaign.Status = db.CampaignComplete
+		campaign.FinishTime = a.CurrentTime()
+	} else {
+		campaign.Status = db.CampaignIncomplete
+	}
+	err = errors.Join(err, a.saveProgress(ctx, campaign, cp))
+
+	if err != nil {
 		err = fmt.Errorf("error sending \"%s\" to list: %w", subject, err)
+		err = &IncompleteSendError{CampaignId: cp.CampaignId, Err: err}
+	}
+	return
+}
+
+func (a *ProdAgent) checkSendDeadline(ctx context.Context) error {
+	deadline, ok := ctx.Deadline()
+
+	if ok && !a.CurrentTime().Add(sendDeadlineMargin).Before(deadline) {
+		return ErrSendDeadlineApproaching
+	}
+	return nil
+}
+
+func (a *ProdAgent) saveProgress(
+	ctx context.Context, campaign *db.Campaign, cp *db.SendCheckpoint,
+) (err error) {
+	cp.Timestamp = a.CurrentTime()
+	campaign.NumSent = cp.NumSent
+
+	if err = a.Checkpoints.PutCheckpoint(ctx, cp); err == nil {
+		err = a.Campaigns.PutCampaign(ctx, campaign)
 	}
 	return
 }
@@ -326,8 +1515,9 @@ func (a *ProdAgent) sendToSpecificRecipients(
 	ctx context.Context,
 	subject string,
 	mt *email.MessageTemplate,
+	idempotencyKey string,
 	addrs []string,
-) (numSent int, err error) {
+) (numSent, numSkipped int, err error) {
 	errs := make([]error, 0, len(addrs))
 	addError := func(addr string, err error) {
 		errs = append(errs, fmt.Errorf("%s: %w", addr, err))
@@ -341,14 +1531,24 @@ func (a *ProdAgent) sendToSpecificRecipients(
 	// change.
 	for _, addr := range addrs {
 		var sub *db.Subscriber
+		var received bool
 		if sub, err = a.Db.Get(ctx, addr); err != nil {
 			addError(addr, err)
 		} else if sub.Status != db.SubscriberVerified {
 			addError(addr, errors.New("not verified"))
+		} else if received, err = a.hasReceived(
+			ctx, addr, idempotencyKey,
+		); err != nil {
+			addError(addr, err)
+		} else if received {
+			numSkipped++
 		} else if err = a.sendOneEmail(ctx, subject, mt, sub); err != nil {
 			addError(addr, err)
 		} else {
 			numSent++
+			if err = a.markReceived(ctx, addr, idempotencyKey); err != nil {
+				addError(addr, err)
+			}
 		}
 	}
 
@@ -359,13 +1559,44 @@ func (a *ProdAgent) sendToSpecificRecipients(
 	return
 }
 
+// hasReceived and markReceived check and record receipts for messages sent to
+// specific recipients.
+//
+// They only do so under an explicit idempotencyKey. Otherwise a test send to a
+// few addresses would mark them as having received the campaign with the
+// default key, the message hash, and a later send to the entire list would skip
+// them.
+func (a *ProdAgent) hasReceived(
+	ctx context.Context, addr, idempotencyKey string,
+) (bool, error) {
+	if idempotencyKey == "" {
+		return false, nil
+	}
+	return a.Db.HasReceived(ctx, addr, idempotencyKey)
+}
+
+func (a *ProdAgent) markReceived(
+	ctx context.Context, addr, idempotencyKey string,
+) error {
+	if idempotencyKey == "" {
+		return nil
+	}
+	return a.Db.MarkReceived(ctx, addr, idempotencyKey)
+}
+
 func (a *ProdAgent) sendOneEmail(
 	ctx context.Context,
 	subject string,
 	mt *email.MessageTemplate,
 	sub *db.Subscriber,
 ) (err error) {
-	recipient := &email.Recipient{Email: sub.Email, Uid: sub.Uid}
+	recipient := &email.Recipient{
+		Email:      sub.Email,
+		Uid:        sub.Uid,
+		List:       a.List,
+		FirstName:  sub.FirstName,
+		Attributes: sub.Attributes,
+	}
 	recipient.SetUnsubscribeInfo(
 		a.UnsubscribeEmail, a.UnsubscribeUrl, a.ApiBaseUrl,
 	)
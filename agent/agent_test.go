@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -28,6 +29,9 @@ const testDomainName = "foo.com"
 const testUnsubEmail = "unsubscribe@foo.com"
 const testUnsubUrl = "https://foo.com/unsubscribe"
 const testApiBaseUrl = "https://foo.com/email/"
+const testVerifyResendCooldown = 10 * time.Minute
+const testMaxVerifyEmails = 3
+const testErasureSalt = "erasure salt"
 
 func testMessage() (msg *email.Message) {
 	msg = &email.Message{}
@@ -54,12 +58,19 @@ var verifiedSubscriber *db.Subscriber = &db.Subscriber{
 }
 
 type prodAgentTestFixture struct {
-	agent      *ProdAgent
-	db         *testdoubles.Database
-	validator  *testdoubles.AddressValidator
-	mailer     *testdoubles.Mailer
-	suppressor *testdoubles.Suppressor
-	logs       *tu.Logs
+	agent       *ProdAgent
+	db          *testdoubles.Database
+	checkpoints *testdoubles.CheckpointStore
+	campaigns   *testdoubles.CampaignStore
+	schedules   *testdoubles.ScheduleStore
+	audit       *testdoubles.AuditLog
+	tombstones  *testdoubles.TombstoneStore
+	erasures    *testdoubles.ErasureStore
+	welcome     *testdoubles.WelcomeStore
+	validator   *testdoubles.AddressValidator
+	mailer      *testdoubles.Mailer
+	suppressor  *testdoubles.Suppressor
+	logs        *tu.Logs
 }
 
 func newProdAgentTestFixture() *prodAgentTestFixture {
@@ -70,26 +81,48 @@ func newProdAgentTestFixture() *prodAgentTestFixture {
 		return td.TestTimestamp
 	}
 	db := testdoubles.NewDatabase()
+	cps := testdoubles.NewCheckpointStore()
+	cs := testdoubles.NewCampaignStore()
+	ss := testdoubles.NewScheduleStore()
+	al := testdoubles.NewAuditLog()
+	ts := testdoubles.NewTombstoneStore()
+	es := testdoubles.NewErasureStore()
+	ws := testdoubles.NewWelcomeStore()
 	av := testdoubles.NewAddressValidator()
 	m := testdoubles.NewMailer()
 	sup := testdoubles.NewSuppressor()
 	logs, logger := tu.NewLogs()
 	pa := &ProdAgent{
+		"",
 		testSender,
 		testSiteTitle,
 		testDomainName,
 		testUnsubEmail,
 		testUnsubUrl,
 		testApiBaseUrl,
+		testVerifyResendCooldown,
+		testMaxVerifyEmails,
+		TombstonePolicy{},
+		"",
+		nil,
 		newUid,
 		currentTime,
 		db,
+		cps,
+		cs,
+		ss,
+		al,
+		ts,
+		es,
+		ws,
 		av,
 		m,
 		sup,
 		logger,
 	}
-	return &prodAgentTestFixture{pa, db, av, m, sup, logs}
+	return &prodAgentTestFixture{
+		pa, db, cps, cs, ss, al, ts, es, ws, av, m, sup, logs,
+	}
 }
 
 func (f *prodAgentTestFixture) setupTestSubscribers() {
@@ -190,6 +223,20 @@ func TestValidate(t *testing.T) {
 		validator.AssertValidated(t, testEmail)
 	})
 
+	t.Run("FailsIfAddressContainsKeySeparator", func(t *testing.T) {
+		agent, validator := setup()
+		const address = "list#news#" + testEmail
+
+		failure, err := agent.Validate(ctx, address)
+
+		assert.NilError(t, err)
+		expected := &email.ValidationFailure{
+			Address: address, Reason: `contains "#"`,
+		}
+		assert.DeepEqual(t, expected, failure)
+		validator.AssertValidated(t, "")
+	})
+
 	t.Run("PassesThroughError", func(t *testing.T) {
 		agent, validator := setup()
 		validator.Error = makeServerError("test error")
@@ -221,7 +268,9 @@ func TestMakeVerificationEmail(t *testing.T) {
 		th.Assert(t, "To", sub.Email)
 		th.Assert(t, "Subject", verifySubjectPrefix+agent.EmailSiteTitle)
 
-		verifyLink := ops.VerifyUrl(agent.ApiBaseUrl, sub.Email, sub.Uid)
+		verifyLink := ops.VerifyUrl(
+			agent.ApiBaseUrl, "", sub.Email, sub.Uid, nil, time.Time{},
+		)
 		textPart := tu.GetNextPartContent(t, pr, "text/plain")
 		assert.Assert(t, is.Contains(textPart, agent.EmailSiteTitle))
 		assert.Assert(t, is.Contains(textPart, verifyLink))
@@ -231,6 +280,41 @@ func TestMakeVerificationEmail(t *testing.T) {
 		verifyAnchor := "<a href=\"" + verifyLink + "\">" + verifyLink + "</a>"
 		assert.Assert(t, is.Contains(htmlPart, verifyAnchor))
 	})
+
+	t.Run("IncludesList", func(t *testing.T) {
+		agent := setup()
+		agent.List = "updates"
+
+		rawMsg := agent.makeVerificationEmail(sub)
+
+		_, _, pr := tu.ParseMultipartMessageAndBoundary(t, string(rawMsg))
+		verifyLink := ops.VerifyUrl(
+			agent.ApiBaseUrl, "updates", sub.Email, sub.Uid, nil, time.Time{},
+		)
+		textPart := tu.GetNextPartContent(t, pr, "text/plain")
+		assert.Assert(t, is.Contains(textPart, verifyLink))
+	})
+
+	t.Run("SignsVerifyTokenThatExpiresWithSubscriber", func(t *testing.T) {
+		agent := setup()
+		secret := []byte(strings.Repeat("s", 32))
+		agent.VerifyTokenKeys = ops.VerifyTokenKeys{
+			{Id: "test", Secret: secret},
+		}
+
+		rawMsg := agent.makeVerificationEmail(sub)
+
+		_, _, pr := tu.ParseMultipartMessageAndBoundary(t, string(rawMsg))
+		textPart := tu.GetNextPartContent(t, pr, "text/plain")
+		_, link, _ := strings.Cut(textPart, ops.ApiPrefixVerify)
+		_, signed, _ := strings.Cut(strings.TrimSpace(link), "/")
+		token, err := agent.VerifyTokenKeys.Parse(signed)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, &ops.VerifyToken{
+			Email: sub.Email, Uid: sub.Uid, Expires: sub.Timestamp,
+		}, token)
+	})
 }
 
 func TestSubscribe(t *testing.T) {
@@ -238,19 +322,34 @@ func TestSubscribe(t *testing.T) {
 		return newProdAgentTestFixture(), context.Background()
 	}
 
+	// Returns a copy of pendingSubscriber that's received numSent verification
+	// emails, the last at td.TestTimestamp.
+	newPendingSubscriber := func(numSent int) *db.Subscriber {
+		sub := *pendingSubscriber
+		sub.VerifySentCount = numSent
+		if numSent != 0 {
+			sub.VerifySentAt = td.TestTimestamp
+		}
+		return &sub
+	}
+	resendTime := td.TestTimestamp.Add(testVerifyResendCooldown)
+
 	t.Run("CreatesNewSubscriberAndSendsVerificationEmail", func(t *testing.T) {
 		f, ctx := setup()
 		msgId := "deadbeef"
 		f.mailer.MessageIds[testEmail] = msgId
 
-		result, err := f.agent.Subscribe(ctx, testEmail)
+		result, err := f.agent.Subscribe(ctx, testEmail, nil, nil, nil)
 
 		assert.NilError(t, err)
 		assert.Equal(t, ops.VerifyLinkSent, result)
 		f.validator.AssertValidated(t, testEmail)
 
 		sub := f.db.Index[testEmail]
-		assert.DeepEqual(t, pendingSubscriber, sub)
+		expected := *pendingSubscriber
+		expected.VerifySentCount = 1
+		expected.VerifySentAt = td.TestTimestamp
+		assert.DeepEqual(t, &expected, sub)
 
 		sentMsgId, verifyEmail := f.mailer.GetMessageTo(t, testEmail)
 		assert.Equal(t, msgId, sentMsgId)
@@ -261,26 +360,265 @@ func TestSubscribe(t *testing.T) {
 		f.logs.AssertContains(t, expectedLog)
 	})
 
-	t.Run("ReturnsVerifyLinkSentForPendingSubscribers", func(t *testing.T) {
+	t.Run("SavesTopicsAsTags", func(t *testing.T) {
 		f, ctx := setup()
-		assert.NilError(t, f.db.Put(ctx, pendingSubscriber))
+		topics := []string{"releases", "essays", "releases"}
+
+		result, err := f.agent.Subscribe(ctx, testEmail, topics, nil, nil)
+
+		assert.NilError(t, err)
+		assert.Equal(t, ops.VerifyLinkSent, result)
+		expected := []string{"essays", "releases"}
+		assert.DeepEqual(t, expected, f.db.Index[testEmail].Tags)
+	})
+
+	t.Run("SavesSignupMetadata", func(t *testing.T) {
+		f, ctx := setup()
+		signup := &db.SignupMetadata{
+			Source:    "footer",
+			UtmSource: "newsletter",
+			SourceIp:  "192.0.2.1",
+			UserAgent: "Mozilla/5.0",
+		}
+
+		result, err := f.agent.Subscribe(ctx, testEmail, nil, signup, nil)
+
+		assert.NilError(t, err)
+		assert.Equal(t, ops.VerifyLinkSent, result)
+		assert.DeepEqual(t, signup, f.db.Index[testEmail].Signup)
+	})
+
+	t.Run("SavesProfile", func(t *testing.T) {
+		f, ctx := setup()
+		profile := &Profile{
+			FirstName: "Mike", Attributes: map[string]string{"City": "Chicago"},
+		}
+
+		result, err := f.agent.Subscribe(ctx, testEmail, nil, nil, profile)
+
+		assert.NilError(t, err)
+		assert.Equal(t, ops.VerifyLinkSent, result)
+		sub := f.db.Index[testEmail]
+		assert.Equal(t, "Mike", sub.FirstName)
+		assert.DeepEqual(t, profile.Attributes, sub.Attributes)
+	})
+
+	t.Run("FailsIfProfileIsInvalid", func(t *testing.T) {
+		f, ctx := setup()
+		profile := &Profile{FirstName: strings.Repeat("x", 257)}
+
+		result, err := f.agent.Subscribe(ctx, testEmail, nil, nil, profile)
+
+		assert.Equal(t, ops.Invalid, result)
+		const expectedErr = "invalid profile: first name longer than 256 bytes"
+		assert.Error(t, err, expectedErr)
+		assert.Equal(t, 0, len(f.db.Subscribers))
+		f.mailer.AssertNoMessageSent(t, testEmail)
+	})
+
+	t.Run("FailsIfTopicIsInvalid", func(t *testing.T) {
+		f, ctx := setup()
+		topics := []string{"Essays!"}
+
+		result, err := f.agent.Subscribe(ctx, testEmail, topics, nil, nil)
+
+		assert.Equal(t, ops.Invalid, result)
+		assert.Assert(t, tu.ErrorIs(err, db.ErrInvalidTag))
+		assert.Equal(t, 0, len(f.db.Subscribers))
+		f.mailer.AssertNoMessageSent(t, testEmail)
+	})
+
+	t.Run("ResendsVerificationEmailToPendingSubscribers", func(t *testing.T) {
+		f, ctx := setup()
+		f.agent.CurrentTime = func() time.Time { return resendTime }
+		assert.NilError(t, f.db.Put(ctx, newPendingSubscriber(1)))
+
+		result, err := f.agent.Subscribe(ctx, testEmail, nil, nil, nil)
+
+		assert.NilError(t, err)
+		assert.Equal(t, ops.VerifyLinkSent, result)
+		sub := f.db.Index[testEmail]
+		assert.Equal(t, td.TestUid, sub.Uid)
+		assert.Equal(t, 2, sub.VerifySentCount)
+		assert.Equal(t, resendTime, sub.VerifySentAt)
+		assert.Equal(t, resendTime.Add(timeToLiveDuration), sub.Timestamp)
+
+		_, verifyEmail := f.mailer.GetMessageTo(t, testEmail)
+		assert.Assert(t, is.Contains(verifyEmail, verifySubjectPrefix))
+	})
+
+	t.Run("AddsTopicsToPendingSubscribersWhenResending", func(t *testing.T) {
+		f, ctx := setup()
+		f.agent.CurrentTime = func() time.Time { return resendTime }
+		sub := newPendingSubscriber(1)
+		sub.Tags = []string{"releases"}
+		assert.NilError(t, f.db.Put(ctx, sub))
+
+		topics := []string{"essays"}
+
+		_, err := f.agent.Subscribe(ctx, testEmail, topics, nil, nil)
+
+		assert.NilError(t, err)
+		expected := []string{"essays", "releases"}
+		assert.DeepEqual(t, expected, f.db.Index[testEmail].Tags)
+		f.mailer.GetMessageTo(t, testEmail)
+	})
+
+	t.Run("AddsTopicsToPendingSubscribersDuringCooldown", func(t *testing.T) {
+		f, ctx := setup()
+		sub := newPendingSubscriber(1)
+		sub.Tags = []string{"releases"}
+		assert.NilError(t, f.db.Put(ctx, sub))
+		topics := []string{"essays"}
+
+		result, err := f.agent.Subscribe(ctx, testEmail, topics, nil, nil)
+
+		assert.NilError(t, err)
+		assert.Equal(t, ops.VerifyLinkSent, result)
+		updated := f.db.Index[testEmail]
+		assert.DeepEqual(t, []string{"essays", "releases"}, updated.Tags)
+		assert.Equal(t, 1, updated.VerifySentCount)
+		f.mailer.AssertNoMessageSent(t, testEmail)
+	})
+
+	t.Run("KeepsSignupMetadataOfPendingSubscribers", func(t *testing.T) {
+		f, ctx := setup()
+		f.agent.CurrentTime = func() time.Time { return resendTime }
+		sub := newPendingSubscriber(1)
+		original := &db.SignupMetadata{Source: "footer"}
+		sub.Signup = original
+		assert.NilError(t, f.db.Put(ctx, sub))
+		signup := &db.SignupMetadata{Source: "sidebar"}
+
+		_, err := f.agent.Subscribe(ctx, testEmail, nil, signup, nil)
+
+		assert.NilError(t, err)
+		assert.Equal(t, original, f.db.Index[testEmail].Signup)
+		f.mailer.GetMessageTo(t, testEmail)
+	})
+
+	t.Run("DoesNotPutDuringCooldownIfNoNewTopics", func(t *testing.T) {
+		f, ctx := setup()
+		sub := newPendingSubscriber(1)
+		sub.Tags = []string{"releases"}
+		assert.NilError(t, f.db.Put(ctx, sub))
+		f.db.SimulatePutErr = func(email string) error {
+			return makeServerError("error putting " + email)
+		}
+		topics := []string{"releases"}
+
+		result, err := f.agent.Subscribe(ctx, testEmail, topics, nil, nil)
+
+		assert.NilError(t, err)
+		assert.Equal(t, ops.VerifyLinkSent, result)
+	})
+
+	t.Run("ResendsToPendingSubscribersWithoutSentCount", func(t *testing.T) {
+		f, ctx := setup()
+		f.agent.CurrentTime = func() time.Time { return resendTime }
+		assert.NilError(t, f.db.Put(ctx, newPendingSubscriber(0)))
+
+		result, err := f.agent.Subscribe(ctx, testEmail, nil, nil, nil)
+
+		assert.NilError(t, err)
+		assert.Equal(t, ops.VerifyLinkSent, result)
+		assert.Equal(t, 2, f.db.Index[testEmail].VerifySentCount)
+		f.mailer.GetMessageTo(t, testEmail)
+	})
+
+	t.Run("DoesNotResendDuringCooldown", func(t *testing.T) {
+		f, ctx := setup()
+		cooldownEnd := td.TestTimestamp.Add(testVerifyResendCooldown)
+		f.agent.CurrentTime = func() time.Time {
+			return cooldownEnd.Add(-time.Second)
+		}
+		assert.NilError(t, f.db.Put(ctx, newPendingSubscriber(1)))
+
+		result, err := f.agent.Subscribe(ctx, testEmail, nil, nil, nil)
+
+		assert.NilError(t, err)
+		assert.Equal(t, ops.VerifyLinkSent, result)
+		assert.Equal(t, 1, f.db.Index[testEmail].VerifySentCount)
+		f.mailer.AssertNoMessageSent(t, testEmail)
+		expectedLog := "not resending verification email to " + testEmail +
+			": cooldown until " + cooldownEnd.Format(time.RFC3339)
+		f.logs.AssertContains(t, expectedLog)
+	})
+
+	t.Run("DoesNotResendAfterMaxVerifyEmails", func(t *testing.T) {
+		f, ctx := setup()
+		f.agent.CurrentTime = func() time.Time { return resendTime }
+		sub := newPendingSubscriber(testMaxVerifyEmails)
+		assert.NilError(t, f.db.Put(ctx, sub))
+
+		result, err := f.agent.Subscribe(ctx, testEmail, nil, nil, nil)
+
+		assert.NilError(t, err)
+		assert.Equal(t, ops.VerifyLinkSent, result)
+		f.mailer.AssertNoMessageSent(t, testEmail)
+		expectedLog := "not resending verification email to " + testEmail +
+			": already sent 3"
+		f.logs.AssertContains(t, expectedLog)
+	})
+
+	t.Run("CountsResendEvenIfSendFails", func(t *testing.T) {
+		f, ctx := setup()
+		f.agent.CurrentTime = func() time.Time { return resendTime }
+		f.mailer.RecipientErrors[testEmail] = makeServerError("send failed")
+		assert.NilError(t, f.db.Put(ctx, newPendingSubscriber(1)))
+
+		result, err := f.agent.Subscribe(ctx, testEmail, nil, nil, nil)
+
+		assert.Equal(t, ops.Invalid, result)
+		assertServerErrorContains(t, err, "send failed")
+		assert.Equal(t, 2, f.db.Index[testEmail].VerifySentCount)
+	})
 
-		result, err := f.agent.Subscribe(ctx, testEmail)
+	t.Run("DoesNotResendIfAnotherRequestResentFirst", func(t *testing.T) {
+		f, ctx := setup()
+		f.agent.CurrentTime = func() time.Time { return resendTime }
+		assert.NilError(t, f.db.Put(ctx, newPendingSubscriber(1)))
+		f.db.SimulateResendErr = func(_ string) error {
+			return db.ErrVerifySentChanged
+		}
+
+		result, err := f.agent.Subscribe(ctx, testEmail, nil, nil, nil)
 
 		assert.NilError(t, err)
 		assert.Equal(t, ops.VerifyLinkSent, result)
 		f.mailer.AssertNoMessageSent(t, testEmail)
+		expectedLog := "not resending verification email to " + testEmail +
+			": " + db.ErrVerifySentChanged.Error()
+		f.logs.AssertContains(t, expectedLog)
+	})
+
+	t.Run("PassesThroughRecordVerifySentError", func(t *testing.T) {
+		f, ctx := setup()
+		f.agent.CurrentTime = func() time.Time { return resendTime }
+		assert.NilError(t, f.db.Put(ctx, newPendingSubscriber(1)))
+		f.db.SimulateResendErr = func(email string) error {
+			return makeServerError("error recording resend to " + email)
+		}
+
+		result, err := f.agent.Subscribe(ctx, testEmail, nil, nil, nil)
+
+		assert.Equal(t, ops.Invalid, result)
+		const expectedErr = "error recording resend to " + testEmail
+		assertServerErrorContains(t, err, expectedErr)
+		f.mailer.AssertNoMessageSent(t, testEmail)
 	})
 
 	t.Run("ReturnsAlreadySubscribedForVerifiedSubscribers", func(t *testing.T) {
 		f, ctx := setup()
 		assert.NilError(t, f.db.Put(ctx, verifiedSubscriber))
+		topics := []string{"essays"}
 
-		result, err := f.agent.Subscribe(ctx, testEmail)
+		result, err := f.agent.Subscribe(ctx, testEmail, topics, nil, nil)
 
 		assert.NilError(t, err)
 		assert.Equal(t, ops.AlreadySubscribed, result)
 		f.mailer.AssertNoMessageSent(t, testEmail)
+		assert.Assert(t, is.Nil(f.db.Index[testEmail].Tags))
 	})
 
 	t.Run("ReturnsInvalidIfAddressFailsValidation", func(t *testing.T) {
@@ -289,7 +627,7 @@ func TestSubscribe(t *testing.T) {
 			Address: testEmail, Reason: "testing",
 		}
 
-		result, err := f.agent.Subscribe(ctx, testEmail)
+		result, err := f.agent.Subscribe(ctx, testEmail, nil, nil, nil)
 
 		assert.NilError(t, err)
 		assert.Equal(t, ops.Invalid, result)
@@ -297,11 +635,25 @@ func TestSubscribe(t *testing.T) {
 		f.logs.AssertContains(t, "validation failed: "+testEmail+": testing")
 	})
 
+	// "list#news#bob@example.com" would otherwise share a DynamoDB key with
+	// bob@example.com on the "news" list.
+	t.Run("ReturnsInvalidIfAddressMatchesOtherListKey", func(t *testing.T) {
+		f, ctx := setup()
+		const address = "list#news#" + testEmail
+
+		result, err := f.agent.Subscribe(ctx, address, nil, nil, nil)
+
+		assert.NilError(t, err)
+		assert.Equal(t, ops.Invalid, result)
+		assert.Equal(t, 0, len(f.db.Index))
+		f.mailer.AssertNoMessageSent(t, address)
+	})
+
 	t.Run("PassesThroughValidateAddressError", func(t *testing.T) {
 		f, ctx := setup()
 		f.validator.Error = makeServerError("SES error")
 
-		result, err := f.agent.Subscribe(ctx, testEmail)
+		result, err := f.agent.Subscribe(ctx, testEmail, nil, nil, nil)
 
 		assert.Equal(t, ops.Invalid, result)
 		assertServerErrorContains(t, err, "SES error")
@@ -314,7 +666,7 @@ func TestSubscribe(t *testing.T) {
 			return makeServerError("error getting " + email)
 		}
 
-		result, err := f.agent.Subscribe(ctx, testEmail)
+		result, err := f.agent.Subscribe(ctx, testEmail, nil, nil, nil)
 
 		assert.Equal(t, ops.Invalid, result)
 		assertServerErrorContains(t, err, "error getting "+testEmail)
@@ -327,7 +679,7 @@ func TestSubscribe(t *testing.T) {
 			return makeServerError("error putting " + email)
 		}
 
-		result, err := f.agent.Subscribe(ctx, testEmail)
+		result, err := f.agent.Subscribe(ctx, testEmail, nil, nil, nil)
 
 		assert.Equal(t, ops.Invalid, result)
 		assertServerErrorContains(t, err, "error putting "+testEmail)
@@ -338,7 +690,7 @@ func TestSubscribe(t *testing.T) {
 		f, ctx := setup()
 		f.mailer.RecipientErrors[testEmail] = makeServerError("send failed")
 
-		result, err := f.agent.Subscribe(ctx, testEmail)
+		result, err := f.agent.Subscribe(ctx, testEmail, nil, nil, nil)
 
 		assert.Equal(t, ops.Invalid, result)
 		assertServerErrorContains(t, err, "send failed")
@@ -392,6 +744,25 @@ func TestGetSubscriber(t *testing.T) {
 		assert.Assert(t, is.Nil(sub))
 		assertServerErrorContains(t, err, "error getting "+testEmail)
 	})
+
+	t.Run("ExportedReturnsSubscriberWithoutCheckingUid", func(t *testing.T) {
+		agent, dbase, ctx := setup()
+		assert.NilError(t, dbase.Put(ctx, pendingSubscriber))
+
+		sub, err := agent.GetSubscriber(ctx, testEmail)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, pendingSubscriber, sub)
+	})
+
+	t.Run("ExportedReturnsErrSubscriberNotFound", func(t *testing.T) {
+		agent, _, ctx := setup()
+
+		sub, err := agent.GetSubscriber(ctx, testEmail)
+
+		assert.Assert(t, is.Nil(sub))
+		assert.Assert(t, tu.ErrorIs(err, db.ErrSubscriberNotFound))
+	})
 }
 
 func TestVerify(t *testing.T) {
@@ -429,6 +800,21 @@ func TestVerify(t *testing.T) {
 		assert.Equal(t, newTimestamp, sub.Timestamp)
 	})
 
+	t.Run("ClearsVerifySentFields", func(t *testing.T) {
+		agent, dbase, pendingSub, ctx := setup()
+		pendingSub.VerifySentCount = 2
+		pendingSub.VerifySentAt = td.TestTimestamp
+		assert.NilError(t, dbase.Put(ctx, pendingSub))
+
+		_, err := agent.Verify(ctx, pendingSub.Email, pendingSub.Uid)
+
+		assert.NilError(t, err)
+		sub, err := dbase.Get(ctx, pendingSub.Email)
+		assert.NilError(t, err)
+		assert.Equal(t, 0, sub.VerifySentCount)
+		assert.Assert(t, sub.VerifySentAt.IsZero())
+	})
+
 	t.Run("ReturnsNotSubscribedIfNotFound", func(t *testing.T) {
 		agent, _, pendingSub, ctx := setup()
 
@@ -473,6 +859,105 @@ func TestVerify(t *testing.T) {
 		assert.Equal(t, ops.Invalid, result)
 		assertServerErrorContains(t, err, "failed to put "+pendingSub.Email)
 	})
+
+	t.Run("WelcomeMessage", func(t *testing.T) {
+		setupWelcome := func() (
+			f *prodAgentTestFixture, sub *db.Subscriber, ctx context.Context,
+		) {
+			f = newProdAgentTestFixture()
+			f.welcome.Message = testMessage()
+			f.welcome.Message.Subject = "Welcome!"
+			sub = &db.Subscriber{
+				Email:     testEmail,
+				Uid:       td.TestUid,
+				Status:    db.SubscriberPending,
+				Timestamp: td.TestTimestamp,
+			}
+			ctx = context.Background()
+			assert.NilError(t, f.db.Put(ctx, sub))
+			return
+		}
+
+		t.Run("SendsAfterVerifying", func(t *testing.T) {
+			f, sub, ctx := setupWelcome()
+
+			result, err := f.agent.Verify(ctx, sub.Email, sub.Uid)
+
+			assert.NilError(t, err)
+			assert.Equal(t, ops.Subscribed, result)
+			assertSentToVerifiedSubscriber(
+				t, "Welcome!", sub, f.mailer, f.logs,
+			)
+		})
+
+		t.Run("DoesNotSendIfNotConfigured", func(t *testing.T) {
+			f, sub, ctx := setupWelcome()
+			f.welcome.Message = nil
+
+			result, err := f.agent.Verify(ctx, sub.Email, sub.Uid)
+
+			assert.NilError(t, err)
+			assert.Equal(t, ops.Subscribed, result)
+			f.mailer.AssertNoMessageSent(t, sub.Email)
+		})
+
+		t.Run("DoesNotSendIfAlreadyVerified", func(t *testing.T) {
+			f, sub, ctx := setupWelcome()
+			_, err := f.agent.Verify(ctx, sub.Email, sub.Uid)
+			assert.NilError(t, err)
+			delete(f.mailer.RecipientMessages, sub.Email)
+
+			result, err := f.agent.Verify(ctx, sub.Email, sub.Uid)
+
+			assert.NilError(t, err)
+			assert.Equal(t, ops.AlreadySubscribed, result)
+			f.mailer.AssertNoMessageSent(t, sub.Email)
+		})
+
+		t.Run("DoesNotSendIfPutFails", func(t *testing.T) {
+			f, sub, ctx := setupWelcome()
+			f.db.SimulatePutErr = func(address string) error {
+				return makeServerError("failed to put " + address)
+			}
+
+			result, err := f.agent.Verify(ctx, sub.Email, sub.Uid)
+
+			assert.Equal(t, ops.Invalid, result)
+			assertServerErrorContains(t, err, "failed to put "+sub.Email)
+			f.mailer.AssertNoMessageSent(t, sub.Email)
+		})
+
+		t.Run("OnlyLogsIfGettingMessageFails", func(t *testing.T) {
+			f, sub, ctx := setupWelcome()
+			f.welcome.GetErr = errors.New("get failed")
+
+			result, err := f.agent.Verify(ctx, sub.Email, sub.Uid)
+
+			assert.NilError(t, err)
+			assert.Equal(t, ops.Subscribed, result)
+			f.mailer.AssertNoMessageSent(t, sub.Email)
+			f.logs.AssertContains(
+				t, "ERROR getting welcome message: get failed",
+			)
+		})
+
+		t.Run("OnlyLogsIfSendFails", func(t *testing.T) {
+			f, sub, ctx := setupWelcome()
+			f.mailer.RecipientErrors[sub.Email] = errors.New("send failed")
+
+			result, err := f.agent.Verify(ctx, sub.Email, sub.Uid)
+
+			assert.NilError(t, err)
+			assert.Equal(t, ops.Subscribed, result)
+			verified, err := f.db.Get(ctx, sub.Email)
+			assert.NilError(t, err)
+			assert.Equal(t, db.SubscriberVerified, verified.Status)
+			f.logs.AssertContains(
+				t,
+				"ERROR sending welcome message to "+sub.Email+": send failed",
+			)
+		})
+	})
 }
 
 func TestUnsubscribe(t *testing.T) {
@@ -502,6 +987,36 @@ func TestUnsubscribe(t *testing.T) {
 		assert.Assert(t, is.Nil(dbase.Index[sub.Email]))
 	})
 
+	t.Run("AcceptsPreviousUidDuringGracePeriod", func(t *testing.T) {
+		agent, dbase, sub, ctx := setup()
+		previousUid := sub.Uid
+		sub.Uid = verifiedSubscriber.Uid
+		sub.PreviousUid = previousUid
+		sub.PreviousUidExpires = td.TestTimestamp.Add(time.Second)
+		assert.NilError(t, dbase.Put(ctx, sub))
+
+		result, err := agent.Unsubscribe(ctx, sub.Email, previousUid)
+
+		assert.NilError(t, err)
+		assert.Equal(t, ops.Unsubscribed, result)
+		assert.Assert(t, is.Nil(dbase.Index[sub.Email]))
+	})
+
+	t.Run("RejectsPreviousUidAfterGracePeriod", func(t *testing.T) {
+		agent, dbase, sub, ctx := setup()
+		previousUid := sub.Uid
+		sub.Uid = verifiedSubscriber.Uid
+		sub.PreviousUid = previousUid
+		sub.PreviousUidExpires = td.TestTimestamp
+		assert.NilError(t, dbase.Put(ctx, sub))
+
+		result, err := agent.Unsubscribe(ctx, sub.Email, previousUid)
+
+		assert.NilError(t, err)
+		assert.Equal(t, ops.NotSubscribed, result)
+		assert.Assert(t, dbase.Index[sub.Email] != nil)
+	})
+
 	t.Run("ReturnsNotSubscribedIfSubscriberNotFound", func(t *testing.T) {
 		agent, _, sub, ctx := setup()
 
@@ -537,18 +1052,139 @@ func TestUnsubscribe(t *testing.T) {
 	})
 }
 
-func TestImport(t *testing.T) {
+func TestUpdateTopics(t *testing.T) {
 	setup := func() (
-		agent *ProdAgent,
-		validator *testdoubles.AddressValidator,
-		dbase *testdoubles.Database,
-		subscriber *db.Subscriber,
-	) {
+		*ProdAgent,
+		*testdoubles.Database,
+		*db.Subscriber,
+		context.Context) {
 		f := newProdAgentTestFixture()
-		agent = f.agent
-		validator = f.validator
-		dbase = f.db
-		uid, _ := agent.NewUid()
+		sub := &db.Subscriber{
+			Email:     testEmail,
+			Uid:       td.TestUid,
+			Status:    db.SubscriberVerified,
+			Timestamp: td.TestTimestamp,
+			Tags:      []string{"essays"},
+		}
+		return f.agent, f.db, sub, context.Background()
+	}
+
+	t.Run("Succeeds", func(t *testing.T) {
+		agent, dbase, sub, ctx := setup()
+		assert.NilError(t, dbase.Put(ctx, sub))
+
+		result, err := agent.UpdateTopics(
+			ctx, sub.Email, sub.Uid, []string{"releases", "news"},
+		)
+
+		assert.NilError(t, err)
+		assert.Equal(t, ops.TopicsUpdated, result)
+		expected := []string{"news", "releases"}
+		assert.DeepEqual(t, expected, dbase.Index[sub.Email].Tags)
+	})
+
+	t.Run("RemovesAllTopics", func(t *testing.T) {
+		agent, dbase, sub, ctx := setup()
+		assert.NilError(t, dbase.Put(ctx, sub))
+
+		result, err := agent.UpdateTopics(ctx, sub.Email, sub.Uid, nil)
+
+		assert.NilError(t, err)
+		assert.Equal(t, ops.TopicsUpdated, result)
+		assert.Assert(t, is.Len(dbase.Index[sub.Email].Tags, 0))
+	})
+
+	t.Run("DoesNotPutUnchangedTopics", func(t *testing.T) {
+		agent, dbase, sub, ctx := setup()
+		assert.NilError(t, dbase.Put(ctx, sub))
+		dbase.SimulatePutErr = func(address string) error {
+			return makeServerError("failed to put " + address)
+		}
+
+		result, err := agent.UpdateTopics(
+			ctx, sub.Email, sub.Uid, []string{"essays"},
+		)
+
+		assert.NilError(t, err)
+		assert.Equal(t, ops.TopicsUpdated, result)
+	})
+
+	t.Run("ReturnsNotSubscribedIfUidDoesNotMatch", func(t *testing.T) {
+		agent, dbase, sub, ctx := setup()
+		assert.NilError(t, dbase.Put(ctx, sub))
+
+		result, err := agent.UpdateTopics(
+			ctx, sub.Email, verifiedSubscriber.Uid, []string{"releases"},
+		)
+
+		assert.NilError(t, err)
+		assert.Equal(t, ops.NotSubscribed, result)
+		assert.DeepEqual(t, []string{"essays"}, dbase.Index[sub.Email].Tags)
+	})
+
+	t.Run("ReturnsNotSubscribedIfSubscriberIsPending", func(t *testing.T) {
+		agent, dbase, sub, ctx := setup()
+		sub.Status = db.SubscriberPending
+		assert.NilError(t, dbase.Put(ctx, sub))
+
+		result, err := agent.UpdateTopics(
+			ctx, sub.Email, sub.Uid, []string{"releases"},
+		)
+
+		assert.NilError(t, err)
+		assert.Equal(t, ops.NotSubscribed, result)
+		assert.DeepEqual(t, []string{"essays"}, dbase.Index[sub.Email].Tags)
+	})
+
+	t.Run("ReturnsNotSubscribedIfSubscriberNotFound", func(t *testing.T) {
+		agent, _, sub, ctx := setup()
+
+		result, err := agent.UpdateTopics(ctx, sub.Email, sub.Uid, nil)
+
+		assert.NilError(t, err)
+		assert.Equal(t, ops.NotSubscribed, result)
+	})
+
+	t.Run("FailsIfTopicIsInvalid", func(t *testing.T) {
+		agent, dbase, sub, ctx := setup()
+		assert.NilError(t, dbase.Put(ctx, sub))
+
+		result, err := agent.UpdateTopics(
+			ctx, sub.Email, sub.Uid, []string{"Not Valid"},
+		)
+
+		assert.Equal(t, ops.Invalid, result)
+		assert.Assert(t, tu.ErrorIs(err, db.ErrInvalidTag))
+	})
+
+	t.Run("PassesThroughPutError", func(t *testing.T) {
+		agent, dbase, sub, ctx := setup()
+		assert.NilError(t, dbase.Put(ctx, sub))
+		dbase.SimulatePutErr = func(address string) error {
+			return makeServerError("failed to put " + address)
+		}
+
+		result, err := agent.UpdateTopics(
+			ctx, sub.Email, sub.Uid, []string{"releases"},
+		)
+
+		assert.Equal(t, ops.Invalid, result)
+		assertServerErrorContains(t, err, "failed to put "+sub.Email)
+	})
+}
+
+func TestImport(t *testing.T) {
+	setup := func() (
+		agent *ProdAgent,
+		validator *testdoubles.AddressValidator,
+		dbase *testdoubles.Database,
+		subscriber *db.Subscriber,
+	) {
+		f := newProdAgentTestFixture()
+		agent = f.agent
+		validator = f.validator
+		dbase = f.db
+		uid, _ := agent.NewUid()
 		subscriber = &db.Subscriber{
 			Email:     testEmail,
 			Uid:       uid,
@@ -563,18 +1199,51 @@ func TestImport(t *testing.T) {
 	t.Run("Succeeds", func(t *testing.T) {
 		agent, validator, dbase, expectedSubscriber := setup()
 
-		err := agent.Import(ctx, testEmail)
+		err := agent.Import(ctx, testEmail, nil)
 
 		assert.NilError(t, err)
 		validator.AssertValidated(t, testEmail)
 		assert.DeepEqual(t, expectedSubscriber, dbase.Index[testEmail])
 	})
 
+	t.Run("SavesProfile", func(t *testing.T) {
+		agent, _, dbase, expectedSubscriber := setup()
+		profile := &Profile{
+			FirstName:  "Mike",
+			Attributes: map[string]string{"City": "Chicago", "plan": "free"},
+		}
+
+		err := agent.Import(ctx, testEmail, profile)
+
+		assert.NilError(t, err)
+		expectedSubscriber.FirstName = "Mike"
+		expectedSubscriber.Attributes = profile.Attributes
+		assert.DeepEqual(t, expectedSubscriber, dbase.Index[testEmail])
+	})
+
+	t.Run("FailsIfProfileIsInvalid", func(t *testing.T) {
+		agent, validator, dbase, _ := setup()
+		profile := &Profile{
+			Attributes: map[string]string{
+				"FirstName": "Mike", "first-name": "Mike", "ok": "ok",
+			},
+		}
+
+		err := agent.Import(ctx, testEmail, profile)
+
+		const expectedErr = "invalid profile: " +
+			"invalid attribute name \"FirstName\"\n" +
+			"invalid attribute name \"first-name\""
+		assert.Error(t, err, expectedErr)
+		assert.Equal(t, "", validator.Email)
+		assert.Assert(t, is.Nil(dbase.Index[testEmail]))
+	})
+
 	t.Run("OverwritesExistingPendingSubscriber", func(t *testing.T) {
 		agent, validator, dbase, expectedSubscriber := setup()
 		dbase.Put(ctx, pendingSubscriber)
 
-		err := agent.Import(ctx, testEmail)
+		err := agent.Import(ctx, testEmail, nil)
 
 		assert.NilError(t, err)
 		validator.AssertValidated(t, testEmail)
@@ -587,7 +1256,7 @@ func TestImport(t *testing.T) {
 			Address: testEmail, Reason: "test failure",
 		}
 
-		err := agent.Import(ctx, testEmail)
+		err := agent.Import(ctx, testEmail, nil)
 
 		validator.AssertValidated(t, testEmail)
 		assert.ErrorContains(t, err, validator.Failure.Reason)
@@ -598,7 +1267,7 @@ func TestImport(t *testing.T) {
 		agent, validator, dbase, _ := setup()
 		validator.Error = makeServerError("test error")
 
-		err := agent.Import(ctx, testEmail)
+		err := agent.Import(ctx, testEmail, nil)
 
 		validator.AssertValidated(t, testEmail)
 		assertServerErrorContains(t, err, "test error")
@@ -610,7 +1279,7 @@ func TestImport(t *testing.T) {
 		// verifiedSubscriber.UUID is different from that of a new subscriber.
 		dbase.Put(ctx, verifiedSubscriber)
 
-		err := agent.Import(ctx, testEmail)
+		err := agent.Import(ctx, testEmail, nil)
 
 		assert.ErrorContains(t, err, "already a verified subscriber")
 		validator.AssertValidated(t, testEmail)
@@ -623,7 +1292,7 @@ func TestImport(t *testing.T) {
 			return makeServerError("test error")
 		}
 
-		err := agent.Import(ctx, testEmail)
+		err := agent.Import(ctx, testEmail, nil)
 
 		validator.AssertValidated(t, testEmail)
 		assertServerErrorContains(t, err, "test error")
@@ -635,7 +1304,7 @@ func TestImport(t *testing.T) {
 			return makeServerError("test error")
 		}
 
-		err := agent.Import(ctx, testEmail)
+		err := agent.Import(ctx, testEmail, nil)
 
 		validator.AssertValidated(t, testEmail)
 		assertServerErrorContains(t, err, "test error")
@@ -756,8 +1425,10 @@ func assertSentToVerifiedSubscriber(
 	t.Helper()
 
 	msgId, m := mailer.GetMessageTo(t, sub.Email)
-	unsubUrl := ops.UnsubscribeUrl(testApiBaseUrl, sub.Email, sub.Uid)
-	unsubMailto := ops.UnsubscribeMailto(testUnsubEmail, sub.Email, sub.Uid)
+	unsubUrl := ops.UnsubscribeUrl(testApiBaseUrl, "", sub.Email, sub.Uid)
+	unsubMailto := ops.UnsubscribeMailto(
+		testUnsubEmail, "", sub.Email, sub.Uid,
+	)
 	expectedLogMsg := fmt.Sprintf(
 		"sent \"%s\" id: %s to: %s", subject, msgId, sub.Email,
 	)
@@ -786,6 +1457,138 @@ func assertDidNotSendToPendingSubscribers(
 	}
 }
 
+func TestAuditEvents(t *testing.T) {
+	setup := func() (*prodAgentTestFixture, context.Context) {
+		f := newProdAgentTestFixture()
+		ctx := WithAuditInfo(context.Background(), "api", "deadbeef")
+		return f, ctx
+	}
+
+	assertRecorded := func(
+		t *testing.T,
+		f *prodAgentTestFixture,
+		op db.AuditOperation,
+		reason ops.RemoveReason,
+	) {
+		t.Helper()
+		expected := []*db.AuditEvent{
+			{
+				Email:     testEmail,
+				Operation: op,
+				Reason:    reason,
+				Source:    "api",
+				RequestId: "deadbeef",
+				Timestamp: td.TestTimestamp,
+			},
+		}
+		assert.DeepEqual(t, expected, f.audit.Events)
+	}
+
+	t.Run("Subscribe", func(t *testing.T) {
+		f, ctx := setup()
+
+		_, err := f.agent.Subscribe(ctx, testEmail, nil, nil, nil)
+
+		assert.NilError(t, err)
+		assertRecorded(t, f, db.AuditSubscribe, ops.RemoveReasonNil)
+	})
+
+	t.Run("SubscribeDoesNotRecordResends", func(t *testing.T) {
+		f, ctx := setup()
+		assert.NilError(t, f.db.Put(ctx, pendingSubscriber))
+
+		_, err := f.agent.Subscribe(ctx, testEmail, nil, nil, nil)
+
+		assert.NilError(t, err)
+		assert.Equal(t, 0, len(f.audit.Events))
+	})
+
+	t.Run("Verify", func(t *testing.T) {
+		f, ctx := setup()
+		pending := *pendingSubscriber
+		assert.NilError(t, f.db.Put(ctx, &pending))
+
+		_, err := f.agent.Verify(ctx, testEmail, pending.Uid)
+
+		assert.NilError(t, err)
+		assertRecorded(t, f, db.AuditVerify, ops.RemoveReasonNil)
+	})
+
+	t.Run("Unsubscribe", func(t *testing.T) {
+		f, ctx := setup()
+		assert.NilError(t, f.db.Put(ctx, verifiedSubscriber))
+
+		_, err := f.agent.Unsubscribe(ctx, testEmail, verifiedSubscriber.Uid)
+
+		assert.NilError(t, err)
+		assertRecorded(t, f, db.AuditUnsubscribe, ops.RemoveReasonNil)
+	})
+
+	t.Run("Import", func(t *testing.T) {
+		f, ctx := setup()
+
+		err := f.agent.Import(ctx, testEmail, nil)
+
+		assert.NilError(t, err)
+		assertRecorded(t, f, db.AuditImport, ops.RemoveReasonNil)
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		f, ctx := setup()
+		assert.NilError(t, f.db.Put(ctx, verifiedSubscriber))
+
+		err := f.agent.Remove(ctx, testEmail, ops.RemoveReasonBounce)
+
+		assert.NilError(t, err)
+		assertRecorded(t, f, db.AuditRemove, ops.RemoveReasonBounce)
+	})
+
+	t.Run("Restore", func(t *testing.T) {
+		f, ctx := setup()
+
+		err := f.agent.Restore(ctx, testEmail)
+
+		assert.NilError(t, err)
+		assertRecorded(t, f, db.AuditRestore, ops.RemoveReasonNil)
+	})
+
+	t.Run("DoesNotRecordFailedOperations", func(t *testing.T) {
+		f, ctx := setup()
+		f.db.SimulateDelErr = func(address string) error {
+			return makeServerError("failed to delete " + address)
+		}
+
+		err := f.agent.Remove(ctx, testEmail, ops.RemoveReasonBounce)
+
+		assert.ErrorContains(t, err, "failed to delete "+testEmail)
+		assert.Equal(t, 0, len(f.audit.Events))
+	})
+
+	t.Run("RecordsEmptySourceWithoutAuditInfo", func(t *testing.T) {
+		f := newProdAgentTestFixture()
+
+		err := f.agent.Import(context.Background(), testEmail, nil)
+
+		assert.NilError(t, err)
+		assert.Equal(t, 1, len(f.audit.Events))
+		assert.Equal(t, "", f.audit.Events[0].Source)
+		assert.Equal(t, "", f.audit.Events[0].RequestId)
+	})
+
+	t.Run("OnlyLogsIfRecordingFails", func(t *testing.T) {
+		f, ctx := setup()
+		f.audit.PutErr = makeServerError("audit log unavailable")
+
+		err := f.agent.Import(ctx, testEmail, nil)
+
+		assert.NilError(t, err)
+		assert.Equal(t, db.SubscriberVerified, f.db.Index[testEmail].Status)
+		f.logs.AssertContains(
+			t, "ERROR recording import audit event for "+testEmail,
+		)
+	})
+}
+
 func TestSend(t *testing.T) {
 	setup := func() (
 		*ProdAgent,
@@ -815,7 +1618,7 @@ func TestSend(t *testing.T) {
 		t.Run("Succeeds", func(t *testing.T) {
 			agent, _, mailer, logs, ctx := setup()
 
-			numSent, err := agent.Send(ctx, msg, []string{})
+			numSent, _, err := agent.Send(ctx, msg, []string{}, "", "")
 
 			assert.NilError(t, err)
 			assertSentToVerifiedSubscribers(t, subject, mailer, logs)
@@ -827,7 +1630,7 @@ func TestSend(t *testing.T) {
 			agent, _, mailer, _, ctx := setup()
 			mailer.BulkCapError = email.ErrBulkSendCapacityExhausted
 
-			numSent, err := agent.Send(ctx, msg, []string{})
+			numSent, _, err := agent.Send(ctx, msg, []string{}, "", "")
 
 			const expectedErrMsg = "couldn't send to subscribers: "
 			assert.ErrorContains(t, err, expectedErrMsg)
@@ -844,7 +1647,7 @@ func TestSend(t *testing.T) {
 				return procSubsErr
 			}
 
-			numSent, err := agent.Send(ctx, msg, []string{})
+			numSent, _, err := agent.Send(ctx, msg, []string{}, "", "")
 
 			expectedErrMsg := fmt.Sprintf(
 				"error sending \"%s\" to list: ProcSubsInState error", subject,
@@ -862,89 +1665,2195 @@ func TestSend(t *testing.T) {
 			sendErr := errors.New("Mailer.Send failed")
 			mailer.RecipientErrors[subs[1].Email] = sendErr
 
-			numSent, err := agent.Send(ctx, msg, []string{})
+			numSent, _, err := agent.Send(ctx, msg, []string{}, "", "")
 
 			assert.Assert(t, tu.ErrorIs(err, sendErr))
 			assertSentToVerifiedSubscriber(t, subject, subs[0], mailer, logs)
 			mailer.AssertNoMessageSent(t, subs[1].Email)
 			assert.Equal(t, 1, numSent)
 		})
-	})
 
-	t.Run("ToSpecificRecipients", func(t *testing.T) {
-		t.Run("Succeeds", func(t *testing.T) {
-			agent, _, mailer, logs, ctx := setup()
-			subs := []*db.Subscriber{
-				db.TestVerifiedSubscribers[0], db.TestVerifiedSubscribers[2],
-			}
-			addrs := getAddrs(subs...)
+		t.Run("SavesCompletedCheckpoint", func(t *testing.T) {
+			f := newProdAgentTestFixture()
+			f.setupTestSubscribers()
+			ctx := context.Background()
+			campaignId := msg.Hash()
+			subs := db.TestVerifiedSubscribers
+			lastSub := subs[len(subs)-1]
 
-			numSent, err := agent.Send(ctx, msg, addrs)
+			_, _, err := f.agent.Send(ctx, msg, []string{}, "", "")
 
 			assert.NilError(t, err)
-			assert.Equal(t, len(addrs), numSent)
-			assertSentToVerifiedSubscriber(t, subject, subs[0], mailer, logs)
-			assertSentToVerifiedSubscriber(t, subject, subs[1], mailer, logs)
-		})
+			expected := &db.SendCheckpoint{
+				CampaignId:  campaignId,
+				MessageHash: msg.Hash(),
+				LastKey:     lastSub.ScanKey(),
+				NumSent:     len(subs),
+				Complete:    true,
+				Timestamp:   td.TestTimestamp,
+			}
+			assert.DeepEqual(t, expected, f.checkpoints.Checkpoints[campaignId])
 
-		t.Run("FailsIfDbGetReturnsError", func(t *testing.T) {
-			agent, dbase, mailer, logs, ctx := setup()
-			subs := []*db.Subscriber{
-				db.TestVerifiedSubscribers[0], db.TestVerifiedSubscribers[2],
+			for _, sub := range subs {
+				received, err := f.db.HasReceived(ctx, sub.Email, campaignId)
+				assert.NilError(t, err)
+				assert.Assert(t, received)
 			}
-			addrs := getAddrs(subs...)
-			getErr := errors.New("Get error")
-			dbase.SimulateGetErr = func(addr string) error {
-				// Return an error on the first address, but the second should still
-				// succeed.
-				if addr == addrs[0] {
-					return getErr
-				}
-				return nil
+		})
+
+		t.Run("SavesCompletedCampaign", func(t *testing.T) {
+			f := newProdAgentTestFixture()
+			f.setupTestSubscribers()
+			campaignId := msg.Hash()
+
+			_, _, err := f.agent.Send(
+				context.Background(), msg, []string{}, "", "",
+			)
+
+			assert.NilError(t, err)
+			expected := &db.Campaign{
+				Id:          campaignId,
+				Subject:     subject,
+				MessageHash: msg.Hash(),
+				StartTime:   td.TestTimestamp,
+				FinishTime:  td.TestTimestamp,
+				NumSent:     len(db.TestVerifiedSubscribers),
+				NumFailed:   0,
+				Status:      db.CampaignComplete,
 			}
+			assert.DeepEqual(t, expected, f.campaigns.Campaigns[campaignId])
+		})
 
-			numSent, err := agent.Send(ctx, msg, addrs)
+		t.Run("UsesIdempotencyKeyAsCampaignId", func(t *testing.T) {
+			f := newProdAgentTestFixture()
+			f.setupTestSubscribers()
+			const key = "idempotency-key"
 
-			assert.Equal(t, 1, numSent)
-			assert.Assert(t, tu.ErrorIs(err, getErr))
-			assertSentToVerifiedSubscriber(t, subject, subs[1], mailer, logs)
-			mailer.AssertNoMessageSent(t, addrs[0])
+			numSent, _, err := f.agent.Send(
+				context.Background(), msg, []string{}, key, "",
+			)
+
+			assert.NilError(t, err)
+			assert.Equal(t, len(db.TestVerifiedSubscribers), numSent)
+			assert.Assert(t, f.checkpoints.Checkpoints[key].Complete)
+			campaign := f.campaigns.Campaigns[key]
+			assert.Assert(t, campaign != nil)
+			assert.Equal(t, db.CampaignComplete, campaign.Status)
 		})
 
-		t.Run("FailsIfAddressNotVerified", func(t *testing.T) {
-			agent, _, mailer, _, ctx := setup()
-			addr := db.TestPendingSubscribers[0].Email
+		t.Run("SkipsSubscribersWhoAlreadyReceivedMessage", func(t *testing.T) {
+			f := newProdAgentTestFixture()
+			f.setupTestSubscribers()
+			ctx := context.Background()
+			subs := db.TestVerifiedSubscribers
+			err := f.db.MarkReceived(ctx, subs[0].Email, msg.Hash())
+			assert.NilError(t, err)
+
+			numSent, numSkipped, err := f.agent.Send(
+				ctx, msg, []string{}, "", "",
+			)
+
+			assert.NilError(t, err)
+			assert.Equal(t, len(subs)-1, numSent)
+			assert.Equal(t, 1, numSkipped)
+			f.mailer.AssertNoMessageSent(t, subs[0].Email)
+		})
+
+		t.Run("SkipsEveryoneIfSentAgain", func(t *testing.T) {
+			f := newProdAgentTestFixture()
+			f.setupTestSubscribers()
+			ctx := context.Background()
+			subs := db.TestVerifiedSubscribers
+			_, _, err := f.agent.Send(ctx, msg, []string{}, "", "")
+			assert.NilError(t, err)
+			f.mailer.RecipientMessages = map[string][]byte{}
+			later := td.TestTimestamp.Add(time.Hour)
+			f.agent.CurrentTime = func() time.Time { return later }
 
-			numSent, err := agent.Send(ctx, msg, []string{addr})
+			numSent, numSkipped, err := f.agent.Send(
+				ctx, msg, []string{}, "", "",
+			)
 
+			assert.NilError(t, err)
 			assert.Equal(t, 0, numSent)
-			assert.ErrorContains(t, err, addr+": not verified")
-			mailer.AssertNoMessageSent(t, addr)
+			assert.Equal(t, len(subs), numSkipped)
+			assert.Equal(t, 0, len(f.mailer.RecipientMessages))
+
+			campaign := f.campaigns.Campaigns[msg.Hash()]
+			assert.Equal(t, db.CampaignComplete, campaign.Status)
+			assert.Equal(t, len(subs), campaign.NumSent)
+			assert.Equal(t, td.TestTimestamp, campaign.StartTime)
+			assert.Equal(t, later, campaign.FinishTime)
 		})
 
-		t.Run("FailsIfSendOneEmailFails", func(t *testing.T) {
-			agent, _, mailer, _, ctx := setup()
-			addr := db.TestVerifiedSubscribers[0].Email
-			sendErr := errors.New("Mailer.Send failed")
-			mailer.RecipientErrors[addr] = sendErr
+		t.Run("FailsIfIdempotencyKeyUsedForOtherMessage", func(t *testing.T) {
+			f := newProdAgentTestFixture()
+			f.setupTestSubscribers()
+			ctx := context.Background()
+			const key = "idempotency-key"
+			_, _, err := f.agent.Send(ctx, msg, []string{}, key, "")
+			assert.NilError(t, err)
+			f.mailer.RecipientMessages = map[string][]byte{}
+			otherMsg := *msg
+			otherMsg.Subject = "Some other subject"
 
-			numSent, err := agent.Send(ctx, msg, []string{addr})
+			numSent, _, err := f.agent.Send(ctx, &otherMsg, []string{}, key, "")
 
+			assert.Error(t, err, "message doesn't match campaign "+key)
 			assert.Equal(t, 0, numSent)
-			assert.Assert(t, tu.ErrorIs(err, sendErr))
-			mailer.AssertNoMessageSent(t, addr)
+			assert.Equal(t, 0, len(f.mailer.RecipientMessages))
 		})
-	})
 
-	t.Run("FailsIfMessageFailsValidationDueToFromDomain", func(t *testing.T) {
-		agent, _, _, _, ctx := setup()
-		badMsg := *msg
-		badMsg.From = "Blog Updates <updates@bar.com>"
+		t.Run("FailsIfCannotGetCheckpoint", func(t *testing.T) {
+			f := newProdAgentTestFixture()
+			f.setupTestSubscribers()
+			getErr := errors.New("GetCheckpoint failed")
+			f.checkpoints.GetErr = getErr
 
-		numSent, err := agent.Send(ctx, &badMsg, []string{})
+			numSent, _, err := f.agent.Send(
+				context.Background(), msg, []string{}, "", "",
+			)
 
-		const expectedErr = "domain of From address is not " + testDomainName
-		assert.ErrorContains(t, err, expectedErr)
+			expectedErr := "couldn't start campaign " + msg.Hash() + ": "
+			assert.ErrorContains(t, err, expectedErr)
+			assert.Assert(t, tu.ErrorIs(err, getErr))
+			assert.Equal(t, 0, numSent)
+			assert.Equal(t, 0, len(f.mailer.RecipientMessages))
+		})
+
+		t.Run("FailsIfCannotGetExistingCampaign", func(t *testing.T) {
+			f := newProdAgentTestFixture()
+			f.setupTestSubscribers()
+			ctx := context.Background()
+			_, _, err := f.agent.Send(ctx, msg, []string{}, "", "")
+			assert.NilError(t, err)
+			delete(f.campaigns.Campaigns, msg.Hash())
+
+			_, _, err = f.agent.Send(ctx, msg, []string{}, "", "")
+
+			expectedErr := "couldn't start campaign " + msg.Hash() + ": "
+			assert.ErrorContains(t, err, expectedErr)
+			assert.Assert(t, tu.ErrorIs(err, db.ErrCampaignNotFound))
+		})
+
+		t.Run("FailsIfCannotSaveInitialCheckpoint", func(t *testing.T) {
+			f := newProdAgentTestFixture()
+			f.setupTestSubscribers()
+			putErr := errors.New("PutCheckpoint failed")
+			f.checkpoints.PutErr = putErr
+
+			numSent, _, err := f.agent.Send(
+				context.Background(), msg, []string{}, "", "",
+			)
+
+			const expectedErrMsg = "couldn't start sending to subscribers: "
+			assert.ErrorContains(t, err, expectedErrMsg)
+			assert.Assert(t, tu.ErrorIs(err, putErr))
+			assert.Equal(t, 0, numSent)
+			assert.Equal(t, 0, len(f.mailer.RecipientMessages))
+		})
+
+		t.Run("FailsIfCannotSaveInitialCampaign", func(t *testing.T) {
+			f := newProdAgentTestFixture()
+			f.setupTestSubscribers()
+			putErr := errors.New("PutCampaign failed")
+			f.campaigns.PutErr = putErr
+
+			numSent, _, err := f.agent.Send(
+				context.Background(), msg, []string{}, "", "",
+			)
+
+			const expectedErrMsg = "couldn't start sending to subscribers: "
+			assert.ErrorContains(t, err, expectedErrMsg)
+			assert.Assert(t, tu.ErrorIs(err, putErr))
+			assert.Equal(t, 0, numSent)
+			assert.Equal(t, 0, len(f.mailer.RecipientMessages))
+		})
+
+		t.Run("SavesIncompleteCheckpointIfSendFails", func(t *testing.T) {
+			f := newProdAgentTestFixture()
+			f.setupTestSubscribers()
+			campaignId := msg.Hash()
+			subs := db.TestVerifiedSubscribers
+			sendErr := errors.New("Mailer.Send failed")
+			f.mailer.RecipientErrors[subs[1].Email] = sendErr
+
+			numSent, _, err := f.agent.Send(
+				context.Background(), msg, []string{}, "", "",
+			)
+
+			var incompleteErr *IncompleteSendError
+			assert.Assert(t, errors.As(err, &incompleteErr))
+			assert.Equal(t, campaignId, incompleteErr.CampaignId)
+			assert.Assert(t, tu.ErrorIs(err, sendErr))
+			assert.Equal(t, 1, numSent)
+
+			cp := f.checkpoints.Checkpoints[campaignId]
+			assert.Assert(t, cp != nil)
+			assert.Assert(t, !cp.Complete)
+			assert.Equal(t, 1, cp.NumSent)
+			assert.DeepEqual(t, subs[0].ScanKey(), cp.LastKey)
+
+			campaign := f.campaigns.Campaigns[campaignId]
+			assert.Assert(t, campaign != nil)
+			assert.Equal(t, db.CampaignIncomplete, campaign.Status)
+			assert.Equal(t, 1, campaign.NumSent)
+			assert.Equal(t, 1, campaign.NumFailed)
+			assert.Assert(t, campaign.FinishTime.IsZero())
+		})
+
+		t.Run("ResumesIncompleteCampaignIfSentAgain", func(t *testing.T) {
+			f := newProdAgentTestFixture()
+			f.setupTestSubscribers()
+			ctx := context.Background()
+			campaignId := msg.Hash()
+			subs := db.TestVerifiedSubscribers
+			f.mailer.RecipientErrors[subs[1].Email] = errors.New("failed")
+
+			_, _, err := f.agent.Send(ctx, msg, []string{}, "", "")
+
+			var incompleteErr *IncompleteSendError
+			assert.Assert(t, errors.As(err, &incompleteErr))
+			delete(f.mailer.RecipientErrors, subs[1].Email)
+			f.db.SimulateReceivedErr = func(address string) (err error) {
+				if address == subs[0].Email {
+					err = errors.New("should've resumed after " + address)
+				}
+				return
+			}
+
+			numSent, numSkipped, err := f.agent.Send(
+				ctx, msg, []string{}, "", "",
+			)
+
+			assert.NilError(t, err)
+			assert.Equal(t, 2, numSent)
+			assert.Equal(t, 0, numSkipped)
+			assert.Assert(t, f.checkpoints.Checkpoints[campaignId].Complete)
+		})
+
+		t.Run("StopsBeforeDeadline", func(t *testing.T) {
+			f := newProdAgentTestFixture()
+			f.setupTestSubscribers()
+			deadline := time.Now().Add(time.Hour)
+			ctx, cancel := context.WithDeadline(context.Background(), deadline)
+			defer cancel()
+			f.agent.CurrentTime = func() time.Time {
+				return deadline.Add(-sendDeadlineMargin)
+			}
+
+			numSent, _, err := f.agent.Send(ctx, msg, []string{}, "", "")
+
+			var incompleteErr *IncompleteSendError
+			assert.Assert(t, errors.As(err, &incompleteErr))
+			assert.Assert(t, tu.ErrorIs(err, ErrSendDeadlineApproaching))
+			assert.Equal(t, 0, numSent)
+			assert.Equal(t, 0, len(f.mailer.RecipientMessages))
+
+			cp := f.checkpoints.Checkpoints[msg.Hash()]
+			assert.Assert(t, cp != nil)
+			assert.Assert(t, !cp.Complete)
+			assert.Assert(t, is.Nil(cp.LastKey))
+		})
+
+		t.Run("FailsIfMarkReceivedFails", func(t *testing.T) {
+			agent, dbase, mailer, _, ctx := setup()
+			subs := db.TestVerifiedSubscribers
+			markErr := errors.New("MarkReceived failed")
+			dbase.SimulateMarkErr = func(address string) (err error) {
+				if address == subs[0].Email {
+					err = markErr
+				}
+				return
+			}
+
+			numSent, _, err := agent.Send(ctx, msg, []string{}, "", "")
+
+			assert.Assert(t, tu.ErrorIs(err, markErr))
+			assert.Equal(t, 1, numSent)
+			mailer.AssertNoMessageSent(t, subs[1].Email)
+		})
+
+		t.Run("FailsIfHasReceivedFails", func(t *testing.T) {
+			agent, dbase, mailer, _, ctx := setup()
+			subs := db.TestVerifiedSubscribers
+			receivedErr := errors.New("HasReceived failed")
+			dbase.SimulateReceivedErr = func(address string) (err error) {
+				if address == subs[1].Email {
+					err = receivedErr
+				}
+				return
+			}
+
+			numSent, _, err := agent.Send(ctx, msg, []string{}, "", "")
+
+			var incompleteErr *IncompleteSendError
+			assert.Assert(t, errors.As(err, &incompleteErr))
+			assert.Assert(t, tu.ErrorIs(err, receivedErr))
+			assert.Equal(t, 1, numSent)
+			mailer.AssertNoMessageSent(t, subs[1].Email)
+		})
+	})
+
+	t.Run("ToTaggedSubscribers", func(t *testing.T) {
+		// Gives the verified subscribers the tags:
+		// - foo@test.com: releases
+		// - bar@test.com: essays
+		// - baz@test.com: essays, releases
+		setupTagged := func() (*prodAgentTestFixture, context.Context) {
+			f := newProdAgentTestFixture()
+			ctx := context.Background()
+			tags := [][]string{{"releases"}, {"essays"}, {"essays", "releases"}}
+
+			for i, sub := range db.TestVerifiedSubscribers {
+				tagged := *sub
+				tagged.Tags = tags[i]
+				assert.NilError(t, f.db.Put(ctx, &tagged))
+				f.mailer.MessageIds[sub.Email] = fmt.Sprintf("msg-%d", i)
+			}
+			return f, ctx
+		}
+		subs := db.TestVerifiedSubscribers
+
+		t.Run("SendsOnlyToMatchingSubscribers", func(t *testing.T) {
+			f, ctx := setupTagged()
+
+			numSent, _, err := f.agent.Send(ctx, msg, nil, "", "essays")
+
+			assert.NilError(t, err)
+			assert.Equal(t, 2, numSent)
+			f.mailer.AssertNoMessageSent(t, subs[0].Email)
+			for _, sub := range subs[1:] {
+				assertSentToVerifiedSubscriber(
+					t, subject, sub, f.mailer, f.logs,
+				)
+			}
+		})
+
+		t.Run("SavesTagFilterInCheckpointAndCampaign", func(t *testing.T) {
+			f, ctx := setupTagged()
+			campaignId := msg.Hash()
+
+			numSent, _, err := f.agent.Send(
+				ctx, msg, nil, "", "releases  and not (essays)",
+			)
+
+			assert.NilError(t, err)
+			assert.Equal(t, 1, numSent)
+			assertSentToVerifiedSubscriber(
+				t, subject, subs[0], f.mailer, f.logs,
+			)
+			const expectedFilter = "releases and not essays"
+			cp := f.checkpoints.Checkpoints[campaignId]
+			assert.Equal(t, expectedFilter, cp.TagFilter)
+			campaign := f.campaigns.Campaigns[campaignId]
+			assert.Equal(t, expectedFilter, campaign.TagFilter)
+		})
+
+		t.Run("FailsIfTagFilterDiffersFromCampaign", func(t *testing.T) {
+			f, ctx := setupTagged()
+			campaignId := msg.Hash()
+
+			numSent, _, err := f.agent.Send(ctx, msg, nil, "", "releases")
+			assert.NilError(t, err)
+			assert.Equal(t, 2, numSent)
+
+			numSent, _, err = f.agent.Send(ctx, msg, nil, "", "essays")
+
+			expectedErr := `tag filter "essays" doesn't match campaign ` +
+				campaignId + ` filter "releases"; use a new idempotency key`
+			assert.ErrorContains(t, err, expectedErr)
+			assert.Equal(t, 0, numSent)
+			f.mailer.AssertNoMessageSent(t, subs[1].Email)
+			cp := f.checkpoints.Checkpoints[campaignId]
+			assert.Equal(t, "releases", cp.TagFilter)
+			campaign := f.campaigns.Campaigns[campaignId]
+			assert.Equal(t, "releases", campaign.TagFilter)
+		})
+
+		t.Run("SendsToNewTagFilterWithNewKey", func(t *testing.T) {
+			f, ctx := setupTagged()
+
+			numSent, _, err := f.agent.Send(ctx, msg, nil, "", "releases")
+			assert.NilError(t, err)
+			assert.Equal(t, 2, numSent)
+
+			numSent, numSkipped, err := f.agent.Send(
+				ctx, msg, nil, "essays-key", "essays",
+			)
+
+			assert.NilError(t, err)
+			assert.Equal(t, 2, numSent)
+			assert.Equal(t, 0, numSkipped)
+			cp := f.checkpoints.Checkpoints["essays-key"]
+			assert.Equal(t, "essays", cp.TagFilter)
+		})
+
+		t.Run("FailsIfTagFilterIsInvalid", func(t *testing.T) {
+			f, ctx := setupTagged()
+
+			numSent, _, err := f.agent.Send(ctx, msg, nil, "", "essays or")
+
+			const expectedErr = `invalid tag filter "essays or": ` +
+				"unexpected end of expression"
+			assert.Error(t, err, expectedErr)
+			assert.Equal(t, 0, numSent)
+			assert.Equal(t, 0, len(f.campaigns.Campaigns))
+		})
+
+		t.Run("FailsIfAddressesSpecified", func(t *testing.T) {
+			f, ctx := setupTagged()
+			addrs := getAddrs(subs[0])
+
+			numSent, _, err := f.agent.Send(ctx, msg, addrs, "", "releases")
+
+			assert.Error(
+				t, err, "can't apply a tag filter to specific recipients",
+			)
+			assert.Equal(t, 0, numSent)
+			f.mailer.AssertNoMessageSent(t, subs[0].Email)
+		})
+	})
+
+	t.Run("ToSpecificRecipients", func(t *testing.T) {
+		t.Run("Succeeds", func(t *testing.T) {
+			agent, _, mailer, logs, ctx := setup()
+			subs := []*db.Subscriber{
+				db.TestVerifiedSubscribers[0], db.TestVerifiedSubscribers[2],
+			}
+			addrs := getAddrs(subs...)
+
+			numSent, _, err := agent.Send(ctx, msg, addrs, "", "")
+
+			assert.NilError(t, err)
+			assert.Equal(t, len(addrs), numSent)
+			assertSentToVerifiedSubscriber(t, subject, subs[0], mailer, logs)
+			assertSentToVerifiedSubscriber(t, subject, subs[1], mailer, logs)
+		})
+
+		t.Run("SkipsRecipientsWhoAlreadyReceivedMessage", func(t *testing.T) {
+			agent, dbase, mailer, _, ctx := setup()
+			addr := db.TestVerifiedSubscribers[0].Email
+			const key = "test-key"
+
+			numSent, numSkipped, err := agent.Send(
+				ctx, msg, []string{addr}, key, "",
+			)
+
+			assert.NilError(t, err)
+			assert.Equal(t, 1, numSent)
+			assert.Equal(t, 0, numSkipped)
+			received, err := dbase.HasReceived(ctx, addr, key)
+			assert.NilError(t, err)
+			assert.Assert(t, received)
+
+			delete(mailer.RecipientMessages, addr)
+			numSent, numSkipped, err = agent.Send(
+				ctx, msg, []string{addr}, key, "",
+			)
+
+			assert.NilError(t, err)
+			assert.Equal(t, 0, numSent)
+			assert.Equal(t, 1, numSkipped)
+			mailer.AssertNoMessageSent(t, addr)
+		})
+
+		t.Run("DoesNotRecordReceiptsWithoutKey", func(t *testing.T) {
+			agent, dbase, mailer, logs, ctx := setup()
+			sub := db.TestVerifiedSubscribers[0]
+
+			numSent, _, err := agent.Send(
+				ctx, msg, []string{sub.Email}, "", "",
+			)
+
+			assert.NilError(t, err)
+			assert.Equal(t, 1, numSent)
+			assert.Equal(t, 0, len(dbase.Receipts[sub.Email]))
+
+			delete(mailer.RecipientMessages, sub.Email)
+			numSent, numSkipped, err := agent.Send(ctx, msg, nil, "", "")
+
+			assert.NilError(t, err)
+			assert.Equal(t, len(db.TestVerifiedSubscribers), numSent)
+			assert.Equal(t, 0, numSkipped)
+			assertSentToVerifiedSubscriber(t, subject, sub, mailer, logs)
+		})
+
+		t.Run("PersonalizesMessage", func(t *testing.T) {
+			agent, dbase, mailer, _, ctx := setup()
+			sub := *db.TestVerifiedSubscribers[0]
+			sub.FirstName = "Mike"
+			sub.Attributes = map[string]string{"City": "Chicago"}
+			assert.NilError(t, dbase.Put(ctx, &sub))
+			personalMsg := *msg
+			personalMsg.Subject = "Hi, {{FirstName}}"
+			personalMsg.TextBody = "How's {{City}}? It's {{Weather}} here."
+			personalMsg.Defaults = map[string]string{
+				"City": "", "Weather": "sunny",
+			}
+
+			addrs := []string{sub.Email}
+
+			numSent, _, err := agent.Send(ctx, &personalMsg, addrs, "", "")
+
+			assert.NilError(t, err)
+			assert.Equal(t, 1, numSent)
+			_, m := mailer.GetMessageTo(t, sub.Email)
+			assert.Assert(t, is.Contains(m, "Subject: Hi, Mike\r\n"))
+			assert.Assert(t, is.Contains(m, "How's Chicago? It's sunny here."))
+		})
+
+		t.Run("FailsIfDbGetReturnsError", func(t *testing.T) {
+			agent, dbase, mailer, logs, ctx := setup()
+			subs := []*db.Subscriber{
+				db.TestVerifiedSubscribers[0], db.TestVerifiedSubscribers[2],
+			}
+			addrs := getAddrs(subs...)
+			getErr := errors.New("Get error")
+			dbase.SimulateGetErr = func(addr string) error {
+				// Return an error on the first address, but the second should still
+				// succeed.
+				if addr == addrs[0] {
+					return getErr
+				}
+				return nil
+			}
+
+			numSent, _, err := agent.Send(ctx, msg, addrs, "", "")
+
+			assert.Equal(t, 1, numSent)
+			assert.Assert(t, tu.ErrorIs(err, getErr))
+			assertSentToVerifiedSubscriber(t, subject, subs[1], mailer, logs)
+			mailer.AssertNoMessageSent(t, addrs[0])
+		})
+
+		t.Run("FailsIfAddressNotVerified", func(t *testing.T) {
+			agent, _, mailer, _, ctx := setup()
+			addr := db.TestPendingSubscribers[0].Email
+
+			numSent, _, err := agent.Send(ctx, msg, []string{addr}, "", "")
+
+			assert.Equal(t, 0, numSent)
+			assert.ErrorContains(t, err, addr+": not verified")
+			mailer.AssertNoMessageSent(t, addr)
+		})
+
+		t.Run("FailsIfSendOneEmailFails", func(t *testing.T) {
+			agent, _, mailer, _, ctx := setup()
+			addr := db.TestVerifiedSubscribers[0].Email
+			sendErr := errors.New("Mailer.Send failed")
+			mailer.RecipientErrors[addr] = sendErr
+
+			numSent, _, err := agent.Send(ctx, msg, []string{addr}, "", "")
+
+			assert.Equal(t, 0, numSent)
+			assert.Assert(t, tu.ErrorIs(err, sendErr))
+			mailer.AssertNoMessageSent(t, addr)
+		})
+	})
+
+	t.Run("FailsIfMessageFailsValidationDueToFromDomain", func(t *testing.T) {
+		agent, _, _, _, ctx := setup()
+		badMsg := *msg
+		badMsg.From = "Blog Updates <updates@bar.com>"
+
+		numSent, _, err := agent.Send(ctx, &badMsg, []string{}, "", "")
+
+		const expectedErr = "domain of From address is not " + testDomainName
+		assert.ErrorContains(t, err, expectedErr)
+		assert.Equal(t, 0, numSent)
+	})
+}
+
+func TestResumeSend(t *testing.T) {
+	const campaignId = "campaign-id"
+	msg := testMessage()
+	subject := msg.Subject
+	subs := db.TestVerifiedSubscribers
+
+	setup := func() (*prodAgentTestFixture, context.Context) {
+		f := newProdAgentTestFixture()
+		f.setupTestSubscribers()
+		f.checkpoints.Checkpoints[campaignId] = &db.SendCheckpoint{
+			CampaignId: campaignId, MessageHash: msg.Hash(),
+		}
+		f.campaigns.Campaigns[campaignId] = &db.Campaign{
+			Id:          campaignId,
+			Subject:     subject,
+			MessageHash: msg.Hash(),
+			StartTime:   td.TestTimestamp.Add(-time.Hour),
+			Status:      db.CampaignIncomplete,
+		}
+		return f, context.Background()
+	}
+
+	t.Run("SendsToRemainingSubscribers", func(t *testing.T) {
+		f, ctx := setup()
+		f.checkpoints.Checkpoints[campaignId].LastKey = subs[0].ScanKey()
+		f.checkpoints.Checkpoints[campaignId].NumSent = 1
+
+		numSent, _, err := f.agent.ResumeSend(ctx, campaignId, msg)
+
+		assert.NilError(t, err)
+		assert.Equal(t, len(subs)-1, numSent)
+		f.mailer.AssertNoMessageSent(t, subs[0].Email)
+		for _, sub := range subs[1:] {
+			assertSentToVerifiedSubscriber(t, subject, sub, f.mailer, f.logs)
+		}
+
+		cp := f.checkpoints.Checkpoints[campaignId]
+		assert.Assert(t, cp.Complete)
+		assert.Equal(t, len(subs), cp.NumSent)
+
+		campaign := f.campaigns.Campaigns[campaignId]
+		assert.Equal(t, db.CampaignComplete, campaign.Status)
+		assert.Equal(t, len(subs), campaign.NumSent)
+		assert.Equal(t, td.TestTimestamp.Add(-time.Hour), campaign.StartTime)
+		assert.Equal(t, td.TestTimestamp, campaign.FinishTime)
+	})
+
+	t.Run("AppliesCheckpointTagFilter", func(t *testing.T) {
+		f, ctx := setup()
+		f.checkpoints.Checkpoints[campaignId].TagFilter = "releases"
+		tagged := *subs[2]
+		tagged.Tags = []string{"releases"}
+		assert.NilError(t, f.db.Delete(ctx, tagged.Email))
+		assert.NilError(t, f.db.Put(ctx, &tagged))
+
+		numSent, _, err := f.agent.ResumeSend(ctx, campaignId, msg)
+
+		assert.NilError(t, err)
+		assert.Equal(t, 1, numSent)
+		assertSentToVerifiedSubscriber(t, subject, &tagged, f.mailer, f.logs)
+		f.mailer.AssertNoMessageSent(t, subs[0].Email)
+		f.mailer.AssertNoMessageSent(t, subs[1].Email)
+	})
+
+	t.Run("FailsIfCheckpointTagFilterIsInvalid", func(t *testing.T) {
+		f, ctx := setup()
+		f.checkpoints.Checkpoints[campaignId].TagFilter = "releases and"
+
+		numSent, _, err := f.agent.ResumeSend(ctx, campaignId, msg)
+
+		const expectedErr = "couldn't send to subscribers: invalid tag filter"
+		assert.ErrorContains(t, err, expectedErr)
+		assert.Equal(t, 0, numSent)
+	})
+
+	t.Run("SkipsSubscribersWhoAlreadyReceivedMessage", func(t *testing.T) {
+		f, ctx := setup()
+		assert.NilError(t, f.db.MarkReceived(ctx, subs[0].Email, campaignId))
+
+		numSent, numSkipped, err := f.agent.ResumeSend(ctx, campaignId, msg)
+
+		assert.NilError(t, err)
+		assert.Equal(t, len(subs)-1, numSent)
+		assert.Equal(t, 1, numSkipped)
+		f.mailer.AssertNoMessageSent(t, subs[0].Email)
+		assert.Assert(t, f.checkpoints.Checkpoints[campaignId].Complete)
+	})
+
+	t.Run("FailsIfMessageFailsValidation", func(t *testing.T) {
+		f, ctx := setup()
+		badMsg := *msg
+		badMsg.From = "Blog Updates <updates@bar.com>"
+
+		numSent, _, err := f.agent.ResumeSend(ctx, campaignId, &badMsg)
+
+		const expectedErr = "domain of From address is not " + testDomainName
+		assert.ErrorContains(t, err, expectedErr)
+		assert.Equal(t, 0, numSent)
+	})
+
+	t.Run("FailsIfCheckpointNotFound", func(t *testing.T) {
+		f, ctx := setup()
+
+		numSent, _, err := f.agent.ResumeSend(ctx, "nonexistent-id", msg)
+
+		const expectedErr = "can't resume campaign nonexistent-id: "
+		assert.ErrorContains(t, err, expectedErr)
+		assert.Assert(t, tu.ErrorIs(err, db.ErrCheckpointNotFound))
+		assert.Equal(t, 0, numSent)
+	})
+
+	t.Run("FailsIfCampaignNotFound", func(t *testing.T) {
+		f, ctx := setup()
+		delete(f.campaigns.Campaigns, campaignId)
+
+		numSent, _, err := f.agent.ResumeSend(ctx, campaignId, msg)
+
+		const expectedErr = "can't resume campaign " + campaignId + ": "
+		assert.ErrorContains(t, err, expectedErr)
+		assert.Assert(t, tu.ErrorIs(err, db.ErrCampaignNotFound))
+		assert.Equal(t, 0, numSent)
+		assert.Equal(t, 0, len(f.mailer.RecipientMessages))
+	})
+
+	t.Run("FailsIfCampaignAlreadyComplete", func(t *testing.T) {
+		f, ctx := setup()
+		f.checkpoints.Checkpoints[campaignId].Complete = true
+
+		numSent, _, err := f.agent.ResumeSend(ctx, campaignId, msg)
+
+		assert.Error(t, err, "campaign "+campaignId+" is already complete")
+		assert.Equal(t, 0, numSent)
+		assert.Equal(t, 0, len(f.mailer.RecipientMessages))
+	})
+
+	t.Run("FailsIfMessageDoesNotMatchCampaign", func(t *testing.T) {
+		f, ctx := setup()
+		otherMsg := *msg
+		otherMsg.Subject = "Some other subject"
+
+		numSent, _, err := f.agent.ResumeSend(ctx, campaignId, &otherMsg)
+
+		assert.Error(t, err, "message doesn't match campaign "+campaignId)
+		assert.Equal(t, 0, numSent)
+		assert.Equal(t, 0, len(f.mailer.RecipientMessages))
+	})
+}
+
+func TestGetCampaign(t *testing.T) {
+	t.Run("Succeeds", func(t *testing.T) {
+		f := newProdAgentTestFixture()
+		expected := &db.Campaign{Id: "campaign-id", Subject: "Hello"}
+		f.campaigns.Campaigns[expected.Id] = expected
+
+		campaign, err := f.agent.GetCampaign(context.Background(), expected.Id)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, expected, campaign)
+	})
+
+	t.Run("FailsIfCampaignNotFound", func(t *testing.T) {
+		f := newProdAgentTestFixture()
+
+		campaign, err := f.agent.GetCampaign(context.Background(), "nope")
+
+		assert.Assert(t, is.Nil(campaign))
+		assert.Assert(t, tu.ErrorIs(err, db.ErrCampaignNotFound))
+	})
+}
+
+func TestListCampaigns(t *testing.T) {
+	t.Run("ReturnsMostRecentlyStartedFirst", func(t *testing.T) {
+		f := newProdAgentTestFixture()
+		campaigns := []*db.Campaign{
+			{Id: "campaign-0", StartTime: td.TestTimestamp},
+			{Id: "campaign-1", StartTime: td.TestTimestamp.Add(time.Hour)},
+			{Id: "campaign-2", StartTime: td.TestTimestamp.Add(-time.Hour)},
+		}
+		for _, c := range campaigns {
+			f.campaigns.Campaigns[c.Id] = c
+		}
+
+		result, err := f.agent.ListCampaigns(context.Background())
+
+		assert.NilError(t, err)
+		expected := []*db.Campaign{campaigns[1], campaigns[0], campaigns[2]}
+		assert.DeepEqual(t, expected, result)
+	})
+
+	t.Run("FailsIfListCampaignsFails", func(t *testing.T) {
+		f := newProdAgentTestFixture()
+		listErr := errors.New("ListCampaigns failed")
+		f.campaigns.ListErr = listErr
+
+		result, err := f.agent.ListCampaigns(context.Background())
+
+		assert.Equal(t, 0, len(result))
+		assert.Assert(t, tu.ErrorIs(err, listErr))
+	})
+}
+
+func TestTombstones(t *testing.T) {
+	setup := func() (*prodAgentTestFixture, context.Context) {
+		return newProdAgentTestFixture(), context.Background()
+	}
+
+	putTombstone := func(
+		t *testing.T,
+		f *prodAgentTestFixture,
+		reason db.TombstoneReason,
+		age time.Duration,
+	) *db.Tombstone {
+		t.Helper()
+		ts := &db.Tombstone{
+			Email:     testEmail,
+			Reason:    reason,
+			Timestamp: td.TestTimestamp.Add(-age),
+		}
+		assert.NilError(
+			t, f.tombstones.PutTombstone(context.Background(), ts),
+		)
+		return ts
+	}
+
+	newTombstone := func(reason db.TombstoneReason) *db.Tombstone {
+		return &db.Tombstone{
+			Email: testEmail, Reason: reason, Timestamp: td.TestTimestamp,
+		}
+	}
+
+	t.Run("Unsubscribe", func(t *testing.T) {
+		t.Run("RecordsTombstone", func(t *testing.T) {
+			f, ctx := setup()
+			assert.NilError(t, f.db.Put(ctx, verifiedSubscriber))
+			uid := verifiedSubscriber.Uid
+
+			result, err := f.agent.Unsubscribe(ctx, testEmail, uid)
+
+			assert.NilError(t, err)
+			assert.Equal(t, ops.Unsubscribed, result)
+			expected := newTombstone(db.TombstoneUnsubscribe)
+			assert.DeepEqual(t, expected, f.tombstones.Tombstones[testEmail])
+		})
+
+		t.Run("ReplacesExistingTombstone", func(t *testing.T) {
+			f, ctx := setup()
+			assert.NilError(t, f.db.Put(ctx, pendingSubscriber))
+			putTombstone(t, f, db.TombstoneBounce, time.Hour)
+			uid := pendingSubscriber.Uid
+
+			_, err := f.agent.Unsubscribe(ctx, testEmail, uid)
+
+			assert.NilError(t, err)
+			expected := newTombstone(db.TombstoneUnsubscribe)
+			assert.DeepEqual(t, expected, f.tombstones.Tombstones[testEmail])
+		})
+
+		t.Run("OnlyLogsIfRecordingFails", func(t *testing.T) {
+			f, ctx := setup()
+			assert.NilError(t, f.db.Put(ctx, verifiedSubscriber))
+			f.tombstones.PutErr = errors.New("test error")
+			uid := verifiedSubscriber.Uid
+
+			result, err := f.agent.Unsubscribe(ctx, testEmail, uid)
+
+			assert.NilError(t, err)
+			assert.Equal(t, ops.Unsubscribed, result)
+			f.logs.AssertContains(
+				t, "ERROR recording tombstone for "+testEmail+": test error",
+			)
+		})
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		t.Run("RecordsTombstone", func(t *testing.T) {
+			f, ctx := setup()
+
+			err := f.agent.Remove(ctx, testEmail, ops.RemoveReasonBounce)
+
+			assert.NilError(t, err)
+			expected := newTombstone(db.TombstoneBounce)
+			assert.DeepEqual(t, expected, f.tombstones.Tombstones[testEmail])
+		})
+
+		t.Run("KeepsExistingTombstone", func(t *testing.T) {
+			f, ctx := setup()
+			ts := putTombstone(t, f, db.TombstoneUnsubscribe, time.Hour)
+
+			err := f.agent.Remove(ctx, testEmail, ops.RemoveReasonComplaint)
+
+			assert.NilError(t, err)
+			assert.DeepEqual(t, ts, f.tombstones.Tombstones[testEmail])
+		})
+
+		t.Run("OnlyLogsIfGettingExistingTombstoneFails", func(t *testing.T) {
+			f, ctx := setup()
+			f.tombstones.GetErr = errors.New("test error")
+
+			err := f.agent.Remove(ctx, testEmail, ops.RemoveReasonComplaint)
+
+			assert.NilError(t, err)
+			assert.Assert(t, is.Nil(f.tombstones.Tombstones[testEmail]))
+			f.logs.AssertContains(
+				t, "ERROR recording tombstone for "+testEmail+": test error",
+			)
+		})
+	})
+
+	t.Run("DeletesTombstoneOnRejoining", func(t *testing.T) {
+		t.Run("Verify", func(t *testing.T) {
+			f, ctx := setup()
+			sub := *pendingSubscriber
+			assert.NilError(t, f.db.Put(ctx, &sub))
+			putTombstone(t, f, db.TombstoneUnsubscribe, time.Hour)
+
+			result, err := f.agent.Verify(ctx, testEmail, sub.Uid)
+
+			assert.NilError(t, err)
+			assert.Equal(t, ops.Subscribed, result)
+			assert.Assert(t, is.Nil(f.tombstones.Tombstones[testEmail]))
+		})
+
+		t.Run("Import", func(t *testing.T) {
+			f, ctx := setup()
+			putTombstone(t, f, db.TombstoneUnsubscribe, time.Hour)
+
+			err := f.agent.Import(ctx, testEmail, nil)
+
+			assert.NilError(t, err)
+			assert.Assert(t, is.Nil(f.tombstones.Tombstones[testEmail]))
+		})
+
+		t.Run("Restore", func(t *testing.T) {
+			f, ctx := setup()
+			putTombstone(t, f, db.TombstoneComplaint, time.Hour)
+
+			err := f.agent.Restore(ctx, testEmail)
+
+			assert.NilError(t, err)
+			assert.Assert(t, is.Nil(f.tombstones.Tombstones[testEmail]))
+		})
+
+		t.Run("OnlyLogsIfDeletingFails", func(t *testing.T) {
+			f, ctx := setup()
+			f.tombstones.DeleteErr = errors.New("test error")
+
+			err := f.agent.Import(ctx, testEmail, nil)
+
+			assert.NilError(t, err)
+			f.logs.AssertContains(
+				t, "ERROR deleting tombstone for "+testEmail+": test error",
+			)
+		})
+	})
+
+	t.Run("Subscribe", func(t *testing.T) {
+		setupSubscribe := func() (*prodAgentTestFixture, context.Context) {
+			f, ctx := setup()
+			f.agent.TombstonePolicy.Subscribe = 24 * time.Hour
+			return f, ctx
+		}
+
+		t.Run("IgnoresTombstoneIfNoCooldown", func(t *testing.T) {
+			f, ctx := setup()
+			putTombstone(t, f, db.TombstoneUnsubscribe, time.Minute)
+			f.tombstones.GetErr = errors.New("shouldn't get tombstone")
+
+			result, err := f.agent.Subscribe(ctx, testEmail, nil, nil, nil)
+
+			assert.NilError(t, err)
+			assert.Equal(t, ops.VerifyLinkSent, result)
+			f.mailer.GetMessageTo(t, testEmail)
+		})
+
+		t.Run("SucceedsIfNoTombstone", func(t *testing.T) {
+			f, ctx := setupSubscribe()
+
+			result, err := f.agent.Subscribe(ctx, testEmail, nil, nil, nil)
+
+			assert.NilError(t, err)
+			assert.Equal(t, ops.VerifyLinkSent, result)
+			f.mailer.GetMessageTo(t, testEmail)
+		})
+
+		t.Run("SucceedsAfterCooldown", func(t *testing.T) {
+			f, ctx := setupSubscribe()
+			putTombstone(t, f, db.TombstoneUnsubscribe, 24*time.Hour)
+
+			result, err := f.agent.Subscribe(ctx, testEmail, nil, nil, nil)
+
+			assert.NilError(t, err)
+			assert.Equal(t, ops.VerifyLinkSent, result)
+			f.mailer.GetMessageTo(t, testEmail)
+		})
+
+		t.Run("DoesNotSubscribeDuringCooldown", func(t *testing.T) {
+			f, ctx := setupSubscribe()
+			ts := putTombstone(t, f, db.TombstoneUnsubscribe, time.Hour)
+
+			result, err := f.agent.Subscribe(ctx, testEmail, nil, nil, nil)
+
+			assert.NilError(t, err)
+			assert.Equal(t, ops.VerifyLinkSent, result)
+			assert.Assert(t, is.Nil(f.db.Index[testEmail]))
+			f.mailer.AssertNoMessageSent(t, testEmail)
+			f.logs.AssertContains(
+				t,
+				"not subscribing: address left the list: "+testEmail+
+					": unsubscribe at "+ts.Timestamp.Format(time.RFC3339),
+			)
+		})
+
+		t.Run("PassesThroughGetTombstoneError", func(t *testing.T) {
+			f, ctx := setupSubscribe()
+			f.tombstones.GetErr = makeServerError("test error")
+
+			result, err := f.agent.Subscribe(ctx, testEmail, nil, nil, nil)
+
+			assert.Equal(t, ops.Invalid, result)
+			assertServerErrorContains(t, err, "test error")
+			f.mailer.AssertNoMessageSent(t, testEmail)
+		})
+	})
+
+	t.Run("Import", func(t *testing.T) {
+		t.Run("FailsIfTombstoneIsForever", func(t *testing.T) {
+			f, ctx := setup()
+			f.agent.TombstonePolicy.Import = TombstoneForever
+			putTombstone(t, f, db.TombstoneUnsubscribe, 10*365*24*time.Hour)
+
+			err := f.agent.Import(ctx, testEmail, nil)
+
+			assert.Assert(t, tu.ErrorIs(err, ErrAddressLeftList))
+			assert.ErrorContains(t, err, testEmail+": unsubscribe at ")
+			assert.Assert(t, is.Nil(f.db.Index[testEmail]))
+		})
+	})
+
+	t.Run("Restore", func(t *testing.T) {
+		setupRestore := func() (*prodAgentTestFixture, context.Context) {
+			f, ctx := setup()
+			f.agent.TombstonePolicy.Restore = TombstoneForever
+			return f, ctx
+		}
+
+		t.Run("FailsIfUnsubscribed", func(t *testing.T) {
+			f, ctx := setupRestore()
+			putTombstone(t, f, db.TombstoneUnsubscribe, time.Hour)
+
+			err := f.agent.Restore(ctx, testEmail)
+
+			assert.Assert(t, tu.ErrorIs(err, ErrAddressLeftList))
+			assert.Assert(t, is.Nil(f.db.Index[testEmail]))
+		})
+
+		t.Run("SucceedsIfRemovedDueToComplaint", func(t *testing.T) {
+			f, ctx := setupRestore()
+			putTombstone(t, f, db.TombstoneComplaint, time.Hour)
+
+			err := f.agent.Restore(ctx, testEmail)
+
+			assert.NilError(t, err)
+			assert.Assert(t, f.db.Index[testEmail] != nil)
+		})
+	})
+}
+
+func TestHistory(t *testing.T) {
+	newEvent := func(op db.AuditOperation, ts time.Time) *db.AuditEvent {
+		return &db.AuditEvent{Email: testEmail, Operation: op, Timestamp: ts}
+	}
+
+	t.Run("ReturnsOldestEventsFirst", func(t *testing.T) {
+		f := newProdAgentTestFixture()
+		events := []*db.AuditEvent{
+			newEvent(db.AuditVerify, td.TestTimestamp.Add(time.Minute)),
+			newEvent(db.AuditSubscribe, td.TestTimestamp),
+			newEvent(db.AuditUnsubscribe, td.TestTimestamp.Add(time.Hour)),
+			{Email: "foo@test.com", Operation: db.AuditImport},
+		}
+		f.audit.Events = events
+
+		result, err := f.agent.History(context.Background(), testEmail)
+
+		assert.NilError(t, err)
+		expected := []*db.AuditEvent{events[1], events[0], events[2]}
+		assert.DeepEqual(t, expected, result)
+	})
+
+	t.Run("PassesThroughError", func(t *testing.T) {
+		f := newProdAgentTestFixture()
+		f.audit.GetErr = makeServerError("audit log unavailable")
+
+		result, err := f.agent.History(context.Background(), testEmail)
+
+		assert.Assert(t, is.Nil(result))
+		assertServerErrorContains(t, err, "audit log unavailable")
+	})
+}
+
+func TestExportSubscriber(t *testing.T) {
+	setup := func() (*prodAgentTestFixture, context.Context) {
+		f := newProdAgentTestFixture()
+		f.agent.ErasureSalt = testErasureSalt
+		return f, context.Background()
+	}
+
+	t.Run("ReturnsEmptyDataIfNothingStored", func(t *testing.T) {
+		f, ctx := setup()
+
+		data, err := f.agent.ExportSubscriber(ctx, testEmail)
+
+		assert.NilError(t, err)
+		expected := &SubscriberData{
+			Email: testEmail, History: []*db.AuditEvent{},
+		}
+		assert.DeepEqual(t, expected, data)
+	})
+
+	t.Run("IncludesListName", func(t *testing.T) {
+		f, ctx := setup()
+		f.agent.List = "updates"
+
+		data, err := f.agent.ExportSubscriber(ctx, testEmail)
+
+		assert.NilError(t, err)
+		assert.Equal(t, "updates", data.List)
+	})
+
+	t.Run("ReturnsEverythingStored", func(t *testing.T) {
+		f, ctx := setup()
+		assert.NilError(t, f.db.Put(ctx, verifiedSubscriber))
+		tombstone := &db.Tombstone{
+			Email:     testEmail,
+			Reason:    db.TombstoneBounce,
+			Timestamp: td.TestTimestamp,
+		}
+		assert.NilError(t, f.tombstones.PutTombstone(ctx, tombstone))
+		f.suppressor.Addresses[testEmail] = ops.RemoveReasonBounce
+		f.erasures.Hashes[f.agent.erasureHash(testEmail)] = true
+		event := &db.AuditEvent{
+			Email:     testEmail,
+			Operation: db.AuditRemove,
+			Reason:    ops.RemoveReasonBounce,
+			Timestamp: td.TestTimestamp,
+		}
+		f.audit.Events = []*db.AuditEvent{event}
+
+		data, err := f.agent.ExportSubscriber(ctx, testEmail)
+
+		assert.NilError(t, err)
+		expected := &SubscriberData{
+			Email:      testEmail,
+			Subscriber: verifiedSubscriber,
+			Tombstone:  tombstone,
+			Suppressed: true,
+			Erased:     true,
+			History:    []*db.AuditEvent{event},
+		}
+		assert.DeepEqual(t, expected, data)
+	})
+
+	t.Run("DoesNotCheckErasuresWithoutSalt", func(t *testing.T) {
+		f, ctx := setup()
+		f.agent.ErasureSalt = ""
+		f.erasures.GetErr = makeServerError("should not be called")
+
+		data, err := f.agent.ExportSubscriber(ctx, testEmail)
+
+		assert.NilError(t, err)
+		assert.Equal(t, false, data.Erased)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		t.Run("IfGettingSubscriberFails", func(t *testing.T) {
+			f, ctx := setup()
+			f.db.SimulateGetErr = func(_ string) error {
+				return makeServerError("get subscriber failed")
+			}
+
+			data, err := f.agent.ExportSubscriber(ctx, testEmail)
+
+			assert.Assert(t, is.Nil(data))
+			assertServerErrorContains(t, err, "get subscriber failed")
+		})
+
+		t.Run("IfGettingTombstoneFails", func(t *testing.T) {
+			f, ctx := setup()
+			f.tombstones.GetErr = makeServerError("get tombstone failed")
+
+			data, err := f.agent.ExportSubscriber(ctx, testEmail)
+
+			assert.Assert(t, is.Nil(data))
+			assertServerErrorContains(t, err, "get tombstone failed")
+		})
+
+		t.Run("IfCheckingSuppressionFails", func(t *testing.T) {
+			f, ctx := setup()
+			f.suppressor.Errors[testEmail] = makeServerError("suppressor down")
+
+			data, err := f.agent.ExportSubscriber(ctx, testEmail)
+
+			assert.Assert(t, is.Nil(data))
+			assertServerErrorContains(t, err, "suppressor down")
+		})
+
+		t.Run("IfCheckingErasureFails", func(t *testing.T) {
+			f, ctx := setup()
+			f.erasures.GetErr = makeServerError("get erasure failed")
+
+			data, err := f.agent.ExportSubscriber(ctx, testEmail)
+
+			assert.Assert(t, is.Nil(data))
+			assertServerErrorContains(t, err, "get erasure failed")
+		})
+
+		t.Run("IfGettingHistoryFails", func(t *testing.T) {
+			f, ctx := setup()
+			f.audit.GetErr = makeServerError("get history failed")
+
+			data, err := f.agent.ExportSubscriber(ctx, testEmail)
+
+			assert.Assert(t, is.Nil(data))
+			assertServerErrorContains(t, err, "get history failed")
+		})
+	})
+}
+
+func TestErase(t *testing.T) {
+	setup := func() (*prodAgentTestFixture, context.Context) {
+		f := newProdAgentTestFixture()
+		f.agent.ErasureSalt = testErasureSalt
+		ctx := context.Background()
+		tombstone := &db.Tombstone{
+			Email:     testEmail,
+			Reason:    db.TombstoneComplaint,
+			Timestamp: td.TestTimestamp,
+		}
+
+		assert.NilError(t, f.db.Put(ctx, verifiedSubscriber))
+		assert.NilError(t, f.db.MarkReceived(ctx, testEmail, "campaign-0"))
+		assert.NilError(t, f.tombstones.PutTombstone(ctx, tombstone))
+		f.suppressor.Addresses[testEmail] = ops.RemoveReasonComplaint
+		f.audit.Events = []*db.AuditEvent{
+			{Email: testEmail, Operation: db.AuditImport},
+			{Email: "foo@test.com", Operation: db.AuditImport},
+		}
+		return f, ctx
+	}
+
+	t.Run("DeletesEverythingButTheHash", func(t *testing.T) {
+		f, ctx := setup()
+
+		err := f.agent.Erase(ctx, testEmail)
+
+		assert.NilError(t, err)
+		assert.Assert(t, is.Nil(f.db.Index[testEmail]))
+		assert.Assert(t, is.Nil(f.db.Receipts[testEmail]))
+		assert.Assert(t, is.Nil(f.tombstones.Tombstones[testEmail]))
+		assert.Equal(
+			t, ops.RemoveReasonComplaint, f.suppressor.Addresses[testEmail],
+		)
+		expectedEvents := []*db.AuditEvent{
+			{Email: "foo@test.com", Operation: db.AuditImport},
+		}
+		assert.DeepEqual(t, expectedEvents, f.audit.Events)
+		hash := f.agent.erasureHash(testEmail)
+		assert.DeepEqual(t, map[string]bool{hash: true}, f.erasures.Hashes)
+		assert.Assert(t, !strings.Contains(hash, testEmail))
+	})
+
+	t.Run("SucceedsIfNothingStored", func(t *testing.T) {
+		f, ctx := setup()
+
+		err := f.agent.Erase(ctx, "foo@test.com")
+
+		assert.NilError(t, err)
+		hash := f.agent.erasureHash("foo@test.com")
+		assert.DeepEqual(t, map[string]bool{hash: true}, f.erasures.Hashes)
+		assert.Assert(t, f.db.Index[testEmail] != nil)
+	})
+
+	t.Run("HashIgnoresCase", func(t *testing.T) {
+		f, _ := setup()
+
+		assert.Equal(
+			t,
+			f.agent.erasureHash(testEmail),
+			f.agent.erasureHash(strings.ToUpper(testEmail)),
+		)
+	})
+
+	t.Run("HashDependsOnSalt", func(t *testing.T) {
+		f, _ := setup()
+		hash := f.agent.erasureHash(testEmail)
+
+		f.agent.ErasureSalt = "different salt"
+
+		assert.Assert(t, hash != f.agent.erasureHash(testEmail))
+	})
+
+	t.Run("ImportRefusesErasedAddress", func(t *testing.T) {
+		f, ctx := setup()
+		assert.NilError(t, f.agent.Erase(ctx, testEmail))
+
+		err := f.agent.Import(ctx, strings.ToUpper(testEmail), nil)
+
+		assert.Assert(t, tu.ErrorIs(err, ErrAddressErased))
+		assert.Equal(t, 0, len(f.db.Index))
+	})
+
+	t.Run("RestoreRefusesErasedAddress", func(t *testing.T) {
+		f, ctx := setup()
+		assert.NilError(t, f.agent.Erase(ctx, testEmail))
+
+		err := f.agent.Restore(ctx, testEmail)
+
+		assert.Assert(t, tu.ErrorIs(err, ErrAddressErased))
+		assert.Equal(t, 0, len(f.db.Index))
+		assert.Equal(
+			t, ops.RemoveReasonComplaint, f.suppressor.Addresses[testEmail],
+		)
+	})
+
+	t.Run("SubscribeAcceptsErasedAddress", func(t *testing.T) {
+		f, ctx := setup()
+		assert.NilError(t, f.agent.Erase(ctx, testEmail))
+
+		result, err := f.agent.Subscribe(ctx, testEmail, nil, nil, nil)
+
+		assert.NilError(t, err)
+		assert.Equal(t, ops.VerifyLinkSent, result)
+		assert.Equal(t, db.SubscriberPending, f.db.Index[testEmail].Status)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		t.Run("IfNoSalt", func(t *testing.T) {
+			f, ctx := setup()
+			f.agent.ErasureSalt = ""
+
+			err := f.agent.Erase(ctx, testEmail)
+
+			const expectedErr = "can't erase " + testEmail +
+				": no erasure salt set"
+			assert.Error(t, err, expectedErr)
+			assert.Assert(t, f.db.Index[testEmail] != nil)
+		})
+
+		t.Run("IfSavingHashFails", func(t *testing.T) {
+			f, ctx := setup()
+			f.erasures.PutErr = makeServerError("put erasure failed")
+
+			err := f.agent.Erase(ctx, testEmail)
+
+			assertServerErrorContains(t, err, "put erasure failed")
+			assert.Assert(t, f.db.Index[testEmail] != nil)
+		})
+
+		t.Run("IfDeletingSubscriberFails", func(t *testing.T) {
+			f, ctx := setup()
+			f.db.SimulateDelErr = func(_ string) error {
+				return makeServerError("delete subscriber failed")
+			}
+
+			err := f.agent.Erase(ctx, testEmail)
+
+			assertServerErrorContains(t, err, "delete subscriber failed")
+			assert.Assert(t, f.tombstones.Tombstones[testEmail] != nil)
+		})
+
+		t.Run("IfDeletingTombstoneFails", func(t *testing.T) {
+			f, ctx := setup()
+			f.tombstones.DeleteErr = makeServerError("delete tombstone failed")
+
+			err := f.agent.Erase(ctx, testEmail)
+
+			assertServerErrorContains(t, err, "delete tombstone failed")
+			assert.Equal(t, 2, len(f.audit.Events))
+		})
+
+		t.Run("IfDeletingHistoryFails", func(t *testing.T) {
+			f, ctx := setup()
+			f.audit.DeleteErr = makeServerError("delete history failed")
+
+			err := f.agent.Erase(ctx, testEmail)
+
+			assertServerErrorContains(t, err, "delete history failed")
+			assert.Assert(t, is.Nil(f.tombstones.Tombstones[testEmail]))
+		})
+	})
+}
+
+func TestWelcomeMessage(t *testing.T) {
+	msg := testMessage()
+	msg.Subject = "Welcome!"
+
+	t.Run("SetAndGetSucceed", func(t *testing.T) {
+		f := newProdAgentTestFixture()
+		ctx := context.Background()
+
+		setErr := f.agent.SetWelcomeMessage(ctx, msg)
+		got, getErr := f.agent.GetWelcomeMessage(ctx)
+
+		assert.NilError(t, setErr)
+		assert.NilError(t, getErr)
+		assert.DeepEqual(t, msg, got)
+	})
+
+	t.Run("GetReturnsNilIfNoWelcomeMessage", func(t *testing.T) {
+		f := newProdAgentTestFixture()
+
+		got, err := f.agent.GetWelcomeMessage(context.Background())
+
+		assert.NilError(t, err)
+		assert.Assert(t, is.Nil(got))
+	})
+
+	t.Run("GetFailsIfStoreFails", func(t *testing.T) {
+		f := newProdAgentTestFixture()
+		getErr := errors.New("GetWelcomeMessage failed")
+		f.welcome.GetErr = getErr
+
+		got, err := f.agent.GetWelcomeMessage(context.Background())
+
+		assert.Assert(t, tu.ErrorIs(err, getErr))
+		assert.Assert(t, is.Nil(got))
+	})
+
+	t.Run("SetNilDeletesWelcomeMessage", func(t *testing.T) {
+		f := newProdAgentTestFixture()
+		f.welcome.Message = msg
+
+		err := f.agent.SetWelcomeMessage(context.Background(), nil)
+
+		assert.NilError(t, err)
+		assert.Assert(t, is.Nil(f.welcome.Message))
+	})
+
+	t.Run("SetFailsIfMessageFailsValidation", func(t *testing.T) {
+		f := newProdAgentTestFixture()
+		badMsg := *msg
+		badMsg.From = "Blog Updates <updates@bar.com>"
+
+		err := f.agent.SetWelcomeMessage(context.Background(), &badMsg)
+
+		const expectedErr = "domain of From address is not " + testDomainName
+		assert.ErrorContains(t, err, expectedErr)
+		assert.Assert(t, is.Nil(f.welcome.Message))
+	})
+
+	t.Run("SetFailsIfPutFails", func(t *testing.T) {
+		f := newProdAgentTestFixture()
+		putErr := errors.New("PutWelcomeMessage failed")
+		f.welcome.PutErr = putErr
+
+		err := f.agent.SetWelcomeMessage(context.Background(), msg)
+
+		assert.Assert(t, tu.ErrorIs(err, putErr))
+	})
+
+	t.Run("SetNilFailsIfDeleteFails", func(t *testing.T) {
+		f := newProdAgentTestFixture()
+		deleteErr := errors.New("DeleteWelcomeMessage failed")
+		f.welcome.DeleteErr = deleteErr
+
+		err := f.agent.SetWelcomeMessage(context.Background(), nil)
+
+		assert.Assert(t, tu.ErrorIs(err, deleteErr))
+	})
+}
+
+func TestRotateUids(t *testing.T) {
+	const gracePeriod = 30 * 24 * time.Hour
+	expires := td.TestTimestamp.Add(gracePeriod)
+
+	newUid := func(n int) uuid.UUID {
+		const uidFmt = "%08d-0000-4000-8000-000000000000"
+		return uuid.MustParse(fmt.Sprintf(uidFmt, n))
+	}
+
+	setup := func() (*prodAgentTestFixture, context.Context) {
+		f := newProdAgentTestFixture()
+		f.setupTestSubscribers()
+		numUids := 0
+		f.agent.NewUid = func() (uuid.UUID, error) {
+			numUids++
+			return newUid(numUids), nil
+		}
+		return f, context.Background()
+	}
+
+	assertRotated := func(
+		t *testing.T, f *prodAgentTestFixture, email string, n int,
+	) {
+		t.Helper()
+		var original *db.Subscriber
+		for _, sub := range db.TestSubscribers {
+			if sub.Email == email {
+				original = sub
+			}
+		}
+		sub := f.db.Index[email]
+		assert.Equal(t, newUid(n), sub.Uid)
+		assert.Equal(t, original.Uid, sub.PreviousUid)
+		assert.Equal(t, expires, sub.PreviousUidExpires)
+	}
+
+	t.Run("RotatesEveryVerifiedSubscriber", func(t *testing.T) {
+		f, ctx := setup()
+
+		numRotated, nextKey, err := f.agent.RotateUids(
+			ctx, gracePeriod, nil, 10,
+		)
+
+		assert.NilError(t, err)
+		assert.Equal(t, 3, numRotated)
+		assert.Assert(t, is.Nil(nextKey))
+		assertRotated(t, f, "foo@test.com", 1)
+		assertRotated(t, f, "bar@test.com", 2)
+		assertRotated(t, f, "baz@test.com", 3)
+		pending := f.db.Index["quux@test.com"]
+		assert.Equal(t, db.TestSubscribers[1], pending)
+	})
+
+	t.Run("RotatesInBatches", func(t *testing.T) {
+		f, ctx := setup()
+
+		numRotated, nextKey, err := f.agent.RotateUids(
+			ctx, gracePeriod, nil, 2,
+		)
+
+		assert.NilError(t, err)
+		assert.Equal(t, 2, numRotated)
+		assert.DeepEqual(t, f.db.Index["bar@test.com"].ScanKey(), nextKey)
+
+		numRotated, nextKey, err = f.agent.RotateUids(
+			ctx, gracePeriod, nextKey, 2,
+		)
+
+		assert.NilError(t, err)
+		assert.Equal(t, 1, numRotated)
+		assert.Assert(t, is.Nil(nextKey))
+		assertRotated(t, f, "baz@test.com", 3)
+	})
+
+	t.Run("StopsBeforeDeadline", func(t *testing.T) {
+		f, _ := setup()
+		deadline := td.TestTimestamp.Add(time.Hour)
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		defer cancel()
+		numCalls := 0
+		f.agent.CurrentTime = func() time.Time {
+			// Compute expiry and rotate the first subscriber, then stop.
+			if numCalls++; numCalls <= 2 {
+				return td.TestTimestamp
+			}
+			return deadline.Add(-sendDeadlineMargin)
+		}
+
+		numRotated, nextKey, err := f.agent.RotateUids(
+			ctx, gracePeriod, nil, 10,
+		)
+
+		assert.NilError(t, err)
+		assert.Equal(t, 1, numRotated)
+		assert.DeepEqual(t, f.db.Index["foo@test.com"].ScanKey(), nextKey)
+		assert.Equal(t, db.TestSubscribers[2], f.db.Index["bar@test.com"])
+	})
+
+	t.Run("SkipsSubscribersWhoLeft", func(t *testing.T) {
+		f, ctx := setup()
+		f.db.SimulateRotateErr = func(address string) error {
+			if address == "bar@test.com" {
+				return db.ErrSubscriberNotFound
+			}
+			return nil
+		}
+
+		numRotated, nextKey, err := f.agent.RotateUids(
+			ctx, gracePeriod, nil, 10,
+		)
+
+		assert.NilError(t, err)
+		assert.Equal(t, 2, numRotated)
+		assert.Assert(t, is.Nil(nextKey))
+		assertRotated(t, f, "baz@test.com", 3)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		t.Run("IfBatchSizeNotPositive", func(t *testing.T) {
+			f, ctx := setup()
+
+			_, _, err := f.agent.RotateUids(ctx, gracePeriod, nil, 0)
+
+			assert.Error(t, err, "batch size must be positive, got 0")
+		})
+
+		t.Run("IfGracePeriodNegative", func(t *testing.T) {
+			f, ctx := setup()
+
+			_, _, err := f.agent.RotateUids(ctx, -time.Hour, nil, 10)
+
+			assert.Error(t, err, "grace period is negative: -1h0m0s")
+		})
+
+		t.Run("IfDeadlineApproachingBeforeFirstSubscriber", func(t *testing.T) {
+			f, _ := setup()
+			deadline := time.Now().Add(time.Hour)
+			ctx, cancel := context.WithDeadline(context.Background(), deadline)
+			defer cancel()
+			f.agent.CurrentTime = func() time.Time {
+				return deadline.Add(-sendDeadlineMargin)
+			}
+
+			numRotated, nextKey, err := f.agent.RotateUids(
+				ctx, gracePeriod, nil, 10,
+			)
+
+			assert.Assert(t, tu.ErrorIs(err, ErrSendDeadlineApproaching))
+			assert.Equal(t, 0, numRotated)
+			assert.Assert(t, is.Nil(nextKey))
+		})
+
+		t.Run("IfNewUidFails", func(t *testing.T) {
+			f, ctx := setup()
+			f.agent.NewUid = func() (uuid.UUID, error) {
+				return uuid.Nil, errors.New("no entropy")
+			}
+
+			numRotated, nextKey, err := f.agent.RotateUids(
+				ctx, gracePeriod, nil, 10,
+			)
+
+			assert.Error(t, err, "failed to rotate UIDs: no entropy")
+			assert.Equal(t, 0, numRotated)
+			assert.Assert(t, is.Nil(nextKey))
+		})
+
+		t.Run("IfRotateUidFails", func(t *testing.T) {
+			f, ctx := setup()
+			f.db.SimulateRotateErr = func(address string) error {
+				if address == "bar@test.com" {
+					return makeServerError("rotate failed for " + address)
+				}
+				return nil
+			}
+
+			numRotated, nextKey, err := f.agent.RotateUids(
+				ctx, gracePeriod, nil, 10,
+			)
+
+			assertServerErrorContains(t, err, "rotate failed for bar@test.com")
+			assert.Equal(t, 1, numRotated)
+			assert.DeepEqual(t, f.db.Index["foo@test.com"].ScanKey(), nextKey)
+		})
+	})
+}
+
+func TestStatusTime(t *testing.T) {
+	t.Run("VerifiedReturnsTimestamp", func(t *testing.T) {
+		sub := &db.Subscriber{
+			Status: db.SubscriberVerified, Timestamp: td.TestTimestamp,
+		}
+
+		assert.Equal(t, td.TestTimestamp, StatusTime(sub))
+	})
+
+	t.Run("PendingReturnsTimestampMinusTimeToLive", func(t *testing.T) {
+		sub := &db.Subscriber{
+			Status:    db.SubscriberPending,
+			Timestamp: td.TestTimestamp.Add(timeToLiveDuration),
+		}
+
+		assert.Equal(t, td.TestTimestamp, StatusTime(sub))
+	})
+}
+
+func TestListSubscribers(t *testing.T) {
+	setup := func() (*prodAgentTestFixture, context.Context) {
+		f := newProdAgentTestFixture()
+		f.setupTestSubscribers()
+		return f, context.Background()
+	}
+
+	emails := func(subs []*db.Subscriber) []string {
+		result := make([]string, 0, len(subs))
+		for _, sub := range subs {
+			result = append(result, sub.Email)
+		}
+		return result
+	}
+
+	t.Run("ListsEverySubscriberWithStatus", func(t *testing.T) {
+		f, ctx := setup()
+
+		subs, nextKey, err := f.agent.ListSubscribers(
+			ctx, db.SubscriberVerified, time.Time{}, "", nil, 10,
+		)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, db.TestVerifiedSubscribers, subs)
+		assert.Assert(t, is.Nil(nextKey))
+	})
+
+	t.Run("ListsInBatches", func(t *testing.T) {
+		f, ctx := setup()
+
+		subs, nextKey, err := f.agent.ListSubscribers(
+			ctx, db.SubscriberVerified, time.Time{}, "", nil, 2,
+		)
+
+		assert.NilError(t, err)
+		expected := []string{"foo@test.com", "bar@test.com"}
+		assert.DeepEqual(t, expected, emails(subs))
+		assert.DeepEqual(t, f.db.Index["bar@test.com"].ScanKey(), nextKey)
+
+		subs, nextKey, err = f.agent.ListSubscribers(
+			ctx, db.SubscriberVerified, time.Time{}, "", nextKey, 2,
+		)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, []string{"baz@test.com"}, emails(subs))
+		assert.Assert(t, is.Nil(nextKey))
+	})
+
+	t.Run("SkipsSubscribersWhoseStatusChangedBeforeSince", func(t *testing.T) {
+		f, ctx := setup()
+		since := td.TestTimestamp.Add(48 * time.Hour)
+
+		subs, _, err := f.agent.ListSubscribers(
+			ctx, db.SubscriberPending, since, "", nil, 10,
+		)
+
+		assert.NilError(t, err)
+		expected := []string{"xyzzy@test.com", "plugh@test.com"}
+		assert.DeepEqual(t, expected, emails(subs))
+	})
+
+	t.Run("SkipsSubscribersOutsideDomain", func(t *testing.T) {
+		f, ctx := setup()
+		corge := &db.Subscriber{
+			Email:     "Corge@Example.com",
+			Uid:       td.TestUid,
+			Status:    db.SubscriberVerified,
+			Timestamp: td.TestTimestamp,
+		}
+		assert.NilError(t, f.db.Put(ctx, corge))
+
+		subs, _, err := f.agent.ListSubscribers(
+			ctx, db.SubscriberVerified, time.Time{}, "example.COM", nil, 10,
+		)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, []*db.Subscriber{corge}, subs)
+	})
+
+	t.Run("StopsBeforeDeadline", func(t *testing.T) {
+		f, _ := setup()
+		deadline := td.TestTimestamp.Add(time.Hour)
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		defer cancel()
+		numCalls := 0
+		f.agent.CurrentTime = func() time.Time {
+			// List the first subscriber, then stop.
+			if numCalls++; numCalls <= 1 {
+				return td.TestTimestamp
+			}
+			return deadline.Add(-sendDeadlineMargin)
+		}
+
+		subs, nextKey, err := f.agent.ListSubscribers(
+			ctx, db.SubscriberVerified, time.Time{}, "", nil, 10,
+		)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, []string{"foo@test.com"}, emails(subs))
+		assert.DeepEqual(t, f.db.Index["foo@test.com"].ScanKey(), nextKey)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		t.Run("IfLimitNotPositive", func(t *testing.T) {
+			f, ctx := setup()
+
+			_, _, err := f.agent.ListSubscribers(
+				ctx, db.SubscriberVerified, time.Time{}, "", nil, 0,
+			)
+
+			assert.Error(t, err, "limit must be positive, got 0")
+		})
+
+		t.Run("IfStatusInvalid", func(t *testing.T) {
+			f, ctx := setup()
+
+			_, _, err := f.agent.ListSubscribers(
+				ctx, "bogus", time.Time{}, "", nil, 10,
+			)
+
+			assert.Error(t, err, `invalid subscriber status: "bogus"`)
+		})
+
+		t.Run("IfDeadlineApproachingBeforeFirstSubscriber", func(t *testing.T) {
+			f, _ := setup()
+			deadline := time.Now().Add(time.Hour)
+			ctx, cancel := context.WithDeadline(context.Background(), deadline)
+			defer cancel()
+			f.agent.CurrentTime = func() time.Time {
+				return deadline.Add(-sendDeadlineMargin)
+			}
+
+			subs, nextKey, err := f.agent.ListSubscribers(
+				ctx, db.SubscriberVerified, time.Time{}, "", nil, 10,
+			)
+
+			assert.Assert(t, tu.ErrorIs(err, ErrSendDeadlineApproaching))
+			assert.Equal(t, 0, len(subs))
+			assert.Assert(t, is.Nil(nextKey))
+		})
+
+		t.Run("IfProcessingSubscribersFails", func(t *testing.T) {
+			f, ctx := setup()
+			f.db.SimulateProcSubsErr = func(address string) error {
+				if address == "bar@test.com" {
+					return makeServerError("scan failed at " + address)
+				}
+				return nil
+			}
+
+			subs, nextKey, err := f.agent.ListSubscribers(
+				ctx, db.SubscriberVerified, time.Time{}, "", nil, 10,
+			)
+
+			assertServerErrorContains(t, err, "scan failed at bar@test.com")
+			assert.ErrorContains(t, err, "failed to list verified subscribers")
+			assert.DeepEqual(t, []string{"foo@test.com"}, emails(subs))
+			assert.DeepEqual(t, f.db.Index["foo@test.com"].ScanKey(), nextKey)
+		})
+	})
+}
+
+func TestSchedule(t *testing.T) {
+	msg := testMessage()
+	sendAt := td.TestTimestamp.Add(24 * time.Hour)
+
+	t.Run("Succeeds", func(t *testing.T) {
+		f := newProdAgentTestFixture()
+
+		id, err := f.agent.Schedule(context.Background(), msg, sendAt)
+
+		assert.NilError(t, err)
+		assert.Equal(t, td.TestUid.String(), id)
+		expected := &db.ScheduledMessage{Id: id, SendAt: sendAt, Message: msg}
+		assert.DeepEqual(t, expected, f.schedules.Messages[id])
+	})
+
+	t.Run("FailsIfMessageFailsValidation", func(t *testing.T) {
+		f := newProdAgentTestFixture()
+		badMsg := *msg
+		badMsg.From = "Blog Updates <updates@bar.com>"
+
+		id, err := f.agent.Schedule(context.Background(), &badMsg, sendAt)
+
+		const expectedErr = "domain of From address is not " + testDomainName
+		assert.ErrorContains(t, err, expectedErr)
+		assert.Equal(t, "", id)
+		assert.Equal(t, 0, len(f.schedules.Messages))
+	})
+
+	t.Run("FailsIfSendTimeIsNotInTheFuture", func(t *testing.T) {
+		f := newProdAgentTestFixture()
+
+		id, err := f.agent.Schedule(
+			context.Background(), msg, td.TestTimestamp,
+		)
+
+		expectedErr := "send time " + td.TestTimestamp.Format(time.RFC3339) +
+			" isn't in the future"
+		assert.Error(t, err, expectedErr)
+		assert.Equal(t, "", id)
+	})
+
+	t.Run("FailsIfNewUidFails", func(t *testing.T) {
+		f := newProdAgentTestFixture()
+		f.agent.NewUid = func() (uuid.UUID, error) {
+			return uuid.Nil, errors.New("NewUid failed")
+		}
+
+		id, err := f.agent.Schedule(context.Background(), msg, sendAt)
+
+		const expectedErr = "couldn't create scheduled message ID: " +
+			"NewUid failed"
+		assert.Error(t, err, expectedErr)
+		assert.Equal(t, "", id)
+	})
+
+	t.Run("FailsIfPutScheduledMessageFails", func(t *testing.T) {
+		f := newProdAgentTestFixture()
+		putErr := errors.New("PutScheduledMessage failed")
+		f.schedules.PutErr = putErr
+
+		id, err := f.agent.Schedule(context.Background(), msg, sendAt)
+
+		assert.Assert(t, tu.ErrorIs(err, putErr))
+		assert.Equal(t, "", id)
+	})
+}
+
+func TestSendScheduled(t *testing.T) {
+	msg := testMessage()
+	subs := db.TestVerifiedSubscribers
+
+	setup := func() (*prodAgentTestFixture, context.Context) {
+		f := newProdAgentTestFixture()
+		f.setupTestSubscribers()
+		return f, context.Background()
+	}
+
+	schedule := func(f *prodAgentTestFixture, id string, sendAt time.Time) {
+		f.schedules.Messages[id] = &db.ScheduledMessage{
+			Id: id, SendAt: sendAt, Message: msg,
+		}
+	}
+
+	t.Run("SendsDueMessagesOnly", func(t *testing.T) {
+		f, ctx := setup()
+		schedule(f, "due", td.TestTimestamp.Add(-time.Minute))
+		schedule(f, "not-yet-due", td.TestTimestamp.Add(time.Minute))
+
+		numSent, err := f.agent.SendScheduled(ctx)
+
+		assert.NilError(t, err)
+		assert.Equal(t, len(subs), numSent)
+		assertSentToVerifiedSubscribers(t, msg.Subject, f.mailer, f.logs)
+		_, dueExists := f.schedules.Messages["due"]
+		_, notYetDueExists := f.schedules.Messages["not-yet-due"]
+		assert.Assert(t, !dueExists)
+		assert.Assert(t, notYetDueExists)
+		assert.Equal(t, 1, len(f.campaigns.Campaigns))
+	})
+
+	t.Run("SendsDueMessageOnceTheClockAdvances", func(t *testing.T) {
+		f, ctx := setup()
+		sendAt := td.TestTimestamp.Add(time.Hour)
+		schedule(f, "scheduled", sendAt)
+
+		numSent, err := f.agent.SendScheduled(ctx)
+
+		assert.NilError(t, err)
+		assert.Equal(t, 0, numSent)
+		assert.Equal(t, 0, len(f.mailer.RecipientMessages))
+
+		f.agent.CurrentTime = func() time.Time {
+			return sendAt
+		}
+
+		numSent, err = f.agent.SendScheduled(ctx)
+
+		assert.NilError(t, err)
+		assert.Equal(t, len(subs), numSent)
+		assert.Equal(t, 0, len(f.schedules.Messages))
+	})
+
+	t.Run("DoesNothingIfNoMessagesAreDue", func(t *testing.T) {
+		f, ctx := setup()
+
+		numSent, err := f.agent.SendScheduled(ctx)
+
+		assert.NilError(t, err)
+		assert.Equal(t, 0, numSent)
+		assert.Equal(t, 0, len(f.campaigns.Campaigns))
+	})
+
+	t.Run("FailsIfGetDueMessagesFails", func(t *testing.T) {
+		f, ctx := setup()
+		getErr := errors.New("GetDueMessages failed")
+		f.schedules.GetDueErr = getErr
+
+		numSent, err := f.agent.SendScheduled(ctx)
+
+		assert.ErrorContains(t, err, "couldn't get scheduled messages: ")
+		assert.Assert(t, tu.ErrorIs(err, getErr))
+		assert.Equal(t, 0, numSent)
+	})
+
+	t.Run("UsesIdAsCampaignId", func(t *testing.T) {
+		f, ctx := setup()
+		schedule(f, "due", td.TestTimestamp)
+
+		_, err := f.agent.SendScheduled(ctx)
+
+		assert.NilError(t, err)
+		campaign := f.campaigns.Campaigns["due"]
+		assert.Assert(t, campaign != nil)
+		assert.Equal(t, db.CampaignComplete, campaign.Status)
+	})
+
+	t.Run("SkipsMessagesClaimedByAnotherSender", func(t *testing.T) {
+		f, ctx := setup()
+		schedule(f, "due", td.TestTimestamp)
+		claimedUntil := td.TestTimestamp.Add(time.Minute)
+		f.schedules.Messages["due"].ClaimedUntil = claimedUntil
+
+		numSent, err := f.agent.SendScheduled(ctx)
+
+		assert.NilError(t, err)
+		assert.Equal(t, 0, numSent)
+		assert.Equal(t, 0, len(f.mailer.RecipientMessages))
+		_, exists := f.schedules.Messages["due"]
+		assert.Assert(t, exists)
+	})
+
+	t.Run("SendsMessageOnceClaimExpires", func(t *testing.T) {
+		f, ctx := setup()
+		schedule(f, "due", td.TestTimestamp.Add(-time.Hour))
+		claimedUntil := td.TestTimestamp
+		f.schedules.Messages["due"].ClaimedUntil = claimedUntil
+
+		numSent, err := f.agent.SendScheduled(ctx)
+
+		assert.NilError(t, err)
+		assert.Equal(t, len(subs), numSent)
+		assert.Equal(t, 0, len(f.schedules.Messages))
+	})
+
+	t.Run("DoesNotSendIfClaimFails", func(t *testing.T) {
+		f, ctx := setup()
+		schedule(f, "due", td.TestTimestamp)
+		claimErr := errors.New("ClaimScheduledMessage failed")
+		f.schedules.ClaimErr = claimErr
+
+		numSent, err := f.agent.SendScheduled(ctx)
+
+		assert.ErrorContains(t, err, "scheduled message due: ")
+		assert.Assert(t, tu.ErrorIs(err, claimErr))
+		assert.Equal(t, 0, numSent)
+		assert.Equal(t, 0, len(f.mailer.RecipientMessages))
+		_, exists := f.schedules.Messages["due"]
+		assert.Assert(t, exists)
+	})
+
+	t.Run("KeepsMessageIfCampaignDoesNotStart", func(t *testing.T) {
+		f, ctx := setup()
+		schedule(f, "due", td.TestTimestamp)
+		capErr := errors.New("no bulk capacity")
+		f.mailer.BulkCapError = capErr
+
+		numSent, err := f.agent.SendScheduled(ctx)
+
+		assert.ErrorContains(t, err, "scheduled message due: ")
+		assert.Assert(t, tu.ErrorIs(err, capErr))
+		assert.Equal(t, 0, numSent)
+		scheduled, exists := f.schedules.Messages["due"]
+		assert.Assert(t, exists)
+		assert.Assert(t, scheduled.ClaimedUntil.IsZero())
+	})
+
+	t.Run("KeepsClaimIfReleaseFails", func(t *testing.T) {
+		f, ctx := setup()
+		schedule(f, "due", td.TestTimestamp)
+		f.mailer.BulkCapError = errors.New("no bulk capacity")
+		releaseErr := errors.New("ReleaseScheduledMessage failed")
+		f.schedules.ReleaseErr = releaseErr
+
+		numSent, err := f.agent.SendScheduled(ctx)
+
+		assert.ErrorContains(t, err, "scheduled message due: ")
+		assert.Assert(t, tu.ErrorIs(err, releaseErr))
+		assert.Equal(t, 0, numSent)
+		expectedClaim := td.TestTimestamp.Add(scheduledSendLease)
+		scheduled := f.schedules.Messages["due"]
+		assert.Equal(t, expectedClaim, scheduled.ClaimedUntil)
+	})
+
+	t.Run("ReportsErrorIfDeleteScheduledMessageFails", func(t *testing.T) {
+		f, ctx := setup()
+		schedule(f, "due", td.TestTimestamp)
+		deleteErr := errors.New("DeleteScheduledMessage failed")
+		f.schedules.DeleteErr = deleteErr
+
+		numSent, err := f.agent.SendScheduled(ctx)
+
+		assert.ErrorContains(t, err, "scheduled message due: ")
+		assert.Assert(t, tu.ErrorIs(err, deleteErr))
+		assert.Equal(t, len(subs), numSent)
+
+		// Once the claim expires, sending again skips every subscriber.
+		f.schedules.DeleteErr = nil
+		f.agent.CurrentTime = func() time.Time {
+			return td.TestTimestamp.Add(scheduledSendLease)
+		}
+
+		numSent, err = f.agent.SendScheduled(ctx)
+
+		assert.NilError(t, err)
+		assert.Equal(t, 0, numSent)
+		assert.Equal(t, 0, len(f.schedules.Messages))
+	})
+
+	t.Run("ReportsCampaignIdIfSendIsIncomplete", func(t *testing.T) {
+		f, ctx := setup()
+		schedule(f, "due", td.TestTimestamp)
+		sendErr := errors.New("Mailer.Send failed")
+		f.mailer.RecipientErrors[subs[1].Email] = sendErr
+
+		numSent, err := f.agent.SendScheduled(ctx)
+
+		var incompleteErr *IncompleteSendError
+		assert.ErrorContains(t, err, "scheduled message due: ")
+		assert.Assert(t, errors.As(err, &incompleteErr))
+		assert.Equal(t, "due", incompleteErr.CampaignId)
+		assert.Equal(t, 1, numSent)
+		scheduled, exists := f.schedules.Messages["due"]
+		assert.Assert(t, exists)
+		assert.Assert(t, scheduled.ClaimedUntil.IsZero())
+	})
+
+	t.Run("ResumesIncompleteSendOnNextInvocation", func(t *testing.T) {
+		f, ctx := setup()
+		schedule(f, "due", td.TestTimestamp)
+		f.mailer.RecipientErrors[subs[1].Email] = errors.New("send failed")
+
+		numSent, err := f.agent.SendScheduled(ctx)
+
+		assert.ErrorContains(t, err, "scheduled message due: ")
+		assert.Equal(t, 1, numSent)
+
+		delete(f.mailer.RecipientErrors, subs[1].Email)
+		numSent, err = f.agent.SendScheduled(ctx)
+
+		assert.NilError(t, err)
+		assert.Equal(t, len(subs)-1, numSent)
+		assertSentToVerifiedSubscribers(t, msg.Subject, f.mailer, f.logs)
+		assert.Equal(t, 0, len(f.schedules.Messages))
+		assert.Equal(t, 1, len(f.campaigns.Campaigns))
+		campaign := f.campaigns.Campaigns["due"]
+		assert.Equal(t, db.CampaignComplete, campaign.Status)
+	})
+
+	t.Run("StopsBeforeDeadline", func(t *testing.T) {
+		f, _ := setup()
+		schedule(f, "first", td.TestTimestamp.Add(-time.Minute))
+		schedule(f, "second", td.TestTimestamp)
+		deadline := td.TestTimestamp.Add(time.Hour)
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		defer cancel()
+		f.agent.CurrentTime = func() time.Time {
+			return deadline.Add(-sendDeadlineMargin)
+		}
+
+		numSent, err := f.agent.SendScheduled(ctx)
+
+		assert.ErrorContains(t, err, "scheduled message first: ")
+		assert.Assert(t, tu.ErrorIs(err, ErrSendDeadlineApproaching))
 		assert.Equal(t, 0, numSent)
+		assert.Equal(t, 0, len(f.campaigns.Campaigns))
+		assert.Equal(t, 2, len(f.schedules.Messages))
+		for id, scheduled := range f.schedules.Messages {
+			assert.Assert(t, scheduled.ClaimedUntil.IsZero(), id)
+		}
+	})
+
+	t.Run("StopsAfterSendReachesDeadline", func(t *testing.T) {
+		f, _ := setup()
+		schedule(f, "first", td.TestTimestamp.Add(-time.Minute))
+		schedule(f, "second", td.TestTimestamp)
+		deadline := td.TestTimestamp.Add(time.Hour)
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		defer cancel()
+		f.agent.CurrentTime = func() time.Time {
+			// Reach the deadline after sending to the first subscriber.
+			if len(f.mailer.RecipientMessages) == 0 {
+				return td.TestTimestamp
+			}
+			return deadline.Add(-sendDeadlineMargin)
+		}
+
+		numSent, err := f.agent.SendScheduled(ctx)
+
+		var incompleteErr *IncompleteSendError
+		assert.ErrorContains(t, err, "scheduled message first: ")
+		assert.Assert(t, errors.As(err, &incompleteErr))
+		assert.Equal(t, "first", incompleteErr.CampaignId)
+		assert.Assert(t, tu.ErrorIs(err, ErrSendDeadlineApproaching))
+		assert.Equal(t, 1, numSent)
+		assert.Equal(t, 1, len(f.campaigns.Campaigns))
+		campaign := f.campaigns.Campaigns["first"]
+		assert.Equal(t, db.CampaignIncomplete, campaign.Status)
+		assert.Equal(t, 2, len(f.schedules.Messages))
+		for id, scheduled := range f.schedules.Messages {
+			assert.Assert(t, scheduled.ClaimedUntil.IsZero(), id)
+		}
 	})
 }
@@ -41,7 +41,11 @@ type DecoyAgent struct {
 }
 
 func (a *DecoyAgent) Subscribe(
-	ctx context.Context, email string,
+	ctx context.Context,
+	email string,
+	topics []string,
+	signup *db.SignupMetadata,
+	profile *Profile,
 ) (ops.OperationResult, error) {
 	return ops.VerifyLinkSent, nil
 }
@@ -58,13 +62,21 @@ func (a *DecoyAgent) Unsubscribe(
 	return ops.Unsubscribed, nil
 }
 
+func (a *DecoyAgent) UpdateTopics(
+	ctx context.Context, email string, uid uuid.UUID, topics []string,
+) (ops.OperationResult, error) {
+	return ops.TopicsUpdated, nil
+}
+
 func (a *DecoyAgent) Validate(
 	_ context.Context, address string,
 ) (*email.ValidationFailure, error) {
 	return nil, nil
 }
 
-func (a *DecoyAgent) Import(ctx context.Context, address string) (err error) {
+func (a *DecoyAgent) Import(
+	ctx context.Context, address string, profile *Profile,
+) (err error) {
 	return nil
 }
 
@@ -78,7 +90,93 @@ func (a *DecoyAgent) Restore(ctx context.Context, email string) error {
 }
 
 func (a *DecoyAgent) Send(
-	ctx context.Context, msg *email.Message, addrs []string,
-) (numSent int, err error) {
+	ctx context.Context,
+	msg *email.Message,
+	addrs []string,
+	idempotencyKey string,
+	tagFilter string,
+) (numSent, numSkipped int, err error) {
+	return 0, 0, nil
+}
+
+func (a *DecoyAgent) ResumeSend(
+	ctx context.Context, campaignId string, msg *email.Message,
+) (numSent, numSkipped int, err error) {
+	return 0, 0, nil
+}
+
+func (a *DecoyAgent) GetCampaign(
+	ctx context.Context, id string,
+) (*db.Campaign, error) {
+	return nil, db.ErrCampaignNotFound
+}
+
+func (a *DecoyAgent) ListCampaigns(
+	ctx context.Context,
+) ([]*db.Campaign, error) {
+	return []*db.Campaign{}, nil
+}
+
+func (a *DecoyAgent) Schedule(
+	ctx context.Context, msg *email.Message, sendAt time.Time,
+) (id string, err error) {
+	return "", nil
+}
+
+func (a *DecoyAgent) SendScheduled(ctx context.Context) (int, error) {
 	return 0, nil
 }
+
+func (a *DecoyAgent) History(
+	ctx context.Context, email string,
+) ([]*db.AuditEvent, error) {
+	return []*db.AuditEvent{}, nil
+}
+
+func (a *DecoyAgent) ExportSubscriber(
+	ctx context.Context, email string,
+) (*SubscriberData, error) {
+	return &SubscriberData{Email: email, History: []*db.AuditEvent{}}, nil
+}
+
+func (a *DecoyAgent) Erase(ctx context.Context, email string) error {
+	return nil
+}
+
+func (a *DecoyAgent) GetWelcomeMessage(
+	ctx context.Context,
+) (*email.Message, error) {
+	return nil, nil
+}
+
+func (a *DecoyAgent) SetWelcomeMessage(
+	ctx context.Context, msg *email.Message,
+) error {
+	return nil
+}
+
+func (a *DecoyAgent) RotateUids(
+	ctx context.Context,
+	gracePeriod time.Duration,
+	startKey *db.ScanKey,
+	batchSize int,
+) (int, *db.ScanKey, error) {
+	return 0, nil, nil
+}
+
+func (a *DecoyAgent) GetSubscriber(
+	ctx context.Context, email string,
+) (*db.Subscriber, error) {
+	return nil, db.ErrSubscriberNotFound
+}
+
+func (a *DecoyAgent) ListSubscribers(
+	ctx context.Context,
+	status db.SubscriberStatus,
+	since time.Time,
+	domain string,
+	startKey *db.ScanKey,
+	limit int,
+) ([]*db.Subscriber, *db.ScanKey, error) {
+	return []*db.Subscriber{}, nil, nil
+}
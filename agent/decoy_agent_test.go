@@ -5,9 +5,13 @@ package agent
 import (
 	"context"
 	"testing"
+	"time"
 
+	"github.com/mbland/elistman/db"
+	"github.com/mbland/elistman/email"
 	"github.com/mbland/elistman/ops"
 	"github.com/mbland/elistman/testdata"
+	"github.com/mbland/elistman/testutils"
 	"gotest.tools/assert"
 	is "gotest.tools/assert/cmp"
 )
@@ -16,7 +20,7 @@ func TestDecoyAgent(t *testing.T) {
 	da := DecoyAgent{}
 	ctx := context.Background()
 
-	result, err := da.Subscribe(ctx, "foo@bar.com")
+	result, err := da.Subscribe(ctx, "foo@bar.com", nil, nil, nil)
 	assert.Equal(t, ops.VerifyLinkSent, result)
 	assert.NilError(t, err)
 
@@ -32,7 +36,7 @@ func TestDecoyAgent(t *testing.T) {
 	assert.Assert(t, is.Nil(failure))
 	assert.NilError(t, err)
 
-	err = da.Import(ctx, "foo@bar.com")
+	err = da.Import(ctx, "foo@bar.com", nil)
 	assert.NilError(t, err)
 
 	err = da.Remove(ctx, "foo@bar.com", ops.RemoveReasonBounce)
@@ -41,7 +45,42 @@ func TestDecoyAgent(t *testing.T) {
 	err = da.Restore(ctx, "foo@bar.com")
 	assert.NilError(t, err)
 
-	numSent, err := da.Send(ctx, nil, []string{})
+	numSent, numSkipped, err := da.Send(ctx, nil, []string{}, "", "")
 	assert.NilError(t, err)
 	assert.Equal(t, 0, numSent)
+	assert.Equal(t, 0, numSkipped)
+
+	history, err := da.History(ctx, "foo@bar.com")
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(history))
+
+	data, err := da.ExportSubscriber(ctx, "foo@bar.com")
+	assert.NilError(t, err)
+	assert.Equal(t, "foo@bar.com", data.Email)
+
+	err = da.Erase(ctx, "foo@bar.com")
+	assert.NilError(t, err)
+
+	welcome, err := da.GetWelcomeMessage(ctx)
+	assert.NilError(t, err)
+	assert.Assert(t, is.Nil(welcome))
+
+	err = da.SetWelcomeMessage(ctx, email.ExampleMessage)
+	assert.NilError(t, err)
+
+	numRotated, nextKey, err := da.RotateUids(ctx, time.Hour, nil, 10)
+	assert.NilError(t, err)
+	assert.Equal(t, 0, numRotated)
+	assert.Assert(t, is.Nil(nextKey))
+
+	sub, err := da.GetSubscriber(ctx, "foo@bar.com")
+	assert.Assert(t, is.Nil(sub))
+	assert.Assert(t, testutils.ErrorIs(err, db.ErrSubscriberNotFound))
+
+	subs, nextKey, err := da.ListSubscribers(
+		ctx, db.SubscriberVerified, time.Time{}, "", nil, 10,
+	)
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(subs))
+	assert.Assert(t, is.Nil(nextKey))
 }
@@ -37,6 +37,41 @@ PARAMETER_OVERRIDES=(
   "UnsubscribedPath=${UNSUBSCRIBED_PATH:?}"
 )
 
+# These parameters are optional, so only pass them along when defined.
+OPTIONAL_PARAMETERS=(
+  "Database=DATABASE"
+  "PostgresUrl=POSTGRES_URL"
+  "Mailer=MAILER"
+  "SmtpAddr=SMTP_ADDR"
+  "SmtpUsername=SMTP_USERNAME"
+  "SmtpPasswordSecret=SMTP_PASSWORD_SECRET"
+  "SkipLinkConfirmation=SKIP_LINK_CONFIRMATION"
+  "VerifyResendCooldown=VERIFY_RESEND_COOLDOWN"
+  "MaxVerifyEmails=MAX_VERIFY_EMAILS"
+  "ResubscribeCooldown=RESUBSCRIBE_COOLDOWN"
+  "ReimportCooldown=REIMPORT_COOLDOWN"
+  "RestoreCooldown=RESTORE_COOLDOWN"
+  "ErasureSalt=ERASURE_SALT"
+  "VerifyTokenKeys=VERIFY_TOKEN_KEYS"
+  "VerifyLinkExpiredPath=VERIFY_LINK_EXPIRED_PATH"
+  "Topics=TOPICS"
+  "TopicsUpdatedPath=TOPICS_UPDATED_PATH"
+)
+
+for param in "${OPTIONAL_PARAMETERS[@]}"; do
+  var_name="${param#*=}"
+
+  if [[ -n "${!var_name}" ]]; then
+    PARAMETER_OVERRIDES+=("${param%=*}=${!var_name}")
+  fi
+done
+
+# LISTS is optional JSON that may contain spaces, so escape it like
+# EMAIL_SITE_TITLE and SENDER_NAME above.
+if [[ -n "$LISTS" ]]; then
+  PARAMETER_OVERRIDES+=("Lists=${LISTS// /\ }")
+fi
+
 export SAM_CLI_TELEMETRY=0
 
 FLAGS=()
@@ -154,8 +154,8 @@ expect_status_from_endpoint 'invalid endpoint not found' \
   "$not_found_status"
 
 if [[ -n "$LOCAL" ]]; then
-    expect_status_from_endpoint '/subscribe with trailing component not found' \
-      POST 'subscribe/foobar' \
+    expect_status_from_endpoint '/subscribe with trailing components not found' \
+      POST 'subscribe/foobar/baz' \
       "$not_found_status"
 
     printf_info '%s\n' \
@@ -17,6 +17,16 @@ import (
 
 const FunctionArnKey = "EListManFunctionArn"
 
+// MaxLambdaPayloadSize is the largest request payload a synchronous Lambda
+// invocation will accept, in bytes.
+//
+// This limits the size of messages sent via the CLI more than
+// email.MaxRawMessageSize, since the JSON payload contains the base64 encoded
+// attachments.
+//
+// See: https://docs.aws.amazon.com/lambda/latest/dg/gettingstarted-limits.html
+const MaxLambdaPayloadSize = 6 * 1024 * 1024
+
 var AwsConfig aws.Config = ops.MustLoadDefaultAwsConfig()
 
 type DynamoDbFactoryFunc func(tableName string) *db.DynamoDb
@@ -144,6 +154,10 @@ func (l *Lambda) Invoke(
 	// https://pkg.go.dev/github.com/aws/aws-sdk-go-v2/service/lambda#InvokeOutput
 	if input.Payload, err = json.Marshal(request); err != nil {
 		return fmt.Errorf("failed to marshal Lambda request payload: %w", err)
+	} else if size := len(input.Payload); size > MaxLambdaPayloadSize {
+		const errFmt = "Lambda request payload of %d bytes exceeds limit " +
+			"of %d bytes; reduce the size of any attachments"
+		return fmt.Errorf(errFmt, size, MaxLambdaPayloadSize)
 	} else if output, err = l.Client.Invoke(ctx, input); err != nil {
 		return ops.AwsError("error invoking Lambda function", err)
 	} else if output.StatusCode != http.StatusOK {
@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -222,6 +223,21 @@ func TestLambdaInvoke(t *testing.T) {
 		assert.ErrorContains(t, err, "failed to marshal Lambda request payload")
 	})
 
+	t.Run("FailsIfRequestExceedsMaxLambdaPayloadSize", func(t *testing.T) {
+		ctx, tlc, l, req, res := setup()
+		req.Message = strings.Repeat("x", MaxLambdaPayloadSize)
+
+		err := l.Invoke(ctx, req, res)
+
+		expectedErr := fmt.Sprintf(
+			"Lambda request payload of %d bytes exceeds limit of %d bytes",
+			MaxLambdaPayloadSize+len(`{"Message":""}`),
+			MaxLambdaPayloadSize,
+		)
+		assert.ErrorContains(t, err, expectedErr)
+		assert.Assert(t, tlc.InvokeInput == nil)
+	})
+
 	t.Run("FailsIfCannotInvokeLambda", func(t *testing.T) {
 		ctx, tlc, l, req, res := setup()
 		tlc.InvokeError = testutils.AwsServerError("test error")
@@ -0,0 +1,206 @@
+// Copyright © 2023 Mike Bland <mbland@acm.org>
+// See LICENSE.txt for details.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/mbland/elistman/db"
+	"github.com/spf13/cobra"
+)
+
+const backupDescription = `` +
+	`Writes every subscriber record to standard output as JSON lines
+
+Reads the subscribers table directly, not via the EListMan Lambda function, and
+writes one JSON object per line for every pending and verified subscriber of
+the list. Each record contains every subscriber field, including the UID,
+timestamp, tags, and signup metadata, so "elistman restore" can write it back
+exactly as it was. Expired pending subscribers aren't included.
+
+The backup also includes the list's erasure records and tombstones, before any
+subscriber records. An erasure record contains only the salted hash of an
+address that "elistman erase" erased, in its Erasure field. A tombstone records
+that an address left the list, in its Tombstone field. Restoring them keeps
+"elistman restore" and "elistman import" from adding those addresses back.
+
+The --table flag names the subscribers table, i.e., the SUBSCRIBERS_TABLE_NAME
+of the EListMan instance. The table lives in DynamoDB unless --postgres-url
+specifies a PostgreSQL database.
+
+Each list requires its own backup. The backup isn't a consistent snapshot of
+the table, so avoid sending messages or importing subscribers while it runs.
+
+Example:
+  elistman backup --table elistman-subscribers > subscribers.jsonl`
+
+// backupRecord is one line of "elistman backup" output.
+//
+// Each record contains exactly one subscriber, tombstone, or erasure hash. The
+// subscriber fields appear at the top level, so a subscriber record is also a
+// valid db.Subscriber, as in backups made before tombstones and erasures were
+// included. List appears in every record, and takes the place of the embedded
+// db.Subscriber's List field.
+type backupRecord struct {
+	*db.Subscriber
+	List      string
+	Tombstone *db.Tombstone `json:",omitempty"`
+	Erasure   string        `json:",omitempty"`
+}
+
+// backupCounts tallies each kind of backupRecord that backup writes or restore
+// reads.
+type backupCounts struct {
+	subscribers int
+	tombstones  int
+	erasures    int
+}
+
+func (c *backupCounts) add(rec *backupRecord) {
+	if rec.Erasure != "" {
+		c.erasures++
+	} else if rec.Tombstone != nil {
+		c.tombstones++
+	} else {
+		c.subscribers++
+	}
+}
+
+func (c *backupCounts) String() string {
+	return fmt.Sprintf(
+		"%d subscribers, %d tombstones, and %d erasures",
+		c.subscribers, c.tombstones, c.erasures,
+	)
+}
+
+func init() {
+	rootCmd.AddCommand(newBackupCmd(NewSubscriberDb))
+}
+
+func newBackupCmd(newDb SubscriberDbFactoryFunc) (cmd *cobra.Command) {
+	cmd = &cobra.Command{
+		Use:   "backup",
+		Short: "Write every subscriber record to standard output",
+		Long:  backupDescription,
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return backupSubscribers(cmd, newDb)
+		},
+	}
+	registerSubscriberDb(cmd)
+	registerList(cmd)
+	return
+}
+
+func backupSubscribers(
+	cmd *cobra.Command, newDb SubscriberDbFactoryFunc,
+) (err error) {
+	cmd.SilenceUsage = true
+	var forList SubscriberDbFunc
+
+	if forList, err = newSubscriberDb(cmd, newDb); err != nil {
+		return
+	}
+
+	ctx := context.Background()
+	list := getListName(cmd)
+	listDb := forList(list)
+	encoder := json.NewEncoder(cmd.OutOrStdout())
+	counts := &backupCounts{}
+
+	write := func(rec *backupRecord) (err error) {
+		if err = encoder.Encode(rec); err == nil {
+			counts.add(rec)
+		}
+		return
+	}
+
+	err = backupErasures(ctx, listDb, list, write)
+	if err == nil {
+		err = backupTombstones(ctx, listDb, list, write)
+	}
+	if err == nil {
+		err = backupSubscriberRecords(ctx, listDb, write)
+	}
+	if err != nil {
+		const errFmt = "backup failed after %s: %w"
+		return fmt.Errorf(errFmt, counts, err)
+	}
+	cmd.PrintErrf("Backed up %s.\n", counts)
+	return
+}
+
+func backupErasures(
+	ctx context.Context,
+	listDb SubscriberDb,
+	list string,
+	write func(*backupRecord) error,
+) (err error) {
+	var hashes []string
+
+	if hashes, err = listDb.ListErasures(ctx); err != nil {
+		return
+	}
+	slices.Sort(hashes)
+
+	for _, hash := range hashes {
+		if err = write(&backupRecord{List: list, Erasure: hash}); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func backupTombstones(
+	ctx context.Context,
+	listDb SubscriberDb,
+	list string,
+	write func(*backupRecord) error,
+) (err error) {
+	var tombstones []*db.Tombstone
+
+	if tombstones, err = listDb.ListTombstones(ctx); err != nil {
+		return
+	}
+	slices.SortFunc(tombstones, func(lhs, rhs *db.Tombstone) int {
+		return strings.Compare(lhs.Email, rhs.Email)
+	})
+
+	for _, ts := range tombstones {
+		if err = write(&backupRecord{List: list, Tombstone: ts}); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func backupSubscriberRecords(
+	ctx context.Context,
+	listDb SubscriberDb,
+	write func(*backupRecord) error,
+) (err error) {
+	var writeErr error
+
+	process := db.SubscriberFunc(func(sub *db.Subscriber) bool {
+		rec := &backupRecord{Subscriber: sub, List: sub.List}
+		writeErr = write(rec)
+		return writeErr == nil
+	})
+
+	for _, status := range []db.SubscriberStatus{
+		db.SubscriberPending, db.SubscriberVerified,
+	} {
+		if err = listDb.ProcessSubscribers(ctx, status, process); err == nil {
+			err = writeErr
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
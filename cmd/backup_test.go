@@ -0,0 +1,207 @@
+//go:build small_tests || all_tests
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mbland/elistman/db"
+	"github.com/mbland/elistman/testdata"
+	"gotest.tools/assert"
+)
+
+func newBackupTestSubscribers() []*db.Subscriber {
+	return []*db.Subscriber{
+		{
+			Email:           "foo@test.com",
+			Uid:             testdata.TestUid,
+			Status:          db.SubscriberPending,
+			Timestamp:       testdata.TestTimestamp.Add(time.Hour),
+			Signup:          &db.SignupMetadata{Source: "footer"},
+			VerifySentCount: 1,
+			VerifySentAt:    testdata.TestTimestamp,
+		},
+		{
+			Email:              "bar@test.com",
+			Uid:                uuid.MustParse(testdata.TestUidStr[:35] + "5"),
+			Status:             db.SubscriberVerified,
+			Timestamp:          testdata.TestTimestamp,
+			FirstName:          "Bar",
+			Attributes:         map[string]string{"city": "Chicago"},
+			Tags:               []string{"essays", "releases"},
+			PreviousUid:        testdata.TestUid,
+			PreviousUidExpires: testdata.TestTimestamp.Add(24 * time.Hour),
+		},
+	}
+}
+
+func newBackupTestTombstone() *db.Tombstone {
+	return &db.Tombstone{
+		Email:     "baz@test.com",
+		Reason:    db.TombstoneUnsubscribe,
+		Timestamp: testdata.TestTimestamp,
+	}
+}
+
+func parseBackup(t *testing.T, backup string) (recs []*backupRecord) {
+	t.Helper()
+	recs = []*backupRecord{}
+
+	for _, line := range strings.Split(strings.TrimSpace(backup), "\n") {
+		rec := &backupRecord{}
+		assert.NilError(t, json.Unmarshal([]byte(line), rec))
+		recs = append(recs, rec)
+	}
+	return
+}
+
+func newSubscriberBackupRecords(subs []*db.Subscriber) []*backupRecord {
+	recs := make([]*backupRecord, 0, len(subs))
+	for _, sub := range subs {
+		recs = append(recs, &backupRecord{Subscriber: sub, List: sub.List})
+	}
+	return recs
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestBackup(t *testing.T) {
+	setup := func() (f *CommandTestFixture, subDb *TestSubscriberDb) {
+		subDb = NewTestSubscriberDb()
+		f = NewCommandTestFixture(newBackupCmd(subDb.GetFactoryFunc()))
+		f.Cmd.SetArgs([]string{"--table", TestTableName})
+		return
+	}
+
+	putSubscribers := func(t *testing.T, listDb *db.MemoryDb) {
+		t.Helper()
+		for _, sub := range newBackupTestSubscribers() {
+			assert.NilError(t, listDb.Put(context.Background(), sub))
+		}
+	}
+
+	t.Run("Succeeds", func(t *testing.T) {
+		f, subDb := setup()
+		putSubscribers(t, subDb.ForList(""))
+
+		err := f.Cmd.Execute()
+
+		assert.NilError(t, err)
+		assert.Equal(t, TestTableName, subDb.TableName)
+		assert.Equal(t, "", subDb.PostgresUrl)
+		const expectedStderr = "Backed up 2 subscribers, 0 tombstones, " +
+			"and 0 erasures.\n"
+		assert.Equal(t, expectedStderr, f.Stderr.String())
+		assert.DeepEqual(
+			t,
+			newSubscriberBackupRecords(newBackupTestSubscribers()),
+			parseBackup(t, f.Stdout.String()),
+		)
+	})
+
+	t.Run("WritesErasuresAndTombstonesFirst", func(t *testing.T) {
+		f, subDb := setup()
+		ctx := context.Background()
+		listDb := subDb.ForList("")
+		putSubscribers(t, listDb)
+		tombstone := newBackupTestTombstone()
+		assert.NilError(t, listDb.PutTombstone(ctx, tombstone))
+		assert.NilError(t, listDb.PutErasure(ctx, "deadbeef"))
+
+		err := f.Cmd.Execute()
+
+		assert.NilError(t, err)
+		const expectedStderr = "Backed up 2 subscribers, 1 tombstones, " +
+			"and 1 erasures.\n"
+		assert.Equal(t, expectedStderr, f.Stderr.String())
+		firstLine, _, _ := strings.Cut(f.Stdout.String(), "\n")
+		assert.Equal(t, `{"List":"","Erasure":"deadbeef"}`, firstLine)
+		expected := append(
+			[]*backupRecord{{Erasure: "deadbeef"}, {Tombstone: tombstone}},
+			newSubscriberBackupRecords(newBackupTestSubscribers())...,
+		)
+		assert.DeepEqual(t, expected, parseBackup(t, f.Stdout.String()))
+	})
+
+	t.Run("BacksUpListFromPostgres", func(t *testing.T) {
+		f, subDb := setup()
+		putSubscribers(t, subDb.ForList("other-list"))
+		f.Cmd.SetArgs([]string{
+			"--table", TestTableName,
+			"--postgres-url", TestPostgresUrl,
+			"--list", "other-list",
+		})
+
+		err := f.Cmd.Execute()
+
+		assert.NilError(t, err)
+		assert.Equal(t, TestPostgresUrl, subDb.PostgresUrl)
+		assert.Equal(t, 2, len(parseBackup(t, f.Stdout.String())))
+	})
+
+	t.Run("WritesListNameInEveryRecord", func(t *testing.T) {
+		f, subDb := setup()
+		listDb := subDb.ForList("other-list")
+		tombstone := newBackupTestTombstone()
+		assert.NilError(t, listDb.PutTombstone(context.Background(), tombstone))
+		f.Cmd.SetArgs([]string{"--table", TestTableName, "-l", "other-list"})
+
+		err := f.Cmd.Execute()
+
+		assert.NilError(t, err)
+		expected := []*backupRecord{
+			{List: "other-list", Tombstone: tombstone},
+		}
+		assert.DeepEqual(t, expected, parseBackup(t, f.Stdout.String()))
+	})
+
+	t.Run("RequiresTableFlag", func(t *testing.T) {
+		f, _ := setup()
+		f.AssertFailsIfRequiredFlagMissing(t, FlagTable, []string{})
+	})
+
+	t.Run("FailsIfCreatingDatabaseFails", func(t *testing.T) {
+		f, subDb := setup()
+		subDb.CreateError = errors.New("create db failed")
+
+		f.ExecuteAndAssertErrorContains(t, "create db failed")
+	})
+
+	t.Run("FailsIfProcessingSubscribersFails", func(t *testing.T) {
+		f, subDb := setup()
+		subDb.ProcessError = errors.New("process failed")
+
+		const expectedErr = "backup failed after 0 subscribers, " +
+			"0 tombstones, and 0 erasures: process failed"
+		f.ExecuteAndAssertErrorContains(t, expectedErr)
+	})
+
+	t.Run("FailsIfListingErasuresOrTombstonesFails", func(t *testing.T) {
+		f, subDb := setup()
+		subDb.ListError = errors.New("list failed")
+
+		const expectedErr = "backup failed after 0 subscribers, " +
+			"0 tombstones, and 0 erasures: list failed"
+		f.ExecuteAndAssertErrorContains(t, expectedErr)
+	})
+
+	t.Run("FailsIfWritingFails", func(t *testing.T) {
+		f, subDb := setup()
+		putSubscribers(t, subDb.ForList(""))
+		f.Cmd.SetOut(failingWriter{})
+
+		const expectedErr = "backup failed after 0 subscribers, " +
+			"0 tombstones, and 0 erasures: write failed"
+		f.ExecuteAndAssertErrorContains(t, expectedErr)
+	})
+}
@@ -0,0 +1,182 @@
+// Copyright © 2023 Mike Bland <mbland@acm.org>
+// See LICENSE.txt for details.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mbland/elistman/db"
+	"github.com/mbland/elistman/events"
+	"github.com/spf13/cobra"
+)
+
+const campaignsDescription = `` +
+	`Lists or shows the records of messages sent to the entire list
+
+Every time "elistman send" sends a message to the entire list, it creates or
+updates a campaign record containing the message subject, a hash of the message,
+the start and finish times, the numbers of messages sent and failed, and the
+current status of the campaign. The campaign ID is the idempotency key of the
+send, which defaults to the hash of the message.
+
+A campaign's status is one of:
+
+  sending:    the send is still in progress
+  complete:   the message was sent to every verified subscriber
+  incomplete: the send stopped before reaching every verified subscriber,
+              and may be resumed via "elistman send --resume CAMPAIGN_ID"`
+
+const campaignsListDescription = `` +
+	`Lists all campaigns, most recently started first`
+
+const campaignsShowDescription = `` +
+	`Shows all the information for the campaign with the specified ID`
+
+const campaignTimeFormat = time.RFC3339
+
+func init() {
+	rootCmd.AddCommand(newCampaignsCmd(NewEListManLambda))
+}
+
+func newCampaignsCmd(newFunc EListManFactoryFunc) (cmd *cobra.Command) {
+	cmd = &cobra.Command{
+		Use:   "campaigns",
+		Short: "List or show the records of messages sent to the list",
+		Long:  campaignsDescription,
+	}
+	cmd.AddCommand(newCampaignsListCmd(newFunc))
+	cmd.AddCommand(newCampaignsShowCmd(newFunc))
+	return
+}
+
+func newCampaignsListCmd(newFunc EListManFactoryFunc) (cmd *cobra.Command) {
+	cmd = &cobra.Command{
+		Use:   "list",
+		Short: "List all campaigns",
+		Long:  campaignsListDescription,
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return listCampaigns(cmd, newFunc, getStackName(cmd))
+		},
+	}
+	registerStackName(cmd)
+	registerList(cmd)
+	cmd.MarkFlagRequired(FlagStackName)
+	return
+}
+
+func newCampaignsShowCmd(newFunc EListManFactoryFunc) (cmd *cobra.Command) {
+	cmd = &cobra.Command{
+		Use:   "show CAMPAIGN_ID",
+		Short: "Show a single campaign",
+		Long:  campaignsShowDescription,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, argv []string) error {
+			return showCampaign(cmd, newFunc, getStackName(cmd), argv[0])
+		},
+	}
+	registerStackName(cmd)
+	registerList(cmd)
+	cmd.MarkFlagRequired(FlagStackName)
+	return
+}
+
+func listCampaigns(
+	cmd *cobra.Command, newFunc EListManFactoryFunc, stackName string,
+) (err error) {
+	var campaigns []*db.Campaign
+
+	if campaigns, err = getCampaigns(cmd, newFunc, stackName, ""); err != nil {
+		return
+	} else if len(campaigns) == 0 {
+		cmd.Println("No campaigns found.")
+		return
+	}
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATUS\tSENT\tFAILED\tSTARTED\tSUBJECT")
+
+	for _, c := range campaigns {
+		fmt.Fprintf(
+			w,
+			"%s\t%s\t%d\t%d\t%s\t%s\n",
+			c.Id,
+			c.Status,
+			c.NumSent,
+			c.NumFailed,
+			c.StartTime.Format(campaignTimeFormat),
+			c.Subject,
+		)
+	}
+	return w.Flush()
+}
+
+func showCampaign(
+	cmd *cobra.Command,
+	newFunc EListManFactoryFunc,
+	stackName string,
+	campaignId string,
+) (err error) {
+	var campaigns []*db.Campaign
+
+	campaigns, err = getCampaigns(cmd, newFunc, stackName, campaignId)
+	if err != nil {
+		return
+	} else if len(campaigns) != 1 {
+		const errFmt = "expected one campaign with ID %s, received %d"
+		return fmt.Errorf(errFmt, campaignId, len(campaigns))
+	}
+	writeCampaign(cmd.OutOrStdout(), campaigns[0])
+	return
+}
+
+func getCampaigns(
+	cmd *cobra.Command,
+	newFunc EListManFactoryFunc,
+	stackName string,
+	campaignId string,
+) (campaigns []*db.Campaign, err error) {
+	cmd.SilenceUsage = true
+	ctx := context.Background()
+	evt := &events.CommandLineEvent{
+		EListManCommand: events.CommandLineCampaignsEvent,
+		Campaigns: &events.CampaignsEvent{
+			List: getListName(cmd), CampaignId: campaignId,
+		},
+	}
+	response := &events.CampaignsResponse{}
+
+	if err = newFunc.Invoke(ctx, stackName, evt, response); err != nil {
+		err = fmt.Errorf("failed to get campaigns: %w", err)
+	} else if !response.Success {
+		err = errors.New("failed to get campaigns: " + response.Details)
+	} else {
+		campaigns = response.Campaigns
+	}
+	return
+}
+
+func writeCampaign(w io.Writer, c *db.Campaign) {
+	finishTime := "-"
+	if !c.FinishTime.IsZero() {
+		finishTime = c.FinishTime.Format(campaignTimeFormat)
+	}
+
+	fmt.Fprintf(w, "ID:           %s\n", c.Id)
+	fmt.Fprintf(w, "Subject:      %s\n", c.Subject)
+	fmt.Fprintf(w, "Message hash: %s\n", c.MessageHash)
+	if c.TagFilter != "" {
+		fmt.Fprintf(w, "Tag filter:   %s\n", c.TagFilter)
+	}
+	fmt.Fprintf(w, "Status:       %s\n", c.Status)
+	fmt.Fprintf(w, "Started:      %s\n", c.StartTime.Format(campaignTimeFormat))
+	fmt.Fprintf(w, "Finished:     %s\n", finishTime)
+	fmt.Fprintf(w, "Sent:         %d\n", c.NumSent)
+	fmt.Fprintf(w, "Failed:       %d\n", c.NumFailed)
+}
@@ -0,0 +1,200 @@
+//go:build small_tests || all_tests
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/mbland/elistman/events"
+	"gotest.tools/assert"
+)
+
+const testCampaignsJson = `{
+  "Success": true,
+  "Campaigns": [
+    {
+      "Id": "campaign-1",
+      "Subject": "Second post",
+      "MessageHash": "hash-1",
+      "StartTime": "2023-09-25T12:00:00Z",
+      "FinishTime": "0001-01-01T00:00:00Z",
+      "NumSent": 9,
+      "NumFailed": 1,
+      "Status": "incomplete"
+    },
+    {
+      "Id": "campaign-0",
+      "Subject": "First post",
+      "MessageHash": "hash-0",
+      "StartTime": "2023-09-18T12:00:00Z",
+      "FinishTime": "2023-09-18T12:05:00Z",
+      "NumSent": 27,
+      "NumFailed": 0,
+      "Status": "complete"
+    }
+  ]
+}`
+
+func TestCampaignsList(t *testing.T) {
+	setup := func() (f *CommandTestFixture, lambda *TestEListManFunc) {
+		lambda = NewTestEListManFunc()
+		cmd := newCampaignsCmd(lambda.GetFactoryFunc())
+		f = NewCommandTestFixture(cmd)
+		f.Cmd.SetArgs([]string{"list", "-s", TestStackName})
+		return
+	}
+
+	t.Run("Succeeds", func(t *testing.T) {
+		f, lambda := setup()
+		lambda.SetResponseJson(testCampaignsJson)
+
+		const expectedOut = "" +
+			"ID          STATUS      SENT  FAILED  STARTED" +
+			"               SUBJECT\n" +
+			"campaign-1  incomplete  9     1       2023-09-25T12:00:00Z" +
+			"  Second post\n" +
+			"campaign-0  complete    27    0       2023-09-18T12:00:00Z" +
+			"  First post\n"
+		f.ExecuteAndAssertStdoutContains(t, expectedOut)
+
+		expectedReq := &events.CommandLineEvent{
+			EListManCommand: events.CommandLineCampaignsEvent,
+			Campaigns:       &events.CampaignsEvent{},
+		}
+		lambda.AssertMatches(t, TestStackName, expectedReq)
+	})
+
+	t.Run("ReportsIfNoCampaignsFound", func(t *testing.T) {
+		f, lambda := setup()
+		lambda.SetResponseJson(`{"Success": true, "Campaigns": []}`)
+
+		f.ExecuteAndAssertStdoutContains(t, "No campaigns found.\n")
+	})
+
+	t.Run("RequiresStackNameFlag", func(t *testing.T) {
+		f, _ := setup()
+		f.AssertFailsIfRequiredFlagMissing(t, FlagStackName, []string{"list"})
+	})
+
+	t.Run("FailsIfInvokingLambdaFails", func(t *testing.T) {
+		f, lambda := setup()
+		f.AssertReturnsLambdaError(t, lambda, "failed to get campaigns: ")
+	})
+
+	t.Run("FailsIfLambdaReturnsError", func(t *testing.T) {
+		f, lambda := setup()
+		lambda.SetResponseJson(`{"Success": false, "Details": "test failure"}`)
+
+		const expectedErr = "failed to get campaigns: test failure"
+		f.ExecuteAndAssertErrorContains(t, expectedErr)
+	})
+}
+
+func TestCampaignsShow(t *testing.T) {
+	setup := func() (f *CommandTestFixture, lambda *TestEListManFunc) {
+		lambda = NewTestEListManFunc()
+		cmd := newCampaignsCmd(lambda.GetFactoryFunc())
+		f = NewCommandTestFixture(cmd)
+		f.Cmd.SetArgs([]string{"show", "-s", TestStackName, "campaign-0"})
+		return
+	}
+
+	t.Run("Succeeds", func(t *testing.T) {
+		f, lambda := setup()
+		lambda.SetResponseJson(`{
+		  "Success": true,
+		  "Campaigns": [
+		    {
+		      "Id": "campaign-0",
+		      "Subject": "First post",
+		      "MessageHash": "hash-0",
+		      "StartTime": "2023-09-18T12:00:00Z",
+		      "FinishTime": "2023-09-18T12:05:00Z",
+		      "NumSent": 27,
+		      "NumFailed": 0,
+		      "Status": "complete"
+		    }
+		  ]
+		}`)
+
+		const expectedOut = "" +
+			"ID:           campaign-0\n" +
+			"Subject:      First post\n" +
+			"Message hash: hash-0\n" +
+			"Status:       complete\n" +
+			"Started:      2023-09-18T12:00:00Z\n" +
+			"Finished:     2023-09-18T12:05:00Z\n" +
+			"Sent:         27\n" +
+			"Failed:       0\n"
+		f.ExecuteAndAssertStdoutContains(t, expectedOut)
+
+		expectedReq := &events.CommandLineEvent{
+			EListManCommand: events.CommandLineCampaignsEvent,
+			Campaigns:       &events.CampaignsEvent{CampaignId: "campaign-0"},
+		}
+		lambda.AssertMatches(t, TestStackName, expectedReq)
+	})
+
+	t.Run("ShowsTagFilter", func(t *testing.T) {
+		f, lambda := setup()
+		lambda.SetResponseJson(`{
+		  "Success": true,
+		  "Campaigns": [
+		    {
+		      "Id": "campaign-0",
+		      "Subject": "First post",
+		      "MessageHash": "hash-0",
+		      "TagFilter": "releases or essays",
+		      "StartTime": "2023-09-18T12:00:00Z",
+		      "NumSent": 5,
+		      "NumFailed": 0,
+		      "Status": "complete"
+		    }
+		  ]
+		}`)
+
+		const expectedOut = "" +
+			"Message hash: hash-0\n" +
+			"Tag filter:   releases or essays\n" +
+			"Status:       complete\n"
+		f.ExecuteAndAssertStdoutContains(t, expectedOut)
+	})
+
+	t.Run("ShowsNoFinishTimeIfNotComplete", func(t *testing.T) {
+		f, lambda := setup()
+		lambda.SetResponseJson(`{
+		  "Success": true,
+		  "Campaigns": [{"Id": "campaign-0", "Status": "sending"}]
+		}`)
+
+		f.ExecuteAndAssertStdoutContains(t, "Finished:     -\n")
+	})
+
+	t.Run("RequiresCampaignId", func(t *testing.T) {
+		f, _ := setup()
+		f.Cmd.SetArgs([]string{"show", "-s", TestStackName})
+
+		err := f.Cmd.Execute()
+
+		assert.ErrorContains(t, err, "accepts 1 arg(s), received 0")
+	})
+
+	t.Run("FailsIfLambdaReturnsError", func(t *testing.T) {
+		f, lambda := setup()
+		lambda.SetResponseJson(
+			`{"Success": false, "Details": "campaign not found"}`,
+		)
+
+		const expectedErr = "failed to get campaigns: campaign not found"
+		f.ExecuteAndAssertErrorContains(t, expectedErr)
+	})
+
+	t.Run("FailsIfResponseDoesNotContainOneCampaign", func(t *testing.T) {
+		f, lambda := setup()
+		lambda.SetResponseJson(testCampaignsJson)
+
+		const expectedErr = "expected one campaign with ID campaign-0, " +
+			"received 2"
+		f.ExecuteAndAssertErrorContains(t, expectedErr)
+	})
+}
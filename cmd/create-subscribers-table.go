@@ -20,22 +20,40 @@ hours, after which the DynamoDB Time To Live feature will remove them.
 
 The command takes one argument, which is the name of the table to create. This
 name will become the value of the SUBSCRIBERS_TABLE_NAME environment variable
-used to configure and deploy the application.`
+used to configure and deploy the application.
+
+With --postgres-url, it instead creates the subscribers table and the tables for
+every other record type in the PostgreSQL database at that URL, for deployments
+where DATABASE is "postgres". The other tables' names begin with the
+subscribers table name. PostgreSQL has no Time To Live feature, so the
+application deletes expired pending subscribers itself.`
 
 func init() {
-	rootCmd.AddCommand(newCreateSubscribersTableCmd(NewDynamoDb))
+	rootCmd.AddCommand(
+		newCreateSubscribersTableCmd(NewDynamoDb, NewPostgresDb),
+	)
 }
 
-func newCreateSubscribersTableCmd(newDynDb DynamoDbFactoryFunc) *cobra.Command {
-	return &cobra.Command{
+func newCreateSubscribersTableCmd(
+	newDynDb DynamoDbFactoryFunc, newPgDb PostgresDbFactoryFunc,
+) *cobra.Command {
+	cmd := &cobra.Command{
 		Use:   "create-subscribers-table",
 		Short: "Create a DynamoDB table for mailing list subscribers",
 		Long:  createSubscribersTableDescription,
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if url := getStringFlag(cmd, FlagPostgresUrl); url != "" {
+				return createPostgresTables(cmd, newPgDb, url, args[0])
+			}
 			return createSubscribersTable(cmd, newDynDb(args[0]), time.Minute)
 		},
 	}
+	cmd.Flags().String(
+		FlagPostgresUrl, "",
+		"create PostgreSQL tables at this URL instead of a DynamoDB table",
+	)
+	return cmd
 }
 
 func createSubscribersTable(
@@ -49,3 +67,18 @@ func createSubscribersTable(
 	}
 	return
 }
+
+func createPostgresTables(
+	cmd *cobra.Command, newPgDb PostgresDbFactoryFunc, url, tableName string,
+) (err error) {
+	cmd.SilenceUsage = true
+	ctx := context.Background()
+	var pgDb PostgresTables
+
+	if pgDb, err = newPgDb(url, tableName); err != nil {
+		return
+	} else if err = pgDb.CreateSubscribersTable(ctx); err == nil {
+		cmd.Printf("Successfully created PostgreSQL tables: %s\n", tableName)
+	}
+	return
+}
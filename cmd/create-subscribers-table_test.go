@@ -3,6 +3,8 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"testing"
 
@@ -16,9 +18,12 @@ func TestCreateSubscribersTable(t *testing.T) {
 	setup := func() (f *CommandTestFixture, client *db.TestDynamoDbClient) {
 		client = db.NewTestDynamoDbClient()
 		f = NewCommandTestFixture(
-			newCreateSubscribersTableCmd(func(tableName string) *db.DynamoDb {
-				return &db.DynamoDb{Client: client, TableName: tableName}
-			}),
+			newCreateSubscribersTableCmd(
+				func(tableName string) *db.DynamoDb {
+					return &db.DynamoDb{Client: client, TableName: tableName}
+				},
+				nil,
+			),
 		)
 		f.Cmd.SetArgs([]string{"elistman-subscribers"})
 		return
@@ -39,3 +44,57 @@ func TestCreateSubscribersTable(t *testing.T) {
 		f.ExecuteAndAssertErrorContains(t, "create table test error")
 	})
 }
+
+type testPostgresTables struct {
+	url       string
+	tableName string
+	created   bool
+	createErr error
+}
+
+func (pt *testPostgresTables) CreateSubscribersTable(
+	_ context.Context,
+) error {
+	pt.created = pt.createErr == nil
+	return pt.createErr
+}
+
+func TestCreateSubscribersTableWithPostgres(t *testing.T) {
+	const TableName = "elistman-subscribers"
+	const Url = "postgres://elistman@localhost/elistman"
+
+	setup := func() (f *CommandTestFixture, tables *testPostgresTables) {
+		tables = &testPostgresTables{}
+		var factoryErr error
+		f = NewCommandTestFixture(
+			newCreateSubscribersTableCmd(
+				nil,
+				func(url, tableName string) (PostgresTables, error) {
+					tables.url = url
+					tables.tableName = tableName
+					return tables, factoryErr
+				},
+			),
+		)
+		f.Cmd.SetArgs([]string{"--postgres-url", Url, TableName})
+		return
+	}
+
+	t.Run("Succeeds", func(t *testing.T) {
+		f, tables := setup()
+
+		const outFmt = "Successfully created PostgreSQL tables: %s\n"
+		f.ExecuteAndAssertStdoutContains(t, fmt.Sprintf(outFmt, TableName))
+		assert.Assert(t, f.Cmd.SilenceUsage == true)
+		assert.Equal(t, Url, tables.url)
+		assert.Equal(t, TableName, tables.tableName)
+		assert.Assert(t, tables.created)
+	})
+
+	t.Run("FailsOnCreateError", func(t *testing.T) {
+		f, tables := setup()
+		tables.createErr = errors.New("create tables test error")
+
+		f.ExecuteAndAssertErrorContains(t, "create tables test error")
+	})
+}
@@ -0,0 +1,82 @@
+// Copyright © 2023 Mike Bland <mbland@acm.org>
+// See LICENSE.txt for details.
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/mbland/elistman/db"
+	"github.com/spf13/cobra"
+)
+
+// SubscriberDb comprises the stores that "backup" and "restore" access for
+// each list.
+type SubscriberDb interface {
+	db.Database
+	db.TombstoneStore
+	db.ErasureStore
+}
+
+// SubscriberDbFunc returns the database for the subscribers of a list.
+//
+// An empty list name selects the default list.
+type SubscriberDbFunc func(list string) SubscriberDb
+
+// SubscriberDbFactoryFunc returns the SubscriberDbFunc for the subscribers
+// table named tableName.
+//
+// The table lives in DynamoDB if postgresUrl is empty, and in the PostgreSQL
+// database at postgresUrl otherwise.
+type SubscriberDbFactoryFunc func(
+	tableName, postgresUrl string,
+) (SubscriberDbFunc, error)
+
+func NewSubscriberDb(
+	tableName, postgresUrl string,
+) (forList SubscriberDbFunc, err error) {
+	if postgresUrl == "" {
+		dynDb := NewDynamoDb(tableName)
+		forList = func(list string) SubscriberDb { return dynDb.ForList(list) }
+		return
+	}
+
+	var pgDb *db.PostgresDb
+	if pgDb, err = newPostgresDb(postgresUrl, tableName); err == nil {
+		forList = func(list string) SubscriberDb { return pgDb.ForList(list) }
+	}
+	return
+}
+
+func registerSubscriberDb(cmd *cobra.Command) {
+	cmd.Flags().StringP(
+		FlagTable, "t", "", "name of the subscribers table",
+	)
+	cmd.Flags().String(
+		FlagPostgresUrl, "",
+		"URL of the PostgreSQL database containing the table, if not DynamoDB",
+	)
+	cmd.MarkFlagRequired(FlagTable)
+}
+
+func newSubscriberDb(
+	cmd *cobra.Command, newDb SubscriberDbFactoryFunc,
+) (SubscriberDbFunc, error) {
+	tableName := getStringFlag(cmd, FlagTable)
+	return newDb(tableName, getStringFlag(cmd, FlagPostgresUrl))
+}
+
+func registerErasureSalt(cmd *cobra.Command) {
+	cmd.Flags().String(
+		FlagErasureSalt, "",
+		"ERASURE_SALT of the EListMan instance, "+
+			"if not the ERASURE_SALT environment variable",
+	)
+}
+
+func getErasureSalt(cmd *cobra.Command) (salt string) {
+	if salt = getStringFlag(cmd, FlagErasureSalt); salt == "" {
+		salt = os.Getenv("ERASURE_SALT")
+	}
+	return
+}
@@ -0,0 +1,91 @@
+// Copyright © 2023 Mike Bland <mbland@acm.org>
+// See LICENSE.txt for details.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/mbland/elistman/events"
+	"github.com/spf13/cobra"
+)
+
+const eraseDescription = `` +
+	`Deletes everything stored about an email address
+
+This is intended to fulfill a data subject erasure request. It deletes the
+subscriber record, the record of the address leaving the list, and its history
+from the default list and every named list, then prints the lists it erased.
+It leaves the address on the SES account suppression list, if it's there, so
+no list will send to an address that bounced or complained before.
+
+It leaves behind only a salted hash of the address in each list, so that
+"import" and "restore" won't add it back to any of them by accident. The address
+may still subscribe again on its own. This requires the ERASURE_SALT
+configuration variable.
+
+The erasure can't be undone. If it fails partway through, it prints the lists it
+erased before the failure. Running it again is safe and will finish the job.`
+
+func init() {
+	rootCmd.AddCommand(newEraseCmd(NewEListManLambda))
+}
+
+func newEraseCmd(newFunc EListManFactoryFunc) (cmd *cobra.Command) {
+	cmd = &cobra.Command{
+		Use:   "erase ADDRESS",
+		Short: "Delete everything stored about an email address",
+		Long:  eraseDescription,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, argv []string) error {
+			return erase(cmd, newFunc, getStackName(cmd), argv[0])
+		},
+	}
+	registerStackName(cmd)
+	cmd.MarkFlagRequired(FlagStackName)
+	return
+}
+
+func erase(
+	cmd *cobra.Command,
+	newFunc EListManFactoryFunc,
+	stackName string,
+	address string,
+) (err error) {
+	cmd.SilenceUsage = true
+	ctx := context.Background()
+	evt := &events.CommandLineEvent{
+		EListManCommand: events.CommandLineEraseEvent,
+		Erase:           &events.EraseEvent{Email: address},
+	}
+	response := &events.EraseResponse{}
+
+	if err = newFunc.Invoke(ctx, stackName, evt, response); err != nil {
+		err = fmt.Errorf("failed to erase %s: %w", address, err)
+		return
+	}
+
+	lists := listNames(response.Lists)
+	if !response.Success {
+		const errFmt = "failed to erase %s after erasing lists [%s]: %s"
+		err = fmt.Errorf(errFmt, address, lists, response.Details)
+	} else {
+		cmd.Printf("Erased all data for %s from lists: %s.\n", address, lists)
+	}
+	return
+}
+
+// listNames returns a comma separated list of list names, where "(default)"
+// stands for the default list.
+func listNames(lists []string) string {
+	names := make([]string, len(lists))
+
+	for i, list := range lists {
+		if names[i] = list; list == "" {
+			names[i] = "(default)"
+		}
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,82 @@
+//go:build small_tests || all_tests
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/mbland/elistman/events"
+	"gotest.tools/assert"
+)
+
+func TestErase(t *testing.T) {
+	setup := func() (f *CommandTestFixture, lambda *TestEListManFunc) {
+		lambda = NewTestEListManFunc()
+		cmd := newEraseCmd(lambda.GetFactoryFunc())
+		f = NewCommandTestFixture(cmd)
+		f.Cmd.SetArgs([]string{"-s", TestStackName, "foo@test.com"})
+		return
+	}
+
+	t.Run("Succeeds", func(t *testing.T) {
+		f, lambda := setup()
+		lambda.SetResponseJson(
+			`{"Success": true, "Lists": ["", "news", "updates"]}`,
+		)
+
+		f.ExecuteAndAssertStdoutContains(
+			t,
+			"Erased all data for foo@test.com "+
+				"from lists: (default), news, updates.\n",
+		)
+
+		expectedReq := &events.CommandLineEvent{
+			EListManCommand: events.CommandLineEraseEvent,
+			Erase:           &events.EraseEvent{Email: "foo@test.com"},
+		}
+		lambda.AssertMatches(t, TestStackName, expectedReq)
+	})
+
+	t.Run("RejectsListFlag", func(t *testing.T) {
+		f, _ := setup()
+		f.Cmd.SetArgs(
+			[]string{"-s", TestStackName, "-l", "updates", "foo@test.com"},
+		)
+
+		err := f.Cmd.Execute()
+
+		assert.ErrorContains(t, err, "unknown shorthand flag: 'l'")
+	})
+
+	t.Run("RequiresAddress", func(t *testing.T) {
+		f, _ := setup()
+		f.Cmd.SetArgs([]string{"-s", TestStackName})
+
+		err := f.Cmd.Execute()
+
+		assert.ErrorContains(t, err, "accepts 1 arg(s), received 0")
+	})
+
+	t.Run("RequiresStackNameFlag", func(t *testing.T) {
+		f, _ := setup()
+		f.AssertFailsIfRequiredFlagMissing(
+			t, FlagStackName, []string{"foo@test.com"},
+		)
+	})
+
+	t.Run("FailsIfInvokingLambdaFails", func(t *testing.T) {
+		f, lambda := setup()
+		f.AssertReturnsLambdaError(t, lambda, "failed to erase foo@test.com: ")
+	})
+
+	t.Run("FailsIfLambdaReturnsError", func(t *testing.T) {
+		f, lambda := setup()
+		lambda.SetResponseJson(
+			`{"Success": false, "Details": "test failure", "Lists": [""]}`,
+		)
+
+		const expectedErr = "failed to erase foo@test.com " +
+			"after erasing lists [(default)]: test failure"
+		f.ExecuteAndAssertErrorContains(t, expectedErr)
+	})
+}
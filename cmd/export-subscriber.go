@@ -0,0 +1,74 @@
+// Copyright © 2023 Mike Bland <mbland@acm.org>
+// See LICENSE.txt for details.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/mbland/elistman/events"
+	"github.com/spf13/cobra"
+)
+
+const exportSubscriberDescription = `` +
+	`Prints everything stored about an email address as JSON
+
+This is intended to fulfill a data subject access request. The output is an
+array with an object for the default list and each named list, containing:
+
+  List:       the name of the list, which is empty for the default list
+  Subscriber: the subscriber record, if the address is on the list
+  Tombstone:  the record of the address leaving the list, if it has
+  Suppressed: whether the address is on the SES account suppression list
+  Erased:     whether the address's data was erased before
+  History:    every status change recorded for the address (see "history")`
+
+func init() {
+	rootCmd.AddCommand(newExportSubscriberCmd(NewEListManLambda))
+}
+
+func newExportSubscriberCmd(
+	newFunc EListManFactoryFunc,
+) (cmd *cobra.Command) {
+	cmd = &cobra.Command{
+		Use:   "export-subscriber ADDRESS",
+		Short: "Print everything stored about an email address",
+		Long:  exportSubscriberDescription,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, argv []string) error {
+			return exportSubscriber(
+				cmd, newFunc, getStackName(cmd), argv[0],
+			)
+		},
+	}
+	registerStackName(cmd)
+	cmd.MarkFlagRequired(FlagStackName)
+	return
+}
+
+func exportSubscriber(
+	cmd *cobra.Command,
+	newFunc EListManFactoryFunc,
+	stackName string,
+	address string,
+) (err error) {
+	cmd.SilenceUsage = true
+	ctx := context.Background()
+	evt := &events.CommandLineEvent{
+		EListManCommand:  events.CommandLineExportSubscriberEvent,
+		ExportSubscriber: &events.ExportSubscriberEvent{Email: address},
+	}
+	response := &events.ExportSubscriberResponse{}
+	const errPrefix = "failed to export subscriber data: "
+
+	if err = newFunc.Invoke(ctx, stackName, evt, response); err != nil {
+		err = fmt.Errorf(errPrefix+"%w", err)
+	} else if !response.Success {
+		err = errors.New(errPrefix + response.Details)
+	} else {
+		err = printJson(cmd, response.Data)
+	}
+	return
+}
@@ -0,0 +1,136 @@
+//go:build small_tests || all_tests
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/mbland/elistman/events"
+	"gotest.tools/assert"
+)
+
+const testExportSubscriberJson = `{
+  "Success": true,
+  "Data": [
+    {
+      "List": "",
+      "Email": "foo@test.com",
+      "Subscriber": null,
+      "Tombstone": {
+        "Email": "foo@test.com",
+        "Reason": "unsubscribe",
+        "Timestamp": "2023-09-25T12:00:00Z"
+      },
+      "Suppressed": false,
+      "Erased": false,
+      "History": [
+        {
+          "Email": "foo@test.com",
+          "Operation": "unsubscribe",
+          "Reason": "",
+          "Source": "api",
+          "RequestId": "request-0",
+          "Timestamp": "2023-09-25T12:00:00Z"
+        }
+      ]
+    },
+    {
+      "List": "updates",
+      "Email": "foo@test.com",
+      "Subscriber": null,
+      "Tombstone": null,
+      "Suppressed": false,
+      "Erased": false,
+      "History": []
+    }
+  ]
+}`
+
+func TestExportSubscriber(t *testing.T) {
+	setup := func() (f *CommandTestFixture, lambda *TestEListManFunc) {
+		lambda = NewTestEListManFunc()
+		cmd := newExportSubscriberCmd(lambda.GetFactoryFunc())
+		f = NewCommandTestFixture(cmd)
+		f.Cmd.SetArgs([]string{"-s", TestStackName, "foo@test.com"})
+		return
+	}
+
+	t.Run("Succeeds", func(t *testing.T) {
+		f, lambda := setup()
+		lambda.SetResponseJson(testExportSubscriberJson)
+
+		const expectedOut = `[
+  {
+    "List": "",
+    "Email": "foo@test.com",
+    "Subscriber": null,
+    "Tombstone": {
+      "Email": "foo@test.com",
+      "Reason": "unsubscribe",
+      "Timestamp": "2023-09-25T12:00:00Z"
+    },
+    "Suppressed": false,
+    "Erased": false,
+    "History": [
+      {
+        "Email": "foo@test.com",
+        "Operation": "unsubscribe",
+        "Reason": "",
+        "Source": "api",
+        "RequestId": "request-0",
+        "Timestamp": "2023-09-25T12:00:00Z"
+      }
+    ]
+  },
+  {
+    "List": "updates",
+    "Email": "foo@test.com",
+    "Subscriber": null,
+    "Tombstone": null,
+    "Suppressed": false,
+    "Erased": false,
+    "History": []
+  }
+]
+`
+		f.ExecuteAndAssertStdoutContains(t, expectedOut)
+
+		expectedReq := &events.CommandLineEvent{
+			EListManCommand: events.CommandLineExportSubscriberEvent,
+			ExportSubscriber: &events.ExportSubscriberEvent{
+				Email: "foo@test.com",
+			},
+		}
+		lambda.AssertMatches(t, TestStackName, expectedReq)
+	})
+
+	t.Run("RequiresAddress", func(t *testing.T) {
+		f, _ := setup()
+		f.Cmd.SetArgs([]string{"-s", TestStackName})
+
+		err := f.Cmd.Execute()
+
+		assert.ErrorContains(t, err, "accepts 1 arg(s), received 0")
+	})
+
+	t.Run("RequiresStackNameFlag", func(t *testing.T) {
+		f, _ := setup()
+		f.AssertFailsIfRequiredFlagMissing(
+			t, FlagStackName, []string{"foo@test.com"},
+		)
+	})
+
+	t.Run("FailsIfInvokingLambdaFails", func(t *testing.T) {
+		f, lambda := setup()
+		const expectedErr = "failed to export subscriber data: "
+		f.AssertReturnsLambdaError(t, lambda, expectedErr)
+	})
+
+	t.Run("FailsIfLambdaReturnsError", func(t *testing.T) {
+		f, lambda := setup()
+		lambda.SetResponseJson(`{"Success": false, "Details": "test failure"}`)
+
+		const expectedErr = "failed to export subscriber data: test failure"
+		f.ExecuteAndAssertErrorContains(t, expectedErr)
+	})
+}
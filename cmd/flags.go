@@ -3,6 +3,25 @@ package cmd
 import "github.com/spf13/cobra"
 
 const FlagStackName = "stack-name"
+const FlagResume = "resume"
+const FlagSendAt = "at"
+const FlagIdempotencyKey = "idempotency-key"
+const FlagMarkdown = "markdown"
+const FlagAddr = "addr"
+const FlagOutbox = "outbox"
+const FlagDomain = "domain"
+const FlagTitle = "title"
+const FlagList = "list"
+const FlagTags = "tags"
+const FlagGracePeriod = "grace-period"
+const FlagBatchSize = "batch-size"
+const FlagDbFile = "db-file"
+const FlagPostgresUrl = "postgres-url"
+const FlagTable = "table"
+const FlagStatus = "status"
+const FlagSince = "since"
+const FlagOutput = "output"
+const FlagErasureSalt = "erasure-salt"
 
 func registerStackName(cmd *cobra.Command) {
 	cmd.Flags().StringP(
@@ -15,6 +34,44 @@ func getStackName(cmd *cobra.Command) string {
 	return getStringFlag(cmd, FlagStackName)
 }
 
+func registerList(cmd *cobra.Command) {
+	cmd.Flags().StringP(
+		FlagList, "l", "", "name of the target list, if not the default list",
+	)
+}
+
+func getListName(cmd *cobra.Command) string {
+	return getStringFlag(cmd, FlagList)
+}
+
+func getResumeId(cmd *cobra.Command) string {
+	return getStringFlag(cmd, FlagResume)
+}
+
+func getSendAt(cmd *cobra.Command) string {
+	return getStringFlag(cmd, FlagSendAt)
+}
+
+func getIdempotencyKey(cmd *cobra.Command) string {
+	return getStringFlag(cmd, FlagIdempotencyKey)
+}
+
+func getTagFilter(cmd *cobra.Command) string {
+	return getStringFlag(cmd, FlagTags)
+}
+
+func registerMarkdown(cmd *cobra.Command) {
+	cmd.Flags().String(
+		FlagMarkdown, "",
+		"Markdown file providing the message body instead of "+
+			"TextBody and HtmlBody",
+	)
+}
+
+func getMarkdownPath(cmd *cobra.Command) string {
+	return getStringFlag(cmd, FlagMarkdown)
+}
+
 func getStringFlag(cmd *cobra.Command, flagName string) (value string) {
 	if f := cmd.Flag(flagName); f != nil {
 		value = f.Value.String()
@@ -25,6 +25,15 @@ func TestGetStringFlag(t *testing.T) {
 	})
 }
 
+func TestListFlag(t *testing.T) {
+	cmd := &cobra.Command{}
+	registerList(cmd)
+	err := cmd.ParseFlags([]string{"-l", "updates"})
+
+	assert.NilError(t, err)
+	assert.Equal(t, "updates", getListName(cmd))
+}
+
 func TestStackNameFlag(t *testing.T) {
 	cmd := &cobra.Command{}
 	registerStackName(cmd)
@@ -0,0 +1,121 @@
+// Copyright © 2023 Mike Bland <mbland@acm.org>
+// See LICENSE.txt for details.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mbland/elistman/db"
+	"github.com/mbland/elistman/events"
+	"github.com/spf13/cobra"
+)
+
+const historyDescription = `` +
+	`Shows the history of status changes for an email address
+
+EListMan records an audit event every time an address subscribes, verifies,
+unsubscribes, is imported, is removed due to a bounce or complaint, or is
+restored. Each event records the operation, the time it happened, where the
+request came from, and the request ID, if any.
+
+The source of each event is one of:
+
+  api:    a request to the API, identified by its API Gateway request ID
+  mailto: an unsubscribe email, identified by its SES message ID
+  sns:    an SES bounce or complaint notification, identified by the SES
+          message ID of the original message
+  cli:    a command from this program, identified by its Lambda request ID
+
+Events remain even after the address is removed from the list.`
+
+const historyTimeFormat = time.RFC3339
+
+func init() {
+	rootCmd.AddCommand(newHistoryCmd(NewEListManLambda))
+}
+
+func newHistoryCmd(newFunc EListManFactoryFunc) (cmd *cobra.Command) {
+	cmd = &cobra.Command{
+		Use:   "history ADDRESS",
+		Short: "Show the history of status changes for an email address",
+		Long:  historyDescription,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, argv []string) error {
+			return showHistory(cmd, newFunc, getStackName(cmd), argv[0])
+		},
+	}
+	registerStackName(cmd)
+	registerList(cmd)
+	cmd.MarkFlagRequired(FlagStackName)
+	return
+}
+
+func showHistory(
+	cmd *cobra.Command,
+	newFunc EListManFactoryFunc,
+	stackName string,
+	address string,
+) (err error) {
+	var history []*db.AuditEvent
+
+	if history, err = getHistory(cmd, newFunc, stackName, address); err != nil {
+		return
+	} else if len(history) == 0 {
+		cmd.Printf("No history found for %s.\n", address)
+		return
+	}
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tOPERATION\tREASON\tSOURCE\tREQUEST ID")
+
+	for _, e := range history {
+		fmt.Fprintf(
+			w,
+			"%s\t%s\t%s\t%s\t%s\n",
+			e.Timestamp.Format(historyTimeFormat),
+			e.Operation,
+			orDash(string(e.Reason)),
+			orDash(e.Source),
+			orDash(e.RequestId),
+		)
+	}
+	return w.Flush()
+}
+
+func getHistory(
+	cmd *cobra.Command,
+	newFunc EListManFactoryFunc,
+	stackName string,
+	address string,
+) (history []*db.AuditEvent, err error) {
+	cmd.SilenceUsage = true
+	ctx := context.Background()
+	evt := &events.CommandLineEvent{
+		EListManCommand: events.CommandLineHistoryEvent,
+		History: &events.HistoryEvent{
+			List: getListName(cmd), Email: address,
+		},
+	}
+	response := &events.HistoryResponse{}
+
+	if err = newFunc.Invoke(ctx, stackName, evt, response); err != nil {
+		err = fmt.Errorf("failed to get history: %w", err)
+	} else if !response.Success {
+		err = errors.New("failed to get history: " + response.Details)
+	} else {
+		history = response.Events
+	}
+	return
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,110 @@
+//go:build small_tests || all_tests
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/mbland/elistman/events"
+	"gotest.tools/assert"
+)
+
+const testHistoryJson = `{
+  "Success": true,
+  "Events": [
+    {
+      "Email": "foo@test.com",
+      "Operation": "subscribe",
+      "Source": "api",
+      "RequestId": "request-0",
+      "Timestamp": "2023-09-18T12:00:00Z"
+    },
+    {
+      "Email": "foo@test.com",
+      "Operation": "verify",
+      "Source": "api",
+      "RequestId": "request-1",
+      "Timestamp": "2023-09-18T12:05:00Z"
+    },
+    {
+      "Email": "foo@test.com",
+      "Operation": "remove",
+      "Reason": "bounce",
+      "Source": "sns",
+      "RequestId": "message-id",
+      "Timestamp": "2023-09-25T12:00:00Z"
+    },
+    {
+      "Email": "foo@test.com",
+      "Operation": "restore",
+      "Timestamp": "2023-09-26T12:00:00Z"
+    }
+  ]
+}`
+
+func TestHistory(t *testing.T) {
+	setup := func() (f *CommandTestFixture, lambda *TestEListManFunc) {
+		lambda = NewTestEListManFunc()
+		cmd := newHistoryCmd(lambda.GetFactoryFunc())
+		f = NewCommandTestFixture(cmd)
+		f.Cmd.SetArgs([]string{"-s", TestStackName, "foo@test.com"})
+		return
+	}
+
+	t.Run("Succeeds", func(t *testing.T) {
+		f, lambda := setup()
+		lambda.SetResponseJson(testHistoryJson)
+
+		const expectedOut = "" +
+			"TIME                  OPERATION  REASON  SOURCE  REQUEST ID\n" +
+			"2023-09-18T12:00:00Z  subscribe  -       api     request-0\n" +
+			"2023-09-18T12:05:00Z  verify     -       api     request-1\n" +
+			"2023-09-25T12:00:00Z  remove     bounce  sns     message-id\n" +
+			"2023-09-26T12:00:00Z  restore    -       -       -\n"
+		f.ExecuteAndAssertStdoutContains(t, expectedOut)
+
+		expectedReq := &events.CommandLineEvent{
+			EListManCommand: events.CommandLineHistoryEvent,
+			History:         &events.HistoryEvent{Email: "foo@test.com"},
+		}
+		lambda.AssertMatches(t, TestStackName, expectedReq)
+	})
+
+	t.Run("ReportsIfNoHistoryFound", func(t *testing.T) {
+		f, lambda := setup()
+		lambda.SetResponseJson(`{"Success": true, "Events": []}`)
+
+		f.ExecuteAndAssertStdoutContains(
+			t, "No history found for foo@test.com.\n",
+		)
+	})
+
+	t.Run("RequiresAddress", func(t *testing.T) {
+		f, _ := setup()
+		f.Cmd.SetArgs([]string{"-s", TestStackName})
+
+		err := f.Cmd.Execute()
+
+		assert.ErrorContains(t, err, "accepts 1 arg(s), received 0")
+	})
+
+	t.Run("RequiresStackNameFlag", func(t *testing.T) {
+		f, _ := setup()
+		f.AssertFailsIfRequiredFlagMissing(
+			t, FlagStackName, []string{"foo@test.com"},
+		)
+	})
+
+	t.Run("FailsIfInvokingLambdaFails", func(t *testing.T) {
+		f, lambda := setup()
+		f.AssertReturnsLambdaError(t, lambda, "failed to get history: ")
+	})
+
+	t.Run("FailsIfLambdaReturnsError", func(t *testing.T) {
+		f, lambda := setup()
+		lambda.SetResponseJson(`{"Success": false, "Details": "test failure"}`)
+
+		const expectedErr = "failed to get history: test failure"
+		f.ExecuteAndAssertErrorContains(t, expectedErr)
+	})
+}
@@ -10,6 +10,7 @@ import (
 	"io"
 	"strings"
 
+	"github.com/mbland/elistman/agent"
 	"github.com/mbland/elistman/events"
 	"github.com/spf13/cobra"
 )
@@ -19,6 +20,15 @@ const importDescription = `` +
 
 Reads the list of addresses from standard input, one address per line.
 
+Each line may also contain the subscriber's first name and any other attributes
+used to personalize messages, separated by tabs:
+
+  ADDRESS<TAB>FIRST NAME<TAB>NAME=VALUE<TAB>NAME=VALUE...
+
+The first name may be empty. Attribute names must be valid message variable
+names, such as "City" or "plan_type", other than "Email", "FirstName", or
+"UnsubscribeUrl".
+
 This is useful for importing a list of existing subscribers from a previous
 system. Will not import addresses that fail validation, and will not override
 records for existing verified subscribers.
@@ -38,6 +48,7 @@ func newImportCmd(newFunc EListManFactoryFunc) (cmd *cobra.Command) {
 		},
 	}
 	registerStackName(cmd)
+	registerList(cmd)
 	cmd.MarkFlagRequired(FlagStackName)
 	return
 }
@@ -46,17 +57,22 @@ func importAddresses(
 	cmd *cobra.Command, newFunc EListManFactoryFunc, stackName string,
 ) (err error) {
 	cmd.SilenceUsage = true
-	var addresses []string
+	var lines []string
+	var importEvent *events.ImportEvent
 
-	if addresses, err = readLines(cmd.InOrStdin()); err != nil {
+	if lines, err = readLines(cmd.InOrStdin()); err != nil {
 		err = fmt.Errorf("failed to read email addresses from stdin: %w", err)
 		return
+	} else if importEvent, err = parseImportLines(lines); err != nil {
+		return
 	}
+	addresses := importEvent.Addresses
+	importEvent.List = getListName(cmd)
 
 	ctx := context.Background()
 	evt := &events.CommandLineEvent{
 		EListManCommand: events.CommandLineImportEvent,
-		Import:          &events.ImportEvent{Addresses: addresses},
+		Import:          importEvent,
 	}
 	response := &events.ImportResponse{}
 
@@ -79,6 +95,54 @@ func readLines(stdin io.Reader) (lines []string, err error) {
 	return
 }
 
+// parseImportLines parses each line into an address and an optional
+// agent.Profile.
+func parseImportLines(lines []string) (*events.ImportEvent, error) {
+	evt := &events.ImportEvent{Addresses: make([]string, 0, len(lines))}
+
+	for i, line := range lines {
+		address, profile, err := parseImportLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		evt.Addresses = append(evt.Addresses, address)
+
+		if profile == nil {
+			continue
+		} else if evt.Profiles == nil {
+			evt.Profiles = map[string]*agent.Profile{}
+		}
+		evt.Profiles[address] = profile
+	}
+	return evt, nil
+}
+
+func parseImportLine(line string) (string, *agent.Profile, error) {
+	fields := strings.Split(line, "\t")
+	address := fields[0]
+
+	if len(fields) == 1 {
+		return address, nil, nil
+	}
+	profile := &agent.Profile{FirstName: strings.TrimSpace(fields[1])}
+
+	for _, attr := range fields[2:] {
+		name, value, ok := strings.Cut(attr, "=")
+		if !ok {
+			const errFmt = "attribute not in NAME=VALUE format: %q"
+			return "", nil, fmt.Errorf(errFmt, attr)
+		} else if profile.Attributes == nil {
+			profile.Attributes = map[string]string{}
+		}
+		profile.Attributes[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+
+	if profile.FirstName == "" && len(profile.Attributes) == 0 {
+		profile = nil
+	}
+	return address, profile, nil
+}
+
 func importSuccessMessage(numImported, total int) string {
 	if numImported == 1 {
 		return "Successfully imported one address.\n"
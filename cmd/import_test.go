@@ -7,6 +7,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/mbland/elistman/agent"
 	"github.com/mbland/elistman/events"
 	"gotest.tools/assert"
 )
@@ -34,6 +35,54 @@ func TestReadLines(t *testing.T) {
 	})
 }
 
+func TestParseImportLines(t *testing.T) {
+	t.Run("SucceedsWithAddressesOnly", func(t *testing.T) {
+		evt, err := parseImportLines([]string{"foo@test.com", "bar@test.com"})
+
+		assert.NilError(t, err)
+		expected := &events.ImportEvent{
+			Addresses: []string{"foo@test.com", "bar@test.com"},
+		}
+		assert.DeepEqual(t, expected, evt)
+	})
+
+	t.Run("SucceedsWithProfiles", func(t *testing.T) {
+		evt, err := parseImportLines([]string{
+			"foo@test.com\tFoo\tCity=Chicago\tplan = free",
+			"bar@test.com\t\tCity=Boston",
+			"baz@test.com\t",
+		})
+
+		assert.NilError(t, err)
+		expected := &events.ImportEvent{
+			Addresses: []string{"foo@test.com", "bar@test.com", "baz@test.com"},
+			Profiles: map[string]*agent.Profile{
+				"foo@test.com": {
+					FirstName: "Foo",
+					Attributes: map[string]string{
+						"City": "Chicago", "plan": "free",
+					},
+				},
+				"bar@test.com": {
+					Attributes: map[string]string{"City": "Boston"},
+				},
+			},
+		}
+		assert.DeepEqual(t, expected, evt)
+	})
+
+	t.Run("FailsIfAttributeIsMalformed", func(t *testing.T) {
+		evt, err := parseImportLines([]string{
+			"foo@test.com", "bar@test.com\tBar\tChicago",
+		})
+
+		assert.Assert(t, evt == nil)
+		const expectedErr = `line 2: attribute not in NAME=VALUE format: ` +
+			`"Chicago"`
+		assert.Error(t, err, expectedErr)
+	})
+}
+
 func TestImportSuccess(t *testing.T) {
 	t.Run("Singular", func(t *testing.T) {
 		msg := importSuccessMessage(1, 1000)
@@ -104,11 +153,59 @@ func TestImport(t *testing.T) {
 		lambda.AssertMatches(t, TestStackName, expectedReq)
 	})
 
+	t.Run("SucceedsImportingToList", func(t *testing.T) {
+		f, lambda := setup()
+		f.Cmd.SetArgs([]string{"-s", TestStackName, "-l", "updates"})
+		lambda.SetResponseJson(`{"NumImported": 3}`)
+
+		const expectedOut = "Successfully imported 3 of 3 addresses.\n"
+		f.ExecuteAndAssertStdoutContains(t, expectedOut)
+
+		expectedReq := &events.CommandLineEvent{
+			EListManCommand: events.CommandLineImportEvent,
+			Import: &events.ImportEvent{
+				List: "updates", Addresses: addrs,
+			},
+		}
+		lambda.AssertMatches(t, TestStackName, expectedReq)
+	})
+
+	t.Run("SucceedsImportingProfiles", func(t *testing.T) {
+		f, lambda := setup()
+		f.Cmd.SetIn(strings.NewReader("foo@test.com\tFoo\tCity=Chicago\n"))
+		lambda.SetResponseJson(`{"NumImported": 1}`)
+
+		const expectedOut = "Successfully imported one address.\n"
+		f.ExecuteAndAssertStdoutContains(t, expectedOut)
+
+		expectedReq := &events.CommandLineEvent{
+			EListManCommand: events.CommandLineImportEvent,
+			Import: &events.ImportEvent{
+				Addresses: []string{"foo@test.com"},
+				Profiles: map[string]*agent.Profile{
+					"foo@test.com": {
+						FirstName:  "Foo",
+						Attributes: map[string]string{"City": "Chicago"},
+					},
+				},
+			},
+		}
+		lambda.AssertMatches(t, TestStackName, expectedReq)
+	})
+
 	t.Run("RequiresStackNameFlag", func(t *testing.T) {
 		f, _ := setup()
 		f.AssertFailsIfRequiredFlagMissing(t, FlagStackName, []string{})
 	})
 
+	t.Run("FailsIfCannotParseLine", func(t *testing.T) {
+		f, _ := setup()
+		f.Cmd.SetIn(strings.NewReader("foo@test.com\tFoo\tChicago\n"))
+
+		const expectedErr = "line 1: attribute not in NAME=VALUE format"
+		f.ExecuteAndAssertErrorContains(t, expectedErr)
+	})
+
 	t.Run("FailsIfCannotReadAddressesFromStdin", func(t *testing.T) {
 		f, _ := setup()
 		f.Cmd.SetIn(&errReader{})
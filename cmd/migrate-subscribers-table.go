@@ -0,0 +1,68 @@
+// Copyright © 2023 Mike Bland <mbland@acm.org>
+// See LICENSE.txt for details.
+
+package cmd
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/mbland/elistman/db"
+	"github.com/spf13/cobra"
+)
+
+const migrateSubscribersTableDescription = `` +
+	`Adds any indexes missing from an existing DynamoDB subscribers table.
+
+Tables created by earlier versions of create-subscribers-table lack the
+"scheduled" index for scheduled messages and the "tags" index for sending to
+tagged subscribers. This command adds each missing index, one at a time, waiting
+for DynamoDB to finish building each one before adding the next. It does nothing
+if the table already has every index.
+
+The command takes one argument, which is the name of the table to migrate. If
+it times out while DynamoDB is still building an index, run it again to wait
+for that index and add any that remain.`
+
+// migrateMaxWaitDuration defines how long migrate-subscribers-table waits for
+// each new index to finish building from the existing table items.
+const migrateMaxWaitDuration = 30 * time.Minute
+
+func init() {
+	rootCmd.AddCommand(newMigrateSubscribersTableCmd(NewDynamoDb))
+}
+
+func newMigrateSubscribersTableCmd(
+	newDynDb DynamoDbFactoryFunc,
+) *cobra.Command {
+	return &cobra.Command{
+		Use:   "migrate-subscribers-table",
+		Short: "Add missing indexes to a DynamoDB subscribers table",
+		Long:  migrateSubscribersTableDescription,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return migrateSubscribersTable(
+				cmd, newDynDb(args[0]), migrateMaxWaitDuration,
+			)
+		},
+	}
+}
+
+func migrateSubscribersTable(
+	cmd *cobra.Command, dyndb *db.DynamoDb, maxWaitDuration time.Duration,
+) (err error) {
+	cmd.SilenceUsage = true
+	ctx := context.Background()
+	var created []string
+
+	created, err = dyndb.MigrateSubscribersTable(ctx, maxWaitDuration)
+	if len(created) != 0 {
+		const outFmt = "Created indexes for DynamoDB table %s: %s\n"
+		cmd.Printf(outFmt, dyndb.TableName, strings.Join(created, ", "))
+	}
+	if err == nil && len(created) == 0 {
+		cmd.Printf("DynamoDB table %s is up to date\n", dyndb.TableName)
+	}
+	return
+}
@@ -0,0 +1,98 @@
+//go:build small_tests || all_tests
+
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/mbland/elistman/db"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestMigrateSubscribersTable(t *testing.T) {
+	const TableName = "elistman-subscribers"
+
+	setup := func(
+		existing ...string,
+	) (f *CommandTestFixture, client *db.TestDynamoDbClient) {
+		client = db.NewTestDynamoDbClient()
+		table := client.DescTableOutput.Table
+		for _, name := range existing {
+			table.GlobalSecondaryIndexes = append(
+				table.GlobalSecondaryIndexes,
+				types.GlobalSecondaryIndexDescription{
+					IndexName:   aws.String(name),
+					IndexStatus: types.IndexStatusActive,
+				},
+			)
+		}
+		f = NewCommandTestFixture(
+			newMigrateSubscribersTableCmd(
+				func(tableName string) *db.DynamoDb {
+					return &db.DynamoDb{Client: client, TableName: tableName}
+				},
+			),
+		)
+		f.Cmd.SetArgs([]string{TableName})
+		return
+	}
+
+	t.Run("CreatesMissingIndexes", func(t *testing.T) {
+		f, client := setup(
+			db.DynamoDbPendingIndexName, db.DynamoDbVerifiedIndexName,
+		)
+
+		f.ExecuteAndAssertStdoutContains(
+			t,
+			"Created indexes for DynamoDB table "+TableName+
+				": scheduled, tags\n",
+		)
+		assert.Assert(t, f.Cmd.SilenceUsage == true)
+		assert.Equal(t, 2, len(client.UpdateTableInputs))
+	})
+
+	t.Run("ReportsTableIsUpToDate", func(t *testing.T) {
+		f, client := setup(
+			db.DynamoDbPendingIndexName,
+			db.DynamoDbVerifiedIndexName,
+			db.DynamoDbScheduledIndexName,
+			db.DynamoDbTagIndexName,
+		)
+
+		f.ExecuteAndAssertStdoutContains(
+			t, "DynamoDB table "+TableName+" is up to date\n",
+		)
+		assert.Equal(t, 0, len(client.UpdateTableInputs))
+	})
+
+	t.Run("ReportsIndexesCreatedBeforeTimingOut", func(t *testing.T) {
+		f, client := setup(
+			db.DynamoDbPendingIndexName, db.DynamoDbVerifiedIndexName,
+		)
+		client.UpdateTableIndexStatus = types.IndexStatusCreating
+		dyndb := &db.DynamoDb{Client: client, TableName: TableName}
+
+		err := migrateSubscribersTable(f.Cmd, dyndb, time.Nanosecond)
+
+		assert.ErrorContains(t, err, `index "scheduled" still CREATING`)
+		assert.Assert(
+			t,
+			is.Contains(
+				f.Stdout.String(),
+				"Created indexes for DynamoDB table "+TableName+
+					": scheduled\n",
+			),
+		)
+	})
+
+	t.Run("FailsOnDynamodDbClientError", func(t *testing.T) {
+		f, client := setup()
+		client.SetUpdateTableError("update table test error")
+
+		f.ExecuteAndAssertErrorContains(t, "update table test error")
+	})
+}
@@ -0,0 +1,33 @@
+// Copyright © 2023 Mike Bland <mbland@acm.org>
+// See LICENSE.txt for details.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/mbland/elistman/db"
+)
+
+// PostgresTables creates the tables for a PostgreSQL database.
+//
+// db.PostgresDb implements it.
+type PostgresTables interface {
+	CreateSubscribersTable(ctx context.Context) error
+}
+
+type PostgresDbFactoryFunc func(url, tableName string) (PostgresTables, error)
+
+func NewPostgresDb(url, tableName string) (PostgresTables, error) {
+	return newPostgresDb(url, tableName)
+}
+
+func newPostgresDb(url, tableName string) (*db.PostgresDb, error) {
+	pool, err := pgxpool.New(context.Background(), url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure PostgreSQL: %w", err)
+	}
+	return db.NewPostgresDb(pool, tableName), nil
+}
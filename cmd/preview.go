@@ -17,7 +17,11 @@ const previewDescription = `` +
 ` + email.ExampleMessageJson + `
 
 If the input passes validation, it then emits a raw email message to standard
-output representing what would be sent to each mailing list member.`
+output representing what would be sent to each mailing list member.
+
+If the --markdown flag specifies a Markdown file, it will generate the TextBody
+and HtmlBody of the message from that file. The JSON input must then omit
+TextBody and HtmlBody.`
 
 func newPreviewCommand() *cobra.Command {
 	var emitExample bool
@@ -32,13 +36,18 @@ func newPreviewCommand() *cobra.Command {
 			if emitExample {
 				input = strings.NewReader(email.ExampleMessageJson)
 			}
-			return email.EmitPreviewMessageFromJson(input, cmd.OutOrStdout())
+			msg, err := readMessage(input, getMarkdownPath(cmd))
+			if err != nil {
+				return err
+			}
+			return email.EmitPreviewMessage(msg, cmd.OutOrStdout())
 		},
 	}
 	previewCmd.Flags().BoolVarP(
 		&emitExample, "example", "x", false,
 		"Use the help example to generate the preview",
 	)
+	registerMarkdown(previewCmd)
 	return previewCmd
 }
 
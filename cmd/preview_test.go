@@ -32,6 +32,25 @@ func TestPreview(t *testing.T) {
 		f.ExecuteAndAssertStdoutContains(t, "Hola, Mundo!")
 	})
 
+	t.Run("SucceedsWithMarkdownFile", func(t *testing.T) {
+		f := setup()
+		f.Cmd.SetIn(strings.NewReader(testMarkdownMessageJson))
+		path := writeMarkdownFile(t, "Hello, *World*!")
+		f.Cmd.SetArgs([]string{"--markdown", path})
+
+		f.ExecuteAndAssertStdoutContains(t, "<p>Hello, <em>World</em>!</p>")
+		assert.Assert(t, strings.Contains(f.Stdout.String(), "Hello, _World_!"))
+	})
+
+	t.Run("FailsIfMarkdownConflictsWithExample", func(t *testing.T) {
+		f := setup()
+		path := writeMarkdownFile(t, "Hello, *World*!")
+		f.Cmd.SetArgs([]string{"--example", "--markdown", path})
+
+		const expectedMsg = "MarkdownBody present, but so is TextBody"
+		f.ExecuteAndAssertErrorContains(t, expectedMsg)
+	})
+
 	t.Run("PassesThroughParseError", func(t *testing.T) {
 		f := setup()
 		f.Cmd.SetIn(strings.NewReader("not a JSON message object"))
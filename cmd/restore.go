@@ -0,0 +1,216 @@
+// Copyright © 2023 Mike Bland <mbland@acm.org>
+// See LICENSE.txt for details.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/google/uuid"
+	"github.com/mbland/elistman/agent"
+	"github.com/mbland/elistman/db"
+	"github.com/spf13/cobra"
+)
+
+const restoreDescription = `` +
+	`Writes subscriber records from "elistman backup" into a subscribers table
+
+Reads JSON lines produced by "elistman backup" from standard input, and writes
+each subscriber record into the table exactly as it appears, including its UID
+and timestamp. Unsubscribe links in messages sent before the backup keep working
+after a restore, even into a different table or database.
+
+The --table flag names the subscribers table, i.e., the SUBSCRIBERS_TABLE_NAME
+of the EListMan instance. The table lives in DynamoDB unless --postgres-url
+specifies a PostgreSQL database. Either way, the table must already exist.
+
+Each record goes to the list named by its List field. Restoring replaces any
+existing record for the same address in that list, but leaves every other
+record alone. The backup's erasure records and tombstones are restored, too.
+
+Restore skips the subscriber records and tombstones of any address that
+"elistman erase" erased, so that restoring a backup made before the erasure
+won't add it back. Recognizing erased addresses requires the ERASURE_SALT of the
+EListMan instance, either from the --erasure-salt flag or the ERASURE_SALT
+environment variable. Without it, restore warns that it can't skip erased
+addresses.
+
+Restore also skips any subscriber record older than the address's tombstone,
+since the address left the list after the backup saved the record. It reports
+each record it skips on standard error.
+
+Example:
+  elistman restore --table elistman-subscribers < subscribers.jsonl`
+
+func init() {
+	rootCmd.AddCommand(newRestoreCmd(NewSubscriberDb))
+}
+
+func newRestoreCmd(newDb SubscriberDbFactoryFunc) (cmd *cobra.Command) {
+	cmd = &cobra.Command{
+		Use:   "restore",
+		Short: "Restore subscriber records from \"elistman backup\"",
+		Long:  restoreDescription,
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return restoreSubscribers(cmd, newDb)
+		},
+	}
+	registerSubscriberDb(cmd)
+	registerErasureSalt(cmd)
+	return
+}
+
+func restoreSubscribers(
+	cmd *cobra.Command, newDb SubscriberDbFactoryFunc,
+) (err error) {
+	cmd.SilenceUsage = true
+	var forList SubscriberDbFunc
+
+	if forList, err = newSubscriberDb(cmd, newDb); err != nil {
+		return
+	}
+
+	ctx := context.Background()
+	decoder := json.NewDecoder(cmd.InOrStdin())
+	salt := getErasureSalt(cmd)
+	counts := &backupCounts{}
+	numSkipped := 0
+
+	if salt == "" {
+		cmd.PrintErrln(
+			"WARNING: no erasure salt set, so erased addresses may be restored",
+		)
+	}
+
+	for {
+		rec := &backupRecord{}
+
+		if err = decoder.Decode(rec); errors.Is(err, io.EOF) {
+			break
+		} else if err == nil {
+			err = restoreRecord(ctx, forList(rec.List), salt, rec)
+		}
+		if errors.Is(err, agent.ErrAddressErased) ||
+			errors.Is(err, agent.ErrAddressLeftList) {
+			cmd.PrintErrln("Skipped record:", err)
+			numSkipped++
+			continue
+		} else if err != nil {
+			const errFmt = "restore failed after %s: %w"
+			return fmt.Errorf(errFmt, counts, err)
+		}
+		counts.add(rec)
+	}
+	cmd.Printf("Restored %s.\n", counts)
+	if numSkipped != 0 {
+		cmd.Printf("Skipped %d records.\n", numSkipped)
+	}
+	return nil
+}
+
+func restoreRecord(
+	ctx context.Context, listDb SubscriberDb, salt string, rec *backupRecord,
+) (err error) {
+	if err = validateBackupRecord(rec); err != nil {
+		return
+	} else if rec.Erasure != "" {
+		return listDb.PutErasure(ctx, rec.Erasure)
+	} else if rec.Tombstone != nil {
+		ts := rec.Tombstone
+
+		if err = checkErasure(ctx, listDb, salt, ts.Email); err == nil {
+			err = listDb.PutTombstone(ctx, ts)
+		}
+		return
+	}
+
+	sub := rec.Subscriber
+	sub.List = rec.List
+
+	if err = checkErasure(ctx, listDb, salt, sub.Email); err != nil {
+		return
+	} else if err = checkTombstone(ctx, listDb, sub); err == nil {
+		err = listDb.Put(ctx, sub)
+	}
+	return
+}
+
+// checkErasure returns an error wrapping agent.ErrAddressErased if "elistman
+// erase" erased the address before.
+//
+// Like agent.ProdAgent, it can't recognize erased addresses without the salt,
+// so it returns nil if salt is empty.
+func checkErasure(
+	ctx context.Context, erasures db.ErasureStore, salt, address string,
+) (err error) {
+	var erased bool
+
+	if salt == "" {
+		return
+	} else if erased, err = erasures.IsErased(
+		ctx, db.ErasureHash(salt, address),
+	); err == nil && erased {
+		err = fmt.Errorf("%w: %s", agent.ErrAddressErased, address)
+	}
+	return
+}
+
+// checkTombstone returns an error wrapping agent.ErrAddressLeftList if the
+// subscriber's address left the list after the backup saved its record.
+//
+// A subscriber record newer than the tombstone belongs to an address that
+// subscribed again after leaving, so checkTombstone returns nil for it.
+func checkTombstone(
+	ctx context.Context, tombstones db.TombstoneStore, sub *db.Subscriber,
+) (err error) {
+	var ts *db.Tombstone
+
+	ts, err = tombstones.GetTombstone(ctx, sub.Email)
+	if errors.Is(err, db.ErrTombstoneNotFound) {
+		err = nil
+	} else if err == nil && ts.Timestamp.After(sub.Timestamp) {
+		err = fmt.Errorf("%w: %s", agent.ErrAddressLeftList, sub.Email)
+	}
+	return
+}
+
+func validateBackupRecord(rec *backupRecord) error {
+	numKinds := 0
+
+	for _, isSet := range []bool{
+		rec.Subscriber != nil, rec.Tombstone != nil, rec.Erasure != "",
+	} {
+		if isSet {
+			numKinds++
+		}
+	}
+
+	if numKinds != 1 {
+		return errors.New(
+			"record must contain exactly one subscriber, tombstone, or erasure",
+		)
+	} else if rec.Tombstone != nil && rec.Tombstone.Email == "" {
+		return errors.New("tombstone has no Email")
+	} else if rec.Subscriber != nil {
+		return validateBackupSubscriber(rec.Subscriber)
+	}
+	return nil
+}
+
+func validateBackupSubscriber(sub *db.Subscriber) error {
+	if sub.Email == "" {
+		return errors.New("record has no Email")
+	} else if sub.Uid == uuid.Nil {
+		return fmt.Errorf("record for %s has no Uid", sub.Email)
+	} else if sub.Status != db.SubscriberPending &&
+		sub.Status != db.SubscriberVerified {
+		const errFmt = "record for %s has invalid Status: %q"
+		return fmt.Errorf(errFmt, sub.Email, sub.Status)
+	}
+	return nil
+}
@@ -0,0 +1,335 @@
+//go:build small_tests || all_tests
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mbland/elistman/db"
+	"github.com/mbland/elistman/testdata"
+	"github.com/mbland/elistman/testutils"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+const testErasureSalt = "restore test salt"
+
+const restoredTwoSubscribers = "Restored 2 subscribers, 0 tombstones, " +
+	"and 0 erasures.\n"
+
+// newBackupJsonLines encodes each record on its own line. Encoding
+// db.Subscribers instead of backupRecords produces a backup in the format that
+// predates tombstone and erasure records.
+func newBackupJsonLines[T any](t *testing.T, records []T) string {
+	t.Helper()
+	sb := &strings.Builder{}
+	encoder := json.NewEncoder(sb)
+
+	for _, rec := range records {
+		assert.NilError(t, encoder.Encode(rec))
+	}
+	return sb.String()
+}
+
+func TestRestore(t *testing.T) {
+	setup := func(
+		stdin string,
+	) (f *CommandTestFixture, subDb *TestSubscriberDb) {
+		subDb = NewTestSubscriberDb()
+		f = NewCommandTestFixture(newRestoreCmd(subDb.GetFactoryFunc()))
+		f.Cmd.SetIn(strings.NewReader(stdin))
+		f.Cmd.SetArgs([]string{
+			"--table", TestTableName, "--erasure-salt", testErasureSalt,
+		})
+		return
+	}
+
+	getSubscriber := func(
+		t *testing.T, listDb *db.MemoryDb, email string,
+	) *db.Subscriber {
+		t.Helper()
+		sub, err := listDb.Get(context.Background(), email)
+		assert.NilError(t, err)
+		return sub
+	}
+
+	t.Run("Succeeds", func(t *testing.T) {
+		subs := newBackupTestSubscribers()
+		subs[1].List = "other-list"
+		f, subDb := setup(newBackupJsonLines(t, subs))
+
+		f.ExecuteAndAssertStdoutContains(t, restoredTwoSubscribers)
+
+		assert.Equal(t, TestTableName, subDb.TableName)
+		assert.Equal(t, "", subDb.PostgresUrl)
+		pending := getSubscriber(t, subDb.ForList(""), subs[0].Email)
+		assert.DeepEqual(t, subs[0], pending)
+		otherList := subDb.ForList("other-list")
+		assert.DeepEqual(t, subs[1], getSubscriber(t, otherList, subs[1].Email))
+	})
+
+	t.Run("RestoresBackupIntoPostgres", func(t *testing.T) {
+		ctx := context.Background()
+		backupDb := NewTestSubscriberDb()
+		backupListDb := backupDb.ForList("")
+		for _, sub := range newBackupTestSubscribers() {
+			assert.NilError(t, backupListDb.Put(ctx, sub))
+		}
+		tombstone := newBackupTestTombstone()
+		assert.NilError(t, backupListDb.PutTombstone(ctx, tombstone))
+		assert.NilError(t, backupListDb.PutErasure(ctx, "deadbeef"))
+		newBackupDb := backupDb.GetFactoryFunc()
+		backup := NewCommandTestFixture(newBackupCmd(newBackupDb))
+		backup.Cmd.SetArgs([]string{"--table", TestTableName})
+		assert.NilError(t, backup.Cmd.Execute())
+
+		f, subDb := setup(backup.Stdout.String())
+		f.Cmd.SetArgs([]string{
+			"--table", TestTableName,
+			"--postgres-url", TestPostgresUrl,
+			"--erasure-salt", testErasureSalt,
+		})
+
+		f.ExecuteAndAssertStdoutContains(
+			t, "Restored 2 subscribers, 1 tombstones, and 1 erasures.\n",
+		)
+
+		assert.Equal(t, TestPostgresUrl, subDb.PostgresUrl)
+		listDb := subDb.ForList("")
+		for _, sub := range newBackupTestSubscribers() {
+			restored := getSubscriber(t, listDb, sub.Email)
+			assert.DeepEqual(t, sub, restored)
+		}
+		restoredTombstone, err := listDb.GetTombstone(ctx, tombstone.Email)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, tombstone, restoredTombstone)
+		erased, err := listDb.IsErased(ctx, "deadbeef")
+		assert.NilError(t, err)
+		assert.Assert(t, erased)
+	})
+
+	t.Run("SkipsErasedAddresses", func(t *testing.T) {
+		subs := newBackupTestSubscribers()
+		f, subDb := setup(newBackupJsonLines(t, subs))
+		upperEmail := strings.ToUpper(subs[1].Email)
+		erased := db.ErasureHash(testErasureSalt, upperEmail)
+		ctx := context.Background()
+		assert.NilError(t, subDb.ForList("").PutErasure(ctx, erased))
+
+		assert.NilError(t, f.Cmd.Execute())
+
+		const expectedOut = "Restored 1 subscribers, 0 tombstones, " +
+			"and 0 erasures.\nSkipped 1 records.\n"
+		const expectedErr = "Skipped record: address was erased: " +
+			"bar@test.com\n"
+		assert.Equal(t, expectedOut, f.Stdout.String())
+		assert.Equal(t, expectedErr, f.Stderr.String())
+		getSubscriber(t, subDb.ForList(""), subs[0].Email)
+		_, err := subDb.ForList("").Get(ctx, subs[1].Email)
+		assert.Assert(t, testutils.ErrorIs(err, db.ErrSubscriberNotFound))
+	})
+
+	t.Run("SkipsTombstonesOfErasedAddresses", func(t *testing.T) {
+		tombstone := newBackupTestTombstone()
+		stdin := newBackupJsonLines(t, []*backupRecord{
+			{Erasure: db.ErasureHash(testErasureSalt, tombstone.Email)},
+			{Tombstone: tombstone},
+		})
+		f, subDb := setup(stdin)
+
+		assert.NilError(t, f.Cmd.Execute())
+
+		const expectedOut = "Restored 0 subscribers, 0 tombstones, " +
+			"and 1 erasures.\nSkipped 1 records.\n"
+		const expectedErr = "Skipped record: address was erased: " +
+			"baz@test.com\n"
+		assert.Equal(t, expectedOut, f.Stdout.String())
+		assert.Equal(t, expectedErr, f.Stderr.String())
+		_, err := subDb.ForList("").GetTombstone(
+			context.Background(), tombstone.Email,
+		)
+		assert.Assert(t, testutils.ErrorIs(err, db.ErrTombstoneNotFound))
+	})
+
+	t.Run("SkipsSubscribersOlderThanTheirTombstones", func(t *testing.T) {
+		subs := newBackupTestSubscribers()
+		f, subDb := setup(newBackupJsonLines(t, subs))
+		ctx := context.Background()
+		listDb := subDb.ForList("")
+		for _, sub := range subs {
+			// subs[0] subscribed again after leaving the list, but subs[1]
+			// left the list after the backup saved its record.
+			tombstone := &db.Tombstone{
+				Email:     sub.Email,
+				Reason:    db.TombstoneUnsubscribe,
+				Timestamp: testdata.TestTimestamp.Add(time.Minute),
+			}
+			assert.NilError(t, listDb.PutTombstone(ctx, tombstone))
+		}
+
+		assert.NilError(t, f.Cmd.Execute())
+
+		const expectedOut = "Restored 1 subscribers, 0 tombstones, " +
+			"and 0 erasures.\nSkipped 1 records.\n"
+		const expectedErr = "Skipped record: address left the list: " +
+			"bar@test.com\n"
+		assert.Equal(t, expectedOut, f.Stdout.String())
+		assert.Equal(t, expectedErr, f.Stderr.String())
+		assert.DeepEqual(t, subs[0], getSubscriber(t, listDb, subs[0].Email))
+		_, err := listDb.Get(ctx, subs[1].Email)
+		assert.Assert(t, testutils.ErrorIs(err, db.ErrSubscriberNotFound))
+	})
+
+	t.Run("UsesErasureSaltEnvironmentVariable", func(t *testing.T) {
+		subs := newBackupTestSubscribers()
+		f, subDb := setup(newBackupJsonLines(t, subs))
+		f.Cmd.SetArgs([]string{"--table", TestTableName})
+		t.Setenv("ERASURE_SALT", testErasureSalt)
+		erased := db.ErasureHash(testErasureSalt, subs[1].Email)
+		ctx := context.Background()
+		assert.NilError(t, subDb.ForList("").PutErasure(ctx, erased))
+
+		assert.NilError(t, f.Cmd.Execute())
+
+		assert.Assert(t, is.Contains(f.Stdout.String(), "Skipped 1 records.\n"))
+	})
+
+	t.Run("WarnsIfNoErasureSalt", func(t *testing.T) {
+		subs := newBackupTestSubscribers()
+		f, subDb := setup(newBackupJsonLines(t, subs))
+		f.Cmd.SetArgs([]string{"--table", TestTableName})
+		t.Setenv("ERASURE_SALT", "")
+		erased := db.ErasureHash(testErasureSalt, subs[1].Email)
+		ctx := context.Background()
+		assert.NilError(t, subDb.ForList("").PutErasure(ctx, erased))
+
+		assert.NilError(t, f.Cmd.Execute())
+
+		assert.Equal(t, restoredTwoSubscribers, f.Stdout.String())
+		const expectedWarning = "WARNING: no erasure salt set, " +
+			"so erased addresses may be restored\n"
+		assert.Equal(t, expectedWarning, f.Stderr.String())
+	})
+
+	t.Run("FailsIfCheckingErasureFails", func(t *testing.T) {
+		f, subDb := setup(newBackupJsonLines(t, newBackupTestSubscribers()))
+		subDb.IsErasedError = errors.New("erasure check failed")
+
+		const expectedErr = "restore failed after 0 subscribers, " +
+			"0 tombstones, and 0 erasures: erasure check failed"
+		f.ExecuteAndAssertErrorContains(t, expectedErr)
+	})
+
+	t.Run("SucceedsWithEmptyInput", func(t *testing.T) {
+		f, _ := setup("")
+
+		f.ExecuteAndAssertStdoutContains(
+			t, "Restored 0 subscribers, 0 tombstones, and 0 erasures.\n",
+		)
+	})
+
+	t.Run("RequiresTableFlag", func(t *testing.T) {
+		f, _ := setup("")
+		f.AssertFailsIfRequiredFlagMissing(t, FlagTable, []string{})
+	})
+
+	t.Run("FailsIfCreatingDatabaseFails", func(t *testing.T) {
+		f, subDb := setup("")
+		subDb.CreateError = errors.New("create db failed")
+
+		f.ExecuteAndAssertErrorContains(t, "create db failed")
+	})
+
+	t.Run("FailsOnInvalidJson", func(t *testing.T) {
+		stdin := newBackupJsonLines(t, newBackupTestSubscribers()[:1])
+		f, _ := setup(stdin + "not JSON\n")
+
+		const expectedErr = "restore failed after 1 subscribers, " +
+			"0 tombstones, and 0 erasures: invalid character"
+		f.ExecuteAndAssertErrorContains(t, expectedErr)
+	})
+
+	t.Run("FailsOnInvalidRecord", func(t *testing.T) {
+		for _, tc := range []struct {
+			name   string
+			modify func(sub *db.Subscriber)
+			err    string
+		}{
+			{
+				name:   "NoEmail",
+				modify: func(sub *db.Subscriber) { sub.Email = "" },
+				err:    "record has no Email",
+			},
+			{
+				name:   "NoUid",
+				modify: func(sub *db.Subscriber) { sub.Uid = uuid.Nil },
+				err:    "record for foo@test.com has no Uid",
+			},
+			{
+				name:   "InvalidStatus",
+				modify: func(sub *db.Subscriber) { sub.Status = "bogus" },
+				err: "record for foo@test.com has invalid Status: " +
+					`"bogus"`,
+			},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				subs := newBackupTestSubscribers()
+				tc.modify(subs[0])
+				f, _ := setup(newBackupJsonLines(t, subs))
+
+				err := f.ExecuteAndAssertErrorContains(t, tc.err)
+
+				const expectedPrefix = "restore failed after 0 subscribers, " +
+					"0 tombstones, and 0 erasures: "
+				assert.ErrorContains(t, err, expectedPrefix)
+			})
+		}
+	})
+
+	t.Run("FailsOnInvalidTombstoneOrErasureRecord", func(t *testing.T) {
+		for _, tc := range []struct {
+			name  string
+			stdin string
+			err   string
+		}{
+			{
+				name:  "NoRecord",
+				stdin: `{"List": ""}`,
+				err: "record must contain exactly one subscriber, " +
+					"tombstone, or erasure",
+			},
+			{
+				name:  "MultipleRecords",
+				stdin: `{"Erasure": "deadbeef", "Tombstone": {}}`,
+				err: "record must contain exactly one subscriber, " +
+					"tombstone, or erasure",
+			},
+			{
+				name:  "TombstoneHasNoEmail",
+				stdin: `{"Tombstone": {"Reason": "unsubscribe"}}`,
+				err:   "tombstone has no Email",
+			},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				f, _ := setup(tc.stdin)
+
+				f.ExecuteAndAssertErrorContains(t, tc.err)
+			})
+		}
+	})
+
+	t.Run("FailsIfPutFails", func(t *testing.T) {
+		f, subDb := setup(newBackupJsonLines(t, newBackupTestSubscribers()))
+		subDb.PutError = errors.New("put failed")
+
+		const expectedErr = "restore failed after 0 subscribers, " +
+			"0 tombstones, and 0 erasures: put failed"
+		f.ExecuteAndAssertErrorContains(t, expectedErr)
+	})
+}
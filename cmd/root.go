@@ -24,6 +24,9 @@ program that creates message input JSON:
 
 To send an email to the list, given the STACK_NAME of the EListMan instance:
   generate-email | elistman send -s STACK_NAME
+
+To run the API locally, writing messages to .eml files instead of sending them:
+  elistman serve
 `
 
 var rootCmd = &cobra.Command{
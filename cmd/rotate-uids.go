@@ -0,0 +1,114 @@
+// Copyright © 2023 Mike Bland <mbland@acm.org>
+// See LICENSE.txt for details.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mbland/elistman/db"
+	"github.com/mbland/elistman/events"
+	"github.com/spf13/cobra"
+)
+
+const rotateUidsDescription = `` +
+	`Replaces the UIDs of every verified subscriber with new random UIDs
+
+A subscriber's UID is the only secret in its verify and unsubscribe links.
+This command replaces the UIDs of verified subscribers in batches, invoking the
+EListMan Lambda function once per batch until every subscriber has a new UID.
+
+Each subscriber's previous UID remains valid for the grace period, so that the
+unsubscribe links in messages sent before the rotation still work for a while.
+Pending subscribers keep their UIDs, since they expire soon anyway.
+
+If a batch fails, running the command again starts over from the first
+subscriber. Subscribers already rotated will get new UIDs again, and their
+original UIDs will stop working immediately.`
+
+const defaultGracePeriod = 30 * 24 * time.Hour
+const defaultBatchSize = 1000
+
+func init() {
+	rootCmd.AddCommand(newRotateUidsCmd(NewEListManLambda))
+}
+
+func newRotateUidsCmd(newFunc EListManFactoryFunc) (cmd *cobra.Command) {
+	cmd = &cobra.Command{
+		Use:   "rotate-uids",
+		Short: "Replace the UIDs of every verified subscriber",
+		Long:  rotateUidsDescription,
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return rotateUids(cmd, newFunc, getStackName(cmd))
+		},
+	}
+	registerStackName(cmd)
+	registerList(cmd)
+	cmd.Flags().Duration(
+		FlagGracePeriod, defaultGracePeriod,
+		"how long each subscriber's previous UID remains valid",
+	)
+	cmd.Flags().Int(
+		FlagBatchSize, defaultBatchSize,
+		"maximum number of subscribers to rotate per Lambda invocation",
+	)
+	cmd.MarkFlagRequired(FlagStackName)
+	return
+}
+
+func rotateUids(
+	cmd *cobra.Command, newFunc EListManFactoryFunc, stackName string,
+) (err error) {
+	flags := cmd.Flags()
+	var gracePeriod time.Duration
+	var batchSize int
+	var lambda EListManFunc
+
+	if gracePeriod, err = flags.GetDuration(FlagGracePeriod); err != nil {
+		return
+	} else if batchSize, err = flags.GetInt(FlagBatchSize); err != nil {
+		return
+	}
+
+	cmd.SilenceUsage = true
+	ctx := context.Background()
+
+	if lambda, err = newFunc(stackName); err != nil {
+		return
+	}
+
+	numRotated := 0
+	var startKey *db.ScanKey
+
+	for {
+		evt := &events.CommandLineEvent{
+			EListManCommand: events.CommandLineRotateUidsEvent,
+			RotateUids: &events.RotateUidsEvent{
+				List:        getListName(cmd),
+				GracePeriod: gracePeriod,
+				BatchSize:   batchSize,
+				StartKey:    startKey,
+			},
+		}
+		response := &events.RotateUidsResponse{}
+
+		if err = lambda.Invoke(ctx, evt, response); err != nil {
+			const errFmt = "failed to rotate UIDs after %d: %w"
+			return fmt.Errorf(errFmt, numRotated, err)
+		}
+		numRotated += response.NumRotated
+
+		if !response.Success {
+			const errFmt = "failed to rotate UIDs after %d: %s"
+			return fmt.Errorf(errFmt, numRotated, response.Details)
+		} else if startKey = response.NextKey; startKey == nil {
+			break
+		}
+		cmd.Printf("Rotated %d UIDs so far...\n", numRotated)
+	}
+	cmd.Printf("Rotated %d UIDs.\n", numRotated)
+	return
+}
@@ -0,0 +1,112 @@
+//go:build small_tests || all_tests
+
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mbland/elistman/db"
+	"github.com/mbland/elistman/events"
+	"gotest.tools/assert"
+)
+
+func TestRotateUids(t *testing.T) {
+	setup := func() (f *CommandTestFixture, lambda *TestEListManFunc) {
+		lambda = NewTestEListManFunc()
+		cmd := newRotateUidsCmd(lambda.GetFactoryFunc())
+		f = NewCommandTestFixture(cmd)
+		f.Cmd.SetArgs([]string{"-s", TestStackName})
+		return
+	}
+
+	newRequest := func(
+		gracePeriod time.Duration, batchSize int, startKey *db.ScanKey,
+	) *events.CommandLineEvent {
+		return &events.CommandLineEvent{
+			EListManCommand: events.CommandLineRotateUidsEvent,
+			RotateUids: &events.RotateUidsEvent{
+				GracePeriod: gracePeriod,
+				BatchSize:   batchSize,
+				StartKey:    startKey,
+			},
+		}
+	}
+
+	const nextKeyJson = `{
+		"Email": "foo@test.com", "Timestamp": "2023-09-18T12:00:00Z"
+	}`
+	nextKey := &db.ScanKey{
+		Email:     "foo@test.com",
+		Timestamp: time.Date(2023, 9, 18, 12, 0, 0, 0, time.UTC),
+	}
+
+	t.Run("SucceedsWithDefaultsInOneBatch", func(t *testing.T) {
+		f, lambda := setup()
+		lambda.SetResponseJson(`{"Success": true, "NumRotated": 27}`)
+
+		f.ExecuteAndAssertStdoutContains(t, "Rotated 27 UIDs.\n")
+
+		expectedReq := newRequest(defaultGracePeriod, defaultBatchSize, nil)
+		lambda.AssertMatches(t, TestStackName, expectedReq)
+	})
+
+	t.Run("SucceedsInMultipleBatches", func(t *testing.T) {
+		f, lambda := setup()
+		f.Cmd.SetArgs([]string{
+			"-s", TestStackName, "--grace-period", "1h", "--batch-size", "2",
+		})
+		lambda.QueueResponseJson(
+			`{"Success": true, "NumRotated": 2, "NextKey": ` +
+				nextKeyJson + `}`,
+		)
+		lambda.QueueResponseJson(`{"Success": true, "NumRotated": 1}`)
+
+		const expectedOut = "Rotated 2 UIDs so far...\nRotated 3 UIDs.\n"
+		f.ExecuteAndAssertStdoutContains(t, expectedOut)
+
+		expectedReqs := []any{
+			newRequest(time.Hour, 2, nil),
+			newRequest(time.Hour, 2, nextKey),
+		}
+		assert.DeepEqual(t, expectedReqs, lambda.InvokeReqs)
+	})
+
+	t.Run("RequiresStackNameFlag", func(t *testing.T) {
+		f, _ := setup()
+		f.AssertFailsIfRequiredFlagMissing(t, FlagStackName, []string{})
+	})
+
+	t.Run("FailsIfGracePeriodInvalid", func(t *testing.T) {
+		f, _ := setup()
+		f.Cmd.SetArgs([]string{"-s", TestStackName, "--grace-period", "1"})
+
+		err := f.Cmd.Execute()
+
+		assert.ErrorContains(t, err, "invalid argument \"1\"")
+	})
+
+	t.Run("FailsIfInvokingLambdaFails", func(t *testing.T) {
+		f, lambda := setup()
+		f.AssertReturnsLambdaError(
+			t, lambda, "failed to rotate UIDs after 0: ",
+		)
+	})
+
+	t.Run("FailsIfLambdaReturnsError", func(t *testing.T) {
+		f, lambda := setup()
+		lambda.QueueResponseJson(
+			`{"Success": true, "NumRotated": 2, "NextKey": ` +
+				nextKeyJson + `}`,
+		)
+		lambda.QueueResponseJson(
+			`{"Success": false, "Details": "test failure", "NumRotated": 1}`,
+		)
+
+		err := f.Cmd.Execute()
+
+		const expectedErr = "failed to rotate UIDs after 3: test failure"
+		assert.Error(t, err, expectedErr)
+		assert.Equal(t, "Rotated 2 UIDs so far...\n", f.Stdout.String())
+	})
+}
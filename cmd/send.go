@@ -7,8 +7,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/mail"
+	"os"
+	"time"
 
+	"github.com/mbland/elistman/db"
 	"github.com/mbland/elistman/email"
 	"github.com/mbland/elistman/events"
 	"github.com/spf13/cobra"
@@ -32,7 +36,50 @@ addresses parse successfully, it will attempt to send the message to only those
 addresses. The EListMan Lambda will perform further validation, and will only
 send the message to addresses matching verified subscribers. It will send the
 message to every verified subscriber address and report errors for all other
-addresses.`
+addresses.
+
+If sending to all subscribers stops before reaching every subscriber, such as
+when a send takes longer than the Lambda timeout, it will report a campaign ID.
+Running the command again with the same message and with the --resume flag set
+to the campaign ID will resume sending where it stopped. Subscribers who
+already received the message will not receive it again.
+
+Every send to all subscribers records which subscribers received the message
+under an idempotency key, which defaults to a hash of the message. Running the
+command again with the same message, such as after a network failure, will skip
+every subscriber who already received it and report how many were skipped. It
+will fail if the --tags filter differs from the original send. To send the same
+message to the same subscribers again, or to different tags, set the
+--idempotency-key flag to a new value. The key is also the campaign ID.
+
+Sends to specific addresses only record which subscribers received the message
+if the --idempotency-key flag is set. Test sends to a few addresses therefore
+won't prevent a later send to all subscribers from reaching them.
+
+If the --markdown flag specifies a Markdown file, it will generate the TextBody
+and HtmlBody of the message from that file. The JSON input must then omit
+TextBody and HtmlBody. The message may also specify its Markdown source directly
+in a MarkdownBody field instead.
+
+If the --list flag specifies the name of a list configured via the LISTS
+deployment parameter, it will send the message to that list's subscribers
+instead of to the default list's.
+
+If the --tags flag specifies a tag filter expression, it will send the message
+only to the subscribers whose tags match it. Subscribers receive tags from the
+topics they choose when subscribing. An expression combines tags with "and",
+"or", and "not", with parentheses for grouping, such as:
+
+  releases or (essays and not drafts)
+
+Resuming a send applies the same tag filter as the original send, so the
+--tags flag can't be combined with --resume. It also can't be combined with
+subscriber addresses or with --at.
+
+If the --at flag specifies a time in RFC 3339 format, such as
+2023-09-26T09:00:00-04:00, the EListMan Lambda will save the message and send
+it to all verified subscribers at that time instead of sending it immediately.
+The message will go out on the first scheduled event at or after that time.`
 
 func init() {
 	rootCmd.AddCommand(newSendCmd(NewEListManLambda))
@@ -45,37 +92,110 @@ func newSendCmd(newFunc EListManFactoryFunc) (cmd *cobra.Command) {
 		Long:  sendDescription,
 		Args:  cobra.ArbitraryArgs,
 		RunE: func(cmd *cobra.Command, argv []string) (err error) {
-			return sendMessage(cmd, newFunc, getStackName(cmd), argv)
+			opts := &sendOptions{
+				StackName: getStackName(cmd),
+				List:      getListName(cmd),
+				ResumeId:  getResumeId(cmd),
+				SendAt:    getSendAt(cmd),
+				Key:       getIdempotencyKey(cmd),
+				Tags:      getTagFilter(cmd),
+				Markdown:  getMarkdownPath(cmd),
+			}
+			return sendMessage(cmd, newFunc, opts, argv)
 		},
 	}
 	registerStackName(cmd)
+	registerList(cmd)
+	cmd.Flags().StringP(
+		FlagResume, "r", "", "campaign ID of an incomplete send to resume",
+	)
+	cmd.Flags().String(
+		FlagSendAt, "", "time to send the message, in RFC 3339 format",
+	)
+	cmd.Flags().StringP(
+		FlagIdempotencyKey, "k", "",
+		"key identifying duplicate sends (default: hash of the message)",
+	)
+	cmd.Flags().StringP(
+		FlagTags, "t", "",
+		"tag filter expression selecting subscribers to receive the message",
+	)
+	registerMarkdown(cmd)
 	cmd.MarkFlagRequired(FlagStackName)
 	return
 }
 
+type sendOptions struct {
+	StackName string
+	List      string
+	ResumeId  string
+	SendAt    string
+	Key       string
+	Tags      string
+	Markdown  string
+}
+
 func sendMessage(
 	cmd *cobra.Command,
 	newFunc EListManFactoryFunc,
-	stackName string,
+	opts *sendOptions,
 	addrs []string,
 ) (err error) {
 	cmd.SilenceUsage = true
+	stackName := opts.StackName
+	resumeId := opts.ResumeId
 	var msg *email.Message
+	var sendAt time.Time
+	var filter *db.TagFilter
 
-	if msg, err = email.NewMessageFromJson(cmd.InOrStdin()); err != nil {
+	if msg, err = readMessage(cmd.InOrStdin(), opts.Markdown); err != nil {
+		return
+	} else if sendAt, err = parseSendAt(opts.SendAt); err != nil {
 		return
 	}
 
 	if len(addrs) == 0 {
 		addrs = nil
+	} else if resumeId != "" {
+		return errors.New("can't specify addresses when resuming a send")
+	} else if !sendAt.IsZero() {
+		return errors.New("can't specify addresses when scheduling a send")
 	} else if err = checkAddresses(addrs); err != nil {
 		return
 	}
 
+	if resumeId != "" && !sendAt.IsZero() {
+		return errors.New("can't schedule resuming a send")
+	} else if opts.Key != "" && resumeId != "" {
+		return errors.New("can't specify an idempotency key when resuming")
+	} else if opts.Key != "" && !sendAt.IsZero() {
+		return errors.New("can't specify an idempotency key when scheduling")
+	}
+
+	if opts.Tags == "" {
+		// Send to every subscriber.
+	} else if len(addrs) != 0 {
+		return errors.New("can't specify addresses with a tag filter")
+	} else if resumeId != "" {
+		return errors.New("can't specify a tag filter when resuming")
+	} else if !sendAt.IsZero() {
+		return errors.New("can't specify a tag filter when scheduling")
+	} else if filter, err = db.ParseTagFilter(opts.Tags); err != nil {
+		return
+	}
+
 	ctx := context.Background()
 	evt := &events.CommandLineEvent{
 		EListManCommand: events.CommandLineSendEvent,
-		Send:            &events.SendEvent{Addresses: addrs, Message: *msg},
+		Send: &events.SendEvent{
+			Addresses:        addrs,
+			ResumeCampaignId: resumeId,
+			SendAt:           sendAt,
+			IdempotencyKey:   opts.Key,
+			List:             opts.List,
+			TagFilter:        filter.String(),
+			Message:          *msg,
+		},
 	}
 	response := &events.SendResponse{}
 
@@ -83,10 +203,55 @@ func sendMessage(
 		return fmt.Errorf("sending failed: %w", err)
 	} else if !response.Success {
 		const errFmt = "sending failed after sending to %d recipients: %s"
-		return fmt.Errorf(errFmt, response.NumSent, response.Details)
+		err = fmt.Errorf(errFmt, response.NumSent, response.Details)
+
+		if response.CampaignId != "" {
+			const resumeFmt = "%w\nto resume, run: elistman send -s %s%s -r %s"
+			listFlag := ""
+			if opts.List != "" {
+				listFlag = " -l " + opts.List
+			}
+			err = fmt.Errorf(
+				resumeFmt, err, stackName, listFlag, response.CampaignId,
+			)
+		}
+		return
+	} else if response.ScheduledId != "" {
+		const schedFmt = "Scheduled the message to send at %s (ID: %s).\n"
+		cmd.Printf(schedFmt, sendAt.Format(time.RFC3339), response.ScheduledId)
 	} else {
 		const successFmt = "Sent the message successfully to %d recipients.\n"
 		cmd.Printf(successFmt, response.NumSent)
+
+		if response.NumSkipped != 0 {
+			const skippedFmt = "Skipped %d duplicate recipients who " +
+				"already received the message.\n"
+			cmd.Printf(skippedFmt, response.NumSkipped)
+		}
+	}
+	return
+}
+
+// readMessage parses a Message from JSON input, generating its TextBody and
+// HtmlBody from the markdownPath file if not empty.
+func readMessage(
+	input io.Reader, markdownPath string,
+) (msg *email.Message, err error) {
+	var markdown []byte
+
+	if markdownPath == "" {
+		return email.NewMessageFromJson(input)
+	} else if markdown, err = os.ReadFile(markdownPath); err != nil {
+		return nil, fmt.Errorf("failed to read Markdown file: %w", err)
+	}
+	return email.NewMessageFromJsonAndMarkdown(input, string(markdown))
+}
+
+func parseSendAt(sendAt string) (t time.Time, err error) {
+	if sendAt == "" {
+		return
+	} else if t, err = time.Parse(time.RFC3339, sendAt); err != nil {
+		err = fmt.Errorf("--%s must be in RFC 3339 format: %w", FlagSendAt, err)
 	}
 	return
 }
@@ -3,14 +3,32 @@
 package cmd
 
 import (
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/mbland/elistman/email"
 	"github.com/mbland/elistman/events"
 	"gotest.tools/assert"
 )
 
+const testMarkdownMessageJson = `{
+	"From": "Foo Bar <foobar@example.com>",
+	"Subject": "Test object",
+	"TextFooter": "Unsubscribe: {{UnsubscribeUrl}}",
+	"HtmlFooter": "<a href='{{UnsubscribeUrl}}'>Unsubscribe</a>"
+}`
+
+func writeMarkdownFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "message.md")
+	assert.NilError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
 func TestSend(t *testing.T) {
 	stackNameArgs := []string{"-s", TestStackName}
 
@@ -37,6 +55,23 @@ func TestSend(t *testing.T) {
 		lambda.AssertMatches(t, TestStackName, expectedReq)
 	})
 
+	t.Run("SucceedsSendingToList", func(t *testing.T) {
+		f, lambda := setup()
+		f.Cmd.SetArgs(append(stackNameArgs, "--list", "updates"))
+		lambda.SetResponseJson(`{"Success": true, "NumSent": 27}`)
+
+		const expectedOut = "Sent the message successfully to 27 recipients.\n"
+		f.ExecuteAndAssertStdoutContains(t, expectedOut)
+
+		expectedReq := &events.CommandLineEvent{
+			EListManCommand: events.CommandLineSendEvent,
+			Send: &events.SendEvent{
+				List: "updates", Message: *email.ExampleMessage,
+			},
+		}
+		lambda.AssertMatches(t, TestStackName, expectedReq)
+	})
+
 	t.Run("SucceedsSendingToSpecificSubscribers", func(t *testing.T) {
 		f, lambda := setup()
 		addrs := []string{"test@foo.com", "test@bar.com", "test@baz.com"}
@@ -56,6 +91,112 @@ func TestSend(t *testing.T) {
 		lambda.AssertMatches(t, TestStackName, expectedReq)
 	})
 
+	t.Run("SucceedsResumingSend", func(t *testing.T) {
+		f, lambda := setup()
+		f.Cmd.SetArgs(append(stackNameArgs, "-r", "campaign-id"))
+		lambda.SetResponseJson(`{"Success": true, "NumSent": 18}`)
+
+		const expectedOut = "Sent the message successfully to 18 recipients.\n"
+		f.ExecuteAndAssertStdoutContains(t, expectedOut)
+
+		expectedReq := &events.CommandLineEvent{
+			EListManCommand: events.CommandLineSendEvent,
+			Send: &events.SendEvent{
+				ResumeCampaignId: "campaign-id",
+				Message:          *email.ExampleMessage,
+			},
+		}
+		lambda.AssertMatches(t, TestStackName, expectedReq)
+	})
+
+	t.Run("SucceedsWithIdempotencyKeyAndReportsSkipped", func(t *testing.T) {
+		f, lambda := setup()
+		f.Cmd.SetArgs(append(stackNameArgs, "-k", "idempotency-key"))
+		lambda.SetResponseJson(
+			`{"Success": true, "NumSent": 24, "NumSkipped": 3}`,
+		)
+
+		const expectedOut = "" +
+			"Sent the message successfully to 24 recipients.\n" +
+			"Skipped 3 duplicate recipients who already received the message.\n"
+		f.ExecuteAndAssertStdoutContains(t, expectedOut)
+
+		expectedReq := &events.CommandLineEvent{
+			EListManCommand: events.CommandLineSendEvent,
+			Send: &events.SendEvent{
+				IdempotencyKey: "idempotency-key",
+				Message:        *email.ExampleMessage,
+			},
+		}
+		lambda.AssertMatches(t, TestStackName, expectedReq)
+	})
+
+	t.Run("SucceedsSendingToTaggedSubscribers", func(t *testing.T) {
+		f, lambda := setup()
+		tags := "releases or (essays and not drafts)"
+		f.Cmd.SetArgs(append(stackNameArgs, "--tags", tags))
+		lambda.SetResponseJson(`{"Success": true, "NumSent": 5}`)
+
+		const expectedOut = "Sent the message successfully to 5 recipients.\n"
+		f.ExecuteAndAssertStdoutContains(t, expectedOut)
+
+		expectedReq := &events.CommandLineEvent{
+			EListManCommand: events.CommandLineSendEvent,
+			Send: &events.SendEvent{
+				TagFilter: "releases or essays and not drafts",
+				Message:   *email.ExampleMessage,
+			},
+		}
+		lambda.AssertMatches(t, TestStackName, expectedReq)
+	})
+
+	t.Run("SucceedsSchedulingSend", func(t *testing.T) {
+		f, lambda := setup()
+		const sendAtStr = "2023-09-26T09:00:00-04:00"
+		f.Cmd.SetArgs(append(stackNameArgs, "--at", sendAtStr))
+		lambda.SetResponseJson(
+			`{"Success": true, "ScheduledId": "scheduled-id"}`,
+		)
+
+		const expectedOut = "Scheduled the message to send at " +
+			sendAtStr + " (ID: scheduled-id).\n"
+		f.ExecuteAndAssertStdoutContains(t, expectedOut)
+
+		sendAt, _ := time.Parse(time.RFC3339, sendAtStr)
+		expectedReq := &events.CommandLineEvent{
+			EListManCommand: events.CommandLineSendEvent,
+			Send: &events.SendEvent{
+				SendAt: sendAt, Message: *email.ExampleMessage,
+			},
+		}
+		lambda.AssertMatches(t, TestStackName, expectedReq)
+	})
+
+	t.Run("SucceedsWithMarkdownFile", func(t *testing.T) {
+		f, lambda := setup()
+		f.Cmd.SetIn(strings.NewReader(testMarkdownMessageJson))
+		path := writeMarkdownFile(t, "Hello, *World*!")
+		f.Cmd.SetArgs(append(stackNameArgs, "--markdown", path))
+		lambda.SetResponseJson(`{"Success": true, "NumSent": 27}`)
+
+		const expectedOut = "Sent the message successfully to 27 recipients.\n"
+		f.ExecuteAndAssertStdoutContains(t, expectedOut)
+
+		msg := &email.Message{
+			From:       "Foo Bar <foobar@example.com>",
+			Subject:    "Test object",
+			TextBody:   "Hello, _World_!\n",
+			TextFooter: "Unsubscribe: {{UnsubscribeUrl}}",
+			HtmlBody:   "<p>Hello, <em>World</em>!</p>\n",
+			HtmlFooter: "<a href='{{UnsubscribeUrl}}'>Unsubscribe</a>",
+		}
+		expectedReq := &events.CommandLineEvent{
+			EListManCommand: events.CommandLineSendEvent,
+			Send:            &events.SendEvent{Message: *msg},
+		}
+		lambda.AssertMatches(t, TestStackName, expectedReq)
+	})
+
 	t.Run("RequiresStackNameFlag", func(t *testing.T) {
 		f, _ := setup()
 		f.AssertFailsIfRequiredFlagMissing(t, FlagStackName, []string{})
@@ -69,6 +210,15 @@ func TestSend(t *testing.T) {
 		f.ExecuteAndAssertErrorContains(t, expectedErr)
 	})
 
+	t.Run("FailsIfCannotReadMarkdownFile", func(t *testing.T) {
+		f, _ := setup()
+		path := filepath.Join(t.TempDir(), "nonexistent.md")
+		f.Cmd.SetArgs(append(stackNameArgs, "--markdown", path))
+
+		const expectedErr = "failed to read Markdown file: "
+		f.ExecuteAndAssertErrorContains(t, expectedErr)
+	})
+
 	t.Run("FailsIfAnySpecifiedAddressesAreInvalid", func(t *testing.T) {
 		f, _ := setup()
 		addrs := []string{"test@foo.com", "oh noes", "test@baz.com", "wat"}
@@ -80,6 +230,95 @@ func TestSend(t *testing.T) {
 		f.ExecuteAndAssertErrorContains(t, expectedErr)
 	})
 
+	t.Run("FailsIfResumingWithSpecificAddresses", func(t *testing.T) {
+		f, _ := setup()
+		args := []string{"-r", "campaign-id", "test@foo.com"}
+		f.Cmd.SetArgs(append(stackNameArgs, args...))
+
+		const expectedErr = "can't specify addresses when resuming a send"
+		f.ExecuteAndAssertErrorContains(t, expectedErr)
+	})
+
+	t.Run("FailsIfSendTimeIsNotRfc3339", func(t *testing.T) {
+		f, _ := setup()
+		f.Cmd.SetArgs(append(stackNameArgs, "--at", "next Tuesday"))
+
+		const expectedErr = "--at must be in RFC 3339 format: "
+		f.ExecuteAndAssertErrorContains(t, expectedErr)
+	})
+
+	t.Run("FailsIfSchedulingWithSpecificAddresses", func(t *testing.T) {
+		f, _ := setup()
+		args := []string{"--at", "2023-09-26T09:00:00Z", "test@foo.com"}
+		f.Cmd.SetArgs(append(stackNameArgs, args...))
+
+		const expectedErr = "can't specify addresses when scheduling a send"
+		f.ExecuteAndAssertErrorContains(t, expectedErr)
+	})
+
+	t.Run("FailsIfSchedulingResumedSend", func(t *testing.T) {
+		f, _ := setup()
+		args := []string{"--at", "2023-09-26T09:00:00Z", "-r", "campaign-id"}
+		f.Cmd.SetArgs(append(stackNameArgs, args...))
+
+		const expectedErr = "can't schedule resuming a send"
+		f.ExecuteAndAssertErrorContains(t, expectedErr)
+	})
+
+	t.Run("FailsIfResumingWithIdempotencyKey", func(t *testing.T) {
+		f, _ := setup()
+		args := []string{"-r", "campaign-id", "-k", "idempotency-key"}
+		f.Cmd.SetArgs(append(stackNameArgs, args...))
+
+		const expectedErr = "can't specify an idempotency key when resuming"
+		f.ExecuteAndAssertErrorContains(t, expectedErr)
+	})
+
+	t.Run("FailsIfSchedulingWithIdempotencyKey", func(t *testing.T) {
+		f, _ := setup()
+		args := []string{"--at", "2023-09-26T09:00:00Z", "-k", "key"}
+		f.Cmd.SetArgs(append(stackNameArgs, args...))
+
+		const expectedErr = "can't specify an idempotency key when scheduling"
+		f.ExecuteAndAssertErrorContains(t, expectedErr)
+	})
+
+	t.Run("FailsIfTagFilterIsInvalid", func(t *testing.T) {
+		f, _ := setup()
+		f.Cmd.SetArgs(append(stackNameArgs, "-t", "releases and"))
+
+		const expectedErr = `invalid tag filter "releases and": ` +
+			"unexpected end of expression"
+		f.ExecuteAndAssertErrorContains(t, expectedErr)
+	})
+
+	t.Run("FailsIfTagFilterWithSpecificAddresses", func(t *testing.T) {
+		f, _ := setup()
+		args := []string{"-t", "releases", "test@foo.com"}
+		f.Cmd.SetArgs(append(stackNameArgs, args...))
+
+		const expectedErr = "can't specify addresses with a tag filter"
+		f.ExecuteAndAssertErrorContains(t, expectedErr)
+	})
+
+	t.Run("FailsIfResumingWithTagFilter", func(t *testing.T) {
+		f, _ := setup()
+		args := []string{"-r", "campaign-id", "-t", "releases"}
+		f.Cmd.SetArgs(append(stackNameArgs, args...))
+
+		const expectedErr = "can't specify a tag filter when resuming"
+		f.ExecuteAndAssertErrorContains(t, expectedErr)
+	})
+
+	t.Run("FailsIfSchedulingWithTagFilter", func(t *testing.T) {
+		f, _ := setup()
+		args := []string{"--at", "2023-09-26T09:00:00Z", "-t", "releases"}
+		f.Cmd.SetArgs(append(stackNameArgs, args...))
+
+		const expectedErr = "can't specify a tag filter when scheduling"
+		f.ExecuteAndAssertErrorContains(t, expectedErr)
+	})
+
 	t.Run("FailsIfInvokingLambdaFails", func(t *testing.T) {
 		f, lambda := setup()
 		f.AssertReturnsLambdaError(t, lambda, "sending failed: ")
@@ -95,4 +334,26 @@ func TestSend(t *testing.T) {
 			"test failure"
 		f.ExecuteAndAssertErrorContains(t, expectedErr)
 	})
+	t.Run("ReportsResumeCommandIfSendingIncomplete", func(t *testing.T) {
+		f, lambda := setup()
+		lambda.SetResponseJson(`{"Success": false, "NumSent": 9, ` +
+			`"Details": "test failure", "CampaignId": "campaign-id"}`)
+
+		const expectedErr = "sending failed after sending to 9 recipients: " +
+			"test failure\n" +
+			"to resume, run: elistman send -s " + TestStackName +
+			" -r campaign-id"
+		f.ExecuteAndAssertErrorContains(t, expectedErr)
+	})
+
+	t.Run("ReportsResumeCommandWithList", func(t *testing.T) {
+		f, lambda := setup()
+		f.Cmd.SetArgs(append(stackNameArgs, "-l", "updates"))
+		lambda.SetResponseJson(`{"Success": false, "NumSent": 9, ` +
+			`"Details": "test failure", "CampaignId": "campaign-id"}`)
+
+		const expectedErr = "to resume, run: elistman send -s " +
+			TestStackName + " -l updates -r campaign-id"
+		f.ExecuteAndAssertErrorContains(t, expectedErr)
+	})
 }
@@ -0,0 +1,310 @@
+// Copyright © 2023 Mike Bland <mbland@acm.org>
+// See LICENSE.txt for details.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/mail"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mbland/elistman/agent"
+	"github.com/mbland/elistman/db"
+	"github.com/mbland/elistman/email"
+	"github.com/mbland/elistman/handler"
+	"github.com/mbland/elistman/ops"
+	"github.com/spf13/cobra"
+)
+
+const serveDescription = `` +
+	`Runs the EListMan API locally, without AWS credentials or Docker.
+
+It serves the same /subscribe, /verify, and /unsubscribe endpoints as the
+deployed API Gateway, converting each request into the event the EListMan Lambda
+would receive. Visit the root URL for a form that submits to /subscribe.
+
+Subscribers live in memory, so they disappear when the server stops, unless
+--db-file names a file in which to keep them. Instead of sending email, the
+server writes each message to an .eml file in the --outbox directory. Open the
+verification message to follow its links back to the server.
+
+Address validation only checks that an address parses, so any syntactically
+valid address will work. Redirects after each operation point back to the local
+server, which serves a placeholder page at each path that a deployed instance
+would use under --domain.`
+
+// HttpServeFunc serves HTTP requests on addr until the server fails.
+//
+// http.ListenAndServe is the production implementation.
+type HttpServeFunc func(addr string, handler http.Handler) error
+
+func init() {
+	rootCmd.AddCommand(newServeCmd(http.ListenAndServe))
+}
+
+func newServeCmd(serve HttpServeFunc) (cmd *cobra.Command) {
+	cmd = &cobra.Command{
+		Use:   "serve",
+		Short: "Run the EListMan API locally for development",
+		Long:  serveDescription,
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			opts := &serveOptions{
+				Addr:      getStringFlag(cmd, FlagAddr),
+				Outbox:    getStringFlag(cmd, FlagOutbox),
+				Domain:    getStringFlag(cmd, FlagDomain),
+				SiteTitle: getStringFlag(cmd, FlagTitle),
+				DbFile:    getStringFlag(cmd, FlagDbFile),
+			}
+			return serveLocally(cmd, serve, opts)
+		},
+	}
+	cmd.Flags().String(
+		FlagAddr, "localhost:8080", "host:port address on which to listen",
+	)
+	cmd.Flags().StringP(
+		FlagOutbox, "o", "outbox", "directory to which to write messages",
+	)
+	cmd.Flags().String(
+		FlagDomain, "localhost", "email domain name of the mailing list",
+	)
+	cmd.Flags().String(FlagTitle, "EListMan", "title of the mailing list site")
+	cmd.Flags().String(
+		FlagDbFile, "", "file in which to keep records between runs",
+	)
+	return
+}
+
+type serveOptions struct {
+	Addr      string
+	Outbox    string
+	Domain    string
+	SiteTitle string
+	DbFile    string
+}
+
+// localDatabase comprises the interfaces that both db.MemoryDb and db.FileDb
+// implement.
+type localDatabase interface {
+	db.Database
+	db.CheckpointStore
+	db.CampaignStore
+	db.ScheduleStore
+	db.AuditLog
+	db.TombstoneStore
+	db.ErasureStore
+	db.WelcomeStore
+}
+
+// These match the example values from the README.
+var localRedirectPaths = handler.RedirectPaths{
+	Invalid:           "subscribe/malformed.html",
+	AlreadySubscribed: "subscribe/already-subscribed.html",
+	VerifyLinkSent:    "subscribe/confirm.html",
+	Subscribed:        "subscribe/hello.html",
+	NotSubscribed:     "unsubscribe/not-subscribed.html",
+	Unsubscribed:      "unsubscribe/goodbye.html",
+	VerifyLinkExpired: "subscribe/expired.html",
+	TopicsUpdated:     "subscribe/topics-updated.html",
+}
+
+func serveLocally(
+	cmd *cobra.Command, serve HttpServeFunc, opts *serveOptions,
+) (err error) {
+	cmd.SilenceUsage = true
+	logger := log.New(cmd.ErrOrStderr(), "", log.LstdFlags)
+	var h *handler.Handler
+
+	if h, err = newLocalHandler(opts, logger); err != nil {
+		return
+	}
+
+	mux := handler.NewHttpHandler(h)
+	mux.Handle("GET /{$}", newSubscribeFormHandler(opts.SiteTitle))
+	for path, title := range localRedirectPages() {
+		mux.Handle("GET /"+path, newRedirectPageHandler(opts.SiteTitle, title))
+	}
+
+	cmd.Printf("Serving the EListMan API at http://%s/\n", opts.Addr)
+	cmd.Printf("Writing messages to: %s\n", opts.Outbox)
+	if opts.DbFile != "" {
+		cmd.Printf("Keeping records in: %s\n", opts.DbFile)
+	}
+	return serve(opts.Addr, mux)
+}
+
+func newLocalHandler(
+	opts *serveOptions, logger *log.Logger,
+) (*handler.Handler, error) {
+	var localDb localDatabase = db.NewMemoryDb()
+
+	if opts.DbFile != "" {
+		fileDb, err := db.OpenFileDb(opts.DbFile)
+		if err != nil {
+			return nil, err
+		}
+		localDb = fileDb
+	}
+
+	return handler.NewHandler(
+		opts.Domain,
+		"http://"+opts.Addr,
+		opts.SiteTitle,
+		&agent.ProdAgent{
+			SenderAddress: fmt.Sprintf(
+				"%s <posts@%s>", opts.SiteTitle, opts.Domain,
+			),
+			EmailSiteTitle:       opts.SiteTitle,
+			EmailDomainName:      opts.Domain,
+			UnsubscribeEmail:     "unsubscribe@" + opts.Domain,
+			UnsubscribeUrl:       "https://" + opts.Domain + "/unsubscribe",
+			ApiBaseUrl:           "http://" + opts.Addr,
+			VerifyResendCooldown: handler.DefaultVerifyResendCooldown,
+			MaxVerifyEmails:      handler.DefaultMaxVerifyEmails,
+			TombstonePolicy:      handler.DefaultTombstonePolicy,
+			NewUid:               uuid.NewRandom,
+			CurrentTime:          time.Now,
+			Db:                   localDb,
+			Checkpoints:          localDb,
+			Campaigns:            localDb,
+			Schedules:            localDb,
+			Audit:                localDb,
+			Tombstones:           localDb,
+			Erasures:             localDb,
+			Welcome:              localDb,
+			Validator:            localValidator{},
+			Mailer:               &email.FileMailer{Dir: opts.Outbox},
+			Suppressor:           newLocalSuppressor(),
+			Log:                  logger,
+		},
+		localRedirectPaths,
+		nil,
+		handler.ResponseTemplate,
+		true,
+		nil,
+		"unsubscribe",
+		// The local server only handles API requests, never mailto events.
+		nil,
+		logger,
+	)
+}
+
+const subscribeFormTemplate = `<!DOCTYPE html>
+<html lang="en-us">
+  <head>
+    <meta charset="utf-8"/>
+    <title>Subscribe - {{.}}</title>
+  </head>
+  <body>
+    <h1>Subscribe to {{.}}</h1>
+    <form method="post" action="/subscribe">
+      <input type="email" name="email" placeholder="Email address" required/>
+      <button type="submit">Subscribe</button>
+    </form>
+  </body>
+</html>
+`
+
+func newSubscribeFormHandler(siteTitle string) http.HandlerFunc {
+	tmpl := template.Must(template.New("form").Parse(subscribeFormTemplate))
+
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("content-type", "text/html; charset=utf-8")
+		tmpl.Execute(w, siteTitle)
+	}
+}
+
+// localRedirectPages maps each of localRedirectPaths to the title of the
+// placeholder page that the local server serves in its place.
+func localRedirectPages() map[string]string {
+	paths := &localRedirectPaths
+	return map[string]string{
+		paths.Invalid:           "Invalid request",
+		paths.AlreadySubscribed: "Already subscribed",
+		paths.VerifyLinkSent:    "Verification link sent",
+		paths.Subscribed:        "Subscribed",
+		paths.NotSubscribed:     "Not subscribed",
+		paths.Unsubscribed:      "Unsubscribed",
+		paths.VerifyLinkExpired: "Verification link expired",
+		paths.TopicsUpdated:     "Topics updated",
+	}
+}
+
+const redirectPageTemplate = `<!DOCTYPE html>
+<html lang="en-us">
+  <head>
+    <meta charset="utf-8"/>
+    <title>{{.Title}} - {{.SiteTitle}}</title>
+  </head>
+  <body>
+    <h1>{{.Title}}</h1>
+    <p><a href="/">Return to the subscribe form</a></p>
+  </body>
+</html>
+`
+
+func newRedirectPageHandler(siteTitle, title string) http.HandlerFunc {
+	tmpl := template.Must(template.New("page").Parse(redirectPageTemplate))
+	params := struct{ Title, SiteTitle string }{title, siteTitle}
+
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("content-type", "text/html; charset=utf-8")
+		tmpl.Execute(w, params)
+	}
+}
+
+// localValidator only checks that an address parses, so `elistman serve`
+// doesn't depend on DNS lookups.
+type localValidator struct{}
+
+func (localValidator) ValidateAddress(
+	_ context.Context, address string,
+) (failure *email.ValidationFailure, err error) {
+	if _, err := mail.ParseAddress(address); err != nil {
+		failure = &email.ValidationFailure{
+			Address: address, Reason: "failed to parse",
+		}
+	}
+	return
+}
+
+// localSuppressor keeps its suppression list in memory instead of SES.
+type localSuppressor struct {
+	mutex     sync.Mutex
+	addresses map[string]ops.RemoveReason
+}
+
+func newLocalSuppressor() *localSuppressor {
+	return &localSuppressor{addresses: map[string]ops.RemoveReason{}}
+}
+
+func (s *localSuppressor) IsSuppressed(
+	_ context.Context, address string,
+) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, ok := s.addresses[address]
+	return ok, nil
+}
+
+func (s *localSuppressor) Suppress(
+	_ context.Context, address string, reason ops.RemoveReason,
+) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.addresses[address] = reason
+	return nil
+}
+
+func (s *localSuppressor) Unsuppress(_ context.Context, address string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.addresses, address)
+	return nil
+}
@@ -0,0 +1,178 @@
+//go:build small_tests || all_tests
+
+package cmd
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+type testHttpServer struct {
+	Addr    string
+	Handler http.Handler
+	Error   error
+}
+
+func (s *testHttpServer) Serve(addr string, handler http.Handler) error {
+	s.Addr = addr
+	s.Handler = handler
+	return s.Error
+}
+
+func (s *testHttpServer) Request(
+	method, path string, form url.Values,
+) *httptest.ResponseRecorder {
+	body := form.Encode()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("content-type", "application/x-www-form-urlencoded")
+	}
+	rec := httptest.NewRecorder()
+	s.Handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestServe(t *testing.T) {
+	setup := func(t *testing.T) (*CommandTestFixture, *testHttpServer, string) {
+		server := &testHttpServer{}
+		f := NewCommandTestFixture(newServeCmd(server.Serve))
+		outbox := filepath.Join(t.TempDir(), "outbox")
+		f.Cmd.SetArgs([]string{"--outbox", outbox, "--domain", "foo.com"})
+		return f, server, outbox
+	}
+
+	readOnlyMessage := func(t *testing.T, outbox string) string {
+		t.Helper()
+
+		files, err := filepath.Glob(filepath.Join(outbox, "*.eml"))
+		assert.NilError(t, err)
+		assert.Equal(t, 1, len(files))
+		content, err := os.ReadFile(files[0])
+		assert.NilError(t, err)
+		return string(content)
+	}
+
+	t.Run("SubscribesVerifiesAndUnsubscribes", func(t *testing.T) {
+		f, server, outbox := setup(t)
+
+		err := f.Cmd.Execute()
+
+		assert.NilError(t, err)
+		assert.Equal(t, "localhost:8080", server.Addr)
+		const expectedOut = "Serving the EListMan API at http://localhost:8080/"
+		assert.Assert(t, strings.Contains(f.Stdout.String(), expectedOut))
+
+		res := server.Request(http.MethodGet, "/", nil)
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Assert(t, strings.Contains(res.Body.String(), `name="email"`))
+
+		form := url.Values{"email": {"subscriber@bar.com"}}
+		res = server.Request(http.MethodPost, "/subscribe", form)
+		assert.Equal(t, http.StatusSeeOther, res.Code)
+		const confirmUrl = "http://localhost:8080/subscribe/confirm.html"
+		assert.Equal(t, confirmUrl, res.Header().Get("location"))
+
+		msg := readOnlyMessage(t, outbox)
+		assert.Assert(t, strings.Contains(msg, "To: subscriber@bar.com"))
+
+		// Remove quoted-printable soft line breaks from the verify link.
+		msg = strings.ReplaceAll(msg, "=\r\n", "")
+		verifyUrl := regexp.MustCompile(
+			`http://localhost:8080(/verify/[^\s]+)`,
+		).FindStringSubmatch(msg)
+		assert.Assert(t, verifyUrl != nil, "no verify link in: %s", msg)
+
+		res = server.Request(http.MethodGet, verifyUrl[1], nil)
+		assert.Equal(t, http.StatusOK, res.Code)
+		const confirmForm = `<form method="post">`
+		assert.Assert(t, strings.Contains(res.Body.String(), confirmForm))
+
+		res = server.Request(http.MethodPost, verifyUrl[1], nil)
+		assert.Equal(t, http.StatusSeeOther, res.Code)
+		const helloUrl = "http://localhost:8080/subscribe/hello.html"
+		assert.Equal(t, helloUrl, res.Header().Get("location"))
+
+		unsubPath := strings.Replace(
+			verifyUrl[1], "/verify/", "/unsubscribe/", 1,
+		)
+		res = server.Request(http.MethodGet, unsubPath, nil)
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Assert(t, strings.Contains(res.Body.String(), confirmForm))
+
+		res = server.Request(http.MethodPost, unsubPath, nil)
+		assert.Equal(t, http.StatusSeeOther, res.Code)
+		const goodbyeUrl = "http://localhost:8080/unsubscribe/goodbye.html"
+		assert.Equal(t, goodbyeUrl, res.Header().Get("location"))
+		assert.Assert(t, strings.Contains(f.Stderr.String(), "Unsubscribed"))
+	})
+
+	t.Run("RedirectsIfAddressInvalid", func(t *testing.T) {
+		f, server, outbox := setup(t)
+		assert.NilError(t, f.Cmd.Execute())
+
+		form := url.Values{"email": {"not an address"}}
+		res := server.Request(http.MethodPost, "/subscribe", form)
+
+		assert.Equal(t, http.StatusSeeOther, res.Code)
+		const invalidUrl = "http://localhost:8080/subscribe/malformed.html"
+		assert.Equal(t, invalidUrl, res.Header().Get("location"))
+		_, err := os.Stat(outbox)
+		assert.Assert(t, errors.Is(err, os.ErrNotExist))
+	})
+
+	t.Run("ServesRedirectPages", func(t *testing.T) {
+		f, server, _ := setup(t)
+		assert.NilError(t, f.Cmd.Execute())
+
+		res := server.Request(http.MethodGet, "/subscribe/hello.html", nil)
+
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Assert(t, strings.Contains(res.Body.String(), "<h1>Subscribed"))
+	})
+
+	t.Run("KeepsRecordsInDbFile", func(t *testing.T) {
+		f, server, outbox := setup(t)
+		dbFile := filepath.Join(t.TempDir(), "elistman.json")
+		f.Cmd.SetArgs([]string{"--outbox", outbox, "--db-file", dbFile})
+		assert.NilError(t, f.Cmd.Execute())
+
+		form := url.Values{"email": {"subscriber@bar.com"}}
+		res := server.Request(http.MethodPost, "/subscribe", form)
+
+		assert.Equal(t, http.StatusSeeOther, res.Code)
+		assert.Assert(t, strings.Contains(f.Stdout.String(), dbFile))
+		content, err := os.ReadFile(dbFile)
+		assert.NilError(t, err)
+		const subEmail = "subscriber@bar.com"
+		assert.Assert(t, strings.Contains(string(content), subEmail))
+	})
+
+	t.Run("FailsIfDbFileIsMalformed", func(t *testing.T) {
+		f, _, outbox := setup(t)
+		dbFile := filepath.Join(t.TempDir(), "elistman.json")
+		assert.NilError(t, os.WriteFile(dbFile, []byte("{"), 0600))
+		f.Cmd.SetArgs([]string{"--outbox", outbox, "--db-file", dbFile})
+
+		err := f.Cmd.Execute()
+
+		assert.ErrorContains(t, err, "failed to parse "+dbFile)
+	})
+
+	t.Run("ReturnsServerError", func(t *testing.T) {
+		f, server, _ := setup(t)
+		server.Error = errors.New("address already in use")
+
+		err := f.Cmd.Execute()
+
+		assert.Error(t, err, "address already in use")
+	})
+}
@@ -0,0 +1,403 @@
+// Copyright © 2023 Mike Bland <mbland@acm.org>
+// See LICENSE.txt for details.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mbland/elistman/agent"
+	"github.com/mbland/elistman/db"
+	"github.com/mbland/elistman/events"
+	"github.com/spf13/cobra"
+)
+
+const subscribersDescription = `` +
+	`Shows or lists subscriber records
+
+Each subscriber record contains the address, its status, the time at which the
+status last changed, the topics the subscriber chose, and the metadata recorded
+when the subscriber signed up. The status is one of:
+
+  pending:  the subscriber hasn't opened the verification link yet, and will
+            expire a day after the most recent verification email
+  verified: the subscriber receives messages sent to the list
+
+Both subcommands print a table by default, or the complete records as JSON with
+--output json.`
+
+const subscribersGetDescription = `` +
+	`Shows all the information for the subscriber with the specified address
+
+Fails if the address isn't a pending or verified subscriber.`
+
+const subscribersListDescription = `` +
+	`Lists every subscriber with the specified status, in address order
+
+--since selects only subscribers whose status changed at or after the specified
+date, in YYYY-MM-DD or RFC 3339 format. --domain selects only subscribers whose
+addresses belong to the specified domain.
+
+The table output ends with the number of subscribers listed. Both formats
+print each batch of subscribers as soon as it arrives, so the table's columns
+may line up differently from one batch to the next.`
+
+const subscribersTimeFormat = time.RFC3339
+const subscribersBatchSize = 1000
+
+const (
+	outputTable = "table"
+	outputJson  = "json"
+)
+
+func init() {
+	rootCmd.AddCommand(newSubscribersCmd(NewEListManLambda))
+}
+
+func newSubscribersCmd(newFunc EListManFactoryFunc) (cmd *cobra.Command) {
+	cmd = &cobra.Command{
+		Use:   "subscribers",
+		Short: "Show or list subscriber records",
+		Long:  subscribersDescription,
+	}
+	cmd.AddCommand(newSubscribersGetCmd(newFunc))
+	cmd.AddCommand(newSubscribersListCmd(newFunc))
+	return
+}
+
+func newSubscribersGetCmd(newFunc EListManFactoryFunc) (cmd *cobra.Command) {
+	cmd = &cobra.Command{
+		Use:   "get ADDRESS",
+		Short: "Show a single subscriber",
+		Long:  subscribersGetDescription,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, argv []string) error {
+			return getSubscriber(cmd, newFunc, getStackName(cmd), argv[0])
+		},
+	}
+	registerStackName(cmd)
+	registerList(cmd)
+	registerOutput(cmd)
+	cmd.MarkFlagRequired(FlagStackName)
+	return
+}
+
+func newSubscribersListCmd(newFunc EListManFactoryFunc) (cmd *cobra.Command) {
+	cmd = &cobra.Command{
+		Use:   "list",
+		Short: "List subscribers with a status",
+		Long:  subscribersListDescription,
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return listSubscribers(cmd, newFunc, getStackName(cmd))
+		},
+	}
+	registerStackName(cmd)
+	registerList(cmd)
+	registerOutput(cmd)
+	cmd.Flags().String(
+		FlagStatus, "", "status of subscribers to list: pending or verified",
+	)
+	cmd.Flags().String(
+		FlagSince, "", "list only subscribers whose status changed since DATE",
+	)
+	cmd.Flags().String(
+		FlagDomain, "", "list only subscribers with addresses in this domain",
+	)
+	cmd.MarkFlagRequired(FlagStackName)
+	cmd.MarkFlagRequired(FlagStatus)
+	return
+}
+
+func registerOutput(cmd *cobra.Command) {
+	cmd.Flags().StringP(
+		FlagOutput, "o", outputTable, "output format: table or json",
+	)
+}
+
+func getOutputFormat(cmd *cobra.Command) (format string, err error) {
+	format = getStringFlag(cmd, FlagOutput)
+	if format != outputTable && format != outputJson {
+		const errFmt = "--%s must be %q or %q, got %q"
+		err = fmt.Errorf(errFmt, FlagOutput, outputTable, outputJson, format)
+	}
+	return
+}
+
+func getSubscriber(
+	cmd *cobra.Command,
+	newFunc EListManFactoryFunc,
+	stackName string,
+	address string,
+) (err error) {
+	cmd.SilenceUsage = true
+	var format string
+	var subs []*db.Subscriber
+	query := events.SubscribersEvent{List: getListName(cmd), Email: address}
+
+	if format, err = getOutputFormat(cmd); err != nil {
+		return
+	} else if subs, _, err = invokeSubscribers(
+		cmd, newFunc, stackName, query,
+	); err != nil {
+		return
+	} else if len(subs) != 1 {
+		return fmt.Errorf("%s %w", address, db.ErrSubscriberNotFound)
+	} else if format == outputJson {
+		return printJson(cmd, subs[0])
+	}
+	writeSubscriber(cmd.OutOrStdout(), subs[0])
+	return
+}
+
+func listSubscribers(
+	cmd *cobra.Command, newFunc EListManFactoryFunc, stackName string,
+) (err error) {
+	cmd.SilenceUsage = true
+	var format string
+	var since time.Time
+	status := db.SubscriberStatus(getStringFlag(cmd, FlagStatus))
+	sinceFlag := getStringFlag(cmd, FlagSince)
+
+	if format, err = getOutputFormat(cmd); err != nil {
+		return
+	} else if status != db.SubscriberPending &&
+		status != db.SubscriberVerified {
+		const errFmt = "--%s must be %q or %q, got %q"
+		return fmt.Errorf(
+			errFmt, FlagStatus, db.SubscriberPending, db.SubscriberVerified,
+			status,
+		)
+	} else if since, err = parseSince(sinceFlag); err != nil {
+		return
+	}
+
+	out := newSubscribersWriter(cmd.OutOrStdout(), format, status)
+	query := events.SubscribersEvent{
+		List:      getListName(cmd),
+		Status:    status,
+		Since:     since,
+		Domain:    getStringFlag(cmd, FlagDomain),
+		BatchSize: subscribersBatchSize,
+	}
+
+	for {
+		var batch []*db.Subscriber
+		var nextKey *db.ScanKey
+
+		batch, nextKey, err = invokeSubscribers(cmd, newFunc, stackName, query)
+		if err != nil {
+			return
+		} else if err = out.write(batch); err != nil {
+			return
+		}
+
+		if query.StartKey = nextKey; nextKey == nil {
+			break
+		}
+	}
+	return out.finish()
+}
+
+func parseSince(since string) (t time.Time, err error) {
+	if since == "" {
+		return
+	} else if t, err = time.Parse(time.DateOnly, since); err == nil {
+		return
+	} else if t, err = time.Parse(time.RFC3339, since); err != nil {
+		const errFmt = "--%s must be in YYYY-MM-DD or RFC 3339 format: %w"
+		err = fmt.Errorf(errFmt, FlagSince, err)
+	}
+	return
+}
+
+func invokeSubscribers(
+	cmd *cobra.Command,
+	newFunc EListManFactoryFunc,
+	stackName string,
+	query events.SubscribersEvent,
+) (subs []*db.Subscriber, nextKey *db.ScanKey, err error) {
+	ctx := context.Background()
+	evt := &events.CommandLineEvent{
+		EListManCommand: events.CommandLineSubscribersEvent,
+		Subscribers:     &query,
+	}
+	response := &events.SubscribersResponse{}
+
+	if err = newFunc.Invoke(ctx, stackName, evt, response); err != nil {
+		err = fmt.Errorf("failed to get subscribers: %w", err)
+	} else if !response.Success {
+		err = errors.New("failed to get subscribers: " + response.Details)
+	} else {
+		subs, nextKey = response.Subscribers, response.NextKey
+	}
+	return
+}
+
+func printJson(cmd *cobra.Command, data any) (err error) {
+	var output []byte
+
+	if output, err = json.MarshalIndent(data, "", "  "); err == nil {
+		cmd.Println(string(output))
+	}
+	return
+}
+
+// subscribersWriter writes each batch of subscribers as soon as
+// listSubscribers receives it, so that listSubscribers needn't keep every
+// subscriber in memory.
+//
+// write doesn't write anything until it receives at least one subscriber, and
+// finish writes whatever must follow the last batch.
+type subscribersWriter interface {
+	write(subs []*db.Subscriber) error
+	finish() error
+}
+
+func newSubscribersWriter(
+	w io.Writer, format string, status db.SubscriberStatus,
+) subscribersWriter {
+	if format == outputJson {
+		return &subscribersJsonWriter{w: w}
+	}
+	return &subscribersTableWriter{
+		w: w, tw: tabwriter.NewWriter(w, 0, 4, 2, ' ', 0), status: status,
+	}
+}
+
+// subscribersJsonWriter writes every subscriber as an element of a single JSON
+// array, formatted the same as printJson would format it.
+type subscribersJsonWriter struct {
+	w io.Writer
+	n int
+}
+
+func (jw *subscribersJsonWriter) write(subs []*db.Subscriber) (err error) {
+	for _, sub := range subs {
+		var data []byte
+		separator := ",\n  "
+
+		if jw.n == 0 {
+			separator = "[\n  "
+		}
+		if data, err = json.MarshalIndent(sub, "  ", "  "); err != nil {
+			return
+		} else if _, err = io.WriteString(
+			jw.w, separator+string(data),
+		); err != nil {
+			return
+		}
+		jw.n++
+	}
+	return
+}
+
+func (jw *subscribersJsonWriter) finish() (err error) {
+	if jw.n == 0 {
+		_, err = io.WriteString(jw.w, "[]\n")
+	} else {
+		_, err = io.WriteString(jw.w, "\n]\n")
+	}
+	return
+}
+
+// subscribersTableWriter writes the table header before the first subscriber,
+// then flushes the table after each batch. finish writes the number of
+// subscribers listed.
+type subscribersTableWriter struct {
+	w      io.Writer
+	tw     *tabwriter.Writer
+	status db.SubscriberStatus
+	n      int
+}
+
+func (sw *subscribersTableWriter) write(subs []*db.Subscriber) error {
+	for _, sub := range subs {
+		if sw.n == 0 {
+			fmt.Fprintln(sw.tw, "EMAIL\tSINCE\tTAGS\tSOURCE")
+		}
+		source := ""
+		if sub.Signup != nil {
+			source = sub.Signup.Source
+		}
+		fmt.Fprintf(
+			sw.tw,
+			"%s\t%s\t%s\t%s\n",
+			sub.Email,
+			agent.StatusTime(sub).Format(subscribersTimeFormat),
+			orDash(strings.Join(sub.Tags, ",")),
+			orDash(source),
+		)
+		sw.n++
+	}
+	return sw.tw.Flush()
+}
+
+func (sw *subscribersTableWriter) finish() error {
+	_, err := fmt.Fprintf(sw.w, "%s\n", subscriberCount(sw.n, sw.status))
+	return err
+}
+
+func subscriberCount(n int, status db.SubscriberStatus) string {
+	if n == 1 {
+		return fmt.Sprintf("1 %s subscriber.", status)
+	}
+	return fmt.Sprintf("%d %s subscribers.", n, status)
+}
+
+func writeSubscriber(w io.Writer, sub *db.Subscriber) {
+	fmt.Fprintf(w, "Email:        %s\n", sub.Email)
+	if sub.List != "" {
+		fmt.Fprintf(w, "List:         %s\n", sub.List)
+	}
+	fmt.Fprintf(w, "Status:       %s\n", sub.Status)
+	fmt.Fprintf(
+		w, "Since:        %s\n",
+		agent.StatusTime(sub).Format(subscribersTimeFormat),
+	)
+	if sub.Status == db.SubscriberPending {
+		expires := sub.Timestamp.Format(subscribersTimeFormat)
+		fmt.Fprintf(w, "Expires:      %s\n", expires)
+		fmt.Fprintf(w, "Verify sent:  %d\n", sub.VerifySentCount)
+	}
+	fmt.Fprintf(w, "First name:   %s\n", orDash(sub.FirstName))
+	fmt.Fprintf(w, "Tags:         %s\n", orDash(strings.Join(sub.Tags, ", ")))
+	fmt.Fprintf(w, "Attributes:   %s\n", orDash(formatAttributes(sub)))
+
+	if sub.Signup == nil {
+		fmt.Fprintln(w, "Signup:       -")
+		return
+	}
+	for _, field := range []struct{ name, value string }{
+		{"Source", sub.Signup.Source},
+		{"UTM source", sub.Signup.UtmSource},
+		{"UTM medium", sub.Signup.UtmMedium},
+		{"UTM campaign", sub.Signup.UtmCampaign},
+		{"UTM term", sub.Signup.UtmTerm},
+		{"UTM content", sub.Signup.UtmContent},
+		{"Referrer", sub.Signup.Referrer},
+		{"Source IP", sub.Signup.SourceIp},
+		{"User agent", sub.Signup.UserAgent},
+	} {
+		if field.value != "" {
+			fmt.Fprintf(w, "%-14s%s\n", field.name+":", field.value)
+		}
+	}
+}
+
+func formatAttributes(sub *db.Subscriber) string {
+	attrs := make([]string, 0, len(sub.Attributes))
+	for _, name := range slices.Sorted(maps.Keys(sub.Attributes)) {
+		attrs = append(attrs, name+"="+sub.Attributes[name])
+	}
+	return strings.Join(attrs, ", ")
+}
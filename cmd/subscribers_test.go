@@ -0,0 +1,386 @@
+//go:build small_tests || all_tests
+
+package cmd
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/mbland/elistman/db"
+	"github.com/mbland/elistman/events"
+	"gotest.tools/assert"
+)
+
+const testSubscriberJson = `{
+  "Email": "foo@test.com",
+  "Uid": "00000000-1111-2222-3333-444444444444",
+  "List": "",
+  "Status": "verified",
+  "Timestamp": "2023-09-25T12:00:00Z",
+  "FirstName": "Foo",
+  "Attributes": {
+    "city": "Chicago",
+    "plan": "free"
+  },
+  "Tags": [
+    "essays",
+    "releases"
+  ],
+  "Signup": {
+    "Source": "footer",
+    "UtmSource": "newsletter",
+    "UtmMedium": "",
+    "UtmCampaign": "",
+    "UtmTerm": "",
+    "UtmContent": "",
+    "Referrer": "https://mike-bland.com/",
+    "SourceIp": "192.168.0.1",
+    "UserAgent": ""
+  },
+  "VerifySentCount": 1,
+  "VerifySentAt": "2023-09-24T12:00:00Z",
+  "PreviousUid": "00000000-0000-0000-0000-000000000000",
+  "PreviousUidExpires": "0001-01-01T00:00:00Z"
+}`
+
+const testPendingSubscriberJson = `{
+  "Email": "bar@test.com",
+  "Uid": "00000000-1111-2222-3333-555555555555",
+  "Status": "pending",
+  "Timestamp": "2023-09-26T12:00:00Z",
+  "VerifySentCount": 2
+}`
+
+func TestSubscribersGet(t *testing.T) {
+	setup := func() (f *CommandTestFixture, lambda *TestEListManFunc) {
+		lambda = NewTestEListManFunc()
+		cmd := newSubscribersCmd(lambda.GetFactoryFunc())
+		f = NewCommandTestFixture(cmd)
+		f.Cmd.SetArgs([]string{"get", "-s", TestStackName, "foo@test.com"})
+		return
+	}
+
+	t.Run("Succeeds", func(t *testing.T) {
+		f, lambda := setup()
+		lambda.SetResponseJson(
+			`{"Success": true, "Subscribers": [` + testSubscriberJson + `]}`,
+		)
+
+		const expectedOut = "" +
+			"Email:        foo@test.com\n" +
+			"Status:       verified\n" +
+			"Since:        2023-09-25T12:00:00Z\n" +
+			"First name:   Foo\n" +
+			"Tags:         essays, releases\n" +
+			"Attributes:   city=Chicago, plan=free\n" +
+			"Source:       footer\n" +
+			"UTM source:   newsletter\n" +
+			"Referrer:     https://mike-bland.com/\n" +
+			"Source IP:    192.168.0.1\n"
+		f.ExecuteAndAssertStdoutContains(t, expectedOut)
+
+		expectedReq := &events.CommandLineEvent{
+			EListManCommand: events.CommandLineSubscribersEvent,
+			Subscribers:     &events.SubscribersEvent{Email: "foo@test.com"},
+		}
+		lambda.AssertMatches(t, TestStackName, expectedReq)
+	})
+
+	t.Run("SucceedsForPendingSubscriberWithoutSignup", func(t *testing.T) {
+		f, lambda := setup()
+		lambda.SetResponseJson(
+			`{"Success": true, "Subscribers": [` +
+				testPendingSubscriberJson + `]}`,
+		)
+
+		const expectedOut = "" +
+			"Email:        bar@test.com\n" +
+			"Status:       pending\n" +
+			"Since:        2023-09-25T12:00:00Z\n" +
+			"Expires:      2023-09-26T12:00:00Z\n" +
+			"Verify sent:  2\n" +
+			"First name:   -\n" +
+			"Tags:         -\n" +
+			"Attributes:   -\n" +
+			"Signup:       -\n"
+		f.ExecuteAndAssertStdoutContains(t, expectedOut)
+	})
+
+	t.Run("PrintsJson", func(t *testing.T) {
+		f, lambda := setup()
+		f.Cmd.SetArgs([]string{
+			"get", "-s", TestStackName, "-o", "json", "foo@test.com",
+		})
+		lambda.SetResponseJson(
+			`{"Success": true, "Subscribers": [` + testSubscriberJson + `]}`,
+		)
+
+		f.ExecuteAndAssertStdoutContains(t, testSubscriberJson+"\n")
+	})
+
+	t.Run("PassesList", func(t *testing.T) {
+		f, lambda := setup()
+		f.Cmd.SetArgs([]string{
+			"get", "-s", TestStackName, "-l", "updates", "foo@test.com",
+		})
+		lambda.SetResponseJson(
+			`{"Success": true, "Subscribers": [` + testSubscriberJson + `]}`,
+		)
+
+		f.ExecuteAndAssertStdoutContains(t, "Email:        foo@test.com\n")
+
+		expectedReq := &events.CommandLineEvent{
+			EListManCommand: events.CommandLineSubscribersEvent,
+			Subscribers: &events.SubscribersEvent{
+				List: "updates", Email: "foo@test.com",
+			},
+		}
+		lambda.AssertMatches(t, TestStackName, expectedReq)
+	})
+
+	t.Run("RequiresStackNameFlag", func(t *testing.T) {
+		f, _ := setup()
+		argv := []string{"get", "foo@test.com"}
+		f.AssertFailsIfRequiredFlagMissing(t, FlagStackName, argv)
+	})
+
+	t.Run("FailsIfNotASubscriber", func(t *testing.T) {
+		f, lambda := setup()
+		lambda.SetResponseJson(`{"Success": true, "Subscribers": []}`)
+
+		const expectedErr = "foo@test.com is not a subscriber"
+		f.ExecuteAndAssertErrorContains(t, expectedErr)
+	})
+
+	t.Run("FailsIfOutputFormatInvalid", func(t *testing.T) {
+		f, _ := setup()
+		f.Cmd.SetArgs([]string{
+			"get", "-s", TestStackName, "-o", "yaml", "foo@test.com",
+		})
+
+		const expectedErr = `--output must be "table" or "json", got "yaml"`
+		f.ExecuteAndAssertErrorContains(t, expectedErr)
+	})
+
+	t.Run("FailsIfInvokingLambdaFails", func(t *testing.T) {
+		f, lambda := setup()
+		f.AssertReturnsLambdaError(t, lambda, "failed to get subscribers: ")
+	})
+
+	t.Run("FailsIfLambdaReturnsError", func(t *testing.T) {
+		f, lambda := setup()
+		lambda.SetResponseJson(`{"Success": false, "Details": "test failure"}`)
+
+		const expectedErr = "failed to get subscribers: test failure"
+		f.ExecuteAndAssertErrorContains(t, expectedErr)
+	})
+}
+
+func TestSubscribersList(t *testing.T) {
+	setup := func() (f *CommandTestFixture, lambda *TestEListManFunc) {
+		lambda = NewTestEListManFunc()
+		cmd := newSubscribersCmd(lambda.GetFactoryFunc())
+		f = NewCommandTestFixture(cmd)
+		f.Cmd.SetArgs([]string{
+			"list", "-s", TestStackName, "--status", "verified",
+		})
+		return
+	}
+
+	newRequest := func(
+		status db.SubscriberStatus, startKey *db.ScanKey,
+	) *events.CommandLineEvent {
+		return &events.CommandLineEvent{
+			EListManCommand: events.CommandLineSubscribersEvent,
+			Subscribers: &events.SubscribersEvent{
+				Status:    status,
+				BatchSize: subscribersBatchSize,
+				StartKey:  startKey,
+			},
+		}
+	}
+
+	t.Run("Succeeds", func(t *testing.T) {
+		f, lambda := setup()
+		lambda.SetResponseJson(`{
+		  "Success": true,
+		  "Subscribers": [
+		    ` + testSubscriberJson + `,
+		    {
+		      "Email": "baz@test.com",
+		      "Status": "verified",
+		      "Timestamp": "2023-09-18T12:00:00Z"
+		    }
+		  ]
+		}`)
+
+		const expectedOut = "" +
+			"EMAIL         SINCE                 TAGS             SOURCE\n" +
+			"foo@test.com  2023-09-25T12:00:00Z  essays,releases  footer\n" +
+			"baz@test.com  2023-09-18T12:00:00Z  -                -\n" +
+			"2 verified subscribers.\n"
+		f.ExecuteAndAssertStdoutContains(t, expectedOut)
+		lambda.AssertMatches(
+			t, TestStackName, newRequest(db.SubscriberVerified, nil),
+		)
+	})
+
+	t.Run("ReportsIfNoSubscribersFound", func(t *testing.T) {
+		f, lambda := setup()
+		lambda.SetResponseJson(`{"Success": true, "Subscribers": []}`)
+
+		f.ExecuteAndAssertStdoutContains(t, "0 verified subscribers.\n")
+	})
+
+	t.Run("ListsInBatches", func(t *testing.T) {
+		f, lambda := setup()
+		f.Cmd.SetArgs([]string{
+			"list", "-s", TestStackName, "--status", "pending",
+		})
+		lambda.QueueResponseJson(`{
+		  "Success": true,
+		  "Subscribers": [` + testPendingSubscriberJson + `],
+		  "NextKey": {"Email": "bar@test.com"}
+		}`)
+		lambda.QueueResponseJson(`{"Success": true, "Subscribers": []}`)
+
+		const expectedOut = "" +
+			"EMAIL         SINCE                 TAGS  SOURCE\n" +
+			"bar@test.com  2023-09-25T12:00:00Z  -     -\n" +
+			"1 pending subscriber.\n"
+		f.ExecuteAndAssertStdoutContains(t, expectedOut)
+
+		nextKey := &db.ScanKey{Email: "bar@test.com"}
+		expectedReqs := []any{
+			newRequest(db.SubscriberPending, nil),
+			newRequest(db.SubscriberPending, nextKey),
+		}
+		assert.DeepEqual(t, expectedReqs, lambda.InvokeReqs)
+	})
+
+	t.Run("WritesEachBatchAsItArrives", func(t *testing.T) {
+		f, lambda := setup()
+		lambda.QueueResponseJson(`{
+		  "Success": true,
+		  "Subscribers": [` + testSubscriberJson + `],
+		  "NextKey": {"Email": "foo@test.com"}
+		}`)
+		lambda.QueueResponseJson(
+			`{"Success": false, "Details": "test failure"}`,
+		)
+
+		err := f.Cmd.Execute()
+
+		assert.ErrorContains(t, err, "failed to get subscribers: test failure")
+		const expectedOut = "" +
+			"EMAIL         SINCE                 TAGS             SOURCE\n" +
+			"foo@test.com  2023-09-25T12:00:00Z  essays,releases  footer\n"
+		assert.Equal(t, expectedOut, f.Stdout.String())
+	})
+
+	t.Run("PassesFilters", func(t *testing.T) {
+		f, lambda := setup()
+		f.Cmd.SetArgs([]string{
+			"list", "-s", TestStackName,
+			"--status", "verified",
+			"--since", "2023-09-18",
+			"--domain", "test.com",
+			"--list", "updates",
+		})
+		lambda.SetResponseJson(`{"Success": true, "Subscribers": []}`)
+
+		f.ExecuteAndAssertStdoutContains(t, "0 verified subscribers.\n")
+
+		expectedReq := newRequest(db.SubscriberVerified, nil)
+		expectedReq.Subscribers.List = "updates"
+		expectedReq.Subscribers.Since = time.Date(
+			2023, time.September, 18, 0, 0, 0, 0, time.UTC,
+		)
+		expectedReq.Subscribers.Domain = "test.com"
+		lambda.AssertMatches(t, TestStackName, expectedReq)
+	})
+
+	t.Run("PrintsJson", func(t *testing.T) {
+		f, lambda := setup()
+		f.Cmd.SetArgs([]string{
+			"list", "-s", TestStackName, "--status", "verified", "-o", "json",
+		})
+		lambda.SetResponseJson(`{"Success": true, "Subscribers": []}`)
+
+		f.ExecuteAndAssertStdoutContains(t, "[]\n")
+	})
+
+	t.Run("PrintsJsonArrayAcrossBatches", func(t *testing.T) {
+		f, lambda := setup()
+		f.Cmd.SetArgs([]string{
+			"list", "-s", TestStackName, "--status", "verified", "-o", "json",
+		})
+		lambda.QueueResponseJson(`{
+		  "Success": true,
+		  "Subscribers": [` + testSubscriberJson + `],
+		  "NextKey": {"Email": "foo@test.com"}
+		}`)
+		lambda.QueueResponseJson(`{
+		  "Success": true,
+		  "Subscribers": [` + testPendingSubscriberJson + `]
+		}`)
+
+		err := f.Cmd.Execute()
+
+		assert.NilError(t, err)
+		var subs []*db.Subscriber
+		assert.NilError(t, json.Unmarshal([]byte(f.Stdout.String()), &subs))
+		assert.Equal(t, 2, len(subs))
+		expected := NewCommandTestFixture(newSubscribersCmd(nil))
+		assert.NilError(t, printJson(expected.Cmd, subs))
+		assert.Equal(t, expected.Stdout.String(), f.Stdout.String())
+	})
+
+	t.Run("RequiresStackNameFlag", func(t *testing.T) {
+		f, _ := setup()
+		argv := []string{"list", "--status", "verified"}
+		f.AssertFailsIfRequiredFlagMissing(t, FlagStackName, argv)
+	})
+
+	t.Run("RequiresStatusFlag", func(t *testing.T) {
+		f, _ := setup()
+		argv := []string{"list", "-s", TestStackName}
+		f.AssertFailsIfRequiredFlagMissing(t, FlagStatus, argv)
+	})
+
+	t.Run("FailsIfStatusInvalid", func(t *testing.T) {
+		f, _ := setup()
+		f.Cmd.SetArgs([]string{
+			"list", "-s", TestStackName, "--status", "bogus",
+		})
+
+		const expectedErr = `--status must be "pending" or "verified", ` +
+			`got "bogus"`
+		f.ExecuteAndAssertErrorContains(t, expectedErr)
+	})
+
+	t.Run("FailsIfSinceInvalid", func(t *testing.T) {
+		f, _ := setup()
+		f.Cmd.SetArgs([]string{
+			"list", "-s", TestStackName, "--status", "verified",
+			"--since", "yesterday",
+		})
+
+		const expectedErr = "--since must be in YYYY-MM-DD or RFC 3339 format"
+		f.ExecuteAndAssertErrorContains(t, expectedErr)
+	})
+
+	t.Run("FailsIfInvokingLambdaFails", func(t *testing.T) {
+		f, lambda := setup()
+		f.AssertReturnsLambdaError(t, lambda, "failed to get subscribers: ")
+	})
+
+	t.Run("FailsIfLambdaReturnsError", func(t *testing.T) {
+		f, lambda := setup()
+		lambda.SetResponseJson(`{"Success": false, "Details": "test failure"}`)
+
+		const expectedErr = "failed to get subscribers: test failure"
+		f.ExecuteAndAssertErrorContains(t, expectedErr)
+	})
+}
@@ -11,6 +11,8 @@ const (
 	TestStackName   = "elistman-test"
 	TestFunctionArn = "arn:aws:lambda:us-east-1:0123456789:function:" +
 		"elistman-dev-Function-0123456789"
+	TestTableName   = "elistman-subscribers"
+	TestPostgresUrl = "postgres://elistman@localhost/elistman"
 )
 
 var TestStack cftypes.Stack = cftypes.Stack{
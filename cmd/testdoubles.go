@@ -6,8 +6,11 @@ import (
 	"context"
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/mbland/elistman/db"
+	"github.com/mbland/elistman/testdata"
 	"gotest.tools/assert"
 )
 
@@ -28,12 +31,17 @@ func (tlc *TestLambdaClient) Invoke(
 	return tlc.InvokeOutput, tlc.InvokeError
 }
 
+// TestEListManFunc returns InvokeResJson from every Invoke, unless
+// InvokeResJsonQueue isn't empty. In that case, each Invoke returns the next
+// response from the queue instead.
 type TestEListManFunc struct {
-	StackName       string
-	CreateFuncError error
-	InvokeReq       any
-	InvokeResJson   []byte
-	InvokeError     error
+	StackName          string
+	CreateFuncError    error
+	InvokeReq          any
+	InvokeReqs         []any
+	InvokeResJson      []byte
+	InvokeResJsonQueue [][]byte
+	InvokeError        error
 }
 
 func NewTestEListManFunc() *TestEListManFunc {
@@ -51,13 +59,25 @@ func (lambda *TestEListManFunc) SetResponseJson(resJson string) {
 	lambda.InvokeResJson = []byte(resJson)
 }
 
+func (lambda *TestEListManFunc) QueueResponseJson(resJson string) {
+	lambda.InvokeResJsonQueue = append(
+		lambda.InvokeResJsonQueue, []byte(resJson),
+	)
+}
+
 func (l *TestEListManFunc) Invoke(_ context.Context, req, res any) error {
 	l.InvokeReq = req
+	l.InvokeReqs = append(l.InvokeReqs, req)
 
 	if l.InvokeError != nil {
 		return l.InvokeError
 	}
-	return json.Unmarshal(l.InvokeResJson, res)
+	resJson := l.InvokeResJson
+	if len(l.InvokeResJsonQueue) != 0 {
+		resJson = l.InvokeResJsonQueue[0]
+		l.InvokeResJsonQueue = l.InvokeResJsonQueue[1:]
+	}
+	return json.Unmarshal(resJson, res)
 }
 
 func (l *TestEListManFunc) AssertMatches(
@@ -68,3 +88,92 @@ func (l *TestEListManFunc) AssertMatches(
 	assert.Equal(t, stackName, l.StackName, "stack names should match")
 	assert.DeepEqual(t, expectedEvent, l.InvokeReq)
 }
+
+// TestSubscriberDb keeps the subscribers of each list in a db.MemoryDb.
+//
+// Every MemoryDb expires pending subscribers whose Timestamp is before
+// testdata.TestTimestamp. Setting ProcessError, PutError, or IsErasedError
+// causes the corresponding method of every list's database to fail. Setting
+// ListError causes both ListTombstones and ListErasures to fail.
+type TestSubscriberDb struct {
+	TableName     string
+	PostgresUrl   string
+	CreateError   error
+	ProcessError  error
+	PutError      error
+	IsErasedError error
+	ListError     error
+	Lists         map[string]*db.MemoryDb
+}
+
+func NewTestSubscriberDb() *TestSubscriberDb {
+	return &TestSubscriberDb{Lists: map[string]*db.MemoryDb{}}
+}
+
+func (tdb *TestSubscriberDb) GetFactoryFunc() SubscriberDbFactoryFunc {
+	return func(tableName, postgresUrl string) (SubscriberDbFunc, error) {
+		tdb.TableName = tableName
+		tdb.PostgresUrl = postgresUrl
+		forList := func(list string) SubscriberDb {
+			return &testListDb{tdb.ForList(list), tdb}
+		}
+		return forList, tdb.CreateError
+	}
+}
+
+// ForList returns the MemoryDb for list, creating it if necessary.
+func (tdb *TestSubscriberDb) ForList(list string) *db.MemoryDb {
+	listDb, ok := tdb.Lists[list]
+	if !ok {
+		listDb = db.NewMemoryDb()
+		listDb.CurrentTime = func() time.Time { return testdata.TestTimestamp }
+		tdb.Lists[list] = listDb
+	}
+	return listDb
+}
+
+type testListDb struct {
+	*db.MemoryDb
+	parent *TestSubscriberDb
+}
+
+func (ldb *testListDb) Put(ctx context.Context, sub *db.Subscriber) error {
+	if ldb.parent.PutError != nil {
+		return ldb.parent.PutError
+	}
+	return ldb.MemoryDb.Put(ctx, sub)
+}
+
+func (ldb *testListDb) IsErased(
+	ctx context.Context, hash string,
+) (bool, error) {
+	if ldb.parent.IsErasedError != nil {
+		return false, ldb.parent.IsErasedError
+	}
+	return ldb.MemoryDb.IsErased(ctx, hash)
+}
+
+func (ldb *testListDb) ListTombstones(
+	ctx context.Context,
+) ([]*db.Tombstone, error) {
+	if ldb.parent.ListError != nil {
+		return nil, ldb.parent.ListError
+	}
+	return ldb.MemoryDb.ListTombstones(ctx)
+}
+
+func (ldb *testListDb) ListErasures(ctx context.Context) ([]string, error) {
+	if ldb.parent.ListError != nil {
+		return nil, ldb.parent.ListError
+	}
+	return ldb.MemoryDb.ListErasures(ctx)
+}
+
+func (ldb *testListDb) ProcessSubscribers(
+	ctx context.Context, status db.SubscriberStatus, sp db.SubscriberProcessor,
+) error {
+	if ldb.parent.ProcessError != nil {
+		return ldb.parent.ProcessError
+	}
+	return ldb.MemoryDb.ProcessSubscribers(ctx, status, sp)
+}
@@ -0,0 +1,176 @@
+// Copyright © 2023 Mike Bland <mbland@acm.org>
+// See LICENSE.txt for details.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/mbland/elistman/email"
+	"github.com/mbland/elistman/events"
+	"github.com/spf13/cobra"
+)
+
+const welcomeDescription = `` +
+	`Shows, sets, or deletes the message welcoming new subscribers
+
+The EListMan Lambda sends the welcome message to each subscriber right after
+the subscriber opens the verification link. The message goes out with the same
+unsubscribe headers and footers as every other message sent to the list. If
+there's no welcome message, new subscribers don't receive one.
+
+Each list configured via the LISTS deployment parameter has its own welcome
+message, selected by the --list flag.`
+
+const welcomeSetDescription = `` +
+	`Reads a JSON object from standard input describing the welcome message:
+
+` + email.ExampleMessageJson + `
+
+It validates the message, then replaces the current welcome message with it.
+
+If the --markdown flag specifies a Markdown file, it will generate the TextBody
+and HtmlBody of the message from that file, just like "send".`
+
+func init() {
+	rootCmd.AddCommand(newWelcomeCmd(NewEListManLambda))
+}
+
+func newWelcomeCmd(newFunc EListManFactoryFunc) (cmd *cobra.Command) {
+	cmd = &cobra.Command{
+		Use:   "welcome",
+		Short: "Show, set, or delete the message welcoming new subscribers",
+		Long:  welcomeDescription,
+	}
+	cmd.AddCommand(newWelcomeShowCmd(newFunc))
+	cmd.AddCommand(newWelcomeSetCmd(newFunc))
+	cmd.AddCommand(newWelcomeClearCmd(newFunc))
+	return
+}
+
+func newWelcomeShowCmd(newFunc EListManFactoryFunc) (cmd *cobra.Command) {
+	cmd = &cobra.Command{
+		Use:   "show",
+		Short: "Print the welcome message as JSON",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return showWelcomeMessage(cmd, newFunc, getStackName(cmd))
+		},
+	}
+	registerStackName(cmd)
+	registerList(cmd)
+	cmd.MarkFlagRequired(FlagStackName)
+	return
+}
+
+func newWelcomeSetCmd(newFunc EListManFactoryFunc) (cmd *cobra.Command) {
+	cmd = &cobra.Command{
+		Use:   "set",
+		Short: "Replace the welcome message",
+		Long:  welcomeSetDescription,
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return setWelcomeMessage(cmd, newFunc, getStackName(cmd))
+		},
+	}
+	registerStackName(cmd)
+	registerList(cmd)
+	registerMarkdown(cmd)
+	cmd.MarkFlagRequired(FlagStackName)
+	return
+}
+
+func newWelcomeClearCmd(newFunc EListManFactoryFunc) (cmd *cobra.Command) {
+	cmd = &cobra.Command{
+		Use:   "clear",
+		Short: "Delete the welcome message",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return clearWelcomeMessage(cmd, newFunc, getStackName(cmd))
+		},
+	}
+	registerStackName(cmd)
+	registerList(cmd)
+	cmd.MarkFlagRequired(FlagStackName)
+	return
+}
+
+func showWelcomeMessage(
+	cmd *cobra.Command, newFunc EListManFactoryFunc, stackName string,
+) (err error) {
+	cmd.SilenceUsage = true
+	var msg *email.Message
+	query := events.WelcomeEvent{List: getListName(cmd)}
+
+	if msg, err = invokeWelcome(
+		newFunc, stackName, query, "failed to get welcome message",
+	); err != nil {
+		return
+	} else if msg == nil {
+		cmd.Println("There's no welcome message.")
+		return
+	}
+	return printJson(cmd, msg)
+}
+
+func setWelcomeMessage(
+	cmd *cobra.Command, newFunc EListManFactoryFunc, stackName string,
+) (err error) {
+	cmd.SilenceUsage = true
+	var msg *email.Message
+
+	if msg, err = readMessage(
+		cmd.InOrStdin(), getMarkdownPath(cmd),
+	); err != nil {
+		return
+	}
+
+	update := events.WelcomeEvent{
+		List: getListName(cmd), Set: true, Message: msg,
+	}
+	if _, err = invokeWelcome(
+		newFunc, stackName, update, "failed to set welcome message",
+	); err == nil {
+		cmd.Println("Updated the welcome message.")
+	}
+	return
+}
+
+func clearWelcomeMessage(
+	cmd *cobra.Command, newFunc EListManFactoryFunc, stackName string,
+) (err error) {
+	cmd.SilenceUsage = true
+	update := events.WelcomeEvent{List: getListName(cmd), Set: true}
+
+	if _, err = invokeWelcome(
+		newFunc, stackName, update, "failed to delete welcome message",
+	); err == nil {
+		cmd.Println("Deleted the welcome message.")
+	}
+	return
+}
+
+func invokeWelcome(
+	newFunc EListManFactoryFunc,
+	stackName string,
+	e events.WelcomeEvent,
+	errPrefix string,
+) (msg *email.Message, err error) {
+	ctx := context.Background()
+	evt := &events.CommandLineEvent{
+		EListManCommand: events.CommandLineWelcomeEvent,
+		Welcome:         &e,
+	}
+	response := &events.WelcomeResponse{}
+
+	if err = newFunc.Invoke(ctx, stackName, evt, response); err != nil {
+		err = fmt.Errorf("%s: %w", errPrefix, err)
+	} else if !response.Success {
+		err = errors.New(errPrefix + ": " + response.Details)
+	} else {
+		msg = response.Message
+	}
+	return
+}
@@ -0,0 +1,153 @@
+//go:build small_tests || all_tests
+
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mbland/elistman/email"
+	"github.com/mbland/elistman/events"
+	"gotest.tools/assert"
+)
+
+func TestWelcomeShow(t *testing.T) {
+	setup := func() (f *CommandTestFixture, lambda *TestEListManFunc) {
+		lambda = NewTestEListManFunc()
+		f = NewCommandTestFixture(newWelcomeCmd(lambda.GetFactoryFunc()))
+		f.Cmd.SetArgs([]string{"show", "-s", TestStackName})
+		return
+	}
+
+	t.Run("Succeeds", func(t *testing.T) {
+		f, lambda := setup()
+		lambda.SetResponseJson(
+			`{"Success": true, "Message": ` + email.ExampleMessageJson + `}`,
+		)
+
+		f.ExecuteAndAssertStdoutContains(t, `"Subject": "Test object"`)
+
+		expectedReq := &events.CommandLineEvent{
+			EListManCommand: events.CommandLineWelcomeEvent,
+			Welcome:         &events.WelcomeEvent{},
+		}
+		lambda.AssertMatches(t, TestStackName, expectedReq)
+	})
+
+	t.Run("ReportsIfNoWelcomeMessage", func(t *testing.T) {
+		f, lambda := setup()
+		f.Cmd.SetArgs([]string{"show", "-s", TestStackName, "-l", "updates"})
+		lambda.SetResponseJson(`{"Success": true}`)
+
+		f.ExecuteAndAssertStdoutContains(t, "There's no welcome message.\n")
+
+		expectedReq := &events.CommandLineEvent{
+			EListManCommand: events.CommandLineWelcomeEvent,
+			Welcome:         &events.WelcomeEvent{List: "updates"},
+		}
+		lambda.AssertMatches(t, TestStackName, expectedReq)
+	})
+
+	t.Run("RequiresStackNameFlag", func(t *testing.T) {
+		f, _ := setup()
+		f.AssertFailsIfRequiredFlagMissing(t, FlagStackName, []string{"show"})
+	})
+
+	t.Run("FailsIfInvokingLambdaFails", func(t *testing.T) {
+		f, lambda := setup()
+		f.AssertReturnsLambdaError(
+			t, lambda, "failed to get welcome message: ",
+		)
+	})
+
+	t.Run("FailsIfLambdaReturnsError", func(t *testing.T) {
+		f, lambda := setup()
+		lambda.SetResponseJson(`{"Success": false, "Details": "test failure"}`)
+
+		const expectedErr = "failed to get welcome message: test failure"
+		f.ExecuteAndAssertErrorContains(t, expectedErr)
+	})
+}
+
+func TestWelcomeSet(t *testing.T) {
+	setup := func() (f *CommandTestFixture, lambda *TestEListManFunc) {
+		lambda = NewTestEListManFunc()
+		f = NewCommandTestFixture(newWelcomeCmd(lambda.GetFactoryFunc()))
+		f.Cmd.SetIn(strings.NewReader(email.ExampleMessageJson))
+		f.Cmd.SetArgs([]string{"set", "-s", TestStackName})
+		return
+	}
+
+	t.Run("Succeeds", func(t *testing.T) {
+		f, lambda := setup()
+		lambda.SetResponseJson(`{"Success": true}`)
+
+		f.ExecuteAndAssertStdoutContains(t, "Updated the welcome message.\n")
+
+		expectedReq := &events.CommandLineEvent{
+			EListManCommand: events.CommandLineWelcomeEvent,
+			Welcome: &events.WelcomeEvent{
+				Set: true, Message: email.ExampleMessage,
+			},
+		}
+		lambda.AssertMatches(t, TestStackName, expectedReq)
+	})
+
+	t.Run("SucceedsWithMarkdownFileForList", func(t *testing.T) {
+		f, lambda := setup()
+		f.Cmd.SetIn(strings.NewReader(testMarkdownMessageJson))
+		path := writeMarkdownFile(t, "Welcome, *friend*!")
+		f.Cmd.SetArgs([]string{
+			"set", "-s", TestStackName, "-l", "updates", "--markdown", path,
+		})
+		lambda.SetResponseJson(`{"Success": true}`)
+
+		f.ExecuteAndAssertStdoutContains(t, "Updated the welcome message.\n")
+	})
+
+	t.Run("FailsIfMessageIsInvalid", func(t *testing.T) {
+		f, lambda := setup()
+		f.Cmd.SetIn(strings.NewReader("{}"))
+
+		f.ExecuteAndAssertErrorContains(t, "message failed validation")
+		assert.Equal(t, 0, len(lambda.InvokeReqs))
+	})
+
+	t.Run("FailsIfLambdaReturnsError", func(t *testing.T) {
+		f, lambda := setup()
+		lambda.SetResponseJson(`{"Success": false, "Details": "test failure"}`)
+
+		const expectedErr = "failed to set welcome message: test failure"
+		f.ExecuteAndAssertErrorContains(t, expectedErr)
+	})
+}
+
+func TestWelcomeClear(t *testing.T) {
+	setup := func() (f *CommandTestFixture, lambda *TestEListManFunc) {
+		lambda = NewTestEListManFunc()
+		f = NewCommandTestFixture(newWelcomeCmd(lambda.GetFactoryFunc()))
+		f.Cmd.SetArgs([]string{"clear", "-s", TestStackName})
+		return
+	}
+
+	t.Run("Succeeds", func(t *testing.T) {
+		f, lambda := setup()
+		lambda.SetResponseJson(`{"Success": true}`)
+
+		f.ExecuteAndAssertStdoutContains(t, "Deleted the welcome message.\n")
+
+		expectedReq := &events.CommandLineEvent{
+			EListManCommand: events.CommandLineWelcomeEvent,
+			Welcome:         &events.WelcomeEvent{Set: true},
+		}
+		lambda.AssertMatches(t, TestStackName, expectedReq)
+	})
+
+	t.Run("FailsIfLambdaReturnsError", func(t *testing.T) {
+		f, lambda := setup()
+		lambda.SetResponseJson(`{"Success": false, "Details": "test failure"}`)
+
+		const expectedErr = "failed to delete welcome message: test failure"
+		f.ExecuteAndAssertErrorContains(t, expectedErr)
+	})
+}
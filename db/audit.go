@@ -0,0 +1,66 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/mbland/elistman/ops"
+)
+
+// AuditEvent records an operation that changed the status of an address.
+//
+// Reason is the ops.RemoveReason for AuditRemove events, and empty for all
+// others.
+//
+// Source identifies where the request for the operation came from, and is
+// usually one of the AuditSource* constants. RequestId identifies the specific
+// request, if available.
+type AuditEvent struct {
+	Email     string
+	Operation AuditOperation
+	Reason    ops.RemoveReason
+	Source    string
+	RequestId string
+	Timestamp time.Time
+}
+
+type AuditOperation string
+
+const (
+	AuditSubscribe   AuditOperation = "subscribe"
+	AuditVerify      AuditOperation = "verify"
+	AuditUnsubscribe AuditOperation = "unsubscribe"
+	AuditImport      AuditOperation = "import"
+	AuditRemove      AuditOperation = "remove"
+	AuditRestore     AuditOperation = "restore"
+)
+
+// Sources of the requests recorded in AuditEvents.
+const (
+	// An API Gateway request, identified by its request ID.
+	AuditSourceApi = "api"
+
+	// An unsubscribe email, identified by its SES message ID.
+	AuditSourceMailto = "mailto"
+
+	// An SES bounce or complaint notification, identified by the SES message
+	// ID of the original message.
+	AuditSourceSns = "sns"
+
+	// A command line interface request, identified by its Lambda request ID.
+	AuditSourceCli = "cli"
+)
+
+// AuditLog saves and retrieves AuditEvent records.
+//
+// AuditLog otherwise only ever appends events. Deleting a subscriber doesn't
+// delete the subscriber's events. Only DeleteAuditEvents does, and it exists
+// only to erase an address's data upon request.
+//
+// GetAuditEvents returns every AuditEvent for the email address in no
+// particular order, or an empty slice if there are none.
+type AuditLog interface {
+	PutAuditEvent(ctx context.Context, event *AuditEvent) error
+	GetAuditEvents(ctx context.Context, email string) ([]*AuditEvent, error)
+	DeleteAuditEvents(ctx context.Context, email string) error
+}
@@ -0,0 +1,51 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/mbland/elistman/types"
+)
+
+// Campaign records the history of sending a message to the entire list.
+//
+// ProdAgent creates a Campaign when it begins sending a message to the list,
+// and updates it as the send progresses, including when resuming a send.
+// FinishTime remains the zero time until the Campaign is complete.
+//
+// TagFilter is the TagFilter expression selecting the subscribers to receive
+// the message, or empty if the Campaign is for every subscriber.
+type Campaign struct {
+	Id          string
+	Subject     string
+	MessageHash string
+	TagFilter   string
+	StartTime   time.Time
+	FinishTime  time.Time
+	NumSent     int
+	NumFailed   int
+	Status      CampaignStatus
+}
+
+type CampaignStatus string
+
+const (
+	CampaignSending    CampaignStatus = "sending"
+	CampaignComplete   CampaignStatus = "complete"
+	CampaignIncomplete CampaignStatus = "incomplete"
+)
+
+// CampaignStore saves and retrieves Campaign records.
+//
+// ListCampaigns returns every Campaign in no particular order.
+type CampaignStore interface {
+	GetCampaign(ctx context.Context, id string) (*Campaign, error)
+	PutCampaign(ctx context.Context, campaign *Campaign) error
+	ListCampaigns(ctx context.Context) ([]*Campaign, error)
+}
+
+// ErrCampaignNotFound indicates that there's no Campaign with a given ID.
+//
+// CampaignStore.GetCampaign returns this error when the underlying request
+// succeeded, but there was no such Campaign.
+const ErrCampaignNotFound = types.SentinelError("campaign not found")
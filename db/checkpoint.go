@@ -0,0 +1,49 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/mbland/elistman/types"
+)
+
+// SendCheckpoint records the progress of sending a message to the entire list.
+//
+// ProdAgent saves a SendCheckpoint periodically while sending a message to the
+// list, and once more when it finishes or stops. If the send stops before
+// reaching every subscriber, the checkpoint enables resuming the send later.
+//
+// LastKey is the ScanKey of the last subscriber processed, or nil if no
+// subscribers have been processed yet. Database.MarkReceived records which
+// subscribers have received the campaign, so resuming never sends duplicate
+// messages, even if the checkpoint is slightly behind.
+//
+// MessageHash is the email.Message.Hash of the message being sent. Resuming a
+// send requires the same message, which this hash ensures.
+//
+// TagFilter is the TagFilter expression selecting the subscribers to receive
+// the message, or empty if the send is to every subscriber. Resuming a send
+// uses the same TagFilter.
+type SendCheckpoint struct {
+	CampaignId  string
+	MessageHash string
+	TagFilter   string
+	LastKey     *ScanKey
+	NumSent     int
+	Complete    bool
+	Timestamp   time.Time
+}
+
+// CheckpointStore saves and retrieves SendCheckpoint records.
+type CheckpointStore interface {
+	GetCheckpoint(
+		ctx context.Context, campaignId string,
+	) (*SendCheckpoint, error)
+	PutCheckpoint(ctx context.Context, checkpoint *SendCheckpoint) error
+}
+
+// ErrCheckpointNotFound indicates that a campaign has no SendCheckpoint.
+//
+// CheckpointStore.GetCheckpoint returns this error when the underlying request
+// succeeded, but there was no such SendCheckpoint.
+const ErrCheckpointNotFound = types.SentinelError("send checkpoint not found")
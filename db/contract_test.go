@@ -0,0 +1,800 @@
+//go:build small_tests || medium_tests || contract_tests || coverage_tests || all_tests
+
+package db
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mbland/elistman/email"
+	"github.com/mbland/elistman/ops"
+	"github.com/mbland/elistman/testutils"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+// contractDb comprises every interface that DynamoDb, MemoryDb, FileDb, and
+// PostgresDb implement.
+type contractDb interface {
+	Database
+	CheckpointStore
+	CampaignStore
+	ScheduleStore
+	AuditLog
+	TombstoneStore
+	ErasureStore
+	WelcomeStore
+}
+
+// newTestSubscriber returns a new pending Subscriber that expires in a day,
+// like those that agent.ProdAgent creates, so it won't expire during a test.
+func newTestSubscriber() *Subscriber {
+	sub := NewSubscriber(testutils.RandomString(8) + "@example.com")
+	sub.Timestamp = sub.Timestamp.Add(24 * time.Hour)
+	return sub
+}
+
+func sorted(subs []*Subscriber) (r []*Subscriber) {
+	r = make([]*Subscriber, len(subs))
+	copy(r, subs)
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].Email < r[j].Email
+	})
+	return
+}
+
+// testDatabaseContract validates the behavior that every contractDb
+// implementation must share.
+//
+// testDb must not contain any of TestSubscribers. scanDelay is how long to
+// wait after adding records before scanning for them, for implementations
+// whose scans are eventually consistent.
+func testDatabaseContract(
+	t *testing.T, testDb contractDb, scanDelay time.Duration,
+) {
+	ctx := context.Background()
+
+	t.Run("PutGetAndDeleteSucceed", func(t *testing.T) {
+		subscriber := newTestSubscriber()
+
+		putErr := testDb.Put(ctx, subscriber)
+		retrievedSubscriber, getErr := testDb.Get(ctx, subscriber.Email)
+		deleteErr := testDb.Delete(ctx, subscriber.Email)
+		_, getAfterDeleteErr := testDb.Get(ctx, subscriber.Email)
+		deleteAfterDeleteErr := testDb.Delete(ctx, subscriber.Email)
+
+		assert.NilError(t, putErr)
+		assert.NilError(t, getErr)
+		assert.NilError(t, deleteErr)
+		assert.DeepEqual(t, subscriber, retrievedSubscriber)
+		assert.Assert(
+			t, testutils.ErrorIs(getAfterDeleteErr, ErrSubscriberNotFound),
+		)
+		// Believe it or not, deleting a nonexistent record doesn't raise any
+		// kind of an error.
+		assert.NilError(t, deleteAfterDeleteErr)
+	})
+
+	t.Run("PutAndGetSucceedWithSignupMetadata", func(t *testing.T) {
+		subscriber := newTestSubscriber()
+		subscriber.Signup = &SignupMetadata{
+			Source: "footer", SourceIp: "192.0.2.1", UserAgent: "Mozilla/5.0",
+		}
+		defer testDb.Delete(ctx, subscriber.Email)
+
+		putErr := testDb.Put(ctx, subscriber)
+		retrievedSubscriber, getErr := testDb.Get(ctx, subscriber.Email)
+
+		assert.NilError(t, putErr)
+		assert.NilError(t, getErr)
+		assert.DeepEqual(t, subscriber, retrievedSubscriber)
+	})
+
+	t.Run("GetFailsIfSubscriberDoesNotExist", func(t *testing.T) {
+		subscriber := newTestSubscriber()
+
+		retrieved, err := testDb.Get(ctx, subscriber.Email)
+
+		assert.Assert(t, is.Nil(retrieved))
+		assert.Assert(t, testutils.ErrorIs(err, ErrSubscriberNotFound))
+		assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+	})
+
+	t.Run("Receipts", func(t *testing.T) {
+		hasReceived := func(
+			t *testing.T, email, campaignId string,
+		) bool {
+			t.Helper()
+			received, err := testDb.HasReceived(ctx, email, campaignId)
+			assert.NilError(t, err)
+			return received
+		}
+
+		t.Run("MarkReceivedSucceeds", func(t *testing.T) {
+			subscriber := newTestSubscriber()
+			assert.NilError(t, testDb.Put(ctx, subscriber))
+			defer testDb.Delete(ctx, subscriber.Email)
+			defer testDb.DeleteReceipts(ctx, subscriber.Email)
+
+			err := testDb.MarkReceived(ctx, subscriber.Email, "campaign-0")
+			assert.NilError(t, err)
+			err = testDb.MarkReceived(ctx, subscriber.Email, "campaign-1")
+			assert.NilError(t, err)
+			err = testDb.MarkReceived(ctx, subscriber.Email, "campaign-0")
+			assert.NilError(t, err)
+
+			assert.Assert(t, hasReceived(t, subscriber.Email, "campaign-0"))
+			assert.Assert(t, hasReceived(t, subscriber.Email, "campaign-1"))
+			assert.Assert(t, !hasReceived(t, subscriber.Email, "campaign-2"))
+
+			retrieved, err := testDb.Get(ctx, subscriber.Email)
+			assert.NilError(t, err)
+			assert.DeepEqual(t, subscriber, retrieved)
+		})
+
+		t.Run("SurviveDeletingSubscriber", func(t *testing.T) {
+			subscriber := newTestSubscriber()
+			assert.NilError(t, testDb.Put(ctx, subscriber))
+			defer testDb.DeleteReceipts(ctx, subscriber.Email)
+			err := testDb.MarkReceived(ctx, subscriber.Email, "campaign-0")
+			assert.NilError(t, err)
+
+			assert.NilError(t, testDb.Delete(ctx, subscriber.Email))
+
+			assert.Assert(t, hasReceived(t, subscriber.Email, "campaign-0"))
+			_, err = testDb.Get(ctx, subscriber.Email)
+			assert.Assert(t, testutils.ErrorIs(err, ErrSubscriberNotFound))
+		})
+
+		t.Run("DeleteReceiptsSucceeds", func(t *testing.T) {
+			subscriber := newTestSubscriber()
+			other := newTestSubscriber()
+			defer testDb.DeleteReceipts(ctx, other.Email)
+			for _, email := range []string{subscriber.Email, other.Email} {
+				err := testDb.MarkReceived(ctx, email, "campaign-0")
+				assert.NilError(t, err)
+				err = testDb.MarkReceived(ctx, email, "campaign-1")
+				assert.NilError(t, err)
+			}
+
+			err := testDb.DeleteReceipts(ctx, subscriber.Email)
+
+			assert.NilError(t, err)
+			assert.Assert(t, !hasReceived(t, subscriber.Email, "campaign-0"))
+			assert.Assert(t, !hasReceived(t, subscriber.Email, "campaign-1"))
+			assert.Assert(t, hasReceived(t, other.Email, "campaign-0"))
+			assert.Assert(t, hasReceived(t, other.Email, "campaign-1"))
+		})
+	})
+
+	t.Run("RotateUid", func(t *testing.T) {
+		t.Run("Succeeds", func(t *testing.T) {
+			subscriber := newTestSubscriber()
+			subscriber.Tags = []string{"essays"}
+			assert.NilError(t, testDb.Put(ctx, subscriber))
+			defer testDb.Delete(ctx, subscriber.Email)
+			newUid := uuid.New()
+			expires := time.Now().Add(time.Hour).Truncate(time.Second)
+
+			err := testDb.RotateUid(ctx, subscriber.Email, newUid, expires)
+
+			assert.NilError(t, err)
+			retrieved, err := testDb.Get(ctx, subscriber.Email)
+			assert.NilError(t, err)
+			expected := *subscriber
+			expected.Uid = newUid
+			expected.PreviousUid = subscriber.Uid
+			expected.PreviousUidExpires = expires
+			assert.DeepEqual(t, &expected, retrieved)
+		})
+
+		t.Run("FailsIfSubscriberDoesNotExist", func(t *testing.T) {
+			subscriber := newTestSubscriber()
+
+			err := testDb.RotateUid(
+				ctx, subscriber.Email, uuid.New(), time.Now(),
+			)
+
+			assert.Assert(t, testutils.ErrorIs(err, ErrSubscriberNotFound))
+			_, err = testDb.Get(ctx, subscriber.Email)
+			assert.Assert(t, testutils.ErrorIs(err, ErrSubscriberNotFound))
+		})
+	})
+
+	t.Run("RecordVerifySent", func(t *testing.T) {
+		resend := func(sub *Subscriber) *Subscriber {
+			updated := *sub
+			updated.VerifySentCount = sub.VerifySentCount + 1
+			updated.VerifySentAt = sub.Timestamp
+			updated.Timestamp = sub.Timestamp.Add(time.Hour)
+			updated.Tags = []string{"essays", "releases"}
+			return &updated
+		}
+
+		t.Run("Succeeds", func(t *testing.T) {
+			subscriber := newTestSubscriber()
+			subscriber.Tags = []string{"essays"}
+			assert.NilError(t, testDb.Put(ctx, subscriber))
+			defer testDb.Delete(ctx, subscriber.Email)
+			updated := resend(subscriber)
+
+			err := testDb.RecordVerifySent(ctx, updated, 0)
+
+			assert.NilError(t, err)
+			retrieved, err := testDb.Get(ctx, subscriber.Email)
+			assert.NilError(t, err)
+			assert.DeepEqual(t, updated, retrieved)
+
+			err = testDb.RecordVerifySent(ctx, resend(updated), 1)
+
+			assert.NilError(t, err)
+			retrieved, err = testDb.Get(ctx, subscriber.Email)
+			assert.NilError(t, err)
+			assert.Equal(t, 2, retrieved.VerifySentCount)
+		})
+
+		t.Run("FailsIfVerifySentCountChanged", func(t *testing.T) {
+			subscriber := newTestSubscriber()
+			subscriber.VerifySentCount = 2
+			assert.NilError(t, testDb.Put(ctx, subscriber))
+			defer testDb.Delete(ctx, subscriber.Email)
+
+			err := testDb.RecordVerifySent(ctx, resend(subscriber), 1)
+
+			assert.Assert(t, testutils.ErrorIs(err, ErrVerifySentChanged))
+			retrieved, err := testDb.Get(ctx, subscriber.Email)
+			assert.NilError(t, err)
+			assert.DeepEqual(t, subscriber, retrieved)
+		})
+
+		t.Run("FailsIfSubscriberVerified", func(t *testing.T) {
+			subscriber := newTestSubscriber()
+			subscriber.Status = SubscriberVerified
+			assert.NilError(t, testDb.Put(ctx, subscriber))
+			defer testDb.Delete(ctx, subscriber.Email)
+
+			err := testDb.RecordVerifySent(ctx, resend(subscriber), 0)
+
+			assert.Assert(t, testutils.ErrorIs(err, ErrVerifySentChanged))
+			retrieved, err := testDb.Get(ctx, subscriber.Email)
+			assert.NilError(t, err)
+			assert.DeepEqual(t, subscriber, retrieved)
+		})
+
+		t.Run("FailsIfSubscriberDoesNotExist", func(t *testing.T) {
+			subscriber := newTestSubscriber()
+
+			err := testDb.RecordVerifySent(ctx, resend(subscriber), 0)
+
+			assert.Assert(t, testutils.ErrorIs(err, ErrVerifySentChanged))
+			_, err = testDb.Get(ctx, subscriber.Email)
+			assert.Assert(t, testutils.ErrorIs(err, ErrSubscriberNotFound))
+		})
+	})
+
+	t.Run("Checkpoints", func(t *testing.T) {
+		t.Run("PutAndGetSucceed", func(t *testing.T) {
+			cp := newTestCheckpoint()
+
+			putErr := testDb.PutCheckpoint(ctx, cp)
+			retrieved, getErr := testDb.GetCheckpoint(ctx, cp.CampaignId)
+
+			assert.NilError(t, putErr)
+			assert.NilError(t, getErr)
+			assert.DeepEqual(t, cp, retrieved)
+		})
+
+		t.Run("GetFailsIfCheckpointDoesNotExist", func(t *testing.T) {
+			cp := newTestCheckpoint()
+
+			retrieved, err := testDb.GetCheckpoint(ctx, cp.CampaignId)
+
+			assert.Assert(t, is.Nil(retrieved))
+			assert.Assert(t, testutils.ErrorIs(err, ErrCheckpointNotFound))
+		})
+	})
+
+	t.Run("Campaigns", func(t *testing.T) {
+		t.Run("PutGetAndListSucceed", func(t *testing.T) {
+			campaigns := []*Campaign{newTestCampaign(), newTestCampaign()}
+			defer func() {
+				// DynamoDb stores Campaigns alongside Subscribers. Deleting
+				// these keys from any other implementation has no effect.
+				for _, c := range campaigns {
+					testDb.Delete(ctx, campaignKeyPrefix+c.Id)
+				}
+			}()
+
+			for _, c := range campaigns {
+				assert.NilError(t, testDb.PutCampaign(ctx, c))
+			}
+			retrieved, getErr := testDb.GetCampaign(ctx, campaigns[0].Id)
+			listed, listErr := testDb.ListCampaigns(ctx)
+
+			assert.NilError(t, getErr)
+			assert.NilError(t, listErr)
+			assert.DeepEqual(t, campaigns[0], retrieved)
+
+			listedIds := make(map[string]bool, len(listed))
+			for _, c := range listed {
+				listedIds[c.Id] = true
+			}
+			for _, c := range campaigns {
+				assert.Assert(t, listedIds[c.Id], "missing: %s", c.Id)
+			}
+		})
+
+		t.Run("GetFailsIfCampaignDoesNotExist", func(t *testing.T) {
+			c := newTestCampaign()
+
+			retrieved, err := testDb.GetCampaign(ctx, c.Id)
+
+			assert.Assert(t, is.Nil(retrieved))
+			assert.Assert(t, testutils.ErrorIs(err, ErrCampaignNotFound))
+		})
+	})
+
+	t.Run("ScheduledMessages", func(t *testing.T) {
+		now := time.Now().Truncate(time.Second)
+
+		t.Run("PutGetDueAndDeleteSucceed", func(t *testing.T) {
+			due := newTestScheduledMessage(now.Add(-time.Minute))
+			notYetDue := newTestScheduledMessage(now.Add(time.Hour))
+			defer testDb.DeleteScheduledMessage(ctx, notYetDue.Id)
+
+			assert.NilError(t, testDb.PutScheduledMessage(ctx, due))
+			assert.NilError(t, testDb.PutScheduledMessage(ctx, notYetDue))
+			dueMsgs, getErr := testDb.GetDueMessages(ctx, now)
+			deleteErr := testDb.DeleteScheduledMessage(ctx, due.Id)
+			dueAfterDelete, getAfterDeleteErr := testDb.GetDueMessages(
+				ctx, now,
+			)
+
+			assert.NilError(t, getErr)
+			assert.NilError(t, deleteErr)
+			assert.NilError(t, getAfterDeleteErr)
+			assert.DeepEqual(t, []*ScheduledMessage{due}, dueMsgs)
+			assert.Equal(t, 0, len(dueAfterDelete))
+		})
+
+		t.Run("SucceedsIfMessageExceedsDynamoDbItemLimit", func(t *testing.T) {
+			scheduled := newTestScheduledMessage(now.Add(-time.Minute))
+			msg := *scheduled.Message
+			msg.TextBody = strings.Repeat("0123456789\n", 100*1024)
+			scheduled.Message = &msg
+			defer testDb.DeleteScheduledMessage(ctx, scheduled.Id)
+
+			assert.NilError(t, testDb.PutScheduledMessage(ctx, scheduled))
+			dueMsgs, err := testDb.GetDueMessages(ctx, now)
+
+			assert.NilError(t, err)
+			assert.DeepEqual(t, []*ScheduledMessage{scheduled}, dueMsgs)
+		})
+
+		t.Run("ClaimSucceedsOnlyUntilClaimExpires", func(t *testing.T) {
+			scheduled := newTestScheduledMessage(now.Add(-time.Minute))
+			until := now.Add(time.Minute)
+			assert.NilError(t, testDb.PutScheduledMessage(ctx, scheduled))
+			defer testDb.DeleteScheduledMessage(ctx, scheduled.Id)
+
+			claimErr := testDb.ClaimScheduledMessage(
+				ctx, scheduled.Id, now, until,
+			)
+			reclaimErr := testDb.ClaimScheduledMessage(
+				ctx, scheduled.Id, now, until.Add(time.Minute),
+			)
+			dueMsgs, getErr := testDb.GetDueMessages(ctx, now)
+			expiredClaimErr := testDb.ClaimScheduledMessage(
+				ctx, scheduled.Id, until, until.Add(time.Minute),
+			)
+
+			assert.NilError(t, claimErr)
+			assert.Assert(
+				t, testutils.ErrorIs(reclaimErr, ErrScheduledMessageClaimed),
+			)
+			assert.NilError(t, getErr)
+			claimed := *scheduled
+			claimed.ClaimedUntil = until
+			assert.DeepEqual(t, []*ScheduledMessage{&claimed}, dueMsgs)
+			assert.NilError(t, expiredClaimErr)
+		})
+
+		t.Run("ReleaseOnlyReleasesTheCurrentClaim", func(t *testing.T) {
+			scheduled := newTestScheduledMessage(now.Add(-time.Minute))
+			until := now.Add(time.Minute)
+			assert.NilError(t, testDb.PutScheduledMessage(ctx, scheduled))
+			defer testDb.DeleteScheduledMessage(ctx, scheduled.Id)
+			assert.NilError(
+				t, testDb.ClaimScheduledMessage(ctx, scheduled.Id, now, until),
+			)
+
+			staleErr := testDb.ReleaseScheduledMessage(
+				ctx, scheduled.Id, until.Add(-time.Minute),
+			)
+			stillClaimedErr := testDb.ClaimScheduledMessage(
+				ctx, scheduled.Id, now, until,
+			)
+			releaseErr := testDb.ReleaseScheduledMessage(
+				ctx, scheduled.Id, until,
+			)
+			reclaimErr := testDb.ClaimScheduledMessage(
+				ctx, scheduled.Id, now, until,
+			)
+			missingErr := testDb.ReleaseScheduledMessage(
+				ctx, "nonexistent-id", until,
+			)
+
+			assert.NilError(t, staleErr)
+			assert.Assert(
+				t,
+				testutils.ErrorIs(stillClaimedErr, ErrScheduledMessageClaimed),
+			)
+			assert.NilError(t, releaseErr)
+			assert.NilError(t, reclaimErr)
+			assert.NilError(t, missingErr)
+		})
+
+		t.Run("ClaimFailsIfMessageDoesNotExist", func(t *testing.T) {
+			err := testDb.ClaimScheduledMessage(
+				ctx, "nonexistent-id", now, now.Add(time.Minute),
+			)
+
+			assert.Assert(
+				t, testutils.ErrorIs(err, ErrScheduledMessageClaimed),
+			)
+		})
+	})
+
+	t.Run("AuditEvents", func(t *testing.T) {
+		t.Run("PutAndGetSucceed", func(t *testing.T) {
+			now := time.Now().Truncate(time.Second)
+			email := testutils.RandomString(8) + "@example.com"
+			other := testutils.RandomString(8) + "@example.com"
+			events := []*AuditEvent{
+				newTestAuditEvent(email, AuditImport, now),
+				newTestAuditEvent(email, AuditRemove, now.Add(time.Second)),
+				newTestAuditEvent(other, AuditImport, now),
+			}
+			events[1].Reason = ops.RemoveReasonBounce
+
+			for _, e := range events {
+				assert.NilError(t, testDb.PutAuditEvent(ctx, e))
+			}
+			retrieved, err := testDb.GetAuditEvents(ctx, email)
+
+			assert.NilError(t, err)
+			sort.Slice(retrieved, func(i, j int) bool {
+				return retrieved[i].Timestamp.Before(retrieved[j].Timestamp)
+			})
+			assert.DeepEqual(t, events[:2], retrieved)
+		})
+
+		t.Run("GetReturnsEmptySliceIfNoEventsExist", func(t *testing.T) {
+			email := testutils.RandomString(8) + "@example.com"
+
+			retrieved, err := testDb.GetAuditEvents(ctx, email)
+
+			assert.NilError(t, err)
+			assert.Equal(t, 0, len(retrieved))
+		})
+
+		t.Run("DeleteRemovesOnlyEventsForEmail", func(t *testing.T) {
+			now := time.Now().Truncate(time.Second)
+			email := testutils.RandomString(8) + "@example.com"
+			other := testutils.RandomString(8) + "@example.com"
+			events := []*AuditEvent{
+				newTestAuditEvent(email, AuditImport, now),
+				newTestAuditEvent(email, AuditRemove, now.Add(time.Second)),
+				newTestAuditEvent(other, AuditImport, now),
+			}
+
+			for _, e := range events {
+				assert.NilError(t, testDb.PutAuditEvent(ctx, e))
+			}
+			deleteErr := testDb.DeleteAuditEvents(ctx, email)
+			retrieved, getErr := testDb.GetAuditEvents(ctx, email)
+			others, getOthersErr := testDb.GetAuditEvents(ctx, other)
+
+			assert.NilError(t, deleteErr)
+			assert.NilError(t, getErr)
+			assert.NilError(t, getOthersErr)
+			assert.Equal(t, 0, len(retrieved))
+			assert.DeepEqual(t, events[2:], others)
+		})
+	})
+
+	t.Run("Erasures", func(t *testing.T) {
+		t.Run("PutAndIsErasedSucceed", func(t *testing.T) {
+			hash := testutils.RandomString(16)
+
+			erasedBefore, beforeErr := testDb.IsErased(ctx, hash)
+			putErr := testDb.PutErasure(ctx, hash)
+			erasedAfter, afterErr := testDb.IsErased(ctx, hash)
+
+			assert.NilError(t, beforeErr)
+			assert.NilError(t, putErr)
+			assert.NilError(t, afterErr)
+			assert.Assert(t, !erasedBefore)
+			assert.Assert(t, erasedAfter)
+		})
+
+		t.Run("ListSucceeds", func(t *testing.T) {
+			hashes := []string{
+				testutils.RandomString(16), testutils.RandomString(16),
+			}
+			for _, hash := range hashes {
+				assert.NilError(t, testDb.PutErasure(ctx, hash))
+			}
+
+			listed, err := testDb.ListErasures(ctx)
+
+			assert.NilError(t, err)
+			for _, hash := range hashes {
+				assert.Assert(t, is.Contains(listed, hash))
+			}
+		})
+	})
+
+	t.Run("Tombstones", func(t *testing.T) {
+		t.Run("PutGetAndDeleteSucceed", func(t *testing.T) {
+			ts := newTestTombstone()
+
+			putErr := testDb.PutTombstone(ctx, ts)
+			retrieved, getErr := testDb.GetTombstone(ctx, ts.Email)
+			deleteErr := testDb.DeleteTombstone(ctx, ts.Email)
+			_, getAfterDeleteErr := testDb.GetTombstone(ctx, ts.Email)
+
+			assert.NilError(t, putErr)
+			assert.NilError(t, getErr)
+			assert.NilError(t, deleteErr)
+			assert.DeepEqual(t, ts, retrieved)
+			assert.Assert(
+				t, testutils.ErrorIs(getAfterDeleteErr, ErrTombstoneNotFound),
+			)
+		})
+
+		t.Run("PutReplacesExistingTombstone", func(t *testing.T) {
+			ts := newTestTombstone()
+			defer testDb.DeleteTombstone(ctx, ts.Email)
+			assert.NilError(t, testDb.PutTombstone(ctx, ts))
+			ts.Reason = TombstoneComplaint
+
+			putErr := testDb.PutTombstone(ctx, ts)
+			retrieved, getErr := testDb.GetTombstone(ctx, ts.Email)
+
+			assert.NilError(t, putErr)
+			assert.NilError(t, getErr)
+			assert.DeepEqual(t, ts, retrieved)
+		})
+
+		t.Run("ListSucceeds", func(t *testing.T) {
+			tombstones := []*Tombstone{newTestTombstone(), newTestTombstone()}
+			defer func() {
+				for _, ts := range tombstones {
+					testDb.DeleteTombstone(ctx, ts.Email)
+				}
+			}()
+			for _, ts := range tombstones {
+				assert.NilError(t, testDb.PutTombstone(ctx, ts))
+			}
+
+			listed, err := testDb.ListTombstones(ctx)
+
+			assert.NilError(t, err)
+			listedByEmail := make(map[string]*Tombstone, len(listed))
+			for _, ts := range listed {
+				listedByEmail[ts.Email] = ts
+			}
+			for _, ts := range tombstones {
+				assert.DeepEqual(t, ts, listedByEmail[ts.Email])
+			}
+		})
+	})
+
+	t.Run("WelcomeMessage", func(t *testing.T) {
+		t.Run("PutGetAndDeleteSucceed", func(t *testing.T) {
+			msg := *email.ExampleMessage
+			msg.Subject = "Welcome!"
+			defer testDb.DeleteWelcomeMessage(ctx)
+
+			putErr := testDb.PutWelcomeMessage(ctx, email.ExampleMessage)
+			replaceErr := testDb.PutWelcomeMessage(ctx, &msg)
+			retrieved, getErr := testDb.GetWelcomeMessage(ctx)
+			deleteErr := testDb.DeleteWelcomeMessage(ctx)
+			_, getAfterDeleteErr := testDb.GetWelcomeMessage(ctx)
+
+			assert.NilError(t, putErr)
+			assert.NilError(t, replaceErr)
+			assert.NilError(t, getErr)
+			assert.NilError(t, deleteErr)
+			assert.DeepEqual(t, &msg, retrieved)
+			assert.Assert(
+				t,
+				testutils.ErrorIs(getAfterDeleteErr, ErrWelcomeMessageNotFound),
+			)
+		})
+
+		t.Run("SucceedsIfMessageExceedsDynamoDbItemLimit", func(t *testing.T) {
+			msg := *email.ExampleMessage
+			msg.TextBody = strings.Repeat("0123456789\n", 100*1024)
+			defer testDb.DeleteWelcomeMessage(ctx)
+
+			putErr := testDb.PutWelcomeMessage(ctx, &msg)
+			retrieved, getErr := testDb.GetWelcomeMessage(ctx)
+
+			assert.NilError(t, putErr)
+			assert.NilError(t, getErr)
+			assert.DeepEqual(t, &msg, retrieved)
+		})
+
+		t.Run("DeleteSucceedsIfMessageDoesNotExist", func(t *testing.T) {
+			assert.NilError(t, testDb.DeleteWelcomeMessage(ctx))
+		})
+	})
+
+	t.Run("WithTestSubscribers", func(t *testing.T) {
+		emails := make([]string, 0, len(TestSubscribers))
+
+		for _, sub := range TestSubscribers {
+			if err := testDb.Put(ctx, sub); err != nil {
+				t.Fatalf("failed to put subscriber: %s", sub)
+			}
+			emails = append(emails, sub.Email)
+		}
+		time.Sleep(scanDelay)
+
+		defer func() {
+			for _, email := range emails {
+				if err := testDb.Delete(ctx, email); err != nil {
+					t.Fatalf("failed to delete subscriber: %s", email)
+				}
+			}
+		}()
+
+		t.Run("ProcessSubscribersInStateSucceeds", func(t *testing.T) {
+			subs := &[]*Subscriber{}
+			f := SubscriberFunc(func(s *Subscriber) bool {
+				*subs = append(*subs, s)
+				return true
+			})
+
+			err := testDb.ProcessSubscribers(ctx, SubscriberVerified, f)
+
+			assert.NilError(t, err)
+			assert.DeepEqual(t, sorted(TestVerifiedSubscribers), sorted(*subs))
+		})
+
+		t.Run("ProcessSubscribersFromStartKeySucceeds", func(t *testing.T) {
+			subs := []*Subscriber{}
+			f := SubscriberFunc(func(s *Subscriber) bool {
+				subs = append(subs, s)
+				return true
+			})
+			err := testDb.ProcessSubscribers(ctx, SubscriberVerified, f)
+			assert.NilError(t, err)
+			allSubs := subs
+			subs = []*Subscriber{}
+
+			err = testDb.ProcessSubscribersFrom(
+				ctx, SubscriberVerified, allSubs[0].ScanKey(), f,
+			)
+
+			assert.NilError(t, err)
+			assert.DeepEqual(t, allSubs[1:], subs)
+		})
+
+		t.Run("ProcessTaggedSubscribersSucceeds", func(t *testing.T) {
+			tagged := *TestVerifiedSubscribers[0]
+			tagged.Tags = []string{"essays", "releases"}
+			assert.NilError(t, testDb.Put(ctx, &tagged))
+			defer func() {
+				assert.NilError(t, testDb.Put(ctx, TestVerifiedSubscribers[0]))
+			}()
+			filter, err := ParseTagFilter("releases and not drafts")
+			assert.NilError(t, err)
+			subs := []*Subscriber{}
+			f := SubscriberFunc(func(s *Subscriber) bool {
+				subs = append(subs, s)
+				return true
+			})
+
+			err = testDb.ProcessTaggedSubscribersFrom(
+				ctx, SubscriberVerified, filter, nil, f,
+			)
+
+			assert.NilError(t, err)
+			assert.DeepEqual(t, []*Subscriber{&tagged}, subs)
+		})
+
+		t.Run("ProcessTaggedSubscribersAfterTagsChange", func(t *testing.T) {
+			tagged := *TestVerifiedSubscribers[0]
+			tagged.Tags = []string{"essays", "releases"}
+			assert.NilError(t, testDb.Put(ctx, &tagged))
+			defer func() {
+				assert.NilError(t, testDb.Put(ctx, TestVerifiedSubscribers[0]))
+			}()
+			tagged.Tags = []string{"essays"}
+			assert.NilError(t, testDb.Put(ctx, &tagged))
+			process := func(expr string) []*Subscriber {
+				t.Helper()
+				filter, err := ParseTagFilter(expr)
+				assert.NilError(t, err)
+				subs := []*Subscriber{}
+				f := SubscriberFunc(func(s *Subscriber) bool {
+					subs = append(subs, s)
+					return true
+				})
+
+				err = testDb.ProcessTaggedSubscribersFrom(
+					ctx, SubscriberVerified, filter, nil, f,
+				)
+				assert.NilError(t, err)
+				return subs
+			}
+
+			assert.DeepEqual(t, []*Subscriber{}, process("releases"))
+			assert.DeepEqual(
+				t, []*Subscriber{&tagged}, process("essays or releases"),
+			)
+
+			assert.NilError(t, testDb.Delete(ctx, tagged.Email))
+			assert.DeepEqual(t, []*Subscriber{}, process("essays"))
+		})
+	})
+}
+
+func newTestCheckpoint() *SendCheckpoint {
+	return &SendCheckpoint{
+		CampaignId:  testutils.RandomString(10),
+		MessageHash: "message-hash",
+		LastKey:     newTestSubscriber().ScanKey(),
+		NumSent:     27,
+		Timestamp:   time.Now().Truncate(time.Second),
+	}
+}
+
+func newTestCampaign() *Campaign {
+	now := time.Now().Truncate(time.Second)
+	return &Campaign{
+		Id:          testutils.RandomString(10),
+		Subject:     "Hello, World!",
+		MessageHash: "message-hash",
+		StartTime:   now,
+		FinishTime:  now.Add(time.Minute),
+		NumSent:     27,
+		NumFailed:   1,
+		Status:      CampaignComplete,
+	}
+}
+
+func newTestScheduledMessage(sendAt time.Time) *ScheduledMessage {
+	return &ScheduledMessage{
+		Id:      testutils.RandomString(10),
+		SendAt:  sendAt,
+		Message: email.ExampleMessage,
+	}
+}
+
+func newTestAuditEvent(
+	email string, op AuditOperation, ts time.Time,
+) *AuditEvent {
+	return &AuditEvent{
+		Email:     email,
+		Operation: op,
+		Source:    AuditSourceCli,
+		RequestId: testutils.RandomString(10),
+		Timestamp: ts,
+	}
+}
+
+func newTestTombstone() *Tombstone {
+	return &Tombstone{
+		Email:     testutils.RandomString(8) + "@example.com",
+		Reason:    TombstoneUnsubscribe,
+		Timestamp: time.Now().Truncate(time.Second),
+	}
+}
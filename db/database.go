@@ -2,6 +2,7 @@ package db
 
 import (
 	"context"
+	"slices"
 	"strings"
 	"time"
 
@@ -9,6 +10,24 @@ import (
 	"github.com/mbland/elistman/types"
 )
 
+// Database stores subscribers and their delivery receipts.
+//
+// A delivery receipt records that a campaign, or a message sent under an
+// idempotency key, was delivered to an address. ProdAgent checks receipts to
+// avoid sending duplicate messages. Each receipt is a separate record, so
+// there's no limit to how many campaigns a subscriber may receive.
+//
+// MarkReceived records a receipt even if the subscriber no longer exists.
+// Delete leaves receipts in place, so that a subscriber who unsubscribes and
+// subscribes again won't receive the same campaign twice. Only DeleteReceipts
+// removes them.
+//
+// RecordVerifySent updates the VerifySentCount, VerifySentAt, Timestamp, and
+// Tags of a pending subscriber, but only if its stored VerifySentCount is still
+// prevCount. Checking and updating happen atomically, so of several concurrent
+// callers that read the same VerifySentCount, only one will succeed. The others
+// will receive ErrVerifySentChanged, as will a caller whose pending subscriber
+// no longer exists.
 type Database interface {
 	Get(ctx context.Context, email string) (*Subscriber, error)
 	Put(ctx context.Context, subscriber *Subscriber) error
@@ -16,6 +35,27 @@ type Database interface {
 	ProcessSubscribers(
 		context.Context, SubscriberStatus, SubscriberProcessor,
 	) error
+	ProcessSubscribersFrom(
+		context.Context, SubscriberStatus, *ScanKey, SubscriberProcessor,
+	) error
+	ProcessTaggedSubscribersFrom(
+		context.Context,
+		SubscriberStatus,
+		*TagFilter,
+		*ScanKey,
+		SubscriberProcessor,
+	) error
+	MarkReceived(ctx context.Context, email, campaignId string) error
+	HasReceived(
+		ctx context.Context, email, campaignId string,
+	) (bool, error)
+	DeleteReceipts(ctx context.Context, email string) error
+	RotateUid(
+		ctx context.Context, email string, uid uuid.UUID, expires time.Time,
+	) error
+	RecordVerifySent(
+		ctx context.Context, subscriber *Subscriber, prevCount int,
+	) error
 }
 
 // ErrSubscriberNotFound indicates that an email address isn't subscribed.
@@ -24,6 +64,15 @@ type Database interface {
 // succeeded, but there was no such Subscriber.
 const ErrSubscriberNotFound = types.SentinelError("is not a subscriber")
 
+// ErrVerifySentChanged indicates that Database.RecordVerifySent didn't update a
+// pending Subscriber.
+//
+// Either another request recorded a verification email first, or the pending
+// Subscriber no longer exists.
+const ErrVerifySentChanged = types.SentinelError(
+	"verification email already recorded or subscriber no longer pending",
+)
+
 // A SubscriberProcessor performs an operation on a Subscriber.
 //
 // Process should return true if processing should continue with the next
@@ -43,10 +92,70 @@ func (f SubscriberFunc) Process(sub *Subscriber) bool {
 	return f(sub)
 }
 
+// Subscriber contains information about a pending or verified subscriber.
+//
+// FirstName and Attributes supply the values of the personalization variables
+// in messages sent to the Subscriber. Both are optional.
+//
+// Tags contains the topics the Subscriber chose when subscribing, sorted and
+// without duplicates. Sends may target only the Subscribers whose Tags match a
+// TagFilter.
+//
+// List is the name of the list to which the Subscriber belongs, or empty for
+// the default list.
+//
+// Signup describes the request that created the Subscriber. It's nil for
+// Subscribers who didn't subscribe via the API, such as imported Subscribers.
+//
+// VerifySentCount and VerifySentAt record how many verification emails a
+// pending Subscriber has received, and when the most recent was sent. ProdAgent
+// uses them to limit how often it resends the verification email, and clears
+// them once the Subscriber is verified.
+//
+// PreviousUid is the Uid the Subscriber had before the most recent RotateUid,
+// or uuid.Nil if it never had another. It remains valid until
+// PreviousUidExpires, so that links in messages sent before the rotation still
+// work for a while. See MatchesUid.
 type Subscriber struct {
+	Email              string
+	Uid                uuid.UUID
+	List               string
+	Status             SubscriberStatus
+	Timestamp          time.Time
+	FirstName          string
+	Attributes         map[string]string
+	Tags               []string
+	Signup             *SignupMetadata
+	VerifySentCount    int
+	VerifySentAt       time.Time
+	PreviousUid        uuid.UUID
+	PreviousUidExpires time.Time
+}
+
+// SignupMetadata describes where a subscription request came from.
+//
+// Source and the Utm* fields come from the subscription form, identifying the
+// form or campaign that produced the request. Referrer, SourceIp, and
+// UserAgent come from the HTTP request itself, and help with investigating
+// abusive requests. All of the fields are optional.
+type SignupMetadata struct {
+	Source      string
+	UtmSource   string
+	UtmMedium   string
+	UtmCampaign string
+	UtmTerm     string
+	UtmContent  string
+	Referrer    string
+	SourceIp    string
+	UserAgent   string
+}
+
+// ScanKey identifies a Subscriber's position within ProcessSubscribers.
+//
+// Passing a ScanKey to ProcessSubscribersFrom will resume processing just
+// after the Subscriber from which it came.
+type ScanKey struct {
 	Email     string
-	Uid       uuid.UUID
-	Status    SubscriberStatus
 	Timestamp time.Time
 }
 
@@ -68,6 +177,24 @@ func NewSubscriber(email string) *Subscriber {
 	}
 }
 
+// ScanKey returns the ScanKey for resuming processing after sub.
+func (sub *Subscriber) ScanKey() *ScanKey {
+	return &ScanKey{Email: sub.Email, Timestamp: sub.Timestamp}
+}
+
+// MatchesUid returns true if uid is sub.Uid, or if it's sub.PreviousUid and
+// now is before sub.PreviousUidExpires.
+func (sub *Subscriber) MatchesUid(uid uuid.UUID, now time.Time) bool {
+	return uid == sub.Uid ||
+		(sub.PreviousUid != uuid.Nil && uid == sub.PreviousUid &&
+			now.Before(sub.PreviousUidExpires))
+}
+
+// HasTag returns true if sub has the tag.
+func (sub *Subscriber) HasTag(tag string) bool {
+	return slices.Contains(sub.Tags, tag)
+}
+
 func (sub *Subscriber) String() string {
 	sb := strings.Builder{}
 	sb.WriteString("Email: ")
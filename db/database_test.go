@@ -5,7 +5,9 @@ package db
 import (
 	"fmt"
 	"testing"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/mbland/elistman/testdata"
 	"gotest.tools/assert"
 )
@@ -27,4 +29,19 @@ func TestSubscriber(t *testing.T) {
 		)
 		assert.Equal(t, expected, sub.String())
 	})
+	t.Run("MatchesUid", func(t *testing.T) {
+		previousUid := uuid.MustParse("11111111-1111-1111-1111-111111111111")
+		expires := testdata.TestTimestamp.Add(time.Hour)
+		sub := &Subscriber{Uid: testdata.TestUid}
+
+		assert.Assert(t, sub.MatchesUid(testdata.TestUid, expires))
+		assert.Assert(t, !sub.MatchesUid(previousUid, testdata.TestTimestamp))
+		assert.Assert(t, !sub.MatchesUid(uuid.Nil, testdata.TestTimestamp))
+
+		sub.PreviousUid = previousUid
+		sub.PreviousUidExpires = expires
+		assert.Assert(t, sub.MatchesUid(testdata.TestUid, expires))
+		assert.Assert(t, sub.MatchesUid(previousUid, testdata.TestTimestamp))
+		assert.Assert(t, !sub.MatchesUid(previousUid, expires))
+	})
 }
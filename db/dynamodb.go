@@ -2,18 +2,28 @@ package db
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	dbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/google/uuid"
+	"github.com/mbland/elistman/email"
 	"github.com/mbland/elistman/ops"
+	"github.com/mbland/elistman/types"
 )
 
+// DynamoDbClient comprises the DynamoDB API calls that DynamoDb makes.
+//
+// The DynamoDbPolicy in template.yml must grant the Lambda function every one
+// of these calls, except for the table administration calls that only the
+// command line interface makes. A small test fails if they fall out of step.
 type DynamoDbClient interface {
 	CreateTable(
 		context.Context, *dynamodb.CreateTableInput, ...func(*dynamodb.Options),
@@ -31,6 +41,10 @@ type DynamoDbClient interface {
 		...func(*dynamodb.Options),
 	) (*dynamodb.UpdateTimeToLiveOutput, error)
 
+	UpdateTable(
+		context.Context, *dynamodb.UpdateTableInput, ...func(*dynamodb.Options),
+	) (*dynamodb.UpdateTableOutput, error)
+
 	DeleteTable(
 		context.Context, *dynamodb.DeleteTableInput, ...func(*dynamodb.Options),
 	) (*dynamodb.DeleteTableOutput, error)
@@ -47,19 +61,35 @@ type DynamoDbClient interface {
 		context.Context, *dynamodb.DeleteItemInput, ...func(*dynamodb.Options),
 	) (*dynamodb.DeleteItemOutput, error)
 
+	UpdateItem(
+		context.Context, *dynamodb.UpdateItemInput, ...func(*dynamodb.Options),
+	) (*dynamodb.UpdateItemOutput, error)
+
 	Scan(
 		context.Context, *dynamodb.ScanInput, ...func(*dynamodb.Options),
 	) (*dynamodb.ScanOutput, error)
+
+	Query(
+		context.Context, *dynamodb.QueryInput, ...func(*dynamodb.Options),
+	) (*dynamodb.QueryOutput, error)
 }
 
+// DynamoDb stores the records for one list in a DynamoDB table.
+//
+// If List is empty, DynamoDb stores records for the default list. Otherwise,
+// the primary key of every record begins with the prefix "list#LIST#", so
+// records for multiple lists can share the same table without colliding. See
+// ForList.
+//
 // https://docs.aws.amazon.com/amazondynamodb/latest/developerguide/WorkingWithItems.html
 type DynamoDb struct {
 	Client    DynamoDbClient
 	TableName string
+	List      string
 }
 
 func NewDynamoDb(cfg aws.Config, tableName string) *DynamoDb {
-	return &DynamoDb{dynamodb.NewFromConfig(cfg), tableName}
+	return &DynamoDb{Client: dynamodb.NewFromConfig(cfg), TableName: tableName}
 }
 
 func NewDynamoDbWithCustomEndpoint(
@@ -68,7 +98,60 @@ func NewDynamoDbWithCustomEndpoint(
 	db := dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
 		o.BaseEndpoint = aws.String(endpoint)
 	})
-	return &DynamoDb{db, tableName}
+	return &DynamoDb{Client: db, TableName: tableName}
+}
+
+// ForList returns a DynamoDb for the named list using the same client and
+// table.
+func (db *DynamoDb) ForList(list string) *DynamoDb {
+	return &DynamoDb{Client: db.Client, TableName: db.TableName, List: list}
+}
+
+// listKeyPrefix begins the primary key of every record for a named list.
+//
+// Subscriber keys for the default list are bare email addresses. Tag, receipt,
+// checkpoint, campaign, scheduled message, audit event, tombstone, erasure, and
+// welcome message keys for the default list begin with their own prefixes.
+// None of them begin with listKeyPrefix.
+const listKeyPrefix = "list#"
+
+// ErrAddressContainsKeySeparator indicates that DynamoDb can't store a record
+// for an address containing "#".
+//
+// "#" separates the parts of every primary key, so such an address could
+// produce the key of another list's record. For example, the default list's
+// "list#news#bob@example.com" would collide with "news" list's
+// "bob@example.com".
+const ErrAddressContainsKeySeparator = types.SentinelError(
+	`address contains "#"`,
+)
+
+// checkAddress returns ErrAddressContainsKeySeparator if email contains "#".
+func checkAddress(email string) (err error) {
+	if strings.Contains(email, "#") {
+		err = fmt.Errorf("%w: %s", ErrAddressContainsKeySeparator, email)
+	}
+	return
+}
+
+func listKey(list string) string {
+	if list == "" {
+		return ""
+	}
+	return listKeyPrefix + list + "#"
+}
+
+// keyPrefix returns the primary key value for a record with the given key
+// value. It's also used to build prefixes for begins_with scan filters.
+func (db *DynamoDb) keyPrefix(value string) string {
+	return listKey(db.List) + value
+}
+
+// key returns the primary key for a record with the given key value.
+func (db *DynamoDb) key(value string) dbAttributes {
+	return dbAttributes{
+		DynamoDbPrimaryKey: &dbString{Value: db.keyPrefix(value)},
+	}
 }
 
 const DynamoDbPrimaryKey = "email"
@@ -81,6 +164,23 @@ const DynamoDbPendingIndexPartitionKey = string(SubscriberPending)
 const DynamoDbVerifiedIndexName string = string(SubscriberVerified)
 const DynamoDbVerifiedIndexPartitionKey string = string(SubscriberVerified)
 
+// Sparse Global Secondary Index for scheduled message records, which contain a
+// "scheduledList" attribute. Each list's scheduled messages share the same
+// partition, sorted by their "sendAt" times, so GetDueMessages can query for
+// the due messages without scanning the table.
+const DynamoDbScheduledIndexName = "scheduled"
+const DynamoDbScheduledIndexPartitionKey = "scheduledList"
+const DynamoDbScheduledIndexSortKey = "sendAt"
+
+// Sparse Global Secondary Index for tag records, which contain a "tagIndex"
+// attribute. Each tag's records for a list share the same partition, sorted by
+// their "tagEmail" addresses, so ProcessTaggedSubscribersFrom can query for the
+// subscribers with a tag without scanning every verified subscriber. The index
+// only needs the keys, since the subscriber records contain everything else.
+const DynamoDbTagIndexName = "tags"
+const DynamoDbTagIndexPartitionKey = "tagIndex"
+const DynamoDbTagIndexSortKey = "tagEmail"
+
 var DynamoDbIndexProjection *dbtypes.Projection = &dbtypes.Projection{
 	ProjectionType: dbtypes.ProjectionTypeAll,
 }
@@ -99,6 +199,22 @@ var DynamoDbCreateTableInput = &dynamodb.CreateTableInput{
 			AttributeName: aws.String(DynamoDbVerifiedIndexPartitionKey),
 			AttributeType: dbtypes.ScalarAttributeTypeN,
 		},
+		{
+			AttributeName: aws.String(DynamoDbScheduledIndexPartitionKey),
+			AttributeType: dbtypes.ScalarAttributeTypeS,
+		},
+		{
+			AttributeName: aws.String(DynamoDbScheduledIndexSortKey),
+			AttributeType: dbtypes.ScalarAttributeTypeN,
+		},
+		{
+			AttributeName: aws.String(DynamoDbTagIndexPartitionKey),
+			AttributeType: dbtypes.ScalarAttributeTypeS,
+		},
+		{
+			AttributeName: aws.String(DynamoDbTagIndexSortKey),
+			AttributeType: dbtypes.ScalarAttributeTypeS,
+		},
 	},
 	KeySchema: []dbtypes.KeySchemaElement{
 		{
@@ -130,6 +246,38 @@ var DynamoDbCreateTableInput = &dynamodb.CreateTableInput{
 			},
 			Projection: DynamoDbIndexProjection,
 		},
+		{
+			IndexName: aws.String(DynamoDbScheduledIndexName),
+			KeySchema: []dbtypes.KeySchemaElement{
+				{
+					AttributeName: aws.String(
+						DynamoDbScheduledIndexPartitionKey,
+					),
+					KeyType: dbtypes.KeyTypeHash,
+				},
+				{
+					AttributeName: aws.String(DynamoDbScheduledIndexSortKey),
+					KeyType:       dbtypes.KeyTypeRange,
+				},
+			},
+			Projection: DynamoDbIndexProjection,
+		},
+		{
+			IndexName: aws.String(DynamoDbTagIndexName),
+			KeySchema: []dbtypes.KeySchemaElement{
+				{
+					AttributeName: aws.String(DynamoDbTagIndexPartitionKey),
+					KeyType:       dbtypes.KeyTypeHash,
+				},
+				{
+					AttributeName: aws.String(DynamoDbTagIndexSortKey),
+					KeyType:       dbtypes.KeyTypeRange,
+				},
+			},
+			Projection: &dbtypes.Projection{
+				ProjectionType: dbtypes.ProjectionTypeKeysOnly,
+			},
+		},
 	},
 }
 
@@ -194,6 +342,127 @@ func (db *DynamoDb) CreateSubscribersTable(
 	return
 }
 
+// indexPollInterval defines how often MigrateSubscribersTable checks whether a
+// new Global Secondary Index has finished backfilling.
+const indexPollInterval = 5 * time.Second
+
+// MigrateSubscribersTable adds any Global Secondary Indexes from
+// DynamoDbCreateTableInput that the table doesn't have yet, such as the
+// scheduled and tags indexes for tables created before they existed. It returns
+// the names of the indexes it created.
+//
+// DynamoDB only creates one index per UpdateTable call, so
+// MigrateSubscribersTable waits up to maxWaitDuration for each index to finish
+// backfilling before creating the next. If it times out, running it again
+// waits for the index in progress, then creates any remaining indexes.
+func (db *DynamoDb) MigrateSubscribersTable(
+	ctx context.Context, maxWaitDuration time.Duration,
+) (created []string, err error) {
+	wrapErr := func(err error) error {
+		const errFmt = "failed to migrate subscribers table \"%s\": %w"
+		return fmt.Errorf(errFmt, db.TableName, err)
+	}
+	created = []string{}
+
+	for _, gsi := range DynamoDbCreateTableInput.GlobalSecondaryIndexes {
+		name := aws.ToString(gsi.IndexName)
+		var status dbtypes.IndexStatus
+
+		if status, err = db.indexStatus(ctx, name); err != nil {
+			return created, wrapErr(err)
+		} else if status == dbtypes.IndexStatusActive {
+			continue
+		} else if status == "" {
+			if err = db.createIndex(ctx, gsi); err != nil {
+				return created, wrapErr(err)
+			}
+			created = append(created, name)
+		}
+		if err = db.waitForIndex(ctx, name, maxWaitDuration); err != nil {
+			return created, wrapErr(err)
+		}
+	}
+	return
+}
+
+// indexStatus returns the empty string if the index doesn't exist.
+func (db *DynamoDb) indexStatus(
+	ctx context.Context, name string,
+) (status dbtypes.IndexStatus, err error) {
+	input := &dynamodb.DescribeTableInput{TableName: aws.String(db.TableName)}
+	var output *dynamodb.DescribeTableOutput
+
+	if output, err = db.Client.DescribeTable(ctx, input); err != nil {
+		err = ops.AwsError("failed to describe table", err)
+		return
+	}
+	for _, gsi := range output.Table.GlobalSecondaryIndexes {
+		if aws.ToString(gsi.IndexName) == name {
+			status = gsi.IndexStatus
+			break
+		}
+	}
+	return
+}
+
+func (db *DynamoDb) createIndex(
+	ctx context.Context, gsi dbtypes.GlobalSecondaryIndex,
+) (err error) {
+	attrDefs := make([]dbtypes.AttributeDefinition, 0, len(gsi.KeySchema))
+
+	for _, key := range gsi.KeySchema {
+		name := aws.ToString(key.AttributeName)
+		for _, def := range DynamoDbCreateTableInput.AttributeDefinitions {
+			if aws.ToString(def.AttributeName) == name {
+				attrDefs = append(attrDefs, def)
+			}
+		}
+	}
+	input := &dynamodb.UpdateTableInput{
+		TableName:            aws.String(db.TableName),
+		AttributeDefinitions: attrDefs,
+		GlobalSecondaryIndexUpdates: []dbtypes.GlobalSecondaryIndexUpdate{
+			{
+				Create: &dbtypes.CreateGlobalSecondaryIndexAction{
+					IndexName:  gsi.IndexName,
+					KeySchema:  gsi.KeySchema,
+					Projection: gsi.Projection,
+				},
+			},
+		},
+	}
+
+	if _, err = db.Client.UpdateTable(ctx, input); err != nil {
+		const errFmt = "failed to create index \"%s\""
+		err = ops.AwsError(fmt.Sprintf(errFmt, *gsi.IndexName), err)
+	}
+	return
+}
+
+func (db *DynamoDb) waitForIndex(
+	ctx context.Context, name string, maxWait time.Duration,
+) (err error) {
+	timeout := time.After(maxWait)
+	var status dbtypes.IndexStatus
+
+	for {
+		if status, err = db.indexStatus(ctx, name); err != nil {
+			return
+		} else if status == dbtypes.IndexStatusActive {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout:
+			const errFmt = "index \"%s\" still %s after %s"
+			return fmt.Errorf(errFmt, name, status, maxWait)
+		case <-time.After(indexPollInterval):
+		}
+	}
+}
+
 func (db *DynamoDb) DeleteTable(ctx context.Context) (err error) {
 	input := &dynamodb.DeleteTableInput{TableName: aws.String(db.TableName)}
 	if _, err = db.Client.DeleteTable(ctx, input); err != nil {
@@ -205,11 +474,15 @@ func (db *DynamoDb) DeleteTable(ctx context.Context) (err error) {
 type (
 	dbString     = dbtypes.AttributeValueMemberS
 	dbNumber     = dbtypes.AttributeValueMemberN
+	dbBool       = dbtypes.AttributeValueMemberBOOL
+	dbStringSet  = dbtypes.AttributeValueMemberSS
+	dbBinary     = dbtypes.AttributeValueMemberB
+	dbMap        = dbtypes.AttributeValueMemberM
 	dbAttributes = map[string]dbtypes.AttributeValue
 )
 
-func subscriberKey(email string) dbAttributes {
-	return dbAttributes{"email": &dbString{Value: email}}
+func (db *DynamoDb) subscriberKey(email string) dbAttributes {
+	return db.key(email)
 }
 
 type dbParser struct {
@@ -224,12 +497,63 @@ func parseSubscriber(attrs dbAttributes) (subscriber *Subscriber, err error) {
 		errs = append(errs, e)
 	}
 
+	if _, ok := attrs["list"]; !ok {
+		// Only subscribers to named lists have this attribute.
+	} else if s.List, err = p.GetString("list"); err != nil {
+		addErr(err)
+	}
 	if s.Email, err = p.GetString("email"); err != nil {
 		addErr(err)
+	} else {
+		s.Email = strings.TrimPrefix(s.Email, listKey(s.List))
 	}
 	if s.Uid, err = p.GetUid("uid"); err != nil {
 		addErr(err)
 	}
+	if _, ok := attrs["firstName"]; !ok {
+		// Subscribers aren't required to provide a first name.
+	} else if s.FirstName, err = p.GetString("firstName"); err != nil {
+		addErr(err)
+	}
+	if _, ok := attrs["attributes"]; !ok {
+		// Only subscribers with custom attributes have this attribute.
+	} else if s.Attributes, err = p.GetStringMap("attributes"); err != nil {
+		addErr(err)
+	}
+	if _, ok := attrs["tags"]; !ok {
+		// Only subscribers who chose topics have this attribute.
+	} else if s.Tags, err = p.GetStringSet("tags"); err != nil {
+		addErr(err)
+	} else {
+		slices.Sort(s.Tags)
+	}
+	if _, ok := attrs["signup"]; !ok {
+		// Only subscribers who subscribed via the API have this attribute.
+	} else if s.Signup, err = p.GetSignupMetadata("signup"); err != nil {
+		addErr(err)
+	}
+	if _, ok := attrs["verifySentCount"]; !ok {
+		// Only pending subscribers have this attribute; verifying clears it.
+	} else if s.VerifySentCount, err = p.GetInt("verifySentCount"); err != nil {
+		addErr(err)
+	}
+	if _, ok := attrs["verifySentAt"]; !ok {
+		// Only pending subscribers have this attribute; verifying clears it.
+	} else if s.VerifySentAt, err = p.GetTime("verifySentAt"); err != nil {
+		addErr(err)
+	}
+	if _, ok := attrs["previousUid"]; !ok {
+		// Only subscribers whose UIDs were rotated have this attribute.
+	} else if s.PreviousUid, err = p.GetUid("previousUid"); err != nil {
+		addErr(err)
+	}
+	if _, ok := attrs["previousUidExpires"]; !ok {
+		// Only subscribers whose UIDs were rotated have this attribute.
+	} else if s.PreviousUidExpires, err = p.GetTime(
+		"previousUidExpires",
+	); err != nil {
+		addErr(err)
+	}
 
 	_, pending := attrs[string(SubscriberPending)]
 	_, verified := attrs[string(SubscriberVerified)]
@@ -263,6 +587,89 @@ func (p *dbParser) GetString(name string) (value string, err error) {
 	})
 }
 
+func (p *dbParser) GetStringSet(name string) (value []string, err error) {
+	return getAttribute(
+		name, p.attrs, func(attr *dbStringSet) ([]string, error) {
+			return attr.Value, nil
+		},
+	)
+}
+
+func (p *dbParser) GetStringMap(
+	name string,
+) (value map[string]string, err error) {
+	return getAttribute(
+		name, p.attrs, func(attr *dbMap) (map[string]string, error) {
+			m := make(map[string]string, len(attr.Value))
+
+			for k, v := range attr.Value {
+				if s, ok := v.(*dbString); !ok {
+					const errFmt = "'%s' is of type %T, not string"
+					return nil, fmt.Errorf(errFmt, k, v)
+				} else {
+					m[k] = s.Value
+				}
+			}
+			return m, nil
+		},
+	)
+}
+
+// signupFields maps the attributes within a subscriber record's "signup" map
+// to the corresponding fields of m.
+func signupFields(m *SignupMetadata) map[string]*string {
+	return map[string]*string{
+		"source":      &m.Source,
+		"utmSource":   &m.UtmSource,
+		"utmMedium":   &m.UtmMedium,
+		"utmCampaign": &m.UtmCampaign,
+		"utmTerm":     &m.UtmTerm,
+		"utmContent":  &m.UtmContent,
+		"referrer":    &m.Referrer,
+		"sourceIp":    &m.SourceIp,
+		"userAgent":   &m.UserAgent,
+	}
+}
+
+func (p *dbParser) GetSignupMetadata(
+	name string,
+) (value *SignupMetadata, err error) {
+	var values map[string]string
+
+	if values, err = p.GetStringMap(name); err != nil {
+		return
+	}
+
+	value = &SignupMetadata{}
+	fields := signupFields(value)
+
+	for k, v := range values {
+		// Ignore unknown attributes, which may come from a newer version.
+		if field, ok := fields[k]; ok {
+			*field = v
+		}
+	}
+	return
+}
+
+func (p *dbParser) GetBytes(name string) (value []byte, err error) {
+	return getAttribute(name, p.attrs, func(attr *dbBinary) ([]byte, error) {
+		return attr.Value, nil
+	})
+}
+
+func (p *dbParser) GetInt(name string) (value int, err error) {
+	return getAttribute(name, p.attrs, func(attr *dbNumber) (int, error) {
+		return strconv.Atoi(attr.Value)
+	})
+}
+
+func (p *dbParser) GetBool(name string) (value bool, err error) {
+	return getAttribute(name, p.attrs, func(attr *dbBool) (bool, error) {
+		return attr.Value, nil
+	})
+}
+
 func (p *dbParser) GetUid(name string) (value uuid.UUID, err error) {
 	return getAttribute(name, p.attrs, func(attr *dbString) (uuid.UUID, error) {
 		return uuid.Parse(attr.Value)
@@ -304,11 +711,13 @@ func (db *DynamoDb) Get(
 	ctx context.Context, email string,
 ) (subscriber *Subscriber, err error) {
 	input := &dynamodb.GetItemInput{
-		Key: subscriberKey(email), TableName: aws.String(db.TableName),
+		Key: db.subscriberKey(email), TableName: aws.String(db.TableName),
 	}
 	var output *dynamodb.GetItemOutput
 
-	if output, err = db.Client.GetItem(ctx, input); err != nil {
+	if err = checkAddress(email); err != nil {
+		return
+	} else if output, err = db.Client.GetItem(ctx, input); err != nil {
 		err = ops.AwsError("failed to get "+email, err)
 	} else if len(output.Item) == 0 {
 		err = ErrSubscriberNotFound
@@ -318,53 +727,1835 @@ func (db *DynamoDb) Get(
 	return
 }
 
+// newSubscriberRecord returns the DynamoDB record for sub.
+//
+// The record's primary key and "list" attribute come from sub.List.
+func newSubscriberRecord(sub *Subscriber) dbAttributes {
+	record := dbAttributes{
+		"email":            &dbString{Value: listKey(sub.List) + sub.Email},
+		"uid":              &dbString{Value: sub.Uid.String()},
+		string(sub.Status): toDynamoDbTimestamp(sub.Timestamp),
+	}
+
+	if sub.List != "" {
+		record["list"] = &dbString{Value: sub.List}
+	}
+
+	if sub.FirstName != "" {
+		record["firstName"] = &dbString{Value: sub.FirstName}
+	}
+	if len(sub.Attributes) != 0 {
+		attrs := make(dbAttributes, len(sub.Attributes))
+		for k, v := range sub.Attributes {
+			attrs[k] = &dbString{Value: v}
+		}
+		record["attributes"] = &dbMap{Value: attrs}
+	}
+	// DynamoDB doesn't allow empty sets.
+	if len(sub.Tags) != 0 {
+		record["tags"] = &dbStringSet{Value: sub.Tags}
+	}
+	if sub.Signup != nil {
+		signup := dbAttributes{}
+		for k, field := range signupFields(sub.Signup) {
+			if *field != "" {
+				signup[k] = &dbString{Value: *field}
+			}
+		}
+		// DynamoDB allows empty maps, but there's no point in storing one.
+		if len(signup) != 0 {
+			record["signup"] = &dbMap{Value: signup}
+		}
+	}
+	if sub.VerifySentCount != 0 {
+		record["verifySentCount"] = &dbNumber{
+			Value: strconv.Itoa(sub.VerifySentCount),
+		}
+	}
+	if !sub.VerifySentAt.IsZero() {
+		record["verifySentAt"] = toDynamoDbTimestamp(sub.VerifySentAt)
+	}
+	if sub.PreviousUid != uuid.Nil {
+		record["previousUid"] = &dbString{Value: sub.PreviousUid.String()}
+		record["previousUidExpires"] = toDynamoDbTimestamp(
+			sub.PreviousUidExpires,
+		)
+	}
+	return record
+}
+
+// Put stores sub as a subscriber to db.List, regardless of sub.List.
+//
+// It also updates the subscriber's tag records. It adds the records for the
+// current tags before storing sub, and deletes the records for any tags it no
+// longer has afterwards. This way, a failure between the steps may leave stale
+// tag records, which ProcessTaggedSubscribersFrom ignores, but never leaves a
+// tagged subscriber without them.
 func (db *DynamoDb) Put(ctx context.Context, sub *Subscriber) (err error) {
+	listSub := *sub
+	listSub.List = db.List
+	tags := indexedTags(sub)
 	input := &dynamodb.PutItemInput{
-		Item: dbAttributes{
-			"email":            &dbString{Value: sub.Email},
-			"uid":              &dbString{Value: sub.Uid.String()},
-			string(sub.Status): toDynamoDbTimestamp(sub.Timestamp),
-		},
-		TableName: aws.String(db.TableName),
+		Item:         newSubscriberRecord(&listSub),
+		TableName:    aws.String(db.TableName),
+		ReturnValues: dbtypes.ReturnValueAllOld,
 	}
-	if _, err = db.Client.PutItem(ctx, input); err != nil {
-		err = ops.AwsError("failed to put "+sub.Email, err)
+	var output *dynamodb.PutItemOutput
+
+	if err = checkAddress(sub.Email); err != nil {
+		return
+	} else if err = db.putTagRecords(ctx, sub.Email, tags); err != nil {
+		return
+	} else if output, err = db.Client.PutItem(ctx, input); err != nil {
+		return ops.AwsError("failed to put "+sub.Email, err)
 	}
-	return
+
+	stale := slices.DeleteFunc(
+		parseIndexedTags(output.Attributes),
+		func(tag string) bool { return slices.Contains(tags, tag) },
+	)
+	return db.deleteTagRecords(ctx, sub.Email, stale)
 }
 
+// Delete deletes the subscriber record for email, and its tag records.
 func (db *DynamoDb) Delete(ctx context.Context, email string) (err error) {
 	input := &dynamodb.DeleteItemInput{
-		Key: subscriberKey(email), TableName: aws.String(db.TableName),
+		Key:          db.subscriberKey(email),
+		TableName:    aws.String(db.TableName),
+		ReturnValues: dbtypes.ReturnValueAllOld,
 	}
-	if _, err = db.Client.DeleteItem(ctx, input); err != nil {
+	var output *dynamodb.DeleteItemOutput
+
+	if output, err = db.Client.DeleteItem(ctx, input); err != nil {
 		err = ops.AwsError("failed to delete "+email, err)
+	} else {
+		tags := parseIndexedTags(output.Attributes)
+		err = db.deleteTagRecords(ctx, email, tags)
 	}
 	return
 }
 
-func (db *DynamoDb) ProcessSubscribers(
-	ctx context.Context, status SubscriberStatus, sp SubscriberProcessor,
-) error {
-	input := &dynamodb.ScanInput{
-		TableName: aws.String(db.TableName),
-		IndexName: aws.String(string(status)),
+// Tag records also live in the subscribers table, one for each tag of each
+// verified subscriber. DynamoDB can't index the members of the "tags" set, so
+// these records comprise the tag index instead. Each key contains the tag and
+// the email address. The "tagIndex" attribute contains the list and the tag,
+// and the "tagEmail" attribute contains the address.
+//
+// Pending subscribers don't have tag records, since messages only go to
+// verified subscribers.
+const tagKeyPrefix = "tag#"
+
+func (db *DynamoDb) tagIndexPartition(tag string) string {
+	return db.keyPrefix(tagKeyPrefix + tag)
+}
+
+func (db *DynamoDb) tagKey(tag, email string) dbAttributes {
+	return dbAttributes{
+		DynamoDbPrimaryKey: &dbString{
+			Value: db.tagIndexPartition(tag) + "#" + email,
+		},
 	}
-	paginator := dynamodb.NewScanPaginator(db.Client, input)
+}
 
-	for paginator.HasMorePages() {
-		output, err := paginator.NextPage(ctx)
+func (db *DynamoDb) newTagRecord(tag, email string) dbAttributes {
+	record := db.tagKey(tag, email)
+	record[DynamoDbTagIndexPartitionKey] = &dbString{
+		Value: db.tagIndexPartition(tag),
+	}
+	record[DynamoDbTagIndexSortKey] = &dbString{Value: email}
+	return record
+}
 
-		if err != nil {
-			prefix := fmt.Sprintf("failed to get %s subscribers", status)
-			return ops.AwsError(prefix, err)
+// indexedTags returns the tags for which sub should have tag records.
+func indexedTags(sub *Subscriber) []string {
+	if sub.Status != SubscriberVerified {
+		return nil
+	}
+	return sub.Tags
+}
+
+// parseIndexedTags returns the tags for which the subscriber record in attrs
+// has tag records, if any.
+func parseIndexedTags(attrs dbAttributes) (tags []string) {
+	if _, verified := attrs[string(SubscriberVerified)]; verified {
+		tags, _ = (&dbParser{attrs}).GetStringSet("tags")
+	}
+	return
+}
+
+func (db *DynamoDb) putTagRecords(
+	ctx context.Context, email string, tags []string,
+) error {
+	for _, tag := range tags {
+		input := &dynamodb.PutItemInput{
+			Item:      db.newTagRecord(tag, email),
+			TableName: aws.String(db.TableName),
+		}
+		if _, err := db.Client.PutItem(ctx, input); err != nil {
+			const errFmt = "failed to put tag %s for %s"
+			return ops.AwsError(fmt.Sprintf(errFmt, tag, email), err)
 		}
+	}
+	return nil
+}
 
-		for _, item := range output.Items {
-			if s, err := parseSubscriber(item); err != nil || !sp.Process(s) {
-				return err
-			}
+func (db *DynamoDb) deleteTagRecords(
+	ctx context.Context, email string, tags []string,
+) error {
+	for _, tag := range tags {
+		input := &dynamodb.DeleteItemInput{
+			Key:       db.tagKey(tag, email),
+			TableName: aws.String(db.TableName),
+		}
+		if _, err := db.Client.DeleteItem(ctx, input); err != nil {
+			const errFmt = "failed to delete tag %s for %s"
+			return ops.AwsError(fmt.Sprintf(errFmt, tag, email), err)
 		}
 	}
 	return nil
 }
+
+// Receipt records also live in the subscribers table, for the same reasons as
+// checkpoint records. Each key contains the email address, so the receipts for
+// an address share the same key prefix, followed by the campaign ID. The record
+// contains no other attributes.
+const receiptKeyPrefix = "receipt#"
+
+func (db *DynamoDb) receiptKeyPrefix(email string) string {
+	return db.keyPrefix(receiptKeyPrefix + email + "#")
+}
+
+func (db *DynamoDb) receiptKey(email, campaignId string) dbAttributes {
+	return dbAttributes{
+		DynamoDbPrimaryKey: &dbString{
+			Value: db.receiptKeyPrefix(email) + campaignId,
+		},
+	}
+}
+
+// MarkReceived records that email received campaignId.
+func (db *DynamoDb) MarkReceived(
+	ctx context.Context, email, campaignId string,
+) (err error) {
+	input := &dynamodb.PutItemInput{
+		Item:      db.receiptKey(email, campaignId),
+		TableName: aws.String(db.TableName),
+	}
+	if _, err = db.Client.PutItem(ctx, input); err != nil {
+		const errFmt = "failed to mark %s as received by %s"
+		err = ops.AwsError(fmt.Sprintf(errFmt, campaignId, email), err)
+	}
+	return
+}
+
+func (db *DynamoDb) HasReceived(
+	ctx context.Context, email, campaignId string,
+) (received bool, err error) {
+	input := &dynamodb.GetItemInput{
+		Key:       db.receiptKey(email, campaignId),
+		TableName: aws.String(db.TableName),
+	}
+	var output *dynamodb.GetItemOutput
+
+	if output, err = db.Client.GetItem(ctx, input); err != nil {
+		const errFmt = "failed to check if %s received %s"
+		err = ops.AwsError(fmt.Sprintf(errFmt, email, campaignId), err)
+	} else {
+		received = len(output.Item) != 0
+	}
+	return
+}
+
+// DeleteReceipts scans the entire table for the receipt records for email and
+// deletes each one.
+//
+// Like DeleteAuditEvents, this requires a full table scan. This is OK, since
+// it's only used to erase an address's data upon request.
+func (db *DynamoDb) DeleteReceipts(
+	ctx context.Context, email string,
+) error {
+	return db.deleteKeysWithPrefix(
+		ctx,
+		db.receiptKeyPrefix(email),
+		"failed to delete receipts for "+email,
+	)
+}
+
+// RotateUid replaces a subscriber's Uid with uid, keeping the current Uid as
+// the PreviousUid until expires.
+//
+// It updates the record in place, so it doesn't overwrite any concurrent
+// changes to other attributes. It returns ErrSubscriberNotFound if the
+// subscriber no longer exists, instead of creating a new record.
+func (db *DynamoDb) RotateUid(
+	ctx context.Context, email string, uid uuid.UUID, expires time.Time,
+) (err error) {
+	// DynamoDB evaluates #uid on the right hand side before updating it.
+	const update = "SET #previousUid = #uid, " +
+		"#previousUidExpires = :expires, #uid = :uid"
+	input := &dynamodb.UpdateItemInput{
+		Key:                 db.subscriberKey(email),
+		TableName:           aws.String(db.TableName),
+		UpdateExpression:    aws.String(update),
+		ConditionExpression: aws.String("attribute_exists(#email)"),
+		ExpressionAttributeNames: map[string]string{
+			"#uid":                "uid",
+			"#previousUid":        "previousUid",
+			"#previousUidExpires": "previousUidExpires",
+			"#email":              "email",
+		},
+		ExpressionAttributeValues: dbAttributes{
+			":uid":     &dbString{Value: uid.String()},
+			":expires": toDynamoDbTimestamp(expires),
+		},
+	}
+	var condErr *dbtypes.ConditionalCheckFailedException
+
+	if _, err = db.Client.UpdateItem(ctx, input); err == nil {
+		return
+	} else if errors.As(err, &condErr) {
+		err = ErrSubscriberNotFound
+	} else {
+		err = ops.AwsError("failed to rotate UID for "+email, err)
+	}
+	return
+}
+
+// RecordVerifySent updates sub's verification email attributes, expiration
+// timestamp, and tags if its stored VerifySentCount is prevCount.
+//
+// It updates the record in place, so it doesn't overwrite any concurrent
+// changes to other attributes. It returns ErrVerifySentChanged if the pending
+// subscriber no longer exists or its VerifySentCount has changed.
+func (db *DynamoDb) RecordVerifySent(
+	ctx context.Context, sub *Subscriber, prevCount int,
+) (err error) {
+	update := "SET #pending = :pending, #count = :count, #sentAt = :sentAt"
+	// A record without verifySentCount predates it, or has a count of zero.
+	condition := "attribute_exists(#pending) AND attribute_not_exists(#count)"
+	names := map[string]string{
+		"#pending": string(SubscriberPending),
+		"#count":   "verifySentCount",
+		"#sentAt":  "verifySentAt",
+	}
+	values := dbAttributes{
+		":pending": toDynamoDbTimestamp(sub.Timestamp),
+		":count":   &dbNumber{Value: strconv.Itoa(sub.VerifySentCount)},
+		":sentAt":  toDynamoDbTimestamp(sub.VerifySentAt),
+	}
+
+	if prevCount != 0 {
+		condition = "attribute_exists(#pending) AND #count = :prevCount"
+		values[":prevCount"] = &dbNumber{Value: strconv.Itoa(prevCount)}
+	}
+	// DynamoDB doesn't allow empty sets, and tags never shrink here anyway.
+	if len(sub.Tags) != 0 {
+		update += ", #tags = :tags"
+		names["#tags"] = "tags"
+		values[":tags"] = &dbStringSet{Value: sub.Tags}
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		Key:                       db.subscriberKey(sub.Email),
+		TableName:                 aws.String(db.TableName),
+		UpdateExpression:          aws.String(update),
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	}
+	var condErr *dbtypes.ConditionalCheckFailedException
+
+	if _, err = db.Client.UpdateItem(ctx, input); err == nil {
+		return
+	} else if errors.As(err, &condErr) {
+		err = ErrVerifySentChanged
+	} else {
+		prefix := "failed to record verification email to " + sub.Email
+		err = ops.AwsError(prefix, err)
+	}
+	return
+}
+
+// addListFilter limits a subscriber index scan to the subscribers of db.List.
+//
+// Every list's subscribers share the same indexes, so the scan filters out the
+// subscribers of other lists. Subscribers of the default list are the only ones
+// without a listKeyPrefix.
+func (db *DynamoDb) addListFilter(input *dynamodb.ScanInput) {
+	filter := "begins_with(#email, :list)"
+	prefix := listKey(db.List)
+
+	if db.List == "" {
+		filter = "NOT " + filter
+		prefix = listKeyPrefix
+	}
+	input.FilterExpression = aws.String(filter)
+	input.ExpressionAttributeNames = map[string]string{"#email": "email"}
+	input.ExpressionAttributeValues = dbAttributes{
+		":list": &dbString{Value: prefix},
+	}
+}
+
+// addTagFilter limits a subscriber index scan to the subscribers matching
+// filter, which may be nil.
+//
+// ProcessTaggedSubscribersFrom only scans with a filter that doesn't provide
+// IndexTags, such as "not drafts", or for pending subscribers, which don't have
+// tag records. The scan still reads the entire index. However, DynamoDB
+// evaluates the filter itself, so only the matching subscribers cross the
+// network, and ProcessTaggedSubscribersFrom never has to parse the rest.
+func addTagFilter(input *dynamodb.ScanInput, filter *TagFilter) {
+	if filter == nil {
+		return
+	}
+	placeholders := map[string]string{}
+	placeholder := func(tag string) string {
+		if ph, ok := placeholders[tag]; ok {
+			return ph
+		}
+		ph := fmt.Sprintf(":tag%d", len(placeholders))
+		placeholders[tag] = ph
+		input.ExpressionAttributeValues[ph] = &dbString{Value: tag}
+		return ph
+	}
+	expr := tagFilterExpression(filter.root, placeholder)
+
+	input.FilterExpression = aws.String(
+		"(" + aws.ToString(input.FilterExpression) + ") AND " + expr,
+	)
+	input.ExpressionAttributeNames["#tags"] = "tags"
+}
+
+func tagFilterExpression(
+	n *tagNode, placeholder func(tag string) string,
+) string {
+	switch n.op {
+	case tagLeaf:
+		return "contains(#tags, " + placeholder(n.tag) + ")"
+	case tagNot:
+		return "(NOT " + tagFilterExpression(n.operands[0], placeholder) + ")"
+	}
+
+	keyword := " AND "
+	if n.op == tagOr {
+		keyword = " OR "
+	}
+	operands := make([]string, len(n.operands))
+	for i, operand := range n.operands {
+		operands[i] = tagFilterExpression(operand, placeholder)
+	}
+	return "(" + strings.Join(operands, keyword) + ")"
+}
+
+func (db *DynamoDb) ProcessSubscribers(
+	ctx context.Context, status SubscriberStatus, sp SubscriberProcessor,
+) error {
+	return db.ProcessSubscribersFrom(ctx, status, nil, sp)
+}
+
+// ProcessSubscribersFrom processes subscribers following startKey.
+//
+// If startKey is nil, processing begins with the first subscriber, just like
+// ProcessSubscribers.
+func (db *DynamoDb) ProcessSubscribersFrom(
+	ctx context.Context,
+	status SubscriberStatus,
+	startKey *ScanKey,
+	sp SubscriberProcessor,
+) error {
+	return db.ProcessTaggedSubscribersFrom(ctx, status, nil, startKey, sp)
+}
+
+// ProcessTaggedSubscribersFrom processes subscribers matching filter following
+// startKey.
+//
+// It behaves like ProcessSubscribersFrom, but skips subscribers that don't
+// match filter. A nil filter matches every subscriber. If filter provides
+// IndexTags, it queries the tag index for the verified subscribers with those
+// tags. Otherwise DynamoDB filters out the subscribers that don't match while
+// scanning the status index.
+func (db *DynamoDb) ProcessTaggedSubscribersFrom(
+	ctx context.Context,
+	status SubscriberStatus,
+	filter *TagFilter,
+	startKey *ScanKey,
+	sp SubscriberProcessor,
+) error {
+	if tags, ok := filter.IndexTags(); ok && status == SubscriberVerified {
+		return db.processIndexedSubscribers(ctx, filter, tags, startKey, sp)
+	}
+
+	input := &dynamodb.ScanInput{
+		TableName: aws.String(db.TableName),
+		IndexName: aws.String(string(status)),
+	}
+	db.addListFilter(input)
+	addTagFilter(input, filter)
+
+	// The key for a Global Secondary Index item includes both the table's
+	// primary key and the index's partition key.
+	if startKey != nil {
+		input.ExclusiveStartKey = dbAttributes{
+			"email":        &dbString{Value: db.keyPrefix(startKey.Email)},
+			string(status): toDynamoDbTimestamp(startKey.Timestamp),
+		}
+	}
+	paginator := dynamodb.NewScanPaginator(db.Client, input)
+
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+
+		if err != nil {
+			prefix := fmt.Sprintf("failed to get %s subscribers", status)
+			return ops.AwsError(prefix, err)
+		}
+
+		for _, item := range output.Items {
+			if s, err := parseSubscriber(item); err != nil || !sp.Process(s) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// processIndexedSubscribers processes the verified subscribers matching filter
+// following startKey, in address order, by querying the tag index for each of
+// tags.
+//
+// Every subscriber matching filter has at least one of tags (see
+// TagFilter.IndexTags). The tag records only identify the candidates, so it
+// still gets each candidate's subscriber record and checks it against filter.
+// This skips any stale tag records left by a Put that failed partway.
+func (db *DynamoDb) processIndexedSubscribers(
+	ctx context.Context,
+	filter *TagFilter,
+	tags []string,
+	startKey *ScanKey,
+	sp SubscriberProcessor,
+) error {
+	queries := make([]*tagQuery, len(tags))
+	for i, tag := range tags {
+		queries[i] = db.newTagQuery(tag, startKey)
+	}
+
+	for {
+		email, err := nextTaggedEmail(ctx, queries)
+		if err != nil || email == "" {
+			return err
+		}
+
+		sub, err := db.Get(ctx, email)
+		if errors.Is(err, ErrSubscriberNotFound) {
+			continue
+		} else if err != nil {
+			return err
+		} else if sub.Status != SubscriberVerified {
+			continue
+		} else if filter.Matches(sub.Tags) && !sp.Process(sub) {
+			return nil
+		}
+	}
+}
+
+// tagQuery returns the addresses in one partition of the tag index, in order.
+type tagQuery struct {
+	tag       string
+	paginator *dynamodb.QueryPaginator
+	emails    []string
+}
+
+func (db *DynamoDb) newTagQuery(tag string, startKey *ScanKey) *tagQuery {
+	condition := "#tagIndex = :tagIndex"
+	names := map[string]string{"#tagIndex": DynamoDbTagIndexPartitionKey}
+	values := dbAttributes{
+		":tagIndex": &dbString{Value: db.tagIndexPartition(tag)},
+	}
+
+	if startKey != nil {
+		condition += " AND #tagEmail > :startEmail"
+		names["#tagEmail"] = DynamoDbTagIndexSortKey
+		values[":startEmail"] = &dbString{Value: startKey.Email}
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(db.TableName),
+		IndexName:                 aws.String(DynamoDbTagIndexName),
+		KeyConditionExpression:    aws.String(condition),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	}
+	return &tagQuery{
+		tag: tag, paginator: dynamodb.NewQueryPaginator(db.Client, input),
+	}
+}
+
+// peek returns the next address from q without consuming it, or the empty
+// string if there are none left.
+func (q *tagQuery) peek(ctx context.Context) (email string, err error) {
+	for len(q.emails) == 0 && q.paginator.HasMorePages() {
+		var output *dynamodb.QueryOutput
+
+		if output, err = q.paginator.NextPage(ctx); err != nil {
+			prefix := "failed to get subscribers tagged " + q.tag
+			return "", ops.AwsError(prefix, err)
+		}
+
+		for _, item := range output.Items {
+			p := &dbParser{item}
+			if email, err = p.GetString(DynamoDbTagIndexSortKey); err != nil {
+				return "", err
+			}
+			q.emails = append(q.emails, email)
+		}
+	}
+
+	if len(q.emails) != 0 {
+		email = q.emails[0]
+	}
+	return
+}
+
+// nextTaggedEmail returns the lowest address remaining in any of queries, or
+// the empty string if there are none left.
+//
+// It consumes the address from every query containing it, so a subscriber with
+// more than one of the tags appears only once.
+func nextTaggedEmail(
+	ctx context.Context, queries []*tagQuery,
+) (next string, err error) {
+	heads := make([]string, len(queries))
+
+	for i, q := range queries {
+		if heads[i], err = q.peek(ctx); err != nil {
+			return
+		} else if heads[i] != "" && (next == "" || heads[i] < next) {
+			next = heads[i]
+		}
+	}
+
+	for i, q := range queries {
+		if next != "" && heads[i] == next {
+			q.emails = q.emails[1:]
+		}
+	}
+	return
+}
+
+// Checkpoint records live in the subscribers table alongside subscriber
+// records. Their primary keys can't collide with subscriber email addresses,
+// since they never contain an '@'. They also don't contain the "pending" or
+// "verified" attributes, so they never appear in ProcessSubscribers scans.
+const checkpointKeyPrefix = "checkpoint#"
+
+func (db *DynamoDb) checkpointKey(campaignId string) dbAttributes {
+	return db.key(checkpointKeyPrefix + campaignId)
+}
+
+func parseCheckpoint(attrs dbAttributes) (cp *SendCheckpoint, err error) {
+	p := dbParser{attrs}
+	c := &SendCheckpoint{}
+	errs := make([]error, 0, 5)
+	addErr := func(e error) {
+		errs = append(errs, e)
+	}
+
+	if c.CampaignId, err = p.GetString("campaignId"); err != nil {
+		addErr(err)
+	}
+	if c.MessageHash, err = p.GetString("messageHash"); err != nil {
+		addErr(err)
+	}
+	if _, ok := attrs["tagFilter"]; !ok {
+		// Only sends to tagged subscribers have this attribute.
+	} else if c.TagFilter, err = p.GetString("tagFilter"); err != nil {
+		addErr(err)
+	}
+	if _, ok := attrs["lastEmail"]; ok {
+		c.LastKey = &ScanKey{}
+		if c.LastKey.Email, err = p.GetString("lastEmail"); err != nil {
+			addErr(err)
+		}
+		if c.LastKey.Timestamp, err = p.GetTime("lastTimestamp"); err != nil {
+			addErr(err)
+		}
+	}
+	if c.NumSent, err = p.GetInt("numSent"); err != nil {
+		addErr(err)
+	}
+	if c.Complete, err = p.GetBool("complete"); err != nil {
+		addErr(err)
+	}
+	if c.Timestamp, err = p.GetTime("updated"); err != nil {
+		addErr(err)
+	}
+
+	if err = errors.Join(errs...); err != nil {
+		err = errors.New("failed to parse checkpoint: " + err.Error())
+	} else {
+		cp = c
+	}
+	return
+}
+
+func (db *DynamoDb) newCheckpointRecord(cp *SendCheckpoint) dbAttributes {
+	record := db.checkpointKey(cp.CampaignId)
+	record["campaignId"] = &dbString{Value: cp.CampaignId}
+	record["messageHash"] = &dbString{Value: cp.MessageHash}
+	record["numSent"] = &dbNumber{Value: strconv.Itoa(cp.NumSent)}
+	record["complete"] = &dbBool{Value: cp.Complete}
+	record["updated"] = toDynamoDbTimestamp(cp.Timestamp)
+
+	if cp.TagFilter != "" {
+		record["tagFilter"] = &dbString{Value: cp.TagFilter}
+	}
+	if cp.LastKey != nil {
+		record["lastEmail"] = &dbString{Value: cp.LastKey.Email}
+		record["lastTimestamp"] = toDynamoDbTimestamp(cp.LastKey.Timestamp)
+	}
+	return record
+}
+
+func (db *DynamoDb) GetCheckpoint(
+	ctx context.Context, campaignId string,
+) (checkpoint *SendCheckpoint, err error) {
+	input := &dynamodb.GetItemInput{
+		Key:       db.checkpointKey(campaignId),
+		TableName: aws.String(db.TableName),
+	}
+	var output *dynamodb.GetItemOutput
+
+	if output, err = db.Client.GetItem(ctx, input); err != nil {
+		err = ops.AwsError("failed to get checkpoint "+campaignId, err)
+	} else if len(output.Item) == 0 {
+		err = ErrCheckpointNotFound
+	} else {
+		checkpoint, err = parseCheckpoint(output.Item)
+	}
+	return
+}
+
+func (db *DynamoDb) PutCheckpoint(
+	ctx context.Context, checkpoint *SendCheckpoint,
+) (err error) {
+	input := &dynamodb.PutItemInput{
+		Item:      db.newCheckpointRecord(checkpoint),
+		TableName: aws.String(db.TableName),
+	}
+	if _, err = db.Client.PutItem(ctx, input); err != nil {
+		prefix := "failed to put checkpoint " + checkpoint.CampaignId
+		err = ops.AwsError(prefix, err)
+	}
+	return
+}
+
+// Campaign records also live in the subscribers table, for the same reasons
+// as checkpoint records.
+const campaignKeyPrefix = "campaign#"
+
+func (db *DynamoDb) campaignKey(id string) dbAttributes {
+	return db.key(campaignKeyPrefix + id)
+}
+
+func parseCampaign(attrs dbAttributes) (campaign *Campaign, err error) {
+	p := dbParser{attrs}
+	c := &Campaign{}
+	var status string
+	errs := make([]error, 0, 8)
+	addErr := func(e error) {
+		errs = append(errs, e)
+	}
+
+	if c.Id, err = p.GetString("campaignId"); err != nil {
+		addErr(err)
+	}
+	if c.Subject, err = p.GetString("subject"); err != nil {
+		addErr(err)
+	}
+	if c.MessageHash, err = p.GetString("messageHash"); err != nil {
+		addErr(err)
+	}
+	if _, ok := attrs["tagFilter"]; !ok {
+		// Only sends to tagged subscribers have this attribute.
+	} else if c.TagFilter, err = p.GetString("tagFilter"); err != nil {
+		addErr(err)
+	}
+	if c.StartTime, err = p.GetTime("started"); err != nil {
+		addErr(err)
+	}
+	if _, ok := attrs["finished"]; !ok {
+		// Only complete campaigns have this attribute.
+	} else if c.FinishTime, err = p.GetTime("finished"); err != nil {
+		addErr(err)
+	}
+	if c.NumSent, err = p.GetInt("numSent"); err != nil {
+		addErr(err)
+	}
+	if c.NumFailed, err = p.GetInt("numFailed"); err != nil {
+		addErr(err)
+	}
+	if status, err = p.GetString("status"); err != nil {
+		addErr(err)
+	}
+	c.Status = CampaignStatus(status)
+
+	if err = errors.Join(errs...); err != nil {
+		err = errors.New("failed to parse campaign: " + err.Error())
+	} else {
+		campaign = c
+	}
+	return
+}
+
+func (db *DynamoDb) newCampaignRecord(campaign *Campaign) dbAttributes {
+	record := db.campaignKey(campaign.Id)
+	record["campaignId"] = &dbString{Value: campaign.Id}
+	record["subject"] = &dbString{Value: campaign.Subject}
+	record["messageHash"] = &dbString{Value: campaign.MessageHash}
+	record["started"] = toDynamoDbTimestamp(campaign.StartTime)
+	record["numSent"] = &dbNumber{Value: strconv.Itoa(campaign.NumSent)}
+	record["numFailed"] = &dbNumber{Value: strconv.Itoa(campaign.NumFailed)}
+	record["status"] = &dbString{Value: string(campaign.Status)}
+
+	if campaign.TagFilter != "" {
+		record["tagFilter"] = &dbString{Value: campaign.TagFilter}
+	}
+	if !campaign.FinishTime.IsZero() {
+		record["finished"] = toDynamoDbTimestamp(campaign.FinishTime)
+	}
+	return record
+}
+
+func (db *DynamoDb) GetCampaign(
+	ctx context.Context, id string,
+) (campaign *Campaign, err error) {
+	input := &dynamodb.GetItemInput{
+		Key: db.campaignKey(id), TableName: aws.String(db.TableName),
+	}
+	var output *dynamodb.GetItemOutput
+
+	if output, err = db.Client.GetItem(ctx, input); err != nil {
+		err = ops.AwsError("failed to get campaign "+id, err)
+	} else if len(output.Item) == 0 {
+		err = ErrCampaignNotFound
+	} else {
+		campaign, err = parseCampaign(output.Item)
+	}
+	return
+}
+
+func (db *DynamoDb) PutCampaign(
+	ctx context.Context, campaign *Campaign,
+) (err error) {
+	input := &dynamodb.PutItemInput{
+		Item:      db.newCampaignRecord(campaign),
+		TableName: aws.String(db.TableName),
+	}
+	if _, err = db.Client.PutItem(ctx, input); err != nil {
+		err = ops.AwsError("failed to put campaign "+campaign.Id, err)
+	}
+	return
+}
+
+// ListCampaigns scans the entire table for campaign records.
+//
+// Campaign records don't appear in either Global Secondary Index, so this
+// requires a full table scan. This is OK, since it's only used for occasional
+// auditing via the command line interface.
+func (db *DynamoDb) ListCampaigns(
+	ctx context.Context,
+) (campaigns []*Campaign, err error) {
+	input := &dynamodb.ScanInput{
+		TableName:                aws.String(db.TableName),
+		FilterExpression:         aws.String("begins_with(#email, :prefix)"),
+		ExpressionAttributeNames: map[string]string{"#email": "email"},
+		ExpressionAttributeValues: dbAttributes{
+			":prefix": &dbString{Value: db.keyPrefix(campaignKeyPrefix)},
+		},
+	}
+	paginator := dynamodb.NewScanPaginator(db.Client, input)
+	campaigns = make([]*Campaign, 0, 10)
+
+	for paginator.HasMorePages() {
+		var output *dynamodb.ScanOutput
+
+		if output, err = paginator.NextPage(ctx); err != nil {
+			return nil, ops.AwsError("failed to list campaigns", err)
+		}
+
+		for _, item := range output.Items {
+			var c *Campaign
+			if c, err = parseCampaign(item); err != nil {
+				return nil, err
+			}
+			campaigns = append(campaigns, c)
+		}
+	}
+	return
+}
+
+// dynamoDbItemSizeLimit is the maximum size of a DynamoDB item, including its
+// attribute names and values.
+//
+// See: https://docs.aws.amazon.com/amazondynamodb/latest/developerguide/ServiceQuotas.html
+const dynamoDbItemSizeLimit = 400 * 1024
+
+// ErrItemTooLarge indicates that DynamoDb didn't store a record because it
+// would exceed DynamoDB's item size limit.
+const ErrItemTooLarge = types.SentinelError(
+	"record exceeds DynamoDB item size limit of 409600 bytes",
+)
+
+// checkItemSizes returns an error wrapping ErrItemTooLarge if any item exceeds
+// dynamoDbItemSizeLimit, so that callers can check every item before storing
+// any of them.
+func checkItemSizes(items ...dbAttributes) error {
+	for _, item := range items {
+		if size := itemSize(item); size > dynamoDbItemSizeLimit {
+			const errFmt = "%w: record size: %d bytes"
+			return fmt.Errorf(errFmt, ErrItemTooLarge, size)
+		}
+	}
+	return nil
+}
+
+// itemSize returns the size of item as DynamoDB computes it, except that it
+// counts each number as its string length, which is never smaller than the
+// size DynamoDB computes.
+func itemSize(item dbAttributes) (size int) {
+	for name, value := range item {
+		size += len(name) + attributeSize(value)
+	}
+	return
+}
+
+func attributeSize(value dbtypes.AttributeValue) (size int) {
+	switch v := value.(type) {
+	case *dbString:
+		size = len(v.Value)
+	case *dbNumber:
+		size = len(v.Value)
+	case *dbBinary:
+		size = len(v.Value)
+	case *dbBool:
+		size = 1
+	case *dbStringSet:
+		for _, s := range v.Value {
+			size += len(s)
+		}
+	case *dbMap:
+		// Each map costs three bytes, plus one byte per element.
+		size = 3 + len(v.Value) + itemSize(v.Value)
+	}
+	return
+}
+
+// Part records also live in the subscribers table, for the same reasons as
+// checkpoint records. They contain values too large for a single record, such
+// as the JSON encoding of an email.Message, including any attachments, since
+// DynamoDB limits each item to 400KB. Each key contains the key of the record
+// that owns the value, followed by the part's index. The owning record stores
+// the number of parts.
+const partKeyPrefix = "part#"
+
+// dynamoDbPartSize is the maximum size of the data in each part record. It
+// leaves plenty of room for the key and attribute names within
+// dynamoDbItemSizeLimit.
+const dynamoDbPartSize = 350 * 1024
+
+func (db *DynamoDb) partKey(owner string, i int) dbAttributes {
+	return db.key(partKeyPrefix + owner + "#" + strconv.Itoa(i))
+}
+
+// splitParts splits data into parts no larger than size. It always returns at
+// least one part, even if data is empty.
+func splitParts(data []byte, size int) (parts [][]byte) {
+	for len(data) > size {
+		parts = append(parts, data[:size])
+		data = data[size:]
+	}
+	return append(parts, data)
+}
+
+// newPartRecords returns the part records containing data belonging to owner.
+func (db *DynamoDb) newPartRecords(owner string, data []byte) []dbAttributes {
+	parts := splitParts(data, dynamoDbPartSize)
+	records := make([]dbAttributes, len(parts))
+
+	for i, part := range parts {
+		records[i] = db.partKey(owner, i)
+		records[i]["data"] = &dbBinary{Value: part}
+	}
+	return records
+}
+
+// putParts stores the records from newPartRecords.
+func (db *DynamoDb) putParts(
+	ctx context.Context, parts []dbAttributes,
+) (err error) {
+	for _, part := range parts {
+		input := &dynamodb.PutItemInput{
+			Item: part, TableName: aws.String(db.TableName),
+		}
+		if _, err = db.Client.PutItem(ctx, input); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// getParts returns the data that putParts stored for owner.
+func (db *DynamoDb) getParts(
+	ctx context.Context, owner string, numParts int,
+) (data []byte, err error) {
+	for i := range numParts {
+		input := &dynamodb.GetItemInput{
+			Key:            db.partKey(owner, i),
+			TableName:      aws.String(db.TableName),
+			ConsistentRead: aws.Bool(true),
+		}
+		var output *dynamodb.GetItemOutput
+		var part []byte
+
+		if output, err = db.Client.GetItem(ctx, input); err != nil {
+			return nil, err
+		} else if part, err = (&dbParser{output.Item}).GetBytes(
+			"data",
+		); err != nil {
+			return nil, fmt.Errorf("failed to parse part %d: %w", i, err)
+		}
+		data = append(data, part...)
+	}
+	return
+}
+
+// deleteParts deletes the part records that putParts stored for owner.
+func (db *DynamoDb) deleteParts(
+	ctx context.Context, owner string, numParts int,
+) (err error) {
+	for i := range numParts {
+		input := &dynamodb.DeleteItemInput{
+			Key: db.partKey(owner, i), TableName: aws.String(db.TableName),
+		}
+		if _, err = db.Client.DeleteItem(ctx, input); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Scheduled message records also live in the subscribers table, for the same
+// reasons as checkpoint records. Each record contains the
+// DynamoDbScheduledIndexPartitionKey and DynamoDbScheduledIndexSortKey
+// attributes, so that it appears in the scheduled message index. The message
+// itself lives in part records, so it may exceed DynamoDB's item size limit.
+const scheduledKeyPrefix = "scheduled#"
+
+func (db *DynamoDb) scheduledKey(id string) dbAttributes {
+	return db.key(scheduledKeyPrefix + id)
+}
+
+func (db *DynamoDb) scheduledPartsOwner(id string) string {
+	return scheduledKeyPrefix + id
+}
+
+// scheduledIndexPartition returns the scheduled message index partition
+// containing db.List's scheduled messages.
+func (db *DynamoDb) scheduledIndexPartition() string {
+	return db.keyPrefix(scheduledKeyPrefix)
+}
+
+// parseScheduledMessage parses every ScheduledMessage field except Message,
+// which lives in the numParts part records.
+func parseScheduledMessage(
+	attrs dbAttributes,
+) (scheduled *ScheduledMessage, numParts int, err error) {
+	p := dbParser{attrs}
+	s := &ScheduledMessage{}
+	errs := make([]error, 0, 3)
+	addErr := func(e error) {
+		errs = append(errs, e)
+	}
+
+	if s.Id, err = p.GetString("scheduledId"); err != nil {
+		addErr(err)
+	}
+	if s.SendAt, err = p.GetTime("sendAt"); err != nil {
+		addErr(err)
+	}
+	if _, ok := attrs["claimedUntil"]; !ok {
+		// Only claimed messages have this attribute.
+	} else if s.ClaimedUntil, err = p.GetTime("claimedUntil"); err != nil {
+		addErr(err)
+	}
+	if numParts, err = p.GetInt("parts"); err != nil {
+		addErr(err)
+	}
+
+	if err = errors.Join(errs...); err != nil {
+		err = errors.New("failed to parse scheduled message: " + err.Error())
+		numParts = 0
+	} else {
+		scheduled = s
+	}
+	return
+}
+
+func (db *DynamoDb) newScheduledMessageRecord(
+	scheduled *ScheduledMessage, numParts int,
+) dbAttributes {
+	record := db.scheduledKey(scheduled.Id)
+	record["scheduledId"] = &dbString{Value: scheduled.Id}
+	record[DynamoDbScheduledIndexPartitionKey] = &dbString{
+		Value: db.scheduledIndexPartition(),
+	}
+	record[DynamoDbScheduledIndexSortKey] = toDynamoDbTimestamp(
+		scheduled.SendAt,
+	)
+	record["parts"] = &dbNumber{Value: strconv.Itoa(numParts)}
+
+	if !scheduled.ClaimedUntil.IsZero() {
+		record["claimedUntil"] = toDynamoDbTimestamp(scheduled.ClaimedUntil)
+	}
+	return record
+}
+
+// PutScheduledMessage stores the message in part records before storing the
+// scheduled message record, so GetDueMessages never finds a scheduled message
+// record with missing parts.
+//
+// It checks the size of every record before storing any of them, and returns
+// an error wrapping ErrItemTooLarge if any is too large.
+func (db *DynamoDb) PutScheduledMessage(
+	ctx context.Context, scheduled *ScheduledMessage,
+) (err error) {
+	prefix := "failed to put scheduled message " + scheduled.Id
+	// Marshaling can't fail, since email.Message contains only strings.
+	msgJson, _ := json.Marshal(scheduled.Message)
+	parts := db.newPartRecords(db.scheduledPartsOwner(scheduled.Id), msgJson)
+	record := db.newScheduledMessageRecord(scheduled, len(parts))
+
+	if err = checkItemSizes(append(parts, record)...); err != nil {
+		return fmt.Errorf("%s: %w", prefix, err)
+	} else if err = db.putParts(ctx, parts); err == nil {
+		input := &dynamodb.PutItemInput{
+			Item: record, TableName: aws.String(db.TableName),
+		}
+		_, err = db.Client.PutItem(ctx, input)
+	}
+	if err != nil {
+		err = ops.AwsError(prefix, err)
+	}
+	return
+}
+
+// GetDueMessages queries the scheduled message index for db.List's due
+// messages, then reads the part records for each.
+func (db *DynamoDb) GetDueMessages(
+	ctx context.Context, now time.Time,
+) (due []*ScheduledMessage, err error) {
+	input := &dynamodb.QueryInput{
+		TableName: aws.String(db.TableName),
+		IndexName: aws.String(DynamoDbScheduledIndexName),
+		KeyConditionExpression: aws.String(
+			"#list = :list AND #sendAt <= :now",
+		),
+		ExpressionAttributeNames: map[string]string{
+			"#list":   DynamoDbScheduledIndexPartitionKey,
+			"#sendAt": DynamoDbScheduledIndexSortKey,
+		},
+		ExpressionAttributeValues: dbAttributes{
+			":list": &dbString{Value: db.scheduledIndexPartition()},
+			":now":  toDynamoDbTimestamp(now),
+		},
+	}
+	paginator := dynamodb.NewQueryPaginator(db.Client, input)
+	due = make([]*ScheduledMessage, 0, 1)
+
+	for paginator.HasMorePages() {
+		var output *dynamodb.QueryOutput
+
+		if output, err = paginator.NextPage(ctx); err != nil {
+			return nil, ops.AwsError("failed to get due messages", err)
+		}
+
+		for _, item := range output.Items {
+			var s *ScheduledMessage
+			if s, err = db.getScheduledMessage(ctx, item); err != nil {
+				return nil, err
+			}
+			due = append(due, s)
+		}
+	}
+	return
+}
+
+func (db *DynamoDb) getScheduledMessage(
+	ctx context.Context, item dbAttributes,
+) (scheduled *ScheduledMessage, err error) {
+	var numParts int
+	var msgJson []byte
+
+	if scheduled, numParts, err = parseScheduledMessage(item); err != nil {
+		return
+	}
+	owner := db.scheduledPartsOwner(scheduled.Id)
+	scheduled.Message = &email.Message{}
+
+	if msgJson, err = db.getParts(ctx, owner, numParts); err == nil {
+		err = json.Unmarshal(msgJson, scheduled.Message)
+	}
+	if err != nil {
+		prefix := "failed to get scheduled message " + scheduled.Id
+		return nil, ops.AwsError(prefix, err)
+	}
+	return
+}
+
+// ClaimScheduledMessage sets the claimedUntil attribute only if the current
+// claim has expired, so DynamoDB ensures that only one concurrent caller
+// succeeds.
+func (db *DynamoDb) ClaimScheduledMessage(
+	ctx context.Context, id string, now, until time.Time,
+) (err error) {
+	const cond = "attribute_exists(#email) AND " +
+		"(attribute_not_exists(#claimedUntil) OR #claimedUntil <= :now)"
+	input := &dynamodb.UpdateItemInput{
+		Key:                 db.scheduledKey(id),
+		TableName:           aws.String(db.TableName),
+		UpdateExpression:    aws.String("SET #claimedUntil = :until"),
+		ConditionExpression: aws.String(cond),
+		ExpressionAttributeNames: map[string]string{
+			"#email": "email", "#claimedUntil": "claimedUntil",
+		},
+		ExpressionAttributeValues: dbAttributes{
+			":now":   toDynamoDbTimestamp(now),
+			":until": toDynamoDbTimestamp(until),
+		},
+	}
+	var condErr *dbtypes.ConditionalCheckFailedException
+
+	if _, err = db.Client.UpdateItem(ctx, input); err == nil {
+		return
+	} else if errors.As(err, &condErr) {
+		err = ErrScheduledMessageClaimed
+	} else {
+		err = ops.AwsError("failed to claim scheduled message "+id, err)
+	}
+	return
+}
+
+// ReleaseScheduledMessage removes the claimedUntil attribute only if it still
+// matches until, using the same kind of conditional update as
+// ClaimScheduledMessage.
+func (db *DynamoDb) ReleaseScheduledMessage(
+	ctx context.Context, id string, until time.Time,
+) (err error) {
+	input := &dynamodb.UpdateItemInput{
+		Key:                 db.scheduledKey(id),
+		TableName:           aws.String(db.TableName),
+		UpdateExpression:    aws.String("REMOVE #claimedUntil"),
+		ConditionExpression: aws.String("#claimedUntil = :until"),
+		ExpressionAttributeNames: map[string]string{
+			"#claimedUntil": "claimedUntil",
+		},
+		ExpressionAttributeValues: dbAttributes{
+			":until": toDynamoDbTimestamp(until),
+		},
+	}
+	var condErr *dbtypes.ConditionalCheckFailedException
+
+	if _, err = db.Client.UpdateItem(ctx, input); errors.As(err, &condErr) {
+		err = nil
+	} else if err != nil {
+		err = ops.AwsError("failed to release scheduled message "+id, err)
+	}
+	return
+}
+
+// DeleteScheduledMessage deletes the scheduled message record before its part
+// records, so GetDueMessages never finds a scheduled message record with
+// missing parts.
+func (db *DynamoDb) DeleteScheduledMessage(
+	ctx context.Context, id string,
+) (err error) {
+	input := &dynamodb.DeleteItemInput{
+		Key:          db.scheduledKey(id),
+		TableName:    aws.String(db.TableName),
+		ReturnValues: dbtypes.ReturnValueAllOld,
+	}
+	var output *dynamodb.DeleteItemOutput
+	var numParts int
+
+	if output, err = db.Client.DeleteItem(ctx, input); err != nil {
+		return ops.AwsError("failed to delete scheduled message "+id, err)
+	} else if len(output.Attributes) == 0 {
+		return
+	} else if _, numParts, err = parseScheduledMessage(
+		output.Attributes,
+	); err != nil {
+		return
+	}
+
+	owner := db.scheduledPartsOwner(id)
+	if err = db.deleteParts(ctx, owner, numParts); err != nil {
+		err = ops.AwsError("failed to delete scheduled message "+id, err)
+	}
+	return
+}
+
+// Audit event records also live in the subscribers table, for the same reasons
+// as checkpoint records. Each key contains the email address, so the events for
+// an address share the same key prefix, followed by the event's timestamp in
+// nanoseconds to make it unique.
+const auditKeyPrefix = "audit#"
+
+func (db *DynamoDb) auditKeyPrefix(email string) string {
+	return db.keyPrefix(auditKeyPrefix + email + "#")
+}
+
+func (db *DynamoDb) auditKey(event *AuditEvent) dbAttributes {
+	nanos := strconv.FormatInt(event.Timestamp.UnixNano(), 10)
+	return dbAttributes{
+		DynamoDbPrimaryKey: &dbString{
+			Value: db.auditKeyPrefix(event.Email) + nanos,
+		},
+	}
+}
+
+func parseAuditEvent(attrs dbAttributes) (event *AuditEvent, err error) {
+	p := dbParser{attrs}
+	e := &AuditEvent{}
+	var operation, reason string
+	errs := make([]error, 0, 6)
+	addErr := func(e error) {
+		errs = append(errs, e)
+	}
+
+	if e.Email, err = p.GetString("auditEmail"); err != nil {
+		addErr(err)
+	}
+	if operation, err = p.GetString("operation"); err != nil {
+		addErr(err)
+	}
+	e.Operation = AuditOperation(operation)
+	if _, ok := attrs["reason"]; !ok {
+		// Only remove events have this attribute.
+	} else if reason, err = p.GetString("reason"); err != nil {
+		addErr(err)
+	}
+	e.Reason = ops.RemoveReason(reason)
+	if e.Source, err = p.GetString("source"); err != nil {
+		addErr(err)
+	}
+	if _, ok := attrs["requestId"]; !ok {
+		// Not every source provides a request ID.
+	} else if e.RequestId, err = p.GetString("requestId"); err != nil {
+		addErr(err)
+	}
+	if e.Timestamp, err = p.GetTime("timestamp"); err != nil {
+		addErr(err)
+	}
+
+	if err = errors.Join(errs...); err != nil {
+		err = errors.New("failed to parse audit event: " + err.Error())
+	} else {
+		event = e
+	}
+	return
+}
+
+func (db *DynamoDb) newAuditEventRecord(event *AuditEvent) dbAttributes {
+	record := db.auditKey(event)
+	record["auditEmail"] = &dbString{Value: event.Email}
+	record["operation"] = &dbString{Value: string(event.Operation)}
+	record["source"] = &dbString{Value: event.Source}
+	record["timestamp"] = toDynamoDbTimestamp(event.Timestamp)
+
+	if event.Reason != ops.RemoveReasonNil {
+		record["reason"] = &dbString{Value: string(event.Reason)}
+	}
+	if event.RequestId != "" {
+		record["requestId"] = &dbString{Value: event.RequestId}
+	}
+	return record
+}
+
+func (db *DynamoDb) PutAuditEvent(
+	ctx context.Context, event *AuditEvent,
+) (err error) {
+	input := &dynamodb.PutItemInput{
+		Item:      db.newAuditEventRecord(event),
+		TableName: aws.String(db.TableName),
+	}
+	if _, err = db.Client.PutItem(ctx, input); err != nil {
+		prefix := "failed to put audit event for " + event.Email
+		err = ops.AwsError(prefix, err)
+	}
+	return
+}
+
+// GetAuditEvents scans the entire table for the audit event records for email.
+//
+// Like ListCampaigns, this requires a full table scan. This is OK, since it's
+// only used for occasional auditing via the command line interface.
+func (db *DynamoDb) GetAuditEvents(
+	ctx context.Context, email string,
+) (events []*AuditEvent, err error) {
+	input := &dynamodb.ScanInput{
+		TableName:                aws.String(db.TableName),
+		FilterExpression:         aws.String("begins_with(#email, :prefix)"),
+		ExpressionAttributeNames: map[string]string{"#email": "email"},
+		ExpressionAttributeValues: dbAttributes{
+			":prefix": &dbString{Value: db.auditKeyPrefix(email)},
+		},
+	}
+	paginator := dynamodb.NewScanPaginator(db.Client, input)
+	events = make([]*AuditEvent, 0, 4)
+
+	for paginator.HasMorePages() {
+		var output *dynamodb.ScanOutput
+
+		if output, err = paginator.NextPage(ctx); err != nil {
+			prefix := "failed to get audit events for " + email
+			return nil, ops.AwsError(prefix, err)
+		}
+
+		for _, item := range output.Items {
+			var e *AuditEvent
+			if e, err = parseAuditEvent(item); err != nil {
+				return nil, err
+			}
+			events = append(events, e)
+		}
+	}
+	return
+}
+
+// DeleteAuditEvents scans the entire table for the audit event records for
+// email and deletes each one.
+//
+// Like GetAuditEvents, this requires a full table scan. This is OK, since it's
+// only used to erase an address's data upon request.
+func (db *DynamoDb) DeleteAuditEvents(
+	ctx context.Context, email string,
+) error {
+	return db.deleteKeysWithPrefix(
+		ctx,
+		db.auditKeyPrefix(email),
+		"failed to delete audit events for "+email,
+	)
+}
+
+// scanKeysWithPrefix scans the entire table for records whose primary keys
+// begin with keyPrefix and passes each one to process.
+//
+// It stops at the first error from process and returns it as is.
+func (db *DynamoDb) scanKeysWithPrefix(
+	ctx context.Context,
+	keyPrefix, errPrefix string,
+	process func(item dbAttributes) error,
+) (err error) {
+	input := &dynamodb.ScanInput{
+		TableName:                aws.String(db.TableName),
+		FilterExpression:         aws.String("begins_with(#email, :prefix)"),
+		ExpressionAttributeNames: map[string]string{"#email": "email"},
+		ExpressionAttributeValues: dbAttributes{
+			":prefix": &dbString{Value: keyPrefix},
+		},
+	}
+	paginator := dynamodb.NewScanPaginator(db.Client, input)
+
+	for paginator.HasMorePages() {
+		var output *dynamodb.ScanOutput
+
+		if output, err = paginator.NextPage(ctx); err != nil {
+			return ops.AwsError(errPrefix, err)
+		}
+
+		for _, item := range output.Items {
+			if err = process(item); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// deleteKeysWithPrefix scans the entire table for records whose primary keys
+// begin with keyPrefix and deletes each one.
+func (db *DynamoDb) deleteKeysWithPrefix(
+	ctx context.Context, keyPrefix, errPrefix string,
+) (err error) {
+	input := &dynamodb.ScanInput{
+		TableName:                aws.String(db.TableName),
+		FilterExpression:         aws.String("begins_with(#email, :prefix)"),
+		ProjectionExpression:     aws.String("#email"),
+		ExpressionAttributeNames: map[string]string{"#email": "email"},
+		ExpressionAttributeValues: dbAttributes{
+			":prefix": &dbString{Value: keyPrefix},
+		},
+	}
+	paginator := dynamodb.NewScanPaginator(db.Client, input)
+
+	for paginator.HasMorePages() {
+		var output *dynamodb.ScanOutput
+
+		if output, err = paginator.NextPage(ctx); err != nil {
+			return ops.AwsError(errPrefix, err)
+		}
+
+		for _, item := range output.Items {
+			input := &dynamodb.DeleteItemInput{
+				Key: dbAttributes{
+					DynamoDbPrimaryKey: item[DynamoDbPrimaryKey],
+				},
+				TableName: aws.String(db.TableName),
+			}
+			if _, err = db.Client.DeleteItem(ctx, input); err != nil {
+				return ops.AwsError(errPrefix, err)
+			}
+		}
+	}
+	return
+}
+
+// Tombstone records also live in the subscribers table, for the same reasons as
+// checkpoint records. Each address has at most one, so its key contains only
+// the address. Like subscriber records, they can't store an address containing
+// "#", since its key could match another list's tombstone key.
+const tombstoneKeyPrefix = "tombstone#"
+
+func (db *DynamoDb) tombstoneKey(email string) dbAttributes {
+	return db.key(tombstoneKeyPrefix + email)
+}
+
+func parseTombstone(attrs dbAttributes) (tombstone *Tombstone, err error) {
+	p := dbParser{attrs}
+	ts := &Tombstone{}
+	var reason string
+	errs := make([]error, 0, 3)
+	addErr := func(e error) {
+		errs = append(errs, e)
+	}
+
+	if ts.Email, err = p.GetString("tombstoneEmail"); err != nil {
+		addErr(err)
+	}
+	if reason, err = p.GetString("reason"); err != nil {
+		addErr(err)
+	}
+	ts.Reason = TombstoneReason(reason)
+	if ts.Timestamp, err = p.GetTime("timestamp"); err != nil {
+		addErr(err)
+	}
+
+	if err = errors.Join(errs...); err != nil {
+		err = errors.New("failed to parse tombstone: " + err.Error())
+	} else {
+		tombstone = ts
+	}
+	return
+}
+
+func (db *DynamoDb) newTombstoneRecord(tombstone *Tombstone) dbAttributes {
+	record := db.tombstoneKey(tombstone.Email)
+	record["tombstoneEmail"] = &dbString{Value: tombstone.Email}
+	record["reason"] = &dbString{Value: string(tombstone.Reason)}
+	record["timestamp"] = toDynamoDbTimestamp(tombstone.Timestamp)
+	return record
+}
+
+func (db *DynamoDb) GetTombstone(
+	ctx context.Context, email string,
+) (tombstone *Tombstone, err error) {
+	input := &dynamodb.GetItemInput{
+		Key: db.tombstoneKey(email), TableName: aws.String(db.TableName),
+	}
+	var output *dynamodb.GetItemOutput
+
+	if err = checkAddress(email); err != nil {
+		return
+	} else if output, err = db.Client.GetItem(ctx, input); err != nil {
+		err = ops.AwsError("failed to get tombstone for "+email, err)
+	} else if len(output.Item) == 0 {
+		err = ErrTombstoneNotFound
+	} else {
+		tombstone, err = parseTombstone(output.Item)
+	}
+	return
+}
+
+func (db *DynamoDb) PutTombstone(
+	ctx context.Context, tombstone *Tombstone,
+) (err error) {
+	input := &dynamodb.PutItemInput{
+		Item:      db.newTombstoneRecord(tombstone),
+		TableName: aws.String(db.TableName),
+	}
+
+	if err = checkAddress(tombstone.Email); err != nil {
+		return
+	} else if _, err = db.Client.PutItem(ctx, input); err != nil {
+		prefix := "failed to put tombstone for " + tombstone.Email
+		err = ops.AwsError(prefix, err)
+	}
+	return
+}
+
+func (db *DynamoDb) DeleteTombstone(
+	ctx context.Context, email string,
+) (err error) {
+	input := &dynamodb.DeleteItemInput{
+		Key: db.tombstoneKey(email), TableName: aws.String(db.TableName),
+	}
+
+	if err = checkAddress(email); err != nil {
+		return
+	} else if _, err = db.Client.DeleteItem(ctx, input); err != nil {
+		err = ops.AwsError("failed to delete tombstone for "+email, err)
+	}
+	return
+}
+
+// ListTombstones scans the entire table for tombstone records.
+//
+// Like ListCampaigns, this requires a full table scan. This is OK, since it's
+// only used for backing up the table via the command line interface.
+func (db *DynamoDb) ListTombstones(
+	ctx context.Context,
+) (tombstones []*Tombstone, err error) {
+	tombstones = make([]*Tombstone, 0, 10)
+	err = db.scanKeysWithPrefix(
+		ctx,
+		db.keyPrefix(tombstoneKeyPrefix),
+		"failed to list tombstones",
+		func(item dbAttributes) (err error) {
+			var ts *Tombstone
+			if ts, err = parseTombstone(item); err == nil {
+				tombstones = append(tombstones, ts)
+			}
+			return
+		},
+	)
+	if err != nil {
+		tombstones = nil
+	}
+	return
+}
+
+// Erasure records also live in the subscribers table, for the same reasons as
+// checkpoint records. The key contains only the salted hash of the erased
+// address, so the record contains no other attributes.
+const erasureKeyPrefix = "erased#"
+
+func (db *DynamoDb) erasureKey(hash string) dbAttributes {
+	return db.key(erasureKeyPrefix + hash)
+}
+
+func (db *DynamoDb) PutErasure(ctx context.Context, hash string) (err error) {
+	input := &dynamodb.PutItemInput{
+		Item: db.erasureKey(hash), TableName: aws.String(db.TableName),
+	}
+	if _, err = db.Client.PutItem(ctx, input); err != nil {
+		err = ops.AwsError("failed to put erasure record", err)
+	}
+	return
+}
+
+func (db *DynamoDb) IsErased(
+	ctx context.Context, hash string,
+) (erased bool, err error) {
+	input := &dynamodb.GetItemInput{
+		Key: db.erasureKey(hash), TableName: aws.String(db.TableName),
+	}
+	var output *dynamodb.GetItemOutput
+
+	if output, err = db.Client.GetItem(ctx, input); err != nil {
+		err = ops.AwsError("failed to get erasure record", err)
+	} else {
+		erased = len(output.Item) != 0
+	}
+	return
+}
+
+// ListErasures scans the entire table for erasure records.
+//
+// Like ListTombstones, this requires a full table scan. This is OK, since it's
+// only used for backing up the table via the command line interface.
+func (db *DynamoDb) ListErasures(
+	ctx context.Context,
+) (hashes []string, err error) {
+	prefix := db.keyPrefix(erasureKeyPrefix)
+	hashes = make([]string, 0, 10)
+	err = db.scanKeysWithPrefix(
+		ctx,
+		prefix,
+		"failed to list erasure records",
+		func(item dbAttributes) (err error) {
+			p := dbParser{item}
+			var key string
+			if key, err = p.GetString(DynamoDbPrimaryKey); err == nil {
+				hashes = append(hashes, strings.TrimPrefix(key, prefix))
+			}
+			return
+		},
+	)
+	if err != nil {
+		hashes = nil
+	}
+	return
+}
+
+// The welcome message record also lives in the subscribers table, for the same
+// reasons as checkpoint records. Each list has at most one, so its key contains
+// only the prefix. Like a scheduled message, the message itself lives in part
+// records. Every PutWelcomeMessage call stores the message under a new version
+// ID, so GetWelcomeMessage never reads a mix of old and new parts.
+const welcomeKeyPrefix = "welcome#"
+
+func (db *DynamoDb) welcomeKey() dbAttributes {
+	return db.key(welcomeKeyPrefix)
+}
+
+func welcomePartsOwner(version string) string {
+	return welcomeKeyPrefix + version
+}
+
+func parseWelcomeMessage(
+	attrs dbAttributes,
+) (version string, numParts int, err error) {
+	p := dbParser{attrs}
+	errs := make([]error, 0, 2)
+	addErr := func(e error) {
+		errs = append(errs, e)
+	}
+
+	if version, err = p.GetString("welcomeVersion"); err != nil {
+		addErr(err)
+	}
+	if numParts, err = p.GetInt("parts"); err != nil {
+		addErr(err)
+	}
+
+	if err = errors.Join(errs...); err != nil {
+		err = errors.New("failed to parse welcome message: " + err.Error())
+		version, numParts = "", 0
+	}
+	return
+}
+
+func (db *DynamoDb) newWelcomeMessageRecord(
+	version string, numParts int,
+) dbAttributes {
+	record := db.welcomeKey()
+	record["welcomeVersion"] = &dbString{Value: version}
+	record["parts"] = &dbNumber{Value: strconv.Itoa(numParts)}
+	return record
+}
+
+func (db *DynamoDb) GetWelcomeMessage(
+	ctx context.Context,
+) (msg *email.Message, err error) {
+	input := &dynamodb.GetItemInput{
+		Key:            db.welcomeKey(),
+		TableName:      aws.String(db.TableName),
+		ConsistentRead: aws.Bool(true),
+	}
+	var output *dynamodb.GetItemOutput
+	var version string
+	var numParts int
+	var msgJson []byte
+
+	if output, err = db.Client.GetItem(ctx, input); err != nil {
+		return nil, ops.AwsError("failed to get welcome message", err)
+	} else if len(output.Item) == 0 {
+		return nil, ErrWelcomeMessageNotFound
+	} else if version, numParts, err = parseWelcomeMessage(
+		output.Item,
+	); err != nil {
+		return
+	}
+
+	owner := welcomePartsOwner(version)
+	msg = &email.Message{}
+
+	if msgJson, err = db.getParts(ctx, owner, numParts); err == nil {
+		err = json.Unmarshal(msgJson, msg)
+	}
+	if err != nil {
+		return nil, ops.AwsError("failed to get welcome message", err)
+	}
+	return
+}
+
+// PutWelcomeMessage stores the message in part records before replacing the
+// welcome message record, then deletes the previous message's part records.
+//
+// It checks the size of every record before storing any of them, and returns
+// an error wrapping ErrItemTooLarge if any is too large.
+func (db *DynamoDb) PutWelcomeMessage(
+	ctx context.Context, msg *email.Message,
+) (err error) {
+	const errPrefix = "failed to put welcome message"
+	// Marshaling can't fail, since email.Message contains only strings.
+	msgJson, _ := json.Marshal(msg)
+	version := uuid.NewString()
+	parts := db.newPartRecords(welcomePartsOwner(version), msgJson)
+	record := db.newWelcomeMessageRecord(version, len(parts))
+	var output *dynamodb.PutItemOutput
+
+	if err = checkItemSizes(append(parts, record)...); err != nil {
+		return fmt.Errorf("%s: %w", errPrefix, err)
+	} else if err = db.putParts(ctx, parts); err != nil {
+		return ops.AwsError(errPrefix, err)
+	}
+
+	input := &dynamodb.PutItemInput{
+		Item:         record,
+		TableName:    aws.String(db.TableName),
+		ReturnValues: dbtypes.ReturnValueAllOld,
+	}
+	if output, err = db.Client.PutItem(ctx, input); err != nil {
+		return ops.AwsError(errPrefix, err)
+	}
+	return db.deleteWelcomeParts(ctx, output.Attributes, errPrefix)
+}
+
+// DeleteWelcomeMessage deletes the welcome message record before its part
+// records, so GetWelcomeMessage never finds a record with missing parts.
+func (db *DynamoDb) DeleteWelcomeMessage(ctx context.Context) (err error) {
+	const errPrefix = "failed to delete welcome message"
+	input := &dynamodb.DeleteItemInput{
+		Key:          db.welcomeKey(),
+		TableName:    aws.String(db.TableName),
+		ReturnValues: dbtypes.ReturnValueAllOld,
+	}
+	var output *dynamodb.DeleteItemOutput
+
+	if output, err = db.Client.DeleteItem(ctx, input); err != nil {
+		return ops.AwsError(errPrefix, err)
+	}
+	return db.deleteWelcomeParts(ctx, output.Attributes, errPrefix)
+}
+
+// deleteWelcomeParts deletes the part records belonging to a replaced or
+// deleted welcome message record, if there was one.
+func (db *DynamoDb) deleteWelcomeParts(
+	ctx context.Context, attrs dbAttributes, errPrefix string,
+) (err error) {
+	var version string
+	var numParts int
+
+	if len(attrs) == 0 {
+		return
+	} else if version, numParts, err = parseWelcomeMessage(attrs); err != nil {
+		return
+	}
+
+	owner := welcomePartsOwner(version)
+	if err = db.deleteParts(ctx, owner, numParts); err != nil {
+		err = ops.AwsError(errPrefix, err)
+	}
+	return
+}
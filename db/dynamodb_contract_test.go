@@ -6,12 +6,12 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"sort"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/google/uuid"
 	"github.com/mbland/elistman/ops"
 	"github.com/mbland/elistman/testutils"
 	"gotest.tools/assert"
@@ -134,19 +134,6 @@ func localDbConfig() (*aws.Config, *testutils.BaseEndpoint, error) {
 	return dbConfig, baseEndpoint, nil
 }
 
-func newTestSubscriber() *Subscriber {
-	return NewSubscriber(testutils.RandomString(8) + "@example.com")
-}
-
-func sorted(subs []*Subscriber) (r []*Subscriber) {
-	r = make([]*Subscriber, len(subs))
-	copy(r, subs)
-	sort.Slice(r, func(i, j int) bool {
-		return r[i].Email < r[j].Email
-	})
-	return
-}
-
 func TestDynamoDb(t *testing.T) {
 	testDb, teardown, err := setupDynamoDb()
 
@@ -160,6 +147,15 @@ func TestDynamoDb(t *testing.T) {
 	var badDb DynamoDb = *testDb
 	badDb.TableName = testDb.TableName + "-nonexistent"
 
+	t.Run("Contract", func(t *testing.T) {
+		var scanDelay time.Duration
+
+		if useAwsDb {
+			scanDelay = 3 * time.Second
+		}
+		testDatabaseContract(t, testDb, scanDelay)
+	})
+
 	// Note that the success cases for CreateTable and DeleteTable are
 	// confirmed by setupDynamoDb() and teardown() above.
 	t.Run("CreateTableFailsIfTableExists", func(t *testing.T) {
@@ -178,27 +174,6 @@ func TestDynamoDb(t *testing.T) {
 		assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
 	})
 
-	t.Run("PutGetAndDeleteSucceed", func(t *testing.T) {
-		subscriber := newTestSubscriber()
-
-		putErr := testDb.Put(ctx, subscriber)
-		retrievedSubscriber, getErr := testDb.Get(ctx, subscriber.Email)
-		deleteErr := testDb.Delete(ctx, subscriber.Email)
-		_, getAfterDeleteErr := testDb.Get(ctx, subscriber.Email)
-		deleteAfterDeleteErr := testDb.Delete(ctx, subscriber.Email)
-
-		assert.NilError(t, putErr)
-		assert.NilError(t, getErr)
-		assert.NilError(t, deleteErr)
-		assert.DeepEqual(t, subscriber, retrievedSubscriber)
-		assert.Assert(
-			t, testutils.ErrorIs(getAfterDeleteErr, ErrSubscriberNotFound),
-		)
-		// Believe it or not, deleting a nonexistent record doesn't raise any
-		// kind of an error.
-		assert.NilError(t, deleteAfterDeleteErr)
-	})
-
 	t.Run("UpdateTimeToLive", func(t *testing.T) {
 		t.Run("Succeeds", func(t *testing.T) {
 			ttlSpec, err := testDb.updateTimeToLive(ctx)
@@ -221,85 +196,255 @@ func TestDynamoDb(t *testing.T) {
 		})
 	})
 
-	t.Run("GetFails", func(t *testing.T) {
-		t.Run("IfSubscriberDoesNotExist", func(t *testing.T) {
-			subscriber := newTestSubscriber()
+	t.Run("GetFailsIfTableDoesNotExist", func(t *testing.T) {
+		subscriber := newTestSubscriber()
+
+		retrieved, err := badDb.Get(ctx, subscriber.Email)
+
+		assert.Assert(t, is.Nil(retrieved))
+		expected := "failed to get " + subscriber.Email + ": "
+		assert.ErrorContains(t, err, expected)
+		assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+	})
+
+	t.Run("PutFailsIfTableDoesNotExist", func(t *testing.T) {
+		subscriber := newTestSubscriber()
+
+		err := badDb.Put(ctx, subscriber)
+
+		assert.ErrorContains(t, err, "failed to put "+subscriber.Email+": ")
+		assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+	})
+
+	t.Run("DeleteFailsIfTableDoesNotExist", func(t *testing.T) {
+		subscriber := newTestSubscriber()
+
+		err := badDb.Delete(ctx, subscriber.Email)
+
+		expected := "failed to delete " + subscriber.Email + ": "
+		assert.ErrorContains(t, err, expected)
+		assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+	})
+
+	t.Run("MarkReceivedFailsIfTableDoesNotExist", func(t *testing.T) {
+		subscriber := newTestSubscriber()
+
+		err := badDb.MarkReceived(ctx, subscriber.Email, "campaign-0")
+
+		expected := "failed to mark campaign-0 as received by " +
+			subscriber.Email + ": "
+		assert.ErrorContains(t, err, expected)
+		assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+	})
+
+	t.Run("HasReceivedFailsIfTableDoesNotExist", func(t *testing.T) {
+		subscriber := newTestSubscriber()
+
+		_, err := badDb.HasReceived(ctx, subscriber.Email, "campaign-0")
+
+		expected := "failed to check if " + subscriber.Email +
+			" received campaign-0: "
+		assert.ErrorContains(t, err, expected)
+		assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+	})
+
+	t.Run("DeleteReceiptsFailsIfTableDoesNotExist", func(t *testing.T) {
+		subscriber := newTestSubscriber()
+
+		err := badDb.DeleteReceipts(ctx, subscriber.Email)
+
+		expected := "failed to delete receipts for " + subscriber.Email + ": "
+		assert.ErrorContains(t, err, expected)
+		assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+	})
+
+	t.Run("RotateUidFailsIfTableDoesNotExist", func(t *testing.T) {
+		subscriber := newTestSubscriber()
+
+		err := badDb.RotateUid(ctx, subscriber.Email, uuid.New(), time.Now())
+
+		expected := "failed to rotate UID for " + subscriber.Email + ": "
+		assert.ErrorContains(t, err, expected)
+		assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+	})
+
+	t.Run("RecordVerifySentFailsIfTableDoesNotExist", func(t *testing.T) {
+		subscriber := newTestSubscriber()
+
+		err := badDb.RecordVerifySent(ctx, subscriber, 0)
+
+		expected := "failed to record verification email to " +
+			subscriber.Email + ": "
+		assert.ErrorContains(t, err, expected)
+		assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+	})
+
+	t.Run("Checkpoints", func(t *testing.T) {
+		t.Run("GetFailsIfTableDoesNotExist", func(t *testing.T) {
+			cp := newTestCheckpoint()
 
-			retrieved, err := testDb.Get(ctx, subscriber.Email)
+			retrieved, err := badDb.GetCheckpoint(ctx, cp.CampaignId)
 
 			assert.Assert(t, is.Nil(retrieved))
-			assert.Assert(t, testutils.ErrorIs(err, ErrSubscriberNotFound))
+			expected := "failed to get checkpoint " + cp.CampaignId + ": "
+			assert.ErrorContains(t, err, expected)
 			assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
 		})
 
-		t.Run("IfTableDoesNotExist", func(t *testing.T) {
-			subscriber := newTestSubscriber()
+		t.Run("PutFailsIfTableDoesNotExist", func(t *testing.T) {
+			cp := newTestCheckpoint()
+
+			err := badDb.PutCheckpoint(ctx, cp)
+
+			expected := "failed to put checkpoint " + cp.CampaignId + ": "
+			assert.ErrorContains(t, err, expected)
+			assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+		})
+	})
 
-			retrieved, err := badDb.Get(ctx, subscriber.Email)
+	t.Run("Campaigns", func(t *testing.T) {
+		t.Run("GetFailsIfTableDoesNotExist", func(t *testing.T) {
+			c := newTestCampaign()
+
+			retrieved, err := badDb.GetCampaign(ctx, c.Id)
 
 			assert.Assert(t, is.Nil(retrieved))
-			expected := "failed to get " + subscriber.Email + ": "
+			expected := "failed to get campaign " + c.Id + ": "
+			assert.ErrorContains(t, err, expected)
+			assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+		})
+
+		t.Run("PutFailsIfTableDoesNotExist", func(t *testing.T) {
+			c := newTestCampaign()
+
+			err := badDb.PutCampaign(ctx, c)
+
+			expected := "failed to put campaign " + c.Id + ": "
 			assert.ErrorContains(t, err, expected)
 			assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
 		})
+
+		t.Run("ListFailsIfTableDoesNotExist", func(t *testing.T) {
+			listed, err := badDb.ListCampaigns(ctx)
+
+			assert.Equal(t, 0, len(listed))
+			assert.ErrorContains(t, err, "failed to list campaigns: ")
+			assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+		})
 	})
 
-	t.Run("PutFails", func(t *testing.T) {
-		t.Run("IfTableDoesNotExist", func(t *testing.T) {
-			subscriber := newTestSubscriber()
+	t.Run("ScheduledMessages", func(t *testing.T) {
+		now := time.Now().Truncate(time.Second)
+
+		t.Run("PutFailsIfTableDoesNotExist", func(t *testing.T) {
+			scheduled := newTestScheduledMessage(now)
+
+			err := badDb.PutScheduledMessage(ctx, scheduled)
+
+			expected := "failed to put scheduled message " + scheduled.Id
+			assert.ErrorContains(t, err, expected)
+			assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+		})
+
+		t.Run("GetDueFailsIfTableDoesNotExist", func(t *testing.T) {
+			dueMsgs, err := badDb.GetDueMessages(ctx, now)
+
+			assert.Equal(t, 0, len(dueMsgs))
+			assert.ErrorContains(t, err, "failed to get due messages: ")
+			assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+		})
+
+		t.Run("ClaimFailsIfTableDoesNotExist", func(t *testing.T) {
+			err := badDb.ClaimScheduledMessage(
+				ctx, "scheduled-id", now, now.Add(time.Minute),
+			)
+
+			expected := "failed to claim scheduled message scheduled-id: "
+			assert.ErrorContains(t, err, expected)
+			assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+		})
 
-			err := badDb.Put(ctx, subscriber)
+		t.Run("DeleteFailsIfTableDoesNotExist", func(t *testing.T) {
+			err := badDb.DeleteScheduledMessage(ctx, "scheduled-id")
 
-			assert.ErrorContains(t, err, "failed to put "+subscriber.Email+": ")
+			expected := "failed to delete scheduled message scheduled-id: "
+			assert.ErrorContains(t, err, expected)
 			assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
 		})
 	})
 
-	t.Run("DeleteFails", func(t *testing.T) {
-		t.Run("IfTableDoesNotExist", func(t *testing.T) {
-			subscriber := newTestSubscriber()
+	t.Run("AuditEvents", func(t *testing.T) {
+		t.Run("PutFailsIfTableDoesNotExist", func(t *testing.T) {
+			e := newTestAuditEvent("foo@test.com", AuditImport, time.Now())
 
-			err := badDb.Delete(ctx, subscriber.Email)
+			err := badDb.PutAuditEvent(ctx, e)
 
-			expected := "failed to delete " + subscriber.Email + ": "
+			expected := "failed to put audit event for foo@test.com: "
+			assert.ErrorContains(t, err, expected)
+			assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+		})
+
+		t.Run("GetFailsIfTableDoesNotExist", func(t *testing.T) {
+			retrieved, err := badDb.GetAuditEvents(ctx, "foo@test.com")
+
+			assert.Equal(t, 0, len(retrieved))
+			expected := "failed to get audit events for foo@test.com: "
+			assert.ErrorContains(t, err, expected)
+			assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+		})
+
+		t.Run("DeleteFailsIfTableDoesNotExist", func(t *testing.T) {
+			err := badDb.DeleteAuditEvents(ctx, "foo@test.com")
+
+			expected := "failed to delete audit events for foo@test.com: "
 			assert.ErrorContains(t, err, expected)
 			assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
 		})
 	})
 
-	t.Run("WithTestSubscribers", func(t *testing.T) {
-		emails := make([]string, 0, len(TestSubscribers))
+	t.Run("Erasures", func(t *testing.T) {
+		t.Run("PutFailsIfTableDoesNotExist", func(t *testing.T) {
+			err := badDb.PutErasure(ctx, "hash")
 
-		for _, sub := range TestSubscribers {
-			if err := testDb.Put(ctx, sub); err != nil {
-				t.Fatalf("failed to put subscriber: %s", sub)
-			}
-			emails = append(emails, sub.Email)
-		}
+			assert.ErrorContains(t, err, "failed to put erasure record: ")
+			assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+		})
 
-		if useAwsDb {
-			time.Sleep(time.Duration(3 * time.Second))
-		}
+		t.Run("IsErasedFailsIfTableDoesNotExist", func(t *testing.T) {
+			erased, err := badDb.IsErased(ctx, "hash")
 
-		defer func() {
-			for _, email := range emails {
-				if err := testDb.Delete(ctx, email); err != nil {
-					t.Fatalf("failed to delete subscriber: %s", email)
-				}
-			}
-		}()
+			assert.Assert(t, !erased)
+			assert.ErrorContains(t, err, "failed to get erasure record: ")
+			assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+		})
+	})
 
-		t.Run("ProcessSubscribersInStateSucceeds", func(t *testing.T) {
-			subs := &[]*Subscriber{}
-			f := SubscriberFunc(func(s *Subscriber) bool {
-				*subs = append(*subs, s)
-				return true
-			})
+	t.Run("Tombstones", func(t *testing.T) {
+		t.Run("GetFailsIfTableDoesNotExist", func(t *testing.T) {
+			retrieved, err := badDb.GetTombstone(ctx, "foo@test.com")
 
-			err := testDb.ProcessSubscribers(ctx, SubscriberVerified, f)
+			assert.Assert(t, is.Nil(retrieved))
+			expected := "failed to get tombstone for foo@test.com: "
+			assert.ErrorContains(t, err, expected)
+			assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+		})
 
-			assert.NilError(t, err)
-			assert.DeepEqual(t, sorted(TestVerifiedSubscribers), sorted(*subs))
+		t.Run("PutFailsIfTableDoesNotExist", func(t *testing.T) {
+			ts := newTestTombstone()
+
+			err := badDb.PutTombstone(ctx, ts)
+
+			expected := "failed to put tombstone for " + ts.Email + ": "
+			assert.ErrorContains(t, err, expected)
+			assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+		})
+
+		t.Run("DeleteFailsIfTableDoesNotExist", func(t *testing.T) {
+			err := badDb.DeleteTombstone(ctx, "foo@test.com")
+
+			expected := "failed to delete tombstone for foo@test.com: "
+			assert.ErrorContains(t, err, expected)
+			assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
 		})
 	})
 }
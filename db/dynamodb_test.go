@@ -6,6 +6,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
@@ -25,7 +30,7 @@ func checkIsExternalError(t *testing.T, err error) {
 
 func TestDynamodDbMethodsReturnExternalErrorsAsAppropriate(t *testing.T) {
 	client := &TestDynamoDbClient{}
-	dyndb := &DynamoDb{client, "subscribers-table"}
+	dyndb := &DynamoDb{Client: client, TableName: "subscribers-table"}
 	ctx := context.Background()
 
 	// All these methods are tested in dynamodb_contract_test, and none of those
@@ -53,6 +58,129 @@ func TestDynamodDbMethodsReturnExternalErrorsAsAppropriate(t *testing.T) {
 
 	err = dyndb.Delete(ctx, testdata.TestEmail)
 	checkIsExternalError(t, err)
+
+	err = dyndb.MarkReceived(ctx, testdata.TestEmail, "campaign-id")
+	checkIsExternalError(t, err)
+
+	_, err = dyndb.HasReceived(ctx, testdata.TestEmail, "campaign-id")
+	checkIsExternalError(t, err)
+
+	err = dyndb.DeleteReceipts(ctx, testdata.TestEmail)
+	checkIsExternalError(t, err)
+
+	err = dyndb.RotateUid(
+		ctx, testdata.TestEmail, testdata.TestUid, testdata.TestTimestamp,
+	)
+	checkIsExternalError(t, err)
+
+	err = dyndb.RecordVerifySent(ctx, &Subscriber{}, 0)
+	checkIsExternalError(t, err)
+
+	_, err = dyndb.GetCheckpoint(ctx, "campaign-id")
+	checkIsExternalError(t, err)
+
+	err = dyndb.PutCheckpoint(ctx, &SendCheckpoint{})
+	checkIsExternalError(t, err)
+
+	_, err = dyndb.GetCampaign(ctx, "campaign-id")
+	checkIsExternalError(t, err)
+
+	err = dyndb.PutCampaign(ctx, &Campaign{})
+	checkIsExternalError(t, err)
+
+	_, err = dyndb.ListCampaigns(ctx)
+	checkIsExternalError(t, err)
+
+	err = dyndb.PutScheduledMessage(ctx, &ScheduledMessage{})
+	checkIsExternalError(t, err)
+
+	_, err = dyndb.GetDueMessages(ctx, testdata.TestTimestamp)
+	checkIsExternalError(t, err)
+
+	err = dyndb.ClaimScheduledMessage(
+		ctx, "scheduled-id", testdata.TestTimestamp, testdata.TestTimestamp,
+	)
+	checkIsExternalError(t, err)
+
+	err = dyndb.ReleaseScheduledMessage(
+		ctx, "scheduled-id", testdata.TestTimestamp,
+	)
+	checkIsExternalError(t, err)
+
+	err = dyndb.DeleteScheduledMessage(ctx, "scheduled-id")
+	checkIsExternalError(t, err)
+
+	err = dyndb.PutAuditEvent(ctx, &AuditEvent{})
+	checkIsExternalError(t, err)
+
+	_, err = dyndb.GetAuditEvents(ctx, testdata.TestEmail)
+	checkIsExternalError(t, err)
+
+	err = dyndb.DeleteAuditEvents(ctx, testdata.TestEmail)
+	checkIsExternalError(t, err)
+
+	_, err = dyndb.GetTombstone(ctx, testdata.TestEmail)
+	checkIsExternalError(t, err)
+
+	err = dyndb.PutTombstone(ctx, &Tombstone{})
+	checkIsExternalError(t, err)
+
+	err = dyndb.DeleteTombstone(ctx, testdata.TestEmail)
+	checkIsExternalError(t, err)
+
+	_, err = dyndb.ListTombstones(ctx)
+	checkIsExternalError(t, err)
+
+	err = dyndb.PutErasure(ctx, "hash")
+	checkIsExternalError(t, err)
+
+	_, err = dyndb.IsErased(ctx, "hash")
+	checkIsExternalError(t, err)
+
+	_, err = dyndb.ListErasures(ctx)
+	checkIsExternalError(t, err)
+}
+
+// dynamoDbAdminMethods are the DynamoDbClient methods that only the command
+// line interface calls, using the operator's own credentials. The Lambda
+// function's DynamoDbPolicy doesn't need to grant them.
+var dynamoDbAdminMethods = []string{
+	"CreateTable", "DescribeTable", "UpdateTimeToLive", "UpdateTable",
+	"DeleteTable",
+}
+
+// templateDynamoDbActions returns the actions that the DynamoDbPolicy in
+// template.yml grants the Lambda function.
+func templateDynamoDbActions(t *testing.T) (actions []string) {
+	t.Helper()
+	template, err := os.ReadFile("../template.yml")
+	assert.NilError(t, err)
+
+	_, policy, found := strings.Cut(string(template), "Sid: DynamoDbPolicy")
+	assert.Assert(t, found, "DynamoDbPolicy not found in template.yml")
+	policy, _, _ = strings.Cut(policy, "Resource:")
+	actionPattern := regexp.MustCompile(`"dynamoDb:(\w+)"`)
+
+	for _, match := range actionPattern.FindAllStringSubmatch(policy, -1) {
+		actions = append(actions, match[1])
+	}
+	slices.Sort(actions)
+	return
+}
+
+func TestTemplateGrantsEveryNonAdminDynamoDbClientMethod(t *testing.T) {
+	clientType := reflect.TypeFor[DynamoDbClient]()
+	expected := make([]string, 0, clientType.NumMethod())
+
+	for i := range clientType.NumMethod() {
+		name := clientType.Method(i).Name
+		if !slices.Contains(dynamoDbAdminMethods, name) {
+			expected = append(expected, name)
+		}
+	}
+	slices.Sort(expected)
+
+	assert.DeepEqual(t, expected, templateDynamoDbActions(t))
 }
 
 func TestGetAttribute(t *testing.T) {
@@ -120,6 +248,148 @@ func TestParseSubscriber(t *testing.T) {
 		})
 	})
 
+	t.Run("SucceedsWithList", func(t *testing.T) {
+		sub := *TestVerifiedSubscribers[0]
+		sub.List = "updates"
+		record := newSubscriberRecord(&sub)
+
+		subscriber, err := parseSubscriber(record)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, &sub, subscriber)
+		expectedKey := "list#updates#" + sub.Email
+		assert.Equal(t, expectedKey, record["email"].(*dbString).Value)
+	})
+
+	t.Run("SucceedsWithTags", func(t *testing.T) {
+		sub := *TestVerifiedSubscribers[0]
+		sub.Tags = []string{"essays", "releases"}
+		record := newSubscriberRecord(&sub)
+		// DynamoDB doesn't preserve the order of set members.
+		record["tags"] = &dbStringSet{Value: []string{"releases", "essays"}}
+
+		subscriber, err := parseSubscriber(record)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, &sub, subscriber)
+	})
+
+	t.Run("SucceedsWithFirstNameAndAttributes", func(t *testing.T) {
+		sub := *TestVerifiedSubscribers[0]
+		sub.FirstName = "Mike"
+		sub.Attributes = map[string]string{"City": "Chicago"}
+
+		subscriber, err := parseSubscriber(newSubscriberRecord(&sub))
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, &sub, subscriber)
+	})
+
+	t.Run("SucceedsWithSignupMetadata", func(t *testing.T) {
+		sub := *TestPendingSubscribers[0]
+		sub.Signup = &SignupMetadata{
+			Source:      "footer",
+			UtmSource:   "newsletter",
+			UtmMedium:   "email",
+			UtmCampaign: "launch",
+			UtmTerm:     "go",
+			UtmContent:  "button",
+			Referrer:    "https://mike-bland.com/",
+			SourceIp:    "192.0.2.1",
+			UserAgent:   "Mozilla/5.0",
+		}
+
+		subscriber, err := parseSubscriber(newSubscriberRecord(&sub))
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, &sub, subscriber)
+	})
+
+	t.Run("OmitsEmptySignupMetadata", func(t *testing.T) {
+		sub := *TestPendingSubscribers[0]
+		sub.Signup = &SignupMetadata{SourceIp: "192.0.2.1"}
+		record := newSubscriberRecord(&sub)
+
+		signup := record["signup"].(*dbMap).Value
+		assert.Equal(t, 1, len(signup))
+
+		sub.Signup = &SignupMetadata{}
+		_, ok := newSubscriberRecord(&sub)["signup"]
+		assert.Assert(t, !ok)
+	})
+
+	t.Run("IgnoresUnknownSignupMetadata", func(t *testing.T) {
+		sub := *TestPendingSubscribers[0]
+		sub.Signup = &SignupMetadata{Source: "footer"}
+		record := newSubscriberRecord(&sub)
+		signup := record["signup"].(*dbMap).Value
+		signup["newField"] = &dbString{Value: "from a later version"}
+
+		subscriber, err := parseSubscriber(record)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, &sub, subscriber)
+	})
+
+	t.Run("SucceedsWithVerifySentCountAndTime", func(t *testing.T) {
+		sub := *TestPendingSubscribers[0]
+		sub.VerifySentCount = 2
+		sub.VerifySentAt = testdata.TestTimestamp
+
+		subscriber, err := parseSubscriber(newSubscriberRecord(&sub))
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, &sub, subscriber)
+	})
+
+	t.Run("SucceedsWithPreviousUid", func(t *testing.T) {
+		sub := *TestVerifiedSubscribers[0]
+		sub.PreviousUid = testdata.TestUid
+		sub.PreviousUidExpires = testdata.TestTimestamp
+
+		subscriber, err := parseSubscriber(newSubscriberRecord(&sub))
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, &sub, subscriber)
+	})
+
+	t.Run("ErrorsIfVerifySentCountNotANumber", func(t *testing.T) {
+		attrs := newSubscriberRecord(TestPendingSubscribers[0])
+		attrs["verifySentCount"] = &dbString{Value: "2"}
+
+		subscriber, err := parseSubscriber(attrs)
+
+		assert.Check(t, is.Nil(subscriber))
+		const expected = "attribute 'verifySentCount' is of type "
+		assert.ErrorContains(t, err, expected)
+	})
+
+	t.Run("ErrorsIfAttributesContainNonStringValue", func(t *testing.T) {
+		attrs := newSubscriberRecord(TestVerifiedSubscribers[0])
+		attrs["attributes"] = &dbMap{
+			Value: dbAttributes{"Age": &dbNumber{Value: "27"}},
+		}
+
+		subscriber, err := parseSubscriber(attrs)
+
+		assert.Check(t, is.Nil(subscriber))
+		assert.ErrorContains(t, err, "failed to parse 'attributes' from: ")
+		assert.ErrorContains(t, err, "'Age' is of type ")
+	})
+
+	t.Run("ErrorsIfSignupContainsNonStringValue", func(t *testing.T) {
+		attrs := newSubscriberRecord(TestPendingSubscribers[0])
+		attrs["signup"] = &dbMap{
+			Value: dbAttributes{"source": &dbNumber{Value: "27"}},
+		}
+
+		subscriber, err := parseSubscriber(attrs)
+
+		assert.Check(t, is.Nil(subscriber))
+		assert.ErrorContains(t, err, "failed to parse 'signup' from: ")
+		assert.ErrorContains(t, err, "'source' is of type ")
+	})
+
 	t.Run("ErrorsIfGettingAttributesFail", func(t *testing.T) {
 		subscriber, err := parseSubscriber(dbAttributes{})
 
@@ -255,9 +525,545 @@ func TestCreateSubscribersTable(t *testing.T) {
 	})
 }
 
+func TestMigrateSubscribersTable(t *testing.T) {
+	ctx := context.Background()
+	setup := func(
+		existing ...string,
+	) (dyndb *DynamoDb, client *TestDynamoDbClient) {
+		client = NewTestDynamoDbClient()
+		dyndb = &DynamoDb{Client: client, TableName: "subscribers"}
+		table := client.DescTableOutput.Table
+
+		for _, name := range existing {
+			table.GlobalSecondaryIndexes = append(
+				table.GlobalSecondaryIndexes,
+				types.GlobalSecondaryIndexDescription{
+					IndexName:   aws.String(name),
+					IndexStatus: types.IndexStatusActive,
+				},
+			)
+		}
+		return
+	}
+
+	const migrateErrPrefix = "failed to migrate " +
+		"subscribers table \"subscribers\": "
+
+	t.Run("CreatesMissingIndexes", func(t *testing.T) {
+		dyndb, client := setup(
+			DynamoDbPendingIndexName, DynamoDbVerifiedIndexName,
+		)
+
+		created, err := dyndb.MigrateSubscribersTable(ctx, time.Nanosecond)
+
+		assert.NilError(t, err)
+		expected := []string{DynamoDbScheduledIndexName, DynamoDbTagIndexName}
+		assert.DeepEqual(t, expected, created)
+		assert.Equal(t, 2, len(client.UpdateTableInputs))
+
+		input := client.UpdateTableInputs[1]
+		tu.AssertAwsStringEqual(t, dyndb.TableName, input.TableName)
+		attrNames := []string{}
+		for _, def := range input.AttributeDefinitions {
+			attrNames = append(attrNames, aws.ToString(def.AttributeName))
+		}
+		expectedAttrs := []string{
+			DynamoDbTagIndexPartitionKey, DynamoDbTagIndexSortKey,
+		}
+		assert.DeepEqual(t, expectedAttrs, attrNames)
+		assert.Equal(t, 1, len(input.GlobalSecondaryIndexUpdates))
+		create := input.GlobalSecondaryIndexUpdates[0].Create
+		tu.AssertAwsStringEqual(t, DynamoDbTagIndexName, create.IndexName)
+		assert.Equal(
+			t, types.ProjectionTypeKeysOnly, create.Projection.ProjectionType,
+		)
+	})
+
+	t.Run("DoesNothingIfUpToDate", func(t *testing.T) {
+		dyndb, client := setup(
+			DynamoDbPendingIndexName,
+			DynamoDbVerifiedIndexName,
+			DynamoDbScheduledIndexName,
+			DynamoDbTagIndexName,
+		)
+
+		created, err := dyndb.MigrateSubscribersTable(ctx, time.Nanosecond)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, []string{}, created)
+		assert.Equal(t, 0, len(client.UpdateTableInputs))
+	})
+
+	t.Run("FailsIfIndexIsStillCreating", func(t *testing.T) {
+		dyndb, client := setup(
+			DynamoDbPendingIndexName, DynamoDbVerifiedIndexName,
+		)
+		client.UpdateTableIndexStatus = types.IndexStatusCreating
+
+		created, err := dyndb.MigrateSubscribersTable(ctx, time.Nanosecond)
+
+		const errFmt = migrateErrPrefix + "index \"%s\" still %s after %s"
+		expected := fmt.Sprintf(
+			errFmt,
+			DynamoDbScheduledIndexName,
+			types.IndexStatusCreating,
+			time.Nanosecond,
+		)
+		assert.Error(t, err, expected)
+		assert.DeepEqual(t, []string{DynamoDbScheduledIndexName}, created)
+		assert.Equal(t, 1, len(client.UpdateTableInputs))
+	})
+
+	t.Run("FailsIfDescribeTableFails", func(t *testing.T) {
+		dyndb, client := setup()
+		client.SetDescribeTableError("describe table failed")
+
+		_, err := dyndb.MigrateSubscribersTable(ctx, time.Nanosecond)
+
+		assert.Assert(t, tu.ErrorIs(err, ops.ErrExternal))
+		assert.ErrorContains(
+			t, err, migrateErrPrefix+"failed to describe table: ",
+		)
+		assert.ErrorContains(t, err, "describe table failed")
+	})
+
+	t.Run("FailsIfUpdateTableFails", func(t *testing.T) {
+		dyndb, client := setup()
+		client.SetUpdateTableError("update table failed")
+
+		created, err := dyndb.MigrateSubscribersTable(ctx, time.Nanosecond)
+
+		assert.Assert(t, tu.ErrorIs(err, ops.ErrExternal))
+		const errFmt = migrateErrPrefix + "failed to create index \"%s\": "
+		expected := fmt.Sprintf(errFmt, DynamoDbPendingIndexName)
+		assert.ErrorContains(t, err, expected)
+		assert.ErrorContains(t, err, "update table failed")
+		assert.DeepEqual(t, []string{}, created)
+	})
+}
+
+func TestParseCheckpoint(t *testing.T) {
+	t.Run("Succeeds", func(t *testing.T) {
+		cp := &SendCheckpoint{
+			CampaignId:  "campaign-id",
+			MessageHash: "message-hash",
+			LastKey:     TestVerifiedSubscribers[0].ScanKey(),
+			NumSent:     27,
+			Complete:    false,
+			Timestamp:   testdata.TestTimestamp,
+		}
+
+		record := (&DynamoDb{}).newCheckpointRecord(cp)
+		checkpoint, err := parseCheckpoint(record)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, cp, checkpoint)
+	})
+
+	t.Run("SucceedsWithTagFilter", func(t *testing.T) {
+		cp := &SendCheckpoint{
+			CampaignId:  "campaign-id",
+			MessageHash: "message-hash",
+			TagFilter:   "releases or essays",
+			Timestamp:   testdata.TestTimestamp,
+		}
+
+		record := (&DynamoDb{}).newCheckpointRecord(cp)
+		checkpoint, err := parseCheckpoint(record)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, cp, checkpoint)
+	})
+
+	t.Run("SucceedsWithoutLastKey", func(t *testing.T) {
+		cp := &SendCheckpoint{
+			CampaignId:  "campaign-id",
+			MessageHash: "message-hash",
+			Timestamp:   testdata.TestTimestamp,
+		}
+
+		record := (&DynamoDb{}).newCheckpointRecord(cp)
+		checkpoint, err := parseCheckpoint(record)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, cp, checkpoint)
+	})
+
+	t.Run("ErrorsIfGettingAttributesFail", func(t *testing.T) {
+		checkpoint, err := parseCheckpoint(dbAttributes{
+			"lastEmail": &dbString{Value: testdata.TestEmail},
+		})
+
+		assert.Check(t, is.Nil(checkpoint))
+		assert.ErrorContains(t, err, "failed to parse checkpoint: ")
+		assert.ErrorContains(t, err, "attribute 'campaignId' not in: ")
+		assert.ErrorContains(t, err, "attribute 'messageHash' not in: ")
+		assert.ErrorContains(t, err, "attribute 'lastTimestamp' not in: ")
+		assert.ErrorContains(t, err, "attribute 'numSent' not in: ")
+		assert.ErrorContains(t, err, "attribute 'complete' not in: ")
+		assert.ErrorContains(t, err, "attribute 'updated' not in: ")
+	})
+}
+
+func TestParseCampaign(t *testing.T) {
+	newCampaign := func() *Campaign {
+		return &Campaign{
+			Id:          "campaign-id",
+			Subject:     "Hello, World!",
+			MessageHash: "message-hash",
+			StartTime:   testdata.TestTimestamp,
+			FinishTime:  testdata.TestTimestamp.Add(time.Hour),
+			NumSent:     27,
+			NumFailed:   1,
+			Status:      CampaignComplete,
+		}
+	}
+
+	t.Run("Succeeds", func(t *testing.T) {
+		c := newCampaign()
+
+		campaign, err := parseCampaign((&DynamoDb{}).newCampaignRecord(c))
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, c, campaign)
+	})
+
+	t.Run("SucceedsWithTagFilter", func(t *testing.T) {
+		c := newCampaign()
+		c.TagFilter = "releases or essays"
+
+		campaign, err := parseCampaign((&DynamoDb{}).newCampaignRecord(c))
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, c, campaign)
+	})
+
+	t.Run("SucceedsWithoutFinishTime", func(t *testing.T) {
+		c := newCampaign()
+		c.FinishTime = time.Time{}
+		c.Status = CampaignSending
+
+		record := (&DynamoDb{}).newCampaignRecord(c)
+		campaign, err := parseCampaign(record)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, c, campaign)
+		_, hasFinished := record["finished"]
+		assert.Assert(t, !hasFinished)
+	})
+
+	t.Run("ErrorsIfGettingAttributesFail", func(t *testing.T) {
+		campaign, err := parseCampaign(dbAttributes{
+			"finished": &dbString{Value: "not a number"},
+		})
+
+		assert.Check(t, is.Nil(campaign))
+		assert.ErrorContains(t, err, "failed to parse campaign: ")
+		assert.ErrorContains(t, err, "attribute 'campaignId' not in: ")
+		assert.ErrorContains(t, err, "attribute 'subject' not in: ")
+		assert.ErrorContains(t, err, "attribute 'messageHash' not in: ")
+		assert.ErrorContains(t, err, "attribute 'started' not in: ")
+		assert.ErrorContains(t, err, "attribute 'finished' is of type ")
+		assert.ErrorContains(t, err, "attribute 'numSent' not in: ")
+		assert.ErrorContains(t, err, "attribute 'numFailed' not in: ")
+		assert.ErrorContains(t, err, "attribute 'status' not in: ")
+	})
+}
+
+func TestSplitParts(t *testing.T) {
+	t.Run("ReturnsOnePartIfEmpty", func(t *testing.T) {
+		assert.DeepEqual(t, [][]byte{{}}, splitParts([]byte{}, 3))
+	})
+
+	t.Run("ReturnsOnePartIfSmallerThanSize", func(t *testing.T) {
+		data := []byte("foo")
+
+		assert.DeepEqual(t, [][]byte{data}, splitParts(data, 3))
+	})
+
+	t.Run("SplitsDataLargerThanSize", func(t *testing.T) {
+		parts := splitParts([]byte("foobarbaz!"), 3)
+
+		expected := [][]byte{
+			[]byte("foo"), []byte("bar"), []byte("baz"), []byte("!"),
+		}
+		assert.DeepEqual(t, expected, parts)
+	})
+}
+
+func TestCheckItemSizes(t *testing.T) {
+	newItem := func(dataSize int) dbAttributes {
+		return dbAttributes{
+			"email": &dbString{Value: "part#foo"},
+			"data":  &dbBinary{Value: make([]byte, dataSize)},
+		}
+	}
+	// "email" + "part#foo" + "data"
+	const overhead = 5 + 8 + 4
+
+	t.Run("SucceedsIfItemsAreWithinLimit", func(t *testing.T) {
+		item := newItem(dynamoDbItemSizeLimit - overhead)
+
+		assert.NilError(t, checkItemSizes(newItem(0), item))
+	})
+
+	t.Run("FailsIfAnyItemExceedsLimit", func(t *testing.T) {
+		item := newItem(dynamoDbItemSizeLimit - overhead + 1)
+
+		err := checkItemSizes(newItem(0), item)
+
+		assert.Assert(t, tu.ErrorIs(err, ErrItemTooLarge))
+		assert.ErrorContains(
+			t, err, "exceeds DynamoDB item size limit of 409600 bytes",
+		)
+		const sizeFmt = "record size: %d bytes"
+		assert.ErrorContains(
+			t, err, fmt.Sprintf(sizeFmt, dynamoDbItemSizeLimit+1),
+		)
+	})
+
+	t.Run("CountsEveryAttributeType", func(t *testing.T) {
+		item := dbAttributes{
+			"s":  &dbString{Value: "foo"},
+			"n":  &dbNumber{Value: "27"},
+			"b":  &dbBool{Value: true},
+			"ss": &dbStringSet{Value: []string{"bar", "baz"}},
+			"m":  &dbMap{Value: dbAttributes{"k": &dbString{Value: "v"}}},
+		}
+
+		// s: 1 + 3, n: 1 + 2, b: 1 + 1, ss: 2 + 6, m: 1 + 3 + 1 + (1 + 1)
+		assert.Equal(t, 24, itemSize(item))
+	})
+}
+
+func TestPutMessageRecordsCheckItemSizesFirst(t *testing.T) {
+	ctx := context.Background()
+	client := &TestDynamoDbClient{}
+	client.SetAllErrors("should not store any records")
+	dyndb := &DynamoDb{Client: client, TableName: "subscribers"}
+
+	// The scheduled message record contains the ID in both its key and its
+	// scheduledId attribute, so an ID this long makes it too large.
+	scheduled := newTestScheduledMessage(testdata.TestTimestamp)
+	scheduled.Id = strings.Repeat("x", dynamoDbItemSizeLimit/2)
+
+	err := dyndb.PutScheduledMessage(ctx, scheduled)
+
+	assert.Assert(t, tu.ErrorIs(err, ErrItemTooLarge))
+	assert.Assert(t, !errors.Is(err, ops.ErrExternal))
+	assert.ErrorContains(t, err, "failed to put scheduled message ")
+}
+
+func TestParseScheduledMessage(t *testing.T) {
+	t.Run("Succeeds", func(t *testing.T) {
+		scheduled := &ScheduledMessage{
+			Id:     "scheduled-id",
+			SendAt: testdata.TestTimestamp,
+		}
+
+		parsed, numParts, err := parseScheduledMessage(
+			(&DynamoDb{}).newScheduledMessageRecord(scheduled, 2),
+		)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, scheduled, parsed)
+		assert.Equal(t, 2, numParts)
+	})
+
+	t.Run("SucceedsWithClaim", func(t *testing.T) {
+		scheduled := &ScheduledMessage{
+			Id:           "scheduled-id",
+			SendAt:       testdata.TestTimestamp,
+			ClaimedUntil: testdata.TestTimestamp.Add(time.Minute),
+		}
+
+		parsed, _, err := parseScheduledMessage(
+			(&DynamoDb{}).newScheduledMessageRecord(scheduled, 1),
+		)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, scheduled, parsed)
+	})
+
+	t.Run("IncludesListInIndexPartition", func(t *testing.T) {
+		scheduled := &ScheduledMessage{Id: "scheduled-id"}
+
+		record := (&DynamoDb{List: "updates"}).newScheduledMessageRecord(
+			scheduled, 1,
+		)
+
+		partition, err := (&dbParser{record}).GetString(
+			DynamoDbScheduledIndexPartitionKey,
+		)
+		assert.NilError(t, err)
+		assert.Equal(t, "list#updates#scheduled#", partition)
+	})
+
+	t.Run("ErrorsIfGettingAttributesFail", func(t *testing.T) {
+		parsed, numParts, err := parseScheduledMessage(dbAttributes{})
+
+		assert.Check(t, is.Nil(parsed))
+		assert.Equal(t, 0, numParts)
+		assert.ErrorContains(t, err, "failed to parse scheduled message: ")
+		assert.ErrorContains(t, err, "attribute 'scheduledId' not in: ")
+		assert.ErrorContains(t, err, "attribute 'sendAt' not in: ")
+		assert.ErrorContains(t, err, "attribute 'parts' not in: ")
+	})
+}
+
+func TestParseAuditEvent(t *testing.T) {
+	newAuditEvent := func() *AuditEvent {
+		return &AuditEvent{
+			Email:     testdata.TestEmail,
+			Operation: AuditRemove,
+			Reason:    ops.RemoveReasonBounce,
+			Source:    AuditSourceSns,
+			RequestId: "message-id",
+			Timestamp: testdata.TestTimestamp,
+		}
+	}
+
+	t.Run("Succeeds", func(t *testing.T) {
+		e := newAuditEvent()
+
+		event, err := parseAuditEvent((&DynamoDb{}).newAuditEventRecord(e))
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, e, event)
+	})
+
+	t.Run("SucceedsWithoutReasonOrRequestId", func(t *testing.T) {
+		e := newAuditEvent()
+		e.Operation = AuditImport
+		e.Reason = ops.RemoveReasonNil
+		e.Source = AuditSourceCli
+		e.RequestId = ""
+
+		record := (&DynamoDb{}).newAuditEventRecord(e)
+		event, err := parseAuditEvent(record)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, e, event)
+		_, hasReason := record["reason"]
+		_, hasRequestId := record["requestId"]
+		assert.Assert(t, !hasReason)
+		assert.Assert(t, !hasRequestId)
+	})
+
+	t.Run("ErrorsIfGettingAttributesFail", func(t *testing.T) {
+		event, err := parseAuditEvent(dbAttributes{
+			"reason":    toDynamoDbTimestamp(testdata.TestTimestamp),
+			"requestId": toDynamoDbTimestamp(testdata.TestTimestamp),
+		})
+
+		assert.Check(t, is.Nil(event))
+		assert.ErrorContains(t, err, "failed to parse audit event: ")
+		assert.ErrorContains(t, err, "attribute 'auditEmail' not in: ")
+		assert.ErrorContains(t, err, "attribute 'operation' not in: ")
+		assert.ErrorContains(t, err, "attribute 'reason' is of type ")
+		assert.ErrorContains(t, err, "attribute 'source' not in: ")
+		assert.ErrorContains(t, err, "attribute 'requestId' is of type ")
+		assert.ErrorContains(t, err, "attribute 'timestamp' not in: ")
+	})
+}
+
+func TestParseTombstone(t *testing.T) {
+	t.Run("Succeeds", func(t *testing.T) {
+		ts := &Tombstone{
+			Email:     testdata.TestEmail,
+			Reason:    TombstoneUnsubscribe,
+			Timestamp: testdata.TestTimestamp,
+		}
+
+		parsed, err := parseTombstone((&DynamoDb{}).newTombstoneRecord(ts))
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, ts, parsed)
+	})
+
+	t.Run("ErrorsIfGettingAttributesFail", func(t *testing.T) {
+		parsed, err := parseTombstone(dbAttributes{})
+
+		assert.Check(t, is.Nil(parsed))
+		assert.ErrorContains(t, err, "failed to parse tombstone: ")
+		assert.ErrorContains(t, err, "attribute 'tombstoneEmail' not in: ")
+		assert.ErrorContains(t, err, "attribute 'reason' not in: ")
+		assert.ErrorContains(t, err, "attribute 'timestamp' not in: ")
+	})
+}
+
+func TestForList(t *testing.T) {
+	dyndb := &DynamoDb{Client: &TestDynamoDbClient{}, TableName: "subscribers"}
+
+	listDb := dyndb.ForList("updates")
+
+	assert.Equal(t, "", dyndb.List)
+	assert.Equal(t, "updates", listDb.List)
+	assert.Equal(t, dyndb.Client, listDb.Client)
+	assert.Equal(t, dyndb.TableName, listDb.TableName)
+
+	t.Run("PrefixesKeys", func(t *testing.T) {
+		keyValue := func(key dbAttributes) string {
+			return key[DynamoDbPrimaryKey].(*dbString).Value
+		}
+
+		email := "foo@test.com"
+		assert.Equal(t, email, keyValue(dyndb.subscriberKey(email)))
+		listEmail := "list#updates#" + email
+		assert.Equal(t, listEmail, keyValue(listDb.subscriberKey(email)))
+		assert.Equal(
+			t,
+			"list#updates#campaign#campaign-id",
+			keyValue(listDb.campaignKey("campaign-id")),
+		)
+	})
+}
+
+func TestRejectsAddressesContainingKeySeparator(t *testing.T) {
+	client := &TestDynamoDbClient{}
+	dyndb := &DynamoDb{Client: client, TableName: "subscribers-table"}
+	ctx := context.Background()
+	// A request reaching the client would return this error instead.
+	client.SetAllErrors("simulated server error")
+	const email = "list#updates#foo@test.com"
+
+	t.Run("Get", func(t *testing.T) {
+		sub, err := dyndb.Get(ctx, email)
+
+		assert.Assert(t, is.Nil(sub))
+		assert.Error(t, err, `address contains "#": `+email)
+		assert.Assert(t, tu.ErrorIs(err, ErrAddressContainsKeySeparator))
+	})
+
+	t.Run("Put", func(t *testing.T) {
+		err := dyndb.Put(ctx, &Subscriber{Email: email})
+
+		assert.Assert(t, tu.ErrorIs(err, ErrAddressContainsKeySeparator))
+	})
+
+	t.Run("GetTombstone", func(t *testing.T) {
+		tombstone, err := dyndb.GetTombstone(ctx, email)
+
+		assert.Assert(t, is.Nil(tombstone))
+		assert.Assert(t, tu.ErrorIs(err, ErrAddressContainsKeySeparator))
+	})
+
+	t.Run("PutTombstone", func(t *testing.T) {
+		err := dyndb.PutTombstone(ctx, &Tombstone{Email: email})
+
+		assert.Assert(t, tu.ErrorIs(err, ErrAddressContainsKeySeparator))
+	})
+
+	t.Run("DeleteTombstone", func(t *testing.T) {
+		err := dyndb.DeleteTombstone(ctx, email)
+
+		assert.Assert(t, tu.ErrorIs(err, ErrAddressContainsKeySeparator))
+	})
+}
+
 func setupDbWithSubscribers() (dyndb *DynamoDb, client *TestDynamoDbClient) {
 	client = &TestDynamoDbClient{}
-	dyndb = &DynamoDb{client, "subscribers-table"}
+	dyndb = &DynamoDb{Client: client, TableName: "subscribers-table"}
 
 	client.addSubscribers(TestSubscribers)
 	return
@@ -315,6 +1121,213 @@ func TestProcessSubscribers(t *testing.T) {
 			assert.NilError(t, err)
 			assert.DeepEqual(t, TestVerifiedSubscribers[:2], *subs)
 		})
+
+		t.Run("FromStartKey", func(t *testing.T) {
+			dynDb, client, subs, f := setup()
+			client.ScanSize = 1
+			startKey := TestVerifiedSubscribers[0].ScanKey()
+
+			err := dynDb.ProcessSubscribersFrom(
+				ctx, SubscriberVerified, startKey, f,
+			)
+
+			assert.NilError(t, err)
+			assert.DeepEqual(t, TestVerifiedSubscribers[1:], *subs)
+		})
+	})
+
+	t.Run("OnlyProcessesSubscribersOfItsList", func(t *testing.T) {
+		dynDb, client, subs, f := setup()
+		listSub := *TestVerifiedSubscribers[0]
+		listSub.List = "updates"
+		client.addSubscribers([]*Subscriber{&listSub})
+
+		err := dynDb.ProcessSubscribers(ctx, SubscriberVerified, f)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, TestVerifiedSubscribers, *subs)
+
+		*subs = []*Subscriber{}
+		err = dynDb.ForList("updates").ProcessSubscribers(
+			ctx, SubscriberVerified, f,
+		)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, []*Subscriber{&listSub}, *subs)
+	})
+
+	t.Run("FiltersByTags", func(t *testing.T) {
+		dynDb, client, _, f := setup()
+		filter, err := ParseTagFilter("releases or not (essays and releases)")
+		assert.NilError(t, err)
+
+		err = dynDb.ProcessTaggedSubscribersFrom(
+			ctx, SubscriberVerified, filter, nil, f,
+		)
+
+		assert.NilError(t, err)
+		input := client.ScanInput
+		const expectedFilter = "(NOT begins_with(#email, :list)) AND " +
+			"(contains(#tags, :tag0) OR " +
+			"(NOT (contains(#tags, :tag1) AND contains(#tags, :tag0))))"
+		assert.Equal(t, expectedFilter, aws.ToString(input.FilterExpression))
+		assert.Equal(t, "tags", input.ExpressionAttributeNames["#tags"])
+		values := input.ExpressionAttributeValues
+		assert.Equal(t, 3, len(values))
+		assert.Equal(t, "releases", values[":tag0"].(*dbString).Value)
+		assert.Equal(t, "essays", values[":tag1"].(*dbString).Value)
+	})
+
+	t.Run("QueriesTagIndex", func(t *testing.T) {
+		setupTagged := func() (
+			dyndb *DynamoDb,
+			client *TestDynamoDbClient,
+			subs *[]*Subscriber,
+			f SubscriberFunc,
+			tagged []*Subscriber,
+		) {
+			dyndb, client, subs, f = setup()
+			tags := [][]string{{"essays"}, {"essays", "releases"}, {"releases"}}
+			for i, sub := range TestVerifiedSubscribers {
+				taggedSub := *sub
+				taggedSub.Tags = tags[i]
+				tagged = append(tagged, &taggedSub)
+			}
+			client.Subscribers = []dbAttributes{}
+			client.addSubscribers(tagged)
+			return
+		}
+		parseFilter := func(t *testing.T, expr string) *TagFilter {
+			t.Helper()
+			filter, err := ParseTagFilter(expr)
+			assert.NilError(t, err)
+			return filter
+		}
+
+		t.Run("InAddressOrderWithoutDuplicates", func(t *testing.T) {
+			dynDb, client, subs, f, tagged := setupTagged()
+			filter := parseFilter(t, "essays or releases")
+
+			err := dynDb.ProcessTaggedSubscribersFrom(
+				ctx, SubscriberVerified, filter, nil, f,
+			)
+
+			assert.NilError(t, err)
+			// bar@, baz@, foo@
+			expected := []*Subscriber{tagged[1], tagged[2], tagged[0]}
+			assert.DeepEqual(t, expected, *subs)
+			assert.Equal(t, 0, client.ScanCalls)
+			assert.Equal(t, 2, client.QueryCalls)
+		})
+
+		t.Run("AndChecksEachSubscriberAgainstFilter", func(t *testing.T) {
+			dynDb, _, subs, f, tagged := setupTagged()
+			filter := parseFilter(t, "essays and not releases")
+
+			err := dynDb.ProcessTaggedSubscribersFrom(
+				ctx, SubscriberVerified, filter, nil, f,
+			)
+
+			assert.NilError(t, err)
+			assert.DeepEqual(t, []*Subscriber{tagged[0]}, *subs)
+		})
+
+		t.Run("FromStartKey", func(t *testing.T) {
+			dynDb, _, subs, f, tagged := setupTagged()
+			filter := parseFilter(t, "essays or releases")
+
+			err := dynDb.ProcessTaggedSubscribersFrom(
+				ctx, SubscriberVerified, filter, tagged[1].ScanKey(), f,
+			)
+
+			assert.NilError(t, err)
+			assert.DeepEqual(t, []*Subscriber{tagged[2], tagged[0]}, *subs)
+		})
+
+		t.Run("WithoutProcessingAllSubscribers", func(t *testing.T) {
+			dynDb, _, subs, _, tagged := setupTagged()
+			filter := parseFilter(t, "essays")
+			f := SubscriberFunc(func(s *Subscriber) bool {
+				*subs = append(*subs, s)
+				return false
+			})
+
+			err := dynDb.ProcessTaggedSubscribersFrom(
+				ctx, SubscriberVerified, filter, nil, f,
+			)
+
+			assert.NilError(t, err)
+			assert.DeepEqual(t, []*Subscriber{tagged[1]}, *subs)
+		})
+
+		t.Run("SkippingStaleTagRecords", func(t *testing.T) {
+			dynDb, client, subs, f, tagged := setupTagged()
+			client.addSubscriberRecord(
+				dynDb.newTagRecord("releases", tagged[0].Email),
+			)
+			client.addSubscriberRecord(
+				dynDb.newTagRecord("releases", "gone@test.com"),
+			)
+			filter := parseFilter(t, "releases")
+
+			err := dynDb.ProcessTaggedSubscribersFrom(
+				ctx, SubscriberVerified, filter, nil, f,
+			)
+
+			assert.NilError(t, err)
+			assert.DeepEqual(t, []*Subscriber{tagged[1], tagged[2]}, *subs)
+		})
+
+		t.Run("OnlyForItsList", func(t *testing.T) {
+			dynDb, client, subs, f, tagged := setupTagged()
+			listSub := *tagged[0]
+			listSub.List = "updates"
+			listSub.Email = "list-only@test.com"
+			client.addSubscribers([]*Subscriber{&listSub})
+			filter := parseFilter(t, "essays")
+
+			err := dynDb.ProcessTaggedSubscribersFrom(
+				ctx, SubscriberVerified, filter, nil, f,
+			)
+
+			assert.NilError(t, err)
+			assert.DeepEqual(t, []*Subscriber{tagged[1], tagged[0]}, *subs)
+
+			*subs = []*Subscriber{}
+			err = dynDb.ForList("updates").ProcessTaggedSubscribersFrom(
+				ctx, SubscriberVerified, filter, nil, f,
+			)
+
+			assert.NilError(t, err)
+			assert.DeepEqual(t, []*Subscriber{&listSub}, *subs)
+		})
+
+		t.Run("ButScansPendingSubscribers", func(t *testing.T) {
+			dynDb, client, _, f, _ := setupTagged()
+			filter := parseFilter(t, "essays")
+
+			err := dynDb.ProcessTaggedSubscribersFrom(
+				ctx, SubscriberPending, filter, nil, f,
+			)
+
+			assert.NilError(t, err)
+			assert.Equal(t, 1, client.ScanCalls)
+			assert.Equal(t, 0, client.QueryCalls)
+		})
+
+		t.Run("ReturnsErrorIfQueryFails", func(t *testing.T) {
+			dynDb, client, _, f, _ := setupTagged()
+			client.ServerErr = tu.AwsServerError("query error")
+			filter := parseFilter(t, "essays")
+
+			err := dynDb.ProcessTaggedSubscribersFrom(
+				ctx, SubscriberVerified, filter, nil, f,
+			)
+
+			const expectedErr = "failed to get subscribers tagged essays"
+			assert.ErrorContains(t, err, expectedErr)
+			assert.Assert(t, tu.ErrorIs(err, ops.ErrExternal))
+		})
 	})
 
 	t.Run("ReturnsError", func(t *testing.T) {
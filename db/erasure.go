@@ -0,0 +1,32 @@
+package db
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// ErasureStore records the salted hashes of email addresses whose data was
+// erased upon request.
+//
+// The hashes enable ProdAgent to recognize an erased address, so it won't
+// import or restore it again by accident, without storing the address itself.
+// ListErasures returns every hash in no particular order, for backing them up.
+type ErasureStore interface {
+	PutErasure(ctx context.Context, hash string) error
+	IsErased(ctx context.Context, hash string) (bool, error)
+	ListErasures(ctx context.Context) ([]string, error)
+}
+
+// ErasureHash returns the hex encoded HMAC-SHA256 hash of the address, keyed by
+// salt.
+//
+// It ignores case, so that the hash of an address matches the hash of the same
+// address with different capitalization.
+func ErasureHash(salt, address string) string {
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(strings.ToLower(address)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,378 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mbland/elistman/email"
+)
+
+// FileDb is an implementation of Database, CheckpointStore, CampaignStore,
+// ScheduleStore, AuditLog, TombstoneStore, ErasureStore, and WelcomeStore that
+// keeps its records in a single JSON file.
+//
+// It's intended for small lists and for local development when records should
+// survive restarts. It behaves exactly like MemoryDb, which holds every record
+// in memory, and rewrites the entire file after every change. It writes a
+// temporary file in the same directory first, then renames it over the
+// original, so the file always contains either the previous or the new
+// records, even after a crash.
+//
+// If saving the file fails, the method returns an error, but the change
+// remains in memory, and the next successful save will include it.
+//
+// It's safe for concurrent use by a single process. Multiple processes must
+// not open the same file at the same time, as each would overwrite the others'
+// changes.
+type FileDb struct {
+	*MemoryDb
+	Path      string
+	saveMutex sync.Mutex
+}
+
+// fileDbVersion identifies the format of a FileDb file.
+const fileDbVersion = 1
+
+// fileDbRecords contains every record within a FileDb file.
+type fileDbRecords struct {
+	Version           int
+	Subscribers       []*Subscriber
+	Receipts          map[string][]string
+	Checkpoints       []*SendCheckpoint
+	Campaigns         []*Campaign
+	ScheduledMessages []*ScheduledMessage
+	AuditEvents       []*AuditEvent
+	Tombstones        []*Tombstone
+	Erasures          []string
+	WelcomeMessage    *email.Message
+}
+
+// OpenFileDb loads the FileDb stored at path.
+//
+// If the file doesn't exist, it creates a new, empty file.
+func OpenFileDb(path string) (fileDb *FileDb, err error) {
+	fileDb = &FileDb{MemoryDb: NewMemoryDb(), Path: path}
+	var data []byte
+
+	if data, err = os.ReadFile(path); errors.Is(err, fs.ErrNotExist) {
+		err = fileDb.save()
+	} else if err != nil {
+		err = fmt.Errorf("failed to open %s: %w", path, err)
+	} else {
+		err = fileDb.load(data)
+	}
+
+	if err != nil {
+		fileDb = nil
+	}
+	return
+}
+
+func (fileDb *FileDb) load(data []byte) (err error) {
+	records := &fileDbRecords{}
+
+	if err = json.Unmarshal(data, records); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", fileDb.Path, err)
+	} else if records.Version != fileDbVersion {
+		const errFmt = "failed to parse %s: unsupported version %d"
+		return fmt.Errorf(errFmt, fileDb.Path, records.Version)
+	}
+
+	db := fileDb.MemoryDb
+	for _, sub := range records.Subscribers {
+		db.subscribers[sub.Email] = sub
+		db.indexTags(sub)
+	}
+	for email, campaignIds := range records.Receipts {
+		db.receipts[email] = map[string]bool{}
+		for _, id := range campaignIds {
+			db.receipts[email][id] = true
+		}
+	}
+	for _, cp := range records.Checkpoints {
+		db.checkpoints[cp.CampaignId] = cp
+	}
+	for _, c := range records.Campaigns {
+		db.campaigns[c.Id] = c
+	}
+	for _, s := range records.ScheduledMessages {
+		db.scheduled[s.Id] = s
+	}
+	for _, e := range records.AuditEvents {
+		db.audit[e.Email] = append(db.audit[e.Email], e)
+	}
+	for _, ts := range records.Tombstones {
+		db.tombstones[ts.Email] = ts
+	}
+	for _, hash := range records.Erasures {
+		db.erasures[hash] = true
+	}
+	db.welcome = records.WelcomeMessage
+	return
+}
+
+// records returns every record from db, sorted by key so that the file
+// contents change only when the records do. It omits expired pending
+// subscribers.
+func (db *MemoryDb) records() *fileDbRecords {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	now := db.CurrentTime()
+	records := &fileDbRecords{Version: fileDbVersion}
+	for _, sub := range db.subscribers {
+		if !db.expired(sub, now) {
+			records.Subscribers = append(records.Subscribers, sub)
+		}
+	}
+	if len(db.receipts) != 0 {
+		records.Receipts = make(map[string][]string, len(db.receipts))
+	}
+	for email, campaignIds := range db.receipts {
+		records.Receipts[email] = sortedKeys(campaignIds)
+	}
+	for _, cp := range db.checkpoints {
+		records.Checkpoints = append(records.Checkpoints, cp)
+	}
+	for _, c := range db.campaigns {
+		records.Campaigns = append(records.Campaigns, c)
+	}
+	for _, s := range db.scheduled {
+		records.ScheduledMessages = append(records.ScheduledMessages, s)
+	}
+	for _, email := range sortedKeys(db.audit) {
+		records.AuditEvents = append(records.AuditEvents, db.audit[email]...)
+	}
+	for _, ts := range db.tombstones {
+		records.Tombstones = append(records.Tombstones, ts)
+	}
+	records.Erasures = sortedKeys(db.erasures)
+	records.WelcomeMessage = db.welcome
+
+	slices.SortFunc(records.Subscribers, func(lhs, rhs *Subscriber) int {
+		return strings.Compare(lhs.Email, rhs.Email)
+	})
+	slices.SortFunc(records.Checkpoints, func(lhs, rhs *SendCheckpoint) int {
+		return strings.Compare(lhs.CampaignId, rhs.CampaignId)
+	})
+	slices.SortFunc(records.Campaigns, func(lhs, rhs *Campaign) int {
+		return strings.Compare(lhs.Id, rhs.Id)
+	})
+	slices.SortFunc(
+		records.ScheduledMessages,
+		func(lhs, rhs *ScheduledMessage) int {
+			return strings.Compare(lhs.Id, rhs.Id)
+		},
+	)
+	slices.SortFunc(records.Tombstones, func(lhs, rhs *Tombstone) int {
+		return strings.Compare(lhs.Email, rhs.Email)
+	})
+	return records
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// save writes every record to a temporary file, then renames it to Path.
+//
+// The caller must hold fileDb.saveMutex, so that saves happen in the same
+// order as the changes they record.
+func (fileDb *FileDb) save() (err error) {
+	data, err := json.MarshalIndent(fileDb.records(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to save %s: %w", fileDb.Path, err)
+	}
+
+	dir, base := filepath.Split(fileDb.Path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, base+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to save %s: %w", fileDb.Path, err)
+	}
+	tmpPath := tmp.Name()
+
+	if _, err = tmp.Write(append(data, '\n')); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, fileDb.Path)
+	}
+
+	if err != nil {
+		os.Remove(tmpPath)
+		err = fmt.Errorf("failed to save %s: %w", fileDb.Path, err)
+	}
+	return
+}
+
+// update applies change, then saves the file if change succeeded.
+func (fileDb *FileDb) update(change func() error) error {
+	fileDb.saveMutex.Lock()
+	defer fileDb.saveMutex.Unlock()
+
+	if err := change(); err != nil {
+		return err
+	}
+	return fileDb.save()
+}
+
+func (fileDb *FileDb) Put(ctx context.Context, sub *Subscriber) error {
+	return fileDb.update(func() error {
+		return fileDb.MemoryDb.Put(ctx, sub)
+	})
+}
+
+func (fileDb *FileDb) Delete(ctx context.Context, email string) error {
+	return fileDb.update(func() error {
+		return fileDb.MemoryDb.Delete(ctx, email)
+	})
+}
+
+func (fileDb *FileDb) MarkReceived(
+	ctx context.Context, email, campaignId string,
+) error {
+	return fileDb.update(func() error {
+		return fileDb.MemoryDb.MarkReceived(ctx, email, campaignId)
+	})
+}
+
+func (fileDb *FileDb) DeleteReceipts(ctx context.Context, email string) error {
+	return fileDb.update(func() error {
+		return fileDb.MemoryDb.DeleteReceipts(ctx, email)
+	})
+}
+
+func (fileDb *FileDb) RotateUid(
+	ctx context.Context, email string, uid uuid.UUID, expires time.Time,
+) error {
+	return fileDb.update(func() error {
+		return fileDb.MemoryDb.RotateUid(ctx, email, uid, expires)
+	})
+}
+
+func (fileDb *FileDb) RecordVerifySent(
+	ctx context.Context, sub *Subscriber, prevCount int,
+) error {
+	return fileDb.update(func() error {
+		return fileDb.MemoryDb.RecordVerifySent(ctx, sub, prevCount)
+	})
+}
+
+func (fileDb *FileDb) PutCheckpoint(
+	ctx context.Context, checkpoint *SendCheckpoint,
+) error {
+	return fileDb.update(func() error {
+		return fileDb.MemoryDb.PutCheckpoint(ctx, checkpoint)
+	})
+}
+
+func (fileDb *FileDb) PutCampaign(ctx context.Context, c *Campaign) error {
+	return fileDb.update(func() error {
+		return fileDb.MemoryDb.PutCampaign(ctx, c)
+	})
+}
+
+func (fileDb *FileDb) PutScheduledMessage(
+	ctx context.Context, scheduled *ScheduledMessage,
+) error {
+	return fileDb.update(func() error {
+		return fileDb.MemoryDb.PutScheduledMessage(ctx, scheduled)
+	})
+}
+
+func (fileDb *FileDb) ClaimScheduledMessage(
+	ctx context.Context, id string, now, until time.Time,
+) error {
+	return fileDb.update(func() error {
+		return fileDb.MemoryDb.ClaimScheduledMessage(ctx, id, now, until)
+	})
+}
+
+func (fileDb *FileDb) ReleaseScheduledMessage(
+	ctx context.Context, id string, until time.Time,
+) error {
+	return fileDb.update(func() error {
+		return fileDb.MemoryDb.ReleaseScheduledMessage(ctx, id, until)
+	})
+}
+
+func (fileDb *FileDb) DeleteScheduledMessage(
+	ctx context.Context, id string,
+) error {
+	return fileDb.update(func() error {
+		return fileDb.MemoryDb.DeleteScheduledMessage(ctx, id)
+	})
+}
+
+func (fileDb *FileDb) PutAuditEvent(
+	ctx context.Context, event *AuditEvent,
+) error {
+	return fileDb.update(func() error {
+		return fileDb.MemoryDb.PutAuditEvent(ctx, event)
+	})
+}
+
+func (fileDb *FileDb) DeleteAuditEvents(
+	ctx context.Context, email string,
+) error {
+	return fileDb.update(func() error {
+		return fileDb.MemoryDb.DeleteAuditEvents(ctx, email)
+	})
+}
+
+func (fileDb *FileDb) PutTombstone(
+	ctx context.Context, tombstone *Tombstone,
+) error {
+	return fileDb.update(func() error {
+		return fileDb.MemoryDb.PutTombstone(ctx, tombstone)
+	})
+}
+
+func (fileDb *FileDb) DeleteTombstone(
+	ctx context.Context, email string,
+) error {
+	return fileDb.update(func() error {
+		return fileDb.MemoryDb.DeleteTombstone(ctx, email)
+	})
+}
+
+func (fileDb *FileDb) PutErasure(ctx context.Context, hash string) error {
+	return fileDb.update(func() error {
+		return fileDb.MemoryDb.PutErasure(ctx, hash)
+	})
+}
+
+func (fileDb *FileDb) PutWelcomeMessage(
+	ctx context.Context, msg *email.Message,
+) error {
+	return fileDb.update(func() error {
+		return fileDb.MemoryDb.PutWelcomeMessage(ctx, msg)
+	})
+}
+
+func (fileDb *FileDb) DeleteWelcomeMessage(ctx context.Context) error {
+	return fileDb.update(func() error {
+		return fileDb.MemoryDb.DeleteWelcomeMessage(ctx)
+	})
+}
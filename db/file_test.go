@@ -0,0 +1,245 @@
+//go:build small_tests || all_tests
+
+package db
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mbland/elistman/email"
+	"github.com/mbland/elistman/testdata"
+	tu "github.com/mbland/elistman/testutils"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func newTestFileDb(t *testing.T) *FileDb {
+	t.Helper()
+	fileDb, err := OpenFileDb(filepath.Join(t.TempDir(), "elistman.json"))
+
+	assert.NilError(t, err)
+	return fileDb
+}
+
+func TestFileDbContract(t *testing.T) {
+	testDatabaseContract(t, newTestFileDb(t), 0)
+}
+
+func TestOpenFileDb(t *testing.T) {
+	t.Run("CreatesEmptyFileIfMissing", func(t *testing.T) {
+		fileDb := newTestFileDb(t)
+
+		reopened, err := OpenFileDb(fileDb.Path)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, fileDb.records(), reopened.records())
+		assert.Equal(t, 0, len(reopened.records().Subscribers))
+	})
+
+	t.Run("FailsIfCannotCreateFile", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "nonexistent", "elistman.json")
+
+		fileDb, err := OpenFileDb(path)
+
+		assert.Assert(t, is.Nil(fileDb))
+		assert.ErrorContains(t, err, "failed to save "+path+": ")
+	})
+
+	t.Run("FailsIfCannotReadFile", func(t *testing.T) {
+		path := t.TempDir()
+
+		fileDb, err := OpenFileDb(path)
+
+		assert.Assert(t, is.Nil(fileDb))
+		assert.ErrorContains(t, err, "failed to open "+path+": ")
+	})
+
+	t.Run("FailsIfFileIsMalformed", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "elistman.json")
+		assert.NilError(t, os.WriteFile(path, []byte("{"), 0600))
+
+		fileDb, err := OpenFileDb(path)
+
+		assert.Assert(t, is.Nil(fileDb))
+		assert.ErrorContains(t, err, "failed to parse "+path+": ")
+	})
+
+	t.Run("FailsIfVersionIsUnsupported", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "elistman.json")
+		assert.NilError(t, os.WriteFile(path, []byte(`{"Version":2}`), 0600))
+
+		fileDb, err := OpenFileDb(path)
+
+		assert.Assert(t, is.Nil(fileDb))
+		expected := "failed to parse " + path + ": unsupported version 2"
+		assert.ErrorContains(t, err, expected)
+	})
+}
+
+func TestFileDbPersistsRecords(t *testing.T) {
+	ctx := context.Background()
+	fileDb := newTestFileDb(t)
+	fileDb.CurrentTime = func() time.Time { return testdata.TestTimestamp }
+	now := testdata.TestTimestamp
+	tagged := *TestVerifiedSubscribers[0]
+	tagged.Tags = []string{"releases"}
+	checkpoint := &SendCheckpoint{
+		CampaignId: "campaign-0",
+		LastKey:    tagged.ScanKey(),
+		NumSent:    1,
+		Timestamp:  now,
+	}
+	campaign := &Campaign{
+		Id: "campaign-0", StartTime: now, Status: CampaignSending,
+	}
+	scheduled := &ScheduledMessage{
+		Id: "scheduled-0", SendAt: now, Message: email.ExampleMessage,
+	}
+	events := []*AuditEvent{
+		{Email: "foo@test.com", Operation: AuditImport, Timestamp: now},
+		{Email: "bar@test.com", Operation: AuditImport, Timestamp: now},
+		{
+			Email:     "foo@test.com",
+			Operation: AuditVerify,
+			Timestamp: now.Add(time.Second),
+		},
+	}
+	tombstone := &Tombstone{
+		Email: "plugh@test.com", Reason: TombstoneBounce, Timestamp: now,
+	}
+
+	for _, sub := range TestSubscribers {
+		assert.NilError(t, fileDb.Put(ctx, sub))
+	}
+	assert.NilError(t, fileDb.Put(ctx, &tagged))
+	assert.NilError(t, fileDb.Delete(ctx, "bar@test.com"))
+	assert.NilError(t, fileDb.MarkReceived(ctx, tagged.Email, "campaign-0"))
+	assert.NilError(t, fileDb.MarkReceived(ctx, "baz@test.com", "campaign-0"))
+	assert.NilError(t, fileDb.DeleteReceipts(ctx, "baz@test.com"))
+	err := fileDb.RotateUid(ctx, "baz@test.com", testdata.TestUid, now)
+	assert.NilError(t, err)
+	assert.NilError(t, fileDb.PutCheckpoint(ctx, checkpoint))
+	assert.NilError(t, fileDb.PutCampaign(ctx, campaign))
+	assert.NilError(t, fileDb.PutScheduledMessage(ctx, scheduled))
+	for _, e := range events {
+		assert.NilError(t, fileDb.PutAuditEvent(ctx, e))
+	}
+	assert.NilError(t, fileDb.PutTombstone(ctx, tombstone))
+	assert.NilError(t, fileDb.PutErasure(ctx, "hash"))
+	assert.NilError(t, fileDb.PutWelcomeMessage(ctx, email.ExampleMessage))
+
+	reopened, err := OpenFileDb(fileDb.Path)
+	assert.NilError(t, err)
+	reopened.CurrentTime = fileDb.CurrentTime
+
+	t.Run("Subscribers", func(t *testing.T) {
+		for _, sub := range TestSubscribers {
+			expected, err := fileDb.Get(ctx, sub.Email)
+			got, reopenedErr := reopened.Get(ctx, sub.Email)
+
+			assert.Equal(t, err, reopenedErr)
+			assert.DeepEqual(t, expected, got)
+		}
+		assert.DeepEqual(t, fileDb.tagged, reopened.tagged)
+	})
+
+	t.Run("Receipts", func(t *testing.T) {
+		expected := map[string]map[string]bool{
+			tagged.Email: {"campaign-0": true},
+		}
+
+		assert.DeepEqual(t, expected, reopened.receipts)
+	})
+
+	t.Run("Checkpoints", func(t *testing.T) {
+		got, err := reopened.GetCheckpoint(ctx, checkpoint.CampaignId)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, checkpoint, got)
+	})
+
+	t.Run("Campaigns", func(t *testing.T) {
+		got, err := reopened.ListCampaigns(ctx)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, []*Campaign{campaign}, got)
+	})
+
+	t.Run("ScheduledMessages", func(t *testing.T) {
+		got, err := reopened.GetDueMessages(ctx, now)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, []*ScheduledMessage{scheduled}, got)
+	})
+
+	t.Run("AuditEvents", func(t *testing.T) {
+		got, err := reopened.GetAuditEvents(ctx, "foo@test.com")
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, []*AuditEvent{events[0], events[2]}, got)
+	})
+
+	t.Run("Tombstones", func(t *testing.T) {
+		got, err := reopened.GetTombstone(ctx, tombstone.Email)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, tombstone, got)
+	})
+
+	t.Run("Erasures", func(t *testing.T) {
+		erased, err := reopened.IsErased(ctx, "hash")
+
+		assert.NilError(t, err)
+		assert.Assert(t, erased)
+	})
+
+	t.Run("WelcomeMessage", func(t *testing.T) {
+		got, err := reopened.GetWelcomeMessage(ctx)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, email.ExampleMessage, got)
+	})
+}
+
+func TestFileDbOmitsExpiredSubscribers(t *testing.T) {
+	ctx := context.Background()
+	fileDb := newTestFileDb(t)
+	fileDb.CurrentTime = func() time.Time { return testdata.TestTimestamp }
+
+	for _, sub := range TestSubscribers {
+		assert.NilError(t, fileDb.Put(ctx, sub))
+	}
+	fileDb.CurrentTime = func() time.Time {
+		return testdata.TestTimestamp.Add(time.Hour * 100)
+	}
+	assert.NilError(t, fileDb.PutErasure(ctx, "hash"))
+
+	reopened, err := OpenFileDb(fileDb.Path)
+	assert.NilError(t, err)
+	reopened.CurrentTime = fileDb.CurrentTime
+
+	assert.Assert(t, is.Len(reopened.subscribers, len(TestSubscribers)-2))
+	_, err = reopened.Get(ctx, "quux@test.com")
+	assert.Assert(t, tu.ErrorIs(err, ErrSubscriberNotFound))
+	_, err = reopened.Get(ctx, "plugh@test.com")
+	assert.NilError(t, err)
+}
+
+func TestFileDbSaveFails(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "db")
+	assert.NilError(t, os.Mkdir(dir, 0700))
+	fileDb, err := OpenFileDb(filepath.Join(dir, "elistman.json"))
+	assert.NilError(t, err)
+	assert.NilError(t, os.RemoveAll(dir))
+
+	err = fileDb.PutErasure(ctx, "hash")
+
+	assert.ErrorContains(t, err, "failed to save "+fileDb.Path+": ")
+	erased, err := fileDb.IsErased(ctx, "hash")
+	assert.NilError(t, err)
+	assert.Assert(t, erased, "change should remain in memory")
+}
@@ -0,0 +1,587 @@
+package db
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mbland/elistman/email"
+)
+
+// MemoryDb is an in-memory implementation of Database, CheckpointStore,
+// CampaignStore, ScheduleStore, AuditLog, TombstoneStore, ErasureStore, and
+// WelcomeStore.
+//
+// It's intended for local development via `elistman serve`, so its contents
+// disappear when the process exits. FileDb keeps the same records in a file.
+// It's safe for concurrent use.
+//
+// Every method stores and returns copies of its records, so callers can't
+// change stored records without calling a method like Put.
+//
+// It maintains an index of subscribers by tag, so ProcessTaggedSubscribersFrom
+// doesn't need to examine every subscriber when the TagFilter provides
+// IndexTags.
+//
+// Like DynamoDb, it expires pending subscribers once their Timestamp passes.
+// DynamoDB's Time To Live feature deletes expired items eventually, but
+// MemoryDb removes them as soon as any method encounters them. Methods never
+// return an expired pending subscriber either way.
+type MemoryDb struct {
+	// CurrentTime returns the time used to expire pending subscribers.
+	// NewMemoryDb sets it to time.Now.
+	CurrentTime func() time.Time
+
+	mutex       sync.Mutex
+	subscribers map[string]*Subscriber
+	tagged      map[string]map[string]bool
+	receipts    map[string]map[string]bool
+	checkpoints map[string]*SendCheckpoint
+	campaigns   map[string]*Campaign
+	scheduled   map[string]*ScheduledMessage
+	audit       map[string][]*AuditEvent
+	tombstones  map[string]*Tombstone
+	erasures    map[string]bool
+	welcome     *email.Message
+}
+
+func NewMemoryDb() *MemoryDb {
+	return &MemoryDb{
+		CurrentTime: time.Now,
+		subscribers: map[string]*Subscriber{},
+		tagged:      map[string]map[string]bool{},
+		receipts:    map[string]map[string]bool{},
+		checkpoints: map[string]*SendCheckpoint{},
+		campaigns:   map[string]*Campaign{},
+		scheduled:   map[string]*ScheduledMessage{},
+		audit:       map[string][]*AuditEvent{},
+		tombstones:  map[string]*Tombstone{},
+		erasures:    map[string]bool{},
+	}
+}
+
+func copySubscriber(sub *Subscriber) *Subscriber {
+	subCopy := *sub
+	subCopy.Attributes = maps.Clone(sub.Attributes)
+	subCopy.Tags = slices.Clone(sub.Tags)
+	if sub.Signup != nil {
+		signup := *sub.Signup
+		subCopy.Signup = &signup
+	}
+	return &subCopy
+}
+
+// indexTags adds sub.Email to the index entry for each of sub.Tags.
+func (db *MemoryDb) indexTags(sub *Subscriber) {
+	for _, tag := range sub.Tags {
+		if db.tagged[tag] == nil {
+			db.tagged[tag] = map[string]bool{}
+		}
+		db.tagged[tag][sub.Email] = true
+	}
+}
+
+// unindexTags removes email from the index entries of its subscriber's Tags.
+func (db *MemoryDb) unindexTags(email string) {
+	sub, ok := db.subscribers[email]
+	if !ok {
+		return
+	}
+	for _, tag := range sub.Tags {
+		if delete(db.tagged[tag], email); len(db.tagged[tag]) == 0 {
+			delete(db.tagged, tag)
+		}
+	}
+}
+
+// subscriber returns the stored Subscriber for email, unless it doesn't exist
+// or has expired.
+//
+// It removes the Subscriber if it has expired. The caller must hold db.mutex.
+func (db *MemoryDb) subscriber(email string) (sub *Subscriber, ok bool) {
+	sub, ok = db.subscribers[email]
+	if ok && db.expired(sub, db.CurrentTime()) {
+		db.unindexTags(email)
+		delete(db.subscribers, email)
+		return nil, false
+	}
+	return
+}
+
+// expired returns true if sub is a pending subscriber whose Timestamp is
+// before now.
+func (db *MemoryDb) expired(sub *Subscriber, now time.Time) bool {
+	return sub.Status == SubscriberPending && sub.Timestamp.Before(now)
+}
+
+func (db *MemoryDb) Get(
+	_ context.Context, email string,
+) (subscriber *Subscriber, err error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if sub, ok := db.subscriber(email); !ok {
+		err = ErrSubscriberNotFound
+	} else {
+		subscriber = copySubscriber(sub)
+	}
+	return
+}
+
+func (db *MemoryDb) Put(_ context.Context, sub *Subscriber) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	db.unindexTags(sub.Email)
+	db.subscribers[sub.Email] = copySubscriber(sub)
+	db.indexTags(sub)
+	return nil
+}
+
+// Delete removes the Subscriber for email, if it exists.
+//
+// Like DynamoDb.Delete, it doesn't return an error if there's no such
+// Subscriber.
+func (db *MemoryDb) Delete(_ context.Context, email string) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	db.unindexTags(email)
+	delete(db.subscribers, email)
+	return nil
+}
+
+// MarkReceived records that email received campaignId.
+func (db *MemoryDb) MarkReceived(
+	_ context.Context, email, campaignId string,
+) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if db.receipts[email] == nil {
+		db.receipts[email] = map[string]bool{}
+	}
+	db.receipts[email][campaignId] = true
+	return nil
+}
+
+func (db *MemoryDb) HasReceived(
+	_ context.Context, email, campaignId string,
+) (bool, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	return db.receipts[email][campaignId], nil
+}
+
+func (db *MemoryDb) DeleteReceipts(_ context.Context, email string) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	delete(db.receipts, email)
+	return nil
+}
+
+// RotateUid replaces a subscriber's Uid with uid, keeping the current Uid as
+// the PreviousUid until expires.
+//
+// It returns ErrSubscriberNotFound if the subscriber no longer exists.
+func (db *MemoryDb) RotateUid(
+	_ context.Context, email string, uid uuid.UUID, expires time.Time,
+) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	sub, ok := db.subscriber(email)
+	if !ok {
+		return ErrSubscriberNotFound
+	}
+	sub.PreviousUid = sub.Uid
+	sub.PreviousUidExpires = expires
+	sub.Uid = uid
+	return nil
+}
+
+// RecordVerifySent updates sub's verification email fields, Timestamp, and
+// Tags if its stored VerifySentCount is prevCount.
+//
+// It returns ErrVerifySentChanged if the pending subscriber no longer exists or
+// its VerifySentCount has changed.
+func (db *MemoryDb) RecordVerifySent(
+	_ context.Context, sub *Subscriber, prevCount int,
+) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	stored, ok := db.subscriber(sub.Email)
+	if !ok || stored.Status != SubscriberPending ||
+		stored.VerifySentCount != prevCount {
+		return ErrVerifySentChanged
+	}
+	db.unindexTags(sub.Email)
+	stored.VerifySentCount = sub.VerifySentCount
+	stored.VerifySentAt = sub.VerifySentAt
+	stored.Timestamp = sub.Timestamp
+	stored.Tags = slices.Clone(sub.Tags)
+	db.indexTags(stored)
+	return nil
+}
+
+func (db *MemoryDb) ProcessSubscribers(
+	ctx context.Context, status SubscriberStatus, sp SubscriberProcessor,
+) error {
+	return db.ProcessSubscribersFrom(ctx, status, nil, sp)
+}
+
+// ProcessSubscribersFrom processes subscribers following startKey.
+//
+// It processes subscribers in order of their email addresses. If startKey is
+// nil, processing begins with the first subscriber, just like
+// ProcessSubscribers.
+//
+// It processes a snapshot of the matching subscribers taken before processing
+// begins, so sp may safely call other MemoryDb methods. The snapshot omits
+// expired pending subscribers.
+func (db *MemoryDb) ProcessSubscribersFrom(
+	ctx context.Context,
+	status SubscriberStatus,
+	startKey *ScanKey,
+	sp SubscriberProcessor,
+) error {
+	return db.ProcessTaggedSubscribersFrom(ctx, status, nil, startKey, sp)
+}
+
+// ProcessTaggedSubscribersFrom processes subscribers matching filter following
+// startKey.
+//
+// It behaves like ProcessSubscribersFrom, but skips subscribers that don't
+// match filter. If filter provides IndexTags, it only examines the subscribers
+// with those tags.
+func (db *MemoryDb) ProcessTaggedSubscribersFrom(
+	_ context.Context,
+	status SubscriberStatus,
+	filter *TagFilter,
+	startKey *ScanKey,
+	sp SubscriberProcessor,
+) error {
+	for _, sub := range db.subscribersFrom(status, filter, startKey) {
+		if !sp.Process(sub) {
+			break
+		}
+	}
+	return nil
+}
+
+func (db *MemoryDb) subscribersFrom(
+	status SubscriberStatus, filter *TagFilter, startKey *ScanKey,
+) []*Subscriber {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	now := db.CurrentTime()
+	candidates := db.subscribers
+	if tags, ok := filter.IndexTags(); ok {
+		candidates = map[string]*Subscriber{}
+		for _, tag := range tags {
+			for email := range db.tagged[tag] {
+				candidates[email] = db.subscribers[email]
+			}
+		}
+	}
+	subs := make([]*Subscriber, 0, len(candidates))
+
+	for email, sub := range candidates {
+		if db.expired(sub, now) {
+			db.unindexTags(email)
+			delete(db.subscribers, email)
+			continue
+		} else if sub.Status != status || !filter.Matches(sub.Tags) {
+			continue
+		} else if startKey != nil && sub.Email <= startKey.Email {
+			continue
+		}
+		subs = append(subs, copySubscriber(sub))
+	}
+	slices.SortFunc(subs, func(lhs, rhs *Subscriber) int {
+		return strings.Compare(lhs.Email, rhs.Email)
+	})
+	return subs
+}
+
+func (db *MemoryDb) GetCheckpoint(
+	_ context.Context, campaignId string,
+) (checkpoint *SendCheckpoint, err error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if cp, ok := db.checkpoints[campaignId]; !ok {
+		err = ErrCheckpointNotFound
+	} else {
+		cpCopy := *cp
+		checkpoint = &cpCopy
+	}
+	return
+}
+
+func (db *MemoryDb) PutCheckpoint(
+	_ context.Context, checkpoint *SendCheckpoint,
+) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	cpCopy := *checkpoint
+	db.checkpoints[checkpoint.CampaignId] = &cpCopy
+	return nil
+}
+
+func (db *MemoryDb) GetCampaign(
+	_ context.Context, id string,
+) (campaign *Campaign, err error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if c, ok := db.campaigns[id]; !ok {
+		err = ErrCampaignNotFound
+	} else {
+		campaignCopy := *c
+		campaign = &campaignCopy
+	}
+	return
+}
+
+func (db *MemoryDb) PutCampaign(_ context.Context, campaign *Campaign) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	campaignCopy := *campaign
+	db.campaigns[campaign.Id] = &campaignCopy
+	return nil
+}
+
+func (db *MemoryDb) ListCampaigns(
+	_ context.Context,
+) (campaigns []*Campaign, err error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	campaigns = make([]*Campaign, 0, len(db.campaigns))
+
+	for _, c := range db.campaigns {
+		campaignCopy := *c
+		campaigns = append(campaigns, &campaignCopy)
+	}
+	return
+}
+
+func copyScheduledMessage(scheduled *ScheduledMessage) *ScheduledMessage {
+	scheduledCopy := *scheduled
+	msgCopy := *scheduled.Message
+	scheduledCopy.Message = &msgCopy
+	return &scheduledCopy
+}
+
+func (db *MemoryDb) PutScheduledMessage(
+	_ context.Context, scheduled *ScheduledMessage,
+) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	db.scheduled[scheduled.Id] = copyScheduledMessage(scheduled)
+	return nil
+}
+
+func (db *MemoryDb) GetDueMessages(
+	_ context.Context, now time.Time,
+) (due []*ScheduledMessage, err error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	due = make([]*ScheduledMessage, 0, 1)
+
+	for _, s := range db.scheduled {
+		if !s.SendAt.After(now) {
+			due = append(due, copyScheduledMessage(s))
+		}
+	}
+	return
+}
+
+func (db *MemoryDb) ClaimScheduledMessage(
+	_ context.Context, id string, now, until time.Time,
+) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	s, ok := db.scheduled[id]
+	if !ok || s.ClaimedUntil.After(now) {
+		return ErrScheduledMessageClaimed
+	}
+	s.ClaimedUntil = until
+	return nil
+}
+
+func (db *MemoryDb) ReleaseScheduledMessage(
+	_ context.Context, id string, until time.Time,
+) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if s, ok := db.scheduled[id]; ok && s.ClaimedUntil.Equal(until) {
+		s.ClaimedUntil = time.Time{}
+	}
+	return nil
+}
+
+func (db *MemoryDb) DeleteScheduledMessage(
+	_ context.Context, id string,
+) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	delete(db.scheduled, id)
+	return nil
+}
+
+func (db *MemoryDb) PutAuditEvent(_ context.Context, event *AuditEvent) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	eventCopy := *event
+	db.audit[event.Email] = append(db.audit[event.Email], &eventCopy)
+	return nil
+}
+
+func (db *MemoryDb) GetAuditEvents(
+	_ context.Context, email string,
+) (events []*AuditEvent, err error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	events = make([]*AuditEvent, 0, len(db.audit[email]))
+
+	for _, e := range db.audit[email] {
+		eventCopy := *e
+		events = append(events, &eventCopy)
+	}
+	return
+}
+
+func (db *MemoryDb) DeleteAuditEvents(_ context.Context, email string) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	delete(db.audit, email)
+	return nil
+}
+
+func (db *MemoryDb) GetTombstone(
+	_ context.Context, email string,
+) (tombstone *Tombstone, err error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if ts, ok := db.tombstones[email]; !ok {
+		err = ErrTombstoneNotFound
+	} else {
+		tombstoneCopy := *ts
+		tombstone = &tombstoneCopy
+	}
+	return
+}
+
+func (db *MemoryDb) PutTombstone(
+	_ context.Context, tombstone *Tombstone,
+) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	tombstoneCopy := *tombstone
+	db.tombstones[tombstone.Email] = &tombstoneCopy
+	return nil
+}
+
+func (db *MemoryDb) DeleteTombstone(_ context.Context, email string) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	delete(db.tombstones, email)
+	return nil
+}
+
+func (db *MemoryDb) ListTombstones(
+	_ context.Context,
+) (tombstones []*Tombstone, err error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	tombstones = make([]*Tombstone, 0, len(db.tombstones))
+
+	for _, ts := range db.tombstones {
+		tombstoneCopy := *ts
+		tombstones = append(tombstones, &tombstoneCopy)
+	}
+	return
+}
+
+func (db *MemoryDb) PutErasure(_ context.Context, hash string) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	db.erasures[hash] = true
+	return nil
+}
+
+func (db *MemoryDb) IsErased(_ context.Context, hash string) (bool, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	return db.erasures[hash], nil
+}
+
+func (db *MemoryDb) ListErasures(
+	_ context.Context,
+) (hashes []string, err error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	hashes = slices.Collect(maps.Keys(db.erasures))
+	return
+}
+
+func (db *MemoryDb) GetWelcomeMessage(
+	_ context.Context,
+) (msg *email.Message, err error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if db.welcome == nil {
+		err = ErrWelcomeMessageNotFound
+	} else {
+		msgCopy := *db.welcome
+		msg = &msgCopy
+	}
+	return
+}
+
+func (db *MemoryDb) PutWelcomeMessage(
+	_ context.Context, msg *email.Message,
+) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	msgCopy := *msg
+	db.welcome = &msgCopy
+	return nil
+}
+
+func (db *MemoryDb) DeleteWelcomeMessage(_ context.Context) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	db.welcome = nil
+	return nil
+}
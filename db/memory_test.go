@@ -0,0 +1,458 @@
+//go:build small_tests || all_tests
+
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mbland/elistman/email"
+	"github.com/mbland/elistman/testdata"
+	tu "github.com/mbland/elistman/testutils"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+// newTestMemoryDb returns a MemoryDb that expires pending subscribers relative
+// to testdata.TestTimestamp, so the pending TestSubscribers don't expire.
+func newTestMemoryDb() *MemoryDb {
+	memDb := NewMemoryDb()
+	memDb.CurrentTime = func() time.Time { return testdata.TestTimestamp }
+	return memDb
+}
+
+func newMemoryDbWithTestSubscribers(t *testing.T) *MemoryDb {
+	t.Helper()
+	memDb := newTestMemoryDb()
+
+	for _, sub := range TestSubscribers {
+		assert.NilError(t, memDb.Put(context.Background(), sub))
+	}
+	return memDb
+}
+
+func TestMemoryDbContract(t *testing.T) {
+	testDatabaseContract(t, NewMemoryDb(), 0)
+}
+
+func TestMemoryDbSubscribers(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("PutGetAndDeleteSucceed", func(t *testing.T) {
+		memDb := newTestMemoryDb()
+		sub := &Subscriber{
+			Email:      testdata.TestEmail,
+			Uid:        testdata.TestUid,
+			Status:     SubscriberPending,
+			Timestamp:  testdata.TestTimestamp,
+			FirstName:  "Mike",
+			Attributes: map[string]string{"Company": "EListMan"},
+		}
+
+		assert.NilError(t, memDb.Put(ctx, sub))
+		got, err := memDb.Get(ctx, testdata.TestEmail)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, sub, got)
+
+		assert.NilError(t, memDb.Delete(ctx, testdata.TestEmail))
+		_, err = memDb.Get(ctx, testdata.TestEmail)
+		assert.Assert(t, tu.ErrorIs(err, ErrSubscriberNotFound))
+
+		// Deleting a nonexistent subscriber doesn't fail, as with DynamoDb.
+		assert.NilError(t, memDb.Delete(ctx, testdata.TestEmail))
+	})
+
+	t.Run("StoresAndReturnsCopies", func(t *testing.T) {
+		memDb := newTestMemoryDb()
+		sub := &Subscriber{
+			Email:      testdata.TestEmail,
+			Status:     SubscriberVerified,
+			Attributes: map[string]string{"Company": "EListMan"},
+			Tags:       []string{"releases"},
+			Signup:     &SignupMetadata{Source: "footer"},
+		}
+		assert.NilError(t, memDb.Put(ctx, sub))
+
+		sub.Attributes["Company"] = "Acme"
+		sub.Tags[0] = "drafts"
+		sub.Signup.Source = "sidebar"
+		got, err := memDb.Get(ctx, testdata.TestEmail)
+		assert.NilError(t, err)
+		got.Status = SubscriberPending
+
+		got, err = memDb.Get(ctx, testdata.TestEmail)
+		assert.NilError(t, err)
+		assert.Equal(t, SubscriberVerified, got.Status)
+		assert.Equal(t, "EListMan", got.Attributes["Company"])
+		assert.DeepEqual(t, []string{"releases"}, got.Tags)
+		assert.Equal(t, "footer", got.Signup.Source)
+	})
+
+	t.Run("MarkReceived", func(t *testing.T) {
+		memDb := newMemoryDbWithTestSubscribers(t)
+		subEmail := TestVerifiedSubscribers[0].Email
+
+		assert.NilError(t, memDb.MarkReceived(ctx, subEmail, "campaign-0"))
+		assert.NilError(t, memDb.MarkReceived(ctx, subEmail, "campaign-0"))
+		assert.NilError(t, memDb.MarkReceived(ctx, subEmail, "campaign-1"))
+
+		expected := map[string]bool{"campaign-0": true, "campaign-1": true}
+		assert.DeepEqual(t, expected, memDb.receipts[subEmail])
+
+		assert.NilError(t, memDb.DeleteReceipts(ctx, subEmail))
+		assert.Assert(t, is.Len(memDb.receipts, 0))
+	})
+
+	t.Run("RotateUid", func(t *testing.T) {
+		memDb := newMemoryDbWithTestSubscribers(t)
+		original := TestVerifiedSubscribers[0]
+		expires := testdata.TestTimestamp.Add(time.Hour)
+
+		err := memDb.RotateUid(ctx, original.Email, testdata.TestUid, expires)
+
+		assert.NilError(t, err)
+		sub, err := memDb.Get(ctx, original.Email)
+		assert.NilError(t, err)
+		assert.Equal(t, testdata.TestUid, sub.Uid)
+		assert.Equal(t, original.Uid, sub.PreviousUid)
+		assert.Equal(t, expires, sub.PreviousUidExpires)
+		assert.Equal(t, uuid.Nil, original.PreviousUid)
+
+		err = memDb.RotateUid(ctx, "nobody@test.com", testdata.TestUid, expires)
+		assert.Assert(t, tu.ErrorIs(err, ErrSubscriberNotFound))
+	})
+
+	t.Run("ExpiresPendingSubscribers", func(t *testing.T) {
+		memDb := newMemoryDbWithTestSubscribers(t)
+		expires := testdata.TestTimestamp.Add(time.Hour)
+		memDb.CurrentTime = func() time.Time {
+			return testdata.TestTimestamp.Add(time.Hour * 72)
+		}
+
+		_, err := memDb.Get(ctx, "quux@test.com")
+		assert.Assert(t, tu.ErrorIs(err, ErrSubscriberNotFound))
+		err = memDb.RotateUid(ctx, "quux@test.com", testdata.TestUid, expires)
+		assert.Assert(t, tu.ErrorIs(err, ErrSubscriberNotFound))
+		assert.Assert(t, is.Len(memDb.subscribers, len(TestSubscribers)-1))
+
+		// A pending subscriber expires only after its Timestamp passes.
+		sub, err := memDb.Get(ctx, "xyzzy@test.com")
+		assert.NilError(t, err)
+		assert.Equal(t, SubscriberPending, sub.Status)
+	})
+
+	t.Run("ProcessSubscribersRemovesExpiredSubscribers", func(t *testing.T) {
+		memDb := newMemoryDbWithTestSubscribers(t)
+		memDb.CurrentTime = func() time.Time {
+			return testdata.TestTimestamp.Add(time.Hour * 100)
+		}
+		emails := []string{}
+		f := SubscriberFunc(func(sub *Subscriber) bool {
+			emails = append(emails, sub.Email)
+			return true
+		})
+
+		err := memDb.ProcessSubscribers(ctx, SubscriberVerified, f)
+
+		assert.NilError(t, err)
+		expected := []string{"bar@test.com", "baz@test.com", "foo@test.com"}
+		assert.DeepEqual(t, expected, emails)
+		assert.Assert(t, is.Len(memDb.subscribers, len(TestSubscribers)-2))
+
+		emails = []string{}
+		err = memDb.ProcessSubscribers(ctx, SubscriberPending, f)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, []string{"plugh@test.com"}, emails)
+	})
+}
+
+func TestMemoryDbProcessSubscribers(t *testing.T) {
+	ctx := context.Background()
+
+	setup := func(t *testing.T) (*MemoryDb, *[]string, SubscriberFunc) {
+		memDb := newMemoryDbWithTestSubscribers(t)
+		emails := []string{}
+		f := SubscriberFunc(func(sub *Subscriber) bool {
+			emails = append(emails, sub.Email)
+			return true
+		})
+		return memDb, &emails, f
+	}
+
+	t.Run("ProcessesSubscribersWithStatusInEmailOrder", func(t *testing.T) {
+		memDb, emails, f := setup(t)
+
+		err := memDb.ProcessSubscribers(ctx, SubscriberVerified, f)
+
+		assert.NilError(t, err)
+		expected := []string{"bar@test.com", "baz@test.com", "foo@test.com"}
+		assert.DeepEqual(t, expected, *emails)
+	})
+
+	t.Run("ResumesFromScanKey", func(t *testing.T) {
+		memDb, emails, f := setup(t)
+		startKey := &ScanKey{Email: "baz@test.com"}
+
+		err := memDb.ProcessSubscribersFrom(
+			ctx, SubscriberVerified, startKey, f,
+		)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, []string{"foo@test.com"}, *emails)
+	})
+
+	t.Run("StopsWhenProcessorReturnsFalse", func(t *testing.T) {
+		memDb, _, _ := setup(t)
+		emails := []string{}
+		f := SubscriberFunc(func(sub *Subscriber) bool {
+			emails = append(emails, sub.Email)
+			return len(emails) != 2
+		})
+
+		err := memDb.ProcessSubscribers(ctx, SubscriberPending, f)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, []string{"plugh@test.com", "quux@test.com"}, emails)
+	})
+
+	t.Run("ProcessorCanUpdateSubscribers", func(t *testing.T) {
+		memDb, _, _ := setup(t)
+		f := SubscriberFunc(func(sub *Subscriber) bool {
+			sub.FirstName = "Updated"
+			return memDb.Put(ctx, sub) == nil
+		})
+
+		err := memDb.ProcessSubscribers(ctx, SubscriberVerified, f)
+
+		assert.NilError(t, err)
+		sub, err := memDb.Get(ctx, "foo@test.com")
+		assert.NilError(t, err)
+		assert.Equal(t, "Updated", sub.FirstName)
+	})
+}
+
+func TestMemoryDbProcessTaggedSubscribers(t *testing.T) {
+	ctx := context.Background()
+
+	setup := func(t *testing.T) (*MemoryDb, *[]string, SubscriberFunc) {
+		memDb := newTestMemoryDb()
+		tags := map[string][]string{
+			"bar@test.com":   {"essays"},
+			"baz@test.com":   {"essays", "releases"},
+			"foo@test.com":   {"releases"},
+			"xyzzy@test.com": {"releases"},
+		}
+		for _, sub := range TestSubscribers {
+			tagged := *sub
+			tagged.Tags = tags[sub.Email]
+			assert.NilError(t, memDb.Put(ctx, &tagged))
+		}
+
+		emails := []string{}
+		f := SubscriberFunc(func(sub *Subscriber) bool {
+			emails = append(emails, sub.Email)
+			return true
+		})
+		return memDb, &emails, f
+	}
+
+	process := func(
+		t *testing.T, memDb *MemoryDb, expr string, f SubscriberFunc,
+	) {
+		t.Helper()
+		filter, err := ParseTagFilter(expr)
+		assert.NilError(t, err)
+
+		err = memDb.ProcessTaggedSubscribersFrom(
+			ctx, SubscriberVerified, filter, nil, f,
+		)
+		assert.NilError(t, err)
+	}
+
+	t.Run("ProcessesMatchingSubscribersInEmailOrder", func(t *testing.T) {
+		memDb, emails, f := setup(t)
+
+		process(t, memDb, "releases", f)
+
+		assert.DeepEqual(t, []string{"baz@test.com", "foo@test.com"}, *emails)
+	})
+
+	t.Run("ProcessesMatchingSubscribersWithoutIndexTags", func(t *testing.T) {
+		memDb, emails, f := setup(t)
+
+		process(t, memDb, "not releases", f)
+
+		assert.DeepEqual(t, []string{"bar@test.com"}, *emails)
+	})
+
+	t.Run("ResumesFromScanKey", func(t *testing.T) {
+		memDb, emails, f := setup(t)
+		filter, err := ParseTagFilter("essays")
+		assert.NilError(t, err)
+		startKey := &ScanKey{Email: "bar@test.com"}
+
+		err = memDb.ProcessTaggedSubscribersFrom(
+			ctx, SubscriberVerified, filter, startKey, f,
+		)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, []string{"baz@test.com"}, *emails)
+	})
+
+	t.Run("UpdatesIndexOnPutAndDelete", func(t *testing.T) {
+		memDb, emails, f := setup(t)
+		sub, err := memDb.Get(ctx, "foo@test.com")
+		assert.NilError(t, err)
+		sub.Tags = []string{"essays"}
+		assert.NilError(t, memDb.Put(ctx, sub))
+		assert.NilError(t, memDb.Delete(ctx, "baz@test.com"))
+
+		process(t, memDb, "releases or essays", f)
+
+		assert.DeepEqual(t, []string{"bar@test.com", "foo@test.com"}, *emails)
+		assert.Assert(t, is.Len(memDb.tagged["essays"], 2))
+		assert.DeepEqual(
+			t,
+			map[string]bool{"xyzzy@test.com": true},
+			memDb.tagged["releases"],
+		)
+	})
+}
+
+func TestMemoryDbCheckpointsAndCampaigns(t *testing.T) {
+	ctx := context.Background()
+	memDb := newTestMemoryDb()
+
+	_, err := memDb.GetCheckpoint(ctx, "campaign-0")
+	assert.Assert(t, tu.ErrorIs(err, ErrCheckpointNotFound))
+	_, err = memDb.GetCampaign(ctx, "campaign-0")
+	assert.Assert(t, tu.ErrorIs(err, ErrCampaignNotFound))
+
+	cp := &SendCheckpoint{CampaignId: "campaign-0", NumSent: 3}
+	assert.NilError(t, memDb.PutCheckpoint(ctx, cp))
+	gotCp, err := memDb.GetCheckpoint(ctx, "campaign-0")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, cp, gotCp)
+
+	campaign := &Campaign{Id: "campaign-0", Status: CampaignSending}
+	assert.NilError(t, memDb.PutCampaign(ctx, campaign))
+	gotCampaign, err := memDb.GetCampaign(ctx, "campaign-0")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, campaign, gotCampaign)
+
+	campaigns, err := memDb.ListCampaigns(ctx)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []*Campaign{campaign}, campaigns)
+}
+
+func TestMemoryDbAuditEvents(t *testing.T) {
+	ctx := context.Background()
+	memDb := newTestMemoryDb()
+	event := &AuditEvent{
+		Email:     "foo@test.com",
+		Operation: AuditSubscribe,
+		Source:    AuditSourceApi,
+		RequestId: "deadbeef",
+		Timestamp: testdata.TestTimestamp,
+	}
+
+	events, err := memDb.GetAuditEvents(ctx, "foo@test.com")
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(events))
+
+	assert.NilError(t, memDb.PutAuditEvent(ctx, event))
+	assert.NilError(t, memDb.PutAuditEvent(ctx, &AuditEvent{
+		Email: "bar@test.com", Operation: AuditImport,
+	}))
+	event.Source = "modified after put"
+
+	events, err = memDb.GetAuditEvents(ctx, "foo@test.com")
+	assert.NilError(t, err)
+	expected := *event
+	expected.Source = AuditSourceApi
+	assert.DeepEqual(t, []*AuditEvent{&expected}, events)
+
+	events[0].Source = "modified after get"
+	events, err = memDb.GetAuditEvents(ctx, "foo@test.com")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []*AuditEvent{&expected}, events)
+
+	assert.NilError(t, memDb.DeleteAuditEvents(ctx, "foo@test.com"))
+	events, err = memDb.GetAuditEvents(ctx, "foo@test.com")
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(events))
+	events, err = memDb.GetAuditEvents(ctx, "bar@test.com")
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(events))
+}
+
+func TestMemoryDbTombstones(t *testing.T) {
+	ctx := context.Background()
+	memDb := newTestMemoryDb()
+	ts := &Tombstone{
+		Email:     "foo@test.com",
+		Reason:    TombstoneUnsubscribe,
+		Timestamp: testdata.TestTimestamp,
+	}
+
+	_, err := memDb.GetTombstone(ctx, "foo@test.com")
+	assert.Assert(t, tu.ErrorIs(err, ErrTombstoneNotFound))
+
+	assert.NilError(t, memDb.PutTombstone(ctx, ts))
+	got, err := memDb.GetTombstone(ctx, "foo@test.com")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, ts, got)
+
+	got.Reason = TombstoneBounce
+	got, err = memDb.GetTombstone(ctx, "foo@test.com")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, ts, got)
+
+	assert.NilError(t, memDb.DeleteTombstone(ctx, "foo@test.com"))
+	_, err = memDb.GetTombstone(ctx, "foo@test.com")
+	assert.Assert(t, tu.ErrorIs(err, ErrTombstoneNotFound))
+	assert.NilError(t, memDb.DeleteTombstone(ctx, "foo@test.com"))
+}
+
+func TestMemoryDbErasures(t *testing.T) {
+	ctx := context.Background()
+	memDb := newTestMemoryDb()
+
+	erased, err := memDb.IsErased(ctx, "hash")
+	assert.NilError(t, err)
+	assert.Assert(t, !erased)
+
+	assert.NilError(t, memDb.PutErasure(ctx, "hash"))
+	erased, err = memDb.IsErased(ctx, "hash")
+	assert.NilError(t, err)
+	assert.Assert(t, erased)
+}
+
+func TestMemoryDbScheduledMessages(t *testing.T) {
+	ctx := context.Background()
+	memDb := newTestMemoryDb()
+	now := testdata.TestTimestamp
+	due := &ScheduledMessage{
+		Id: "due", SendAt: now, Message: &email.Message{Subject: "Due"},
+	}
+	notDue := &ScheduledMessage{
+		Id:      "not-due",
+		SendAt:  now.Add(time.Second),
+		Message: &email.Message{Subject: "Not due"},
+	}
+
+	assert.NilError(t, memDb.PutScheduledMessage(ctx, due))
+	assert.NilError(t, memDb.PutScheduledMessage(ctx, notDue))
+
+	msgs, err := memDb.GetDueMessages(ctx, now)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []*ScheduledMessage{due}, msgs)
+
+	assert.NilError(t, memDb.DeleteScheduledMessage(ctx, "due"))
+	msgs, err = memDb.GetDueMessages(ctx, now)
+	assert.NilError(t, err)
+	assert.Equal(t, 0, len(msgs))
+}
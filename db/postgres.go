@@ -0,0 +1,1240 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mbland/elistman/email"
+	"github.com/mbland/elistman/ops"
+)
+
+// PostgresClient is the subset of the pgx API that PostgresDb uses.
+//
+// Both *pgxpool.Pool and *pgx.Conn implement it, though only a pool is safe for
+// concurrent use. PostgresDb never holds a transaction open while calling back
+// into code that may use Client, so a single *pgx.Conn works otherwise.
+type PostgresClient interface {
+	Exec(
+		ctx context.Context, sql string, args ...any,
+	) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	BeginTx(ctx context.Context, opts pgx.TxOptions) (pgx.Tx, error)
+}
+
+// PostgresDb stores the records for one list in a set of PostgreSQL tables.
+//
+// TableName is the name of the subscribers table. Every other record type has
+// its own table named TableName plus a suffix, such as "_checkpoints". Every
+// table has a "list" column that's part of its primary key, so records for
+// multiple lists can share the same tables without colliding. If List is
+// empty, PostgresDb stores records for the default list. See ForList.
+//
+// PostgreSQL has no equivalent of DynamoDB's Time To Live feature. Instead,
+// every query ignores pending subscribers whose Timestamp has passed, and
+// ExpireSubscribers deletes them. RunExpiryJob calls ExpireSubscribers
+// periodically.
+type PostgresDb struct {
+	Client    PostgresClient
+	TableName string
+	List      string
+
+	// CurrentTime returns the time used to expire pending subscribers.
+	// NewPostgresDb sets it to time.Now.
+	CurrentTime func() time.Time
+}
+
+func NewPostgresDb(client PostgresClient, tableName string) *PostgresDb {
+	return &PostgresDb{
+		Client: client, TableName: tableName, CurrentTime: time.Now,
+	}
+}
+
+// ForList returns a PostgresDb for the named list using the same client and
+// tables.
+func (db *PostgresDb) ForList(list string) *PostgresDb {
+	listDb := *db
+	listDb.List = list
+	return &listDb
+}
+
+// Suffixes appended to PostgresDb.TableName to name the tables for records
+// other than subscribers.
+const (
+	PostgresReceiptsSuffix    = "_receipts"
+	PostgresCheckpointsSuffix = "_checkpoints"
+	PostgresCampaignsSuffix   = "_campaigns"
+	PostgresScheduledSuffix   = "_scheduled"
+	PostgresAuditSuffix       = "_audit"
+	PostgresTombstonesSuffix  = "_tombstones"
+	PostgresErasuresSuffix    = "_erasures"
+	PostgresWelcomeSuffix     = "_welcome"
+)
+
+// postgresTableSuffixes contains the suffix of every table, including the
+// empty suffix of the subscribers table.
+var postgresTableSuffixes = []string{
+	"",
+	PostgresReceiptsSuffix,
+	PostgresCheckpointsSuffix,
+	PostgresCampaignsSuffix,
+	PostgresScheduledSuffix,
+	PostgresAuditSuffix,
+	PostgresTombstonesSuffix,
+	PostgresErasuresSuffix,
+	PostgresWelcomeSuffix,
+}
+
+// table returns the quoted name of the table with the given suffix.
+func (db *PostgresDb) table(suffix string) string {
+	return pgx.Identifier{db.TableName + suffix}.Sanitize()
+}
+
+// query replaces every "{table}" and "{table_suffix}" in sql with the quoted
+// name of the corresponding table.
+func (db *PostgresDb) query(sql string) string {
+	replacements := make([]string, 0, len(postgresTableSuffixes)*2)
+	for _, suffix := range postgresTableSuffixes {
+		replacements = append(
+			replacements, "{table"+suffix+"}", db.table(suffix),
+		)
+	}
+	return strings.NewReplacer(replacements...).Replace(sql)
+}
+
+// postgresSchema creates every table and index.
+//
+// The subscribers table's status_time column holds Subscriber.Timestamp. The
+// partial index on status_time for pending subscribers keeps ExpireSubscribers
+// from scanning the verified subscribers. The GIN index on tags enables
+// PostgreSQL to use the index for the "@>" operator that tag filters use.
+const postgresSchema = `
+CREATE TABLE {table} (
+	list text NOT NULL DEFAULT '',
+	email text NOT NULL,
+	uid uuid NOT NULL,
+	status text NOT NULL CHECK (status IN ('pending', 'verified')),
+	status_time timestamptz NOT NULL,
+	first_name text NOT NULL DEFAULT '',
+	attributes jsonb,
+	tags text[] NOT NULL DEFAULT '{}',
+	signup jsonb,
+	verify_sent_count integer NOT NULL DEFAULT 0,
+	verify_sent_at timestamptz,
+	previous_uid uuid,
+	previous_uid_expires timestamptz,
+	PRIMARY KEY (list, email)
+);
+CREATE INDEX ON {table} (list, status, email);
+CREATE INDEX ON {table} (status_time) WHERE status = 'pending';
+CREATE INDEX ON {table} USING GIN (tags);
+
+CREATE TABLE {table_receipts} (
+	list text NOT NULL DEFAULT '',
+	email text NOT NULL,
+	campaign_id text NOT NULL,
+	PRIMARY KEY (list, email, campaign_id)
+);
+
+CREATE TABLE {table_checkpoints} (
+	list text NOT NULL DEFAULT '',
+	campaign_id text NOT NULL,
+	message_hash text NOT NULL,
+	tag_filter text NOT NULL DEFAULT '',
+	last_email text,
+	last_timestamp timestamptz,
+	num_sent integer NOT NULL,
+	complete boolean NOT NULL,
+	updated timestamptz NOT NULL,
+	PRIMARY KEY (list, campaign_id)
+);
+
+CREATE TABLE {table_campaigns} (
+	list text NOT NULL DEFAULT '',
+	campaign_id text NOT NULL,
+	subject text NOT NULL,
+	message_hash text NOT NULL,
+	tag_filter text NOT NULL DEFAULT '',
+	started timestamptz NOT NULL,
+	finished timestamptz,
+	num_sent integer NOT NULL,
+	num_failed integer NOT NULL,
+	status text NOT NULL,
+	PRIMARY KEY (list, campaign_id)
+);
+
+CREATE TABLE {table_scheduled} (
+	list text NOT NULL DEFAULT '',
+	scheduled_id text NOT NULL,
+	send_at timestamptz NOT NULL,
+	claimed_until timestamptz,
+	message jsonb NOT NULL,
+	PRIMARY KEY (list, scheduled_id)
+);
+
+CREATE TABLE {table_audit} (
+	id bigint GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+	list text NOT NULL DEFAULT '',
+	email text NOT NULL,
+	operation text NOT NULL,
+	reason text NOT NULL DEFAULT '',
+	source text NOT NULL DEFAULT '',
+	request_id text NOT NULL DEFAULT '',
+	event_time timestamptz NOT NULL
+);
+CREATE INDEX ON {table_audit} (list, email);
+
+CREATE TABLE {table_tombstones} (
+	list text NOT NULL DEFAULT '',
+	email text NOT NULL,
+	reason text NOT NULL,
+	removed timestamptz NOT NULL,
+	PRIMARY KEY (list, email)
+);
+
+CREATE TABLE {table_erasures} (
+	list text NOT NULL DEFAULT '',
+	hash text NOT NULL,
+	PRIMARY KEY (list, hash)
+);
+
+CREATE TABLE {table_welcome} (
+	list text NOT NULL DEFAULT '' PRIMARY KEY,
+	message jsonb NOT NULL
+);
+`
+
+// CreateSubscribersTable creates the subscribers table and every other table
+// PostgresDb uses.
+//
+// It creates every table within a single transaction, so it either creates all
+// of them or none of them.
+func (db *PostgresDb) CreateSubscribersTable(ctx context.Context) (err error) {
+	var tx pgx.Tx
+
+	if tx, err = db.Client.BeginTx(ctx, pgx.TxOptions{}); err == nil {
+		defer tx.Rollback(ctx)
+
+		if _, err = tx.Exec(ctx, db.query(postgresSchema)); err == nil {
+			err = tx.Commit(ctx)
+		}
+	}
+
+	if err != nil {
+		const errFmt = "failed to create subscribers table \"%s\""
+		err = postgresError(fmt.Sprintf(errFmt, db.TableName), err)
+	}
+	return
+}
+
+// DeleteTables drops every table PostgresDb uses.
+func (db *PostgresDb) DeleteTables(ctx context.Context) (err error) {
+	tables := make([]string, len(postgresTableSuffixes))
+	for i, suffix := range postgresTableSuffixes {
+		tables[i] = db.table(suffix)
+	}
+	sql := "DROP TABLE " + strings.Join(tables, ", ")
+
+	if _, err = db.Client.Exec(ctx, sql); err != nil {
+		err = postgresError("failed to delete db tables "+db.TableName, err)
+	}
+	return
+}
+
+// postgresError adds prefix to err, and wraps err with ops.ErrExternal unless
+// PostgreSQL rejected the request because of a problem with the request itself.
+//
+// The SQLSTATE classes that postgresTransientClasses contains indicate
+// problems with the connection or the server, so retrying the request later may
+// succeed. Errors that don't come from PostgreSQL at all, such as network
+// errors, are also external.
+//
+// https://www.postgresql.org/docs/current/errcodes-appendix.html
+func postgresError(prefix string, err error) error {
+	var pgErr *pgconn.PgError
+
+	if errors.As(err, &pgErr) && !postgresTransientClasses[pgErr.Code[:2]] {
+		return fmt.Errorf("%s: %s", prefix, err)
+	}
+	return fmt.Errorf("%s: %w: %s", prefix, ops.ErrExternal, err)
+}
+
+var postgresTransientClasses = map[string]bool{
+	"08": true, // Connection Exception
+	"40": true, // Transaction Rollback
+	"53": true, // Insufficient Resources
+	"57": true, // Operator Intervention
+	"58": true, // System Error
+}
+
+// notExpired returns a condition excluding pending subscribers whose
+// status_time is before the current time, passed as parameter number param.
+func notExpired(param int) string {
+	const condFmt = "(status <> 'pending' OR status_time >= $%d)"
+	return fmt.Sprintf(condFmt, param)
+}
+
+// postgresSubscriberColumns are the subscribers table columns that
+// scanSubscriber expects, in order.
+const postgresSubscriberColumns = "email, uid, list, status, status_time, " +
+	"first_name, attributes, tags, signup, verify_sent_count, " +
+	"verify_sent_at, previous_uid, previous_uid_expires"
+
+func scanSubscriber(row pgx.Row) (subscriber *Subscriber, err error) {
+	sub := &Subscriber{}
+	var status string
+	var verifySentAt, previousUidExpires *time.Time
+	var previousUid *uuid.UUID
+
+	err = row.Scan(
+		&sub.Email,
+		&sub.Uid,
+		&sub.List,
+		&status,
+		&sub.Timestamp,
+		&sub.FirstName,
+		&sub.Attributes,
+		&sub.Tags,
+		&sub.Signup,
+		&sub.VerifySentCount,
+		&verifySentAt,
+		&previousUid,
+		&previousUidExpires,
+	)
+	if err != nil {
+		return
+	}
+
+	// Empty arrays and maps read back as empty, not nil, but Subscribers
+	// elsewhere always use nil.
+	sub.Status = SubscriberStatus(status)
+	sub.Tags = nilIfEmpty(sub.Tags)
+	if len(sub.Attributes) == 0 {
+		sub.Attributes = nil
+	}
+	sub.VerifySentAt = timeOrZero(verifySentAt)
+	if previousUid != nil {
+		sub.PreviousUid = *previousUid
+		sub.PreviousUidExpires = timeOrZero(previousUidExpires)
+	}
+	return sub, nil
+}
+
+func nilIfEmpty(values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	return values
+}
+
+// nonNil returns an empty slice if values is nil, since pgx stores a nil slice
+// as NULL.
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+// nullTime returns nil if t is the zero time, so it's stored as NULL.
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func timeOrZero(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
+}
+
+func (db *PostgresDb) Get(
+	ctx context.Context, email string,
+) (subscriber *Subscriber, err error) {
+	sql := db.query("SELECT " + postgresSubscriberColumns +
+		" FROM {table} WHERE list = $1 AND email = $2 AND " + notExpired(3))
+	row := db.Client.QueryRow(ctx, sql, db.List, email, db.CurrentTime())
+
+	if subscriber, err = scanSubscriber(row); err == nil {
+		return
+	} else if errors.Is(err, pgx.ErrNoRows) {
+		err = ErrSubscriberNotFound
+	} else {
+		err = postgresError("failed to get "+email, err)
+	}
+	return
+}
+
+// Put stores sub as a subscriber to db.List, regardless of sub.List.
+func (db *PostgresDb) Put(ctx context.Context, sub *Subscriber) (err error) {
+	const sql = `INSERT INTO {table} (` + postgresSubscriberColumns + `)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+ON CONFLICT (list, email) DO UPDATE SET
+	uid = EXCLUDED.uid,
+	status = EXCLUDED.status,
+	status_time = EXCLUDED.status_time,
+	first_name = EXCLUDED.first_name,
+	attributes = EXCLUDED.attributes,
+	tags = EXCLUDED.tags,
+	signup = EXCLUDED.signup,
+	verify_sent_count = EXCLUDED.verify_sent_count,
+	verify_sent_at = EXCLUDED.verify_sent_at,
+	previous_uid = EXCLUDED.previous_uid,
+	previous_uid_expires = EXCLUDED.previous_uid_expires`
+
+	var attributes map[string]string
+	var previousUid *uuid.UUID
+	var previousUidExpires *time.Time
+
+	if len(sub.Attributes) != 0 {
+		attributes = sub.Attributes
+	}
+	if sub.PreviousUid != uuid.Nil {
+		previousUid = &sub.PreviousUid
+		previousUidExpires = nullTime(sub.PreviousUidExpires)
+	}
+
+	_, err = db.Client.Exec(
+		ctx,
+		db.query(sql),
+		sub.Email,
+		sub.Uid,
+		db.List,
+		string(sub.Status),
+		sub.Timestamp,
+		sub.FirstName,
+		attributes,
+		nonNil(sub.Tags),
+		sub.Signup,
+		sub.VerifySentCount,
+		nullTime(sub.VerifySentAt),
+		previousUid,
+		previousUidExpires,
+	)
+	if err != nil {
+		err = postgresError("failed to put "+sub.Email, err)
+	}
+	return
+}
+
+func (db *PostgresDb) Delete(ctx context.Context, email string) (err error) {
+	sql := db.query("DELETE FROM {table} WHERE list = $1 AND email = $2")
+
+	if _, err = db.Client.Exec(ctx, sql, db.List, email); err != nil {
+		err = postgresError("failed to delete "+email, err)
+	}
+	return
+}
+
+// MarkReceived records that email received campaignId.
+func (db *PostgresDb) MarkReceived(
+	ctx context.Context, email, campaignId string,
+) (err error) {
+	sql := db.query(`INSERT INTO {table_receipts} (list, email, campaign_id)
+	VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`)
+
+	_, err = db.Client.Exec(ctx, sql, db.List, email, campaignId)
+	if err != nil {
+		const errFmt = "failed to mark %s as received by %s"
+		err = postgresError(fmt.Sprintf(errFmt, campaignId, email), err)
+	}
+	return
+}
+
+func (db *PostgresDb) HasReceived(
+	ctx context.Context, email, campaignId string,
+) (received bool, err error) {
+	sql := db.query(`SELECT EXISTS (
+		SELECT 1 FROM {table_receipts}
+		WHERE list = $1 AND email = $2 AND campaign_id = $3
+	)`)
+
+	row := db.Client.QueryRow(ctx, sql, db.List, email, campaignId)
+	if err = row.Scan(&received); err != nil {
+		const errFmt = "failed to check if %s received %s"
+		err = postgresError(fmt.Sprintf(errFmt, email, campaignId), err)
+	}
+	return
+}
+
+func (db *PostgresDb) DeleteReceipts(
+	ctx context.Context, email string,
+) (err error) {
+	sql := db.query(
+		"DELETE FROM {table_receipts} WHERE list = $1 AND email = $2",
+	)
+
+	if _, err = db.Client.Exec(ctx, sql, db.List, email); err != nil {
+		err = postgresError("failed to delete receipts for "+email, err)
+	}
+	return
+}
+
+// RotateUid replaces a subscriber's Uid with uid, keeping the current Uid as
+// the PreviousUid until expires.
+//
+// It updates the record in place, so it doesn't overwrite any concurrent
+// changes to other columns. It returns ErrSubscriberNotFound if the subscriber
+// no longer exists, instead of creating a new record.
+func (db *PostgresDb) RotateUid(
+	ctx context.Context, email string, uid uuid.UUID, expires time.Time,
+) (err error) {
+	// PostgreSQL evaluates uid on the right hand side before updating it.
+	sql := db.query(`UPDATE {table}
+	SET previous_uid = uid, previous_uid_expires = $3, uid = $4
+	WHERE list = $1 AND email = $2 AND ` + notExpired(5))
+	var tag pgconn.CommandTag
+
+	tag, err = db.Client.Exec(
+		ctx, sql, db.List, email, expires, uid, db.CurrentTime(),
+	)
+	if err != nil {
+		err = postgresError("failed to rotate UID for "+email, err)
+	} else if tag.RowsAffected() == 0 {
+		err = ErrSubscriberNotFound
+	}
+	return
+}
+
+// RecordVerifySent updates sub's verification email columns, Timestamp, and
+// tags if its stored VerifySentCount is prevCount.
+//
+// It updates the record in place, so it doesn't overwrite any concurrent
+// changes to other columns. It returns ErrVerifySentChanged if the pending
+// subscriber no longer exists or its VerifySentCount has changed.
+func (db *PostgresDb) RecordVerifySent(
+	ctx context.Context, sub *Subscriber, prevCount int,
+) (err error) {
+	sql := db.query(`UPDATE {table}
+	SET verify_sent_count = $3, verify_sent_at = $4, status_time = $5,
+		tags = $6
+	WHERE list = $1 AND email = $2 AND status = 'pending'
+		AND verify_sent_count = $7 AND status_time >= $8`)
+	var tag pgconn.CommandTag
+
+	tag, err = db.Client.Exec(
+		ctx,
+		sql,
+		db.List,
+		sub.Email,
+		sub.VerifySentCount,
+		nullTime(sub.VerifySentAt),
+		sub.Timestamp,
+		nonNil(sub.Tags),
+		prevCount,
+		db.CurrentTime(),
+	)
+	if err != nil {
+		prefix := "failed to record verification email to " + sub.Email
+		err = postgresError(prefix, err)
+	} else if tag.RowsAffected() == 0 {
+		err = ErrVerifySentChanged
+	}
+	return
+}
+
+func (db *PostgresDb) ProcessSubscribers(
+	ctx context.Context, status SubscriberStatus, sp SubscriberProcessor,
+) error {
+	return db.ProcessSubscribersFrom(ctx, status, nil, sp)
+}
+
+// ProcessSubscribersFrom processes subscribers following startKey.
+//
+// If startKey is nil, processing begins with the first subscriber, just like
+// ProcessSubscribers.
+func (db *PostgresDb) ProcessSubscribersFrom(
+	ctx context.Context,
+	status SubscriberStatus,
+	startKey *ScanKey,
+	sp SubscriberProcessor,
+) error {
+	return db.ProcessTaggedSubscribersFrom(ctx, status, nil, startKey, sp)
+}
+
+// postgresFetchSize is the number of subscribers that
+// ProcessTaggedSubscribersFrom fetches per query.
+const postgresFetchSize = 100
+
+// ProcessTaggedSubscribersFrom processes subscribers matching filter following
+// startKey.
+//
+// It behaves like ProcessSubscribersFrom, but PostgreSQL filters out
+// subscribers that don't match filter. A nil filter matches every subscriber.
+//
+// It processes subscribers in order of their email addresses, fetching
+// postgresFetchSize subscribers per query, so it never holds more than that
+// many in memory. Each query starts after the last subscriber of the previous
+// batch, and it processes each batch only after reading all of it. It holds
+// no transaction or cursor open while calling sp, so sp may safely call other
+// PostgresDb methods, even when Client is a single *pgx.Conn.
+func (db *PostgresDb) ProcessTaggedSubscribersFrom(
+	ctx context.Context,
+	status SubscriberStatus,
+	filter *TagFilter,
+	startKey *ScanKey,
+	sp SubscriberProcessor,
+) (err error) {
+	var subs []*Subscriber
+
+	for {
+		query, args := db.subscribersQuery(status, filter, startKey)
+		query += fmt.Sprintf(" LIMIT %d", postgresFetchSize)
+
+		if subs, err = db.fetchSubscribers(ctx, query, args); err != nil {
+			prefix := fmt.Sprintf("failed to get %s subscribers", status)
+			return postgresError(prefix, err)
+		}
+		for _, sub := range subs {
+			if !sp.Process(sub) {
+				return
+			}
+		}
+		if len(subs) < postgresFetchSize {
+			return
+		}
+		startKey = subs[len(subs)-1].ScanKey()
+	}
+}
+
+// fetchSubscribers reads every row of the query result before returning, which
+// releases the connection before the caller processes the subscribers.
+func (db *PostgresDb) fetchSubscribers(
+	ctx context.Context, query string, args []any,
+) (subs []*Subscriber, err error) {
+	rows, err := db.Client.Query(ctx, query, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	subs = make([]*Subscriber, 0, postgresFetchSize)
+	for rows.Next() {
+		var sub *Subscriber
+		if sub, err = scanSubscriber(rows); err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+	return subs, rows.Err()
+}
+
+// subscribersQuery returns the query for the subscribers that
+// ProcessTaggedSubscribersFrom processes, and the arguments for its parameters.
+func (db *PostgresDb) subscribersQuery(
+	status SubscriberStatus, filter *TagFilter, startKey *ScanKey,
+) (query string, args []any) {
+	args = []any{db.List, string(status), db.CurrentTime()}
+	param := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	conditions := []string{"list = $1", "status = $2", notExpired(3)}
+
+	if startKey != nil {
+		conditions = append(conditions, "email > "+param(startKey.Email))
+	}
+	if filter != nil {
+		conditions = append(
+			conditions,
+			tagFilterSql(filter.root, func(tag string) string {
+				return param(tag)
+			}),
+		)
+	}
+	query = db.query("SELECT " + postgresSubscriberColumns +
+		" FROM {table} WHERE " + strings.Join(conditions, " AND ") +
+		" ORDER BY email")
+	return
+}
+
+// tagFilterSql returns the SQL condition for the tag filter expression tree
+// rooted at n.
+//
+// It uses the "@>" (contains) operator for each tag, since the GIN index on the
+// tags column supports it.
+func tagFilterSql(n *tagNode, param func(tag string) string) string {
+	switch n.op {
+	case tagLeaf:
+		return "tags @> ARRAY[" + param(n.tag) + "::text]"
+	case tagNot:
+		return "(NOT " + tagFilterSql(n.operands[0], param) + ")"
+	}
+
+	keyword := " AND "
+	if n.op == tagOr {
+		keyword = " OR "
+	}
+	operands := make([]string, len(n.operands))
+	for i, operand := range n.operands {
+		operands[i] = tagFilterSql(operand, param)
+	}
+	return "(" + strings.Join(operands, keyword) + ")"
+}
+
+// ExpireSubscribers deletes every pending subscriber whose Timestamp has
+// passed, from every list, and returns the number deleted.
+//
+// It takes the place of DynamoDB's Time To Live feature. Other PostgresDb
+// methods ignore expired subscribers whether or not ExpireSubscribers has
+// deleted them yet.
+func (db *PostgresDb) ExpireSubscribers(
+	ctx context.Context,
+) (numExpired int64, err error) {
+	sql := db.query(
+		"DELETE FROM {table} WHERE status = 'pending' AND status_time < $1",
+	)
+	var tag pgconn.CommandTag
+
+	if tag, err = db.Client.Exec(ctx, sql, db.CurrentTime()); err != nil {
+		err = postgresError("failed to expire pending subscribers", err)
+	} else {
+		numExpired = tag.RowsAffected()
+	}
+	return
+}
+
+// RunExpiryJob calls ExpireSubscribers immediately, then again after every
+// interval, until ctx is done.
+//
+// It logs the result of every call that expires subscribers or fails, and
+// keeps running after failures. It's meant to run in its own goroutine.
+func (db *PostgresDb) RunExpiryJob(
+	ctx context.Context, interval time.Duration, logger *log.Logger,
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := db.ExpireSubscribers(ctx); err != nil {
+			logger.Printf("ERROR: %s", err)
+		} else if n != 0 {
+			logger.Printf("expired %d pending subscribers", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (db *PostgresDb) GetCheckpoint(
+	ctx context.Context, campaignId string,
+) (checkpoint *SendCheckpoint, err error) {
+	sql := db.query(`SELECT campaign_id, message_hash, tag_filter, last_email,
+		last_timestamp, num_sent, complete, updated
+	FROM {table_checkpoints} WHERE list = $1 AND campaign_id = $2`)
+	cp := &SendCheckpoint{}
+	var lastEmail *string
+	var lastTimestamp *time.Time
+
+	err = db.Client.QueryRow(ctx, sql, db.List, campaignId).Scan(
+		&cp.CampaignId,
+		&cp.MessageHash,
+		&cp.TagFilter,
+		&lastEmail,
+		&lastTimestamp,
+		&cp.NumSent,
+		&cp.Complete,
+		&cp.Timestamp,
+	)
+	if err == nil {
+		if lastEmail != nil {
+			cp.LastKey = &ScanKey{
+				Email: *lastEmail, Timestamp: timeOrZero(lastTimestamp),
+			}
+		}
+		checkpoint = cp
+	} else if errors.Is(err, pgx.ErrNoRows) {
+		err = ErrCheckpointNotFound
+	} else {
+		err = postgresError("failed to get checkpoint "+campaignId, err)
+	}
+	return
+}
+
+func (db *PostgresDb) PutCheckpoint(
+	ctx context.Context, checkpoint *SendCheckpoint,
+) (err error) {
+	const sql = `INSERT INTO {table_checkpoints} (list, campaign_id,
+		message_hash, tag_filter, last_email, last_timestamp, num_sent,
+		complete, updated)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	ON CONFLICT (list, campaign_id) DO UPDATE SET
+		message_hash = EXCLUDED.message_hash,
+		tag_filter = EXCLUDED.tag_filter,
+		last_email = EXCLUDED.last_email,
+		last_timestamp = EXCLUDED.last_timestamp,
+		num_sent = EXCLUDED.num_sent,
+		complete = EXCLUDED.complete,
+		updated = EXCLUDED.updated`
+	var lastEmail *string
+	var lastTimestamp *time.Time
+
+	if lastKey := checkpoint.LastKey; lastKey != nil {
+		lastEmail = &lastKey.Email
+		lastTimestamp = &lastKey.Timestamp
+	}
+
+	_, err = db.Client.Exec(
+		ctx,
+		db.query(sql),
+		db.List,
+		checkpoint.CampaignId,
+		checkpoint.MessageHash,
+		checkpoint.TagFilter,
+		lastEmail,
+		lastTimestamp,
+		checkpoint.NumSent,
+		checkpoint.Complete,
+		checkpoint.Timestamp,
+	)
+	if err != nil {
+		prefix := "failed to put checkpoint " + checkpoint.CampaignId
+		err = postgresError(prefix, err)
+	}
+	return
+}
+
+// postgresCampaignColumns are the campaigns table columns that scanCampaign
+// expects, in order.
+const postgresCampaignColumns = "campaign_id, subject, message_hash, " +
+	"tag_filter, started, finished, num_sent, num_failed, status"
+
+func scanCampaign(row pgx.Row) (campaign *Campaign, err error) {
+	c := &Campaign{}
+	var finished *time.Time
+	var status string
+
+	err = row.Scan(
+		&c.Id,
+		&c.Subject,
+		&c.MessageHash,
+		&c.TagFilter,
+		&c.StartTime,
+		&finished,
+		&c.NumSent,
+		&c.NumFailed,
+		&status,
+	)
+	if err == nil {
+		c.FinishTime = timeOrZero(finished)
+		c.Status = CampaignStatus(status)
+		campaign = c
+	}
+	return
+}
+
+func (db *PostgresDb) GetCampaign(
+	ctx context.Context, id string,
+) (campaign *Campaign, err error) {
+	sql := db.query("SELECT " + postgresCampaignColumns +
+		" FROM {table_campaigns} WHERE list = $1 AND campaign_id = $2")
+	row := db.Client.QueryRow(ctx, sql, db.List, id)
+
+	if campaign, err = scanCampaign(row); err == nil {
+		return
+	} else if errors.Is(err, pgx.ErrNoRows) {
+		err = ErrCampaignNotFound
+	} else {
+		err = postgresError("failed to get campaign "+id, err)
+	}
+	return
+}
+
+func (db *PostgresDb) PutCampaign(
+	ctx context.Context, campaign *Campaign,
+) (err error) {
+	const sql = `INSERT INTO {table_campaigns} (list, ` +
+		postgresCampaignColumns + `)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	ON CONFLICT (list, campaign_id) DO UPDATE SET
+		subject = EXCLUDED.subject,
+		message_hash = EXCLUDED.message_hash,
+		tag_filter = EXCLUDED.tag_filter,
+		started = EXCLUDED.started,
+		finished = EXCLUDED.finished,
+		num_sent = EXCLUDED.num_sent,
+		num_failed = EXCLUDED.num_failed,
+		status = EXCLUDED.status`
+
+	_, err = db.Client.Exec(
+		ctx,
+		db.query(sql),
+		db.List,
+		campaign.Id,
+		campaign.Subject,
+		campaign.MessageHash,
+		campaign.TagFilter,
+		campaign.StartTime,
+		nullTime(campaign.FinishTime),
+		campaign.NumSent,
+		campaign.NumFailed,
+		string(campaign.Status),
+	)
+	if err != nil {
+		err = postgresError("failed to put campaign "+campaign.Id, err)
+	}
+	return
+}
+
+func (db *PostgresDb) ListCampaigns(
+	ctx context.Context,
+) (campaigns []*Campaign, err error) {
+	sql := db.query("SELECT " + postgresCampaignColumns +
+		" FROM {table_campaigns} WHERE list = $1")
+	var rows pgx.Rows
+
+	if rows, err = db.Client.Query(ctx, sql, db.List); err == nil {
+		campaigns, err = pgx.CollectRows(
+			rows,
+			func(row pgx.CollectableRow) (*Campaign, error) {
+				return scanCampaign(row)
+			},
+		)
+	}
+	if err != nil {
+		return nil, postgresError("failed to list campaigns", err)
+	}
+	return
+}
+
+func (db *PostgresDb) PutScheduledMessage(
+	ctx context.Context, scheduled *ScheduledMessage,
+) (err error) {
+	const sql = `INSERT INTO {table_scheduled} (list, scheduled_id, send_at,
+		claimed_until, message)
+	VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (list, scheduled_id) DO UPDATE SET
+		send_at = EXCLUDED.send_at,
+		claimed_until = EXCLUDED.claimed_until,
+		message = EXCLUDED.message`
+
+	_, err = db.Client.Exec(
+		ctx,
+		db.query(sql),
+		db.List,
+		scheduled.Id,
+		scheduled.SendAt,
+		nullTime(scheduled.ClaimedUntil),
+		scheduled.Message,
+	)
+	if err != nil {
+		prefix := "failed to put scheduled message " + scheduled.Id
+		err = postgresError(prefix, err)
+	}
+	return
+}
+
+func (db *PostgresDb) GetDueMessages(
+	ctx context.Context, now time.Time,
+) (due []*ScheduledMessage, err error) {
+	sql := db.query(`SELECT scheduled_id, send_at, claimed_until, message
+	FROM {table_scheduled} WHERE list = $1 AND send_at <= $2`)
+	var rows pgx.Rows
+
+	if rows, err = db.Client.Query(ctx, sql, db.List, now); err == nil {
+		due, err = pgx.CollectRows(
+			rows,
+			func(row pgx.CollectableRow) (*ScheduledMessage, error) {
+				s := &ScheduledMessage{}
+				var claimedUntil *time.Time
+				err := row.Scan(&s.Id, &s.SendAt, &claimedUntil, &s.Message)
+				s.ClaimedUntil = timeOrZero(claimedUntil)
+				return s, err
+			},
+		)
+	}
+	if err != nil {
+		return nil, postgresError("failed to get due messages", err)
+	}
+	return
+}
+
+// ClaimScheduledMessage sets the claimed_until column only if the current claim
+// has expired, so PostgreSQL ensures that only one concurrent caller succeeds.
+func (db *PostgresDb) ClaimScheduledMessage(
+	ctx context.Context, id string, now, until time.Time,
+) (err error) {
+	sql := db.query(`UPDATE {table_scheduled} SET claimed_until = $4
+	WHERE list = $1 AND scheduled_id = $2 AND
+		(claimed_until IS NULL OR claimed_until <= $3)`)
+	var tag pgconn.CommandTag
+
+	tag, err = db.Client.Exec(ctx, sql, db.List, id, now, until)
+	if err != nil {
+		err = postgresError("failed to claim scheduled message "+id, err)
+	} else if tag.RowsAffected() == 0 {
+		err = ErrScheduledMessageClaimed
+	}
+	return
+}
+
+func (db *PostgresDb) ReleaseScheduledMessage(
+	ctx context.Context, id string, until time.Time,
+) (err error) {
+	sql := db.query(`UPDATE {table_scheduled} SET claimed_until = NULL
+	WHERE list = $1 AND scheduled_id = $2 AND claimed_until = $3`)
+
+	if _, err = db.Client.Exec(ctx, sql, db.List, id, until); err != nil {
+		err = postgresError("failed to release scheduled message "+id, err)
+	}
+	return
+}
+
+func (db *PostgresDb) DeleteScheduledMessage(
+	ctx context.Context, id string,
+) (err error) {
+	sql := db.query(
+		"DELETE FROM {table_scheduled} WHERE list = $1 AND scheduled_id = $2",
+	)
+
+	if _, err = db.Client.Exec(ctx, sql, db.List, id); err != nil {
+		err = postgresError("failed to delete scheduled message "+id, err)
+	}
+	return
+}
+
+func (db *PostgresDb) PutAuditEvent(
+	ctx context.Context, event *AuditEvent,
+) (err error) {
+	const sql = `INSERT INTO {table_audit} (list, email, operation, reason,
+		source, request_id, event_time)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err = db.Client.Exec(
+		ctx,
+		db.query(sql),
+		db.List,
+		event.Email,
+		string(event.Operation),
+		string(event.Reason),
+		event.Source,
+		event.RequestId,
+		event.Timestamp,
+	)
+	if err != nil {
+		prefix := "failed to put audit event for " + event.Email
+		err = postgresError(prefix, err)
+	}
+	return
+}
+
+// GetAuditEvents returns every AuditEvent for the email address in the order
+// PutAuditEvent stored them.
+func (db *PostgresDb) GetAuditEvents(
+	ctx context.Context, email string,
+) (events []*AuditEvent, err error) {
+	sql := db.query(`SELECT email, operation, reason, source, request_id,
+		event_time
+	FROM {table_audit} WHERE list = $1 AND email = $2 ORDER BY id`)
+	var rows pgx.Rows
+
+	if rows, err = db.Client.Query(ctx, sql, db.List, email); err == nil {
+		events, err = pgx.CollectRows(
+			rows,
+			func(row pgx.CollectableRow) (*AuditEvent, error) {
+				e := &AuditEvent{}
+				var op, reason string
+				err := row.Scan(
+					&e.Email,
+					&op,
+					&reason,
+					&e.Source,
+					&e.RequestId,
+					&e.Timestamp,
+				)
+				e.Operation = AuditOperation(op)
+				e.Reason = ops.RemoveReason(reason)
+				return e, err
+			},
+		)
+	}
+	if err != nil {
+		prefix := "failed to get audit events for " + email
+		return nil, postgresError(prefix, err)
+	}
+	return
+}
+
+func (db *PostgresDb) DeleteAuditEvents(
+	ctx context.Context, email string,
+) (err error) {
+	sql := db.query("DELETE FROM {table_audit} WHERE list = $1 AND email = $2")
+
+	if _, err = db.Client.Exec(ctx, sql, db.List, email); err != nil {
+		prefix := "failed to delete audit events for " + email
+		err = postgresError(prefix, err)
+	}
+	return
+}
+
+func (db *PostgresDb) GetTombstone(
+	ctx context.Context, email string,
+) (tombstone *Tombstone, err error) {
+	sql := db.query(`SELECT email, reason, removed
+	FROM {table_tombstones} WHERE list = $1 AND email = $2`)
+	ts := &Tombstone{}
+	var reason string
+
+	err = db.Client.QueryRow(ctx, sql, db.List, email).Scan(
+		&ts.Email, &reason, &ts.Timestamp,
+	)
+	if err == nil {
+		ts.Reason = TombstoneReason(reason)
+		tombstone = ts
+	} else if errors.Is(err, pgx.ErrNoRows) {
+		err = ErrTombstoneNotFound
+	} else {
+		err = postgresError("failed to get tombstone for "+email, err)
+	}
+	return
+}
+
+func (db *PostgresDb) PutTombstone(
+	ctx context.Context, tombstone *Tombstone,
+) (err error) {
+	const sql = `INSERT INTO {table_tombstones} (list, email, reason, removed)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (list, email) DO UPDATE SET
+		reason = EXCLUDED.reason, removed = EXCLUDED.removed`
+
+	_, err = db.Client.Exec(
+		ctx,
+		db.query(sql),
+		db.List,
+		tombstone.Email,
+		string(tombstone.Reason),
+		tombstone.Timestamp,
+	)
+	if err != nil {
+		prefix := "failed to put tombstone for " + tombstone.Email
+		err = postgresError(prefix, err)
+	}
+	return
+}
+
+func (db *PostgresDb) DeleteTombstone(
+	ctx context.Context, email string,
+) (err error) {
+	sql := db.query(
+		"DELETE FROM {table_tombstones} WHERE list = $1 AND email = $2",
+	)
+
+	if _, err = db.Client.Exec(ctx, sql, db.List, email); err != nil {
+		err = postgresError("failed to delete tombstone for "+email, err)
+	}
+	return
+}
+
+func (db *PostgresDb) ListTombstones(
+	ctx context.Context,
+) (tombstones []*Tombstone, err error) {
+	sql := db.query(`SELECT email, reason, removed
+	FROM {table_tombstones} WHERE list = $1`)
+	var rows pgx.Rows
+
+	if rows, err = db.Client.Query(ctx, sql, db.List); err == nil {
+		tombstones, err = pgx.CollectRows(
+			rows,
+			func(row pgx.CollectableRow) (*Tombstone, error) {
+				ts := &Tombstone{}
+				var reason string
+				err := row.Scan(&ts.Email, &reason, &ts.Timestamp)
+				ts.Reason = TombstoneReason(reason)
+				return ts, err
+			},
+		)
+	}
+	if err != nil {
+		return nil, postgresError("failed to list tombstones", err)
+	}
+	return
+}
+
+func (db *PostgresDb) PutErasure(ctx context.Context, hash string) (err error) {
+	sql := db.query(`INSERT INTO {table_erasures} (list, hash) VALUES ($1, $2)
+	ON CONFLICT DO NOTHING`)
+
+	if _, err = db.Client.Exec(ctx, sql, db.List, hash); err != nil {
+		err = postgresError("failed to put erasure record", err)
+	}
+	return
+}
+
+func (db *PostgresDb) IsErased(
+	ctx context.Context, hash string,
+) (erased bool, err error) {
+	sql := db.query(`SELECT EXISTS (
+		SELECT 1 FROM {table_erasures} WHERE list = $1 AND hash = $2
+	)`)
+
+	err = db.Client.QueryRow(ctx, sql, db.List, hash).Scan(&erased)
+	if err != nil {
+		err = postgresError("failed to get erasure record", err)
+	}
+	return
+}
+
+func (db *PostgresDb) ListErasures(
+	ctx context.Context,
+) (hashes []string, err error) {
+	sql := db.query("SELECT hash FROM {table_erasures} WHERE list = $1")
+	var rows pgx.Rows
+
+	if rows, err = db.Client.Query(ctx, sql, db.List); err == nil {
+		hashes, err = pgx.CollectRows(rows, pgx.RowTo[string])
+	}
+	if err != nil {
+		return nil, postgresError("failed to list erasure records", err)
+	}
+	return
+}
+
+func (db *PostgresDb) GetWelcomeMessage(
+	ctx context.Context,
+) (msg *email.Message, err error) {
+	sql := db.query("SELECT message FROM {table_welcome} WHERE list = $1")
+
+	err = db.Client.QueryRow(ctx, sql, db.List).Scan(&msg)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = ErrWelcomeMessageNotFound
+	} else if err != nil {
+		err = postgresError("failed to get welcome message", err)
+	}
+	return
+}
+
+func (db *PostgresDb) PutWelcomeMessage(
+	ctx context.Context, msg *email.Message,
+) (err error) {
+	sql := db.query(`INSERT INTO {table_welcome} (list, message)
+	VALUES ($1, $2)
+	ON CONFLICT (list) DO UPDATE SET message = EXCLUDED.message`)
+
+	if _, err = db.Client.Exec(ctx, sql, db.List, msg); err != nil {
+		err = postgresError("failed to put welcome message", err)
+	}
+	return
+}
+
+func (db *PostgresDb) DeleteWelcomeMessage(ctx context.Context) (err error) {
+	sql := db.query("DELETE FROM {table_welcome} WHERE list = $1")
+
+	if _, err = db.Client.Exec(ctx, sql, db.List); err != nil {
+		err = postgresError("failed to delete welcome message", err)
+	}
+	return
+}
@@ -0,0 +1,586 @@
+//go:build ((medium_tests || contract_tests) && !no_coverage_tests) || coverage_tests || all_tests
+
+package db
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/mbland/elistman/ops"
+	"github.com/mbland/elistman/testutils"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+var postgresUrl string
+var postgresDockerVersion string
+
+func init() {
+	flag.StringVar(
+		&postgresUrl,
+		"pgurl",
+		"",
+		"Test against this PostgreSQL URL (instead of local Docker container)",
+	)
+	flag.StringVar(
+		&postgresDockerVersion,
+		"pgDockerVersion",
+		"17-alpine",
+		"Version of the postgres Docker image to test against",
+	)
+}
+
+// postgresTestPassword is the password for the local Docker container, which
+// only listens on localhost and only exists for the duration of the test.
+const postgresTestPassword = "elistman-test"
+
+func setupPostgresDb() (pgDb *PostgresDb, teardown func() error, err error) {
+	teardownServer := func() error { return nil }
+	teardownDbWithError := func(err error) error {
+		if err == nil {
+			return teardownServer()
+		} else if teardownErr := teardownServer(); teardownErr != nil {
+			const msgFmt = "teardown after error failed: %s\noriginal error: %s"
+			return fmt.Errorf(msgFmt, teardownErr, err)
+		}
+		return err
+	}
+
+	tableName := "elistman-database-test-" + testutils.RandomString(10)
+	url := postgresUrl
+	ctx := context.Background()
+	var pool *pgxpool.Pool
+
+	if url == "" {
+		if url, teardownServer, err = setupLocalPostgres(); err != nil {
+			return
+		}
+	}
+
+	if pool, err = waitForPostgres(ctx, url); err != nil {
+		err = teardownDbWithError(err)
+		return
+	}
+	pgDb = NewPostgresDb(pool, tableName)
+
+	if err = pgDb.CreateSubscribersTable(ctx); err != nil {
+		pool.Close()
+		err = teardownDbWithError(err)
+	} else {
+		teardown = func() error {
+			err := pgDb.DeleteTables(ctx)
+			pool.Close()
+			return teardownDbWithError(err)
+		}
+	}
+	return
+}
+
+// See also:
+// - https://hub.docker.com/_/postgres
+func setupLocalPostgres() (url string, teardown func() error, err error) {
+	var hostPort string
+
+	if hostPort, err = testutils.PickUnusedHostPort(); err != nil {
+		return
+	}
+
+	dockerImage := "postgres:" + postgresDockerVersion
+	teardown, err = testutils.LaunchDockerContainer(
+		"PostgreSQL",
+		testutils.BaseEndpoint(hostPort),
+		5432,
+		dockerImage,
+		"-e", "POSTGRES_PASSWORD="+postgresTestPassword,
+	)
+	if err == nil {
+		const urlFmt = "postgres://postgres:%s@%s/postgres?sslmode=disable"
+		url = fmt.Sprintf(urlFmt, postgresTestPassword, hostPort)
+	}
+	return
+}
+
+// waitForPostgres returns a connection pool for url once the server accepts
+// connections.
+//
+// The postgres Docker image starts a temporary server that only accepts local
+// connections while it initializes the database, so this waits until the real
+// server starts accepting connections.
+func waitForPostgres(
+	ctx context.Context, url string,
+) (pool *pgxpool.Pool, err error) {
+	if pool, err = pgxpool.New(ctx, url); err != nil {
+		return
+	}
+	deadline := time.Now().Add(maxTableWaitDuration)
+
+	for err = pool.Ping(ctx); err != nil; err = pool.Ping(ctx) {
+		if time.Now().After(deadline) {
+			pool.Close()
+			const errFmt = "PostgreSQL not ready after %s: %s"
+			return nil, fmt.Errorf(errFmt, maxTableWaitDuration, err)
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+	return
+}
+
+func TestPostgresDb(t *testing.T) {
+	testDb, teardown, err := setupPostgresDb()
+
+	assert.NilError(t, err)
+	defer func() {
+		err := teardown()
+		assert.NilError(t, err)
+	}()
+
+	ctx := context.Background()
+	var badDb PostgresDb = *testDb
+	badDb.TableName = testDb.TableName + "-nonexistent"
+
+	t.Run("Contract", func(t *testing.T) {
+		testDatabaseContract(t, testDb, 0)
+	})
+
+	t.Run("ListsDoNotCollide", func(t *testing.T) {
+		sub := newTestSubscriber()
+		listDb := testDb.ForList("other-list")
+		listSub := *sub
+		listSub.List = "other-list"
+		listSub.Uid = uuid.New()
+		defer testDb.Delete(ctx, sub.Email)
+		defer listDb.Delete(ctx, sub.Email)
+
+		assert.NilError(t, testDb.Put(ctx, sub))
+		assert.NilError(t, listDb.Put(ctx, &listSub))
+		retrieved, err := testDb.Get(ctx, sub.Email)
+		assert.NilError(t, err)
+		retrievedListSub, listErr := listDb.Get(ctx, sub.Email)
+		assert.NilError(t, listErr)
+
+		assert.DeepEqual(t, sub, retrieved)
+		assert.DeepEqual(t, &listSub, retrievedListSub)
+	})
+
+	t.Run("ProcessSubscribersFetchesMultipleBatches", func(t *testing.T) {
+		listDb := testDb.ForList("batches")
+		expected := make([]*Subscriber, 0, postgresFetchSize*2+1)
+
+		for i := 0; i != cap(expected); i++ {
+			sub := newTestSubscriber()
+			sub.Email = fmt.Sprintf("%03d-%s", i, sub.Email)
+			sub.List = "batches"
+			sub.Status = SubscriberVerified
+			assert.NilError(t, listDb.Put(ctx, sub))
+			defer listDb.Delete(ctx, sub.Email)
+			expected = append(expected, sub)
+		}
+		subs := []*Subscriber{}
+		f := SubscriberFunc(func(s *Subscriber) bool {
+			subs = append(subs, s)
+			return len(subs) != postgresFetchSize+1
+		})
+
+		err := listDb.ProcessSubscribers(ctx, SubscriberVerified, f)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, expected[:postgresFetchSize+1], subs)
+
+		err = listDb.ProcessSubscribersFrom(
+			ctx, SubscriberVerified, subs[len(subs)-1].ScanKey(), f,
+		)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, expected, subs)
+	})
+
+	t.Run("ProcessSubscribersWritesViaSingleConnection", func(t *testing.T) {
+		conn, err := testDb.Client.(*pgxpool.Pool).Acquire(ctx)
+		assert.NilError(t, err)
+		defer conn.Release()
+		connDb := testDb.ForList("single-conn")
+		connDb.Client = conn.Conn()
+
+		for i := 0; i != postgresFetchSize+1; i++ {
+			sub := newTestSubscriber()
+			sub.Email = fmt.Sprintf("%03d-%s", i, sub.Email)
+			sub.List = "single-conn"
+			sub.Status = SubscriberVerified
+			assert.NilError(t, connDb.Put(ctx, sub))
+			defer connDb.Delete(ctx, sub.Email)
+			defer connDb.DeleteReceipts(ctx, sub.Email)
+		}
+		numReceived := 0
+		var markErr error
+		f := SubscriberFunc(func(s *Subscriber) bool {
+			markErr = connDb.MarkReceived(ctx, s.Email, "campaign-id")
+			numReceived++
+			return markErr == nil
+		})
+
+		err = connDb.ProcessSubscribers(ctx, SubscriberVerified, f)
+
+		assert.NilError(t, err)
+		assert.NilError(t, markErr)
+		assert.Equal(t, postgresFetchSize+1, numReceived)
+	})
+
+	t.Run("ExpiresPendingSubscribers", func(t *testing.T) {
+		expired := newTestSubscriber()
+		expired.Timestamp = time.Now().Add(-time.Minute).Truncate(time.Second)
+		pending := newTestSubscriber()
+		assert.NilError(t, testDb.Put(ctx, expired))
+		assert.NilError(t, testDb.Put(ctx, pending))
+		defer testDb.Delete(ctx, expired.Email)
+		defer testDb.Delete(ctx, pending.Email)
+
+		_, getErr := testDb.Get(ctx, expired.Email)
+		rotateErr := testDb.RotateUid(
+			ctx, expired.Email, uuid.New(), time.Now(),
+		)
+		numExpired, expireErr := testDb.ExpireSubscribers(ctx)
+
+		assert.Assert(t, testutils.ErrorIs(getErr, ErrSubscriberNotFound))
+		assert.Assert(t, testutils.ErrorIs(rotateErr, ErrSubscriberNotFound))
+		assert.NilError(t, expireErr)
+		assert.Equal(t, int64(1), numExpired)
+		_, err := testDb.Get(ctx, pending.Email)
+		assert.NilError(t, err)
+
+		numExpired, expireErr = testDb.ExpireSubscribers(ctx)
+		assert.NilError(t, expireErr)
+		assert.Equal(t, int64(0), numExpired)
+	})
+
+	t.Run("RunExpiryJobExpiresPendingSubscribers", func(t *testing.T) {
+		expired := newTestSubscriber()
+		expired.Timestamp = time.Now().Add(-time.Minute).Truncate(time.Second)
+		assert.NilError(t, testDb.Put(ctx, expired))
+		defer testDb.Delete(ctx, expired.Email)
+		logs, jobCtx := newExpiryJobLog(ctx)
+
+		testDb.RunExpiryJob(jobCtx, time.Hour, log.New(logs, "", 0))
+
+		assert.Equal(t, "expired 1 pending subscribers\n", logs.String())
+	})
+
+	t.Run("CreateSubscribersTableFailsIfTableExists", func(t *testing.T) {
+		err := testDb.CreateSubscribersTable(ctx)
+
+		expected := "failed to create subscribers table \"" +
+			testDb.TableName + "\": "
+		assert.ErrorContains(t, err, expected)
+		assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+	})
+
+	t.Run("DeleteTablesFailsIfTablesDoNotExist", func(t *testing.T) {
+		err := badDb.DeleteTables(ctx)
+
+		expected := "failed to delete db tables " + badDb.TableName + ": "
+		assert.ErrorContains(t, err, expected)
+		assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+	})
+
+	t.Run("ExpireSubscribersFailsIfTableDoesNotExist", func(t *testing.T) {
+		numExpired, err := badDb.ExpireSubscribers(ctx)
+
+		assert.Equal(t, int64(0), numExpired)
+		expected := "failed to expire pending subscribers: "
+		assert.ErrorContains(t, err, expected)
+		assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+	})
+
+	t.Run("RunExpiryJobLogsErrors", func(t *testing.T) {
+		logs, jobCtx := newExpiryJobLog(ctx)
+
+		badDb.RunExpiryJob(jobCtx, time.Hour, log.New(logs, "", 0))
+
+		expected := "ERROR: failed to expire pending subscribers: "
+		assert.Assert(t, strings.HasPrefix(logs.String(), expected))
+	})
+
+	t.Run("GetFailsIfTableDoesNotExist", func(t *testing.T) {
+		subscriber := newTestSubscriber()
+
+		retrieved, err := badDb.Get(ctx, subscriber.Email)
+
+		assert.Assert(t, is.Nil(retrieved))
+		expected := "failed to get " + subscriber.Email + ": "
+		assert.ErrorContains(t, err, expected)
+		assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+	})
+
+	t.Run("PutFailsIfTableDoesNotExist", func(t *testing.T) {
+		subscriber := newTestSubscriber()
+
+		err := badDb.Put(ctx, subscriber)
+
+		assert.ErrorContains(t, err, "failed to put "+subscriber.Email+": ")
+		assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+	})
+
+	t.Run("DeleteFailsIfTableDoesNotExist", func(t *testing.T) {
+		subscriber := newTestSubscriber()
+
+		err := badDb.Delete(ctx, subscriber.Email)
+
+		expected := "failed to delete " + subscriber.Email + ": "
+		assert.ErrorContains(t, err, expected)
+		assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+	})
+
+	t.Run("MarkReceivedFailsIfTableDoesNotExist", func(t *testing.T) {
+		subscriber := newTestSubscriber()
+
+		err := badDb.MarkReceived(ctx, subscriber.Email, "campaign-0")
+
+		expected := "failed to mark campaign-0 as received by " +
+			subscriber.Email + ": "
+		assert.ErrorContains(t, err, expected)
+		assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+	})
+
+	t.Run("HasReceivedFailsIfTableDoesNotExist", func(t *testing.T) {
+		subscriber := newTestSubscriber()
+
+		_, err := badDb.HasReceived(ctx, subscriber.Email, "campaign-0")
+
+		expected := "failed to check if " + subscriber.Email +
+			" received campaign-0: "
+		assert.ErrorContains(t, err, expected)
+		assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+	})
+
+	t.Run("DeleteReceiptsFailsIfTableDoesNotExist", func(t *testing.T) {
+		subscriber := newTestSubscriber()
+
+		err := badDb.DeleteReceipts(ctx, subscriber.Email)
+
+		expected := "failed to delete receipts for " + subscriber.Email + ": "
+		assert.ErrorContains(t, err, expected)
+		assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+	})
+
+	t.Run("RotateUidFailsIfTableDoesNotExist", func(t *testing.T) {
+		subscriber := newTestSubscriber()
+
+		err := badDb.RotateUid(ctx, subscriber.Email, uuid.New(), time.Now())
+
+		expected := "failed to rotate UID for " + subscriber.Email + ": "
+		assert.ErrorContains(t, err, expected)
+		assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+	})
+
+	t.Run("RecordVerifySentFailsIfTableDoesNotExist", func(t *testing.T) {
+		subscriber := newTestSubscriber()
+
+		err := badDb.RecordVerifySent(ctx, subscriber, 0)
+
+		expected := "failed to record verification email to " +
+			subscriber.Email + ": "
+		assert.ErrorContains(t, err, expected)
+		assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+	})
+
+	t.Run("ProcessSubscribersFailsIfTableDoesNotExist", func(t *testing.T) {
+		f := SubscriberFunc(func(s *Subscriber) bool { return true })
+
+		err := badDb.ProcessSubscribers(ctx, SubscriberVerified, f)
+
+		expected := "failed to get verified subscribers: "
+		assert.ErrorContains(t, err, expected)
+		assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+	})
+
+	t.Run("Checkpoints", func(t *testing.T) {
+		t.Run("GetFailsIfTableDoesNotExist", func(t *testing.T) {
+			cp := newTestCheckpoint()
+
+			retrieved, err := badDb.GetCheckpoint(ctx, cp.CampaignId)
+
+			assert.Assert(t, is.Nil(retrieved))
+			expected := "failed to get checkpoint " + cp.CampaignId + ": "
+			assert.ErrorContains(t, err, expected)
+			assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+		})
+
+		t.Run("PutFailsIfTableDoesNotExist", func(t *testing.T) {
+			cp := newTestCheckpoint()
+
+			err := badDb.PutCheckpoint(ctx, cp)
+
+			expected := "failed to put checkpoint " + cp.CampaignId + ": "
+			assert.ErrorContains(t, err, expected)
+			assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+		})
+	})
+
+	t.Run("Campaigns", func(t *testing.T) {
+		t.Run("GetFailsIfTableDoesNotExist", func(t *testing.T) {
+			c := newTestCampaign()
+
+			retrieved, err := badDb.GetCampaign(ctx, c.Id)
+
+			assert.Assert(t, is.Nil(retrieved))
+			expected := "failed to get campaign " + c.Id + ": "
+			assert.ErrorContains(t, err, expected)
+			assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+		})
+
+		t.Run("PutFailsIfTableDoesNotExist", func(t *testing.T) {
+			c := newTestCampaign()
+
+			err := badDb.PutCampaign(ctx, c)
+
+			expected := "failed to put campaign " + c.Id + ": "
+			assert.ErrorContains(t, err, expected)
+			assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+		})
+
+		t.Run("ListFailsIfTableDoesNotExist", func(t *testing.T) {
+			listed, err := badDb.ListCampaigns(ctx)
+
+			assert.Equal(t, 0, len(listed))
+			assert.ErrorContains(t, err, "failed to list campaigns: ")
+			assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+		})
+	})
+
+	t.Run("ScheduledMessages", func(t *testing.T) {
+		now := time.Now().Truncate(time.Second)
+
+		t.Run("PutFailsIfTableDoesNotExist", func(t *testing.T) {
+			scheduled := newTestScheduledMessage(now)
+
+			err := badDb.PutScheduledMessage(ctx, scheduled)
+
+			expected := "failed to put scheduled message " + scheduled.Id
+			assert.ErrorContains(t, err, expected)
+			assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+		})
+
+		t.Run("GetDueFailsIfTableDoesNotExist", func(t *testing.T) {
+			dueMsgs, err := badDb.GetDueMessages(ctx, now)
+
+			assert.Equal(t, 0, len(dueMsgs))
+			assert.ErrorContains(t, err, "failed to get due messages: ")
+			assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+		})
+
+		t.Run("ClaimFailsIfTableDoesNotExist", func(t *testing.T) {
+			err := badDb.ClaimScheduledMessage(
+				ctx, "scheduled-id", now, now.Add(time.Minute),
+			)
+
+			expected := "failed to claim scheduled message scheduled-id: "
+			assert.ErrorContains(t, err, expected)
+			assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+		})
+
+		t.Run("DeleteFailsIfTableDoesNotExist", func(t *testing.T) {
+			err := badDb.DeleteScheduledMessage(ctx, "scheduled-id")
+
+			expected := "failed to delete scheduled message scheduled-id: "
+			assert.ErrorContains(t, err, expected)
+			assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+		})
+	})
+
+	t.Run("AuditEvents", func(t *testing.T) {
+		t.Run("PutFailsIfTableDoesNotExist", func(t *testing.T) {
+			e := newTestAuditEvent("foo@test.com", AuditImport, time.Now())
+
+			err := badDb.PutAuditEvent(ctx, e)
+
+			expected := "failed to put audit event for foo@test.com: "
+			assert.ErrorContains(t, err, expected)
+			assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+		})
+
+		t.Run("GetFailsIfTableDoesNotExist", func(t *testing.T) {
+			retrieved, err := badDb.GetAuditEvents(ctx, "foo@test.com")
+
+			assert.Equal(t, 0, len(retrieved))
+			expected := "failed to get audit events for foo@test.com: "
+			assert.ErrorContains(t, err, expected)
+			assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+		})
+
+		t.Run("DeleteFailsIfTableDoesNotExist", func(t *testing.T) {
+			err := badDb.DeleteAuditEvents(ctx, "foo@test.com")
+
+			expected := "failed to delete audit events for foo@test.com: "
+			assert.ErrorContains(t, err, expected)
+			assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+		})
+	})
+
+	t.Run("Erasures", func(t *testing.T) {
+		t.Run("PutFailsIfTableDoesNotExist", func(t *testing.T) {
+			err := badDb.PutErasure(ctx, "hash")
+
+			assert.ErrorContains(t, err, "failed to put erasure record: ")
+			assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+		})
+
+		t.Run("IsErasedFailsIfTableDoesNotExist", func(t *testing.T) {
+			erased, err := badDb.IsErased(ctx, "hash")
+
+			assert.Assert(t, !erased)
+			assert.ErrorContains(t, err, "failed to get erasure record: ")
+			assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+		})
+	})
+
+	t.Run("Tombstones", func(t *testing.T) {
+		t.Run("GetFailsIfTableDoesNotExist", func(t *testing.T) {
+			retrieved, err := badDb.GetTombstone(ctx, "foo@test.com")
+
+			assert.Assert(t, is.Nil(retrieved))
+			expected := "failed to get tombstone for foo@test.com: "
+			assert.ErrorContains(t, err, expected)
+			assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+		})
+
+		t.Run("PutFailsIfTableDoesNotExist", func(t *testing.T) {
+			ts := newTestTombstone()
+
+			err := badDb.PutTombstone(ctx, ts)
+
+			expected := "failed to put tombstone for " + ts.Email + ": "
+			assert.ErrorContains(t, err, expected)
+			assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+		})
+
+		t.Run("DeleteFailsIfTableDoesNotExist", func(t *testing.T) {
+			err := badDb.DeleteTombstone(ctx, "foo@test.com")
+
+			expected := "failed to delete tombstone for foo@test.com: "
+			assert.ErrorContains(t, err, expected)
+			assert.Assert(t, testutils.ErrorIsNot(err, ops.ErrExternal))
+		})
+	})
+}
+
+// expiryJobLog cancels the context for RunExpiryJob when the job first writes
+// to the log, so the job returns after the first time it logs a result.
+type expiryJobLog struct {
+	bytes.Buffer
+	cancel context.CancelFunc
+}
+
+func newExpiryJobLog(ctx context.Context) (*expiryJobLog, context.Context) {
+	jobCtx, cancel := context.WithCancel(ctx)
+	return &expiryJobLog{cancel: cancel}, jobCtx
+}
+
+func (l *expiryJobLog) Write(p []byte) (int, error) {
+	defer l.cancel()
+	return l.Buffer.Write(p)
+}
@@ -0,0 +1,113 @@
+//go:build small_tests || all_tests
+
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mbland/elistman/ops"
+	"github.com/mbland/elistman/testdata"
+	tu "github.com/mbland/elistman/testutils"
+	"gotest.tools/assert"
+)
+
+func newTestPostgresDb() *PostgresDb {
+	pgDb := NewPostgresDb(nil, "elistman-subscribers")
+	pgDb.CurrentTime = func() time.Time { return testdata.TestTimestamp }
+	return pgDb
+}
+
+func TestPostgresDbQuery(t *testing.T) {
+	pgDb := newTestPostgresDb()
+
+	sql := pgDb.query(
+		"SELECT * FROM {table} JOIN {table_audit} USING (list, email)",
+	)
+
+	const expected = `SELECT * FROM "elistman-subscribers" ` +
+		`JOIN "elistman-subscribers_audit" USING (list, email)`
+	assert.Equal(t, expected, sql)
+}
+
+func TestPostgresDbSubscribersQuery(t *testing.T) {
+	const selectFrom = "SELECT " + postgresSubscriberColumns +
+		` FROM "elistman-subscribers" WHERE list = $1 AND status = $2 AND ` +
+		"(status <> 'pending' OR status_time >= $3)"
+
+	t.Run("WithoutStartKeyOrFilter", func(t *testing.T) {
+		pgDb := newTestPostgresDb()
+
+		query, args := pgDb.subscribersQuery(SubscriberVerified, nil, nil)
+
+		assert.Equal(t, selectFrom+" ORDER BY email", query)
+		assert.DeepEqual(
+			t, []any{"", "verified", testdata.TestTimestamp}, args,
+		)
+	})
+
+	t.Run("WithStartKeyAndFilter", func(t *testing.T) {
+		pgDb := newTestPostgresDb().ForList("other-list")
+		startKey := &ScanKey{Email: "foo@test.com"}
+		filter, err := ParseTagFilter("releases or (essays and not releases)")
+		assert.NilError(t, err)
+
+		query, args := pgDb.subscribersQuery(
+			SubscriberPending, filter, startKey,
+		)
+
+		expected := selectFrom + " AND email > $4 AND " +
+			"(tags @> ARRAY[$5::text] OR " +
+			"(tags @> ARRAY[$6::text] AND (NOT tags @> ARRAY[$7::text])))" +
+			" ORDER BY email"
+		assert.Equal(t, expected, query)
+		assert.DeepEqual(
+			t,
+			[]any{
+				"other-list",
+				"pending",
+				testdata.TestTimestamp,
+				"foo@test.com",
+				"releases",
+				"essays",
+				"releases",
+			},
+			args,
+		)
+	})
+}
+
+func TestPostgresError(t *testing.T) {
+	t.Run("RequestErrorIsNotExternal", func(t *testing.T) {
+		pgErr := &pgconn.PgError{
+			Severity: "ERROR",
+			Code:     "42P01",
+			Message:  `relation "foo" does not exist`,
+		}
+
+		err := postgresError("failed to get foo@test.com", pgErr)
+
+		const expected = "failed to get foo@test.com: " +
+			`ERROR: relation "foo" does not exist (SQLSTATE 42P01)`
+		assert.Error(t, err, expected)
+		assert.Assert(t, tu.ErrorIsNot(err, ops.ErrExternal))
+	})
+
+	t.Run("ServerErrorIsExternal", func(t *testing.T) {
+		pgErr := &pgconn.PgError{Code: "53300", Message: "too many clients"}
+
+		err := postgresError("failed to get foo@test.com", pgErr)
+
+		assert.ErrorContains(t, err, "failed to get foo@test.com: ")
+		assert.Assert(t, tu.ErrorIs(err, ops.ErrExternal))
+	})
+
+	t.Run("ConnectionErrorIsExternal", func(t *testing.T) {
+		err := postgresError("failed to get foo@test.com", errors.New("EOF"))
+
+		assert.ErrorContains(t, err, "failed to get foo@test.com: ")
+		assert.Assert(t, tu.ErrorIs(err, ops.ErrExternal))
+	})
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/mbland/elistman/email"
+	"github.com/mbland/elistman/types"
+)
+
+// ScheduledMessage is an email.Message to send to the entire list at SendAt.
+//
+// ClaimedUntil is the time until which the sender that most recently called
+// ScheduleStore.ClaimScheduledMessage has the exclusive right to send it, or
+// the zero time if no sender has claimed it.
+type ScheduledMessage struct {
+	Id           string
+	SendAt       time.Time
+	ClaimedUntil time.Time
+	Message      *email.Message
+}
+
+// ScheduleStore saves and retrieves ScheduledMessage records.
+//
+// GetDueMessages returns every ScheduledMessage with a SendAt time at or
+// before now, in no particular order, including those already claimed.
+//
+// ClaimScheduledMessage sets a ScheduledMessage's ClaimedUntil time to until,
+// giving the caller the exclusive right to send it until then. It returns
+// ErrScheduledMessageClaimed if another claim hasn't expired by now, or if the
+// ScheduledMessage no longer exists. Checking and setting the claim happen
+// atomically, so only one of several concurrent callers can succeed.
+//
+// ReleaseScheduledMessage resets a ScheduledMessage's ClaimedUntil time to the
+// zero time, but only if it's still until, i.e., only if the caller's own claim
+// is still in effect. This allows any sender to claim it again right away. It
+// doesn't return an error if the claim changed or the ScheduledMessage no
+// longer exists.
+//
+// DeleteScheduledMessage doesn't return an error if no ScheduledMessage with
+// the specified ID exists.
+type ScheduleStore interface {
+	PutScheduledMessage(ctx context.Context, msg *ScheduledMessage) error
+	GetDueMessages(
+		ctx context.Context, now time.Time,
+	) ([]*ScheduledMessage, error)
+	ClaimScheduledMessage(
+		ctx context.Context, id string, now, until time.Time,
+	) error
+	ReleaseScheduledMessage(
+		ctx context.Context, id string, until time.Time,
+	) error
+	DeleteScheduledMessage(ctx context.Context, id string) error
+}
+
+// ErrScheduledMessageClaimed indicates that ClaimScheduledMessage couldn't
+// claim a ScheduledMessage.
+//
+// Either another sender claimed it first, or another sender already sent and
+// deleted it.
+const ErrScheduledMessageClaimed = types.SentinelError(
+	"scheduled message already claimed or deleted",
+)
@@ -0,0 +1,297 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/mbland/elistman/types"
+)
+
+// ErrInvalidTag indicates that a tag doesn't match tagRegexp.
+const ErrInvalidTag = types.SentinelError("invalid tag")
+
+// Tags come from subscribe form topics and appear in tag filter expressions,
+// so they may only contain lowercase letters, digits, and hyphens. They also
+// can't be any of the tagKeywords.
+var tagRegexp = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+const maxTagLength = 64
+
+// ValidateTag returns ErrInvalidTag if tag isn't a valid tag.
+func ValidateTag(tag string) error {
+	if len(tag) > maxTagLength || !tagRegexp.MatchString(tag) ||
+		tagKeywords[tag] {
+		return fmt.Errorf("%w: %q", ErrInvalidTag, tag)
+	}
+	return nil
+}
+
+// NormalizeTags validates tags and returns them sorted, without duplicates.
+//
+// It returns nil if tags is empty.
+func NormalizeTags(tags []string) ([]string, error) {
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	for _, tag := range tags {
+		if err := ValidateTag(tag); err != nil {
+			return nil, err
+		}
+	}
+	result := slices.Clone(tags)
+	slices.Sort(result)
+	return slices.Compact(result), nil
+}
+
+// TagFilter selects subscribers by their Tags.
+//
+// A TagFilter comes from an expression combining tags with the "and", "or",
+// and "not" operators, with optional parentheses for grouping. "not" binds
+// most tightly, followed by "and", then "or". For example:
+//
+//	releases or (essays and not drafts)
+//
+// A nil *TagFilter matches every subscriber.
+type TagFilter struct {
+	root *tagNode
+}
+
+type tagOp int
+
+const (
+	tagLeaf tagOp = iota
+	tagAnd
+	tagOr
+	tagNot
+)
+
+var tagKeywords = map[string]bool{"and": true, "or": true, "not": true}
+
+// tagNode is one node of a TagFilter's expression tree.
+//
+// A tagLeaf node has a tag and no operands. A tagNot node has one operand.
+// tagAnd and tagOr nodes have two or more operands.
+type tagNode struct {
+	op       tagOp
+	tag      string
+	operands []*tagNode
+}
+
+// ParseTagFilter parses a TagFilter from expr.
+//
+// It returns a nil *TagFilter, which matches every subscriber, if expr is
+// empty or contains only whitespace.
+func ParseTagFilter(expr string) (filter *TagFilter, err error) {
+	p := &tagFilterParser{tokens: tokenizeTagFilter(expr)}
+
+	if len(p.tokens) == 0 {
+		return
+	}
+
+	var root *tagNode
+	if root, err = p.parseOr(); err != nil {
+		// Report the error below.
+	} else if tok, ok := p.peek(); ok {
+		err = fmt.Errorf("unexpected %q", tok)
+	} else {
+		return &TagFilter{root}, nil
+	}
+	return nil, fmt.Errorf("invalid tag filter %q: %w", expr, err)
+}
+
+func tokenizeTagFilter(expr string) []string {
+	expr = strings.ReplaceAll(expr, "(", " ( ")
+	expr = strings.ReplaceAll(expr, ")", " ) ")
+	return strings.Fields(expr)
+}
+
+type tagFilterParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *tagFilterParser) peek() (string, bool) {
+	if p.pos == len(p.tokens) {
+		return "", false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *tagFilterParser) next() (tok string, ok bool) {
+	if tok, ok = p.peek(); ok {
+		p.pos++
+	}
+	return
+}
+
+func (p *tagFilterParser) accept(keyword string) bool {
+	if tok, ok := p.peek(); ok && tok == keyword {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *tagFilterParser) parseOr() (*tagNode, error) {
+	return p.parseBinary(tagOr, "or", p.parseAnd)
+}
+
+func (p *tagFilterParser) parseAnd() (*tagNode, error) {
+	return p.parseBinary(tagAnd, "and", p.parseNot)
+}
+
+func (p *tagFilterParser) parseBinary(
+	op tagOp, keyword string, parseOperand func() (*tagNode, error),
+) (node *tagNode, err error) {
+	if node, err = parseOperand(); err != nil {
+		return
+	}
+	operands := []*tagNode{node}
+
+	for p.accept(keyword) {
+		if node, err = parseOperand(); err != nil {
+			return
+		}
+		operands = append(operands, node)
+	}
+
+	if len(operands) == 1 {
+		return operands[0], nil
+	}
+	return &tagNode{op: op, operands: operands}, nil
+}
+
+func (p *tagFilterParser) parseNot() (node *tagNode, err error) {
+	if !p.accept("not") {
+		return p.parseOperand()
+	} else if node, err = p.parseNot(); err != nil {
+		return
+	}
+	return &tagNode{op: tagNot, operands: []*tagNode{node}}, nil
+}
+
+func (p *tagFilterParser) parseOperand() (node *tagNode, err error) {
+	tok, ok := p.next()
+
+	if !ok {
+		err = errors.New("unexpected end of expression")
+	} else if tok == "(" {
+		if node, err = p.parseOr(); err == nil && !p.accept(")") {
+			err = errors.New(`missing ")"`)
+		}
+	} else if tok == ")" || tagKeywords[tok] {
+		err = fmt.Errorf("unexpected %q", tok)
+	} else if err = ValidateTag(tok); err == nil {
+		node = &tagNode{op: tagLeaf, tag: tok}
+	}
+	return
+}
+
+// Matches returns true if a subscriber with tags satisfies f.
+func (f *TagFilter) Matches(tags []string) bool {
+	return f == nil || f.root.matches(tags)
+}
+
+func (n *tagNode) matches(tags []string) bool {
+	switch n.op {
+	case tagLeaf:
+		return slices.Contains(tags, n.tag)
+	case tagNot:
+		return !n.operands[0].matches(tags)
+	case tagAnd:
+		for _, operand := range n.operands {
+			if !operand.matches(tags) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, operand := range n.operands {
+		if operand.matches(tags) {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the normalized expression for f, or the empty string if f is
+// nil.
+//
+// Parsing the result produces an equivalent TagFilter.
+func (f *TagFilter) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.root.format(tagOr)
+}
+
+// format returns the expression for n, parenthesized if its operator binds
+// less tightly than the parent operator.
+func (n *tagNode) format(parent tagOp) string {
+	switch n.op {
+	case tagLeaf:
+		return n.tag
+	case tagNot:
+		return "not " + n.operands[0].format(tagNot)
+	}
+
+	keyword := " and "
+	if n.op == tagOr {
+		keyword = " or "
+	}
+	operands := make([]string, len(n.operands))
+	for i, operand := range n.operands {
+		operands[i] = operand.format(n.op)
+	}
+	expr := strings.Join(operands, keyword)
+
+	if (n.op == tagOr && parent != tagOr) || parent == tagNot {
+		expr = "(" + expr + ")"
+	}
+	return expr
+}
+
+// IndexTags returns tags such that every subscriber matching f has at least
+// one of them.
+//
+// Database implementations with an index of subscribers by tag may use the
+// result to process only the subscribers with those tags, instead of every
+// subscriber. IndexTags returns false if there's no such set of tags, such as
+// when f is nil, or when it matches subscribers without any tags.
+func (f *TagFilter) IndexTags() ([]string, bool) {
+	if f == nil {
+		return nil, false
+	}
+	return f.root.indexTags()
+}
+
+func (n *tagNode) indexTags() (tags []string, ok bool) {
+	switch n.op {
+	case tagLeaf:
+		return []string{n.tag}, true
+	case tagNot:
+		return nil, false
+	case tagAnd:
+		// Any operand's tags will do, so pick the one with the fewest.
+		for _, operand := range n.operands {
+			if opTags, opOk := operand.indexTags(); !opOk {
+				continue
+			} else if !ok || len(opTags) < len(tags) {
+				tags, ok = opTags, true
+			}
+		}
+		return
+	}
+	for _, operand := range n.operands {
+		opTags, opOk := operand.indexTags()
+		if !opOk {
+			return nil, false
+		}
+		tags = append(tags, opTags...)
+	}
+	slices.Sort(tags)
+	return slices.Compact(tags), true
+}
@@ -0,0 +1,175 @@
+//go:build small_tests || all_tests
+
+package db
+
+import (
+	"testing"
+
+	tu "github.com/mbland/elistman/testutils"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestValidateTag(t *testing.T) {
+	t.Run("Succeeds", func(t *testing.T) {
+		assert.NilError(t, ValidateTag("release-notes-2"))
+	})
+
+	t.Run("FailsIfInvalid", func(t *testing.T) {
+		err := ValidateTag("Release Notes")
+
+		assert.Assert(t, tu.ErrorIs(err, ErrInvalidTag))
+		assert.ErrorContains(t, err, `"Release Notes"`)
+	})
+
+	t.Run("FailsIfKeyword", func(t *testing.T) {
+		assert.Assert(t, tu.ErrorIs(ValidateTag("not"), ErrInvalidTag))
+	})
+}
+
+func TestNormalizeTags(t *testing.T) {
+	t.Run("SortsAndRemovesDuplicates", func(t *testing.T) {
+		tags, err := NormalizeTags([]string{"releases", "essays", "releases"})
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, []string{"essays", "releases"}, tags)
+	})
+
+	t.Run("ReturnsNilIfEmpty", func(t *testing.T) {
+		tags, err := NormalizeTags([]string{})
+
+		assert.NilError(t, err)
+		assert.Assert(t, is.Nil(tags))
+	})
+
+	t.Run("FailsIfAnyTagIsInvalid", func(t *testing.T) {
+		tags, err := NormalizeTags([]string{"releases", "Essays"})
+
+		assert.Assert(t, tu.ErrorIs(err, ErrInvalidTag))
+		assert.Assert(t, is.Nil(tags))
+	})
+}
+
+func TestParseTagFilter(t *testing.T) {
+	parse := func(t *testing.T, expr string) *TagFilter {
+		t.Helper()
+		filter, err := ParseTagFilter(expr)
+		assert.NilError(t, err)
+		return filter
+	}
+
+	t.Run("ReturnsNilIfEmpty", func(t *testing.T) {
+		filter := parse(t, " \t")
+
+		assert.Assert(t, filter == nil)
+		assert.Assert(t, filter.Matches(nil))
+		assert.Equal(t, "", filter.String())
+	})
+
+	t.Run("MatchesSingleTag", func(t *testing.T) {
+		filter := parse(t, "releases")
+
+		assert.Assert(t, filter.Matches([]string{"essays", "releases"}))
+		assert.Assert(t, !filter.Matches([]string{"essays"}))
+		assert.Assert(t, !filter.Matches(nil))
+	})
+
+	t.Run("AndBindsMoreTightlyThanOr", func(t *testing.T) {
+		filter := parse(t, "releases or essays and drafts")
+
+		assert.Assert(t, filter.Matches([]string{"releases"}))
+		assert.Assert(t, filter.Matches([]string{"drafts", "essays"}))
+		assert.Assert(t, !filter.Matches([]string{"essays"}))
+	})
+
+	t.Run("NotBindsMostTightly", func(t *testing.T) {
+		filter := parse(t, "not releases and essays")
+
+		assert.Assert(t, filter.Matches([]string{"essays"}))
+		assert.Assert(t, !filter.Matches([]string{"essays", "releases"}))
+		assert.Assert(t, !filter.Matches(nil))
+	})
+
+	t.Run("ParenthesesGroupExpressions", func(t *testing.T) {
+		filter := parse(t, "not (releases or essays)")
+
+		assert.Assert(t, filter.Matches(nil))
+		assert.Assert(t, filter.Matches([]string{"drafts"}))
+		assert.Assert(t, !filter.Matches([]string{"essays"}))
+	})
+
+	t.Run("StringIsNormalized", func(t *testing.T) {
+		filter := parse(t, "((releases)) or (essays  and not(drafts or old))")
+
+		const expected = "releases or essays and not (drafts or old)"
+		assert.Equal(t, expected, filter.String())
+		assert.Equal(t, expected, parse(t, expected).String())
+	})
+
+	t.Run("StringParenthesizesOrWithinAnd", func(t *testing.T) {
+		filter := parse(t, "(releases or essays) and not drafts")
+
+		const expected = "(releases or essays) and not drafts"
+		assert.Equal(t, expected, filter.String())
+	})
+
+	t.Run("Fails", func(t *testing.T) {
+		for _, tc := range []struct{ expr, errMsg string }{
+			{"releases and", "unexpected end of expression"},
+			{"releases essays", `unexpected "essays"`},
+			{"(releases or essays", `missing ")"`},
+			{"releases)", `unexpected ")"`},
+			{"or releases", `unexpected "or"`},
+			{"Releases", `invalid tag: "Releases"`},
+		} {
+			filter, err := ParseTagFilter(tc.expr)
+
+			assert.Assert(t, is.Nil(filter), tc.expr)
+			expected := `invalid tag filter "` + tc.expr + `": ` + tc.errMsg
+			assert.Error(t, err, expected)
+		}
+	})
+}
+
+func TestTagFilterIndexTags(t *testing.T) {
+	indexTags := func(t *testing.T, expr string) ([]string, bool) {
+		t.Helper()
+		filter, err := ParseTagFilter(expr)
+		assert.NilError(t, err)
+		return filter.IndexTags()
+	}
+
+	t.Run("NilFilterHasNoIndexTags", func(t *testing.T) {
+		tags, ok := indexTags(t, "")
+
+		assert.Assert(t, !ok)
+		assert.Assert(t, is.Nil(tags))
+	})
+
+	t.Run("Tag", func(t *testing.T) {
+		tags, ok := indexTags(t, "releases")
+
+		assert.Assert(t, ok)
+		assert.DeepEqual(t, []string{"releases"}, tags)
+	})
+
+	t.Run("OrUsesEveryOperand", func(t *testing.T) {
+		tags, ok := indexTags(t, "releases or essays or releases")
+
+		assert.Assert(t, ok)
+		assert.DeepEqual(t, []string{"essays", "releases"}, tags)
+	})
+
+	t.Run("AndUsesOperandWithFewestTags", func(t *testing.T) {
+		tags, ok := indexTags(t, "(releases or essays) and not old and drafts")
+
+		assert.Assert(t, ok)
+		assert.DeepEqual(t, []string{"drafts"}, tags)
+	})
+
+	t.Run("NotHasNoIndexTags", func(t *testing.T) {
+		_, ok := indexTags(t, "releases or not essays")
+
+		assert.Assert(t, !ok)
+	})
+}
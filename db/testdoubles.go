@@ -4,6 +4,8 @@ package db
 
 import (
 	"context"
+	"slices"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
@@ -23,21 +25,34 @@ import (
 // dynamodb_contract_test tests and validates these individual operations. Given
 // that, CreateSubscribersTable can then be tested more quickly and reliably
 // using this test double.
+//
+// UpdateTable is implemented just enough to test MigrateSubscribersTable. It
+// adds each Global Secondary Index it creates to DescTableOutput with the
+// status from UpdateTableIndexStatus, which defaults to ACTIVE.
+//
+// GetItem and Query are implemented just enough to test querying the tag index
+// via ProcessTaggedSubscribersFrom. GetItem returns records from Subscribers,
+// and Query only supports the tag index, whose records addSubscribers adds.
 type TestDynamoDbClient struct {
-	ServerErr         error
-	CreateTableInput  *dynamodb.CreateTableInput
-	CreateTableOutput *dynamodb.CreateTableOutput
-	CreateTableErr    error
-	DescTableInput    *dynamodb.DescribeTableInput
-	DescTableOutput   *dynamodb.DescribeTableOutput
-	DescTableErr      error
-	UpdateTtlInput    *dynamodb.UpdateTimeToLiveInput
-	UpdateTtlOutput   *dynamodb.UpdateTimeToLiveOutput
-	UpdateTtlErr      error
-	Subscribers       []dbAttributes
-	ScanSize          int
-	ScanCalls         int
-	ScanErr           error
+	ServerErr              error
+	CreateTableInput       *dynamodb.CreateTableInput
+	CreateTableOutput      *dynamodb.CreateTableOutput
+	CreateTableErr         error
+	DescTableInput         *dynamodb.DescribeTableInput
+	DescTableOutput        *dynamodb.DescribeTableOutput
+	DescTableErr           error
+	UpdateTtlInput         *dynamodb.UpdateTimeToLiveInput
+	UpdateTtlOutput        *dynamodb.UpdateTimeToLiveOutput
+	UpdateTtlErr           error
+	UpdateTableInputs      []*dynamodb.UpdateTableInput
+	UpdateTableErr         error
+	UpdateTableIndexStatus types.IndexStatus
+	Subscribers            []dbAttributes
+	ScanSize               int
+	ScanCalls              int
+	ScanInput              *dynamodb.ScanInput
+	ScanErr                error
+	QueryCalls             int
 }
 
 // NewTestDynamoDbClient returns an initialized TestDynamoDbClient.
@@ -68,6 +83,7 @@ func (client *TestDynamoDbClient) SetAllErrors(msg string) {
 	client.CreateTableErr = err
 	client.DescTableErr = err
 	client.UpdateTtlErr = err
+	client.UpdateTableErr = err
 	client.ScanErr = err
 }
 
@@ -83,6 +99,10 @@ func (client *TestDynamoDbClient) SetUpdateTimeToLiveError(msg string) {
 	client.UpdateTtlErr = testutils.AwsServerError(msg)
 }
 
+func (client *TestDynamoDbClient) SetUpdateTableError(msg string) {
+	client.UpdateTableErr = testutils.AwsServerError(msg)
+}
+
 func (client *TestDynamoDbClient) SetScanError(msg string) {
 	client.ScanErr = testutils.AwsServerError(msg)
 }
@@ -114,6 +134,33 @@ func (client *TestDynamoDbClient) UpdateTimeToLive(
 	return client.UpdateTtlOutput, client.UpdateTtlErr
 }
 
+func (client *TestDynamoDbClient) UpdateTable(
+	_ context.Context,
+	input *dynamodb.UpdateTableInput,
+	_ ...func(*dynamodb.Options),
+) (*dynamodb.UpdateTableOutput, error) {
+	client.UpdateTableInputs = append(client.UpdateTableInputs, input)
+
+	if client.UpdateTableErr != nil {
+		return nil, client.UpdateTableErr
+	}
+	status := client.UpdateTableIndexStatus
+	if status == "" {
+		status = types.IndexStatusActive
+	}
+	table := client.DescTableOutput.Table
+
+	for _, update := range input.GlobalSecondaryIndexUpdates {
+		table.GlobalSecondaryIndexes = append(
+			table.GlobalSecondaryIndexes,
+			types.GlobalSecondaryIndexDescription{
+				IndexName: update.Create.IndexName, IndexStatus: status,
+			},
+		)
+	}
+	return &dynamodb.UpdateTableOutput{TableDescription: table}, nil
+}
+
 func (client *TestDynamoDbClient) DeleteTable(
 	context.Context, *dynamodb.DeleteTableInput, ...func(*dynamodb.Options),
 ) (*dynamodb.DeleteTableOutput, error) {
@@ -121,9 +168,23 @@ func (client *TestDynamoDbClient) DeleteTable(
 }
 
 func (client *TestDynamoDbClient) GetItem(
-	context.Context, *dynamodb.GetItemInput, ...func(*dynamodb.Options),
-) (*dynamodb.GetItemOutput, error) {
-	return nil, client.ServerErr
+	_ context.Context,
+	input *dynamodb.GetItemInput,
+	_ ...func(*dynamodb.Options),
+) (output *dynamodb.GetItemOutput, err error) {
+	if err = client.ServerErr; err != nil {
+		return
+	}
+	key := getPrimaryKey(input.Key)
+	output = &dynamodb.GetItemOutput{}
+
+	for _, record := range client.Subscribers {
+		if getPrimaryKey(record) == key {
+			output.Item = record
+			break
+		}
+	}
+	return
 }
 
 func (client *TestDynamoDbClient) PutItem(
@@ -138,14 +199,76 @@ func (client *TestDynamoDbClient) DeleteItem(
 	return nil, client.ServerErr
 }
 
+func (client *TestDynamoDbClient) UpdateItem(
+	context.Context, *dynamodb.UpdateItemInput, ...func(*dynamodb.Options),
+) (*dynamodb.UpdateItemOutput, error) {
+	return nil, client.ServerErr
+}
+
+func (client *TestDynamoDbClient) Query(
+	_ context.Context,
+	input *dynamodb.QueryInput,
+	_ ...func(*dynamodb.Options),
+) (output *dynamodb.QueryOutput, err error) {
+	client.QueryCalls++
+
+	if err = client.ServerErr; err != nil {
+		return
+	} else if aws.ToString(input.IndexName) != DynamoDbTagIndexName {
+		err = testutils.AwsServerError("only the tag index is implemented")
+		return
+	}
+
+	values := input.ExpressionAttributeValues
+	partition := values[":tagIndex"].(*dbString).Value
+	startEmail := ""
+	if start, ok := values[":startEmail"].(*dbString); ok {
+		startEmail = start.Value
+	}
+	getString := func(record dbAttributes, name string) (value string) {
+		value, _ = (&dbParser{record}).GetString(name)
+		return
+	}
+	items := []dbAttributes{}
+
+	for _, record := range client.Subscribers {
+		if getString(record, DynamoDbTagIndexPartitionKey) != partition {
+			continue
+		} else if getString(record, DynamoDbTagIndexSortKey) > startEmail {
+			items = append(items, record)
+		}
+	}
+	slices.SortFunc(items, func(lhs, rhs dbAttributes) int {
+		return strings.Compare(
+			getString(lhs, DynamoDbTagIndexSortKey),
+			getString(rhs, DynamoDbTagIndexSortKey),
+		)
+	})
+	output = &dynamodb.QueryOutput{Items: items}
+	return
+}
+
+func getPrimaryKey(record dbAttributes) (key string) {
+	key, _ = (&dbParser{record}).GetString(DynamoDbPrimaryKey)
+	return
+}
+
 func (client *TestDynamoDbClient) addSubscriberRecord(sub dbAttributes) {
 	client.Subscribers = append(client.Subscribers, sub)
 }
 
+// addSubscribers adds the records for each of subs, including the tag records
+// that DynamoDb.Put would add.
 func (client *TestDynamoDbClient) addSubscribers(subs []*Subscriber) {
 	for _, sub := range subs {
 		subRec := newSubscriberRecord(sub)
 		client.Subscribers = append(client.Subscribers, subRec)
+
+		dyndb := &DynamoDb{List: sub.List}
+		for _, tag := range indexedTags(sub) {
+			tagRec := dyndb.newTagRecord(tag, sub.Email)
+			client.Subscribers = append(client.Subscribers, tagRec)
+		}
 	}
 }
 
@@ -153,6 +276,7 @@ func (client *TestDynamoDbClient) Scan(
 	_ context.Context, input *dynamodb.ScanInput, _ ...func(*dynamodb.Options),
 ) (output *dynamodb.ScanOutput, err error) {
 	client.ScanCalls++
+	client.ScanInput = input
 
 	err = client.ScanErr
 	if err != nil {
@@ -161,11 +285,14 @@ func (client *TestDynamoDbClient) Scan(
 
 	// Remember that our schema is to keep pending and verified subscribers
 	// partitioned across disjoint Global Secondary Indexes. So we first filter
-	// for subscribers in the desired state.
+	// for subscribers in the desired state, then for those in the desired list
+	// (see DynamoDb.addListFilter).
 	subscribers := make([]dbAttributes, 0, len(client.Subscribers))
 	for _, sub := range client.Subscribers {
 		if _, ok := sub[aws.ToString(input.IndexName)]; !ok {
 			continue
+		} else if !matchesListFilter(input, sub) {
+			continue
 		}
 		subscribers = append(subscribers, sub)
 	}
@@ -201,10 +328,16 @@ func (client *TestDynamoDbClient) Scan(
 	return
 }
 
-func newSubscriberRecord(sub *Subscriber) dbAttributes {
-	return dbAttributes{
-		"email":            &dbString{Value: sub.Email},
-		"uid":              &dbString{Value: sub.Uid.String()},
-		string(sub.Status): toDynamoDbTimestamp(sub.Timestamp),
+func matchesListFilter(input *dynamodb.ScanInput, sub dbAttributes) bool {
+	listPrefix, ok := input.ExpressionAttributeValues[":list"].(*dbString)
+	if !ok {
+		return true
+	}
+	email, _ := (&dbParser{sub}).GetString("email")
+	hasPrefix := strings.HasPrefix(email, listPrefix.Value)
+
+	if strings.HasPrefix(aws.ToString(input.FilterExpression), "NOT ") {
+		return !hasPrefix
 	}
+	return hasPrefix
 }
@@ -0,0 +1,47 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/mbland/elistman/types"
+)
+
+// Tombstone records that an address left the list after its Subscriber was
+// deleted.
+//
+// Reason is TombstoneUnsubscribe if the subscriber asked to leave. Otherwise it
+// matches the ops.RemoveReason for removing the address due to a bounce or
+// complaint.
+type Tombstone struct {
+	Email     string
+	Reason    TombstoneReason
+	Timestamp time.Time
+}
+
+type TombstoneReason string
+
+const (
+	TombstoneUnsubscribe TombstoneReason = "unsubscribe"
+	TombstoneBounce      TombstoneReason = "Bounce"
+	TombstoneComplaint   TombstoneReason = "Complaint"
+)
+
+// TombstoneStore saves and retrieves Tombstone records.
+//
+// Each address has at most one Tombstone. PutTombstone replaces any existing
+// Tombstone for the same address. DeleteTombstone doesn't return an error if
+// there's no Tombstone for the address. ListTombstones returns every Tombstone
+// in no particular order, for backing them up.
+type TombstoneStore interface {
+	GetTombstone(ctx context.Context, email string) (*Tombstone, error)
+	PutTombstone(ctx context.Context, tombstone *Tombstone) error
+	DeleteTombstone(ctx context.Context, email string) error
+	ListTombstones(ctx context.Context) ([]*Tombstone, error)
+}
+
+// ErrTombstoneNotFound indicates that there's no Tombstone for an address.
+//
+// TombstoneStore.GetTombstone returns this error when the underlying request
+// succeeded, but there was no such Tombstone.
+const ErrTombstoneNotFound = types.SentinelError("tombstone not found")
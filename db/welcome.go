@@ -0,0 +1,28 @@
+package db
+
+import (
+	"context"
+
+	"github.com/mbland/elistman/email"
+	"github.com/mbland/elistman/types"
+)
+
+// WelcomeStore saves and retrieves the message that welcomes new subscribers
+// to a list.
+//
+// Each list has at most one welcome message. PutWelcomeMessage replaces any
+// existing welcome message. DeleteWelcomeMessage doesn't return an error if
+// there's no welcome message.
+type WelcomeStore interface {
+	GetWelcomeMessage(ctx context.Context) (*email.Message, error)
+	PutWelcomeMessage(ctx context.Context, msg *email.Message) error
+	DeleteWelcomeMessage(ctx context.Context) error
+}
+
+// ErrWelcomeMessageNotFound indicates that a list has no welcome message.
+//
+// WelcomeStore.GetWelcomeMessage returns this error when the underlying request
+// succeeded, but there was no welcome message.
+const ErrWelcomeMessageNotFound = types.SentinelError(
+	"welcome message not found",
+)
@@ -0,0 +1,293 @@
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+)
+
+// MaxRawMessageSize is the largest raw message SES will accept, in bytes.
+//
+// This limit applies to the entire message after encoding, including all
+// attachments and inline parts. Messages sent via the elistman CLI are further
+// limited by the Lambda synchronous invocation payload size, which cmd.Lambda
+// enforces before invoking the function.
+//
+// See: https://docs.aws.amazon.com/ses/latest/dg/quotas.html
+const MaxRawMessageSize = 40 * 1024 * 1024
+
+// Attachment is a file included with a Message.
+//
+// Content is base64 encoded in the JSON representation of a Message.
+//
+// ContentId is required for Message.Inline parts, and identifies the part
+// within HtmlBody via a "cid:" URL, such as <img src="cid:logo">. It's ignored
+// for Message.Attachments.
+type Attachment struct {
+	Filename    string
+	ContentType string
+	ContentId   string `json:",omitempty"`
+	Content     []byte
+}
+
+func (a *Attachment) validate(field string, index int) []error {
+	errs := make([]error, 0, 3)
+	addErr := func(format string, args ...any) {
+		prefix := fmt.Sprintf("%s[%d] ", field, index)
+		errs = append(errs, fmt.Errorf(prefix+format, args...))
+	}
+
+	if a.Filename == "" {
+		addErr("missing Filename")
+	} else if strings.ContainsAny(a.Filename, "\r\n") {
+		addErr("Filename contains a newline")
+	}
+	if a.ContentType == "" {
+		addErr("missing ContentType")
+	} else if _, _, err := mime.ParseMediaType(a.ContentType); err != nil {
+		addErr("invalid ContentType \"%s\": %s", a.ContentType, err)
+	}
+	if len(a.Content) == 0 {
+		addErr("missing Content")
+	}
+	return errs
+}
+
+func (msg *Message) validateAttachments() (errs []error) {
+	for i := range msg.Attachments {
+		errs = append(errs, msg.Attachments[i].validate("Attachments", i)...)
+	}
+
+	if len(msg.Inline) != 0 && len(msg.HtmlBody) == 0 {
+		errs = append(errs, errors.New("Inline present, but HtmlBody missing"))
+	}
+	contentIds := make(map[string]bool, len(msg.Inline))
+
+	for i := range msg.Inline {
+		part := &msg.Inline[i]
+		errs = append(errs, part.validate("Inline", i)...)
+		addErr := func(format string) {
+			errFmt := "Inline[%d] " + format
+			errs = append(errs, fmt.Errorf(errFmt, i, part.ContentId))
+		}
+
+		if part.ContentId == "" {
+			errs = append(errs, fmt.Errorf("Inline[%d] missing ContentId", i))
+		} else if strings.ContainsAny(part.ContentId, "<>\"\r\n\t ") {
+			addErr("invalid ContentId \"%s\"")
+		} else if contentIds[part.ContentId] {
+			addErr("duplicate ContentId \"%s\"")
+		} else {
+			contentIds[part.ContentId] = true
+			cid := "cid:" + part.ContentId
+
+			if len(msg.HtmlBody) != 0 && !strings.Contains(msg.HtmlBody, cid) {
+				addErr("ContentId \"%s\" not referenced in HtmlBody")
+			}
+		}
+	}
+	return
+}
+
+// validateSize ensures the generated message won't exceed MaxRawMessageSize.
+//
+// The size is measured using a Recipient with no personalization values or
+// unsubscribe info, so the message sent to each subscriber will be slightly
+// larger. In practice, attachments account for nearly all of the size of any
+// message approaching the limit.
+func (msg *Message) validateSize() error {
+	bc := &byteCounter{}
+
+	// byteCounter never returns an error.
+	NewMessageTemplate(msg).EmitMessage(bc, &Recipient{})
+
+	if bc.n > MaxRawMessageSize {
+		const errFmt = "message size of %d bytes exceeds limit of %d bytes"
+		return fmt.Errorf(errFmt, bc.n, MaxRawMessageSize)
+	}
+	return nil
+}
+
+type byteCounter struct {
+	n int
+}
+
+func (bc *byteCounter) Write(b []byte) (int, error) {
+	bc.n += len(b)
+	return len(b), nil
+}
+
+// attachmentPart is an Attachment with its part headers and base64 encoded
+// content generated once by NewMessageTemplate.
+type attachmentPart struct {
+	header  textproto.MIMEHeader
+	content []byte
+}
+
+func newAttachmentPart(a *Attachment, disposition string) *attachmentPart {
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Type", a.ContentType)
+	h.Set("Content-Transfer-Encoding", "base64")
+	h.Set(
+		"Content-Disposition",
+		mime.FormatMediaType(
+			disposition, map[string]string{"filename": a.Filename},
+		),
+	)
+	if disposition == "inline" {
+		h.Set("Content-ID", "<"+a.ContentId+">")
+	}
+	return &attachmentPart{header: h, content: encodeBase64(a.Content)}
+}
+
+func newAttachmentParts(
+	attachments []Attachment, disposition string,
+) (parts []*attachmentPart) {
+	parts = make([]*attachmentPart, len(attachments))
+
+	for i := range attachments {
+		parts[i] = newAttachmentPart(&attachments[i], disposition)
+	}
+	return
+}
+
+// base64LineLength is the maximum encoded line length allowed by RFC 2045.
+const base64LineLength = 76
+
+func encodeBase64(content []byte) []byte {
+	encoded := base64.StdEncoding.EncodeToString(content)
+	b := &bytes.Buffer{}
+
+	for len(encoded) > base64LineLength {
+		b.WriteString(encoded[:base64LineLength])
+		b.Write(crlf)
+		encoded = encoded[base64LineLength:]
+	}
+	b.WriteString(encoded)
+	b.Write(crlf)
+	return b.Bytes()
+}
+
+func emitAttachments(w *multipart.Writer, parts []*attachmentPart) error {
+	for _, part := range parts {
+		if pw, err := w.CreatePart(part.header); err != nil {
+			return err
+		} else if _, err = pw.Write(part.content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newNestedWriter creates a multipart.Writer for a multipart entity nested
+// within a new part of parent.
+func newNestedWriter(
+	parent *multipart.Writer, mediaType string,
+) (nested *multipart.Writer, err error) {
+	// multipart.Writer doesn't write anything until it creates a part, so this
+	// is an easy way to generate a random boundary.
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+	params := map[string]string{"boundary": boundary}
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Type", mime.FormatMediaType(mediaType, params))
+	var pw io.Writer
+
+	if pw, err = parent.CreatePart(h); err == nil {
+		nested = multipart.NewWriter(pw)
+		err = nested.SetBoundary(boundary)
+	}
+	return
+}
+
+// emitWithAttachments emits a message containing Attachments, Inline parts, or
+// both.
+//
+// The structure of the message is:
+//
+//	multipart/mixed (if there are Attachments)
+//	  multipart/related (if there are Inline parts)
+//	    multipart/alternative
+//	      text/plain
+//	      text/html
+//	    Inline parts...
+//	  Attachments...
+func (mt *MessageTemplate) emitWithAttachments(w *writer, sub *Recipient) {
+	mpw := multipart.NewWriter(w)
+	mediaType := "multipart/related"
+	emitParts := mt.emitRelatedParts
+
+	if len(mt.attachments) != 0 {
+		mediaType = "multipart/mixed"
+		emitParts = mt.emitMixedParts
+	}
+	contentType := mime.FormatMediaType(
+		mediaType, map[string]string{"boundary": mpw.Boundary()},
+	)
+	w.Write(contentTypeHeader)
+	w.WriteLine(contentType)
+	w.Write(crlf)
+
+	if err := emitParts(mpw, sub); err != nil && w.err == nil {
+		w.err = err
+	}
+}
+
+func (mt *MessageTemplate) emitMixedParts(
+	mpw *multipart.Writer, sub *Recipient,
+) (err error) {
+	if len(mt.inline) == 0 {
+		err = mt.emitContent(mpw, sub)
+	} else {
+		var related *multipart.Writer
+		related, err = newNestedWriter(mpw, "multipart/related")
+
+		if err == nil {
+			err = mt.emitRelatedParts(related, sub)
+		}
+	}
+
+	if err == nil {
+		err = emitAttachments(mpw, mt.attachments)
+	}
+	if err == nil {
+		err = mpw.Close()
+	}
+	return
+}
+
+func (mt *MessageTemplate) emitRelatedParts(
+	mpw *multipart.Writer, sub *Recipient,
+) (err error) {
+	if err = mt.emitContent(mpw, sub); err != nil {
+		return
+	} else if err = emitAttachments(mpw, mt.inline); err != nil {
+		return
+	}
+	return mpw.Close()
+}
+
+// emitContent emits the text and HTML content of the message as a part of a
+// multipart message containing attachments.
+func (mt *MessageTemplate) emitContent(
+	mpw *multipart.Writer, sub *Recipient,
+) error {
+	if mt.htmlBody.isEmpty() {
+		h := textproto.MIMEHeader{}
+		h.Add("Content-Transfer-Encoding", "quoted-printable")
+		tb := mt.textBody
+		tf := mt.textFooter
+		return emitPart(mpw, h, textContentType, tb, tf, sub)
+	}
+
+	alternative, err := newNestedWriter(mpw, "multipart/alternative")
+	if err != nil {
+		return err
+	}
+	return mt.emitAlternativeParts(alternative, sub)
+}
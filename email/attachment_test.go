@@ -0,0 +1,337 @@
+//go:build small_tests || all_tests
+
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	tu "github.com/mbland/elistman/testutils"
+	"gotest.tools/assert"
+)
+
+var testAttachment = Attachment{
+	Filename:    "report.pdf",
+	ContentType: "application/pdf",
+	Content:     []byte("%PDF-1.7 not really a PDF"),
+}
+
+var testInline = Attachment{
+	Filename:    "logo.png",
+	ContentType: "image/png",
+	ContentId:   "logo",
+	Content:     []byte("\x89PNG not really a PNG"),
+}
+
+func newAttachmentTestMessage(attachments, inline []Attachment) *Message {
+	msg := *testMessage
+	msg.HtmlBody = strings.Replace(
+		msg.HtmlBody, "<body>", "<body><img src=\"cid:logo\">", 1,
+	)
+	msg.Attachments = attachments
+	msg.Inline = inline
+	return &msg
+}
+
+func TestEncodeBase64(t *testing.T) {
+	t.Run("EncodesShortContentOnOneLine", func(t *testing.T) {
+		assert.Equal(t, "Zm9vYmFy\r\n", string(encodeBase64([]byte("foobar"))))
+	})
+
+	t.Run("WrapsLinesAt76Characters", func(t *testing.T) {
+		content := bytes.Repeat([]byte("0123456789"), 12)
+
+		encoded := string(encodeBase64(content))
+
+		lines := strings.Split(strings.TrimSuffix(encoded, "\r\n"), "\r\n")
+		assert.Equal(t, 3, len(lines))
+		assert.Equal(t, base64LineLength, len(lines[0]))
+		assert.Equal(t, base64LineLength, len(lines[1]))
+		decoded, err := base64.StdEncoding.DecodeString(strings.Join(lines, ""))
+		assert.NilError(t, err)
+		assert.DeepEqual(t, content, decoded)
+	})
+}
+
+func TestAttachmentJsonMarshaling(t *testing.T) {
+	msg := newAttachmentTestMessage(
+		[]Attachment{testAttachment}, []Attachment{testInline},
+	)
+
+	msgJson, err := json.Marshal(msg)
+	assert.NilError(t, err)
+
+	encoded := base64.StdEncoding.EncodeToString(testAttachment.Content)
+	assert.Assert(t, strings.Contains(string(msgJson), encoded))
+
+	var parsed Message
+	assert.NilError(t, json.Unmarshal(msgJson, &parsed))
+	assert.DeepEqual(t, msg, &parsed)
+}
+
+func TestValidateAttachments(t *testing.T) {
+	setup := func() *Message {
+		return newAttachmentTestMessage(
+			[]Attachment{testAttachment}, []Attachment{testInline},
+		)
+	}
+
+	assertErrors := func(t *testing.T, msg *Message, errs ...string) {
+		t.Helper()
+		expected := "message failed validation: " + strings.Join(errs, "\n")
+		assert.Error(t, msg.Validate(), expected)
+	}
+
+	t.Run("Succeeds", func(t *testing.T) {
+		assert.NilError(t, setup().Validate())
+	})
+
+	t.Run("FailsIfAttachmentFieldsMissing", func(t *testing.T) {
+		msg := setup()
+		msg.Attachments = append(msg.Attachments, Attachment{})
+
+		assertErrors(
+			t,
+			msg,
+			"Attachments[1] missing Filename",
+			"Attachments[1] missing ContentType",
+			"Attachments[1] missing Content",
+		)
+	})
+
+	t.Run("FailsIfFilenameOrContentTypeInvalid", func(t *testing.T) {
+		msg := setup()
+		msg.Attachments[0].Filename = "report.pdf\r\nBcc: evil@foo.com"
+		msg.Attachments[0].ContentType = "application/pdf; ="
+
+		assertErrors(
+			t,
+			msg,
+			"Attachments[0] Filename contains a newline",
+			"Attachments[0] invalid ContentType \"application/pdf; =\": "+
+				"mime: invalid media parameter",
+		)
+	})
+
+	t.Run("FailsIfInlineWithoutHtmlBody", func(t *testing.T) {
+		msg := setup()
+		msg.HtmlBody = ""
+		msg.HtmlFooter = ""
+
+		assertErrors(t, msg, "Inline present, but HtmlBody missing")
+	})
+
+	t.Run("FailsIfContentIdMissing", func(t *testing.T) {
+		msg := setup()
+		msg.Inline[0].ContentId = ""
+
+		assertErrors(t, msg, "Inline[0] missing ContentId")
+	})
+
+	t.Run("FailsIfContentIdInvalid", func(t *testing.T) {
+		msg := setup()
+		msg.Inline[0].ContentId = "<logo>"
+
+		assertErrors(t, msg, "Inline[0] invalid ContentId \"<logo>\"")
+	})
+
+	t.Run("FailsIfContentIdDuplicated", func(t *testing.T) {
+		msg := setup()
+		msg.Inline = append(msg.Inline, testInline)
+
+		assertErrors(t, msg, "Inline[1] duplicate ContentId \"logo\"")
+	})
+
+	t.Run("FailsIfContentIdNotReferencedInHtmlBody", func(t *testing.T) {
+		msg := setup()
+		msg.Inline[0].ContentId = "banner"
+
+		assertErrors(
+			t, msg, "Inline[0] ContentId \"banner\" not referenced in HtmlBody",
+		)
+	})
+
+	t.Run("FailsIfMessageExceedsMaxRawMessageSize", func(t *testing.T) {
+		msg := setup()
+		// Base64 encoding increases the size by a third, plus line breaks.
+		msg.Attachments[0].Content = make([]byte, MaxRawMessageSize*3/4)
+
+		err := msg.Validate()
+
+		assert.ErrorContains(t, err, "message failed validation: message size")
+		assert.ErrorContains(t, err, "exceeds limit of 41943040 bytes")
+	})
+}
+
+func parseMultipart(
+	t *testing.T, h textproto.MIMEHeader, body io.Reader, mediaType string,
+) *multipart.Reader {
+	t.Helper()
+
+	params := tu.AssertContentTypeAndGetParams(t, h, mediaType)
+	return multipart.NewReader(body, params["boundary"])
+}
+
+func nextPart(t *testing.T, pr *multipart.Reader) *multipart.Part {
+	t.Helper()
+
+	part, err := pr.NextPart()
+	assert.NilError(t, err)
+	return part
+}
+
+func assertAlternativePart(t *testing.T, pr *multipart.Reader) {
+	t.Helper()
+
+	part := nextPart(t, pr)
+	alt := parseMultipart(t, part.Header, part, "multipart/alternative")
+	tu.AssertNextPart(t, alt, "text/plain", decodedTextContent)
+	tu.AssertNextPart(t, alt, "text/html", decodedHtmlContent)
+	assertNoMoreParts(t, alt)
+}
+
+func assertAttachmentPart(
+	t *testing.T, pr *multipart.Reader, disposition string, a *Attachment,
+) {
+	t.Helper()
+
+	part := nextPart(t, pr)
+	th := tu.TestHeader{Header: map[string][]string(part.Header)}
+	th.Assert(t, "Content-Type", a.ContentType)
+	th.Assert(t, "Content-Transfer-Encoding", "base64")
+	if d, params, err := mime.ParseMediaType(
+		part.Header.Get("Content-Disposition"),
+	); err != nil {
+		t.Fatalf("couldn't parse Content-Disposition: %s", err)
+	} else {
+		assert.Equal(t, disposition, d)
+		assert.Equal(t, a.Filename, params["filename"])
+	}
+	if disposition == "inline" {
+		th.Assert(t, "Content-Id", "<"+a.ContentId+">")
+	}
+
+	content, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, part))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, a.Content, content)
+}
+
+func assertNoMoreParts(t *testing.T, pr *multipart.Reader) {
+	t.Helper()
+
+	_, err := pr.NextPart()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestGenerateMessageWithAttachments(t *testing.T) {
+	generate := func(
+		t *testing.T, attachments, inline []Attachment, mediaType string,
+	) *multipart.Reader {
+		t.Helper()
+
+		msg := *testMessage
+		msg.Attachments = attachments
+		msg.Inline = inline
+		content := string(NewMessageTemplate(&msg).GenerateMessage(
+			newTestRecipient(),
+		))
+
+		parsed := tu.ParseMessage(t, content)
+		assertMessageHeaders(t, parsed, content)
+		h := textproto.MIMEHeader(parsed.Header)
+		return parseMultipart(t, h, parsed.Body, mediaType)
+	}
+
+	t.Run("GeneratesMixedMessageWithAttachments", func(t *testing.T) {
+		attachments := []Attachment{testAttachment, testAttachment}
+		attachments[1].Filename = "report copy.pdf"
+
+		pr := generate(t, attachments, nil, "multipart/mixed")
+
+		assertAlternativePart(t, pr)
+		assertAttachmentPart(t, pr, "attachment", &attachments[0])
+		assertAttachmentPart(t, pr, "attachment", &attachments[1])
+		assertNoMoreParts(t, pr)
+	})
+
+	t.Run("GeneratesRelatedMessageWithInlineParts", func(t *testing.T) {
+		pr := generate(t, nil, []Attachment{testInline}, "multipart/related")
+
+		assertAlternativePart(t, pr)
+		assertAttachmentPart(t, pr, "inline", &testInline)
+		assertNoMoreParts(t, pr)
+	})
+
+	t.Run("GeneratesMixedMessageWithRelatedPart", func(t *testing.T) {
+		pr := generate(
+			t,
+			[]Attachment{testAttachment},
+			[]Attachment{testInline},
+			"multipart/mixed",
+		)
+
+		part := nextPart(t, pr)
+		related := parseMultipart(t, part.Header, part, "multipart/related")
+		assertAlternativePart(t, related)
+		assertAttachmentPart(t, related, "inline", &testInline)
+		assertNoMoreParts(t, related)
+		assertAttachmentPart(t, pr, "attachment", &testAttachment)
+		assertNoMoreParts(t, pr)
+	})
+
+	t.Run("GeneratesTextOnlyContentWithAttachments", func(t *testing.T) {
+		mt := *testTemplate
+		mt.htmlBody = constantTemplate("")
+		mt.htmlFooter = constantTemplate("")
+		mt.attachments = newAttachmentParts(
+			[]Attachment{testAttachment}, "attachment",
+		)
+
+		content := string(mt.GenerateMessage(newTestRecipient()))
+
+		parsed := tu.ParseMessage(t, content)
+		h := textproto.MIMEHeader(parsed.Header)
+		pr := parseMultipart(t, h, parsed.Body, "multipart/mixed")
+		tu.AssertNextPart(t, pr, "text/plain", decodedTextContent)
+		assertAttachmentPart(t, pr, "attachment", &testAttachment)
+		assertNoMoreParts(t, pr)
+	})
+}
+
+func TestEmitWithAttachmentsReturnsWriteErrors(t *testing.T) {
+	msg := newAttachmentTestMessage(
+		[]Attachment{testAttachment}, []Attachment{testInline},
+	)
+	mt := NewMessageTemplate(msg)
+
+	emit := func(errorOn string) error {
+		ew := &tu.ErrWriter{
+			Buf: &strings.Builder{}, Err: errors.New("write error"),
+		}
+		ew.ErrorOn = errorOn
+		return mt.EmitMessage(ew, newTestRecipient())
+	}
+
+	for _, errorOn := range []string{
+		"Content-Type: multipart/related",
+		"Content-Type: multipart/alternative",
+		"Content-Type: text/html",
+		"Content-Disposition: inline",
+		"Content-Disposition: attachment",
+		"--\r\n",
+	} {
+		t.Run(errorOn, func(t *testing.T) {
+			err := emit(errorOn)
+
+			assert.ErrorContains(t, err, "write error")
+		})
+	}
+}
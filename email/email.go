@@ -36,9 +36,18 @@ var ExampleRecipient *Recipient = func() (r *Recipient) {
 }()
 
 func EmitPreviewMessageFromJson(input io.Reader, output io.Writer) error {
-	if mt, err := NewMessageTemplateFromJson(input); err != nil {
+	if msg, err := NewMessageFromJson(input); err != nil {
 		return err
-	} else if err = mt.EmitMessage(output, ExampleRecipient); err != nil {
+	} else {
+		return EmitPreviewMessage(msg, output)
+	}
+}
+
+// EmitPreviewMessage emits msg as it would be sent to ExampleRecipient.
+func EmitPreviewMessage(msg *Message, output io.Writer) error {
+	mt := NewMessageTemplate(msg)
+
+	if err := mt.EmitMessage(output, ExampleRecipient); err != nil {
 		return fmt.Errorf("failed to emit preview message: %w", err)
 	}
 	return nil
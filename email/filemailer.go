@@ -0,0 +1,48 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileMailer writes each message to an .eml file instead of sending it.
+//
+// It's intended for local development via `elistman serve`, so one can open
+// verification messages with an email client or a text editor.
+type FileMailer struct {
+	// Dir is the directory to which FileMailer writes messages. Send creates
+	// it if it doesn't exist.
+	Dir string
+}
+
+// BulkCapacityAvailable always returns nil.
+func (mailer *FileMailer) BulkCapacityAvailable(_ context.Context) error {
+	return nil
+}
+
+// Send writes msg to a new file in Dir whose name begins with recipient.
+//
+// messageId is the name of the file without the .eml extension.
+func (mailer *FileMailer) Send(
+	_ context.Context, recipient string, msg []byte,
+) (messageId string, err error) {
+	var f *os.File
+	pattern := strings.ReplaceAll(recipient, string(os.PathSeparator), "_")
+
+	if err = os.MkdirAll(mailer.Dir, 0o755); err != nil {
+		err = fmt.Errorf("failed to create message directory: %w", err)
+	} else if f, err = os.CreateTemp(mailer.Dir, pattern+"-*.eml"); err != nil {
+		err = fmt.Errorf("failed to create message file: %w", err)
+	} else if _, err = f.Write(msg); err != nil {
+		f.Close()
+		err = fmt.Errorf("failed to write %s: %w", f.Name(), err)
+	} else if err = f.Close(); err != nil {
+		err = fmt.Errorf("failed to write %s: %w", f.Name(), err)
+	} else {
+		messageId = strings.TrimSuffix(filepath.Base(f.Name()), ".eml")
+	}
+	return
+}
@@ -0,0 +1,66 @@
+//go:build small_tests || all_tests
+
+package email
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestFileMailer(t *testing.T) {
+	const recipient = "subscriber@foo.com"
+	const msg = "Subject: Hello\r\n\r\nHello, World!\r\n"
+	ctx := context.Background()
+
+	t.Run("BulkCapacityAlwaysAvailable", func(t *testing.T) {
+		mailer := &FileMailer{Dir: t.TempDir()}
+
+		assert.NilError(t, mailer.BulkCapacityAvailable(ctx))
+	})
+
+	t.Run("WritesEachMessageToNewFile", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "outbox")
+		mailer := &FileMailer{Dir: dir}
+
+		firstId, err := mailer.Send(ctx, recipient, []byte(msg))
+		assert.NilError(t, err)
+		secondId, err := mailer.Send(ctx, recipient, []byte(msg))
+		assert.NilError(t, err)
+
+		assert.Assert(t, firstId != secondId)
+		for _, msgId := range []string{firstId, secondId} {
+			assert.Assert(t, strings.HasPrefix(msgId, recipient+"-"), msgId)
+			content, err := os.ReadFile(filepath.Join(dir, msgId+".eml"))
+			assert.NilError(t, err)
+			assert.Equal(t, msg, string(content))
+		}
+	})
+
+	t.Run("ReplacesPathSeparatorsInRecipient", func(t *testing.T) {
+		dir := t.TempDir()
+		mailer := &FileMailer{Dir: dir}
+		evilRecipient := "../" + recipient
+
+		msgId, err := mailer.Send(ctx, evilRecipient, []byte(msg))
+
+		assert.NilError(t, err)
+		assert.Assert(t, strings.HasPrefix(msgId, ".._"+recipient), msgId)
+		_, err = os.Stat(filepath.Join(dir, msgId+".eml"))
+		assert.NilError(t, err)
+	})
+
+	t.Run("FailsIfCannotCreateDir", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "not-a-dir")
+		assert.NilError(t, os.WriteFile(file, []byte{}, 0o644))
+		mailer := &FileMailer{Dir: filepath.Join(file, "outbox")}
+
+		_, err := mailer.Send(ctx, recipient, []byte(msg))
+
+		assert.ErrorContains(t, err, "failed to create message directory: ")
+	})
+}
@@ -0,0 +1,114 @@
+package email
+
+import (
+	"bytes"
+	"mime"
+	"net/mail"
+	"strings"
+	"unicode"
+)
+
+// maxHeaderLineLength is the line length limit recommended by RFC 5322, not
+// including the trailing CRLF.
+//
+// See: https://www.rfc-editor.org/rfc/rfc5322#section-2.1.1
+const maxHeaderLineLength = 78
+
+// headerTemplate is a message header whose value may contain personalization
+// variables.
+type headerTemplate struct {
+	name  string
+	value *template
+
+	// header is the complete, encoded header if value contains no variables.
+	header []byte
+}
+
+// parseHeaderTemplate parses the value of a message header.
+//
+// If the value contains no variables, the header is encoded immediately, since
+// every Recipient will receive an identical header.
+func parseHeaderTemplate(
+	name, value string, defaults map[string]string,
+) *headerTemplate {
+	ht := &headerTemplate{
+		name: name, value: parseTemplate([]byte(value), defaults, escapeHeader),
+	}
+
+	if ht.value.isConstant() {
+		ht.header = formatHeader(name, value)
+	}
+	return ht
+}
+
+func (ht *headerTemplate) fill(r *Recipient) []byte {
+	if ht.header != nil {
+		return ht.header
+	}
+	return formatHeader(ht.name, string(ht.value.fill(r)))
+}
+
+// formatHeader produces a complete header line, encoded and folded as needed.
+//
+// A value containing non-ASCII characters is encoded using RFC 2047 "Q"
+// encoding. Lines longer than maxHeaderLineLength are folded at spaces between
+// words, and between the encoded words of an encoded value.
+//
+// See:
+//   - https://www.rfc-editor.org/rfc/rfc2047
+//   - https://www.rfc-editor.org/rfc/rfc5322#section-2.2.3
+func formatHeader(name, value string) []byte {
+	return foldHeader(name, encodeHeaderValue(value))
+}
+
+// formatAddressHeader produces a complete header line for an address header.
+//
+// If the address contains non-ASCII characters, the display name is encoded
+// using RFC 2047 "Q" encoding. The address itself remains unchanged, as is
+// any address that fails to parse.
+func formatAddressHeader(name, address string) []byte {
+	if !isAscii(address) {
+		if addr, err := mail.ParseAddress(address); err == nil {
+			address = addr.String()
+		}
+	}
+	return foldHeader(name, address)
+}
+
+func encodeHeaderValue(value string) string {
+	if isAscii(value) {
+		return value
+	}
+	return mime.QEncoding.Encode("utf-8", value)
+}
+
+func isAscii(s string) bool {
+	for i := range len(s) {
+		if s[i] > unicode.MaxASCII {
+			return false
+		}
+	}
+	return true
+}
+
+func foldHeader(name, value string) []byte {
+	b := &bytes.Buffer{}
+	b.WriteString(name)
+	b.WriteString(":")
+	lineLen := b.Len()
+
+	for _, word := range strings.Split(value, " ") {
+		// Per RFC 5322, a folded line can't contain only whitespace, so only
+		// fold before a non-empty word. This may fold the line before the first
+		// word, since an encoded word may be up to 75 characters long.
+		if word != "" && lineLen+1+len(word) > maxHeaderLineLength {
+			b.Write(crlf)
+			lineLen = 0
+		}
+		b.WriteString(" ")
+		b.WriteString(word)
+		lineLen += 1 + len(word)
+	}
+	b.Write(crlf)
+	return b.Bytes()
+}
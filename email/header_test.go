@@ -0,0 +1,176 @@
+//go:build small_tests || all_tests
+
+package email
+
+import (
+	"mime"
+	"strings"
+	"testing"
+
+	tu "github.com/mbland/elistman/testutils"
+	"gotest.tools/assert"
+)
+
+func assertHeaderLinesWithinLimit(t *testing.T, header string) {
+	t.Helper()
+
+	assert.Assert(t, strings.HasSuffix(header, "\r\n"))
+	lines := strings.Split(strings.TrimSuffix(header, "\r\n"), "\r\n")
+
+	for i, line := range lines {
+		assert.Assert(
+			t,
+			len(line) <= maxHeaderLineLength,
+			"line %d too long: %q",
+			i,
+			line,
+		)
+		if i != 0 {
+			const indentMsg = "line %d not indented: %q"
+			assert.Assert(t, strings.HasPrefix(line, " "), indentMsg, i, line)
+			assert.Assert(t, strings.TrimSpace(line) != "", "blank line %d", i)
+		}
+	}
+}
+
+// decodeHeaderValue unfolds and decodes a header produced by formatHeader.
+func decodeHeaderValue(t *testing.T, name, header string) string {
+	t.Helper()
+
+	header = strings.TrimSuffix(header, "\r\n")
+	unfolded := strings.ReplaceAll(header, "\r\n", "")
+	value, ok := strings.CutPrefix(unfolded, name+": ")
+	assert.Assert(t, ok, "unexpected header: %q", header)
+
+	decoded, err := (&mime.WordDecoder{}).DecodeHeader(value)
+	assert.NilError(t, err)
+	return decoded
+}
+
+func TestFormatHeader(t *testing.T) {
+	t.Run("LeavesShortAsciiValueUnchanged", func(t *testing.T) {
+		header := string(formatHeader("Subject", "Hello, World!"))
+
+		assert.Equal(t, "Subject: Hello, World!\r\n", header)
+	})
+
+	t.Run("FoldsLongAsciiValue", func(t *testing.T) {
+		const sentence = "All work and no play makes Jack a dull boy."
+		subject := strings.TrimSpace(strings.Repeat(sentence+" ", 4))
+
+		header := string(formatHeader("Subject", subject))
+
+		assertHeaderLinesWithinLimit(t, header)
+		assert.Assert(t, strings.Count(header, "\r\n") > 1)
+		assert.Equal(t, subject, decodeHeaderValue(t, "Subject", header))
+	})
+
+	t.Run("DoesNotFoldBeforeEmptyWords", func(t *testing.T) {
+		xs := strings.Repeat("x", 66)
+		subject := xs + "      y"
+
+		header := string(formatHeader("Subject", subject))
+
+		assert.Equal(t, "Subject: "+xs+"     \r\n y\r\n", header)
+		assert.Equal(t, subject, decodeHeaderValue(t, "Subject", header))
+	})
+
+	t.Run("EncodesMultibyteValue", func(t *testing.T) {
+		header := string(formatHeader("Subject", "Café ☕"))
+
+		assert.Equal(t, "Subject: =?utf-8?q?Caf=C3=A9_=E2=98=95?=\r\n", header)
+		assert.Equal(t, "Café ☕", decodeHeaderValue(t, "Subject", header))
+	})
+
+	t.Run("FoldsLongMultibyteValue", func(t *testing.T) {
+		subject := strings.Repeat("Ünïcödé sübjéct 🎉 ", 8)
+
+		header := string(formatHeader("Subject", subject))
+
+		assertHeaderLinesWithinLimit(t, header)
+		assert.Assert(t, strings.Count(header, "\r\n") > 1)
+		assert.Equal(t, subject, decodeHeaderValue(t, "Subject", header))
+	})
+}
+
+func TestFormatAddressHeader(t *testing.T) {
+	t.Run("LeavesAsciiAddressUnchanged", func(t *testing.T) {
+		header := string(formatAddressHeader("From", "Foo <foo@bar.com>"))
+
+		assert.Equal(t, "From: Foo <foo@bar.com>\r\n", header)
+	})
+
+	t.Run("EncodesMultibyteDisplayName", func(t *testing.T) {
+		from := "Zoë Café <zoe@bar.com>"
+		header := string(formatAddressHeader("From", from))
+
+		const expected = "From: =?utf-8?q?Zo=C3=AB_Caf=C3=A9?= " +
+			"<zoe@bar.com>\r\n"
+		assert.Equal(t, expected, header)
+		msg := tu.ParseMessage(t, header+"\r\n")
+		addr, err := msg.Header.AddressList("From")
+		assert.NilError(t, err)
+		assert.Equal(t, "Zoë Café", addr[0].Name)
+		assert.Equal(t, "zoe@bar.com", addr[0].Address)
+	})
+
+	t.Run("LeavesUnparseableAddressUnchanged", func(t *testing.T) {
+		header := string(formatAddressHeader("From", "Zoë Café"))
+
+		assert.Equal(t, "From: Zoë Café\r\n", header)
+	})
+}
+
+func TestHeaderTemplate(t *testing.T) {
+	defaults := map[string]string{"FirstName": "amigo"}
+
+	t.Run("EncodesConstantHeaderOnce", func(t *testing.T) {
+		ht := parseHeaderTemplate("Subject", "¡Hola!", defaults)
+
+		const expected = "Subject: =?utf-8?q?=C2=A1Hola!?=\r\n"
+		assert.Equal(t, expected, string(ht.header))
+		assert.Equal(t, expected, string(ht.fill(newTestRecipient())))
+	})
+
+	t.Run("EncodesHeaderForEachRecipient", func(t *testing.T) {
+		ht := parseHeaderTemplate("Subject", "Hi, {{FirstName}}!", defaults)
+		r := newTestRecipient()
+
+		assert.Assert(t, ht.header == nil)
+		assert.Equal(t, "Subject: Hi, amigo!\r\n", string(ht.fill(r)))
+
+		r.FirstName = "Zoë\r\nBcc: evil@foo.com"
+		header := string(ht.fill(r))
+
+		const expected = "Subject: " +
+			"=?utf-8?q?Hi,_Zo=C3=AB__Bcc:_evil@foo.com!?=\r\n"
+		assert.Equal(t, expected, header)
+	})
+}
+
+func TestGenerateMessageWithMultibyteHeaders(t *testing.T) {
+	msg := *testMessage
+	msg.From = "Zoë Café <zoe@foo.com>"
+	msg.Subject = "Ünïcödé sübjéct for {{FirstName}} 🎉"
+	msg.Defaults = map[string]string{"FirstName": "friend"}
+	r := newTestRecipient()
+	r.FirstName = "José"
+
+	content := string(NewMessageTemplate(&msg).GenerateMessage(r))
+
+	parsed := tu.ParseMessage(t, content)
+	addr, err := parsed.Header.AddressList("From")
+	assert.NilError(t, err)
+	assert.Equal(t, "Zoë Café", addr[0].Name)
+
+	subject, err := (&mime.WordDecoder{}).DecodeHeader(
+		parsed.Header.Get("Subject"),
+	)
+	assert.NilError(t, err)
+	assert.Equal(t, "Ünïcödé sübjéct for José 🎉", subject)
+
+	headers, _, _ := strings.Cut(content, "\r\n\r\n")
+	for _, c := range headers {
+		assert.Assert(t, c < 0x80, "non-ASCII character in headers: %q", c)
+	}
+}
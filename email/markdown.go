@@ -0,0 +1,258 @@
+package email
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// markdown converts Message.MarkdownBody to HTML using the CommonMark spec.
+//
+// Raw HTML within the Markdown source is omitted from the output, since
+// HtmlBody and HtmlFooter already provide complete control over the HTML part.
+var markdown = goldmark.New()
+
+// RenderMarkdown generates TextBody and HtmlBody from MarkdownBody.
+//
+// Does nothing if MarkdownBody is empty. Otherwise it's an error for TextBody
+// or HtmlBody to be present. On success, MarkdownBody is cleared, so the
+// Message can pass through Validate and NewMessageTemplate like any other.
+//
+// HtmlBody will contain an HTML fragment, not a complete document, so
+// HtmlFooter must not close any elements it doesn't open itself. TextBody will
+// contain a plain text rendering of the same content. Personalization variables
+// anywhere in the Markdown source, including link URLs, will appear in both
+// bodies unchanged. Since variable values aren't URL encoded, a variable within
+// a URL should only take values that are already safe to appear there.
+func (msg *Message) RenderMarkdown() error {
+	if msg.MarkdownBody == "" {
+		return nil
+	} else if msg.TextBody != "" || msg.HtmlBody != "" {
+		const errMsg = "MarkdownBody present, but so is TextBody or HtmlBody"
+		return errors.New(errMsg)
+	}
+
+	protected, vars := protectVars(msg.MarkdownBody)
+	source := []byte(protected)
+	doc := markdown.Parser().Parse(text.NewReader(source))
+	htmlBuf := &bytes.Buffer{}
+
+	if err := markdown.Renderer().Render(htmlBuf, source, doc); err != nil {
+		return fmt.Errorf("failed to render MarkdownBody as HTML: %w", err)
+	}
+	msg.TextBody = renderMarkdownText(doc, source, vars)
+	msg.HtmlBody = vars.Replace(htmlBuf.String())
+	msg.MarkdownBody = ""
+	return nil
+}
+
+// varPlaceholderPrefix begins every placeholder that protectVars substitutes
+// for a personalization variable.
+const varPlaceholderPrefix = "elistmanvar"
+
+// protectVars replaces every personalization variable in the Markdown source
+// with a placeholder, returning the result and a Replacer that restores the
+// original variables.
+//
+// Markdown would otherwise percent-encode the braces of a variable within a
+// link URL, and treat underscores within names like {{first_name}} as
+// emphasis. Each placeholder contains only letters and digits, which Markdown
+// leaves alone. The prefix is extended until it doesn't appear in the source,
+// so no placeholder can collide with the original text.
+func protectVars(source string) (string, *strings.Replacer) {
+	prefix := varPlaceholderPrefix
+	for strings.Contains(source, prefix) {
+		prefix += "x"
+	}
+
+	var protect, restore []string
+	seen := map[string]bool{}
+
+	for _, name := range templateVars(source) {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		variable := string(varStart) + name + string(varEnd)
+		placeholder := fmt.Sprintf("%s%dz", prefix, len(seen))
+		protect = append(protect, variable, placeholder)
+		restore = append(restore, placeholder, variable)
+	}
+	return strings.NewReplacer(protect...).Replace(source),
+		strings.NewReplacer(restore...)
+}
+
+// renderMarkdownText renders the parsed Markdown document as plain text,
+// restoring the variables replaced by protectVars.
+//
+// The result preserves the structure of the document using common plain text
+// email conventions, e.g. "*strong*", "_emphasis_", "link text (URL)", and
+// "> " for block quotes.
+func renderMarkdownText(
+	doc ast.Node, source []byte, vars *strings.Replacer,
+) string {
+	r := &textRenderer{source: source, vars: vars}
+	return vars.Replace(r.block(doc)) + "\n"
+}
+
+type textRenderer struct {
+	source []byte
+	vars   *strings.Replacer
+}
+
+func (r *textRenderer) block(n ast.Node) string {
+	switch node := n.(type) {
+	case *ast.Paragraph, *ast.TextBlock:
+		return r.inline(n)
+	case *ast.Heading:
+		return r.heading(node)
+	case *ast.ThematicBreak:
+		return "---"
+	case *ast.CodeBlock, *ast.FencedCodeBlock:
+		return indentLines(strings.TrimSuffix(r.lines(n), "\n"), "    ")
+	case *ast.Blockquote:
+		return quoteLines(r.blocks(n, "\n\n"))
+	case *ast.List:
+		return r.list(node)
+	case *ast.HTMLBlock:
+		return ""
+	}
+	return r.blocks(n, "\n\n")
+}
+
+func (r *textRenderer) blocks(n ast.Node, separator string) string {
+	results := make([]string, 0, n.ChildCount())
+
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		if result := r.block(c); result != "" {
+			results = append(results, result)
+		}
+	}
+	return strings.Join(results, separator)
+}
+
+func (r *textRenderer) heading(h *ast.Heading) string {
+	// Restore any variables first, so the underline matches the title width.
+	title := r.vars.Replace(r.inline(h))
+	width := 0
+
+	for _, line := range strings.Split(title, "\n") {
+		width = max(width, utf8.RuneCountInString(line))
+	}
+
+	switch h.Level {
+	case 1:
+		return title + "\n" + strings.Repeat("=", width)
+	case 2:
+		return title + "\n" + strings.Repeat("-", width)
+	}
+	return strings.Repeat("#", h.Level) + " " + title
+}
+
+func (r *textRenderer) list(l *ast.List) string {
+	itemSeparator := "\n\n"
+	if l.IsTight {
+		itemSeparator = "\n"
+	}
+	items := make([]string, 0, l.ChildCount())
+
+	for item := l.FirstChild(); item != nil; item = item.NextSibling() {
+		marker := "- "
+		if l.IsOrdered() {
+			marker = fmt.Sprintf("%d. ", l.Start+len(items))
+		}
+		indent := strings.Repeat(" ", len(marker))
+		content := indentLines(r.blocks(item, itemSeparator), indent)
+		items = append(items, marker+strings.TrimPrefix(content, indent))
+	}
+	return strings.Join(items, itemSeparator)
+}
+
+func (r *textRenderer) lines(n ast.Node) string {
+	sb := &strings.Builder{}
+	lines := n.Lines()
+
+	for i := range lines.Len() {
+		segment := lines.At(i)
+		sb.Write(segment.Value(r.source))
+	}
+	return sb.String()
+}
+
+func (r *textRenderer) inline(n ast.Node) string {
+	sb := &strings.Builder{}
+
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		r.writeInline(sb, c)
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+func (r *textRenderer) writeInline(sb *strings.Builder, n ast.Node) {
+	switch node := n.(type) {
+	case *ast.Text:
+		sb.Write(node.Value(r.source))
+		if node.SoftLineBreak() || node.HardLineBreak() {
+			sb.WriteString("\n")
+		}
+	case *ast.String:
+		sb.Write(node.Value)
+	case *ast.Emphasis:
+		marker := "_"
+		if node.Level == 2 {
+			marker = "*"
+		}
+		sb.WriteString(marker + r.inline(node) + marker)
+	case *ast.Link:
+		writeLink(sb, r.inline(node), string(node.Destination))
+	case *ast.Image:
+		// A "cid:" URL refers to an inline part, which is meaningless to a
+		// plain text reader.
+		if dest := string(node.Destination); strings.HasPrefix(dest, "cid:") {
+			sb.WriteString(r.inline(node))
+		} else {
+			writeLink(sb, r.inline(node), dest)
+		}
+	case *ast.AutoLink:
+		sb.Write(node.URL(r.source))
+	case *ast.RawHTML:
+	default:
+		for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+			r.writeInline(sb, c)
+		}
+	}
+}
+
+func writeLink(sb *strings.Builder, label, dest string) {
+	if label == "" || label == dest {
+		sb.WriteString(dest)
+	} else {
+		sb.WriteString(label + " (" + dest + ")")
+	}
+}
+
+func indentLines(s, indent string) string {
+	lines := strings.Split(s, "\n")
+
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = indent + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func quoteLines(s string) string {
+	lines := strings.Split(s, "\n")
+
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix("> "+line, " ")
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,163 @@
+//go:build small_tests || all_tests
+
+package email
+
+import (
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	t.Run("DoesNothingIfMarkdownBodyEmpty", func(t *testing.T) {
+		msg := *testMessage
+
+		assert.NilError(t, msg.RenderMarkdown())
+
+		assert.Equal(t, testMessage.TextBody, msg.TextBody)
+		assert.Equal(t, testMessage.HtmlBody, msg.HtmlBody)
+	})
+
+	t.Run("GeneratesTextAndHtmlBodies", func(t *testing.T) {
+		msg := &Message{
+			MarkdownBody: "# Hello, {{FirstName}}!\n\n" +
+				"This is **important**. See [the site](https://foo.com).\n",
+		}
+
+		assert.NilError(t, msg.RenderMarkdown())
+
+		const expectedText = "Hello, {{FirstName}}!\n" +
+			"=====================\n\n" +
+			"This is *important*. See the site (https://foo.com).\n"
+		const expectedHtml = "<h1>Hello, {{FirstName}}!</h1>\n" +
+			"<p>This is <strong>important</strong>. " +
+			"See <a href=\"https://foo.com\">the site</a>.</p>\n"
+		assert.Equal(t, expectedText, msg.TextBody)
+		assert.Equal(t, expectedHtml, msg.HtmlBody)
+		assert.Equal(t, "", msg.MarkdownBody)
+	})
+
+	t.Run("PreservesVariablesInLinkUrls", func(t *testing.T) {
+		msg := &Message{
+			MarkdownBody: "[Your profile](https://foo.com/p?u={{Email}})\n",
+		}
+
+		assert.NilError(t, msg.RenderMarkdown())
+
+		const expectedText = "Your profile (https://foo.com/p?u={{Email}})\n"
+		const expectedHtml = "<p><a href=\"https://foo.com/p?u={{Email}}\">" +
+			"Your profile</a></p>\n"
+		assert.Equal(t, expectedText, msg.TextBody)
+		assert.Equal(t, expectedHtml, msg.HtmlBody)
+	})
+
+	t.Run("PreservesVariablesContainingUnderscores", func(t *testing.T) {
+		msg := &Message{
+			MarkdownBody: "Hi {{first_name}} {{last_name}} of {{home_city}}, " +
+				"{{first_name}}!\n",
+		}
+
+		assert.NilError(t, msg.RenderMarkdown())
+
+		const expected = "Hi {{first_name}} {{last_name}} of {{home_city}}, " +
+			"{{first_name}}!"
+		assert.Equal(t, expected+"\n", msg.TextBody)
+		assert.Equal(t, "<p>"+expected+"</p>\n", msg.HtmlBody)
+	})
+
+	t.Run("PlaceholdersDoNotCollideWithSource", func(t *testing.T) {
+		msg := &Message{
+			MarkdownBody: "elistmanvar1z elistmanvarx1z {{FirstName}}\n",
+		}
+
+		assert.NilError(t, msg.RenderMarkdown())
+
+		const expected = "elistmanvar1z elistmanvarx1z {{FirstName}}"
+		assert.Equal(t, expected+"\n", msg.TextBody)
+		assert.Equal(t, "<p>"+expected+"</p>\n", msg.HtmlBody)
+	})
+
+	t.Run("FailsIfTextBodyOrHtmlBodyPresent", func(t *testing.T) {
+		msg := *testMessage
+		msg.MarkdownBody = "Hello, World!"
+
+		err := msg.RenderMarkdown()
+
+		const expected = "MarkdownBody present, but so is TextBody or HtmlBody"
+		assert.Error(t, err, expected)
+	})
+
+	t.Run("OmitsRawHtml", func(t *testing.T) {
+		msg := &Message{MarkdownBody: "<div>raw</div>\n\nHello, <b>World</b>!"}
+
+		assert.NilError(t, msg.RenderMarkdown())
+
+		assert.Equal(t, "Hello, World!\n", msg.TextBody)
+		assert.Assert(t, !strings.Contains(msg.HtmlBody, "<div>"))
+		assert.Assert(t, !strings.Contains(msg.HtmlBody, "<b>"))
+	})
+}
+
+func TestRenderMarkdownText(t *testing.T) {
+	render := func(t *testing.T, source string) string {
+		t.Helper()
+
+		msg := &Message{MarkdownBody: source}
+		assert.NilError(t, msg.RenderMarkdown())
+		return msg.TextBody
+	}
+
+	t.Run("RendersHeadings", func(t *testing.T) {
+		text := render(t, "# Título\n\n## Second\n\n### Third")
+
+		expected := "Título\n======\n\nSecond\n------\n\n### Third\n"
+		assert.Equal(t, expected, text)
+	})
+
+	t.Run("RendersInlineFormatting", func(t *testing.T) {
+		text := render(
+			t,
+			"_a_ **b** `c` <https://foo.com> [https://bar.com](https://bar.com)",
+		)
+
+		assert.Equal(t, "_a_ *b* c https://foo.com https://bar.com\n", text)
+	})
+
+	t.Run("RendersLineBreaks", func(t *testing.T) {
+		text := render(t, "soft\nbreak and hard  \nbreak")
+
+		assert.Equal(t, "soft\nbreak and hard\nbreak\n", text)
+	})
+
+	t.Run("RendersImages", func(t *testing.T) {
+		text := render(
+			t, "![Logo](cid:logo) ![Photo](https://foo.com/photo.jpg)",
+		)
+
+		assert.Equal(t, "Logo Photo (https://foo.com/photo.jpg)\n", text)
+	})
+
+	t.Run("RendersLists", func(t *testing.T) {
+		text := render(
+			t,
+			"- one\n- two\n  - nested\n\n"+
+				"3. three\n\n4. four\n   continued",
+		)
+
+		expected := "- one\n- two\n  - nested\n\n" +
+			"3. three\n\n4. four\n   continued\n"
+		assert.Equal(t, expected, text)
+	})
+
+	t.Run("RendersBlockquotesCodeBlocksAndBreaks", func(t *testing.T) {
+		text := render(
+			t, "> quoted\n>\n> more\n\n```\ncode\n  indented\n```\n\n---",
+		)
+
+		expected := "> quoted\n>\n> more\n\n" +
+			"    code\n      indented\n\n" +
+			"---\n"
+		assert.Equal(t, expected, text)
+	})
+}
@@ -2,29 +2,64 @@ package email
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"mime"
 	"mime/multipart"
 	"mime/quotedprintable"
 	"net/mail"
 	"net/textproto"
+	"slices"
 	"strings"
 )
 
+// Message contains the content of a message to send to subscribers.
+//
+// Subject, TextBody, TextFooter, HtmlBody, and HtmlFooter may contain
+// personalization variables of the form "{{Name}}". VarUnsubscribeUrl,
+// VarTopicsUrl, VarEmail, and VarFirstName are always available. Any other
+// variable must appear in Defaults, and takes its value from
+// Recipient.Attributes.
+//
+// Defaults maps variable names to the values used when a Recipient has no value
+// for that variable. It may also contain a default for VarFirstName.
+//
+// MarkdownBody, if present, replaces TextBody and HtmlBody. NewMessageFromJson
+// renders it via RenderMarkdown before validating the Message.
+//
+// Attachments appear as separate files in the recipient's email client. Inline
+// parts, such as images, are referenced from HtmlBody via "cid:" URLs. See
+// Attachment.
 type Message struct {
-	From       string
-	Subject    string
-	TextBody   string
-	TextFooter string
-	HtmlBody   string
-	HtmlFooter string
+	From         string
+	Subject      string
+	TextBody     string
+	TextFooter   string
+	HtmlBody     string
+	HtmlFooter   string
+	MarkdownBody string            `json:",omitempty"`
+	Defaults     map[string]string `json:",omitempty"`
+	Attachments  []Attachment      `json:",omitempty"`
+	Inline       []Attachment      `json:",omitempty"`
 }
 
 func NewMessageFromJson(
 	r io.Reader, validators ...MessageValidatorFunc,
+) (msg *Message, err error) {
+	return NewMessageFromJsonAndMarkdown(r, "", validators...)
+}
+
+// NewMessageFromJsonAndMarkdown parses a Message from JSON, replacing its
+// MarkdownBody with markdown if not empty.
+//
+// It's an error for both markdown and the JSON MarkdownBody to be present.
+func NewMessageFromJsonAndMarkdown(
+	r io.Reader, markdown string, validators ...MessageValidatorFunc,
 ) (msg *Message, err error) {
 	var msgJson []byte
 	newMsg := &Message{}
@@ -32,6 +67,16 @@ func NewMessageFromJson(
 		err = fmt.Errorf("failed to read JSON from input: %w", err)
 	} else if err = json.Unmarshal(msgJson, newMsg); err != nil {
 		err = fmt.Errorf("failed to parse message input from JSON: %w", err)
+	} else if markdown != "" && newMsg.MarkdownBody != "" {
+		err = errors.New("message input already contains MarkdownBody")
+	} else if markdown != "" {
+		newMsg.MarkdownBody = markdown
+	}
+
+	if err != nil {
+		return
+	} else if err = newMsg.RenderMarkdown(); err != nil {
+		err = fmt.Errorf("message failed validation: %w", err)
 	} else if err = newMsg.Validate(validators...); err == nil {
 		msg = newMsg
 	}
@@ -76,7 +121,9 @@ func (msg *Message) Validate(validators ...MessageValidatorFunc) error {
 	if len(msg.Subject) == 0 {
 		addErr("missing Subject")
 	}
-	if len(msg.TextBody) == 0 {
+	if len(msg.MarkdownBody) != 0 {
+		addErr("MarkdownBody present, but not rendered by RenderMarkdown")
+	} else if len(msg.TextBody) == 0 {
 		addErr("missing TextBody")
 	}
 	if len(msg.TextFooter) == 0 {
@@ -93,6 +140,14 @@ func (msg *Message) Validate(validators ...MessageValidatorFunc) error {
 	} else if len(msg.HtmlFooter) != 0 {
 		addErr("HtmlFooter present, but HtmlBody missing")
 	}
+	errs = append(errs, msg.validateVars()...)
+	errs = append(errs, msg.validateAttachments()...)
+
+	// Only check the size of an otherwise valid message, since generating an
+	// invalid one isn't meaningful.
+	if errors.Join(errs...) == nil {
+		errs = append(errs, msg.validateSize())
+	}
 
 	for _, vf := range validators {
 		errs = append(errs, vf(msg, fromName, fromAddress))
@@ -104,6 +159,42 @@ func (msg *Message) Validate(validators ...MessageValidatorFunc) error {
 	return nil
 }
 
+func (msg *Message) validateVars() (errs []error) {
+	known := make(map[string]bool, len(builtinVars)+len(msg.Defaults))
+	for _, name := range builtinVars {
+		known[name] = true
+	}
+	for name := range msg.Defaults {
+		known[name] = true
+	}
+
+	checkSection := func(section, content string) {
+		reported := map[string]bool{}
+
+		for _, name := range templateVars(content) {
+			if !known[name] && !reported[name] {
+				reported[name] = true
+				const errFmt = "%s contains unknown variable {{%s}}"
+				errs = append(errs, fmt.Errorf(errFmt, section, name))
+			}
+		}
+	}
+
+	checkSection("Subject", msg.Subject)
+	checkSection("TextBody", msg.TextBody)
+	checkSection("TextFooter", msg.TextFooter)
+	checkSection("HtmlBody", msg.HtmlBody)
+	checkSection("HtmlFooter", msg.HtmlFooter)
+
+	for _, name := range slices.Sorted(maps.Keys(msg.Defaults)) {
+		if !isVarName([]byte(name)) {
+			const errFmt = "Defaults contains invalid variable name \"%s\""
+			errs = append(errs, fmt.Errorf(errFmt, name))
+		}
+	}
+	return
+}
+
 // CheckDomain ensures Message.From is from the expected domain.
 func CheckDomain(domain string) MessageValidatorFunc {
 	return func(_ *Message, _, addr string) (err error) {
@@ -117,13 +208,27 @@ func CheckDomain(domain string) MessageValidatorFunc {
 	}
 }
 
+// Hash returns a hex encoded SHA-256 hash of the Message content.
+//
+// ProdAgent uses it to ensure that resuming a send uses the same Message as the
+// original send.
+func (msg *Message) Hash() string {
+	// json.Marshal can't fail for a Message, since it contains only strings,
+	// string maps, and byte slices.
+	msgJson, _ := json.Marshal(msg)
+	sum := sha256.Sum256(msgJson)
+	return hex.EncodeToString(sum[:])
+}
+
 type MessageTemplate struct {
-	from       []byte
-	subject    []byte
-	textBody   []byte
-	textFooter []byte
-	htmlBody   []byte
-	htmlFooter []byte
+	from        []byte
+	subject     *headerTemplate
+	textBody    *template
+	textFooter  *template
+	htmlBody    *template
+	htmlFooter  *template
+	attachments []*attachmentPart
+	inline      []*attachmentPart
 }
 
 func NewMessageTemplateFromJson(
@@ -137,33 +242,20 @@ func NewMessageTemplateFromJson(
 }
 
 func NewMessageTemplate(m *Message) *MessageTemplate {
-	makeHeader := func(name, value string) []byte {
-		b := &bytes.Buffer{}
-		b.WriteString(name)
-		b.WriteString(": ")
-		b.WriteString(value)
-		b.Write(crlf)
-		return b.Bytes()
-	}
-
-	mt := &MessageTemplate{
-		from:       makeHeader("From", m.From),
-		subject:    makeHeader("Subject", m.Subject),
-		textBody:   convertToCrlf(appendNewlineIfNeeded(m.TextBody)),
-		textFooter: convertToCrlf(m.TextFooter),
-		htmlBody:   convertToCrlf(appendNewlineIfNeeded(m.HtmlBody)),
-		htmlFooter: convertToCrlf(m.HtmlFooter),
+	d := m.Defaults
+	textBody := appendNewlineIfNeeded(m.TextBody)
+	htmlBody := appendNewlineIfNeeded(m.HtmlBody)
+
+	return &MessageTemplate{
+		from:        formatAddressHeader("From", m.From),
+		subject:     parseHeaderTemplate("Subject", m.Subject, d),
+		textBody:    parseQuotedPrintableTemplate(textBody, d, nil),
+		textFooter:  parseQuotedPrintableTemplate(m.TextFooter, d, nil),
+		htmlBody:    parseQuotedPrintableTemplate(htmlBody, d, escapeHtml),
+		htmlFooter:  parseQuotedPrintableTemplate(m.HtmlFooter, d, escapeHtml),
+		attachments: newAttachmentParts(m.Attachments, "attachment"),
+		inline:      newAttachmentParts(m.Inline, "inline"),
 	}
-
-	tb := &bytes.Buffer{}
-	hb := &bytes.Buffer{}
-
-	// bytest.Buffer never errors, so neither will the quotedprintable writer.
-	writeQuotedPrintable(tb, mt.textBody)
-	mt.textBody = tb.Bytes()
-	writeQuotedPrintable(hb, mt.htmlBody)
-	mt.htmlBody = hb.Bytes()
-	return mt
 }
 
 var toHeaderPrefix = []byte("To: ")
@@ -190,11 +282,13 @@ func (mt *MessageTemplate) EmitMessage(b io.Writer, r *Recipient) error {
 	w.Write(mt.from)
 	w.Write(toHeaderPrefix)
 	w.WriteLine(r.Email)
-	w.Write(mt.subject)
+	w.Write(mt.subject.fill(r))
 	r.EmitUnsubscribeHeaders(w)
 	w.Write(mimeVersion)
 
-	if len(mt.htmlBody) == 0 {
+	if len(mt.attachments) != 0 || len(mt.inline) != 0 {
+		mt.emitWithAttachments(w, r)
+	} else if mt.htmlBody.isEmpty() {
 		mt.emitTextOnly(w, r)
 	} else {
 		mt.emitMultipart(w, r)
@@ -240,9 +334,11 @@ func (mt *MessageTemplate) emitTextOnly(w *writer, sub *Recipient) {
 	w.Write(contentTypeHeader)
 	w.WriteLine(textContentType)
 	w.Write(contentEncodingQuotedPrintable)
-	w.Write(mt.textBody)
-	err := writeQuotedPrintable(w, sub.FillInUnsubscribeUrl(mt.textFooter))
+	err := mt.textBody.emitQuotedPrintable(w, sub)
 
+	if err == nil {
+		err = mt.textFooter.emitQuotedPrintable(w, sub)
+	}
 	if w.err == nil {
 		w.err = err
 	}
@@ -258,36 +354,44 @@ func (mt *MessageTemplate) emitMultipart(w *writer, sub *Recipient) {
 	w.WriteLine(contentType)
 	w.Write(crlf)
 
+	if err := mt.emitAlternativeParts(mpw, sub); err != nil && w.err == nil {
+		w.err = err
+	}
+}
+
+func (mt *MessageTemplate) emitAlternativeParts(
+	mpw *multipart.Writer, sub *Recipient,
+) error {
 	h := textproto.MIMEHeader{}
 	h.Add("Content-Transfer-Encoding", "quoted-printable")
 
 	tb := mt.textBody
-	tf := sub.FillInUnsubscribeUrl(mt.textFooter)
+	tf := mt.textFooter
 	hb := mt.htmlBody
-	hf := sub.FillInUnsubscribeUrl(mt.htmlFooter)
+	hf := mt.htmlFooter
 
-	if err := emitPart(mpw, h, textContentType, tb, tf); err != nil {
-		w.err = err
-	} else if err = emitPart(mpw, h, htmlContentType, hb, hf); err != nil {
-		w.err = err
-	} else if err = mpw.Close(); err != nil {
-		w.err = err
+	if err := emitPart(mpw, h, textContentType, tb, tf, sub); err != nil {
+		return err
+	} else if err = emitPart(mpw, h, htmlContentType, hb, hf, sub); err != nil {
+		return err
 	}
+	return mpw.Close()
 }
 
 func emitPart(
 	w *multipart.Writer,
 	h textproto.MIMEHeader,
 	contentType string,
-	body, footer []byte,
+	body, footer *template,
+	sub *Recipient,
 ) error {
 	h.Set("Content-Type", contentType)
 	if pw, err := w.CreatePart(h); err != nil {
 		return err
-	} else if _, err = pw.Write(body); err != nil {
+	} else if err = body.emitQuotedPrintable(pw, sub); err != nil {
 		return err
 	} else {
-		return writeQuotedPrintable(pw, footer)
+		return footer.emitQuotedPrintable(pw, sub)
 	}
 }
 
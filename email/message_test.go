@@ -206,33 +206,57 @@ var testMessage *Message = &Message{
 		"</body></html>",
 }
 
-var testTemplate *MessageTemplate = &MessageTemplate{
-	from:    []byte("From: EListMan@foo.com\r\n"),
-	subject: []byte("Subject: This is a test\r\n"),
+func constantTemplate(s string) *template {
+	return &template{text: [][]byte{[]byte(s)}}
+}
 
-	textBody: []byte("This is only a test.\r\n" +
+var testTemplate *MessageTemplate = &MessageTemplate{
+	from: []byte("From: EListMan@foo.com\r\n"),
+	subject: &headerTemplate{
+		name: "Subject",
+		value: &template{
+			text:   [][]byte{[]byte("This is a test")},
+			escape: escapeHeader,
+		},
+		header: []byte("Subject: This is a test\r\n"),
+	},
+
+	textBody: constantTemplate("This is only a test.\r\n" +
 		"\r\n" +
 		"This message body is over 76 characters wide " +
 		"so we can see quoted-printable=\r\n" +
 		" encoding in the MessageTemplate.\r\n"),
-	textFooter: []byte("\r\n" +
-		"Unsubscribe: " + UnsubscribeUrlTemplate + "\r\n" +
-		"This footer is over 76 characters wide, " +
-		"but will be quoted-printable encoded by EmitMessage."),
-
-	htmlBody: []byte("<!DOCTYPE html>\r\n" +
-		"<html><head><title>This is a test</title></head>\r\n" +
-		"<body><p>This is only a test.</p>\r\n" +
-		"\r\n" +
-		"<p>This message body is over 76 characters wide " +
-		"so we can see quoted-printa=\r\n" +
-		"ble encoding in the MessageTemplate.</p>\r\n"),
-	htmlFooter: []byte("\r\n" +
-		"<p><a href=\"" + UnsubscribeUrlTemplate +
-		"\">Unsubscribe</a></p>\r\n" +
-		"<p>This footer is over 76 characters wide, " +
-		"but will be quoted-printable encoded by EmitMessage.</p>\r\n" +
-		"</body></html>"),
+	textFooter: &template{
+		text: [][]byte{
+			[]byte("\r\nUnsubscribe: "),
+			[]byte("\r\n" +
+				"This footer is over 76 characters wide, " +
+				"but will be quoted-printable encoded by EmitMessage."),
+		},
+		vars: []templateVar{{name: VarUnsubscribeUrl}},
+	},
+
+	htmlBody: &template{
+		text: [][]byte{[]byte("<!DOCTYPE html>\r\n" +
+			"<html><head><title>This is a test</title></head>\r\n" +
+			"<body><p>This is only a test.</p>\r\n" +
+			"\r\n" +
+			"<p>This message body is over 76 characters wide " +
+			"so we can see quoted-printa=\r\n" +
+			"ble encoding in the MessageTemplate.</p>\r\n")},
+		escape: escapeHtml,
+	},
+	htmlFooter: &template{
+		text: [][]byte{
+			[]byte("\r\n<p><a href=\""),
+			[]byte("\">Unsubscribe</a></p>\r\n" +
+				"<p>This footer is over 76 characters wide, " +
+				"but will be quoted-printable encoded by EmitMessage.</p>\r\n" +
+				"</body></html>"),
+		},
+		vars:   []templateVar{{name: VarUnsubscribeUrl}},
+		escape: escapeHtml,
+	},
 }
 
 func TestMessageValidate(t *testing.T) {
@@ -309,6 +333,60 @@ func TestMessageValidate(t *testing.T) {
 		assert.Error(t, msg.Validate(), expectedErrMsg)
 	})
 
+	t.Run("SucceedsWithBuiltinAndDefaultVariables", func(t *testing.T) {
+		msg := newTestMessage()
+		msg.Subject = "Hello, {{FirstName}} in {{City}}"
+		msg.TextBody = "This message is for {{Email}}."
+		msg.Defaults = map[string]string{"FirstName": "friend", "City": ""}
+
+		assert.NilError(t, msg.Validate())
+	})
+
+	t.Run("FailsIfVariablesUnknown", func(t *testing.T) {
+		msg := newTestMessage()
+		msg.Subject = "Hello, {{FirstName}} in {{City}}"
+		msg.TextBody = "{{Nickname}}, {{Nickname}}, it's {{Nickname}}"
+		msg.HtmlFooter += "{{Country}}"
+
+		expectedErrMsg := strings.Join(
+			[]string{
+				"message failed validation: " +
+					"Subject contains unknown variable {{City}}",
+				"TextBody contains unknown variable {{Nickname}}",
+				"HtmlFooter contains unknown variable {{Country}}",
+			},
+			"\n",
+		)
+		assert.Error(t, msg.Validate(), expectedErrMsg)
+	})
+
+	t.Run("FailsIfDefaultsContainInvalidNames", func(t *testing.T) {
+		msg := newTestMessage()
+		msg.Defaults = map[string]string{"City": "", "2much": "", "": ""}
+
+		expectedErrMsg := strings.Join(
+			[]string{
+				"message failed validation: " +
+					"Defaults contains invalid variable name \"\"",
+				"Defaults contains invalid variable name \"2much\"",
+			},
+			"\n",
+		)
+		assert.Error(t, msg.Validate(), expectedErrMsg)
+	})
+
+	t.Run("FailsIfMarkdownBodyNotRendered", func(t *testing.T) {
+		msg := newTestMessage()
+		msg.TextBody = ""
+		msg.HtmlBody = ""
+		msg.HtmlFooter = ""
+		msg.MarkdownBody = "Hello, *World*!"
+
+		const expectedErrMsg = "message failed validation: " +
+			"MarkdownBody present, but not rendered by RenderMarkdown"
+		assert.Error(t, msg.Validate(), expectedErrMsg)
+	})
+
 	t.Run("FailsIfMessageValidatorFuncReturnsError", func(t *testing.T) {
 		msg := newTestMessage()
 		msg.From = "Foo Bar <foo@bar.com>"
@@ -345,11 +423,56 @@ func TestCheckDomain(t *testing.T) {
 	})
 }
 
+func TestMessageHash(t *testing.T) {
+	t.Run("IsSameForEqualMessages", func(t *testing.T) {
+		msgCopy := *ExampleMessage
+
+		assert.Equal(t, ExampleMessage.Hash(), msgCopy.Hash())
+		assert.Equal(t, 64, len(msgCopy.Hash()))
+	})
+
+	t.Run("IsUnchangedByEmptyDefaults", func(t *testing.T) {
+		msgCopy := *ExampleMessage
+		msgCopy.Defaults = map[string]string{}
+
+		assert.Equal(t, ExampleMessage.Hash(), msgCopy.Hash())
+	})
+
+	t.Run("DiffersIfContentDiffers", func(t *testing.T) {
+		msgCopy := *ExampleMessage
+		msgCopy.TextBody += " Goodbye, World!"
+
+		assert.Assert(t, ExampleMessage.Hash() != msgCopy.Hash())
+	})
+}
+
 func byteStringsEqual(t *testing.T, expected, actual []byte) {
 	t.Helper()
 	assert.Check(t, is.Equal(string(expected), string(actual)))
 }
 
+func assertTemplatesEqual(t *testing.T, expected, actual *template) {
+	t.Helper()
+
+	assert.Check(t, is.Equal(len(expected.text), len(actual.text)))
+	for i := 0; i < len(expected.text) && i < len(actual.text); i++ {
+		byteStringsEqual(t, expected.text[i], actual.text[i])
+	}
+	expectedVars := fmt.Sprintf("%v", expected.vars)
+	assert.Check(t, is.Equal(expectedVars, fmt.Sprintf("%v", actual.vars)))
+	assert.Check(t, is.Equal(expected.escape == nil, actual.escape == nil))
+}
+
+func assertHeaderTemplatesEqual(
+	t *testing.T, expected, actual *headerTemplate,
+) {
+	t.Helper()
+
+	assert.Check(t, is.Equal(expected.name, actual.name))
+	assertTemplatesEqual(t, expected.value, actual.value)
+	byteStringsEqual(t, expected.header, actual.header)
+}
+
 func TestNewMessageTemplate(t *testing.T) {
 	assertMessageTemplatesEqual := func(
 		t *testing.T, expected, actual *MessageTemplate,
@@ -357,11 +480,11 @@ func TestNewMessageTemplate(t *testing.T) {
 		t.Helper()
 
 		byteStringsEqual(t, expected.from, actual.from)
-		byteStringsEqual(t, expected.subject, actual.subject)
-		byteStringsEqual(t, expected.textBody, actual.textBody)
-		byteStringsEqual(t, expected.textFooter, actual.textFooter)
-		byteStringsEqual(t, expected.htmlBody, actual.htmlBody)
-		byteStringsEqual(t, expected.htmlFooter, actual.htmlFooter)
+		assertHeaderTemplatesEqual(t, expected.subject, actual.subject)
+		assertTemplatesEqual(t, expected.textBody, actual.textBody)
+		assertTemplatesEqual(t, expected.textFooter, actual.textFooter)
+		assertTemplatesEqual(t, expected.htmlBody, actual.htmlBody)
+		assertTemplatesEqual(t, expected.htmlFooter, actual.htmlFooter)
 	}
 
 	t.Run("Succeeds", func(t *testing.T) {
@@ -411,7 +534,7 @@ var encodedTextFooter = []byte("\r\n" +
 
 var textOnlyContent = "Content-Type: " + textContentType + "\r\n" +
 	string(contentEncodingQuotedPrintable) +
-	string(testTemplate.textBody) +
+	string(testTemplate.textBody.text[0]) +
 	string(encodedTextFooter)
 
 var decodedTextContent = string(convertToCrlf(testMessage.TextBody)) +
@@ -450,7 +573,7 @@ func TestEmitTextOnly(t *testing.T) {
 var textPart string = "Content-Transfer-Encoding: quoted-printable\r\n" +
 	"Content-Type: " + textContentType + "\r\n" +
 	"\r\n" +
-	string(testTemplate.textBody) +
+	string(testTemplate.textBody.text[0]) +
 	string(encodedTextFooter)
 
 func TestEmitPart(t *testing.T) {
@@ -476,12 +599,13 @@ func TestEmitPart(t *testing.T) {
 
 	contentType := textContentType
 	body := testTemplate.textBody
-	footer := instantiatedTextFooter
+	footer := testTemplate.textFooter
+	r := newTestRecipient()
 
 	t.Run("Succeeds", func(t *testing.T) {
 		sb, h, mpw := setup()
 
-		err := emitPart(mpw, h, contentType, body, footer)
+		err := emitPart(mpw, h, contentType, body, footer, r)
 
 		assert.NilError(t, err)
 		boundaryMarker := "--" + mpw.Boundary() + "\r\n"
@@ -497,7 +621,7 @@ func TestEmitPart(t *testing.T) {
 		ew, h, mpw := setupErrWriter("CreatePart error")
 		ew.ErrorOn = "--" + mpw.Boundary()
 
-		err := emitPart(mpw, h, contentType, body, footer)
+		err := emitPart(mpw, h, contentType, body, footer, r)
 
 		assert.Error(t, err, "CreatePart error")
 	})
@@ -506,7 +630,7 @@ func TestEmitPart(t *testing.T) {
 		ew, h, mpw := setupErrWriter("Write error")
 		ew.ErrorOn = "This is only a test." // appears in body
 
-		err := emitPart(mpw, h, contentType, body, footer)
+		err := emitPart(mpw, h, contentType, body, footer, r)
 
 		assert.Error(t, err, "Write error")
 	})
@@ -515,7 +639,7 @@ func TestEmitPart(t *testing.T) {
 		ew, h, mpw := setupErrWriter("writeQuotedPrintable error")
 		ew.ErrorOn = "Unsubscribe: " // appears in footer
 
-		err := emitPart(mpw, h, contentType, body, footer)
+		err := emitPart(mpw, h, contentType, body, footer, r)
 
 		assert.Error(t, err, "writeQuotedPrintable error")
 	})
@@ -541,7 +665,7 @@ var encodedHtmlFooter = []byte("\r\n" +
 var htmlPart = "Content-Transfer-Encoding: quoted-printable\r\n" +
 	"Content-Type: " + htmlContentType + "\r\n" +
 	"\r\n" +
-	string(testTemplate.htmlBody) +
+	string(testTemplate.htmlBody.text[0]) +
 	string(encodedHtmlFooter)
 
 func multipartContent(boundary string) string {
@@ -661,7 +785,7 @@ func TestGenerateMessage(t *testing.T) {
 
 	t.Run("GeneratesPlaintextMessage", func(t *testing.T) {
 		textTemplate := *testTemplate
-		textTemplate.htmlBody = []byte{}
+		textTemplate.htmlBody = constantTemplate("")
 
 		content := string(textTemplate.GenerateMessage(r))
 
@@ -728,6 +852,62 @@ func TestNewMessageFromJson(t *testing.T) {
 	})
 }
 
+func TestNewMessageFromJsonAndMarkdown(t *testing.T) {
+	const markdownJson = `{
+		"From": "Foo Bar <foobar@example.com>",
+		"Subject": "Test object",
+		"TextFooter": "Unsubscribe: {{UnsubscribeUrl}}",
+		"HtmlFooter": "<a href='{{UnsubscribeUrl}}'>Unsubscribe</a>"
+	}`
+
+	t.Run("RendersMarkdownArgument", func(t *testing.T) {
+		buf := bytes.NewBuffer([]byte(markdownJson))
+
+		msg, err := NewMessageFromJsonAndMarkdown(buf, "Hello, *World*!")
+
+		assert.NilError(t, err)
+		assert.Equal(t, "Hello, _World_!\n", msg.TextBody)
+		assert.Equal(t, "<p>Hello, <em>World</em>!</p>\n", msg.HtmlBody)
+		assert.Equal(t, "", msg.MarkdownBody)
+	})
+
+	t.Run("RendersMarkdownBodyFromJson", func(t *testing.T) {
+		msgJson := strings.Replace(
+			markdownJson, "{", `{"MarkdownBody": "Hello, *World*!",`, 1,
+		)
+
+		msg, err := NewMessageFromJson(strings.NewReader(msgJson))
+
+		assert.NilError(t, err)
+		assert.Equal(t, "Hello, _World_!\n", msg.TextBody)
+		assert.Equal(t, "<p>Hello, <em>World</em>!</p>\n", msg.HtmlBody)
+	})
+
+	t.Run("ErrorsIfMarkdownBodyAlsoInJson", func(t *testing.T) {
+		msgJson := strings.Replace(
+			markdownJson, "{", `{"MarkdownBody": "Hello, *World*!",`, 1,
+		)
+
+		msg, err := NewMessageFromJsonAndMarkdown(
+			strings.NewReader(msgJson), "Hello, *World*!",
+		)
+
+		assert.Assert(t, is.Nil(msg))
+		assert.Error(t, err, "message input already contains MarkdownBody")
+	})
+
+	t.Run("ErrorsIfTextBodyAlsoInJson", func(t *testing.T) {
+		buf := bytes.NewBuffer([]byte(ExampleMessageJson))
+
+		msg, err := NewMessageFromJsonAndMarkdown(buf, "Hello, *World*!")
+
+		assert.Assert(t, is.Nil(msg))
+		const expectedMsg = "message failed validation: " +
+			"MarkdownBody present, but so is TextBody or HtmlBody"
+		assert.Error(t, err, expectedMsg)
+	})
+}
+
 func TestMustParseMessageFromJson(t *testing.T) {
 	t.Run("Succeeds", func(t *testing.T) {
 		buf := bytes.NewBuffer([]byte(ExampleMessageJson))
@@ -1,7 +1,6 @@
 package email
 
 import (
-	"bytes"
 	"io"
 	"net/url"
 	"strings"
@@ -10,38 +9,60 @@ import (
 	"github.com/mbland/elistman/ops"
 )
 
-const UnsubscribeUrlTemplate = "{{UnsubscribeUrl}}"
-
-var unsubscribeUrlTemplate = []byte(UnsubscribeUrlTemplate)
-
+// Recipient contains the information needed to generate a message for one
+// subscriber.
+//
+// FirstName and Attributes provide the values for personalization variables.
+// See VarFirstName and Message.Defaults.
+//
+// List is the name of the list to which the Recipient subscribes, or empty for
+// the default list. The unsubscribe URLs and headers identify this list.
 type Recipient struct {
 	Email        string
 	Uid          uuid.UUID
+	List         string
+	FirstName    string
+	Attributes   map[string]string
 	unsubFormUrl []byte
 	unsubApiUrl  []byte
 	unsubHeader  []byte
+	topicsUrl    []byte
 }
 
+// SetUnsubscribeInfo generates the unsubscribe URLs and headers for the
+// Recipient, as well as the value of VarTopicsUrl.
 func (sub *Recipient) SetUnsubscribeInfo(email, formUrl, apiBaseUrl string) {
-	sub.unsubFormUrl = unsubscribeFormUrl(formUrl, sub.Email, sub.Uid)
-	sub.unsubApiUrl = []byte(ops.UnsubscribeUrl(apiBaseUrl, sub.Email, sub.Uid))
+	sub.unsubFormUrl = unsubscribeFormUrl(formUrl, sub.List, sub.Email, sub.Uid)
+	sub.unsubApiUrl = []byte(
+		ops.UnsubscribeUrl(apiBaseUrl, sub.List, sub.Email, sub.Uid),
+	)
 
 	sb := &strings.Builder{}
 	sb.WriteString("List-Unsubscribe: <")
-	sb.WriteString(ops.UnsubscribeMailto(email, sub.Email, sub.Uid))
+	sb.WriteString(ops.UnsubscribeMailto(email, sub.List, sub.Email, sub.Uid))
 	sb.WriteString(">, <")
 	sb.Write(sub.unsubApiUrl)
 	sb.WriteString(">\r\n")
 	sub.unsubHeader = []byte(sb.String())
+	sub.topicsUrl = []byte(
+		ops.TopicsUrl(apiBaseUrl, sub.List, sub.Email, sub.Uid),
+	)
 }
 
-func unsubscribeFormUrl(baseFormUrl, email string, uid uuid.UUID) []byte {
+func unsubscribeFormUrl(
+	baseFormUrl, list, email string, uid uuid.UUID,
+) []byte {
 	sb := &strings.Builder{}
 	sb.WriteString(baseFormUrl)
 	sb.WriteString("?email=")
 	sb.WriteString(url.QueryEscape(email))
 	sb.WriteString("&uid=")
 	sb.WriteString(uid.String())
+
+	if list != "" {
+		sb.WriteString("&list=")
+		sb.WriteString(list)
+	}
 	return []byte(sb.String())
 }
 
@@ -61,6 +82,14 @@ func (sub *Recipient) EmitUnsubscribeHeaders(w io.Writer) (err error) {
 	return
 }
 
-func (sub *Recipient) FillInUnsubscribeUrl(msg []byte) []byte {
-	return bytes.Replace(msg, unsubscribeUrlTemplate, sub.unsubFormUrl, 1)
+// value returns the Recipient's value for a personalization variable other
+// than VarUnsubscribeUrl or VarTopicsUrl, or the empty string if it has none.
+func (sub *Recipient) value(name string) string {
+	switch name {
+	case VarEmail:
+		return sub.Email
+	case VarFirstName:
+		return sub.FirstName
+	}
+	return sub.Attributes[name]
 }
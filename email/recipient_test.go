@@ -47,16 +47,44 @@ func TestSubscriber(t *testing.T) {
 		assert.Equal(t, unsubApiUrl, string(sub.unsubApiUrl))
 		assert.Equal(t, unsubFormUrl, string(sub.unsubFormUrl))
 		assert.Equal(t, header, string(sub.unsubHeader))
+		topicsUrl := testApiBaseUrl + ops.ApiPrefixTopics +
+			url.PathEscape(sub.Email) + "/" + testUid
+		assert.Equal(t, topicsUrl, string(sub.topicsUrl))
 	})
 
-	t.Run("FillInUnsubscribeUrlReplacesTemplate", func(t *testing.T) {
-		sub := setup()
-		orig := "Unsubscribe at " + UnsubscribeUrlTemplate + " at any time"
+	t.Run("SetUnsubscribeInfoIncludesList", func(t *testing.T) {
+		sub := &Recipient{
+			Email: "subscriber@foo.com",
+			Uid:   uuid.MustParse(testUid),
+			List:  "news",
+		}
+
+		sub.SetUnsubscribeInfo(testUnsubEmail, testUnsubUrl, testApiBaseUrl)
 
-		result := sub.FillInUnsubscribeUrl([]byte(orig))
+		unsubApiUrl := testApiBaseUrl + ops.ApiPrefixUnsubscribe + "news/" +
+			url.PathEscape(sub.Email) + "/" + testUid
+		assert.Equal(t, unsubApiUrl, string(sub.unsubApiUrl))
+		unsubFormUrl := testUnsubUrl + "?email=" + url.QueryEscape(sub.Email) +
+			"&uid=" + testUid + "&list=news"
+		assert.Equal(t, unsubFormUrl, string(sub.unsubFormUrl))
+		const mailtoSubjectEnd = testUid + "%20news>"
+		assert.Assert(t, strings.Contains(
+			string(sub.unsubHeader), mailtoSubjectEnd,
+		))
+		topicsUrl := testApiBaseUrl + ops.ApiPrefixTopics + "news/" +
+			url.PathEscape(sub.Email) + "/" + testUid
+		assert.Equal(t, topicsUrl, string(sub.topicsUrl))
+	})
+
+	t.Run("ValueReturnsPersonalizationValues", func(t *testing.T) {
+		sub := setup()
+		sub.FirstName = "Sub"
+		sub.Attributes = map[string]string{"City": "Chicago"}
 
-		expected := "Unsubscribe at " + string(sub.unsubFormUrl) + " at any time"
-		assert.Equal(t, expected, string(result))
+		assert.Equal(t, sub.Email, sub.value(VarEmail))
+		assert.Equal(t, "Sub", sub.value(VarFirstName))
+		assert.Equal(t, "Chicago", sub.value("City"))
+		assert.Equal(t, "", sub.value("Country"))
 	})
 
 	t.Run("EmitUnsubscribeHeaders", func(t *testing.T) {
@@ -0,0 +1,305 @@
+package email
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"sync"
+	"time"
+
+	"github.com/mbland/elistman/ops"
+)
+
+// SmtpMailer sends messages via an SMTP server instead of SES.
+//
+// It keeps its connection to the server open between calls to Send, so a bulk
+// send doesn't need to reconnect and authenticate for every recipient. If the
+// connection has been idle for at least IdleCheck, Send first checks it with a
+// NOOP command. If the server closed the connection between sends, the next
+// Send reconnects. Close ends the connection.
+//
+// SmtpMailer requires the server to support STARTTLS, unless AllowPlaintext is
+// true.
+type SmtpMailer struct {
+	// Addr is the "host:port" address of the SMTP server.
+	Addr string
+
+	// Sender is the envelope sender (MAIL FROM) address for every message.
+	Sender string
+
+	// Auth, if not nil, authenticates with the server after STARTTLS.
+	Auth smtp.Auth
+
+	// TlsConfig, if not nil, configures STARTTLS. Otherwise SmtpMailer
+	// verifies the server's certificate against the host from Addr.
+	TlsConfig *tls.Config
+
+	// AllowPlaintext permits sending without STARTTLS if the server doesn't
+	// support it. Only use this with a server on the local host.
+	AllowPlaintext bool
+
+	// LocalName is the host name sent with EHLO. Defaults to "localhost".
+	LocalName string
+
+	// Dial opens the connection to Addr. Defaults to net.Dialer.DialContext.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// IdleCheck is how long the connection may remain idle before Send checks
+	// it with NOOP. Defaults to DefaultSmtpIdleCheck.
+	IdleCheck time.Duration
+
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+
+	mutex    sync.Mutex
+	conn     net.Conn
+	client   *smtp.Client
+	lastUsed time.Time
+}
+
+// DefaultSmtpIdleCheck is the default value of SmtpMailer.IdleCheck.
+//
+// During a bulk send, a NOOP before every message would add a round trip per
+// recipient to a connection that's almost certainly still open.
+const DefaultSmtpIdleCheck = 30 * time.Second
+
+// BulkCapacityAvailable always returns nil.
+//
+// Unlike SES, SMTP servers don't report their sending quotas.
+func (mailer *SmtpMailer) BulkCapacityAvailable(_ context.Context) error {
+	return nil
+}
+
+// Send delivers msg to recipient via the SMTP server.
+//
+// messageId is the server's response to the end of the message data, which
+// usually contains the server's queue ID for the message.
+func (mailer *SmtpMailer) Send(
+	ctx context.Context, recipient string, msg []byte,
+) (messageId string, err error) {
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+
+	if err = ctx.Err(); err != nil {
+		err = fmt.Errorf("send to %s failed: %w", recipient, err)
+	} else if messageId, err = mailer.send(ctx, recipient, msg); err != nil {
+		err = smtpError("send to "+recipient+" failed", err)
+	}
+	return
+}
+
+// Close ends the connection to the SMTP server, if one is open.
+func (mailer *SmtpMailer) Close() (err error) {
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+
+	if mailer.client == nil {
+		return
+	} else if err = mailer.client.Quit(); err != nil {
+		mailer.client.Close()
+	}
+	mailer.client = nil
+	mailer.conn = nil
+	return
+}
+
+func (mailer *SmtpMailer) send(
+	ctx context.Context, recipient string, msg []byte,
+) (messageId string, err error) {
+	var c *smtp.Client
+
+	if c, err = mailer.startMail(ctx); err != nil {
+		return
+	} else if err = c.Rcpt(recipient); err != nil {
+		mailer.reset()
+	} else if messageId, err = sendData(c.Text, msg); err != nil {
+		mailer.reset()
+	} else {
+		mailer.lastUsed = mailer.now()
+	}
+	return
+}
+
+// startMail begins a mail transaction via the MAIL command.
+//
+// If the connection wasn't checked with NOOP before MAIL, and MAIL failed
+// without a reply from the server, the server must have closed the connection.
+// In that case, startMail reconnects and tries MAIL once more.
+func (mailer *SmtpMailer) startMail(
+	ctx context.Context,
+) (c *smtp.Client, err error) {
+	var checked bool
+	var reply *textproto.Error
+
+	if c, checked, err = mailer.connection(ctx); err != nil {
+		return
+	} else if err = c.Mail(mailer.Sender); err == nil {
+		return
+	} else if checked || errors.As(err, &reply) {
+		mailer.reset()
+		return
+	}
+
+	mailer.disconnect()
+	if c, err = mailer.connect(ctx); err != nil {
+		return
+	} else if err = c.Mail(mailer.Sender); err != nil {
+		mailer.reset()
+	}
+	return
+}
+
+// connection returns the open connection to the server, or a new one if the
+// server closed it since the last Send.
+//
+// checked is false only if the connection was reused without a NOOP, because
+// it was used successfully less than IdleCheck ago.
+func (mailer *SmtpMailer) connection(
+	ctx context.Context,
+) (c *smtp.Client, checked bool, err error) {
+	if mailer.client != nil {
+		setDeadline(ctx, mailer.conn)
+
+		if mailer.now().Sub(mailer.lastUsed) < mailer.idleCheck() {
+			return mailer.client, false, nil
+		} else if err = mailer.client.Noop(); err == nil {
+			return mailer.client, true, nil
+		}
+		mailer.disconnect()
+	}
+	c, err = mailer.connect(ctx)
+	return c, true, err
+}
+
+func (mailer *SmtpMailer) idleCheck() time.Duration {
+	if mailer.IdleCheck == 0 {
+		return DefaultSmtpIdleCheck
+	}
+	return mailer.IdleCheck
+}
+
+func (mailer *SmtpMailer) now() time.Time {
+	if mailer.Now == nil {
+		return time.Now()
+	}
+	return mailer.Now()
+}
+
+func (mailer *SmtpMailer) connect(
+	ctx context.Context,
+) (c *smtp.Client, err error) {
+	var host string
+	var conn net.Conn
+	dial := mailer.Dial
+
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	if host, _, err = net.SplitHostPort(mailer.Addr); err != nil {
+		return
+	} else if conn, err = dial(ctx, "tcp", mailer.Addr); err != nil {
+		return
+	}
+	setDeadline(ctx, conn)
+
+	if c, err = smtp.NewClient(conn, host); err != nil {
+		conn.Close()
+		return
+	} else if err = mailer.startSession(c, host); err != nil {
+		c.Close()
+		return nil, err
+	}
+	mailer.conn = conn
+	mailer.client = c
+	return
+}
+
+func (mailer *SmtpMailer) startSession(c *smtp.Client, host string) error {
+	localName := mailer.LocalName
+	if localName == "" {
+		localName = "localhost"
+	}
+	tlsConfig := mailer.TlsConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{ServerName: host}
+	}
+
+	if err := c.Hello(localName); err != nil {
+		return err
+	} else if ok, _ := c.Extension("STARTTLS"); ok {
+		if err = c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	} else if !mailer.AllowPlaintext {
+		return errors.New(mailer.Addr + " doesn't support STARTTLS")
+	}
+
+	if mailer.Auth != nil {
+		return c.Auth(mailer.Auth)
+	}
+	return nil
+}
+
+// reset aborts the current mail transaction so the next Send can reuse the
+// connection. If that fails, it closes the connection instead.
+func (mailer *SmtpMailer) reset() {
+	if mailer.client.Reset() != nil {
+		mailer.disconnect()
+	}
+}
+
+func (mailer *SmtpMailer) disconnect() {
+	mailer.client.Close()
+	mailer.client = nil
+	mailer.conn = nil
+}
+
+func setDeadline(ctx context.Context, conn net.Conn) {
+	// The zero time.Time clears any deadline set by a previous Send.
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+}
+
+// sendData sends the DATA command and the message content.
+//
+// smtp.Client.Data doesn't return the server's final response, which usually
+// contains the message's queue ID, so sendData uses the underlying
+// textproto.Conn directly.
+func sendData(text *textproto.Conn, msg []byte) (response string, err error) {
+	var id uint
+
+	if id, err = text.Cmd("DATA"); err != nil {
+		return
+	}
+	text.StartResponse(id)
+	_, _, err = text.ReadResponse(354)
+	text.EndResponse(id)
+
+	if err != nil {
+		return
+	}
+	w := text.DotWriter()
+
+	if _, err = w.Write(msg); err != nil {
+		return
+	} else if err = w.Close(); err != nil {
+		return
+	}
+	_, response, err = text.ReadResponse(250)
+	return
+}
+
+// smtpError wraps err with ops.ErrExternal unless the server rejected the
+// message permanently, i.e. with a 5xx reply code.
+func smtpError(prefix string, err error) error {
+	var tpErr *textproto.Error
+
+	if errors.As(err, &tpErr) && tpErr.Code >= 500 {
+		return fmt.Errorf("%s: %s", prefix, err)
+	}
+	return fmt.Errorf("%s: %w: %s", prefix, ops.ErrExternal, err)
+}
@@ -0,0 +1,469 @@
+//go:build small_tests || all_tests
+
+package email
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mbland/elistman/ops"
+	tu "github.com/mbland/elistman/testutils"
+	"gotest.tools/assert"
+)
+
+type testSmtpMessage struct {
+	from string
+	to   string
+	data string
+}
+
+// testSmtpServer is an in-process SMTP server stand-in.
+//
+// Its dial method connects an SmtpMailer to a new server session via
+// net.Pipe, so no actual network connections are necessary.
+type testSmtpServer struct {
+	tlsConfig    *tls.Config
+	username     string
+	password     string
+	rejectRcpt   string
+	tempFailRcpt string
+	closeAfter   int
+
+	mutex       sync.Mutex
+	connections int
+	commands    []string
+	messages    []testSmtpMessage
+}
+
+func (s *testSmtpServer) dial(
+	_ context.Context, _, _ string,
+) (net.Conn, error) {
+	client, server := net.Pipe()
+	s.mutex.Lock()
+	s.connections++
+	s.mutex.Unlock()
+
+	go s.serve(server)
+	return client, nil
+}
+
+func (s *testSmtpServer) record(command string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.commands = append(s.commands, command)
+}
+
+func (s *testSmtpServer) addMessage(msg testSmtpMessage) (numMessages int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.messages = append(s.messages, msg)
+	return len(s.messages)
+}
+
+func (s *testSmtpServer) Connections() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.connections
+}
+
+func (s *testSmtpServer) Commands() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string{}, s.commands...)
+}
+
+func (s *testSmtpServer) Messages() []testSmtpMessage {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]testSmtpMessage{}, s.messages...)
+}
+
+func (s *testSmtpServer) serve(conn net.Conn) {
+	// Closing conn instead of text skips sending a TLS close_notify alert
+	// after STARTTLS, which would block until the client read it.
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+
+	tlsActive := false
+	authenticated := false
+	msg := testSmtpMessage{}
+	text.PrintfLine("220 localhost ESMTP test server")
+
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		verb = strings.ToUpper(verb)
+		s.record(verb)
+
+		switch verb {
+		case "EHLO":
+			text.PrintfLine("250-localhost")
+			if s.tlsConfig != nil && !tlsActive {
+				text.PrintfLine("250-STARTTLS")
+			}
+			if s.username != "" {
+				text.PrintfLine("250-AUTH PLAIN")
+			}
+			text.PrintfLine("250 SMTPUTF8")
+		case "STARTTLS":
+			text.PrintfLine("220 2.0.0 Ready to start TLS")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			text = textproto.NewConn(tlsConn)
+			tlsActive = true
+		case "AUTH":
+			creds := "\x00" + s.username + "\x00" + s.password
+			expected := "PLAIN " + base64.StdEncoding.EncodeToString(
+				[]byte(creds),
+			)
+			if authenticated = arg == expected; authenticated {
+				text.PrintfLine("235 2.7.0 Authentication successful")
+			} else {
+				text.PrintfLine("535 5.7.8 Authentication credentials invalid")
+			}
+		case "MAIL":
+			if s.username != "" && !authenticated {
+				text.PrintfLine("530 5.7.0 Authentication required")
+			} else {
+				msg.from = parseSmtpPath(arg)
+				text.PrintfLine("250 2.1.0 Ok")
+			}
+		case "RCPT":
+			switch msg.to = parseSmtpPath(arg); msg.to {
+			case s.rejectRcpt:
+				text.PrintfLine("550 5.1.1 No such user")
+			case s.tempFailRcpt:
+				text.PrintfLine("451 4.3.0 Try again later")
+			default:
+				text.PrintfLine("250 2.1.5 Ok")
+			}
+		case "DATA":
+			text.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			msg.data = string(data)
+			numMessages := s.addMessage(msg)
+			msg = testSmtpMessage{}
+			text.PrintfLine("250 2.0.0 Ok: queued as %d", numMessages)
+
+			if numMessages == s.closeAfter {
+				return
+			}
+		case "RSET":
+			msg = testSmtpMessage{}
+			text.PrintfLine("250 2.0.0 Ok")
+		case "NOOP":
+			text.PrintfLine("250 2.0.0 Ok")
+		case "QUIT":
+			// Wait for the client to close its end of the connection, as
+			// net.Pipe doesn't buffer writes the way a TCP connection would.
+			text.PrintfLine("221 2.0.0 Bye")
+			io.Copy(io.Discard, text.R)
+			return
+		default:
+			text.PrintfLine("502 5.5.2 Command not recognized")
+		}
+	}
+}
+
+func parseSmtpPath(arg string) string {
+	_, path, _ := strings.Cut(arg, "<")
+	path, _, _ = strings.Cut(path, ">")
+	return path
+}
+
+// newTestTlsConfigs generates a self signed certificate for "localhost" and
+// returns TLS configurations for both the server and the client.
+func newTestTlsConfigs(t *testing.T) (server, client *tls.Config) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NilError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(
+		rand.Reader, template, template, &key.PublicKey, key,
+	)
+	assert.NilError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NilError(t, err)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	server = &tls.Config{
+		Certificates: []tls.Certificate{
+			{Certificate: [][]byte{der}, PrivateKey: key},
+		},
+	}
+	client = &tls.Config{RootCAs: roots, ServerName: "localhost"}
+	return
+}
+
+func TestSmtpMailer(t *testing.T) {
+	const sender = "no-reply@mike-bland.com"
+	const recipient = "subscriber@foo.com"
+	const testMsg = "From: " + sender + "\r\n" +
+		"To: " + recipient + "\r\n" +
+		"Subject: Hello\r\n" +
+		"\r\n" +
+		"Hello, World!\r\n" +
+		".leading dot\r\n"
+
+	// textproto.Reader.ReadDotBytes converts CRLF to LF and removes the dot
+	// stuffing added by the client.
+	var receivedMsg = strings.ReplaceAll(testMsg, "\r\n", "\n")
+
+	setup := func(t *testing.T) (*testSmtpServer, *SmtpMailer) {
+		serverTls, clientTls := newTestTlsConfigs(t)
+		server := &testSmtpServer{
+			tlsConfig: serverTls, username: "elistman", password: "secret",
+		}
+		mailer := &SmtpMailer{
+			Addr:      "localhost:587",
+			Sender:    sender,
+			Auth:      smtp.PlainAuth("", "elistman", "secret", "localhost"),
+			TlsConfig: clientTls,
+			Dial:      server.dial,
+		}
+		return server, mailer
+	}
+
+	send := func(mailer *SmtpMailer, recipient string) (string, error) {
+		return mailer.Send(context.Background(), recipient, []byte(testMsg))
+	}
+
+	t.Run("BulkCapacityAlwaysAvailable", func(t *testing.T) {
+		_, mailer := setup(t)
+
+		assert.NilError(t, mailer.BulkCapacityAvailable(context.Background()))
+	})
+
+	t.Run("SendsViaStartTlsWithAuth", func(t *testing.T) {
+		server, mailer := setup(t)
+		defer mailer.Close()
+
+		msgId, err := send(mailer, recipient)
+
+		assert.NilError(t, err)
+		assert.Equal(t, "2.0.0 Ok: queued as 1", msgId)
+		expected := testSmtpMessage{
+			from: sender, to: recipient, data: receivedMsg,
+		}
+		messages := server.Messages()
+		assert.Equal(t, 1, len(messages))
+		assert.Equal(t, expected, messages[0])
+		expectedCmds := []string{
+			"EHLO", "STARTTLS", "EHLO", "AUTH", "MAIL", "RCPT", "DATA",
+		}
+		assert.DeepEqual(t, expectedCmds, server.Commands())
+	})
+
+	t.Run("ReusesConnectionAcrossSends", func(t *testing.T) {
+		server, mailer := setup(t)
+		defer mailer.Close()
+
+		for i := range 3 {
+			msgId, err := send(mailer, fmt.Sprintf("sub-%d@foo.com", i))
+
+			assert.NilError(t, err)
+			assert.Equal(t, fmt.Sprintf("2.0.0 Ok: queued as %d", i+1), msgId)
+		}
+
+		assert.Equal(t, 1, server.Connections())
+		assert.Equal(t, 3, len(server.Messages()))
+		assert.Assert(t, !slices.Contains(server.Commands(), "NOOP"))
+	})
+
+	t.Run("ChecksIdleConnectionWithNoop", func(t *testing.T) {
+		server, mailer := setup(t)
+		now := time.Now()
+		mailer.Now = func() time.Time { return now }
+		defer mailer.Close()
+
+		_, err := send(mailer, recipient)
+		assert.NilError(t, err)
+		now = now.Add(DefaultSmtpIdleCheck)
+		_, err = send(mailer, recipient)
+		assert.NilError(t, err)
+
+		assert.Equal(t, 1, server.Connections())
+		cmds := server.Commands()
+		assert.DeepEqual(t, []string{"NOOP", "MAIL", "RCPT", "DATA"}, cmds[7:])
+	})
+
+	t.Run("ReconnectsIfServerClosedConnection", func(t *testing.T) {
+		server, mailer := setup(t)
+		server.closeAfter = 1
+		defer mailer.Close()
+
+		_, err := send(mailer, recipient)
+		assert.NilError(t, err)
+		_, err = send(mailer, recipient)
+		assert.NilError(t, err)
+
+		assert.Equal(t, 2, server.Connections())
+		assert.Equal(t, 2, len(server.Messages()))
+	})
+
+	t.Run("ReconnectsIfServerClosedIdleConnection", func(t *testing.T) {
+		server, mailer := setup(t)
+		server.closeAfter = 1
+		mailer.IdleCheck = time.Nanosecond
+		defer mailer.Close()
+
+		_, err := send(mailer, recipient)
+		assert.NilError(t, err)
+		_, err = send(mailer, recipient)
+		assert.NilError(t, err)
+
+		assert.Equal(t, 2, server.Connections())
+		assert.Equal(t, 2, len(server.Messages()))
+	})
+
+	t.Run("SendsWithoutStartTlsIfAllowPlaintext", func(t *testing.T) {
+		server, mailer := setup(t)
+		server.tlsConfig = nil
+		server.username = ""
+		mailer.Auth = nil
+		mailer.AllowPlaintext = true
+		defer mailer.Close()
+
+		_, err := send(mailer, recipient)
+
+		assert.NilError(t, err)
+		assert.Equal(t, 1, len(server.Messages()))
+	})
+
+	t.Run("FailsIfStartTlsUnsupported", func(t *testing.T) {
+		server, mailer := setup(t)
+		server.tlsConfig = nil
+
+		_, err := send(mailer, recipient)
+
+		const expected = "send to " + recipient + " failed: external error: " +
+			"localhost:587 doesn't support STARTTLS"
+		assert.Error(t, err, expected)
+		assert.Assert(t, tu.ErrorIs(err, ops.ErrExternal))
+		assert.Equal(t, 0, len(server.Messages()))
+	})
+
+	t.Run("FailsIfAuthFails", func(t *testing.T) {
+		server, mailer := setup(t)
+		server.password = "not the password"
+
+		_, err := send(mailer, recipient)
+
+		assert.ErrorContains(t, err, "535")
+		assert.Assert(t, tu.ErrorIsNot(err, ops.ErrExternal))
+	})
+
+	t.Run("ReusesConnectionAfterRecipientRejected", func(t *testing.T) {
+		server, mailer := setup(t)
+		server.rejectRcpt = recipient
+		defer mailer.Close()
+
+		_, err := send(mailer, recipient)
+
+		const expected = "send to " + recipient + " failed: " +
+			`550 "5.1.1 No such user"`
+		assert.Error(t, err, expected)
+		assert.Assert(t, tu.ErrorIsNot(err, ops.ErrExternal))
+
+		_, err = send(mailer, "another-subscriber@foo.com")
+
+		assert.NilError(t, err)
+		assert.Equal(t, 1, server.Connections())
+		assert.Equal(t, "RSET", server.Commands()[6])
+	})
+
+	t.Run("ReturnsExternalErrorIfRcptFailsTemporarily", func(t *testing.T) {
+		server, mailer := setup(t)
+		server.tempFailRcpt = recipient
+		defer mailer.Close()
+
+		_, err := send(mailer, recipient)
+
+		assert.ErrorContains(t, err, `451 "4.3.0 Try again later"`)
+		assert.Assert(t, tu.ErrorIs(err, ops.ErrExternal))
+	})
+
+	t.Run("FailsIfDialFails", func(t *testing.T) {
+		_, mailer := setup(t)
+		dialErr := errors.New("connection refused")
+		mailer.Dial = func(context.Context, string, string) (net.Conn, error) {
+			return nil, dialErr
+		}
+
+		_, err := send(mailer, recipient)
+
+		const expected = "send to " + recipient + " failed: " +
+			"external error: connection refused"
+		assert.Error(t, err, expected)
+		assert.Assert(t, tu.ErrorIs(err, ops.ErrExternal))
+	})
+
+	t.Run("FailsIfAddrInvalid", func(t *testing.T) {
+		_, mailer := setup(t)
+		mailer.Addr = "localhost"
+
+		_, err := send(mailer, recipient)
+
+		assert.ErrorContains(t, err, "missing port in address")
+	})
+
+	t.Run("FailsIfContextCanceled", func(t *testing.T) {
+		server, mailer := setup(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := mailer.Send(ctx, recipient, []byte(testMsg))
+
+		assert.Assert(t, tu.ErrorIs(err, context.Canceled))
+		assert.Equal(t, 0, server.Connections())
+	})
+
+	t.Run("CloseSendsQuit", func(t *testing.T) {
+		server, mailer := setup(t)
+		_, err := send(mailer, recipient)
+		assert.NilError(t, err)
+
+		assert.NilError(t, mailer.Close())
+
+		cmds := server.Commands()
+		assert.Equal(t, "QUIT", cmds[len(cmds)-1])
+		assert.NilError(t, mailer.Close())
+	})
+}
@@ -0,0 +1,256 @@
+package email
+
+import (
+	"bytes"
+	"html"
+	"io"
+	"slices"
+	"strings"
+)
+
+// Personalization variables available to every Message.
+//
+// A Message may use other variables in the form "{{Name}}" by declaring them in
+// Message.Defaults. These variables take their values from
+// Recipient.Attributes.
+//
+// VarTopicsUrl is the link to the page where a subscriber may change the
+// topics they receive.
+const (
+	VarUnsubscribeUrl = "UnsubscribeUrl"
+	VarTopicsUrl      = "TopicsUrl"
+	VarEmail          = "Email"
+	VarFirstName      = "FirstName"
+)
+
+const UnsubscribeUrlTemplate = "{{" + VarUnsubscribeUrl + "}}"
+
+var builtinVars = []string{
+	VarUnsubscribeUrl, VarTopicsUrl, VarEmail, VarFirstName,
+}
+
+// isUrlVar returns true if name is VarUnsubscribeUrl or VarTopicsUrl, whose
+// values Recipient.SetUnsubscribeInfo generates for every Recipient.
+func isUrlVar(name string) bool {
+	return name == VarUnsubscribeUrl || name == VarTopicsUrl
+}
+
+var varStart = []byte("{{")
+var varEnd = []byte("}}")
+
+// template is a Message section split into literal text and personalization
+// variables.
+//
+// NewMessageTemplate parses each section into a template only once. Emitting a
+// message for each Recipient then only requires filling in the Recipient's
+// value for each variable, if the section contains any.
+type template struct {
+	// text contains the literal text surrounding each variable, so
+	// len(text) == len(vars) + 1.
+	text [][]byte
+	vars []templateVar
+
+	// escape, if not nil, transforms every variable value other than the
+	// unsubscribe and topics URLs before it's emitted.
+	escape func(string) string
+
+	// defaultEncoded, if not nil, is the quoted-printable encoding of the
+	// template filled in with only the defaults. It's emitted for every
+	// Recipient without a value for any of the template's variables.
+	defaultEncoded []byte
+}
+
+type templateVar struct {
+	name     string
+	fallback string
+}
+
+var escapeHeader = strings.NewReplacer("\r", " ", "\n", " ").Replace
+var escapeHtml = html.EscapeString
+
+func parseTemplate(
+	s []byte, defaults map[string]string, escape func(string) string,
+) *template {
+	t := &template{escape: escape}
+
+	for {
+		name, before, after, ok := nextVar(s)
+		if !ok {
+			break
+		}
+		t.text = append(t.text, before)
+		t.vars = append(t.vars, templateVar{name, defaults[name]})
+		s = after
+	}
+	t.text = append(t.text, s)
+	return t
+}
+
+// parseQuotedPrintableTemplate parses a Message body or footer.
+//
+// If the section contains no variables, it's encoded as quoted-printable
+// immediately, since every Recipient will receive identical content. If it
+// contains only variables that may fall back to Message.Defaults, its default
+// content is also encoded immediately, since every Recipient without
+// personalization values will receive it.
+func parseQuotedPrintableTemplate(
+	s string, defaults map[string]string, escape func(string) string,
+) (t *template) {
+	t = parseTemplate(convertToCrlf(s), defaults, escape)
+
+	// bytes.Buffer never errors, so neither will the quotedprintable writer.
+	if t.isConstant() {
+		b := &bytes.Buffer{}
+		writeQuotedPrintable(b, t.text[0])
+		t.text[0] = b.Bytes()
+	} else if !t.hasAddressVars() {
+		b := &bytes.Buffer{}
+		writeQuotedPrintable(b, t.fill(&Recipient{}))
+		t.defaultEncoded = b.Bytes()
+	}
+	return
+}
+
+// nextVar finds the first "{{Name}}" variable in s, returning its name and the
+// text before and after it.
+//
+// Any "{{" sequence not followed by a valid variable name and "}}" is treated
+// as literal text.
+func nextVar(s []byte) (name string, before, after []byte, ok bool) {
+	for offset := 0; ; {
+		i := bytes.Index(s[offset:], varStart)
+		if i == -1 {
+			return
+		}
+		nameStart := offset + i + len(varStart)
+		nameLen := bytes.Index(s[nameStart:], varEnd)
+
+		if nameLen == -1 {
+			return
+		}
+
+		if candidate := s[nameStart : nameStart+nameLen]; isVarName(candidate) {
+			name = string(candidate)
+			before = s[:offset+i]
+			after = s[nameStart+nameLen+len(varEnd):]
+			ok = true
+			return
+		}
+		offset = nameStart
+	}
+}
+
+func isVarName(name []byte) bool {
+	if len(name) == 0 {
+		return false
+	}
+	for i, c := range name {
+		isLetter := ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z')
+		isDigitOrUnderscore := ('0' <= c && c <= '9') || c == '_'
+
+		if !(isLetter || (i != 0 && isDigitOrUnderscore)) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsAttributeName returns true if name may identify one of a
+// Recipient.Attributes, which is any valid variable name other than one of the
+// variables available to every Message.
+func IsAttributeName(name string) bool {
+	return isVarName([]byte(name)) && !slices.Contains(builtinVars, name)
+}
+
+// templateVars returns the names of every variable in s, in order of
+// appearance.
+func templateVars(s string) (names []string) {
+	b := []byte(s)
+
+	for {
+		name, _, after, ok := nextVar(b)
+		if !ok {
+			return
+		}
+		names = append(names, name)
+		b = after
+	}
+}
+
+func (t *template) isConstant() bool {
+	return len(t.vars) == 0
+}
+
+// hasAddressVars returns true if the template contains VarEmail,
+// VarUnsubscribeUrl, or VarTopicsUrl, whose values differ for every Recipient.
+func (t *template) hasAddressVars() bool {
+	for _, v := range t.vars {
+		if v.name == VarEmail || isUrlVar(v.name) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasValues returns true if the Recipient has a value for any of the
+// template's variables.
+func (t *template) hasValues(r *Recipient) bool {
+	for _, v := range t.vars {
+		if isUrlVar(v.name) || r.value(v.name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *template) isEmpty() bool {
+	return t.isConstant() && len(t.text[0]) == 0
+}
+
+func (t *template) fill(r *Recipient) []byte {
+	if t.isConstant() {
+		return t.text[0]
+	}
+
+	b := &bytes.Buffer{}
+	for i, v := range t.vars {
+		b.Write(t.text[i])
+		b.Write(t.value(v, r))
+	}
+	b.Write(t.text[len(t.vars)])
+	return b.Bytes()
+}
+
+func (t *template) value(v templateVar, r *Recipient) []byte {
+	// SetUnsubscribeInfo already generated URLs that are safe to emit as is.
+	if v.name == VarUnsubscribeUrl {
+		return r.unsubFormUrl
+	} else if v.name == VarTopicsUrl {
+		return r.topicsUrl
+	}
+
+	value := r.value(v.name)
+	if value == "" {
+		value = v.fallback
+	}
+	if t.escape != nil {
+		value = t.escape(value)
+	}
+	return []byte(value)
+}
+
+// emitQuotedPrintable writes the template filled in for the Recipient, encoded
+// as quoted-printable.
+//
+// The template must come from parseQuotedPrintableTemplate, which has already
+// encoded it if it's constant, and encoded its default content if possible.
+func (t *template) emitQuotedPrintable(w io.Writer, r *Recipient) error {
+	if t.isConstant() {
+		_, err := w.Write(t.text[0])
+		return err
+	} else if t.defaultEncoded != nil && !t.hasValues(r) {
+		_, err := w.Write(t.defaultEncoded)
+		return err
+	}
+	return writeQuotedPrintable(w, t.fill(r))
+}
@@ -0,0 +1,193 @@
+//go:build small_tests || all_tests
+
+package email
+
+import (
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestTemplateVars(t *testing.T) {
+	t.Run("ReturnsNothingIfNoVariables", func(t *testing.T) {
+		assert.Assert(t, templateVars("Hello, World!") == nil)
+	})
+
+	t.Run("ReturnsVariablesInOrder", func(t *testing.T) {
+		vars := templateVars("{{FirstName}}, {{Email}} and {{FirstName}}")
+
+		assert.DeepEqual(t, []string{"FirstName", "Email", "FirstName"}, vars)
+	})
+
+	t.Run("IgnoresBracesNotSurroundingVariableNames", func(t *testing.T) {
+		s := "{{ FirstName }} {{}} {{1st}} {{{{Email}} {{First-Name}} {{City"
+
+		assert.DeepEqual(t, []string{"Email"}, templateVars(s))
+	})
+}
+
+func TestIsAttributeName(t *testing.T) {
+	assert.Assert(t, IsAttributeName("City"))
+	assert.Assert(t, IsAttributeName("first_name"))
+	assert.Assert(t, !IsAttributeName(""))
+	assert.Assert(t, !IsAttributeName("1st"))
+	assert.Assert(t, !IsAttributeName("First-Name"))
+	assert.Assert(t, !IsAttributeName(VarFirstName))
+	assert.Assert(t, !IsAttributeName(VarEmail))
+	assert.Assert(t, !IsAttributeName(VarUnsubscribeUrl))
+	assert.Assert(t, !IsAttributeName(VarTopicsUrl))
+}
+
+func TestTemplate(t *testing.T) {
+	defaults := map[string]string{"FirstName": "friend", "City": "your city"}
+
+	setup := func(s string, escape func(string) string) *template {
+		return parseTemplate([]byte(s), defaults, escape)
+	}
+
+	newRecipient := func() *Recipient {
+		r := newTestRecipient()
+		r.FirstName = "Sub"
+		r.Attributes = map[string]string{"City": "Chicago"}
+		return r
+	}
+
+	t.Run("ConstantTemplateReturnsOriginalText", func(t *testing.T) {
+		tmpl := setup("Hello, World!", nil)
+
+		assert.Assert(t, tmpl.isConstant())
+		assert.Equal(t, "Hello, World!", string(tmpl.fill(newRecipient())))
+	})
+
+	t.Run("FillsInRecipientValues", func(t *testing.T) {
+		tmpl := setup("Hi {{FirstName}} <{{Email}}> in {{City}}!", nil)
+
+		result := tmpl.fill(newRecipient())
+
+		const expected = "Hi Sub <subscriber@foo.com> in Chicago!"
+		assert.Equal(t, expected, string(result))
+	})
+
+	t.Run("FillsInDefaultsForMissingValues", func(t *testing.T) {
+		tmpl := setup("Hi {{FirstName}} in {{City}}!", nil)
+
+		result := tmpl.fill(newTestRecipient())
+
+		assert.Equal(t, "Hi friend in your city!", string(result))
+	})
+
+	t.Run("FillsInUnsubscribeUrlWithoutEscaping", func(t *testing.T) {
+		tmpl := setup("<a href=\""+UnsubscribeUrlTemplate+"\">", escapeHtml)
+		r := newRecipient()
+
+		result := tmpl.fill(r)
+
+		expected := "<a href=\"" + string(r.unsubFormUrl) + "\">"
+		assert.Equal(t, expected, string(result))
+	})
+
+	t.Run("FillsInTopicsUrlWithoutEscaping", func(t *testing.T) {
+		tmpl := setup("<a href=\"{{TopicsUrl}}\">", escapeHtml)
+		r := newRecipient()
+
+		result := tmpl.fill(r)
+
+		expected := "<a href=\"" + string(r.topicsUrl) + "\">"
+		assert.Equal(t, expected, string(result))
+	})
+
+	t.Run("EscapesHtmlValues", func(t *testing.T) {
+		tmpl := setup("<p>Hi {{FirstName}}!</p>", escapeHtml)
+		r := newRecipient()
+		r.FirstName = "<script>alert('Sub')</script>"
+
+		result := tmpl.fill(r)
+
+		const expected = "<p>Hi &lt;script&gt;alert(&#39;Sub&#39;)" +
+			"&lt;/script&gt;!</p>"
+		assert.Equal(t, expected, string(result))
+	})
+
+	t.Run("RemovesNewlinesFromHeaderValues", func(t *testing.T) {
+		tmpl := setup("Subject: Hi {{FirstName}}\r\n", escapeHeader)
+		r := newRecipient()
+		r.FirstName = "Sub\r\nBcc: evil@foo.com"
+
+		result := tmpl.fill(r)
+
+		const expected = "Subject: Hi Sub  Bcc: evil@foo.com\r\n"
+		assert.Equal(t, expected, string(result))
+	})
+
+	t.Run("EncodesConstantQuotedPrintableTemplateOnce", func(t *testing.T) {
+		body := strings.Repeat("0123456789", 8) + "\n"
+		tmpl := parseQuotedPrintableTemplate(body, defaults, nil)
+		sb := &strings.Builder{}
+
+		err := tmpl.emitQuotedPrintable(sb, newRecipient())
+
+		assert.NilError(t, err)
+		expected := strings.Repeat("0123456789", 7) + "01234=\r\n" +
+			"56789\r\n"
+		assert.Equal(t, expected, string(tmpl.text[0]))
+		assert.Equal(t, expected, sb.String())
+	})
+
+	t.Run("EncodesQuotedPrintableTemplateForEachRecipient", func(t *testing.T) {
+		tmpl := parseQuotedPrintableTemplate("a={{FirstName}}\n", defaults, nil)
+		sb := &strings.Builder{}
+
+		err := tmpl.emitQuotedPrintable(sb, newRecipient())
+
+		assert.NilError(t, err)
+		assert.Equal(t, "a=3DSub\r\n", sb.String())
+	})
+
+	t.Run("EncodesDefaultQuotedPrintableTemplateOnce", func(t *testing.T) {
+		tmpl := parseQuotedPrintableTemplate(
+			"a={{FirstName}} in {{City}}\n", defaults, nil,
+		)
+		sb := &strings.Builder{}
+
+		err := tmpl.emitQuotedPrintable(sb, newTestRecipient())
+
+		assert.NilError(t, err)
+		const expected = "a=3Dfriend in your city\r\n"
+		assert.Equal(t, expected, string(tmpl.defaultEncoded))
+		assert.Equal(t, expected, sb.String())
+	})
+
+	t.Run("DoesNotEncodeDefaultsIfTemplateContainsAddress", func(t *testing.T) {
+		tmpl := parseQuotedPrintableTemplate(
+			"{{FirstName}} <{{Email}}>\n", defaults, nil,
+		)
+
+		assert.Assert(t, tmpl.defaultEncoded == nil)
+	})
+}
+
+func TestGeneratePersonalizedMessage(t *testing.T) {
+	msg := *testMessage
+	msg.Subject = "Hello, {{FirstName}}"
+	msg.TextBody = "This message is for {{Email}} in {{City}}.\n"
+	msg.Defaults = map[string]string{"FirstName": "friend", "City": "town"}
+	mt := NewMessageTemplate(&msg)
+	r := newTestRecipient()
+	r.FirstName = "Sub"
+
+	content := string(mt.GenerateMessage(r))
+
+	assert.Assert(t, strings.Contains(content, "Subject: Hello, Sub\r\n"))
+	assert.Assert(
+		t,
+		strings.Contains(
+			content, "This message is for subscriber@foo.com in town.\r\n",
+		),
+	)
+
+	r.FirstName = ""
+	content = string(mt.GenerateMessage(r))
+
+	assert.Assert(t, strings.Contains(content, "Subject: Hello, friend\r\n"))
+}
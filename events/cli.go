@@ -1,38 +1,244 @@
 package events
 
 import (
+	"time"
+
+	"github.com/mbland/elistman/agent"
+	"github.com/mbland/elistman/db"
 	"github.com/mbland/elistman/email"
 )
 
 type CommandLineEventType string
 
 const (
-	CommandLineSendEvent   = CommandLineEventType("Send")
-	CommandLineImportEvent = CommandLineEventType("Import")
+	CommandLineSendEvent             = CommandLineEventType("Send")
+	CommandLineImportEvent           = CommandLineEventType("Import")
+	CommandLineCampaignsEvent        = CommandLineEventType("Campaigns")
+	CommandLineHistoryEvent          = CommandLineEventType("History")
+	CommandLineExportSubscriberEvent = CommandLineEventType("ExportSubscriber")
+	CommandLineEraseEvent            = CommandLineEventType("Erase")
+	CommandLineRotateUidsEvent       = CommandLineEventType("RotateUids")
+	CommandLineSubscribersEvent      = CommandLineEventType("Subscribers")
+	CommandLineWelcomeEvent          = CommandLineEventType("Welcome")
 )
 
 type CommandLineEvent struct {
-	EListManCommand CommandLineEventType `json:"elistmanCommand"`
-	Send            *SendEvent           `json:"send"`
-	Import          *ImportEvent         `json:"import"`
+	EListManCommand  CommandLineEventType   `json:"elistmanCommand"`
+	Send             *SendEvent             `json:"send"`
+	Import           *ImportEvent           `json:"import"`
+	Campaigns        *CampaignsEvent        `json:"campaigns"`
+	History          *HistoryEvent          `json:"history"`
+	ExportSubscriber *ExportSubscriberEvent `json:"exportSubscriber"`
+	Erase            *EraseEvent            `json:"erase"`
+	RotateUids       *RotateUidsEvent       `json:"rotateUids"`
+	Subscribers      *SubscribersEvent      `json:"subscribers"`
+	Welcome          *WelcomeEvent          `json:"welcome"`
 }
 
+// SendEvent describes a message to send to the list or to specific Addresses.
+//
+// If ResumeCampaignId isn't empty, the message will resume sending to the
+// entire list using the checkpoint for that campaign ID. Addresses must be
+// empty in this case.
+//
+// If SendAt isn't the zero time, the message will be scheduled to send to the
+// entire list at that time instead of being sent immediately. Addresses and
+// ResumeCampaignId must be empty in this case.
+//
+// IdempotencyKey identifies duplicate sends of the same message. Subscribers
+// who've already received a message with the same key won't receive it again.
+// When sending to the entire list, it defaults to a hash of the message if
+// empty, and resending with the same key requires the same TagFilter. When
+// sending to Addresses, receipts are only recorded if it isn't empty.
+//
+// List names the list to send to. If empty, it's the default list.
+//
+// TagFilter is a db.TagFilter expression selecting the subscribers to receive
+// the message. If empty, the message goes to every subscriber. Addresses,
+// ResumeCampaignId, and SendAt must be empty if it isn't.
 type SendEvent struct {
-	Addresses []string
+	Addresses        []string
+	ResumeCampaignId string    `json:",omitempty"`
+	SendAt           time.Time `json:",omitzero"`
+	IdempotencyKey   string    `json:",omitempty"`
+	List             string    `json:",omitempty"`
+	TagFilter        string    `json:",omitempty"`
 	email.Message
 }
 
+// SendResponse describes the result of handling a SendEvent.
+//
+// CampaignId is set when a send to the entire list stopped before reaching
+// every subscriber. Passing it back as SendEvent.ResumeCampaignId will resume
+// the send.
+//
+// NumSkipped is the number of subscribers who didn't receive the message
+// because they'd already received it.
+//
+// ScheduledId is set when the message was scheduled successfully.
 type SendResponse struct {
-	Success bool
-	NumSent int
-	Details string
+	Success     bool
+	NumSent     int
+	NumSkipped  int
+	Details     string
+	CampaignId  string `json:",omitempty"`
+	ScheduledId string `json:",omitempty"`
 }
 
+// ImportEvent contains addresses to import into the List, or into the default
+// list if List is empty.
+//
+// Profiles maps addresses to the agent.Profile to import with them, if any.
 type ImportEvent struct {
+	List      string `json:",omitempty"`
 	Addresses []string
+	Profiles  map[string]*agent.Profile `json:",omitempty"`
 }
 
 type ImportResponse struct {
 	NumImported int
 	Failures    []string
 }
+
+// CampaignsEvent requests either one db.Campaign or all of them.
+//
+// If CampaignId is empty, the response will contain every db.Campaign.
+//
+// List names the list the campaigns belong to. If empty, it's the default list.
+type CampaignsEvent struct {
+	List       string `json:",omitempty"`
+	CampaignId string `json:",omitempty"`
+}
+
+type CampaignsResponse struct {
+	Success   bool
+	Details   string
+	Campaigns []*db.Campaign
+}
+
+// HistoryEvent requests every db.AuditEvent recorded for Email.
+//
+// List names the list the events belong to. If empty, it's the default list.
+type HistoryEvent struct {
+	List  string `json:",omitempty"`
+	Email string
+}
+
+// HistoryResponse contains the db.AuditEvents for a HistoryEvent, oldest first.
+type HistoryResponse struct {
+	Success bool
+	Details string
+	Events  []*db.AuditEvent
+}
+
+// ExportSubscriberEvent requests everything stored about Email from the default
+// list and every named list.
+type ExportSubscriberEvent struct {
+	Email string
+}
+
+// ExportSubscriberResponse contains the agent.SubscriberData for an
+// ExportSubscriberEvent from each list, starting with the default list.
+type ExportSubscriberResponse struct {
+	Success bool
+	Details string
+	Data    []*agent.SubscriberData
+}
+
+// EraseEvent requests the deletion of everything stored about Email from the
+// default list and every named list.
+type EraseEvent struct {
+	Email string
+}
+
+// EraseResponse lists the names of the lists erased, starting with the default
+// list, whose name is empty. If Success is false, Lists contains only the lists
+// erased before the failure.
+type EraseResponse struct {
+	Success bool
+	Details string
+	Lists   []string
+}
+
+// RotateUidsEvent requests new UIDs for up to BatchSize verified subscribers
+// following StartKey, or from the beginning if StartKey is nil.
+//
+// Each subscriber's previous UID remains valid for GracePeriod.
+//
+// List names the list the subscribers belong to. If empty, it's the default
+// list.
+type RotateUidsEvent struct {
+	List        string `json:",omitempty"`
+	GracePeriod time.Duration
+	BatchSize   int
+	StartKey    *db.ScanKey `json:",omitempty"`
+}
+
+// RotateUidsResponse describes the result of handling a RotateUidsEvent.
+//
+// NextKey is set if there are more subscribers left to rotate. Passing it back
+// as RotateUidsEvent.StartKey will rotate the next batch.
+type RotateUidsResponse struct {
+	Success    bool
+	Details    string
+	NumRotated int
+	NextKey    *db.ScanKey `json:",omitempty"`
+}
+
+// SubscribersEvent requests either one db.Subscriber or a batch of them.
+//
+// If Email isn't empty, the response will contain the db.Subscriber for Email,
+// or no subscribers if there isn't one.
+//
+// Otherwise the response will contain up to BatchSize subscribers with Status
+// following StartKey, or from the beginning if StartKey is nil. If Since isn't
+// the zero time, it will only contain subscribers whose status changed at or
+// after Since. If Domain isn't empty, it will only contain subscribers whose
+// addresses belong to Domain.
+//
+// List names the list the subscribers belong to. If empty, it's the default
+// list.
+type SubscribersEvent struct {
+	List      string              `json:",omitempty"`
+	Email     string              `json:",omitempty"`
+	Status    db.SubscriberStatus `json:",omitempty"`
+	Since     time.Time           `json:",omitzero"`
+	Domain    string              `json:",omitempty"`
+	BatchSize int                 `json:",omitempty"`
+	StartKey  *db.ScanKey         `json:",omitempty"`
+}
+
+// SubscribersResponse contains the db.Subscribers for a SubscribersEvent.
+//
+// NextKey is set if there are more subscribers left to list. Passing it back
+// as SubscribersEvent.StartKey will list the next batch. The response may
+// contain fewer than SubscribersEvent.BatchSize subscribers even if NextKey is
+// set, to stay within Lambda's limit on the size of a response.
+type SubscribersResponse struct {
+	Success     bool
+	Details     string
+	Subscribers []*db.Subscriber
+	NextKey     *db.ScanKey `json:",omitempty"`
+}
+
+// WelcomeEvent gets, sets, or deletes the message welcoming new subscribers.
+//
+// If Set is false, the response will contain the current welcome message, or
+// no message if there isn't one. If Set is true, Message replaces the welcome
+// message, or deletes it if nil.
+//
+// List names the list the welcome message belongs to. If empty, it's the
+// default list.
+type WelcomeEvent struct {
+	List    string         `json:",omitempty"`
+	Set     bool           `json:",omitempty"`
+	Message *email.Message `json:",omitempty"`
+}
+
+// WelcomeResponse contains the welcome message for a WelcomeEvent that didn't
+// set it, if there is one.
+type WelcomeResponse struct {
+	Success bool
+	Details string
+	Message *email.Message `json:",omitempty"`
+}
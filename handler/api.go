@@ -7,30 +7,58 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"slices"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/mbland/elistman/agent"
+	"github.com/mbland/elistman/db"
 	"github.com/mbland/elistman/ops"
 )
 
 type RedirectMap map[ops.OperationResult]string
 
+// apiHandler performs operations requested via the API Gateway.
+//
+// If ConfirmLinks is true, a GET request for a verify or unsubscribe link
+// returns a confirmation page instead of performing the operation. The page
+// contains a button that sends a POST request to the same link, which then
+// performs the operation. This prevents link scanners that prefetch URLs from
+// verifying or unsubscribing subscribers.
+//
+// If VerifyTokenKeys isn't empty, verify links must contain an ops.VerifyToken
+// signed by one of the keys. A request with an expired token redirects to the
+// ops.VerifyLinkExpired page without performing the operation.
+//
+// Topics is the allow-list of topics that Subscribe and Topics requests may
+// contain. A request for any other topic fails, and a Topics request fails if
+// Topics is empty.
+//
+// SiteTitle, Agent, Redirects, and Topics serve the default list. Lists
+// contains the same for every named list (see Handler.AddList).
 type apiHandler struct {
 	SiteTitle        string
 	Agent            agent.SubscriptionAgent
 	Redirects        RedirectMap
+	Topics           []string
+	Lists            map[string]*apiList
+	ConfirmLinks     bool
+	VerifyTokenKeys  ops.VerifyTokenKeys
 	responseTemplate *template.Template
 	log              *log.Logger
 }
 
 func newApiHandler(
-	emailDomain string,
+	siteUrl string,
 	siteTitle string,
 	agent agent.SubscriptionAgent,
 	paths RedirectPaths,
+	topics []string,
 	responseTemplate string,
+	confirmLinks bool,
+	verifyTokenKeys ops.VerifyTokenKeys,
 	logger *log.Logger,
 ) (handler *apiHandler, err error) {
 	var resTmpl *template.Template
@@ -38,26 +66,70 @@ func newApiHandler(
 		return
 	}
 
-	fullUrl := func(path string) string {
-		return "https://" + emailDomain + "/" + path
-	}
-
 	return &apiHandler{
 		siteTitle,
 		agent,
-		RedirectMap{
-			ops.Invalid:           fullUrl(paths.Invalid),
-			ops.AlreadySubscribed: fullUrl(paths.AlreadySubscribed),
-			ops.VerifyLinkSent:    fullUrl(paths.VerifyLinkSent),
-			ops.Subscribed:        fullUrl(paths.Subscribed),
-			ops.NotSubscribed:     fullUrl(paths.NotSubscribed),
-			ops.Unsubscribed:      fullUrl(paths.Unsubscribed),
-		},
+		newRedirectMap(siteUrl, paths),
+		topics,
+		map[string]*apiList{},
+		confirmLinks,
+		verifyTokenKeys,
 		resTmpl,
 		logger,
 	}, nil
 }
 
+// newRedirectMap converts each of paths into a URL under siteUrl, which
+// contains the scheme and host of the site, e.g. "https://mike-bland.com".
+func newRedirectMap(siteUrl string, paths RedirectPaths) RedirectMap {
+	fullUrl := func(path string) string {
+		return siteUrl + "/" + path
+	}
+
+	return RedirectMap{
+		ops.Invalid:           fullUrl(paths.Invalid),
+		ops.AlreadySubscribed: fullUrl(paths.AlreadySubscribed),
+		ops.VerifyLinkSent:    fullUrl(paths.VerifyLinkSent),
+		ops.Subscribed:        fullUrl(paths.Subscribed),
+		ops.NotSubscribed:     fullUrl(paths.NotSubscribed),
+		ops.Unsubscribed:      fullUrl(paths.Unsubscribed),
+		ops.VerifyLinkExpired: fullUrl(paths.VerifyLinkExpired),
+		ops.TopicsUpdated:     fullUrl(paths.TopicsUpdated),
+	}
+}
+
+// apiList contains the apiHandler settings specific to a single list.
+type apiList struct {
+	SiteTitle string
+	Agent     agent.SubscriptionAgent
+	Redirects RedirectMap
+	Topics    []string
+}
+
+func (h *apiHandler) getList(op *eventOperation) (*apiList, error) {
+	if op.List == "" {
+		return &apiList{h.SiteTitle, h.Agent, h.Redirects, h.Topics}, nil
+	} else if list, ok := h.Lists[op.List]; ok {
+		return list, nil
+	}
+	return nil, &ParseError{op.Type, "unknown list: " + op.List}
+}
+
+// checkTopics returns an error if op contains any topic not in list.Topics, or
+// if op is a Topics operation and list.Topics is empty.
+func (list *apiList) checkTopics(op *eventOperation) (err error) {
+	if op.Type == Topics && len(list.Topics) == 0 {
+		return &ParseError{op.Type, "list has no topics"}
+	}
+	for _, topic := range op.Topics {
+		if !slices.Contains(list.Topics, topic) {
+			_, err = paramError(op.Type, errors.New("unknown topic: "+topic))
+			return
+		}
+	}
+	return
+}
+
 type responseTemplateParams struct {
 	Title     string
 	SiteTitle string
@@ -109,14 +181,20 @@ func (h *apiHandler) addResponseBody(
 ) {
 	httpStatus := res.StatusCode
 	title := fmt.Sprintf("%d %s", httpStatus, http.StatusText(httpStatus))
-	params := &responseTemplateParams{title, h.SiteTitle, body}
+	h.addResponseBodyWithTitle(res, h.SiteTitle, title, body)
+}
+
+func (h *apiHandler) addResponseBodyWithTitle(
+	res *events.APIGatewayProxyResponse, siteTitle, title, body string,
+) {
+	params := &responseTemplateParams{title, siteTitle, body}
 	builder := &strings.Builder{}
 
 	if err := h.responseTemplate.Execute(builder, params); err != nil {
 		// This should never happen, but if it does, fall back to plain text.
 		h.log.Printf("ERROR adding HTML response body: %s: %+v", err, params)
 		res.Headers["content-type"] = "text/plain; charset=utf-8"
-		res.Body = fmt.Sprintf("%s - %s\n\n%s\n", title, h.SiteTitle, body)
+		res.Body = fmt.Sprintf("%s - %s\n\n%s\n", title, siteTitle, body)
 	} else {
 		res.Headers["content-type"] = "text/html; charset=utf-8"
 		res.Body = builder.String()
@@ -166,6 +244,7 @@ func logApiResponse(
 
 func newApiRequest(req *events.APIGatewayProxyRequest) (*apiRequest, error) {
 	contentType, foundContentType := req.Headers["content-type"]
+	referrer, foundReferrer := req.Headers["referer"]
 	body := req.Body
 
 	// This accounts for differences in HTTP Header casing between running
@@ -191,6 +270,9 @@ func newApiRequest(req *events.APIGatewayProxyRequest) (*apiRequest, error) {
 	if !foundContentType {
 		contentType = req.Headers["Content-Type"]
 	}
+	if !foundReferrer {
+		referrer = req.Headers["Referer"]
+	}
 
 	// For some reason, the prod API Gateway will base64 encode POST body
 	// payloads. The `sam-local` server will not. Either way, it's good to do
@@ -210,6 +292,9 @@ func newApiRequest(req *events.APIGatewayProxyRequest) (*apiRequest, error) {
 		contentType,
 		req.PathParameters,
 		body,
+		req.RequestContext.Identity.SourceIP,
+		req.RequestContext.Identity.UserAgent,
+		referrer,
 	}, nil
 }
 
@@ -219,13 +304,23 @@ func (h *apiHandler) handleApiRequest(
 	res := &events.APIGatewayProxyResponse{Headers: map[string]string{}}
 	res.Headers["content-type"] = "text/plain; charset=utf-8"
 
-	if op, err := parseApiRequest(req); err != nil {
-		return h.respondToParseError(res, err)
+	keys := h.VerifyTokenKeys
+
+	if op, err := parseApiRequest(req, keys, time.Now()); err != nil {
+		return h.respondToParseError(res, op, err)
+	} else if list, err := h.getList(op); err != nil {
+		return h.respondToParseError(res, op, err)
+	} else if err := list.checkTopics(op); err != nil {
+		return h.respondToParseError(res, op, err)
+	} else if op.Type == Topics && req.Method == http.MethodGet {
+		return h.topicsResponse(ctx, res, req.Id, list, op)
+	} else if h.needsConfirmation(req, op) {
+		return h.confirmationResponse(res, req.Id, list, op), nil
 	} else if result, err := h.performOperation(ctx, req.Id, op); err != nil {
 		return nil, err
 	} else if op.OneClick {
 		res.StatusCode = http.StatusOK
-	} else if redirect, ok := h.Redirects[result]; !ok {
+	} else if redirect, ok := list.Redirects[result]; !ok {
 		return nil, fmt.Errorf("no redirect for op result: %s", result)
 	} else {
 		res.StatusCode = http.StatusSeeOther
@@ -234,8 +329,117 @@ func (h *apiHandler) handleApiRequest(
 	return res, nil
 }
 
+func (h *apiHandler) needsConfirmation(
+	req *apiRequest, op *eventOperation,
+) bool {
+	return h.ConfirmLinks &&
+		req.Method == http.MethodGet &&
+		(op.Type == Verify || op.Type == Unsubscribe) &&
+		!op.Expired
+}
+
+// confirmationResponse returns a page with a button that POSTs the request.
+//
+// The form has no action attribute, so the browser will POST to the same URL
+// as the original GET request. This avoids having to reconstruct the URL, which
+// includes the API mapping key in production.
+func (h *apiHandler) confirmationResponse(
+	res *events.APIGatewayProxyResponse,
+	requestId string,
+	list *apiList,
+	op *eventOperation,
+) *events.APIGatewayProxyResponse {
+	email := template.HTMLEscapeString(op.Email)
+	title := "Confirm subscription"
+	prompt := "Please confirm your subscription for <strong>" + email +
+		"</strong>."
+	button := "Confirm subscription"
+
+	if op.Type == Unsubscribe {
+		title = "Confirm unsubscribe"
+		prompt = "Please confirm that you want to unsubscribe <strong>" +
+			email + "</strong>."
+		button = "Unsubscribe"
+	}
+
+	res.StatusCode = http.StatusOK
+	res.Headers["cache-control"] = "no-store"
+	body := "<p>" + prompt + "</p>\n" +
+		`<form method="post">` + "\n" +
+		`  <button type="submit">` + button + "</button>\n" +
+		"</form>"
+	h.addResponseBodyWithTitle(res, list.SiteTitle, title, body)
+	h.log.Printf("%s: confirmation requested: %s", requestId, op)
+	return res
+}
+
+// topicsResponse returns a page with a form for updating a verified
+// subscriber's topics, with the subscriber's current topics already checked.
+//
+// Like the confirmation page, the form has no action attribute, so the browser
+// will POST the checked topics to the same URL as the original GET request. If
+// the subscriber doesn't exist, isn't verified, or has a different UID, it
+// redirects to the ops.NotSubscribed page instead.
+func (h *apiHandler) topicsResponse(
+	ctx context.Context,
+	res *events.APIGatewayProxyResponse,
+	requestId string,
+	list *apiList,
+	op *eventOperation,
+) (*events.APIGatewayProxyResponse, error) {
+	sub, err := list.Agent.GetSubscriber(ctx, op.Email)
+
+	if errors.Is(err, db.ErrSubscriberNotFound) {
+		sub = nil
+	} else if errors.Is(err, ops.ErrExternal) {
+		return nil, &errorWithStatus{http.StatusBadGateway, err.Error()}
+	} else if err != nil {
+		return nil, err
+	}
+
+	if sub == nil ||
+		sub.Status != db.SubscriberVerified ||
+		!sub.MatchesUid(op.Uid, time.Now()) {
+		res.StatusCode = http.StatusSeeOther
+		res.Headers["location"] = list.Redirects[ops.NotSubscribed]
+		h.log.Printf("%s: topics requested: %s: not subscribed", requestId, op)
+		return res, nil
+	}
+
+	const checkboxFmt = `  <label><input type="checkbox" name="%s" ` +
+		`value="%s"%s/> %s</label><br/>` + "\n"
+	body := &strings.Builder{}
+	body.WriteString("<p>Choose the topics for <strong>" +
+		template.HTMLEscapeString(op.Email) + "</strong>.</p>\n" +
+		`<form method="post">` + "\n")
+
+	for _, topic := range list.Topics {
+		checked := ""
+		if sub.HasTag(topic) {
+			checked = " checked"
+		}
+		escaped := template.HTMLEscapeString(topic)
+		fmt.Fprintf(body, checkboxFmt, topicsParam, escaped, checked, escaped)
+	}
+	body.WriteString(`  <button type="submit">Update topics</button>` + "\n" +
+		"</form>")
+
+	res.StatusCode = http.StatusOK
+	res.Headers["cache-control"] = "no-store"
+	h.addResponseBodyWithTitle(
+		res, list.SiteTitle, "Update topics", body.String(),
+	)
+	h.log.Printf("%s: topics requested: %s", requestId, op)
+	return res, nil
+}
+
+// respondToParseError redirects to the Invalid page for op's list if err wraps
+// ErrUserInput. Otherwise, or if op's list doesn't exist, it returns a page
+// describing the error.
+//
+// op may be nil if err doesn't wrap ErrUserInput.
 func (h *apiHandler) respondToParseError(
-	response *events.APIGatewayProxyResponse, err error,
+	response *events.APIGatewayProxyResponse, op *eventOperation, err error,
 ) (*events.APIGatewayProxyResponse, error) {
 	if !errors.Is(err, ErrUserInput) {
 		response.StatusCode = http.StatusBadRequest
@@ -243,7 +447,9 @@ func (h *apiHandler) respondToParseError(
 			"<pre>\n" + template.HTMLEscapeString(err.Error()) + "\n</pre>\n" +
 			"<p>Please correct the request and try again.</p>"
 		h.addResponseBody(response, body)
-	} else if redirect, ok := h.Redirects[ops.Invalid]; !ok {
+	} else if list, listErr := h.getList(op); listErr != nil {
+		return h.respondToParseError(response, op, listErr)
+	} else if redirect, ok := list.Redirects[ops.Invalid]; !ok {
 		return nil, errors.New("no redirect for invalid operation")
 	} else {
 		response.StatusCode = http.StatusSeeOther
@@ -255,13 +461,30 @@ func (h *apiHandler) respondToParseError(
 func (h *apiHandler) performOperation(
 	ctx context.Context, requestId string, op *eventOperation,
 ) (result ops.OperationResult, err error) {
+	var list *apiList
+
+	if list, err = h.getList(op); err != nil {
+		return
+	}
+	ctx = agent.WithAuditInfo(ctx, db.AuditSourceApi, requestId)
+
 	switch op.Type {
 	case Subscribe:
-		result, err = h.Agent.Subscribe(ctx, op.Email)
+		result, err = list.Agent.Subscribe(
+			ctx, op.Email, op.Topics, op.Signup, op.Profile,
+		)
 	case Verify:
-		result, err = h.Agent.Verify(ctx, op.Email, op.Uid)
+		if op.Expired {
+			result = ops.VerifyLinkExpired
+		} else {
+			result, err = list.Agent.Verify(ctx, op.Email, op.Uid)
+		}
 	case Unsubscribe:
-		result, err = h.Agent.Unsubscribe(ctx, op.Email, op.Uid)
+		result, err = list.Agent.Unsubscribe(ctx, op.Email, op.Uid)
+	case Topics:
+		result, err = list.Agent.UpdateTopics(
+			ctx, op.Email, op.Uid, op.Topics,
+		)
 	default:
 		err = fmt.Errorf("can't handle operation type: %s", op.Type)
 	}
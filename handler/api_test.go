@@ -12,8 +12,12 @@ import (
 	"strings"
 	"testing"
 	"text/template"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/google/uuid"
+	"github.com/mbland/elistman/agent"
+	"github.com/mbland/elistman/db"
 	"github.com/mbland/elistman/ops"
 	"github.com/mbland/elistman/testutils"
 	"gotest.tools/assert"
@@ -58,11 +62,14 @@ func newApiHandlerFixture() *apiHandlerFixture {
 	logs := &testutils.Logs{}
 	agent := &testAgent{}
 	handler, err := newApiHandler(
-		testEmailDomain,
+		testSiteUrl,
 		testSiteTitle,
 		agent,
 		testRedirects,
+		testTopics,
 		ResponseTemplate,
+		true,
+		nil,
 		logs.NewLogger(),
 	)
 
@@ -77,12 +84,13 @@ func TestNewApiHandler(t *testing.T) {
 
 	t.Run("SetsBasicFields", func(t *testing.T) {
 		assert.Equal(t, testSiteTitle, f.handler.SiteTitle)
+		assert.Equal(t, true, f.handler.ConfirmLinks)
 		assert.Assert(t, f.handler.responseTemplate != nil)
 	})
 
 	t.Run("SetsRedirectMap", func(t *testing.T) {
 		fullUrl := func(path string) string {
-			return "https://" + testEmailDomain + "/" + path
+			return testSiteUrl + "/" + path
 		}
 		expected := RedirectMap{
 			ops.Invalid:           fullUrl(testRedirects.Invalid),
@@ -91,6 +99,8 @@ func TestNewApiHandler(t *testing.T) {
 			ops.Subscribed:        fullUrl(testRedirects.Subscribed),
 			ops.NotSubscribed:     fullUrl(testRedirects.NotSubscribed),
 			ops.Unsubscribed:      fullUrl(testRedirects.Unsubscribed),
+			ops.VerifyLinkExpired: fullUrl(testRedirects.VerifyLinkExpired),
+			ops.TopicsUpdated:     fullUrl(testRedirects.TopicsUpdated),
 		}
 
 		assert.DeepEqual(t, expected, f.handler.Redirects)
@@ -100,11 +110,14 @@ func TestNewApiHandler(t *testing.T) {
 		tmpl := "{{.Bogus}}"
 
 		handler, err := newApiHandler(
-			testEmailDomain,
+			testSiteUrl,
 			testSiteTitle,
 			&testAgent{},
 			testRedirects,
+			testTopics,
 			tmpl,
+			true,
+			nil,
 			&log.Logger{},
 		)
 
@@ -229,16 +242,29 @@ func TestNewApiRequest(t *testing.T) {
 			RequestContext: events.APIGatewayProxyRequestContext{
 				RequestID:    requestId,
 				ResourcePath: rawPath,
-				Identity:     events.APIGatewayRequestIdentity{},
+				Identity: events.APIGatewayRequestIdentity{
+					SourceIP: "192.0.2.1", UserAgent: "Mozilla/5.0",
+				},
+			},
+			Headers: map[string]string{
+				"content-type": contentType,
+				"referer":      "https://mike-bland.com/",
 			},
-			Headers:        map[string]string{"content-type": contentType},
 			PathParameters: pathParams,
 			Body:           body,
 		}
 	}
 
 	expectedReq := &apiRequest{
-		requestId, rawPath, http.MethodPost, contentType, pathParams, body,
+		requestId,
+		rawPath,
+		http.MethodPost,
+		contentType,
+		pathParams,
+		body,
+		"192.0.2.1",
+		"Mozilla/5.0",
+		"https://mike-bland.com/",
 	}
 
 	t.Run("Succeeds", func(t *testing.T) {
@@ -259,6 +285,17 @@ func TestNewApiRequest(t *testing.T) {
 		assert.Equal(t, contentType, req.ContentType)
 	})
 
+	t.Run("ParsesUppercaseReferer", func(t *testing.T) {
+		awsReq := newReq()
+		delete(awsReq.Headers, "referer")
+		awsReq.Headers["Referer"] = "https://mike-bland.com/"
+
+		req, err := newApiRequest(awsReq)
+
+		assert.NilError(t, err)
+		assert.Equal(t, "https://mike-bland.com/", req.Referrer)
+	})
+
 	t.Run("DecodesBase64EncodedBody", func(t *testing.T) {
 		awsReq := newReq()
 		awsReq.Body = base64.StdEncoding.EncodeToString([]byte(body))
@@ -285,10 +322,11 @@ func TestNewApiRequest(t *testing.T) {
 func TestRespondToParseError(t *testing.T) {
 	f := newApiHandlerFixture()
 	userInputError := fmt.Errorf("%w: PEBKAC", ErrUserInput)
+	subscribeOp := &eventOperation{Type: Subscribe}
 
 	t.Run("ReturnsBadRequestIfNotErrUserInput", func(t *testing.T) {
 		res, err := f.handler.respondToParseError(
-			apiGatewayResponse(http.StatusOK), errors.New("not a PEBKAC"),
+			apiGatewayResponse(http.StatusOK), nil, errors.New("not a PEBKAC"),
 		)
 
 		assert.NilError(t, err)
@@ -299,6 +337,7 @@ func TestRespondToParseError(t *testing.T) {
 	t.Run("HtmlEscapesErrorInResponseBody", func(t *testing.T) {
 		res, err := f.handler.respondToParseError(
 			apiGatewayResponse(http.StatusOK),
+			nil,
 			errors.New("mbland@<script>alert('pwned')</script>acm.org"),
 		)
 
@@ -313,7 +352,7 @@ func TestRespondToParseError(t *testing.T) {
 		delete(f.handler.Redirects, ops.Invalid)
 
 		res, err := f.handler.respondToParseError(
-			apiGatewayResponse(http.StatusOK), userInputError,
+			apiGatewayResponse(http.StatusOK), subscribeOp, userInputError,
 		)
 
 		assert.Assert(t, is.Nil(res))
@@ -322,7 +361,7 @@ func TestRespondToParseError(t *testing.T) {
 
 	t.Run("RedirectsToInvalidOpPageIfBadSubscribeInput", func(t *testing.T) {
 		res, err := f.handler.respondToParseError(
-			apiGatewayResponse(http.StatusOK), userInputError,
+			apiGatewayResponse(http.StatusOK), subscribeOp, userInputError,
 		)
 
 		assert.NilError(t, err)
@@ -331,6 +370,35 @@ func TestRespondToParseError(t *testing.T) {
 			t, f.handler.Redirects[ops.Invalid], res.Headers["location"],
 		)
 	})
+
+	t.Run("RedirectsToInvalidOpPageForList", func(t *testing.T) {
+		f := newApiHandlerFixture()
+		listRedirects := map[ops.OperationResult]string{
+			ops.Invalid: "https://mike-bland.com/updates/invalid",
+		}
+		f.handler.Lists["updates"] = &apiList{Redirects: listRedirects}
+		op := &eventOperation{Type: Subscribe, List: "updates"}
+
+		res, err := f.handler.respondToParseError(
+			apiGatewayResponse(http.StatusOK), op, userInputError,
+		)
+
+		assert.NilError(t, err)
+		assert.Equal(t, http.StatusSeeOther, res.StatusCode)
+		assert.Equal(t, listRedirects[ops.Invalid], res.Headers["location"])
+	})
+
+	t.Run("ReturnsBadRequestIfListIsUnknown", func(t *testing.T) {
+		op := &eventOperation{Type: Subscribe, List: "bogus"}
+
+		res, err := f.handler.respondToParseError(
+			apiGatewayResponse(http.StatusOK), op, userInputError,
+		)
+
+		assert.NilError(t, err)
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+		assert.Assert(t, is.Contains(res.Body, "unknown list: bogus"))
+	})
 }
 
 func TestLogOperationResult(t *testing.T) {
@@ -377,6 +445,60 @@ func TestPerformOperation(t *testing.T) {
 		f.logs.AssertContains(t, "deadbeef: result: Subscribe")
 	})
 
+	t.Run("SubscribePassesTopics", func(t *testing.T) {
+		f := newApiHandlerFixture()
+		f.agent.OpResult = ops.VerifyLinkSent
+		topics := []string{"essays", "releases"}
+
+		result, err := f.handler.performOperation(
+			f.ctx,
+			"deadbeef",
+			&eventOperation{
+				Type: Subscribe, Email: "mbland@acm.org", Topics: topics,
+			},
+		)
+
+		assert.NilError(t, err)
+		assert.Equal(t, ops.VerifyLinkSent, result)
+		assert.DeepEqual(t, topics, f.agent.Calls[0].Topics)
+	})
+
+	t.Run("SubscribePassesSignupMetadata", func(t *testing.T) {
+		f := newApiHandlerFixture()
+		f.agent.OpResult = ops.VerifyLinkSent
+		signup := &db.SignupMetadata{Source: "footer", SourceIp: "192.0.2.1"}
+
+		result, err := f.handler.performOperation(
+			f.ctx,
+			"deadbeef",
+			&eventOperation{
+				Type: Subscribe, Email: "mbland@acm.org", Signup: signup,
+			},
+		)
+
+		assert.NilError(t, err)
+		assert.Equal(t, ops.VerifyLinkSent, result)
+		assert.Equal(t, signup, f.agent.Calls[0].Signup)
+	})
+
+	t.Run("SubscribePassesProfile", func(t *testing.T) {
+		f := newApiHandlerFixture()
+		f.agent.OpResult = ops.VerifyLinkSent
+		profile := &agent.Profile{FirstName: "Mike"}
+
+		result, err := f.handler.performOperation(
+			f.ctx,
+			"deadbeef",
+			&eventOperation{
+				Type: Subscribe, Email: "mbland@acm.org", Profile: profile,
+			},
+		)
+
+		assert.NilError(t, err)
+		assert.Equal(t, ops.VerifyLinkSent, result)
+		assert.Equal(t, profile, f.agent.Calls[0].Profile)
+	})
+
 	t.Run("VerifySucceeds", func(t *testing.T) {
 		f := newApiHandlerFixture()
 		f.agent.OpResult = ops.Subscribed
@@ -394,6 +516,46 @@ func TestPerformOperation(t *testing.T) {
 		f.logs.AssertContains(t, "deadbeef: result: Verify")
 	})
 
+	t.Run("VerifyReturnsVerifyLinkExpiredIfExpired", func(t *testing.T) {
+		f := newApiHandlerFixture()
+		f.agent.OpResult = ops.Subscribed
+
+		result, err := f.handler.performOperation(
+			f.ctx,
+			"deadbeef",
+			&eventOperation{
+				Type:    Verify,
+				Email:   "mbland@acm.org",
+				Uid:     testValidUid,
+				Expired: true,
+			},
+		)
+
+		assert.NilError(t, err)
+		assert.Equal(t, ops.VerifyLinkExpired, result)
+		assert.Equal(t, 0, len(f.agent.Calls))
+		f.logs.AssertContains(
+			t, "deadbeef: result: Verify (Expired): mbland@acm.org",
+		)
+	})
+
+	t.Run("AddsAuditInfo", func(t *testing.T) {
+		f := newApiHandlerFixture()
+		f.agent.OpResult = ops.Subscribed
+
+		_, err := f.handler.performOperation(
+			f.ctx,
+			"deadbeef",
+			&eventOperation{
+				Type: Verify, Email: "mbland@acm.org", Uid: testValidUid,
+			},
+		)
+
+		assert.NilError(t, err)
+		assert.Equal(t, db.AuditSourceApi, f.agent.AuditSource)
+		assert.Equal(t, "deadbeef", f.agent.RequestId)
+	})
+
 	t.Run("UnsubscribeSucceeds", func(t *testing.T) {
 		f := newApiHandlerFixture()
 		f.agent.OpResult = ops.Unsubscribed
@@ -411,6 +573,30 @@ func TestPerformOperation(t *testing.T) {
 		f.logs.AssertContains(t, "deadbeef: result: Unsubscribe")
 	})
 
+	t.Run("TopicsSucceeds", func(t *testing.T) {
+		f := newApiHandlerFixture()
+		f.agent.OpResult = ops.TopicsUpdated
+		topics := []string{"releases"}
+
+		result, err := f.handler.performOperation(
+			f.ctx,
+			"deadbeef",
+			&eventOperation{
+				Type:   Topics,
+				Email:  "mbland@acm.org",
+				Uid:    testValidUid,
+				Topics: topics,
+			},
+		)
+
+		assert.NilError(t, err)
+		assert.Equal(t, ops.TopicsUpdated, result)
+		assert.Equal(t, "UpdateTopics", f.agent.Calls[0].Method)
+		assert.Equal(t, testValidUid, f.agent.Calls[0].Uid)
+		assert.DeepEqual(t, topics, f.agent.Calls[0].Topics)
+		f.logs.AssertContains(t, "deadbeef: result: Topics")
+	})
+
 	t.Run("RaisesErrorIfCantHandleOpType", func(t *testing.T) {
 		f := newApiHandlerFixture()
 
@@ -511,6 +697,246 @@ func TestHandleApiRequest(t *testing.T) {
 		assert.Equal(t, http.StatusOK, response.StatusCode)
 	})
 
+	t.Run("ReturnsConfirmationPageForGetUnsubscribe", func(t *testing.T) {
+		f := newApiHandlerFixture()
+		req := newUnsubscribeRequest()
+		req.Method = http.MethodGet
+		req.ContentType = ""
+
+		response, err := f.handler.handleApiRequest(f.ctx, req)
+
+		assert.NilError(t, err)
+		assert.Equal(t, 0, len(f.agent.Calls))
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		assert.Equal(t, "no-store", response.Headers["cache-control"])
+		assert.Assert(t, is.Contains(response.Body, "Confirm unsubscribe"))
+		assert.Assert(t, is.Contains(response.Body, "mbland@acm.org"))
+		assert.Assert(t, is.Contains(response.Body, `<form method="post">`))
+		const button = `<button type="submit">Unsubscribe</button>`
+		assert.Assert(t, is.Contains(response.Body, button))
+		f.logs.AssertContains(t, "deadbeef: confirmation requested: ")
+	})
+
+	t.Run("ReturnsConfirmationPageForGetVerify", func(t *testing.T) {
+		f := newApiHandlerFixture()
+		req := newUnsubscribeRequest()
+		req.RawPath = ops.ApiPrefixVerify + "mbland@acm.org/" + testValidUidStr
+		req.Method = http.MethodGet
+		req.ContentType = ""
+
+		response, err := f.handler.handleApiRequest(f.ctx, req)
+
+		assert.NilError(t, err)
+		assert.Equal(t, 0, len(f.agent.Calls))
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		const button = `<button type="submit">Confirm subscription</button>`
+		assert.Assert(t, is.Contains(response.Body, button))
+	})
+
+	t.Run("PerformsGetOperationIfNotConfirmingLinks", func(t *testing.T) {
+		f := newApiHandlerFixture()
+		f.handler.ConfirmLinks = false
+		f.agent.OpResult = ops.Unsubscribed
+		req := newUnsubscribeRequest()
+		req.Method = http.MethodGet
+		req.ContentType = ""
+
+		response, err := f.handler.handleApiRequest(f.ctx, req)
+
+		assert.NilError(t, err)
+		assert.Equal(t, "Unsubscribe", f.agent.Calls[0].Method)
+		assert.Equal(t, http.StatusSeeOther, response.StatusCode)
+	})
+
+	t.Run("RedirectsIfVerifyLinkExpiredWithoutConfirming", func(t *testing.T) {
+		f := newApiHandlerFixture()
+		f.handler.VerifyTokenKeys = testVerifyTokenKeys
+		token := testVerifyTokenKeys.Sign(&ops.VerifyToken{
+			Email:   "mbland@acm.org",
+			Uid:     testValidUid,
+			Expires: time.Now().Add(-time.Minute),
+		})
+		req := newUnsubscribeRequest()
+		req.RawPath = ops.ApiPrefixVerify + "mbland@acm.org/" + token
+		req.Params["uid"] = token
+		req.Method = http.MethodGet
+		req.ContentType = ""
+
+		response, err := f.handler.handleApiRequest(f.ctx, req)
+
+		assert.NilError(t, err)
+		assert.Equal(t, 0, len(f.agent.Calls))
+		assert.Equal(t, http.StatusSeeOther, response.StatusCode)
+		expected := f.handler.Redirects[ops.VerifyLinkExpired]
+		assert.Equal(t, expected, response.Headers["location"])
+	})
+
+	t.Run("VerifiesWithValidToken", func(t *testing.T) {
+		f := newApiHandlerFixture()
+		f.handler.VerifyTokenKeys = testVerifyTokenKeys
+		f.agent.OpResult = ops.Subscribed
+		token := testVerifyTokenKeys.Sign(&ops.VerifyToken{
+			Email:   "mbland@acm.org",
+			Uid:     testValidUid,
+			Expires: time.Now().Add(time.Hour),
+		})
+		req := newUnsubscribeRequest()
+		req.RawPath = ops.ApiPrefixVerify + "mbland@acm.org/" + token
+		req.Params["uid"] = token
+
+		response, err := f.handler.handleApiRequest(f.ctx, req)
+
+		assert.NilError(t, err)
+		assert.Equal(t, "Verify", f.agent.Calls[0].Method)
+		assert.Equal(t, testValidUid, f.agent.Calls[0].Uid)
+		expected := f.handler.Redirects[ops.Subscribed]
+		assert.Equal(t, expected, response.Headers["location"])
+	})
+
+	t.Run("RedirectsToInvalidIfSubscribeTopicNotAllowed", func(t *testing.T) {
+		f := newApiHandlerFixture()
+		req := &apiRequest{
+			Id:          "deadbeef",
+			RawPath:     ops.ApiPrefixSubscribe,
+			Method:      http.MethodPost,
+			ContentType: "application/x-www-form-urlencoded",
+			Body:        "email=mbland%40acm.org&topics=essays,podcasts",
+		}
+
+		response, err := f.handler.handleApiRequest(f.ctx, req)
+
+		assert.NilError(t, err)
+		assert.Equal(t, 0, len(f.agent.Calls))
+		assert.Equal(t, http.StatusSeeOther, response.StatusCode)
+		expected := f.handler.Redirects[ops.Invalid]
+		assert.Equal(t, expected, response.Headers["location"])
+	})
+
+	newTopicsRequest := func(method, body string) *apiRequest {
+		req := newUnsubscribeRequest()
+		req.RawPath = ops.ApiPrefixTopics + "mbland@acm.org/" + testValidUidStr
+		req.Method = method
+		req.Body = body
+		if method == http.MethodGet {
+			req.ContentType = ""
+		}
+		return req
+	}
+
+	t.Run("ReturnsBadRequestForTopicsIfListHasNone", func(t *testing.T) {
+		f := newApiHandlerFixture()
+		f.handler.Topics = nil
+
+		response, err := f.handler.handleApiRequest(
+			f.ctx, newTopicsRequest(http.MethodGet, ""),
+		)
+
+		assert.NilError(t, err)
+		assert.Equal(t, 0, len(f.agent.Calls))
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+		assert.Assert(t, is.Contains(response.Body, "list has no topics"))
+	})
+
+	t.Run("ReturnsBadRequestIfTopicNotAllowed", func(t *testing.T) {
+		f := newApiHandlerFixture()
+
+		response, err := f.handler.handleApiRequest(
+			f.ctx, newTopicsRequest(http.MethodPost, "topics=podcasts"),
+		)
+
+		assert.NilError(t, err)
+		assert.Equal(t, 0, len(f.agent.Calls))
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+		assert.Assert(t, is.Contains(response.Body, "unknown topic: podcasts"))
+	})
+
+	t.Run("ReturnsTopicsFormForGetTopics", func(t *testing.T) {
+		f := newApiHandlerFixture()
+		f.agent.Subscribers = []*db.Subscriber{{
+			Email:  "mbland@acm.org",
+			Uid:    testValidUid,
+			Status: db.SubscriberVerified,
+			Tags:   []string{"releases"},
+		}}
+
+		response, err := f.handler.handleApiRequest(
+			f.ctx, newTopicsRequest(http.MethodGet, ""),
+		)
+
+		assert.NilError(t, err)
+		assert.Equal(t, "GetSubscriber", f.agent.Calls[0].Method)
+		assert.Equal(t, 1, len(f.agent.Calls))
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		assert.Equal(t, "no-store", response.Headers["cache-control"])
+		assert.Assert(t, is.Contains(response.Body, "Update topics"))
+		assert.Assert(t, is.Contains(response.Body, `<form method="post">`))
+		const essays = `<input type="checkbox" name="topics" ` +
+			`value="essays"/> essays`
+		const releases = `<input type="checkbox" name="topics" ` +
+			`value="releases" checked/> releases`
+		assert.Assert(t, is.Contains(response.Body, essays))
+		assert.Assert(t, is.Contains(response.Body, releases))
+		f.logs.AssertContains(t, "deadbeef: topics requested: ")
+	})
+
+	t.Run("RedirectsToNotSubscribedForGetTopics", func(t *testing.T) {
+		for _, sub := range []*db.Subscriber{
+			nil,
+			{
+				Email:  "mbland@acm.org",
+				Uid:    testValidUid,
+				Status: db.SubscriberPending,
+			},
+			{
+				Email:  "mbland@acm.org",
+				Uid:    uuid.New(),
+				Status: db.SubscriberVerified,
+			},
+		} {
+			f := newApiHandlerFixture()
+			if sub != nil {
+				f.agent.Subscribers = []*db.Subscriber{sub}
+			}
+
+			response, err := f.handler.handleApiRequest(
+				f.ctx, newTopicsRequest(http.MethodGet, ""),
+			)
+
+			assert.NilError(t, err)
+			assert.Equal(t, http.StatusSeeOther, response.StatusCode)
+			expected := f.handler.Redirects[ops.NotSubscribed]
+			assert.Equal(t, expected, response.Headers["location"])
+		}
+	})
+
+	t.Run("ReturnsErrorIfGetSubscriberFailsForGetTopics", func(t *testing.T) {
+		f := newApiHandlerFixture()
+		f.agent.Error = newOpsErrExternal("not our fault...")
+
+		response, err := f.handler.handleApiRequest(
+			f.ctx, newTopicsRequest(http.MethodGet, ""),
+		)
+
+		assert.DeepEqual(t, newBadGatewayError("not our fault..."), err)
+		assert.Assert(t, is.Nil(response))
+	})
+
+	t.Run("UpdatesTopicsForPostTopics", func(t *testing.T) {
+		f := newApiHandlerFixture()
+		f.agent.OpResult = ops.TopicsUpdated
+
+		response, err := f.handler.handleApiRequest(
+			f.ctx, newTopicsRequest(http.MethodPost, "topics=essays"),
+		)
+
+		assert.NilError(t, err)
+		assert.Equal(t, "UpdateTopics", f.agent.Calls[0].Method)
+		assert.DeepEqual(t, []string{"essays"}, f.agent.Calls[0].Topics)
+		assert.Equal(t, http.StatusSeeOther, response.StatusCode)
+		expected := f.handler.Redirects[ops.TopicsUpdated]
+		assert.Equal(t, expected, response.Headers["location"])
+	})
+
 	t.Run("ReturnsErrorIfNoRedirectForOpResult", func(t *testing.T) {
 		f := newApiHandlerFixture()
 		f.agent.OpResult = ops.Unsubscribed
@@ -2,27 +2,53 @@ package handler
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
+	"github.com/aws/aws-lambda-go/lambdacontext"
 	"github.com/mbland/elistman/agent"
+	"github.com/mbland/elistman/db"
 	"github.com/mbland/elistman/events"
 )
 
 type cliHandler struct {
 	Agent agent.SubscriptionAgent
+	Lists listAgents
 	Log   *log.Logger
 }
 
 func (h *cliHandler) HandleEvent(
 	ctx context.Context, e *events.CommandLineEvent,
 ) (res any, err error) {
+	requestId := ""
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		requestId = lc.AwsRequestID
+	}
+	ctx = agent.WithAuditInfo(ctx, db.AuditSourceCli, requestId)
+
 	switch e.EListManCommand {
 	case events.CommandLineSendEvent:
 		res = h.HandleSendEvent(ctx, e.Send)
 	case events.CommandLineImportEvent:
 		res = h.HandleImportEvent(ctx, e.Import)
+	case events.CommandLineCampaignsEvent:
+		res = h.HandleCampaignsEvent(ctx, e.Campaigns)
+	case events.CommandLineHistoryEvent:
+		res = h.HandleHistoryEvent(ctx, e.History)
+	case events.CommandLineExportSubscriberEvent:
+		res = h.HandleExportSubscriberEvent(ctx, e.ExportSubscriber)
+	case events.CommandLineEraseEvent:
+		res = h.HandleEraseEvent(ctx, e.Erase)
+	case events.CommandLineRotateUidsEvent:
+		res = h.HandleRotateUidsEvent(ctx, e.RotateUids)
+	case events.CommandLineSubscribersEvent:
+		res = h.HandleSubscribersEvent(ctx, e.Subscribers)
+	case events.CommandLineWelcomeEvent:
+		res = h.HandleWelcomeEvent(ctx, e.Welcome)
 	default:
 		err = fmt.Errorf("unknown EListMan command: %s", e.EListManCommand)
 	}
@@ -31,18 +57,64 @@ func (h *cliHandler) HandleEvent(
 
 func (h *cliHandler) HandleSendEvent(
 	ctx context.Context, e *events.SendEvent,
+) (res *events.SendResponse) {
+	res = &events.SendResponse{}
+	var a agent.SubscriptionAgent
+	var err error
+	var incompleteErr *agent.IncompleteSendError
+
+	if a, err = h.Lists.get(h.Agent, e.List); err != nil {
+		// Report the error below.
+	} else if !e.SendAt.IsZero() {
+		return h.scheduleSend(ctx, a, e)
+	} else if e.ResumeCampaignId != "" {
+		res.NumSent, res.NumSkipped, err = a.ResumeSend(
+			ctx, e.ResumeCampaignId, &e.Message,
+		)
+	} else {
+		res.NumSent, res.NumSkipped, err = a.Send(
+			ctx, &e.Message, e.Addresses, e.IdempotencyKey, e.TagFilter,
+		)
+	}
+
+	if res.Success = err == nil; !res.Success {
+		res.Details = err.Error()
+	}
+	if errors.As(err, &incompleteErr) {
+		res.CampaignId = incompleteErr.CampaignId
+	}
+
+	const logFmt = "send: subject: \"%s\"; success: %t; " +
+		"num sent: %d; num skipped: %d"
+	h.Log.Printf(
+		logFmt, e.Message.Subject, res.Success, res.NumSent, res.NumSkipped,
+	)
+	return
+}
+
+func (h *cliHandler) scheduleSend(
+	ctx context.Context, a agent.SubscriptionAgent, e *events.SendEvent,
 ) (res *events.SendResponse) {
 	res = &events.SendResponse{}
 	var err error
 
-	res.NumSent, err = h.Agent.Send(ctx, &e.Message, e.Addresses)
+	if len(e.Addresses) != 0 || e.ResumeCampaignId != "" {
+		err = errors.New("can only schedule new sends to the entire list")
+	} else if e.IdempotencyKey != "" {
+		err = errors.New("can't schedule a send with an idempotency key")
+	} else if e.TagFilter != "" {
+		err = errors.New("can't schedule a send with a tag filter")
+	} else {
+		res.ScheduledId, err = a.Schedule(ctx, &e.Message, e.SendAt)
+	}
 
 	if res.Success = err == nil; !res.Success {
 		res.Details = err.Error()
 	}
 
-	const logFmt = "send: subject: \"%s\"; success: %t; num sent: %d"
-	h.Log.Printf(logFmt, e.Message.Subject, res.Success, res.NumSent)
+	const logFmt = "schedule: subject: \"%s\"; send at: %s; success: %t"
+	sendAt := e.SendAt.Format(time.RFC3339)
+	h.Log.Printf(logFmt, e.Message.Subject, sendAt, res.Success)
 	return
 }
 
@@ -51,9 +123,15 @@ func (h *cliHandler) HandleImportEvent(
 ) (response *events.ImportResponse) {
 	failures := make([]string, 0, len(e.Addresses))
 	imported := make([]string, 0, len(e.Addresses))
+	a, err := h.Lists.get(h.Agent, e.List)
+
+	if err != nil {
+		h.Log.Printf("failed to import: %s", err)
+		return &events.ImportResponse{Failures: []string{err.Error()}}
+	}
 
 	for _, addr := range e.Addresses {
-		if err := h.Agent.Import(ctx, addr); err != nil {
+		if err := a.Import(ctx, addr, e.Profiles[addr]); err != nil {
 			failures = append(failures, fmt.Sprintf("%s: %s", addr, err))
 		} else {
 			imported = append(imported, addr)
@@ -73,3 +151,199 @@ func (h *cliHandler) HandleImportEvent(
 	}
 	return
 }
+
+func (h *cliHandler) HandleCampaignsEvent(
+	ctx context.Context, e *events.CampaignsEvent,
+) (res *events.CampaignsResponse) {
+	res = &events.CampaignsResponse{}
+	var a agent.SubscriptionAgent
+	var err error
+
+	if a, err = h.Lists.get(h.Agent, e.List); err != nil {
+		// Report the error below.
+	} else if e.CampaignId == "" {
+		res.Campaigns, err = a.ListCampaigns(ctx)
+	} else {
+		var c *db.Campaign
+		if c, err = a.GetCampaign(ctx, e.CampaignId); err == nil {
+			res.Campaigns = []*db.Campaign{c}
+		}
+	}
+
+	if res.Success = err == nil; !res.Success {
+		res.Details = err.Error()
+		h.Log.Printf("failed to get campaigns: %s", err)
+	}
+	return
+}
+
+func (h *cliHandler) HandleHistoryEvent(
+	ctx context.Context, e *events.HistoryEvent,
+) (res *events.HistoryResponse) {
+	res = &events.HistoryResponse{}
+	var a agent.SubscriptionAgent
+	var err error
+
+	if a, err = h.Lists.get(h.Agent, e.List); err == nil {
+		res.Events, err = a.History(ctx, e.Email)
+	}
+
+	if res.Success = err == nil; !res.Success {
+		res.Details = err.Error()
+		h.Log.Printf("failed to get history for %s: %s", e.Email, err)
+	}
+	return
+}
+
+func (h *cliHandler) HandleExportSubscriberEvent(
+	ctx context.Context, e *events.ExportSubscriberEvent,
+) (res *events.ExportSubscriberResponse) {
+	res = &events.ExportSubscriberResponse{}
+	data := make([]*agent.SubscriberData, 0, len(h.Lists)+1)
+
+	err := h.Lists.each(
+		h.Agent,
+		func(_ string, a agent.SubscriptionAgent) (err error) {
+			var d *agent.SubscriberData
+			if d, err = a.ExportSubscriber(ctx, e.Email); err == nil {
+				data = append(data, d)
+			}
+			return
+		},
+	)
+
+	if res.Success = err == nil; res.Success {
+		res.Data = data
+	} else {
+		res.Details = err.Error()
+		h.Log.Printf("failed to export data for %s: %s", e.Email, err)
+	}
+	return
+}
+
+func (h *cliHandler) HandleEraseEvent(
+	ctx context.Context, e *events.EraseEvent,
+) (res *events.EraseResponse) {
+	res = &events.EraseResponse{Lists: make([]string, 0, len(h.Lists)+1)}
+
+	err := h.Lists.each(
+		h.Agent,
+		func(list string, a agent.SubscriptionAgent) (err error) {
+			if err = a.Erase(ctx, e.Email); err == nil {
+				res.Lists = append(res.Lists, list)
+			}
+			return
+		},
+	)
+
+	if res.Success = err == nil; !res.Success {
+		res.Details = err.Error()
+		h.Log.Printf("failed to erase %s: %s", e.Email, err)
+	}
+	return
+}
+
+func (h *cliHandler) HandleRotateUidsEvent(
+	ctx context.Context, e *events.RotateUidsEvent,
+) (res *events.RotateUidsResponse) {
+	res = &events.RotateUidsResponse{}
+	var a agent.SubscriptionAgent
+	var err error
+
+	if a, err = h.Lists.get(h.Agent, e.List); err == nil {
+		res.NumRotated, res.NextKey, err = a.RotateUids(
+			ctx, e.GracePeriod, e.StartKey, e.BatchSize,
+		)
+	}
+
+	if res.Success = err == nil; !res.Success {
+		res.Details = err.Error()
+		h.Log.Printf("failed to rotate UIDs: %s", err)
+	}
+	return
+}
+
+func (h *cliHandler) HandleSubscribersEvent(
+	ctx context.Context, e *events.SubscribersEvent,
+) (res *events.SubscribersResponse) {
+	res = &events.SubscribersResponse{}
+	var a agent.SubscriptionAgent
+	var err error
+
+	if a, err = h.Lists.get(h.Agent, e.List); err != nil {
+		// Report the error below.
+	} else if e.Email == "" {
+		res.Subscribers, res.NextKey, err = a.ListSubscribers(
+			ctx, e.Status, e.Since, e.Domain, e.StartKey, e.BatchSize,
+		)
+		if err == nil {
+			err = trimSubscribersResponse(res, maxSubscribersResponseSize)
+		}
+	} else {
+		var sub *db.Subscriber
+		if sub, err = a.GetSubscriber(ctx, e.Email); err == nil {
+			res.Subscribers = []*db.Subscriber{sub}
+		} else if errors.Is(err, db.ErrSubscriberNotFound) {
+			res.Subscribers = []*db.Subscriber{}
+			err = nil
+		}
+	}
+
+	if res.Success = err == nil; !res.Success {
+		res.Details = err.Error()
+		h.Log.Printf("failed to get subscribers: %s", err)
+	}
+	return
+}
+
+// maxSubscribersResponseSize limits the size of the JSON encoding of the
+// Subscribers in each SubscribersResponse. Lambda limits the response to a
+// synchronous invocation to 6MB, and this leaves plenty of room for the rest of
+// the response.
+const maxSubscribersResponseSize = 5 * 1024 * 1024
+
+// trimSubscribersResponse drops the subscribers at the end of res.Subscribers
+// whose JSON encoding would exceed maxSize, and sets res.NextKey so the next
+// request will list them.
+//
+// It always keeps the first subscriber, so that every request makes progress.
+func trimSubscribersResponse(
+	res *events.SubscribersResponse, maxSize int,
+) (err error) {
+	size := len("[]")
+
+	for i, sub := range res.Subscribers {
+		var data []byte
+
+		if data, err = json.Marshal(sub); err != nil {
+			return
+		} else if size += len(data) + len(","); size > maxSize && i != 0 {
+			res.NextKey = res.Subscribers[i-1].ScanKey()
+			res.Subscribers = res.Subscribers[:i]
+			return
+		}
+	}
+	return
+}
+
+func (h *cliHandler) HandleWelcomeEvent(
+	ctx context.Context, e *events.WelcomeEvent,
+) (res *events.WelcomeResponse) {
+	res = &events.WelcomeResponse{}
+	var a agent.SubscriptionAgent
+	var err error
+
+	if a, err = h.Lists.get(h.Agent, e.List); err != nil {
+		// Report the error below.
+	} else if !e.Set {
+		res.Message, err = a.GetWelcomeMessage(ctx)
+	} else if err = a.SetWelcomeMessage(ctx, e.Message); err == nil {
+		h.Log.Printf("welcome message updated: deleted: %t", e.Message == nil)
+	}
+
+	if res.Success = err == nil; !res.Success {
+		res.Details = err.Error()
+		h.Log.Printf("failed to handle welcome message: %s", err)
+	}
+	return
+}
@@ -4,11 +4,16 @@ package handler
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/mbland/elistman/agent"
+	"github.com/mbland/elistman/db"
 	"github.com/mbland/elistman/email"
 	"github.com/mbland/elistman/events"
 	"github.com/mbland/elistman/testutils"
@@ -24,7 +29,7 @@ func setupTestCliHandler() (
 		ImportResponse:    func(string) error { return nil },
 	}
 	logs, logger := testutils.NewLogs()
-	return &cliHandler{ta, logger}, ta, logs, context.Background()
+	return &cliHandler{ta, listAgents{}, logger}, ta, logs, context.Background()
 }
 
 func TestCliHandlerHandleSendEvent(t *testing.T) {
@@ -35,8 +40,11 @@ func TestCliHandlerHandleSendEvent(t *testing.T) {
 	expectedLogMsg := func(
 		msg *email.Message, res *events.SendResponse,
 	) string {
-		const logFmt = "send: subject: \"%s\"; success: %t; num sent: %d"
-		return fmt.Sprintf(logFmt, msg.Subject, res.Success, res.NumSent)
+		const logFmt = "send: subject: \"%s\"; success: %t; " +
+			"num sent: %d; num skipped: %d"
+		return fmt.Sprintf(
+			logFmt, msg.Subject, res.Success, res.NumSent, res.NumSkipped,
+		)
 	}
 
 	t.Run("SucceedsSendingToEntireList", func(t *testing.T) {
@@ -78,6 +86,54 @@ func TestCliHandlerHandleSendEvent(t *testing.T) {
 		assert.DeepEqual(t, expectedCalls, agent.Calls)
 	})
 
+	t.Run("PassesIdempotencyKeyAndReportsNumSkipped", func(t *testing.T) {
+		handler, agent, logs, ctx := setupTestCliHandler()
+		keyEvent := *event
+		keyEvent.IdempotencyKey = "idempotency-key"
+		agent.NumSkipped = 3
+		agent.SendResponse = func(_ *email.Message, _ []string) (int, error) {
+			return 24, nil
+		}
+
+		res := handler.HandleSendEvent(ctx, &keyEvent)
+
+		expectedResult := &events.SendResponse{
+			Success: true, NumSent: 24, NumSkipped: 3,
+		}
+		assert.DeepEqual(t, expectedResult, res)
+		logs.AssertContains(t, expectedLogMsg(&event.Message, expectedResult))
+		expectedCalls := []testAgentCalls{
+			{
+				Method:         "Send",
+				Msg:            &event.Message,
+				IdempotencyKey: "idempotency-key",
+			},
+		}
+		assert.DeepEqual(t, expectedCalls, agent.Calls)
+	})
+
+	t.Run("PassesTagFilter", func(t *testing.T) {
+		handler, agent, _, ctx := setupTestCliHandler()
+		tagEvent := *event
+		tagEvent.TagFilter = "releases or essays"
+		agent.SendResponse = func(_ *email.Message, _ []string) (int, error) {
+			return 3, nil
+		}
+
+		res := handler.HandleSendEvent(ctx, &tagEvent)
+
+		expectedResult := &events.SendResponse{Success: true, NumSent: 3}
+		assert.DeepEqual(t, expectedResult, res)
+		expectedCalls := []testAgentCalls{
+			{
+				Method:    "Send",
+				Msg:       &event.Message,
+				TagFilter: "releases or essays",
+			},
+		}
+		assert.DeepEqual(t, expectedCalls, agent.Calls)
+	})
+
 	t.Run("FailsIfSendRaisesError", func(t *testing.T) {
 		handler, agent, logs, ctx := setupTestCliHandler()
 		sendTargetedErr := errors.New("simulated SendTargeted error")
@@ -95,6 +151,128 @@ func TestCliHandlerHandleSendEvent(t *testing.T) {
 		assert.DeepEqual(t, expectedResult, res)
 		logs.AssertContains(t, expectedLogMsg(&event.Message, expectedResult))
 	})
+
+	t.Run("ResumesSendIfCampaignIdPresent", func(t *testing.T) {
+		handler, agent, _, ctx := setupTestCliHandler()
+		resumeEvent := *event
+		resumeEvent.ResumeCampaignId = "campaign-id"
+		agent.SendResponse = func(_ *email.Message, _ []string) (int, error) {
+			return 27, nil
+		}
+
+		res := handler.HandleSendEvent(ctx, &resumeEvent)
+
+		expectedResult := &events.SendResponse{Success: true, NumSent: 27}
+		assert.DeepEqual(t, expectedResult, res)
+		expectedCalls := []testAgentCalls{
+			{
+				Method:     "ResumeSend",
+				Msg:        &event.Message,
+				CampaignId: "campaign-id",
+			},
+		}
+		assert.DeepEqual(t, expectedCalls, agent.Calls)
+	})
+
+	t.Run("ReportsCampaignIdIfSendIsIncomplete", func(t *testing.T) {
+		handler, ta, _, ctx := setupTestCliHandler()
+		sendErr := &agent.IncompleteSendError{
+			CampaignId: "campaign-id", Err: errors.New("simulated error"),
+		}
+		ta.SendResponse = func(_ *email.Message, _ []string) (int, error) {
+			return 1, sendErr
+		}
+
+		res := handler.HandleSendEvent(ctx, event)
+
+		expectedResult := &events.SendResponse{
+			Success:    false,
+			Details:    "simulated error",
+			NumSent:    1,
+			CampaignId: "campaign-id",
+		}
+		assert.DeepEqual(t, expectedResult, res)
+	})
+}
+
+func TestCliHandlerScheduleSend(t *testing.T) {
+	sendAt := time.Date(2023, time.September, 26, 9, 0, 0, 0, time.UTC)
+	event := &events.SendEvent{
+		SendAt: sendAt, Message: *email.ExampleMessage,
+	}
+
+	t.Run("Succeeds", func(t *testing.T) {
+		handler, agent, logs, ctx := setupTestCliHandler()
+		agent.ScheduledId = "scheduled-id"
+
+		res := handler.HandleSendEvent(ctx, event)
+
+		expected := &events.SendResponse{
+			Success: true, ScheduledId: "scheduled-id",
+		}
+		assert.DeepEqual(t, expected, res)
+		expectedCalls := []testAgentCalls{
+			{Method: "Schedule", Msg: &event.Message, SendAt: sendAt},
+		}
+		assert.DeepEqual(t, expectedCalls, agent.Calls)
+		logs.AssertContains(
+			t,
+			"schedule: subject: \""+event.Message.Subject+"\"; "+
+				"send at: 2023-09-26T09:00:00Z; success: true",
+		)
+	})
+
+	t.Run("FailsIfScheduleFails", func(t *testing.T) {
+		handler, agent, _, ctx := setupTestCliHandler()
+		agent.Error = errors.New("Schedule failed")
+
+		res := handler.HandleSendEvent(ctx, event)
+
+		expected := &events.SendResponse{Details: "Schedule failed"}
+		assert.DeepEqual(t, expected, res)
+	})
+
+	t.Run("FailsIfAddressesSpecified", func(t *testing.T) {
+		handler, agent, _, ctx := setupTestCliHandler()
+		targetedEvent := *event
+		targetedEvent.Addresses = []string{"test@foo.com"}
+
+		res := handler.HandleSendEvent(ctx, &targetedEvent)
+
+		expected := &events.SendResponse{
+			Details: "can only schedule new sends to the entire list",
+		}
+		assert.DeepEqual(t, expected, res)
+		assert.Equal(t, 0, len(agent.Calls))
+	})
+
+	t.Run("FailsIfIdempotencyKeySpecified", func(t *testing.T) {
+		handler, agent, _, ctx := setupTestCliHandler()
+		keyEvent := *event
+		keyEvent.IdempotencyKey = "idempotency-key"
+
+		res := handler.HandleSendEvent(ctx, &keyEvent)
+
+		expected := &events.SendResponse{
+			Details: "can't schedule a send with an idempotency key",
+		}
+		assert.DeepEqual(t, expected, res)
+		assert.Equal(t, 0, len(agent.Calls))
+	})
+
+	t.Run("FailsIfTagFilterSpecified", func(t *testing.T) {
+		handler, agent, _, ctx := setupTestCliHandler()
+		tagEvent := *event
+		tagEvent.TagFilter = "releases"
+
+		res := handler.HandleSendEvent(ctx, &tagEvent)
+
+		expected := &events.SendResponse{
+			Details: "can't schedule a send with a tag filter",
+		}
+		assert.DeepEqual(t, expected, res)
+		assert.Equal(t, 0, len(agent.Calls))
+	})
 }
 
 func TestCliHandlerHandleImportEvent(t *testing.T) {
@@ -117,6 +295,20 @@ func TestCliHandlerHandleImportEvent(t *testing.T) {
 			strings.Join(event.Addresses, ", ")))
 	})
 
+	t.Run("PassesProfiles", func(t *testing.T) {
+		handler, ta, _, ctx := setupTestCliHandler()
+		profile := &agent.Profile{FirstName: "Foo"}
+		profileEvent := &events.ImportEvent{
+			Addresses: event.Addresses,
+			Profiles:  map[string]*agent.Profile{"foo@test.com": profile},
+		}
+
+		res := handler.HandleImportEvent(ctx, profileEvent)
+
+		assert.Equal(t, len(event.Addresses), res.NumImported)
+		assert.DeepEqual(t, profileEvent.Profiles, ta.ImportedProfiles)
+	})
+
 	t.Run("EmptyEventDoesNothing", func(t *testing.T) {
 		handler, _, logs, ctx := setupTestCliHandler()
 		emptyEvent := &events.ImportEvent{}
@@ -155,6 +347,563 @@ func TestCliHandlerHandleImportEvent(t *testing.T) {
 	})
 }
 
+func TestCliHandlerHandleCampaignsEvent(t *testing.T) {
+	campaigns := []*db.Campaign{
+		{Id: "campaign-1", Subject: "Second post"},
+		{Id: "campaign-0", Subject: "First post"},
+	}
+
+	t.Run("ListsAllCampaigns", func(t *testing.T) {
+		handler, agent, _, ctx := setupTestCliHandler()
+		agent.Campaigns = campaigns
+
+		res := handler.HandleCampaignsEvent(ctx, &events.CampaignsEvent{})
+
+		expected := &events.CampaignsResponse{
+			Success: true, Campaigns: campaigns,
+		}
+		assert.DeepEqual(t, expected, res)
+		expectedCalls := []testAgentCalls{{Method: "ListCampaigns"}}
+		assert.DeepEqual(t, expectedCalls, agent.Calls)
+	})
+
+	t.Run("GetsOneCampaign", func(t *testing.T) {
+		handler, agent, _, ctx := setupTestCliHandler()
+		agent.Campaigns = campaigns[1:]
+		event := &events.CampaignsEvent{CampaignId: "campaign-0"}
+
+		res := handler.HandleCampaignsEvent(ctx, event)
+
+		expected := &events.CampaignsResponse{
+			Success: true, Campaigns: campaigns[1:],
+		}
+		assert.DeepEqual(t, expected, res)
+		expectedCalls := []testAgentCalls{
+			{Method: "GetCampaign", CampaignId: "campaign-0"},
+		}
+		assert.DeepEqual(t, expectedCalls, agent.Calls)
+	})
+
+	t.Run("ReportsFailure", func(t *testing.T) {
+		handler, agent, logs, ctx := setupTestCliHandler()
+		agent.Error = db.ErrCampaignNotFound
+		event := &events.CampaignsEvent{CampaignId: "campaign-0"}
+
+		res := handler.HandleCampaignsEvent(ctx, event)
+
+		expected := &events.CampaignsResponse{
+			Success: false, Details: db.ErrCampaignNotFound.Error(),
+		}
+		assert.DeepEqual(t, expected, res)
+		logs.AssertContains(t, "failed to get campaigns: campaign not found")
+	})
+}
+
+func TestCliHandlerHandleHistoryEvent(t *testing.T) {
+	auditEvents := []*db.AuditEvent{
+		{Email: "foo@test.com", Operation: db.AuditSubscribe},
+		{Email: "foo@test.com", Operation: db.AuditVerify},
+	}
+
+	t.Run("Succeeds", func(t *testing.T) {
+		handler, agent, _, ctx := setupTestCliHandler()
+		agent.AuditEvents = auditEvents
+		event := &events.HistoryEvent{Email: "foo@test.com"}
+
+		res := handler.HandleHistoryEvent(ctx, event)
+
+		expected := &events.HistoryResponse{
+			Success: true, Events: auditEvents,
+		}
+		assert.DeepEqual(t, expected, res)
+		expectedCalls := []testAgentCalls{
+			{Method: "History", Email: "foo@test.com"},
+		}
+		assert.DeepEqual(t, expectedCalls, agent.Calls)
+	})
+
+	t.Run("ReportsFailure", func(t *testing.T) {
+		handler, agent, logs, ctx := setupTestCliHandler()
+		agent.Error = errors.New("test error")
+		event := &events.HistoryEvent{Email: "foo@test.com"}
+
+		res := handler.HandleHistoryEvent(ctx, event)
+
+		expected := &events.HistoryResponse{
+			Success: false, Details: "test error",
+		}
+		assert.DeepEqual(t, expected, res)
+		logs.AssertContains(
+			t, "failed to get history for foo@test.com: test error",
+		)
+	})
+
+	t.Run("FailsForUnknownList", func(t *testing.T) {
+		handler, _, _, ctx := setupTestCliHandler()
+		event := &events.HistoryEvent{List: "updates", Email: "foo@test.com"}
+
+		res := handler.HandleHistoryEvent(ctx, event)
+
+		assert.Assert(t, !res.Success)
+		assert.Equal(t, "unknown list: updates", res.Details)
+	})
+}
+
+func TestCliHandlerHandleExportSubscriberEvent(t *testing.T) {
+	data := &agent.SubscriberData{
+		Email:      "foo@test.com",
+		Suppressed: true,
+		History:    []*db.AuditEvent{},
+	}
+	newData := func(data ...*agent.SubscriberData) []*agent.SubscriberData {
+		return data
+	}
+
+	t.Run("Succeeds", func(t *testing.T) {
+		handler, agent, _, ctx := setupTestCliHandler()
+		agent.SubscriberData = data
+		event := &events.ExportSubscriberEvent{Email: "foo@test.com"}
+
+		res := handler.HandleExportSubscriberEvent(ctx, event)
+
+		expected := &events.ExportSubscriberResponse{
+			Success: true, Data: newData(data),
+		}
+		assert.DeepEqual(t, expected, res)
+		expectedCalls := []testAgentCalls{
+			{Method: "ExportSubscriber", Email: "foo@test.com"},
+		}
+		assert.DeepEqual(t, expectedCalls, agent.Calls)
+	})
+
+	t.Run("ReportsFailure", func(t *testing.T) {
+		handler, agent, logs, ctx := setupTestCliHandler()
+		agent.Error = errors.New("test error")
+		event := &events.ExportSubscriberEvent{Email: "foo@test.com"}
+
+		res := handler.HandleExportSubscriberEvent(ctx, event)
+
+		expected := &events.ExportSubscriberResponse{
+			Success: false, Details: "test error",
+		}
+		assert.DeepEqual(t, expected, res)
+		logs.AssertContains(
+			t, "failed to export data for foo@test.com: test error",
+		)
+	})
+
+	t.Run("ExportsEveryList", func(t *testing.T) {
+		handler, defaultAgent, _, ctx := setupTestCliHandler()
+		defaultAgent.SubscriberData = data
+		updatesData := &agent.SubscriberData{
+			List: "updates", Email: "foo@test.com",
+		}
+		updatesAgent := &testAgent{SubscriberData: updatesData}
+		newsAgent := &testAgent{}
+		handler.Lists = listAgents{"updates": updatesAgent, "news": newsAgent}
+		event := &events.ExportSubscriberEvent{Email: "foo@test.com"}
+
+		res := handler.HandleExportSubscriberEvent(ctx, event)
+
+		expected := &events.ExportSubscriberResponse{
+			Success: true,
+			Data:    newData(data, nil, updatesData),
+		}
+		assert.DeepEqual(t, expected, res)
+		expectedCalls := []testAgentCalls{
+			{Method: "ExportSubscriber", Email: "foo@test.com"},
+		}
+		assert.DeepEqual(t, expectedCalls, newsAgent.Calls)
+		assert.DeepEqual(t, expectedCalls, updatesAgent.Calls)
+	})
+
+	t.Run("ReportsListThatFailed", func(t *testing.T) {
+		handler, _, _, ctx := setupTestCliHandler()
+		updatesAgent := &testAgent{Error: errors.New("test error")}
+		handler.Lists = listAgents{"updates": updatesAgent}
+		event := &events.ExportSubscriberEvent{Email: "foo@test.com"}
+
+		res := handler.HandleExportSubscriberEvent(ctx, event)
+
+		expected := &events.ExportSubscriberResponse{
+			Success: false, Details: "list updates: test error",
+		}
+		assert.DeepEqual(t, expected, res)
+	})
+}
+
+func TestCliHandlerHandleEraseEvent(t *testing.T) {
+	t.Run("Succeeds", func(t *testing.T) {
+		handler, agent, _, ctx := setupTestCliHandler()
+		event := &events.EraseEvent{Email: "foo@test.com"}
+
+		res := handler.HandleEraseEvent(ctx, event)
+
+		expected := &events.EraseResponse{Success: true, Lists: []string{""}}
+		assert.DeepEqual(t, expected, res)
+		expectedCalls := []testAgentCalls{
+			{Method: "Erase", Email: "foo@test.com"},
+		}
+		assert.DeepEqual(t, expectedCalls, agent.Calls)
+	})
+
+	t.Run("ReportsFailure", func(t *testing.T) {
+		handler, agent, logs, ctx := setupTestCliHandler()
+		agent.Error = errors.New("test error")
+		event := &events.EraseEvent{Email: "foo@test.com"}
+
+		res := handler.HandleEraseEvent(ctx, event)
+
+		expected := &events.EraseResponse{
+			Success: false, Details: "test error", Lists: []string{},
+		}
+		assert.DeepEqual(t, expected, res)
+		logs.AssertContains(t, "failed to erase foo@test.com: test error")
+	})
+
+	t.Run("ErasesEveryList", func(t *testing.T) {
+		handler, defaultAgent, _, ctx := setupTestCliHandler()
+		updatesAgent := &testAgent{}
+		newsAgent := &testAgent{}
+		handler.Lists = listAgents{"updates": updatesAgent, "news": newsAgent}
+		event := &events.EraseEvent{Email: "foo@test.com"}
+
+		res := handler.HandleEraseEvent(ctx, event)
+
+		expected := &events.EraseResponse{
+			Success: true, Lists: []string{"", "news", "updates"},
+		}
+		assert.DeepEqual(t, expected, res)
+		expectedCalls := []testAgentCalls{
+			{Method: "Erase", Email: "foo@test.com"},
+		}
+		assert.DeepEqual(t, expectedCalls, defaultAgent.Calls)
+		assert.DeepEqual(t, expectedCalls, newsAgent.Calls)
+		assert.DeepEqual(t, expectedCalls, updatesAgent.Calls)
+	})
+
+	t.Run("ReportsListsErasedBeforeFailure", func(t *testing.T) {
+		handler, _, logs, ctx := setupTestCliHandler()
+		newsAgent := &testAgent{Error: errors.New("test error")}
+		updatesAgent := &testAgent{}
+		handler.Lists = listAgents{"updates": updatesAgent, "news": newsAgent}
+		event := &events.EraseEvent{Email: "foo@test.com"}
+
+		res := handler.HandleEraseEvent(ctx, event)
+
+		expected := &events.EraseResponse{
+			Success: false,
+			Details: "list news: test error",
+			Lists:   []string{""},
+		}
+		assert.DeepEqual(t, expected, res)
+		assert.Equal(t, 0, len(updatesAgent.Calls))
+		logs.AssertContains(
+			t, "failed to erase foo@test.com: list news: test error",
+		)
+	})
+}
+
+func TestCliHandlerHandleRotateUidsEvent(t *testing.T) {
+	startKey := &db.ScanKey{Email: "bar@test.com"}
+	nextKey := &db.ScanKey{Email: "foo@test.com"}
+
+	t.Run("Succeeds", func(t *testing.T) {
+		handler, agent, _, ctx := setupTestCliHandler()
+		agent.NumSent = 2
+		agent.NextKey = nextKey
+		event := &events.RotateUidsEvent{
+			GracePeriod: time.Hour, BatchSize: 2, StartKey: startKey,
+		}
+
+		res := handler.HandleRotateUidsEvent(ctx, event)
+
+		expected := &events.RotateUidsResponse{
+			Success: true, NumRotated: 2, NextKey: nextKey,
+		}
+		assert.DeepEqual(t, expected, res)
+		expectedCalls := []testAgentCalls{
+			{
+				Method:      "RotateUids",
+				GracePeriod: time.Hour,
+				StartKey:    startKey,
+				BatchSize:   2,
+			},
+		}
+		assert.DeepEqual(t, expectedCalls, agent.Calls)
+	})
+
+	t.Run("ReportsFailure", func(t *testing.T) {
+		handler, agent, logs, ctx := setupTestCliHandler()
+		agent.NumSent = 1
+		agent.NextKey = nextKey
+		agent.Error = errors.New("test error")
+		event := &events.RotateUidsEvent{GracePeriod: time.Hour, BatchSize: 2}
+
+		res := handler.HandleRotateUidsEvent(ctx, event)
+
+		expected := &events.RotateUidsResponse{
+			Success:    false,
+			Details:    "test error",
+			NumRotated: 1,
+			NextKey:    nextKey,
+		}
+		assert.DeepEqual(t, expected, res)
+		logs.AssertContains(t, "failed to rotate UIDs: test error")
+	})
+
+	t.Run("FailsForUnknownList", func(t *testing.T) {
+		handler, _, _, ctx := setupTestCliHandler()
+		event := &events.RotateUidsEvent{List: "updates", BatchSize: 2}
+
+		res := handler.HandleRotateUidsEvent(ctx, event)
+
+		assert.Assert(t, !res.Success)
+		assert.Equal(t, "unknown list: updates", res.Details)
+	})
+}
+
+func TestCliHandlerHandleSubscribersEvent(t *testing.T) {
+	subs := []*db.Subscriber{
+		{Email: "bar@test.com", Status: db.SubscriberVerified},
+		{Email: "foo@test.com", Status: db.SubscriberVerified},
+	}
+	startKey := &db.ScanKey{Email: "baz@test.com"}
+	nextKey := &db.ScanKey{Email: "foo@test.com"}
+	since := time.Date(2023, time.September, 25, 0, 0, 0, 0, time.UTC)
+
+	t.Run("ListsSubscribers", func(t *testing.T) {
+		handler, agent, _, ctx := setupTestCliHandler()
+		agent.Subscribers = subs
+		agent.NextKey = nextKey
+		event := &events.SubscribersEvent{
+			Status:    db.SubscriberVerified,
+			Since:     since,
+			Domain:    "test.com",
+			BatchSize: 2,
+			StartKey:  startKey,
+		}
+
+		res := handler.HandleSubscribersEvent(ctx, event)
+
+		expected := &events.SubscribersResponse{
+			Success: true, Subscribers: subs, NextKey: nextKey,
+		}
+		assert.DeepEqual(t, expected, res)
+		expectedCalls := []testAgentCalls{
+			{
+				Method:    "ListSubscribers",
+				Status:    db.SubscriberVerified,
+				Since:     since,
+				Domain:    "test.com",
+				StartKey:  startKey,
+				BatchSize: 2,
+			},
+		}
+		assert.DeepEqual(t, expectedCalls, agent.Calls)
+	})
+
+	t.Run("TrimsResponseToSizeLimit", func(t *testing.T) {
+		handler, agent, _, ctx := setupTestCliHandler()
+		bigName := strings.Repeat("x", maxSubscribersResponseSize/2)
+		agent.Subscribers = []*db.Subscriber{
+			{Email: "bar@test.com", FirstName: bigName},
+			{Email: "baz@test.com", FirstName: bigName},
+			{Email: "foo@test.com"},
+		}
+		event := &events.SubscribersEvent{
+			Status: db.SubscriberVerified, BatchSize: 3,
+		}
+
+		res := handler.HandleSubscribersEvent(ctx, event)
+
+		expected := &events.SubscribersResponse{
+			Success:     true,
+			Subscribers: agent.Subscribers[:1],
+			NextKey:     agent.Subscribers[0].ScanKey(),
+		}
+		assert.DeepEqual(t, expected, res)
+	})
+
+	t.Run("GetsOneSubscriber", func(t *testing.T) {
+		handler, agent, _, ctx := setupTestCliHandler()
+		agent.Subscribers = subs[1:]
+		event := &events.SubscribersEvent{Email: "foo@test.com"}
+
+		res := handler.HandleSubscribersEvent(ctx, event)
+
+		expected := &events.SubscribersResponse{
+			Success: true, Subscribers: subs[1:],
+		}
+		assert.DeepEqual(t, expected, res)
+		expectedCalls := []testAgentCalls{
+			{Method: "GetSubscriber", Email: "foo@test.com"},
+		}
+		assert.DeepEqual(t, expectedCalls, agent.Calls)
+	})
+
+	t.Run("ReturnsNoSubscribersIfNotFound", func(t *testing.T) {
+		handler, _, _, ctx := setupTestCliHandler()
+		event := &events.SubscribersEvent{Email: "foo@test.com"}
+
+		res := handler.HandleSubscribersEvent(ctx, event)
+
+		expected := &events.SubscribersResponse{
+			Success: true, Subscribers: []*db.Subscriber{},
+		}
+		assert.DeepEqual(t, expected, res)
+	})
+
+	t.Run("ReportsFailure", func(t *testing.T) {
+		handler, agent, logs, ctx := setupTestCliHandler()
+		agent.NextKey = nextKey
+		agent.Error = errors.New("test error")
+		event := &events.SubscribersEvent{
+			Status: db.SubscriberPending, BatchSize: 2,
+		}
+
+		res := handler.HandleSubscribersEvent(ctx, event)
+
+		expected := &events.SubscribersResponse{
+			Success: false, Details: "test error", NextKey: nextKey,
+		}
+		assert.DeepEqual(t, expected, res)
+		logs.AssertContains(t, "failed to get subscribers: test error")
+	})
+
+	t.Run("FailsForUnknownList", func(t *testing.T) {
+		handler, _, _, ctx := setupTestCliHandler()
+		event := &events.SubscribersEvent{
+			List: "updates", Email: "foo@test.com",
+		}
+
+		res := handler.HandleSubscribersEvent(ctx, event)
+
+		assert.Assert(t, !res.Success)
+		assert.Equal(t, "unknown list: updates", res.Details)
+	})
+}
+
+func TestTrimSubscribersResponse(t *testing.T) {
+	subs := []*db.Subscriber{
+		{Email: "bar@test.com", Status: db.SubscriberVerified},
+		{Email: "baz@test.com", Status: db.SubscriberVerified},
+		{Email: "foo@test.com", Status: db.SubscriberVerified},
+	}
+	nextKey := &db.ScanKey{Email: "quux@test.com"}
+	sizeOf := func(t *testing.T, subs []*db.Subscriber) int {
+		t.Helper()
+		data, err := json.Marshal(subs)
+		assert.NilError(t, err)
+		return len(data)
+	}
+
+	t.Run("KeepsEverythingIfWithinLimit", func(t *testing.T) {
+		res := &events.SubscribersResponse{Subscribers: subs, NextKey: nextKey}
+
+		err := trimSubscribersResponse(res, sizeOf(t, subs)+1)
+
+		assert.NilError(t, err)
+		expected := &events.SubscribersResponse{
+			Subscribers: subs, NextKey: nextKey,
+		}
+		assert.DeepEqual(t, expected, res)
+	})
+
+	t.Run("DropsSubscribersBeyondLimit", func(t *testing.T) {
+		res := &events.SubscribersResponse{Subscribers: subs, NextKey: nextKey}
+
+		err := trimSubscribersResponse(res, sizeOf(t, subs[:2])+1)
+
+		assert.NilError(t, err)
+		expected := &events.SubscribersResponse{
+			Subscribers: subs[:2], NextKey: subs[1].ScanKey(),
+		}
+		assert.DeepEqual(t, expected, res)
+	})
+
+	t.Run("AlwaysKeepsFirstSubscriber", func(t *testing.T) {
+		res := &events.SubscribersResponse{Subscribers: subs}
+
+		err := trimSubscribersResponse(res, 1)
+
+		assert.NilError(t, err)
+		expected := &events.SubscribersResponse{
+			Subscribers: subs[:1], NextKey: subs[0].ScanKey(),
+		}
+		assert.DeepEqual(t, expected, res)
+	})
+}
+
+func TestCliHandlerHandleWelcomeEvent(t *testing.T) {
+	t.Run("GetsWelcomeMessage", func(t *testing.T) {
+		handler, agent, _, ctx := setupTestCliHandler()
+		agent.WelcomeMessage = email.ExampleMessage
+
+		res := handler.HandleWelcomeEvent(ctx, &events.WelcomeEvent{})
+
+		expected := &events.WelcomeResponse{
+			Success: true, Message: email.ExampleMessage,
+		}
+		assert.DeepEqual(t, expected, res)
+		expectedCalls := []testAgentCalls{{Method: "GetWelcomeMessage"}}
+		assert.DeepEqual(t, expectedCalls, agent.Calls)
+	})
+
+	t.Run("SetsWelcomeMessage", func(t *testing.T) {
+		handler, agent, logs, ctx := setupTestCliHandler()
+		event := &events.WelcomeEvent{
+			Set: true, Message: email.ExampleMessage,
+		}
+
+		res := handler.HandleWelcomeEvent(ctx, event)
+
+		assert.DeepEqual(t, &events.WelcomeResponse{Success: true}, res)
+		expectedCalls := []testAgentCalls{
+			{Method: "SetWelcomeMessage", Msg: email.ExampleMessage},
+		}
+		assert.DeepEqual(t, expectedCalls, agent.Calls)
+		logs.AssertContains(t, "welcome message updated: deleted: false")
+	})
+
+	t.Run("DeletesWelcomeMessage", func(t *testing.T) {
+		handler, agent, logs, ctx := setupTestCliHandler()
+		event := &events.WelcomeEvent{Set: true}
+
+		res := handler.HandleWelcomeEvent(ctx, event)
+
+		assert.DeepEqual(t, &events.WelcomeResponse{Success: true}, res)
+		expectedCalls := []testAgentCalls{{Method: "SetWelcomeMessage"}}
+		assert.DeepEqual(t, expectedCalls, agent.Calls)
+		logs.AssertContains(t, "welcome message updated: deleted: true")
+	})
+
+	t.Run("ReportsFailure", func(t *testing.T) {
+		handler, agent, logs, ctx := setupTestCliHandler()
+		agent.Error = errors.New("test error")
+
+		res := handler.HandleWelcomeEvent(ctx, &events.WelcomeEvent{})
+
+		expected := &events.WelcomeResponse{
+			Success: false, Details: "test error",
+		}
+		assert.DeepEqual(t, expected, res)
+		logs.AssertContains(
+			t, "failed to handle welcome message: test error",
+		)
+	})
+
+	t.Run("FailsForUnknownList", func(t *testing.T) {
+		handler, _, _, ctx := setupTestCliHandler()
+		event := &events.WelcomeEvent{List: "updates"}
+
+		res := handler.HandleWelcomeEvent(ctx, event)
+
+		assert.Assert(t, !res.Success)
+		assert.Equal(t, "unknown list: updates", res.Details)
+	})
+}
+
 func TestCliHandlerHandleEvent(t *testing.T) {
 	t.Run("SuccessfullyHandlesSendEvent", func(t *testing.T) {
 		handler, agent, _, ctx := setupTestCliHandler()
@@ -194,6 +943,155 @@ func TestCliHandlerHandleEvent(t *testing.T) {
 		assert.DeepEqual(t, expectedResponse, res)
 	})
 
+	t.Run("SuccessfullyHandlesCampaignsEvent", func(t *testing.T) {
+		handler, agent, _, ctx := setupTestCliHandler()
+		agent.Campaigns = []*db.Campaign{{Id: "campaign-0"}}
+		event := &events.CommandLineEvent{
+			EListManCommand: events.CommandLineCampaignsEvent,
+			Campaigns:       &events.CampaignsEvent{},
+		}
+
+		res, err := handler.HandleEvent(ctx, event)
+
+		assert.NilError(t, err)
+		expectedResponse := &events.CampaignsResponse{
+			Success: true, Campaigns: agent.Campaigns,
+		}
+		assert.DeepEqual(t, expectedResponse, res)
+	})
+
+	t.Run("SuccessfullyHandlesHistoryEvent", func(t *testing.T) {
+		handler, agent, _, ctx := setupTestCliHandler()
+		agent.AuditEvents = []*db.AuditEvent{{Email: "foo@test.com"}}
+		event := &events.CommandLineEvent{
+			EListManCommand: events.CommandLineHistoryEvent,
+			History:         &events.HistoryEvent{Email: "foo@test.com"},
+		}
+
+		res, err := handler.HandleEvent(ctx, event)
+
+		assert.NilError(t, err)
+		expectedResponse := &events.HistoryResponse{
+			Success: true, Events: agent.AuditEvents,
+		}
+		assert.DeepEqual(t, expectedResponse, res)
+	})
+
+	t.Run("SuccessfullyHandlesExportSubscriberEvent", func(t *testing.T) {
+		handler, ta, _, ctx := setupTestCliHandler()
+		event := &events.CommandLineEvent{
+			EListManCommand: events.CommandLineExportSubscriberEvent,
+			ExportSubscriber: &events.ExportSubscriberEvent{
+				Email: "foo@test.com",
+			},
+		}
+
+		res, err := handler.HandleEvent(ctx, event)
+
+		assert.NilError(t, err)
+		expectedResponse := &events.ExportSubscriberResponse{
+			Success: true, Data: []*agent.SubscriberData{nil},
+		}
+		assert.DeepEqual(t, expectedResponse, res)
+		expectedCalls := []testAgentCalls{
+			{Method: "ExportSubscriber", Email: "foo@test.com"},
+		}
+		assert.DeepEqual(t, expectedCalls, ta.Calls)
+	})
+
+	t.Run("SuccessfullyHandlesEraseEvent", func(t *testing.T) {
+		handler, agent, _, ctx := setupTestCliHandler()
+		event := &events.CommandLineEvent{
+			EListManCommand: events.CommandLineEraseEvent,
+			Erase:           &events.EraseEvent{Email: "foo@test.com"},
+		}
+
+		res, err := handler.HandleEvent(ctx, event)
+
+		assert.NilError(t, err)
+		expectedResponse := &events.EraseResponse{
+			Success: true, Lists: []string{""},
+		}
+		assert.DeepEqual(t, expectedResponse, res)
+		expectedCalls := []testAgentCalls{
+			{Method: "Erase", Email: "foo@test.com"},
+		}
+		assert.DeepEqual(t, expectedCalls, agent.Calls)
+	})
+
+	t.Run("SuccessfullyHandlesRotateUidsEvent", func(t *testing.T) {
+		handler, agent, _, ctx := setupTestCliHandler()
+		agent.NumSent = 3
+		event := &events.CommandLineEvent{
+			EListManCommand: events.CommandLineRotateUidsEvent,
+			RotateUids: &events.RotateUidsEvent{
+				GracePeriod: time.Hour, BatchSize: 10,
+			},
+		}
+
+		res, err := handler.HandleEvent(ctx, event)
+
+		assert.NilError(t, err)
+		expectedResponse := &events.RotateUidsResponse{
+			Success: true, NumRotated: 3,
+		}
+		assert.DeepEqual(t, expectedResponse, res)
+	})
+
+	t.Run("SuccessfullyHandlesSubscribersEvent", func(t *testing.T) {
+		handler, agent, _, ctx := setupTestCliHandler()
+		sub := &db.Subscriber{Email: "foo@test.com"}
+		agent.Subscribers = []*db.Subscriber{sub}
+		event := &events.CommandLineEvent{
+			EListManCommand: events.CommandLineSubscribersEvent,
+			Subscribers:     &events.SubscribersEvent{Email: "foo@test.com"},
+		}
+
+		res, err := handler.HandleEvent(ctx, event)
+
+		assert.NilError(t, err)
+		expectedResponse := &events.SubscribersResponse{
+			Success: true, Subscribers: []*db.Subscriber{sub},
+		}
+		assert.DeepEqual(t, expectedResponse, res)
+	})
+
+	t.Run("SuccessfullyHandlesWelcomeEvent", func(t *testing.T) {
+		handler, agent, _, ctx := setupTestCliHandler()
+		agent.WelcomeMessage = email.ExampleMessage
+		event := &events.CommandLineEvent{
+			EListManCommand: events.CommandLineWelcomeEvent,
+			Welcome:         &events.WelcomeEvent{},
+		}
+
+		res, err := handler.HandleEvent(ctx, event)
+
+		assert.NilError(t, err)
+		expectedResponse := &events.WelcomeResponse{
+			Success: true, Message: email.ExampleMessage,
+		}
+		assert.DeepEqual(t, expectedResponse, res)
+	})
+
+	t.Run("AddsAuditInfoWithLambdaRequestId", func(t *testing.T) {
+		handler, agent, _, ctx := setupTestCliHandler()
+		ctx = lambdacontext.NewContext(
+			ctx, &lambdacontext.LambdaContext{AwsRequestID: "deadbeef"},
+		)
+		event := &events.CommandLineEvent{
+			EListManCommand: events.CommandLineImportEvent,
+			Import: &events.ImportEvent{
+				Addresses: []string{"foo@test.com"},
+			},
+		}
+
+		_, err := handler.HandleEvent(ctx, event)
+
+		assert.NilError(t, err)
+		assert.Equal(t, db.AuditSourceCli, agent.AuditSource)
+		assert.Equal(t, "deadbeef", agent.RequestId)
+	})
+
 	t.Run("FailsOnUnknownEvent", func(t *testing.T) {
 		handler, _, _, ctx := setupTestCliHandler()
 		event := &events.CommandLineEvent{
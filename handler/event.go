@@ -17,6 +17,7 @@ const (
 	MailtoEvent
 	SnsEvent
 	CommandLineEvent
+	ScheduledEvent
 )
 
 type Event struct {
@@ -25,6 +26,7 @@ type Event struct {
 	MailtoEvent      *awsevents.SimpleEmailEvent
 	SnsEvent         *awsevents.SNSEvent
 	CommandLineEvent *events.CommandLineEvent
+	ScheduledEvent   *awsevents.EventBridgeEvent
 	Unknown          []byte
 }
 
@@ -32,6 +34,7 @@ type Event struct {
 // - https://www.synvert-tcm.com/blog/handling-multiple-aws-lambda-event-types-with-go/
 // See also:
 // - https://docs.aws.amazon.com/ses/latest/dg/receiving-email-action-lambda-event.html
+// - https://docs.aws.amazon.com/lambda/latest/dg/with-eventbridge-scheduler.html
 func (event *Event) UnmarshalJSON(data []byte) error {
 	if bytes.Equal(data, []byte("null")) {
 		return nil
@@ -51,6 +54,10 @@ func (event *Event) UnmarshalJSON(data []byte) error {
 		event.Type = CommandLineEvent
 		event.CommandLineEvent = &events.CommandLineEvent{}
 		return json.Unmarshal(data, event.CommandLineEvent)
+	} else if bytes.Contains(data, []byte(`"detail-type":`)) {
+		event.Type = ScheduledEvent
+		event.ScheduledEvent = &awsevents.EventBridgeEvent{}
+		return json.Unmarshal(data, event.ScheduledEvent)
 	}
 	event.Unknown = data
 	return nil
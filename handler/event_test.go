@@ -3,7 +3,9 @@
 package handler
 
 import (
+	"encoding/json"
 	"testing"
+	"time"
 
 	awsevents "github.com/aws/aws-lambda-go/events"
 	"github.com/mbland/elistman/email"
@@ -158,3 +160,45 @@ func TestCommandLineEvent(t *testing.T) {
 		},
 	})
 }
+
+// Adapted from:
+// https://docs.aws.amazon.com/eventbridge/latest/userguide/eb-run-lambda-schedule.html
+const scheduledEventJson = `{
+	"version": "0",
+	"id": "53dc4d37-cffa-4f76-80c9-8b7d4a4d2eaa",
+	"detail-type": "Scheduled Event",
+	"source": "aws.events",
+	"account": "123456789012",
+	"time": "2023-09-26T13:00:00Z",
+	"region": "us-east-1",
+	"resources": [
+		"arn:aws:events:us-east-1:123456789012:rule/elistman-scheduled-sends"
+	],
+	"detail": {}
+}`
+
+func TestScheduledEvent(t *testing.T) {
+	e := Event{}
+	eventTime := time.Date(2023, time.September, 26, 13, 0, 0, 0, time.UTC)
+
+	err := e.UnmarshalJSON([]byte(scheduledEventJson))
+
+	assert.NilError(t, err)
+	assert.DeepEqual(t, e, Event{
+		Type: ScheduledEvent,
+		ScheduledEvent: &awsevents.EventBridgeEvent{
+			Version:    "0",
+			ID:         "53dc4d37-cffa-4f76-80c9-8b7d4a4d2eaa",
+			DetailType: ScheduledEventDetailType,
+			Source:     "aws.events",
+			AccountID:  "123456789012",
+			Time:       eventTime,
+			Region:     "us-east-1",
+			Resources: []string{
+				"arn:aws:events:us-east-1:123456789012:" +
+					"rule/elistman-scheduled-sends",
+			},
+			Detail: json.RawMessage("{}"),
+		},
+	})
+}
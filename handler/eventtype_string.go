@@ -13,11 +13,12 @@ func _() {
 	_ = x[MailtoEvent-2]
 	_ = x[SnsEvent-3]
 	_ = x[CommandLineEvent-4]
+	_ = x[ScheduledEvent-5]
 }
 
-const _EventType_name = "UnknownEventApiRequestMailtoEventSnsEventCommandLineEvent"
+const _EventType_name = "UnknownEventApiRequestMailtoEventSnsEventCommandLineEventScheduledEvent"
 
-var _EventType_index = [...]uint8{0, 12, 22, 33, 41, 57}
+var _EventType_index = [...]uint8{0, 12, 22, 33, 41, 57, 71}
 
 func (i EventType) String() string {
 	if i < 0 || i >= EventType(len(_EventType_index)-1) {
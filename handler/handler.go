@@ -7,27 +7,50 @@ import (
 
 	"github.com/mbland/elistman/agent"
 	"github.com/mbland/elistman/email"
+	"github.com/mbland/elistman/ops"
 )
 
+// Handler dispatches each Event to the handler for its type.
+//
+// It serves the default list passed to NewHandler, plus any lists added via
+// AddList.
+//
+// siteUrl is the scheme and host of the site containing the redirect paths for
+// every list, e.g. "https://mike-bland.com".
 type Handler struct {
-	api    *apiHandler
-	mailto *mailtoHandler
-	sns    *snsHandler
-	cli    *cliHandler
+	siteUrl   string
+	lists     listAgents
+	api       *apiHandler
+	mailto    *mailtoHandler
+	sns       *snsHandler
+	cli       *cliHandler
+	scheduled *scheduledHandler
 }
 
 func NewHandler(
 	emailDomain string,
+	siteUrl string,
 	siteTitle string,
 	agent agent.SubscriptionAgent,
 	paths RedirectPaths,
+	topics []string,
 	responseTemplate string,
+	confirmLinks bool,
+	verifyTokenKeys ops.VerifyTokenKeys,
 	unsubscribeUserName string,
 	bouncer email.Bouncer,
 	logger *log.Logger,
 ) (*Handler, error) {
 	api, err := newApiHandler(
-		emailDomain, siteTitle, agent, paths, responseTemplate, logger,
+		siteUrl,
+		siteTitle,
+		agent,
+		paths,
+		topics,
+		responseTemplate,
+		confirmLinks,
+		verifyTokenKeys,
+		logger,
 	)
 
 	if err != nil {
@@ -35,11 +58,15 @@ func NewHandler(
 	}
 
 	unsubAddr := unsubscribeUserName + "@" + emailDomain
+	lists := listAgents{}
 	return &Handler{
+		siteUrl,
+		lists,
 		api,
-		&mailtoHandler{emailDomain, unsubAddr, agent, bouncer, logger},
-		&snsHandler{agent, logger},
-		&cliHandler{agent, logger},
+		&mailtoHandler{emailDomain, unsubAddr, agent, lists, bouncer, logger},
+		&snsHandler{agent, listAgents{}, logger},
+		&cliHandler{agent, lists, logger},
+		&scheduledHandler{agent, lists, logger},
 	}, nil
 }
 
@@ -68,6 +95,8 @@ func (h *Handler) HandleEvent(
 		h.sns.HandleEvent(ctx, event.SnsEvent)
 	case CommandLineEvent:
 		result, err = h.cli.HandleEvent(ctx, event.CommandLineEvent)
+	case ScheduledEvent:
+		h.scheduled.HandleEvent(ctx, event.ScheduledEvent)
 	case UnknownEvent:
 		// An unknown event is one that Event.UnmarshalJSON knows nothing about.
 		err = fmt.Errorf("unknown event: %s", string(event.Unknown))
@@ -13,6 +13,8 @@ import (
 
 	awsevents "github.com/aws/aws-lambda-go/events"
 	"github.com/google/uuid"
+	"github.com/mbland/elistman/agent"
+	"github.com/mbland/elistman/db"
 	"github.com/mbland/elistman/email"
 	"github.com/mbland/elistman/events"
 	"github.com/mbland/elistman/ops"
@@ -26,26 +28,66 @@ type testAgent struct {
 	Uid               uuid.UUID
 	OpResult          ops.OperationResult
 	NumSent           int
+	NumSkipped        int
 	ImportedAddresses []string
+	ImportedProfiles  map[string]*agent.Profile
 	ImportResponse    func(address string) error
 	SendResponse      func(msg *email.Message, addrs []string) (int, error)
+	Campaigns         []*db.Campaign
+	AuditEvents       []*db.AuditEvent
+	SubscriberData    *agent.SubscriberData
+	Subscribers       []*db.Subscriber
+	NextKey           *db.ScanKey
+	ScheduledId       string
+	WelcomeMessage    *email.Message
+	AuditSource       string
+	RequestId         string
 	Error             error
 	Calls             []testAgentCalls
 }
 
+// saveAuditInfo saves the values that agent.WithAuditInfo added to ctx.
+func (a *testAgent) saveAuditInfo(ctx context.Context) {
+	a.AuditSource, a.RequestId = agent.AuditInfo(ctx)
+}
+
 type testAgentCalls struct {
-	Method string
-	Email  string
-	Uid    uuid.UUID
-	Msg    *email.Message
-	Reason ops.RemoveReason
-	Addrs  []string
+	Method         string
+	Email          string
+	Uid            uuid.UUID
+	Msg            *email.Message
+	Reason         ops.RemoveReason
+	Addrs          []string
+	CampaignId     string
+	SendAt         time.Time
+	IdempotencyKey string
+	Topics         []string
+	Signup         *db.SignupMetadata
+	Profile        *agent.Profile
+	TagFilter      string
+	GracePeriod    time.Duration
+	StartKey       *db.ScanKey
+	BatchSize      int
+	Status         db.SubscriberStatus
+	Since          time.Time
+	Domain         string
 }
 
 func (a *testAgent) Subscribe(
-	ctx context.Context, email string,
+	ctx context.Context,
+	email string,
+	topics []string,
+	signup *db.SignupMetadata,
+	profile *agent.Profile,
 ) (ops.OperationResult, error) {
-	a.Calls = append(a.Calls, testAgentCalls{Method: "Subscribe", Email: email})
+	a.Calls = append(a.Calls, testAgentCalls{
+		Method:  "Subscribe",
+		Email:   email,
+		Topics:  topics,
+		Signup:  signup,
+		Profile: profile,
+	})
+	a.saveAuditInfo(ctx)
 	a.Email = email
 	return a.OpResult, a.Error
 }
@@ -56,6 +98,7 @@ func (a *testAgent) Verify(
 	a.Calls = append(a.Calls, testAgentCalls{
 		Method: "Verify", Email: email, Uid: uid,
 	})
+	a.saveAuditInfo(ctx)
 	a.Email = email
 	a.Uid = uid
 	return a.OpResult, a.Error
@@ -67,6 +110,19 @@ func (a *testAgent) Unsubscribe(
 	a.Calls = append(a.Calls, testAgentCalls{
 		Method: "Unsubscribe", Email: email, Uid: uid,
 	})
+	a.saveAuditInfo(ctx)
+	a.Email = email
+	a.Uid = uid
+	return a.OpResult, a.Error
+}
+
+func (a *testAgent) UpdateTopics(
+	ctx context.Context, email string, uid uuid.UUID, topics []string,
+) (ops.OperationResult, error) {
+	a.Calls = append(a.Calls, testAgentCalls{
+		Method: "UpdateTopics", Email: email, Uid: uid, Topics: topics,
+	})
+	a.saveAuditInfo(ctx)
 	a.Email = email
 	a.Uid = uid
 	return a.OpResult, a.Error
@@ -80,8 +136,17 @@ func (a *testAgent) Validate(
 	return nil, nil
 }
 
-func (a *testAgent) Import(_ context.Context, address string) (err error) {
+func (a *testAgent) Import(
+	ctx context.Context, address string, profile *agent.Profile,
+) (err error) {
 	a.ImportedAddresses = append(a.ImportedAddresses, address)
+	if profile != nil {
+		if a.ImportedProfiles == nil {
+			a.ImportedProfiles = map[string]*agent.Profile{}
+		}
+		a.ImportedProfiles[address] = profile
+	}
+	a.saveAuditInfo(ctx)
 	return a.ImportResponse(address)
 }
 
@@ -90,25 +155,170 @@ func (a *testAgent) Remove(
 ) error {
 	agentCall := testAgentCalls{Method: "Remove", Email: email, Reason: reason}
 	a.Calls = append(a.Calls, agentCall)
+	a.saveAuditInfo(ctx)
 	a.Email = email
 	return a.Error
 }
 
 func (a *testAgent) Restore(ctx context.Context, email string) error {
 	a.Calls = append(a.Calls, testAgentCalls{Method: "Restore", Email: email})
+	a.saveAuditInfo(ctx)
 	a.Email = email
 	return a.Error
 }
 
 func (a *testAgent) Send(
-	ctx context.Context, msg *email.Message, addrs []string,
-) (numSent int, err error) {
-	call := testAgentCalls{Method: "Send", Msg: msg, Addrs: addrs}
+	ctx context.Context,
+	msg *email.Message,
+	addrs []string,
+	idempotencyKey string,
+	tagFilter string,
+) (numSent, numSkipped int, err error) {
+	call := testAgentCalls{
+		Method:         "Send",
+		Msg:            msg,
+		Addrs:          addrs,
+		IdempotencyKey: idempotencyKey,
+		TagFilter:      tagFilter,
+	}
+	a.Calls = append(a.Calls, call)
+	numSent, err = a.SendResponse(msg, addrs)
+	return numSent, a.NumSkipped, err
+}
+
+func (a *testAgent) ResumeSend(
+	ctx context.Context, campaignId string, msg *email.Message,
+) (numSent, numSkipped int, err error) {
+	call := testAgentCalls{
+		Method: "ResumeSend", Msg: msg, CampaignId: campaignId,
+	}
 	a.Calls = append(a.Calls, call)
-	return a.SendResponse(msg, addrs)
+	numSent, err = a.SendResponse(msg, nil)
+	return numSent, a.NumSkipped, err
+}
+
+func (a *testAgent) GetCampaign(
+	ctx context.Context, id string,
+) (*db.Campaign, error) {
+	a.Calls = append(a.Calls, testAgentCalls{
+		Method: "GetCampaign", CampaignId: id,
+	})
+	if a.Error != nil {
+		return nil, a.Error
+	}
+	return a.Campaigns[0], nil
+}
+
+func (a *testAgent) Schedule(
+	ctx context.Context, msg *email.Message, sendAt time.Time,
+) (string, error) {
+	a.Calls = append(a.Calls, testAgentCalls{
+		Method: "Schedule", Msg: msg, SendAt: sendAt,
+	})
+	return a.ScheduledId, a.Error
+}
+
+func (a *testAgent) SendScheduled(ctx context.Context) (int, error) {
+	a.Calls = append(a.Calls, testAgentCalls{Method: "SendScheduled"})
+	return a.NumSent, a.Error
+}
+
+func (a *testAgent) ListCampaigns(
+	ctx context.Context,
+) ([]*db.Campaign, error) {
+	a.Calls = append(a.Calls, testAgentCalls{Method: "ListCampaigns"})
+	return a.Campaigns, a.Error
+}
+
+func (a *testAgent) History(
+	ctx context.Context, email string,
+) ([]*db.AuditEvent, error) {
+	a.Calls = append(a.Calls, testAgentCalls{Method: "History", Email: email})
+	return a.AuditEvents, a.Error
+}
+
+func (a *testAgent) ExportSubscriber(
+	ctx context.Context, email string,
+) (*agent.SubscriberData, error) {
+	a.Calls = append(a.Calls, testAgentCalls{
+		Method: "ExportSubscriber", Email: email,
+	})
+	return a.SubscriberData, a.Error
+}
+
+func (a *testAgent) Erase(ctx context.Context, email string) error {
+	a.Calls = append(a.Calls, testAgentCalls{Method: "Erase", Email: email})
+	return a.Error
+}
+
+func (a *testAgent) GetWelcomeMessage(
+	ctx context.Context,
+) (*email.Message, error) {
+	a.Calls = append(a.Calls, testAgentCalls{Method: "GetWelcomeMessage"})
+	return a.WelcomeMessage, a.Error
+}
+
+func (a *testAgent) SetWelcomeMessage(
+	ctx context.Context, msg *email.Message,
+) error {
+	a.Calls = append(a.Calls, testAgentCalls{
+		Method: "SetWelcomeMessage", Msg: msg,
+	})
+	return a.Error
+}
+
+func (a *testAgent) RotateUids(
+	ctx context.Context,
+	gracePeriod time.Duration,
+	startKey *db.ScanKey,
+	batchSize int,
+) (int, *db.ScanKey, error) {
+	a.Calls = append(a.Calls, testAgentCalls{
+		Method:      "RotateUids",
+		GracePeriod: gracePeriod,
+		StartKey:    startKey,
+		BatchSize:   batchSize,
+	})
+	return a.NumSent, a.NextKey, a.Error
+}
+
+// GetSubscriber returns the first of Subscribers, or db.ErrSubscriberNotFound
+// if Subscribers is empty.
+func (a *testAgent) GetSubscriber(
+	ctx context.Context, email string,
+) (*db.Subscriber, error) {
+	a.Calls = append(a.Calls, testAgentCalls{
+		Method: "GetSubscriber", Email: email,
+	})
+	if a.Error != nil {
+		return nil, a.Error
+	} else if len(a.Subscribers) == 0 {
+		return nil, db.ErrSubscriberNotFound
+	}
+	return a.Subscribers[0], nil
+}
+
+func (a *testAgent) ListSubscribers(
+	ctx context.Context,
+	status db.SubscriberStatus,
+	since time.Time,
+	domain string,
+	startKey *db.ScanKey,
+	limit int,
+) ([]*db.Subscriber, *db.ScanKey, error) {
+	a.Calls = append(a.Calls, testAgentCalls{
+		Method:    "ListSubscribers",
+		Status:    status,
+		Since:     since,
+		Domain:    domain,
+		StartKey:  startKey,
+		BatchSize: limit,
+	})
+	return a.Subscribers, a.NextKey, a.Error
 }
 
 const testEmailDomain = "mike-bland.com"
+const testSiteUrl = "https://" + testEmailDomain
 const testSiteTitle = "Mike Bland's blog"
 const testUnsubscribeUser = "unsubscribe"
 const testUnsubscribeAddress = testUnsubscribeUser + "@" + testEmailDomain
@@ -123,6 +333,14 @@ var testRedirects = RedirectPaths{
 	Subscribed:        "subscribed",
 	NotSubscribed:     "not-subscribed",
 	Unsubscribed:      "unsubscribed",
+	VerifyLinkExpired: "verify-link-expired",
+	TopicsUpdated:     "topics-updated",
+}
+
+var testTopics = []string{"essays", "releases"}
+
+var testVerifyTokenKeys = ops.VerifyTokenKeys{
+	{Id: "test", Secret: []byte("0123456789abcdef0123456789abcdef")},
 }
 
 type testBouncer struct {
@@ -167,10 +385,14 @@ func newHandlerFixture() *handlerFixture {
 	ctx := context.Background()
 	handler, err := NewHandler(
 		testEmailDomain,
+		testSiteUrl,
 		testSiteTitle,
 		agent,
 		testRedirects,
+		testTopics,
 		ResponseTemplate,
+		true,
+		nil,
 		testUnsubscribeUser,
 		bouncer,
 		logger,
@@ -294,10 +516,14 @@ func TestNewHandler(t *testing.T) {
 	newHandler := func(responseTemplate string) (*Handler, error) {
 		return NewHandler(
 			testEmailDomain,
+			testSiteUrl,
 			testSiteTitle,
 			&testAgent{},
 			testRedirects,
+			testTopics,
 			responseTemplate,
+			true,
+			nil,
 			testUnsubscribeUser,
 			&testBouncer{},
 			&log.Logger{},
@@ -309,6 +535,7 @@ func TestNewHandler(t *testing.T) {
 
 		assert.NilError(t, err)
 		assert.Equal(t, testSiteTitle, handler.api.SiteTitle)
+		assert.DeepEqual(t, testTopics, handler.api.Topics)
 		assert.Equal(t, testUnsubscribeAddress, handler.mailto.UnsubscribeAddr)
 		assert.Assert(t, handler.sns != nil)
 	})
@@ -399,6 +626,21 @@ func TestHandleEvent(t *testing.T) {
 		)
 	})
 
+	t.Run("HandleSuccessfulScheduledEvent", func(t *testing.T) {
+		f := newHandlerFixture()
+		f.event.Type = ScheduledEvent
+		f.event.ScheduledEvent = &awsevents.EventBridgeEvent{
+			DetailType: ScheduledEventDetailType,
+		}
+		f.agent.NumSent = 27
+
+		response, err := f.handler.HandleEvent(f.ctx, f.event)
+
+		assert.NilError(t, err)
+		assert.Assert(t, is.Nil(response))
+		f.logs.AssertContains(t, "scheduled sends: num sent: 27")
+	})
+
 	t.Run("HandleUnknownCommandLineEvent", func(t *testing.T) {
 		f := newHandlerFixture()
 		f.event.Type = CommandLineEvent
@@ -0,0 +1,185 @@
+package handler
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+
+	awsevents "github.com/aws/aws-lambda-go/events"
+	"github.com/mbland/elistman/ops"
+)
+
+// httpRoute mirrors an Api event from template.yml.
+type httpRoute struct {
+	method       string
+	resourcePath string
+}
+
+var httpRoutes = []httpRoute{
+	{http.MethodPost, ops.ApiPrefixSubscribe},
+	{http.MethodGet, ops.ApiPrefixVerify + "{email}/{uid}"},
+	{http.MethodPost, ops.ApiPrefixVerify + "{email}/{uid}"},
+	{http.MethodGet, ops.ApiPrefixUnsubscribe + "{email}/{uid}"},
+	{http.MethodPost, ops.ApiPrefixUnsubscribe + "{email}/{uid}"},
+	{http.MethodGet, ops.ApiPrefixTopics + "{email}/{uid}"},
+	{http.MethodPost, ops.ApiPrefixTopics + "{email}/{uid}"},
+	{http.MethodPost, ops.ApiPrefixSubscribe + "/{list}"},
+	{http.MethodGet, ops.ApiPrefixVerify + "{list}/{email}/{uid}"},
+	{http.MethodPost, ops.ApiPrefixVerify + "{list}/{email}/{uid}"},
+	{http.MethodGet, ops.ApiPrefixUnsubscribe + "{list}/{email}/{uid}"},
+	{http.MethodPost, ops.ApiPrefixUnsubscribe + "{list}/{email}/{uid}"},
+	{http.MethodGet, ops.ApiPrefixTopics + "{list}/{email}/{uid}"},
+	{http.MethodPost, ops.ApiPrefixTopics + "{list}/{email}/{uid}"},
+}
+
+// NewHttpHandler adapts h to net/http, for running the API locally.
+//
+// The returned ServeMux routes requests for the same endpoints as the API
+// Gateway configuration from template.yml. It converts each request to an
+// events.APIGatewayProxyRequest, passes it to h.HandleEvent, and writes the
+// resulting events.APIGatewayProxyResponse as the HTTP response.
+//
+// Callers may register other handlers with the ServeMux, so long as they don't
+// conflict with the API endpoints.
+func NewHttpHandler(h *Handler) *http.ServeMux {
+	mux := http.NewServeMux()
+	requestIds := &atomic.Uint64{}
+
+	for _, route := range httpRoutes {
+		mux.Handle(
+			route.method+" "+route.resourcePath,
+			&httpApiHandler{h, route.resourcePath, requestIds},
+		)
+	}
+	return mux
+}
+
+type httpApiHandler struct {
+	handler      *Handler
+	resourcePath string
+	requestIds   *atomic.Uint64
+}
+
+func (h *httpApiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	reqId := strconv.FormatUint(h.requestIds.Add(1), 10)
+	var req *awsevents.APIGatewayProxyRequest
+	var result any
+	var err error
+
+	if req, err = newApiGatewayRequest(r, reqId, h.resourcePath); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	event := &Event{Type: ApiRequest, ApiRequest: req}
+	if result, err = h.handler.HandleEvent(r.Context(), event); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	} else if res, ok := result.(*awsevents.APIGatewayProxyResponse); !ok {
+		msg := fmt.Sprintf("unexpected API response: %+v", result)
+		http.Error(w, msg, http.StatusInternalServerError)
+	} else if err = writeApiGatewayResponse(w, res); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// newApiGatewayRequest converts r into the form API Gateway would produce.
+//
+// Like API Gateway, it leaves path parameters URL encoded, and lowercases the
+// names of headers as it would for HTTP/2 requests.
+func newApiGatewayRequest(
+	r *http.Request, requestId, resourcePath string,
+) (req *awsevents.APIGatewayProxyRequest, err error) {
+	var body []byte
+	sourceIp := r.RemoteAddr
+
+	if body, err = io.ReadAll(r.Body); err != nil {
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	} else if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		sourceIp = host
+	}
+
+	identity := awsevents.APIGatewayRequestIdentity{
+		SourceIP: sourceIp, UserAgent: r.UserAgent(),
+	}
+	req = &awsevents.APIGatewayProxyRequest{
+		Resource:                        resourcePath,
+		Path:                            r.URL.Path,
+		HTTPMethod:                      r.Method,
+		Headers:                         map[string]string{},
+		MultiValueHeaders:               map[string][]string{},
+		QueryStringParameters:           map[string]string{},
+		MultiValueQueryStringParameters: r.URL.Query(),
+		PathParameters:                  map[string]string{},
+		RequestContext: awsevents.APIGatewayProxyRequestContext{
+			RequestID:    requestId,
+			ResourcePath: resourcePath,
+			HTTPMethod:   r.Method,
+			Path:         r.URL.Path,
+			Protocol:     r.Proto,
+			Identity:     identity,
+		},
+	}
+
+	for name, values := range r.Header {
+		name = strings.ToLower(name)
+		req.Headers[name] = strings.Join(values, ",")
+		req.MultiValueHeaders[name] = values
+	}
+	for name, values := range req.MultiValueQueryStringParameters {
+		req.QueryStringParameters[name] = values[len(values)-1]
+	}
+	for _, name := range pathParamNames(resourcePath) {
+		req.PathParameters[name] = url.PathEscape(r.PathValue(name))
+	}
+
+	if utf8.Valid(body) {
+		req.Body = string(body)
+	} else {
+		req.Body = base64.StdEncoding.EncodeToString(body)
+		req.IsBase64Encoded = true
+	}
+	return
+}
+
+// pathParamNames returns the names of the "{param}" segments of resourcePath.
+func pathParamNames(resourcePath string) (names []string) {
+	for _, segment := range strings.Split(resourcePath, "/") {
+		if name, ok := strings.CutPrefix(segment, "{"); ok {
+			names = append(names, strings.TrimSuffix(name, "}"))
+		}
+	}
+	return
+}
+
+func writeApiGatewayResponse(
+	w http.ResponseWriter, res *awsevents.APIGatewayProxyResponse,
+) (err error) {
+	body := []byte(res.Body)
+
+	if res.IsBase64Encoded {
+		if body, err = base64.StdEncoding.DecodeString(res.Body); err != nil {
+			return fmt.Errorf("failed to base64 decode response body: %w", err)
+		}
+	}
+
+	header := w.Header()
+	for name, value := range res.Headers {
+		header.Set(name, value)
+	}
+	for name, values := range res.MultiValueHeaders {
+		header[http.CanonicalHeaderKey(name)] = values
+	}
+	w.WriteHeader(res.StatusCode)
+
+	// There's nothing more to do if this fails, since the status is already
+	// written.
+	w.Write(body)
+	return
+}
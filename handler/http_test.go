@@ -0,0 +1,216 @@
+//go:build small_tests || all_tests
+
+package handler
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	awsevents "github.com/aws/aws-lambda-go/events"
+	"github.com/mbland/elistman/ops"
+	"gotest.tools/assert"
+)
+
+func TestNewHttpHandler(t *testing.T) {
+	const formType = "application/x-www-form-urlencoded"
+
+	serve := func(
+		f *handlerFixture, method, path, body string,
+	) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if body != "" {
+			req.Header.Set("Content-Type", formType)
+		}
+		rec := httptest.NewRecorder()
+		NewHttpHandler(f.handler).ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("Subscribes", func(t *testing.T) {
+		f := newHandlerFixture()
+		f.agent.OpResult = ops.VerifyLinkSent
+
+		res := serve(f, http.MethodPost, "/subscribe", "email=mbland%40acm.org")
+
+		assert.Equal(t, http.StatusSeeOther, res.Code)
+		expectedRedirect := f.handler.api.Redirects[ops.VerifyLinkSent]
+		assert.Equal(t, expectedRedirect, res.Header().Get("Location"))
+		assert.Equal(t, "mbland@acm.org", f.agent.Email)
+		f.logs.AssertContains(t, `1: 192.0.2.1 "POST /subscribe HTTP/1.1" 303`)
+	})
+
+	t.Run("ReturnsVerifyConfirmationPage", func(t *testing.T) {
+		f := newHandlerFixture()
+
+		res := serve(
+			f, http.MethodGet, "/verify/mbland%40acm.org/"+testValidUidStr, "",
+		)
+
+		assert.Equal(t, http.StatusOK, res.Code)
+		const form = `<form method="post">`
+		assert.Assert(t, strings.Contains(res.Body.String(), form))
+		assert.Equal(t, 0, len(f.agent.Calls))
+	})
+
+	t.Run("Verifies", func(t *testing.T) {
+		f := newHandlerFixture()
+		f.agent.OpResult = ops.Subscribed
+
+		res := serve(
+			f, http.MethodPost, "/verify/mbland%40acm.org/"+testValidUidStr, "",
+		)
+
+		assert.Equal(t, http.StatusSeeOther, res.Code)
+		expectedRedirect := f.handler.api.Redirects[ops.Subscribed]
+		assert.Equal(t, expectedRedirect, res.Header().Get("Location"))
+		assert.Equal(t, "Verify", f.agent.Calls[0].Method)
+		assert.Equal(t, "mbland@acm.org", f.agent.Email)
+		assert.Equal(t, testValidUid, f.agent.Uid)
+	})
+
+	t.Run("UnsubscribesViaOneClick", func(t *testing.T) {
+		f := newHandlerFixture()
+		f.agent.OpResult = ops.Unsubscribed
+
+		res := serve(
+			f,
+			http.MethodPost,
+			"/unsubscribe/mbland@acm.org/"+testValidUidStr,
+			"List-Unsubscribe=One-Click",
+		)
+
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, "Unsubscribe", f.agent.Calls[0].Method)
+		assert.Equal(t, "mbland@acm.org", f.agent.Email)
+	})
+
+	t.Run("ReturnsBadRequestForInvalidParams", func(t *testing.T) {
+		f := newHandlerFixture()
+
+		res := serve(f, http.MethodGet, "/verify/mbland@acm.org/bogus-uid", "")
+
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+		const htmlType = "text/html; charset=utf-8"
+		assert.Equal(t, htmlType, res.Header().Get("Content-Type"))
+		assert.Assert(t, strings.Contains(res.Body.String(), "invalid uid"))
+		assert.Equal(t, 0, len(f.agent.Calls))
+	})
+
+	t.Run("ReturnsNotFoundForUnknownEndpoint", func(t *testing.T) {
+		f := newHandlerFixture()
+
+		res := serve(f, http.MethodPost, "/foobar/mbland@acm.org", "")
+
+		assert.Equal(t, http.StatusNotFound, res.Code)
+	})
+
+	t.Run("ReturnsMethodNotAllowedForWrongMethod", func(t *testing.T) {
+		f := newHandlerFixture()
+
+		res := serve(f, http.MethodGet, "/subscribe", "")
+
+		assert.Equal(t, http.StatusMethodNotAllowed, res.Code)
+	})
+}
+
+func TestNewApiGatewayRequest(t *testing.T) {
+	const resourcePath = "/unsubscribe/{email}/{uid}"
+
+	newRequest := func(body string) *http.Request {
+		mux := http.NewServeMux()
+		var req *http.Request
+		mux.HandleFunc(
+			"POST "+resourcePath,
+			func(_ http.ResponseWriter, r *http.Request) { req = r },
+		)
+		r := httptest.NewRequest(
+			http.MethodPost,
+			"/unsubscribe/foo+bar%40test.com/"+testValidUidStr+"?a=1&a=2",
+			strings.NewReader(body),
+		)
+		r.Header.Add("Content-Type", "text/plain")
+		r.Header.Add("X-Foo", "bar")
+		r.Header.Add("X-Foo", "baz")
+		r.Header.Add("User-Agent", "Mozilla/5.0")
+		mux.ServeHTTP(httptest.NewRecorder(), r)
+		return req
+	}
+
+	t.Run("ConvertsRequest", func(t *testing.T) {
+		req, err := newApiGatewayRequest(
+			newRequest("Hello, World!"), "deadbeef", resourcePath,
+		)
+
+		assert.NilError(t, err)
+		assert.Equal(t, http.MethodPost, req.HTTPMethod)
+		assert.Equal(t, "deadbeef", req.RequestContext.RequestID)
+		assert.Equal(t, resourcePath, req.RequestContext.ResourcePath)
+		assert.Equal(t, "HTTP/1.1", req.RequestContext.Protocol)
+		assert.Equal(t, "192.0.2.1", req.RequestContext.Identity.SourceIP)
+		assert.Equal(t, "Mozilla/5.0", req.RequestContext.Identity.UserAgent)
+		assert.Equal(t, "text/plain", req.Headers["content-type"])
+		assert.Equal(t, "bar,baz", req.Headers["x-foo"])
+		assert.DeepEqual(
+			t, []string{"bar", "baz"}, req.MultiValueHeaders["x-foo"],
+		)
+		assert.Equal(t, "2", req.QueryStringParameters["a"])
+		expectedParams := map[string]string{
+			"email": "foo+bar@test.com", "uid": testValidUidStr,
+		}
+		assert.DeepEqual(t, expectedParams, req.PathParameters)
+		assert.Equal(t, "Hello, World!", req.Body)
+		assert.Assert(t, !req.IsBase64Encoded)
+	})
+
+	t.Run("EncodesNonUtf8Body", func(t *testing.T) {
+		body := string([]byte{0xff, 0xfe, 0xfd})
+
+		req, err := newApiGatewayRequest(
+			newRequest(body), "deadbeef", resourcePath,
+		)
+
+		assert.NilError(t, err)
+		encoded := base64.StdEncoding.EncodeToString([]byte(body))
+		assert.Equal(t, encoded, req.Body)
+		assert.Assert(t, req.IsBase64Encoded)
+	})
+}
+
+func TestWriteApiGatewayResponse(t *testing.T) {
+	t.Run("WritesResponse", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		res := &awsevents.APIGatewayProxyResponse{
+			StatusCode: http.StatusOK,
+			Headers:    map[string]string{"content-type": "text/plain"},
+			MultiValueHeaders: map[string][]string{
+				"x-foo": {"bar", "baz"},
+			},
+			Body:            base64.StdEncoding.EncodeToString([]byte("Hi!")),
+			IsBase64Encoded: true,
+		}
+
+		err := writeApiGatewayResponse(rec, res)
+
+		assert.NilError(t, err)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "text/plain", rec.Header().Get("Content-Type"))
+		assert.DeepEqual(t, []string{"bar", "baz"}, rec.Header()["X-Foo"])
+		assert.Equal(t, "Hi!", rec.Body.String())
+	})
+
+	t.Run("FailsIfBodyNotBase64Encoded", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		res := &awsevents.APIGatewayProxyResponse{
+			StatusCode: http.StatusOK, Body: "Hi!", IsBase64Encoded: true,
+		}
+
+		err := writeApiGatewayResponse(rec, res)
+
+		const expected = "failed to base64 decode response body"
+		assert.ErrorContains(t, err, expected)
+		assert.Equal(t, 0, rec.Body.Len())
+	})
+}
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"fmt"
+	"net/mail"
+	"sort"
+
+	"github.com/mbland/elistman/agent"
+	"github.com/mbland/elistman/ops"
+)
+
+// List describes a named mailing list served alongside the default list.
+//
+// Each List has its own site title, sender address, redirect paths, and topic
+// allow-list. Its Agent must use the list's own subscriber data, and must
+// generate verify, unsubscribe, and topics links containing Name.
+type List struct {
+	Name          string
+	SiteTitle     string
+	SenderAddress string
+	Agent         agent.SubscriptionAgent
+	Paths         RedirectPaths
+	Topics        []string
+}
+
+// AddList adds a named list that h will serve in addition to the default list.
+//
+// API requests, unsubscribe emails, and command line events select the list by
+// name. SES bounce and complaint notifications select the list by the address
+// of the original message's sender.
+func (h *Handler) AddList(list *List) (err error) {
+	var sender *mail.Address
+
+	if err = ops.ValidateListName(list.Name); err != nil {
+		return
+	} else if _, exists := h.lists[list.Name]; exists {
+		return fmt.Errorf("list already added: %s", list.Name)
+	} else if sender, err = mail.ParseAddress(list.SenderAddress); err != nil {
+		const errFmt = "invalid sender address for list %s: %w"
+		return fmt.Errorf(errFmt, list.Name, err)
+	}
+
+	h.lists[list.Name] = list.Agent
+	h.api.Lists[list.Name] = &apiList{
+		list.SiteTitle,
+		list.Agent,
+		newRedirectMap(h.siteUrl, list.Paths),
+		list.Topics,
+	}
+	h.sns.Senders[sender.Address] = list.Agent
+	return
+}
+
+// listAgents maps list names to the agents that serve them.
+//
+// The default list, named by the empty string, isn't in the map.
+type listAgents map[string]agent.SubscriptionAgent
+
+func (la listAgents) get(
+	defaultAgent agent.SubscriptionAgent, list string,
+) (agent.SubscriptionAgent, error) {
+	if list == "" {
+		return defaultAgent, nil
+	} else if a, ok := la[list]; ok {
+		return a, nil
+	}
+	return nil, fmt.Errorf("unknown list: %s", list)
+}
+
+// names returns the names of every list in sorted order.
+// each calls f with the default list's agent, then with each named list's agent
+// in name order, stopping at the first error. Errors from named lists begin
+// with the list's name.
+func (la listAgents) each(
+	defaultAgent agent.SubscriptionAgent,
+	f func(list string, a agent.SubscriptionAgent) error,
+) (err error) {
+	if err = f("", defaultAgent); err != nil {
+		return
+	}
+	for _, name := range la.names() {
+		if err = f(name, la[name]); err != nil {
+			return fmt.Errorf("list %s: %w", name, err)
+		}
+	}
+	return
+}
+
+func (la listAgents) names() []string {
+	names := make([]string, 0, len(la))
+	for name := range la {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,342 @@
+//go:build small_tests || all_tests
+
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	awsevents "github.com/aws/aws-lambda-go/events"
+	"github.com/mbland/elistman/email"
+	"github.com/mbland/elistman/events"
+	"github.com/mbland/elistman/ops"
+	"github.com/mbland/elistman/testutils"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+var testListRedirects = RedirectPaths{
+	Invalid:           "updates/invalid",
+	AlreadySubscribed: "updates/already-subscribed",
+	VerifyLinkSent:    "updates/verify-link-sent",
+	Subscribed:        "updates/subscribed",
+	NotSubscribed:     "updates/not-subscribed",
+	Unsubscribed:      "updates/unsubscribed",
+}
+
+type listHandlerFixture struct {
+	*handlerFixture
+	listAgent *testAgent
+}
+
+func newListHandlerFixture() *listHandlerFixture {
+	f := newHandlerFixture()
+	listAgent := &testAgent{}
+	err := f.handler.AddList(&List{
+		Name:          "updates",
+		SiteTitle:     "Mike Bland's updates",
+		SenderAddress: "Mike Bland <updates@mike-bland.com>",
+		Agent:         listAgent,
+		Paths:         testListRedirects,
+	})
+
+	if err != nil {
+		panic("failed to add test list: " + err.Error())
+	}
+	return &listHandlerFixture{f, listAgent}
+}
+
+func TestAddList(t *testing.T) {
+	newList := func() *List {
+		return &List{
+			Name:          "updates",
+			SiteTitle:     "Mike Bland's updates",
+			SenderAddress: "updates@mike-bland.com",
+			Agent:         &testAgent{},
+			Paths:         testListRedirects,
+			Topics:        []string{"news"},
+		}
+	}
+
+	t.Run("Succeeds", func(t *testing.T) {
+		f := newHandlerFixture()
+		list := newList()
+
+		err := f.handler.AddList(list)
+
+		assert.NilError(t, err)
+		assert.Equal(t, list.Agent, f.handler.lists["updates"])
+		assert.Equal(t, list.Agent, f.handler.sns.Senders[list.SenderAddress])
+		apiList := f.handler.api.Lists["updates"]
+		assert.Equal(t, list.SiteTitle, apiList.SiteTitle)
+		assert.Equal(
+			t,
+			testSiteUrl+"/updates/subscribed",
+			apiList.Redirects[ops.Subscribed],
+		)
+		assert.DeepEqual(t, []string{"news"}, apiList.Topics)
+	})
+
+	t.Run("FailsIfNameInvalid", func(t *testing.T) {
+		f := newHandlerFixture()
+		list := newList()
+		list.Name = "Updates!"
+
+		err := f.handler.AddList(list)
+
+		assert.Assert(t, testutils.ErrorIs(err, ops.ErrInvalidListName))
+	})
+
+	t.Run("FailsIfAlreadyAdded", func(t *testing.T) {
+		f := newHandlerFixture()
+		assert.NilError(t, f.handler.AddList(newList()))
+
+		err := f.handler.AddList(newList())
+
+		assert.Error(t, err, "list already added: updates")
+	})
+
+	t.Run("FailsIfSenderAddressInvalid", func(t *testing.T) {
+		f := newHandlerFixture()
+		list := newList()
+		list.SenderAddress = "not an address"
+
+		err := f.handler.AddList(list)
+
+		const expectedErr = "invalid sender address for list updates: "
+		assert.ErrorContains(t, err, expectedErr)
+	})
+}
+
+func TestHandleEventForList(t *testing.T) {
+	t.Run("ApiRequest", func(t *testing.T) {
+		f := newListHandlerFixture()
+		f.event.Type = ApiRequest
+		f.listAgent.OpResult = ops.VerifyLinkSent
+
+		req := apiGatewayRequest(
+			http.MethodPost, ops.ApiPrefixSubscribe+"/{list}",
+		)
+		req.Headers = map[string]string{
+			"content-type": "application/x-www-form-urlencoded",
+		}
+		req.PathParameters = map[string]string{"list": "updates"}
+		req.Body = "email=mbland%40acm.org"
+		f.event.ApiRequest = req
+
+		response, err := f.handler.HandleEvent(f.ctx, f.event)
+
+		assert.NilError(t, err)
+		assert.Equal(t, "", f.agent.Email)
+		assert.Equal(t, "mbland@acm.org", f.listAgent.Email)
+		apiResponse := response.(*awsevents.APIGatewayProxyResponse)
+		assert.Equal(t, http.StatusSeeOther, apiResponse.StatusCode)
+		expectedRedirect := testSiteUrl + "/updates/verify-link-sent"
+		assert.Equal(t, expectedRedirect, apiResponse.Headers["location"])
+	})
+
+	newListSubscribeRequest := func(
+		body string,
+	) *awsevents.APIGatewayProxyRequest {
+		req := apiGatewayRequest(
+			http.MethodPost, ops.ApiPrefixSubscribe+"/{list}",
+		)
+		req.Headers = map[string]string{
+			"content-type": "application/x-www-form-urlencoded",
+		}
+		req.PathParameters = map[string]string{"list": "updates"}
+		req.Body = body
+		return req
+	}
+
+	t.Run("ApiRequestWithInvalidEmailUsesListRedirect", func(t *testing.T) {
+		f := newListHandlerFixture()
+		f.event.Type = ApiRequest
+		f.event.ApiRequest = newListSubscribeRequest("email=mbland+acm.org")
+
+		response, err := f.handler.HandleEvent(f.ctx, f.event)
+
+		assert.NilError(t, err)
+		apiResponse := response.(*awsevents.APIGatewayProxyResponse)
+		assert.Equal(t, http.StatusSeeOther, apiResponse.StatusCode)
+		expectedRedirect := testSiteUrl + "/updates/invalid"
+		assert.Equal(t, expectedRedirect, apiResponse.Headers["location"])
+		assert.Equal(t, 0, len(f.listAgent.Calls))
+	})
+
+	t.Run("ApiRequestWithUnknownTopicUsesListRedirect", func(t *testing.T) {
+		f := newListHandlerFixture()
+		f.event.Type = ApiRequest
+		f.event.ApiRequest = newListSubscribeRequest(
+			"email=mbland%40acm.org&topics=podcasts",
+		)
+
+		response, err := f.handler.HandleEvent(f.ctx, f.event)
+
+		assert.NilError(t, err)
+		apiResponse := response.(*awsevents.APIGatewayProxyResponse)
+		assert.Equal(t, http.StatusSeeOther, apiResponse.StatusCode)
+		expectedRedirect := testSiteUrl + "/updates/invalid"
+		assert.Equal(t, expectedRedirect, apiResponse.Headers["location"])
+		assert.Equal(t, 0, len(f.agent.Calls))
+		assert.Equal(t, 0, len(f.listAgent.Calls))
+	})
+
+	t.Run("ApiRequestConfirmationPageUsesListTitle", func(t *testing.T) {
+		f := newListHandlerFixture()
+		f.event.Type = ApiRequest
+		req := apiGatewayRequest(
+			http.MethodGet, ops.ApiPrefixVerify+"{list}/{email}/{uid}",
+		)
+		req.PathParameters = map[string]string{
+			"list":  "updates",
+			"email": "mbland@acm.org",
+			"uid":   testValidUidStr,
+		}
+		f.event.ApiRequest = req
+
+		response, err := f.handler.HandleEvent(f.ctx, f.event)
+
+		assert.NilError(t, err)
+		apiResponse := response.(*awsevents.APIGatewayProxyResponse)
+		assert.Equal(t, http.StatusOK, apiResponse.StatusCode)
+		assert.Assert(t, is.Contains(apiResponse.Body, "Mike Bland's updates"))
+		assert.Equal(t, 0, len(f.listAgent.Calls))
+	})
+
+	t.Run("ApiRequestForUnknownListFails", func(t *testing.T) {
+		f := newListHandlerFixture()
+		f.event.Type = ApiRequest
+		req := apiGatewayRequest(
+			http.MethodPost, ops.ApiPrefixVerify+"{list}/{email}/{uid}",
+		)
+		req.PathParameters = map[string]string{
+			"list":  "bogus",
+			"email": "mbland@acm.org",
+			"uid":   testValidUidStr,
+		}
+		f.event.ApiRequest = req
+
+		response, err := f.handler.HandleEvent(f.ctx, f.event)
+
+		assert.NilError(t, err)
+		apiResponse := response.(*awsevents.APIGatewayProxyResponse)
+		assert.Equal(t, http.StatusBadRequest, apiResponse.StatusCode)
+		assert.Assert(t, is.Contains(apiResponse.Body, "unknown list: bogus"))
+		assert.Equal(t, 0, len(f.agent.Calls))
+		assert.Equal(t, 0, len(f.listAgent.Calls))
+	})
+
+	t.Run("MailtoEvent", func(t *testing.T) {
+		f := newListHandlerFixture()
+		f.event.Type = MailtoEvent
+		f.event.MailtoEvent = simpleEmailEvent()
+		headers := &f.event.MailtoEvent.Records[0].SES.Mail.CommonHeaders
+		headers.Subject += " updates"
+		f.listAgent.OpResult = ops.Unsubscribed
+
+		_, err := f.handler.HandleEvent(f.ctx, f.event)
+
+		assert.NilError(t, err)
+		assert.Equal(t, "", f.agent.Email)
+		assert.Equal(t, "mbland@acm.org", f.listAgent.Email)
+		assert.Equal(t, testValidUid, f.listAgent.Uid)
+		f.logs.AssertContains(t, "success")
+	})
+
+	t.Run("MailtoEventForUnknownListIsIgnored", func(t *testing.T) {
+		f := newListHandlerFixture()
+		f.event.Type = MailtoEvent
+		f.event.MailtoEvent = simpleEmailEvent()
+		headers := &f.event.MailtoEvent.Records[0].SES.Mail.CommonHeaders
+		headers.Subject += " bogus"
+
+		_, err := f.handler.HandleEvent(f.ctx, f.event)
+
+		assert.NilError(t, err)
+		assert.Equal(t, 0, len(f.agent.Calls))
+		const expectedLog = "failed to parse, ignoring: unknown list: bogus"
+		f.logs.AssertContains(t, expectedLog)
+	})
+
+	t.Run("SnsEventSelectsListBySender", func(t *testing.T) {
+		f := newListHandlerFixture()
+		f.event.Type = SnsEvent
+		record := sesEventRecord()
+		record.EventType = "Bounce"
+		record.Bounce = &events.SesBounceEvent{
+			BounceType: "Permanent", BounceSubType: "General",
+		}
+		record.Mail.CommonHeaders.From = []string{
+			"Mike Bland <updates@mike-bland.com>",
+		}
+		f.event.SnsEvent = simpleNotificationServiceEvent()
+		encoded, err := json.Marshal(record)
+		assert.NilError(t, err)
+		f.event.SnsEvent.Records[0].SNS.Message = string(encoded)
+
+		_, err = f.handler.HandleEvent(f.ctx, f.event)
+
+		assert.NilError(t, err)
+		assert.Equal(t, 0, len(f.agent.Calls))
+		assert.Equal(t, "foo@bar.com", f.listAgent.Email)
+		assert.Equal(t, "Remove", f.listAgent.Calls[0].Method)
+	})
+
+	t.Run("CommandLineSendEvent", func(t *testing.T) {
+		f := newListHandlerFixture()
+		f.event.Type = CommandLineEvent
+		f.event.CommandLineEvent = &events.CommandLineEvent{
+			EListManCommand: events.CommandLineSendEvent,
+			Send: &events.SendEvent{
+				List: "updates", Message: *email.ExampleMessage,
+			},
+		}
+		f.listAgent.SendResponse = func(
+			*email.Message, []string,
+		) (int, error) {
+			return 27, nil
+		}
+
+		response, err := f.handler.HandleEvent(f.ctx, f.event)
+
+		assert.NilError(t, err)
+		expected := &events.SendResponse{Success: true, NumSent: 27}
+		assert.DeepEqual(t, expected, response)
+		assert.Equal(t, 0, len(f.agent.Calls))
+	})
+
+	t.Run("CommandLineSendEventForUnknownListFails", func(t *testing.T) {
+		f := newListHandlerFixture()
+		f.event.Type = CommandLineEvent
+		f.event.CommandLineEvent = &events.CommandLineEvent{
+			EListManCommand: events.CommandLineSendEvent,
+			Send: &events.SendEvent{
+				List: "bogus", Message: *email.ExampleMessage,
+			},
+		}
+
+		response, err := f.handler.HandleEvent(f.ctx, f.event)
+
+		assert.NilError(t, err)
+		expected := &events.SendResponse{Details: "unknown list: bogus"}
+		assert.DeepEqual(t, expected, response)
+	})
+
+	t.Run("ScheduledEventSendsForEveryList", func(t *testing.T) {
+		f := newListHandlerFixture()
+		f.event.Type = ScheduledEvent
+		f.event.ScheduledEvent = &awsevents.EventBridgeEvent{
+			DetailType: ScheduledEventDetailType,
+		}
+		f.agent.NumSent = 27
+		f.listAgent.NumSent = 3
+
+		_, err := f.handler.HandleEvent(f.ctx, f.event)
+
+		assert.NilError(t, err)
+		f.logs.AssertContains(t, "scheduled sends: num sent: 27")
+		f.logs.AssertContains(t, "scheduled sends [updates]: num sent: 3")
+	})
+}
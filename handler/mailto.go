@@ -7,6 +7,7 @@ import (
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/mbland/elistman/agent"
+	"github.com/mbland/elistman/db"
 	"github.com/mbland/elistman/email"
 	"github.com/mbland/elistman/ops"
 )
@@ -15,6 +16,7 @@ type mailtoHandler struct {
 	EmailDomain     string
 	UnsubscribeAddr string
 	Agent           agent.SubscriptionAgent
+	Lists           listAgents
 	Bouncer         email.Bouncer
 	Log             *log.Logger
 }
@@ -70,7 +72,7 @@ func (h *mailtoHandler) handleMailtoEvent(
 	ctx context.Context, ev *mailtoEvent,
 ) {
 	outcome := "success"
-	unsubscribe := h.Agent.Unsubscribe
+	ctx = agent.WithAuditInfo(ctx, db.AuditSourceMailto, ev.MessageId)
 
 	if bounceMessageId, err := h.bounceIfDmarcFails(ctx, ev); err != nil {
 		outcome = "DMARC bounce failed: " + err.Error()
@@ -80,7 +82,9 @@ func (h *mailtoHandler) handleMailtoEvent(
 		outcome = "marked as spam, ignored"
 	} else if op, err := parseMailtoEvent(ev, h.UnsubscribeAddr); err != nil {
 		outcome = "failed to parse, ignoring: " + err.Error()
-	} else if result, err := unsubscribe(ctx, op.Email, op.Uid); err != nil {
+	} else if a, err := h.Lists.get(h.Agent, op.List); err != nil {
+		outcome = "failed to parse, ignoring: " + err.Error()
+	} else if result, err := a.Unsubscribe(ctx, op.Email, op.Uid); err != nil {
 		outcome = "error: " + err.Error()
 	} else if result != ops.Unsubscribed {
 		outcome = "failed: " + result.String()
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/mbland/elistman/db"
 	"github.com/mbland/elistman/ops"
 	"github.com/mbland/elistman/testutils"
 	"gotest.tools/assert"
@@ -39,7 +40,12 @@ func newMailtoHandlerFixture() *mailtoHandlerFixture {
 		bouncer,
 		logs,
 		&mailtoHandler{
-			testEmailDomain, testUnsubscribeAddress, agent, bouncer, logger,
+			testEmailDomain,
+			testUnsubscribeAddress,
+			agent,
+			listAgents{},
+			bouncer,
+			logger,
 		},
 		context.Background(),
 		&mailtoEvent{
@@ -153,6 +159,8 @@ func TestHandleMailtoEvent(t *testing.T) {
 			`From:"mbland@acm.org" `+
 			`To:"`+testUnsubscribeAddress+`" `+
 			`Subject:"mbland@acm.org `+testValidUidStr+`"]: success`)
+		assert.Equal(t, db.AuditSourceMailto, f.agent.AuditSource)
+		assert.Equal(t, "deadbeef", f.agent.RequestId)
 	})
 
 	t.Run("LogsIfFailsToBounceOnDmarcFail", func(t *testing.T) {
@@ -1,11 +1,16 @@
 package handler
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/mbland/elistman/agent"
+	"github.com/mbland/elistman/db"
+	"github.com/mbland/elistman/ops"
 	"github.com/mbland/elistman/types"
 )
 
@@ -16,6 +21,63 @@ type RedirectPaths struct {
 	Subscribed        string
 	NotSubscribed     string
 	Unsubscribed      string
+	VerifyLinkExpired string
+	TopicsUpdated     string
+}
+
+// Values for Options.Mailer.
+const (
+	MailerSes  = "ses"
+	MailerSmtp = "smtp"
+)
+
+// Values for Options.Database.
+const (
+	DatabaseDynamoDb = "dynamodb"
+	DatabasePostgres = "postgres"
+)
+
+// SmtpOptions configure the email.SmtpMailer when Options.Mailer is MailerSmtp.
+//
+// Username and PasswordSecret are optional, but if one is defined, both must
+// be. PasswordSecret is the name of the AWS Secrets Manager secret containing
+// the password, so the password itself never appears in the environment.
+type SmtpOptions struct {
+	Addr           string
+	Username       string
+	PasswordSecret string
+}
+
+// ListOptions configure a named list served in addition to the default list.
+//
+// Every field except Topics is required. Topics is the list's allow-list of
+// topics, like Options.Topics, and if it isn't empty, RedirectPaths must
+// contain topicsUpdated. The list shares every other setting with the default
+// list, including its subscribers table, unsubscribe address, and unsubscribe
+// form. See List and Handler.AddList.
+type ListOptions struct {
+	Name           string        `json:"name"`
+	SiteTitle      string        `json:"siteTitle"`
+	SenderName     string        `json:"senderName"`
+	SenderUserName string        `json:"senderUserName"`
+	Topics         []string      `json:"topics"`
+	RedirectPaths  RedirectPaths `json:"redirectPaths"`
+}
+
+// Default values for Options.VerifyResendCooldown and Options.MaxVerifyEmails.
+const (
+	DefaultVerifyResendCooldown = 10 * time.Minute
+	DefaultMaxVerifyEmails      = 3
+)
+
+// DefaultTombstonePolicy is the default value for Options.TombstonePolicy.
+//
+// It allows an address to subscribe again a day after leaving the list, but
+// never allows importing or restoring an address that unsubscribed.
+var DefaultTombstonePolicy = agent.TombstonePolicy{
+	Subscribe: 24 * time.Hour,
+	Import:    agent.TombstoneForever,
+	Restore:   agent.TombstoneForever,
 }
 
 type Options struct {
@@ -31,7 +93,71 @@ type Options struct {
 	ConfigurationSet     string
 	MaxBulkSendCapacity  types.Capacity
 
+	// Database selects the database implementation, either DatabaseDynamoDb
+	// or DatabasePostgres. Defaults to DatabaseDynamoDb if the DATABASE
+	// environment variable is undefined. Either way, SubscribersTableName
+	// names the subscribers table.
+	//
+	// PostgresUrl is the connection string for the PostgreSQL server, from the
+	// POSTGRES_URL environment variable. It's required if Database is
+	// DatabasePostgres.
+	Database    string
+	PostgresUrl string
+
+	// Mailer selects the email.Mailer implementation, either MailerSes or
+	// MailerSmtp. Defaults to MailerSes if the MAILER environment variable is
+	// undefined.
+	Mailer string
+	Smtp   SmtpOptions
+
+	// SkipLinkConfirmation disables the confirmation page for GET requests to
+	// verify and unsubscribe links, so they take effect immediately. Defaults
+	// to false if the SKIP_LINK_CONFIRMATION environment variable is undefined.
+	SkipLinkConfirmation bool
+
+	// VerifyResendCooldown and MaxVerifyEmails limit how often a pending
+	// subscriber may receive a verification email. They default to
+	// DefaultVerifyResendCooldown and DefaultMaxVerifyEmails if the
+	// VERIFY_RESEND_COOLDOWN and MAX_VERIFY_EMAILS environment variables are
+	// undefined. See agent.ProdAgent.
+	VerifyResendCooldown time.Duration
+	MaxVerifyEmails      int
+
+	// TombstonePolicy determines how long after an address leaves the list
+	// it may subscribe, be imported, or be restored again. Each field
+	// defaults to the corresponding DefaultTombstonePolicy field if the
+	// RESUBSCRIBE_COOLDOWN, REIMPORT_COOLDOWN, or RESTORE_COOLDOWN
+	// environment variable is undefined. Each variable is either a Go
+	// duration string or "forever". See agent.ProdAgent.
+	TombstonePolicy agent.TombstonePolicy
+
+	// ErasureSalt is the secret key for hashing the addresses that
+	// "elistman erase" erases. It's empty if the ERASURE_SALT environment
+	// variable is undefined, in which case erasure isn't possible. See
+	// agent.ProdAgent.
+	ErasureSalt string
+
+	// VerifyTokenKeys sign and check the ops.VerifyToken in each verification
+	// link. They're parsed from the VERIFY_TOKEN_KEYS environment variable, a
+	// comma separated list of ID:SECRET pairs (see ops.ParseVerifyTokenKeys).
+	// VerifyTokenKeys is empty if VERIFY_TOKEN_KEYS is undefined, in which case
+	// verification links contain subscriber UIDs instead. Otherwise
+	// RedirectPaths.VerifyLinkExpired, set by VERIFY_LINK_EXPIRED_PATH, is
+	// required, as is the same path for each of Lists.
+	VerifyTokenKeys ops.VerifyTokenKeys
+
+	// Topics is the allow-list of topics that subscribers may choose, parsed
+	// from the comma separated TOPICS environment variable. Subscribe requests
+	// for any other topic fail. It's empty if TOPICS is undefined, in which
+	// case subscribers may not choose any topics. Otherwise
+	// RedirectPaths.TopicsUpdated, set by TOPICS_UPDATED_PATH, is required.
+	Topics []string
+
 	RedirectPaths RedirectPaths
+
+	// Lists contains the named lists parsed from the JSON array in the LISTS
+	// environment variable. It's empty if LISTS is undefined.
+	Lists []ListOptions
 }
 
 type UndefinedEnvVarsError struct {
@@ -68,6 +194,23 @@ func (env *environment) options() (*Options, error) {
 	env.assign(&opts.ConfigurationSet, "CONFIGURATION_SET")
 	env.assignCapacity(&opts.MaxBulkSendCapacity, "MAX_BULK_SEND_CAPACITY")
 
+	env.assignDatabase(&opts)
+	env.assignMailer(&opts)
+	env.assignBool(&opts.SkipLinkConfirmation, "SKIP_LINK_CONFIRMATION")
+	opts.VerifyResendCooldown = DefaultVerifyResendCooldown
+	env.assignDuration(&opts.VerifyResendCooldown, "VERIFY_RESEND_COOLDOWN")
+	opts.MaxVerifyEmails = DefaultMaxVerifyEmails
+	env.assignInt(&opts.MaxVerifyEmails, "MAX_VERIFY_EMAILS")
+	policy := &opts.TombstonePolicy
+	*policy = DefaultTombstonePolicy
+	env.assignCooldown(&policy.Subscribe, "RESUBSCRIBE_COOLDOWN")
+	env.assignCooldown(&policy.Import, "REIMPORT_COOLDOWN")
+	env.assignCooldown(&policy.Restore, "RESTORE_COOLDOWN")
+	opts.ErasureSalt = env.getenv("ERASURE_SALT")
+	env.assignVerifyTokenKeys(&opts.VerifyTokenKeys, "VERIFY_TOKEN_KEYS")
+	verifyTokens := len(opts.VerifyTokenKeys) != 0
+	env.assignTopics(&opts.Topics, "TOPICS")
+
 	redirects := &opts.RedirectPaths
 	env.assignPath(&redirects.Invalid, "INVALID_REQUEST_PATH")
 	env.assignPath(&redirects.AlreadySubscribed, "ALREADY_SUBSCRIBED_PATH")
@@ -75,6 +218,13 @@ func (env *environment) options() (*Options, error) {
 	env.assignPath(&redirects.Subscribed, "SUBSCRIBED_PATH")
 	env.assignPath(&redirects.NotSubscribed, "NOT_SUBSCRIBED_PATH")
 	env.assignPath(&redirects.Unsubscribed, "UNSUBSCRIBED_PATH")
+	if verifyTokens {
+		env.assignPath(&redirects.VerifyLinkExpired, "VERIFY_LINK_EXPIRED_PATH")
+	}
+	if len(opts.Topics) != 0 {
+		env.assignPath(&redirects.TopicsUpdated, "TOPICS_UPDATED_PATH")
+	}
+	env.assignLists(&opts.Lists, "LISTS", verifyTokens)
 
 	if len(env.undefinedVars) != 0 {
 		undefErr := &UndefinedEnvVarsError{UndefinedVars: env.undefinedVars}
@@ -94,6 +244,98 @@ func (env *environment) assign(opt *string, varname string) {
 	}
 }
 
+func (env *environment) assignDatabase(opts *Options) {
+	opts.Database = env.getenv("DATABASE")
+
+	switch opts.Database {
+	case "":
+		opts.Database = DatabaseDynamoDb
+	case DatabaseDynamoDb:
+	case DatabasePostgres:
+		env.assign(&opts.PostgresUrl, "POSTGRES_URL")
+	default:
+		const errFmt = "invalid DATABASE: %q is not %q or %q"
+		err := fmt.Errorf(
+			errFmt, opts.Database, DatabaseDynamoDb, DatabasePostgres,
+		)
+		env.errors = append(env.errors, err)
+	}
+}
+
+func (env *environment) assignMailer(opts *Options) {
+	opts.Mailer = env.getenv("MAILER")
+
+	switch opts.Mailer {
+	case "":
+		opts.Mailer = MailerSes
+	case MailerSes:
+	case MailerSmtp:
+		smtp := &opts.Smtp
+		env.assign(&smtp.Addr, "SMTP_ADDR")
+		smtp.Username = env.getenv("SMTP_USERNAME")
+		smtp.PasswordSecret = env.getenv("SMTP_PASSWORD_SECRET")
+
+		if (smtp.Username == "") != (smtp.PasswordSecret == "") {
+			const errMsg = "SMTP_USERNAME and SMTP_PASSWORD_SECRET " +
+				"must both be defined, or neither"
+			env.errors = append(env.errors, errors.New(errMsg))
+		}
+	default:
+		const errFmt = "invalid MAILER: %q is not %q or %q"
+		err := fmt.Errorf(errFmt, opts.Mailer, MailerSes, MailerSmtp)
+		env.errors = append(env.errors, err)
+	}
+}
+
+func (env *environment) assignBool(opt *bool, varname string) {
+	if value := env.getenv(varname); value == "" {
+		return
+	} else if b, err := strconv.ParseBool(value); err != nil {
+		const errFmt = "invalid %s: %w"
+		env.errors = append(env.errors, fmt.Errorf(errFmt, varname, err))
+	} else {
+		*opt = b
+	}
+}
+
+func (env *environment) assignDuration(opt *time.Duration, varname string) {
+	if value := env.getenv(varname); value == "" {
+		return
+	} else if d, err := time.ParseDuration(value); err != nil {
+		const errFmt = "invalid %s: %w"
+		env.errors = append(env.errors, fmt.Errorf(errFmt, varname, err))
+	} else if d < 0 {
+		const errFmt = "invalid %s: %s is negative"
+		env.errors = append(env.errors, fmt.Errorf(errFmt, varname, value))
+	} else {
+		*opt = d
+	}
+}
+
+// assignCooldown assigns a duration, or agent.TombstoneForever if the value is
+// "forever".
+func (env *environment) assignCooldown(opt *time.Duration, varname string) {
+	if env.getenv(varname) == "forever" {
+		*opt = agent.TombstoneForever
+	} else {
+		env.assignDuration(opt, varname)
+	}
+}
+
+func (env *environment) assignInt(opt *int, varname string) {
+	if value := env.getenv(varname); value == "" {
+		return
+	} else if i, err := strconv.Atoi(value); err != nil {
+		const errFmt = "invalid %s: %w"
+		env.errors = append(env.errors, fmt.Errorf(errFmt, varname, err))
+	} else if i < 0 {
+		const errFmt = "invalid %s: %d is negative"
+		env.errors = append(env.errors, fmt.Errorf(errFmt, varname, i))
+	} else {
+		*opt = i
+	}
+}
+
 func (env *environment) assignCapacity(opt *types.Capacity, varname string) {
 	var capStr string
 	var capRaw float64
@@ -114,7 +356,138 @@ func (env *environment) assignCapacity(opt *types.Capacity, varname string) {
 	}
 }
 
+func (env *environment) assignVerifyTokenKeys(
+	opt *ops.VerifyTokenKeys, varname string,
+) {
+	if value := env.getenv(varname); value == "" {
+		return
+	} else if keys, err := ops.ParseVerifyTokenKeys(value); err != nil {
+		const errFmt = "invalid %s: %w"
+		env.errors = append(env.errors, fmt.Errorf(errFmt, varname, err))
+	} else {
+		*opt = keys
+	}
+}
+
+func (env *environment) assignTopics(opt *[]string, varname string) {
+	if value := env.getenv(varname); value == "" {
+		return
+	} else if topics, err := parseTopicList(
+		strings.Split(value, ","),
+	); err != nil {
+		const errFmt = "invalid %s: %w"
+		env.errors = append(env.errors, fmt.Errorf(errFmt, varname, err))
+	} else {
+		*opt = topics
+	}
+}
+
+// parseTopicList trims and normalizes an allow-list of topics.
+func parseTopicList(topics []string) ([]string, error) {
+	trimmed := make([]string, 0, len(topics))
+	for _, topic := range topics {
+		if topic = strings.TrimSpace(topic); topic != "" {
+			trimmed = append(trimmed, topic)
+		}
+	}
+	return db.NormalizeTags(trimmed)
+}
+
 func (env *environment) assignPath(opt *string, varname string) {
 	env.assign(opt, varname)
 	*opt, _ = strings.CutPrefix(*opt, "/")
 }
+
+// assignLists parses the ListOptions for every named list.
+//
+// If verifyTokens is true, each list requires a verifyLinkExpired redirect
+// path.
+func (env *environment) assignLists(
+	opt *[]ListOptions, varname string, verifyTokens bool,
+) {
+	value := env.getenv(varname)
+	if value == "" {
+		return
+	}
+
+	var lists []ListOptions
+	decoder := json.NewDecoder(strings.NewReader(value))
+	decoder.DisallowUnknownFields()
+	addErr := func(err error) {
+		const errFmt = "invalid %s: %w"
+		env.errors = append(env.errors, fmt.Errorf(errFmt, varname, err))
+	}
+
+	if err := decoder.Decode(&lists); err != nil {
+		addErr(err)
+		return
+	}
+
+	names := make(map[string]bool, len(lists))
+	for i := range lists {
+		list := &lists[i]
+		if err := checkListOptions(list, names, verifyTokens); err != nil {
+			addErr(err)
+		}
+		names[list.Name] = true
+
+		paths := &list.RedirectPaths
+		for _, path := range []*string{
+			&paths.Invalid,
+			&paths.AlreadySubscribed,
+			&paths.VerifyLinkSent,
+			&paths.Subscribed,
+			&paths.NotSubscribed,
+			&paths.Unsubscribed,
+			&paths.VerifyLinkExpired,
+			&paths.TopicsUpdated,
+		} {
+			*path, _ = strings.CutPrefix(*path, "/")
+		}
+	}
+	*opt = lists
+}
+
+func checkListOptions(
+	list *ListOptions, names map[string]bool, verifyTokens bool,
+) error {
+	paths := &list.RedirectPaths
+	missing := make([]string, 0, 10)
+	addMissing := func(value, field string) {
+		if value == "" {
+			missing = append(missing, field)
+		}
+	}
+
+	if err := ops.ValidateListName(list.Name); err != nil {
+		return err
+	} else if names[list.Name] {
+		return fmt.Errorf("duplicate list name: %s", list.Name)
+	} else if topics, err := parseTopicList(list.Topics); err != nil {
+		return fmt.Errorf("list %s has invalid topics: %w", list.Name, err)
+	} else {
+		list.Topics = topics
+	}
+
+	addMissing(list.SiteTitle, "siteTitle")
+	addMissing(list.SenderName, "senderName")
+	addMissing(list.SenderUserName, "senderUserName")
+	addMissing(paths.Invalid, "redirectPaths.invalid")
+	addMissing(paths.AlreadySubscribed, "redirectPaths.alreadySubscribed")
+	addMissing(paths.VerifyLinkSent, "redirectPaths.verifyLinkSent")
+	addMissing(paths.Subscribed, "redirectPaths.subscribed")
+	addMissing(paths.NotSubscribed, "redirectPaths.notSubscribed")
+	addMissing(paths.Unsubscribed, "redirectPaths.unsubscribed")
+	if verifyTokens {
+		addMissing(paths.VerifyLinkExpired, "redirectPaths.verifyLinkExpired")
+	}
+	if len(list.Topics) != 0 {
+		addMissing(paths.TopicsUpdated, "redirectPaths.topicsUpdated")
+	}
+
+	if len(missing) != 0 {
+		const errFmt = "list %s missing: %s"
+		return fmt.Errorf(errFmt, list.Name, strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -4,8 +4,13 @@ package handler
 
 import (
 	"errors"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/mbland/elistman/agent"
+	"github.com/mbland/elistman/db"
+	"github.com/mbland/elistman/ops"
 	"github.com/mbland/elistman/testutils"
 	"github.com/mbland/elistman/types"
 	"gotest.tools/assert"
@@ -99,6 +104,11 @@ func TestAllRequiredEnvironmentVariablesDefined(t *testing.T) {
 			SubscribersTableName: "subscribers",
 			ConfigurationSet:     "config-set",
 			MaxBulkSendCapacity:  expectedCapacity,
+			Database:             DatabaseDynamoDb,
+			Mailer:               MailerSes,
+			VerifyResendCooldown: DefaultVerifyResendCooldown,
+			MaxVerifyEmails:      DefaultMaxVerifyEmails,
+			TombstonePolicy:      DefaultTombstonePolicy,
 
 			// Note that GetOptions will remove a leading '/' character from the
 			// path value.
@@ -141,6 +151,558 @@ func TestOptionsAssignCapacityAddsError(t *testing.T) {
 	})
 }
 
+func TestOptionsAssignDatabase(t *testing.T) {
+	t.Run("SucceedsWithDynamoDb", func(t *testing.T) {
+		env, getenv := testEnv()
+		env["DATABASE"] = "dynamodb"
+
+		opts, err := GetOptions(getenv)
+
+		assert.NilError(t, err)
+		assert.Equal(t, DatabaseDynamoDb, opts.Database)
+		assert.Equal(t, "", opts.PostgresUrl)
+	})
+
+	t.Run("SucceedsWithPostgres", func(t *testing.T) {
+		env, getenv := testEnv()
+		env["DATABASE"] = "postgres"
+		env["POSTGRES_URL"] = "postgres://elistman@db.mike-bland.com/elistman"
+
+		opts, err := GetOptions(getenv)
+
+		assert.NilError(t, err)
+		assert.Equal(t, DatabasePostgres, opts.Database)
+		expected := "postgres://elistman@db.mike-bland.com/elistman"
+		assert.Equal(t, expected, opts.PostgresUrl)
+	})
+
+	t.Run("FailsIfPostgresUrlUndefined", func(t *testing.T) {
+		env, getenv := testEnv()
+		env["DATABASE"] = "postgres"
+
+		opts, err := GetOptions(getenv)
+
+		assert.Assert(t, is.Nil(opts))
+		undefErr := &UndefinedEnvVarsError{}
+		assert.Assert(t, errors.As(err, &undefErr))
+		assert.DeepEqual(t, []string{"POSTGRES_URL"}, undefErr.UndefinedVars)
+	})
+
+	t.Run("FailsIfDatabaseInvalid", func(t *testing.T) {
+		env, getenv := testEnv()
+		env["DATABASE"] = "stone-tablets"
+
+		opts, err := GetOptions(getenv)
+
+		assert.Assert(t, is.Nil(opts))
+		const expectedErr = "invalid DATABASE: " +
+			`"stone-tablets" is not "dynamodb" or "postgres"`
+		assert.Error(t, err, expectedErr)
+	})
+}
+
+func TestOptionsAssignMailer(t *testing.T) {
+	t.Run("SucceedsWithSes", func(t *testing.T) {
+		env, getenv := testEnv()
+		env["MAILER"] = "ses"
+
+		opts, err := GetOptions(getenv)
+
+		assert.NilError(t, err)
+		assert.Equal(t, MailerSes, opts.Mailer)
+		assert.DeepEqual(t, SmtpOptions{}, opts.Smtp)
+	})
+
+	t.Run("SucceedsWithSmtp", func(t *testing.T) {
+		env, getenv := testEnv()
+		env["MAILER"] = "smtp"
+		env["SMTP_ADDR"] = "smtp.mike-bland.com:587"
+		env["SMTP_USERNAME"] = "elistman"
+		env["SMTP_PASSWORD_SECRET"] = "elistman-smtp-password"
+
+		opts, err := GetOptions(getenv)
+
+		assert.NilError(t, err)
+		assert.Equal(t, MailerSmtp, opts.Mailer)
+		expected := SmtpOptions{
+			Addr:           "smtp.mike-bland.com:587",
+			Username:       "elistman",
+			PasswordSecret: "elistman-smtp-password",
+		}
+		assert.DeepEqual(t, expected, opts.Smtp)
+	})
+
+	t.Run("SucceedsWithSmtpWithoutCredentials", func(t *testing.T) {
+		env, getenv := testEnv()
+		env["MAILER"] = "smtp"
+		env["SMTP_ADDR"] = "localhost:25"
+
+		opts, err := GetOptions(getenv)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, SmtpOptions{Addr: "localhost:25"}, opts.Smtp)
+	})
+
+	t.Run("FailsIfSmtpAddrUndefined", func(t *testing.T) {
+		env, getenv := testEnv()
+		env["MAILER"] = "smtp"
+
+		opts, err := GetOptions(getenv)
+
+		assert.Assert(t, is.Nil(opts))
+		undefErr := &UndefinedEnvVarsError{}
+		assert.Assert(t, errors.As(err, &undefErr))
+		assert.DeepEqual(t, []string{"SMTP_ADDR"}, undefErr.UndefinedVars)
+	})
+
+	t.Run("FailsIfOnlyUsernameOrPasswordDefined", func(t *testing.T) {
+		env, getenv := testEnv()
+		env["MAILER"] = "smtp"
+		env["SMTP_ADDR"] = "smtp.mike-bland.com:587"
+		env["SMTP_USERNAME"] = "elistman"
+
+		opts, err := GetOptions(getenv)
+
+		assert.Assert(t, is.Nil(opts))
+		const expectedErr = "SMTP_USERNAME and SMTP_PASSWORD_SECRET " +
+			"must both be defined, or neither"
+		assert.Error(t, err, expectedErr)
+	})
+
+	t.Run("FailsIfMailerInvalid", func(t *testing.T) {
+		env, getenv := testEnv()
+		env["MAILER"] = "carrier-pigeon"
+
+		opts, err := GetOptions(getenv)
+
+		assert.Assert(t, is.Nil(opts))
+		const expectedErr = "invalid MAILER: " +
+			`"carrier-pigeon" is not "ses" or "smtp"`
+		assert.Error(t, err, expectedErr)
+	})
+}
+
+func TestOptionsAssignSkipLinkConfirmation(t *testing.T) {
+	t.Run("DefaultsToFalse", func(t *testing.T) {
+		_, getenv := testEnv()
+
+		opts, err := GetOptions(getenv)
+
+		assert.NilError(t, err)
+		assert.Equal(t, false, opts.SkipLinkConfirmation)
+	})
+
+	t.Run("SucceedsIfTrue", func(t *testing.T) {
+		env, getenv := testEnv()
+		env["SKIP_LINK_CONFIRMATION"] = "true"
+
+		opts, err := GetOptions(getenv)
+
+		assert.NilError(t, err)
+		assert.Equal(t, true, opts.SkipLinkConfirmation)
+	})
+
+	t.Run("FailsIfNotBoolean", func(t *testing.T) {
+		env, getenv := testEnv()
+		env["SKIP_LINK_CONFIRMATION"] = "sometimes"
+
+		opts, err := GetOptions(getenv)
+
+		assert.Assert(t, is.Nil(opts))
+		assert.ErrorContains(t, err, "invalid SKIP_LINK_CONFIRMATION: ")
+		assert.ErrorContains(t, err, "strconv.ParseBool")
+	})
+}
+
+func TestOptionsAssignVerifyResendLimits(t *testing.T) {
+	// Note that the default case is covered by the tests above.
+
+	t.Run("Succeeds", func(t *testing.T) {
+		env, getenv := testEnv()
+		env["VERIFY_RESEND_COOLDOWN"] = "1h30m"
+		env["MAX_VERIFY_EMAILS"] = "5"
+
+		opts, err := GetOptions(getenv)
+
+		assert.NilError(t, err)
+		assert.Equal(t, 90*time.Minute, opts.VerifyResendCooldown)
+		assert.Equal(t, 5, opts.MaxVerifyEmails)
+	})
+
+	t.Run("FailsIfNotParseable", func(t *testing.T) {
+		env, getenv := testEnv()
+		env["VERIFY_RESEND_COOLDOWN"] = "a while"
+		env["MAX_VERIFY_EMAILS"] = "a few"
+
+		opts, err := GetOptions(getenv)
+
+		assert.Assert(t, is.Nil(opts))
+		assert.ErrorContains(t, err, "invalid VERIFY_RESEND_COOLDOWN: ")
+		assert.ErrorContains(t, err, "invalid MAX_VERIFY_EMAILS: ")
+		assert.ErrorContains(t, err, "strconv.Atoi")
+	})
+
+	t.Run("FailsIfNegative", func(t *testing.T) {
+		env, getenv := testEnv()
+		env["VERIFY_RESEND_COOLDOWN"] = "-1m"
+		env["MAX_VERIFY_EMAILS"] = "-1"
+
+		opts, err := GetOptions(getenv)
+
+		assert.Assert(t, is.Nil(opts))
+		const cooldownErr = "invalid VERIFY_RESEND_COOLDOWN: -1m is negative"
+		assert.ErrorContains(t, err, cooldownErr)
+		const maxErr = "invalid MAX_VERIFY_EMAILS: -1 is negative"
+		assert.ErrorContains(t, err, maxErr)
+	})
+}
+
+func TestOptionsAssignTombstonePolicy(t *testing.T) {
+	// Note that the default case is covered by the tests above.
+
+	t.Run("Succeeds", func(t *testing.T) {
+		env, getenv := testEnv()
+		env["RESUBSCRIBE_COOLDOWN"] = "1h"
+		env["REIMPORT_COOLDOWN"] = "forever"
+		env["RESTORE_COOLDOWN"] = "0"
+
+		opts, err := GetOptions(getenv)
+
+		assert.NilError(t, err)
+		expected := agent.TombstonePolicy{
+			Subscribe: time.Hour, Import: agent.TombstoneForever,
+		}
+		assert.Equal(t, expected, opts.TombstonePolicy)
+	})
+
+	t.Run("FailsIfNotParseableOrNegative", func(t *testing.T) {
+		env, getenv := testEnv()
+		env["RESUBSCRIBE_COOLDOWN"] = "a while"
+		env["REIMPORT_COOLDOWN"] = "never"
+		env["RESTORE_COOLDOWN"] = "-1h"
+
+		opts, err := GetOptions(getenv)
+
+		assert.Assert(t, is.Nil(opts))
+		assert.ErrorContains(t, err, "invalid RESUBSCRIBE_COOLDOWN: ")
+		assert.ErrorContains(t, err, "invalid REIMPORT_COOLDOWN: ")
+		const restoreErr = "invalid RESTORE_COOLDOWN: -1h is negative"
+		assert.ErrorContains(t, err, restoreErr)
+	})
+}
+
+func TestOptionsAssignErasureSalt(t *testing.T) {
+	t.Run("DefaultsToEmpty", func(t *testing.T) {
+		_, getenv := testEnv()
+
+		opts, err := GetOptions(getenv)
+
+		assert.NilError(t, err)
+		assert.Equal(t, "", opts.ErasureSalt)
+	})
+
+	t.Run("Succeeds", func(t *testing.T) {
+		env, getenv := testEnv()
+		env["ERASURE_SALT"] = "erasure salt"
+
+		opts, err := GetOptions(getenv)
+
+		assert.NilError(t, err)
+		assert.Equal(t, "erasure salt", opts.ErasureSalt)
+	})
+}
+
+func TestOptionsAssignVerifyTokenKeys(t *testing.T) {
+	secret := strings.Repeat("s", 32)
+
+	t.Run("DefaultsToEmpty", func(t *testing.T) {
+		_, getenv := testEnv()
+
+		opts, err := GetOptions(getenv)
+
+		assert.NilError(t, err)
+		assert.Equal(t, 0, len(opts.VerifyTokenKeys))
+		assert.Equal(t, "", opts.RedirectPaths.VerifyLinkExpired)
+	})
+
+	t.Run("Succeeds", func(t *testing.T) {
+		env, getenv := testEnv()
+		env["VERIFY_TOKEN_KEYS"] = "new:" + secret + ",old:" + secret
+		env["VERIFY_LINK_EXPIRED_PATH"] = "/subscribe/expired.html"
+
+		opts, err := GetOptions(getenv)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, ops.VerifyTokenKeys{
+			{Id: "new", Secret: []byte(secret)},
+			{Id: "old", Secret: []byte(secret)},
+		}, opts.VerifyTokenKeys)
+		const expectedPath = "subscribe/expired.html"
+		assert.Equal(t, expectedPath, opts.RedirectPaths.VerifyLinkExpired)
+	})
+
+	t.Run("FailsIfInvalid", func(t *testing.T) {
+		env, getenv := testEnv()
+		env["VERIFY_TOKEN_KEYS"] = "new:too-short"
+		env["VERIFY_LINK_EXPIRED_PATH"] = "/subscribe/expired.html"
+
+		opts, err := GetOptions(getenv)
+
+		assert.Assert(t, is.Nil(opts))
+		assert.Assert(
+			t, testutils.ErrorIs(err, ops.ErrInvalidVerifyTokenKeys),
+		)
+		assert.ErrorContains(t, err, "invalid VERIFY_TOKEN_KEYS: ")
+	})
+
+	t.Run("RequiresVerifyLinkExpiredPath", func(t *testing.T) {
+		env, getenv := testEnv()
+		env["VERIFY_TOKEN_KEYS"] = "new:" + secret
+
+		opts, err := GetOptions(getenv)
+
+		assert.Assert(t, is.Nil(opts))
+		const expectedErr = "undefined environment variables: " +
+			"VERIFY_LINK_EXPIRED_PATH"
+		assert.ErrorContains(t, err, expectedErr)
+	})
+
+	t.Run("RequiresVerifyLinkExpiredPathForLists", func(t *testing.T) {
+		env, getenv := testEnv()
+		env["VERIFY_TOKEN_KEYS"] = "new:" + secret
+		env["VERIFY_LINK_EXPIRED_PATH"] = "/subscribe/expired.html"
+		env["LISTS"] = `[{
+			"name": "updates",
+			"siteTitle": "Mike Bland's updates",
+			"senderName": "Mike Bland",
+			"senderUserName": "updates",
+			"redirectPaths": {
+				"invalid": "/updates/invalid",
+				"alreadySubscribed": "/updates/already-subscribed",
+				"verifyLinkSent": "/updates/verify",
+				"subscribed": "/updates/subscribed",
+				"notSubscribed": "/updates/not-subscribed",
+				"unsubscribed": "/updates/unsubscribed"
+			}
+		}]`
+
+		opts, err := GetOptions(getenv)
+
+		assert.Assert(t, is.Nil(opts))
+		const expectedErr = "invalid LISTS: list updates missing: " +
+			"redirectPaths.verifyLinkExpired"
+		assert.Error(t, err, expectedErr)
+	})
+}
+
+func TestOptionsAssignTopics(t *testing.T) {
+	t.Run("DefaultsToEmpty", func(t *testing.T) {
+		_, getenv := testEnv()
+
+		opts, err := GetOptions(getenv)
+
+		assert.NilError(t, err)
+		assert.Equal(t, 0, len(opts.Topics))
+		assert.Equal(t, "", opts.RedirectPaths.TopicsUpdated)
+	})
+
+	t.Run("Succeeds", func(t *testing.T) {
+		env, getenv := testEnv()
+		env["TOPICS"] = "releases, essays,,releases"
+		env["TOPICS_UPDATED_PATH"] = "/subscribe/topics-updated.html"
+
+		opts, err := GetOptions(getenv)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, []string{"essays", "releases"}, opts.Topics)
+		const expectedPath = "subscribe/topics-updated.html"
+		assert.Equal(t, expectedPath, opts.RedirectPaths.TopicsUpdated)
+	})
+
+	t.Run("FailsIfInvalid", func(t *testing.T) {
+		env, getenv := testEnv()
+		env["TOPICS"] = "essays,Releases!"
+		env["TOPICS_UPDATED_PATH"] = "/subscribe/topics-updated.html"
+
+		opts, err := GetOptions(getenv)
+
+		assert.Assert(t, is.Nil(opts))
+		assert.Assert(t, testutils.ErrorIs(err, db.ErrInvalidTag))
+		assert.ErrorContains(t, err, "invalid TOPICS: ")
+	})
+
+	t.Run("RequiresTopicsUpdatedPath", func(t *testing.T) {
+		env, getenv := testEnv()
+		env["TOPICS"] = "essays"
+
+		opts, err := GetOptions(getenv)
+
+		assert.Assert(t, is.Nil(opts))
+		const expectedErr = "undefined environment variables: " +
+			"TOPICS_UPDATED_PATH"
+		assert.ErrorContains(t, err, expectedErr)
+	})
+
+	listWithTopics := func(topics string) string {
+		return `[{
+			"name": "updates",
+			"siteTitle": "Mike Bland's updates",
+			"senderName": "Mike Bland",
+			"senderUserName": "updates",
+			"topics": ` + topics + `,
+			"redirectPaths": {
+				"invalid": "/updates/invalid",
+				"alreadySubscribed": "/updates/already-subscribed",
+				"verifyLinkSent": "/updates/verify",
+				"subscribed": "/updates/subscribed",
+				"notSubscribed": "/updates/not-subscribed",
+				"unsubscribed": "/updates/unsubscribed"
+			}
+		}]`
+	}
+
+	t.Run("RequiresTopicsUpdatedPathForLists", func(t *testing.T) {
+		env, getenv := testEnv()
+		env["LISTS"] = listWithTopics(`["releases"]`)
+
+		opts, err := GetOptions(getenv)
+
+		assert.Assert(t, is.Nil(opts))
+		const expectedErr = "invalid LISTS: list updates missing: " +
+			"redirectPaths.topicsUpdated"
+		assert.Error(t, err, expectedErr)
+	})
+
+	t.Run("FailsIfListTopicsInvalid", func(t *testing.T) {
+		env, getenv := testEnv()
+		env["LISTS"] = listWithTopics(`["Releases!"]`)
+
+		opts, err := GetOptions(getenv)
+
+		assert.Assert(t, is.Nil(opts))
+		assert.Assert(t, testutils.ErrorIs(err, db.ErrInvalidTag))
+		const expectedErr = "invalid LISTS: list updates has invalid topics: "
+		assert.ErrorContains(t, err, expectedErr)
+	})
+}
+
+func TestOptionsAssignLists(t *testing.T) {
+	const updatesList = `{
+		"name": "updates",
+		"siteTitle": "Mike Bland's updates",
+		"senderName": "Mike Bland",
+		"senderUserName": "updates",
+		"topics": ["releases", "news"],
+		"redirectPaths": {
+			"invalid": "/updates/invalid",
+			"alreadySubscribed": "/updates/already-subscribed",
+			"verifyLinkSent": "/updates/verify",
+			"subscribed": "/updates/subscribed",
+			"notSubscribed": "/updates/not-subscribed",
+			"unsubscribed": "/updates/unsubscribed",
+			"topicsUpdated": "/updates/topics-updated"
+		}
+	}`
+
+	t.Run("DefaultsToEmpty", func(t *testing.T) {
+		_, getenv := testEnv()
+
+		opts, err := GetOptions(getenv)
+
+		assert.NilError(t, err)
+		assert.Equal(t, 0, len(opts.Lists))
+	})
+
+	t.Run("Succeeds", func(t *testing.T) {
+		env, getenv := testEnv()
+		env["LISTS"] = "[" + updatesList + "]"
+
+		opts, err := GetOptions(getenv)
+
+		assert.NilError(t, err)
+		expected := []ListOptions{
+			{
+				Name:           "updates",
+				SiteTitle:      "Mike Bland's updates",
+				SenderName:     "Mike Bland",
+				SenderUserName: "updates",
+				Topics:         []string{"news", "releases"},
+				RedirectPaths: RedirectPaths{
+					Invalid:           "updates/invalid",
+					AlreadySubscribed: "updates/already-subscribed",
+					VerifyLinkSent:    "updates/verify",
+					Subscribed:        "updates/subscribed",
+					NotSubscribed:     "updates/not-subscribed",
+					Unsubscribed:      "updates/unsubscribed",
+					TopicsUpdated:     "updates/topics-updated",
+				},
+			},
+		}
+		assert.DeepEqual(t, expected, opts.Lists)
+	})
+
+	t.Run("FailsIfNotJson", func(t *testing.T) {
+		env, getenv := testEnv()
+		env["LISTS"] = "updates"
+
+		opts, err := GetOptions(getenv)
+
+		assert.Assert(t, is.Nil(opts))
+		assert.ErrorContains(t, err, "invalid LISTS: ")
+	})
+
+	t.Run("FailsIfUnknownField", func(t *testing.T) {
+		env, getenv := testEnv()
+		env["LISTS"] = `[{"name": "updates", "title": "Updates"}]`
+
+		opts, err := GetOptions(getenv)
+
+		assert.Assert(t, is.Nil(opts))
+		const expectedErr = `invalid LISTS: json: unknown field "title"`
+		assert.ErrorContains(t, err, expectedErr)
+	})
+
+	t.Run("FailsIfNameInvalid", func(t *testing.T) {
+		env, getenv := testEnv()
+		env["LISTS"] = `[{"name": "Updates!"}]`
+
+		opts, err := GetOptions(getenv)
+
+		assert.Assert(t, is.Nil(opts))
+		assert.Assert(t, testutils.ErrorIs(err, ops.ErrInvalidListName))
+		assert.ErrorContains(t, err, "invalid LISTS: ")
+	})
+
+	t.Run("FailsIfDuplicateName", func(t *testing.T) {
+		env, getenv := testEnv()
+		env["LISTS"] = "[" + updatesList + "," + updatesList + "]"
+
+		opts, err := GetOptions(getenv)
+
+		assert.Assert(t, is.Nil(opts))
+		const expectedErr = "invalid LISTS: duplicate list name: updates"
+		assert.Error(t, err, expectedErr)
+	})
+
+	t.Run("FailsIfFieldsMissing", func(t *testing.T) {
+		env, getenv := testEnv()
+		env["LISTS"] = `[{
+			"name": "updates",
+			"senderName": "Mike Bland",
+			"redirectPaths": {"invalid": "/updates/invalid"}
+		}]`
+
+		opts, err := GetOptions(getenv)
+
+		assert.Assert(t, is.Nil(opts))
+		const expectedErr = "invalid LISTS: list updates missing: " +
+			"siteTitle, senderUserName, redirectPaths.alreadySubscribed, " +
+			"redirectPaths.verifyLinkSent, redirectPaths.subscribed, " +
+			"redirectPaths.notSubscribed, redirectPaths.unsubscribed"
+		assert.Error(t, err, expectedErr)
+	})
+}
+
 func TestOptionsReturnsMultipleWrappedErrors(t *testing.T) {
 	env, getenv := testEnv()
 	delete(env, "SENDER_NAME")
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"mime"
@@ -12,6 +13,8 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/mbland/elistman/agent"
+	"github.com/mbland/elistman/db"
 	"github.com/mbland/elistman/ops"
 	"github.com/mbland/elistman/types"
 )
@@ -24,13 +27,33 @@ const (
 	Subscribe
 	Verify
 	Unsubscribe
+	Topics
 )
 
+// eventOperation describes a parsed request to perform an operation.
+//
+// List is empty for operations on the default list.
+//
+// Topics contains the tags that a Subscribe or Topics operation applies to
+// the subscriber, and is empty for all other operations.
+//
+// Signup describes the request for a Subscribe operation, and is nil for all
+// other operations.
+//
+// Profile contains the personalization values for a Subscribe operation, and
+// is nil for all other operations or if the request contains none.
+//
+// Expired is true for a Verify operation whose ops.VerifyToken has expired.
 type eventOperation struct {
 	Type     eventOperationType
 	Email    string
 	Uid      uuid.UUID
 	OneClick bool
+	List     string
+	Topics   []string
+	Signup   *db.SignupMetadata
+	Profile  *agent.Profile
+	Expired  bool
 }
 
 func (op *eventOperation) String() string {
@@ -39,15 +62,24 @@ func (op *eventOperation) String() string {
 
 	if op.Type == Undefined {
 		return builder.String()
-	} else if op.OneClick {
+	} else if op.List != "" {
+		builder.WriteString(" [" + op.List + "]")
+	}
+	if op.OneClick {
 		builder.WriteString(" (One-Click)")
 	}
+	if op.Expired {
+		builder.WriteString(" (Expired)")
+	}
 
 	builder.WriteString(": " + op.Email)
 
 	if op.Type != Subscribe {
 		builder.WriteString(" " + op.Uid.String())
 	}
+	if len(op.Topics) != 0 {
+		builder.WriteString(" (" + strings.Join(op.Topics, ", ") + ")")
+	}
 	return builder.String()
 }
 
@@ -64,6 +96,11 @@ func (e *ParseError) Error() string {
 	return e.Type.String() + ": " + e.Message
 }
 
+// apiRequest contains the parts of an API Gateway request that describe an
+// eventOperation.
+//
+// SourceIp, UserAgent, and Referrer are only used to populate the
+// db.SignupMetadata for Subscribe operations.
 type apiRequest struct {
 	Id          string
 	RawPath     string
@@ -71,27 +108,62 @@ type apiRequest struct {
 	ContentType string
 	Params      map[string]string
 	Body        string
+	SourceIp    string
+	UserAgent   string
+	Referrer    string
 }
 
-func parseApiRequest(req *apiRequest) (op *eventOperation, err error) {
+// parseApiRequest parses an apiRequest into an eventOperation.
+//
+// If keys isn't empty, the uid parameter of a Verify request must be an
+// ops.VerifyToken signed by keys for the same email address. If the token
+// expired before now, the result's Expired field is true.
+//
+// If the error wraps ErrUserInput, the result contains only the Type and List,
+// so the handler can redirect to that list's page for invalid requests.
+func parseApiRequest(
+	req *apiRequest, keys ops.VerifyTokenKeys, now time.Time,
+) (op *eventOperation, err error) {
 	if optype, err := parseOperationType(req.RawPath); err != nil {
 		return requestError(optype, err)
 	} else if params, err := parseParams(req); err != nil {
 		return requestError(optype, err)
 	} else if email, err := parseEmail(params); err != nil {
-		return paramError(optype, err)
-	} else if uid, err := parseUid(optype, params); err != nil {
-		return paramError(optype, err)
+		return listParamError(optype, params, err)
+	} else if uid, expired, err := parseUid(
+		optype, email, params, keys, now,
+	); err != nil {
+		return listParamError(optype, params, err)
+	} else if topics, err := parseTopics(optype, params); err != nil {
+		return listParamError(optype, params, err)
+	} else if profile, err := parseProfile(optype, params); err != nil {
+		return listParamError(optype, params, err)
 	} else {
 		return &eventOperation{
 			optype,
 			email,
 			uid,
 			isOneClickUnsubscribeRequest(optype, req, params),
+			params["list"],
+			topics,
+			parseSignupMetadata(optype, req, params),
+			profile,
+			expired,
 		}, nil
 	}
 }
 
+// listParamError returns the result of paramError. If the error wraps
+// ErrUserInput, it also returns an eventOperation identifying the list.
+func listParamError(
+	optype eventOperationType, params map[string]string, err error,
+) (op *eventOperation, _ error) {
+	if op, err = paramError(optype, err); errors.Is(err, ErrUserInput) {
+		op = &eventOperation{Type: optype, List: params["list"]}
+	}
+	return op, err
+}
+
 func requestError(
 	optype eventOperationType, err error,
 ) (*eventOperation, error) {
@@ -115,6 +187,8 @@ func parseOperationType(endpoint string) (eventOperationType, error) {
 		return Verify, nil
 	} else if strings.HasPrefix(endpoint, ops.ApiPrefixUnsubscribe) {
 		return Unsubscribe, nil
+	} else if strings.HasPrefix(endpoint, ops.ApiPrefixTopics) {
+		return Topics, nil
 	}
 	return Undefined, fmt.Errorf("unknown endpoint: %s", endpoint)
 }
@@ -124,7 +198,9 @@ func parseParams(req *apiRequest) (map[string]string, error) {
 	values := url.Values{}
 	var err error
 
-	if req.Method == http.MethodPost {
+	// A confirmation page form has no fields, and a client may send its POST
+	// request without a content-type, so only parse a nonempty body.
+	if req.Method == http.MethodPost && req.Body != "" {
 		if values, err = parseBody(req.ContentType, req.Body); err != nil {
 			errFmt := `failed to parse body params with content-type %q: %s`
 			return nil, fmt.Errorf(errFmt, req.ContentType, err)
@@ -134,6 +210,11 @@ func parseParams(req *apiRequest) (map[string]string, error) {
 	}
 
 	for k, v := range values {
+		// A form may present topics as checkboxes sharing the same name.
+		if k == topicsParam && len(v) > 1 {
+			v = []string{strings.Join(v, ",")}
+		}
+
 		if len(v) != 1 {
 			values := strings.Join(v, ", ")
 			return nil, fmt.Errorf("multiple values for %q: %s", k, values)
@@ -193,12 +274,143 @@ func parseEmail(params map[string]string) (string, error) {
 }
 
 func parseUid(
-	optype eventOperationType, params map[string]string,
-) (uuid.UUID, error) {
+	optype eventOperationType,
+	email string,
+	params map[string]string,
+	keys ops.VerifyTokenKeys,
+	now time.Time,
+) (uid uuid.UUID, expired bool, err error) {
 	if optype == Subscribe {
-		return uuid.Nil, nil
+		return
+	} else if optype != Verify || len(keys) == 0 {
+		uid, err = parseParam(params, "uid", uuid.Nil, uuid.Parse)
+		return
+	}
+
+	var token *ops.VerifyToken
+	parseToken := func(signed string) (t *ops.VerifyToken, err error) {
+		if t, err = keys.Parse(signed); err == nil && t.Email != email {
+			err = fmt.Errorf("token is for %s", t.Email)
+		}
+		return
+	}
+
+	if token, err = parseParam(params, "uid", nil, parseToken); err == nil {
+		uid, expired = token.Uid, token.Expired(now)
+	}
+	return
+}
+
+// topicsParam is the name of the Subscribe and Topics parameter containing the
+// topics to apply as db.Subscriber.Tags.
+//
+// Its value is a comma separated list of topics. It may also appear more than
+// once, such as when a form presents each topic as a checkbox.
+const topicsParam = "topics"
+
+// maxTopics limits how many topics a subscriber may choose, which limits the
+// size of the resulting database record.
+const maxTopics = 32
+
+func parseTopics(
+	optype eventOperationType, params map[string]string,
+) (topics []string, err error) {
+	value := params[topicsParam]
+
+	if (optype != Subscribe && optype != Topics) || value == "" {
+		return
+	}
+	for _, topic := range strings.Split(value, ",") {
+		if topic = strings.TrimSpace(topic); topic != "" {
+			topics = append(topics, topic)
+		}
+	}
+
+	if len(topics) > maxTopics {
+		const errFmt = "too many topics: %d (max %d)"
+		return nil, fmt.Errorf(errFmt, len(topics), maxTopics)
+	} else if topics, err = db.NormalizeTags(topics); err != nil {
+		err = fmt.Errorf("invalid %s parameter: %w", topicsParam, err)
+	}
+	return
+}
+
+// firstNameParam is the name of the Subscribe parameter containing the
+// subscriber's agent.Profile.FirstName.
+const firstNameParam = "first_name"
+
+// maxFirstNameLength limits the length of the first name, which limits the
+// size of the resulting database record.
+const maxFirstNameLength = 256
+
+// parseProfile returns the agent.Profile for a Subscribe request.
+//
+// It returns nil for other operations, or if there's no first name. It doesn't
+// accept any Attributes, which only Import may set.
+func parseProfile(
+	optype eventOperationType, params map[string]string,
+) (*agent.Profile, error) {
+	firstName := strings.TrimSpace(params[firstNameParam])
+
+	if optype != Subscribe || firstName == "" {
+		return nil, nil
+	} else if len(firstName) > maxFirstNameLength {
+		const errFmt = "%s parameter longer than %d bytes"
+		return nil, fmt.Errorf(errFmt, firstNameParam, maxFirstNameLength)
+	}
+	return &agent.Profile{FirstName: firstName}, nil
+}
+
+// signupParams maps the Subscribe parameters identifying the form or campaign
+// that produced a request to the corresponding db.SignupMetadata fields.
+func signupParams(m *db.SignupMetadata) map[string]*string {
+	return map[string]*string{
+		"source":       &m.Source,
+		"utm_source":   &m.UtmSource,
+		"utm_medium":   &m.UtmMedium,
+		"utm_campaign": &m.UtmCampaign,
+		"utm_term":     &m.UtmTerm,
+		"utm_content":  &m.UtmContent,
+	}
+}
+
+// maxSignupValueLength limits the length of each db.SignupMetadata field, which
+// limits the size of the resulting database record.
+const maxSignupValueLength = 256
+
+// parseSignupMetadata returns the db.SignupMetadata for a Subscribe request.
+//
+// These values are informational only, so it truncates values that are too
+// long instead of rejecting the request. It returns nil for other operations,
+// or if there's no metadata at all.
+func parseSignupMetadata(
+	optype eventOperationType, req *apiRequest, params map[string]string,
+) *db.SignupMetadata {
+	if optype != Subscribe {
+		return nil
 	}
-	return parseParam(params, "uid", uuid.Nil, uuid.Parse)
+
+	signup := &db.SignupMetadata{
+		Referrer:  truncateSignupValue(req.Referrer),
+		SourceIp:  truncateSignupValue(req.SourceIp),
+		UserAgent: truncateSignupValue(req.UserAgent),
+	}
+	for name, field := range signupParams(signup) {
+		*field = truncateSignupValue(strings.TrimSpace(params[name]))
+	}
+
+	if *signup == (db.SignupMetadata{}) {
+		return nil
+	}
+	return signup
+}
+
+func truncateSignupValue(value string) string {
+	if len(value) <= maxSignupValueLength {
+		return value
+	}
+	// Don't leave a partial UTF-8 sequence at the end.
+	return strings.ToValidUTF8(value[:maxSignupValueLength], "")
 }
 
 func parseEmailAddress(emailParam string) (email string, err error) {
@@ -209,7 +421,7 @@ func parseEmailAddress(emailParam string) (email string, err error) {
 	}
 }
 
-func parseParam[T string | uuid.UUID](
+func parseParam[T string | uuid.UUID | *ops.VerifyToken](
 	params map[string]string,
 	name string,
 	nilValue T,
@@ -238,6 +450,7 @@ func isOneClickUnsubscribeRequest(
 type parsedSubject struct {
 	Email string
 	Uid   uuid.UUID
+	List  string
 }
 
 type mailtoEvent struct {
@@ -264,7 +477,15 @@ func parseMailtoEvent(
 		return nil, err
 	} else {
 		return &eventOperation{
-			Unsubscribe, subject.Email, subject.Uid, true,
+			Unsubscribe,
+			subject.Email,
+			subject.Uid,
+			true,
+			subject.List,
+			nil,
+			nil,
+			nil,
+			false,
 		}, nil
 	}
 }
@@ -290,17 +511,37 @@ func checkForOnlyOneAddress(headerName string, addrs []string) (err error) {
 	return
 }
 
+// parseEmailSubject parses the subject of a mailto: unsubscribe request.
+//
+// The subject is "<email> <uid>" for the default list, and "<email> <uid>
+// <list>" for a named list. See ops.UnsubscribeMailto.
 func parseEmailSubject(subject string) (result *parsedSubject, err error) {
 	result = &parsedSubject{}
 	params := strings.Split(subject, " ")
+	list := ""
+
+	if len(params) == 3 {
+		list = params[2]
+		params = params[:2]
+	}
+
 	if len(params) != 2 || params[0] == "" || params[1] == "" {
 		err = fmt.Errorf(`subject not in "<email> <uid>" format: "%s"`, subject)
 	} else if email, emailErr := parseEmailAddress(params[0]); emailErr != nil {
 		err = fmt.Errorf("invalid email address: %s: %s", params[0], emailErr)
 	} else if uid, uidErr := uuid.Parse(params[1]); uidErr != nil {
 		err = fmt.Errorf("invalid uid: %s: %s", params[1], uidErr)
+	} else if listErr := validateOptionalListName(list); listErr != nil {
+		err = listErr
 	} else {
-		result = &parsedSubject{email, uid}
+		result = &parsedSubject{email, uid, list}
 	}
 	return
 }
+
+func validateOptionalListName(list string) error {
+	if list == "" {
+		return nil
+	}
+	return ops.ValidateListName(list)
+}
@@ -11,8 +11,11 @@ import (
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/mbland/elistman/agent"
+	"github.com/mbland/elistman/db"
 	"github.com/mbland/elistman/ops"
 	"github.com/mbland/elistman/testutils"
 	"gotest.tools/assert"
@@ -65,6 +68,50 @@ func TestEventOperationString(t *testing.T) {
 		expected := "Unsubscribe (One-Click): mbland@acm.org " + testValidUidStr
 		assert.Equal(t, expected, op.String())
 	})
+
+	t.Run("ExpiredVerify", func(t *testing.T) {
+		op := &eventOperation{
+			Type:    Verify,
+			Email:   "mbland@acm.org",
+			Uid:     testValidUid,
+			Expired: true,
+		}
+
+		expected := "Verify (Expired): mbland@acm.org " + testValidUidStr
+		assert.Equal(t, expected, op.String())
+	})
+
+	t.Run("SubscribeToList", func(t *testing.T) {
+		op := &eventOperation{
+			Type: Subscribe, Email: "mbland@acm.org", List: "updates",
+		}
+
+		assert.Equal(t, "Subscribe [updates]: mbland@acm.org", op.String())
+	})
+
+	t.Run("SubscribeWithTopics", func(t *testing.T) {
+		op := &eventOperation{
+			Type:   Subscribe,
+			Email:  "mbland@acm.org",
+			Topics: []string{"essays", "releases"},
+		}
+
+		expected := "Subscribe: mbland@acm.org (essays, releases)"
+		assert.Equal(t, expected, op.String())
+	})
+
+	t.Run("TopicsWithTopics", func(t *testing.T) {
+		op := &eventOperation{
+			Type:   Topics,
+			Email:  "mbland@acm.org",
+			Uid:    testValidUid,
+			Topics: []string{"essays", "releases"},
+		}
+
+		expected := "Topics: mbland@acm.org " + testValidUidStr +
+			" (essays, releases)"
+		assert.Equal(t, expected, op.String())
+	})
 }
 
 func TestParseErrorIncludesOptypeAndMessage(t *testing.T) {
@@ -238,6 +285,20 @@ func TestParseParams(t *testing.T) {
 		assert.DeepEqual(t, parsedParams, result)
 	})
 
+	t.Run("SuccessWithEmptyPostWithoutContentType", func(t *testing.T) {
+		req := newRequest()
+		req.ContentType = ""
+		req.Body = ""
+		req.Params = map[string]string{
+			"email": "mbland%40acm.org", "uid": "0123-456-789",
+		}
+
+		result, err := parseParams(req)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, parsedParams, result)
+	})
+
 	t.Run("ErrorIfBodyPresentForNonPostRequest", func(t *testing.T) {
 		req := newRequest()
 		req.Method = http.MethodGet
@@ -285,6 +346,16 @@ func TestParseParams(t *testing.T) {
 		assert.Assert(t, is.Nil(result))
 	})
 
+	t.Run("JoinsMultipleTopicsValues", func(t *testing.T) {
+		req := newRequest()
+		req.Body = "email=mbland%40acm.org&topics=releases&topics=essays"
+
+		result, err := parseParams(req)
+
+		assert.NilError(t, err)
+		assert.Equal(t, "releases,essays", result["topics"])
+	})
+
 	t.Run("ErrorIfUnescapingPathParameterFails", func(t *testing.T) {
 		req := newRequest()
 		req.Body = ""
@@ -322,6 +393,13 @@ func TestParseOperationType(t *testing.T) {
 		assert.Equal(t, "Unsubscribe", result.String())
 	})
 
+	t.Run("Topics", func(t *testing.T) {
+		result, err := parseOperationType(ops.ApiPrefixTopics + "/foobar")
+
+		assert.NilError(t, err)
+		assert.Equal(t, "Topics", result.String())
+	})
+
 	t.Run("Undefined", func(t *testing.T) {
 		result, err := parseOperationType("/foobar/baz")
 
@@ -359,7 +437,9 @@ func TestParseEmail(t *testing.T) {
 
 func TestParseUid(t *testing.T) {
 	t.Run("IgnoreSubscribeOp", func(t *testing.T) {
-		result, err := parseUid(Subscribe, map[string]string{})
+		result, _, err := parseUid(
+			Subscribe, "", map[string]string{}, nil, time.Time{},
+		)
 
 		assert.NilError(t, err)
 		assert.Equal(t, uuid.Nil, result)
@@ -369,12 +449,17 @@ func TestParseUid(t *testing.T) {
 		expected, err := uuid.Parse("00000000-1111-2222-3333-444444444444")
 		assert.NilError(t, err)
 
-		result, err := parseUid(
-			Verify, map[string]string{"uid": expected.String()},
+		result, expired, err := parseUid(
+			Verify,
+			"mbland@acm.org",
+			map[string]string{"uid": expected.String()},
+			nil,
+			time.Time{},
 		)
 
 		assert.NilError(t, err)
 		assert.Equal(t, expected, result)
+		assert.Equal(t, false, expired)
 	})
 }
 
@@ -416,10 +501,14 @@ func TestIsOneClickSubscribeRequest(t *testing.T) {
 }
 
 func TestParseApiRequest(t *testing.T) {
+	parse := func(req *apiRequest) (*eventOperation, error) {
+		return parseApiRequest(req, nil, time.Now())
+	}
+
 	t.Run("Unknown", func(t *testing.T) {
 		var parseError *ParseError
 
-		result, err := parseApiRequest(&apiRequest{
+		result, err := parse(&apiRequest{
 			RawPath: "/foobar", Params: map[string]string{},
 		})
 
@@ -440,7 +529,7 @@ func TestParseApiRequest(t *testing.T) {
 			Body:        "email=mbland%40acm.org&email=foo%40bar.com",
 		}
 
-		result, err := parseApiRequest(req)
+		result, err := parse(req)
 
 		assert.Assert(t, is.Nil(result))
 		assert.Assert(t, errors.As(err, &parseError))
@@ -451,20 +540,31 @@ func TestParseApiRequest(t *testing.T) {
 	})
 
 	t.Run("UserInputForEmailInvalid", func(t *testing.T) {
-		result, err := parseApiRequest(&apiRequest{
+		result, err := parse(&apiRequest{
 			RawPath: ops.ApiPrefixSubscribe,
 			Params:  map[string]string{"email": "foobar"},
 		})
 
-		assert.Assert(t, is.Nil(result))
+		assert.DeepEqual(t, &eventOperation{Type: Subscribe}, result)
 		assert.Assert(t, testutils.ErrorIs(err, ErrUserInput))
 		assert.ErrorContains(t, err, "invalid email parameter: foobar")
 	})
 
+	t.Run("UserInputErrorIdentifiesList", func(t *testing.T) {
+		result, err := parse(&apiRequest{
+			RawPath: ops.ApiPrefixSubscribe,
+			Params:  map[string]string{"email": "foobar", "list": "updates"},
+		})
+
+		expected := &eventOperation{Type: Subscribe, List: "updates"}
+		assert.DeepEqual(t, expected, result)
+		assert.Assert(t, testutils.ErrorIs(err, ErrUserInput))
+	})
+
 	t.Run("PathParameterForUidInvalid", func(t *testing.T) {
 		var parseError *ParseError
 
-		result, err := parseApiRequest(&apiRequest{
+		result, err := parse(&apiRequest{
 			RawPath: ops.ApiPrefixVerify + "mbland@acm.org/0123456789",
 			Params: map[string]string{
 				"email": "mbland@acm.org", "uid": "0123456789",
@@ -486,16 +586,249 @@ func TestParseApiRequest(t *testing.T) {
 			Body:        "email=mbland%40acm.org",
 		}
 
-		result, err := parseApiRequest(req)
+		result, err := parse(req)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(
+			t, result, &eventOperation{
+				Subscribe,
+				"mbland@acm.org",
+				uuid.Nil,
+				false,
+				"",
+				nil,
+				nil,
+				nil,
+				false,
+			},
+		)
+	})
+
+	t.Run("SuccessfulSubscribeToList", func(t *testing.T) {
+		req := &apiRequest{
+			RawPath:     ops.ApiPrefixSubscribe + "/updates",
+			Params:      map[string]string{"list": "updates"},
+			Method:      http.MethodPost,
+			ContentType: "application/x-www-form-urlencoded",
+			Body:        "email=mbland%40acm.org",
+		}
+
+		result, err := parse(req)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(
+			t, result, &eventOperation{
+				Subscribe,
+				"mbland@acm.org",
+				uuid.Nil,
+				false,
+				"updates",
+				nil,
+				nil,
+				nil,
+				false,
+			},
+		)
+	})
+
+	t.Run("SuccessfulSubscribeWithTopics", func(t *testing.T) {
+		req := &apiRequest{
+			RawPath:     ops.ApiPrefixSubscribe,
+			Params:      map[string]string{},
+			Method:      http.MethodPost,
+			ContentType: "application/x-www-form-urlencoded",
+			Body: "email=mbland%40acm.org" +
+				"&topics=releases,+essays&topics=releases",
+		}
+
+		result, err := parse(req)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, []string{"essays", "releases"}, result.Topics)
+	})
+
+	t.Run("SuccessfulSubscribeWithSignupMetadata", func(t *testing.T) {
+		req := &apiRequest{
+			RawPath:     ops.ApiPrefixSubscribe,
+			Params:      map[string]string{},
+			Method:      http.MethodPost,
+			ContentType: "application/x-www-form-urlencoded",
+			Body: "email=mbland%40acm.org&source=footer" +
+				"&utm_source=newsletter&utm_medium=email" +
+				"&utm_campaign=launch&utm_term=go&utm_content=button",
+			SourceIp:  "192.0.2.1",
+			UserAgent: "Mozilla/5.0",
+			Referrer:  "https://mike-bland.com/",
+		}
+
+		result, err := parse(req)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, &db.SignupMetadata{
+			Source:      "footer",
+			UtmSource:   "newsletter",
+			UtmMedium:   "email",
+			UtmCampaign: "launch",
+			UtmTerm:     "go",
+			UtmContent:  "button",
+			Referrer:    "https://mike-bland.com/",
+			SourceIp:    "192.0.2.1",
+			UserAgent:   "Mozilla/5.0",
+		}, result.Signup)
+	})
+
+	t.Run("TruncatesLongSignupMetadata", func(t *testing.T) {
+		// Make sure truncation doesn't split the multibyte "é".
+		userAgent := strings.Repeat("a", maxSignupValueLength-1) + "é"
+
+		result, err := parse(&apiRequest{
+			RawPath:   ops.ApiPrefixSubscribe,
+			Params:    map[string]string{"email": "mbland@acm.org"},
+			UserAgent: userAgent,
+		})
+
+		assert.NilError(t, err)
+		expected := strings.Repeat("a", maxSignupValueLength-1)
+		assert.Equal(t, expected, result.Signup.UserAgent)
+	})
+
+	t.Run("NoSignupMetadataForOtherOperations", func(t *testing.T) {
+		const uidStr = "00000000-1111-2222-3333-444444444444"
+
+		result, err := parse(&apiRequest{
+			RawPath: ops.ApiPrefixVerify,
+			Params: map[string]string{
+				"email": "mbland@acm.org", "uid": uidStr,
+			},
+			SourceIp: "192.0.2.1",
+		})
+
+		assert.NilError(t, err)
+		assert.Assert(t, is.Nil(result.Signup))
+	})
+
+	t.Run("SuccessfulSubscribeWithFirstName", func(t *testing.T) {
+		req := &apiRequest{
+			RawPath:     ops.ApiPrefixSubscribe,
+			Params:      map[string]string{},
+			Method:      http.MethodPost,
+			ContentType: "application/x-www-form-urlencoded",
+			Body:        "email=mbland%40acm.org&first_name=+Mike+",
+		}
+
+		result, err := parse(req)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, &agent.Profile{FirstName: "Mike"}, result.Profile)
+	})
+
+	t.Run("NoProfileForOtherOperations", func(t *testing.T) {
+		const uidStr = "00000000-1111-2222-3333-444444444444"
+
+		result, err := parse(&apiRequest{
+			RawPath: ops.ApiPrefixVerify,
+			Params: map[string]string{
+				"email": "mbland@acm.org", "uid": uidStr, "first_name": "Mike",
+			},
+		})
+
+		assert.NilError(t, err)
+		assert.Assert(t, is.Nil(result.Profile))
+	})
+
+	t.Run("UserInputForFirstNameTooLong", func(t *testing.T) {
+		result, err := parse(&apiRequest{
+			RawPath: ops.ApiPrefixSubscribe,
+			Params: map[string]string{
+				"email":      "mbland@acm.org",
+				"first_name": strings.Repeat("a", maxFirstNameLength+1),
+			},
+		})
+
+		assert.DeepEqual(t, &eventOperation{Type: Subscribe}, result)
+		assert.Assert(t, testutils.ErrorIs(err, ErrUserInput))
+		const expectedErr = "first_name parameter longer than 256 bytes"
+		assert.ErrorContains(t, err, expectedErr)
+	})
+
+	t.Run("UserInputForTopicsInvalid", func(t *testing.T) {
+		result, err := parse(&apiRequest{
+			RawPath: ops.ApiPrefixSubscribe,
+			Params: map[string]string{
+				"email": "mbland@acm.org", "topics": "releases,Essays!",
+			},
+		})
+
+		assert.DeepEqual(t, &eventOperation{Type: Subscribe}, result)
+		assert.Assert(t, testutils.ErrorIs(err, ErrUserInput))
+		const expectedErr = `invalid topics parameter: invalid tag: "Essays!"`
+		assert.ErrorContains(t, err, expectedErr)
+	})
+
+	t.Run("UserInputForTooManyTopics", func(t *testing.T) {
+		topics := make([]string, maxTopics+1)
+		for i := range topics {
+			topics[i] = fmt.Sprintf("topic-%d", i)
+		}
+
+		result, err := parse(&apiRequest{
+			RawPath: ops.ApiPrefixSubscribe,
+			Params: map[string]string{
+				"email": "mbland@acm.org", "topics": strings.Join(topics, ","),
+			},
+		})
+
+		assert.DeepEqual(t, &eventOperation{Type: Subscribe}, result)
+		assert.Assert(t, testutils.ErrorIs(err, ErrUserInput))
+		assert.ErrorContains(t, err, "too many topics: 33 (max 32)")
+	})
+
+	t.Run("SuccessfulTopicsUpdate", func(t *testing.T) {
+		req := &apiRequest{
+			RawPath: ops.ApiPrefixTopics + "mbland@acm.org/" +
+				testValidUidStr,
+			Params: map[string]string{
+				"email": "mbland@acm.org", "uid": testValidUidStr,
+			},
+			Method:      http.MethodPost,
+			ContentType: "application/x-www-form-urlencoded",
+			Body:        "topics=releases&topics=essays",
+		}
+
+		result, err := parse(req)
 
 		assert.NilError(t, err)
 		assert.DeepEqual(
 			t, result, &eventOperation{
-				Subscribe, "mbland@acm.org", uuid.Nil, false,
+				Topics,
+				"mbland@acm.org",
+				testValidUid,
+				false,
+				"",
+				[]string{"essays", "releases"},
+				nil,
+				nil,
+				false,
 			},
 		)
 	})
 
+	t.Run("InvalidTopicsUpdateIsNotUserInput", func(t *testing.T) {
+		result, err := parse(&apiRequest{
+			RawPath: ops.ApiPrefixTopics,
+			Params: map[string]string{
+				"email":  "mbland@acm.org",
+				"uid":    testValidUidStr,
+				"topics": "Essays!",
+			},
+		})
+
+		assert.Assert(t, is.Nil(result))
+		assert.Assert(t, !errors.Is(err, ErrUserInput))
+		const expectedErr = `invalid topics parameter: invalid tag: "Essays!"`
+		assert.ErrorContains(t, err, expectedErr)
+	})
+
 	t.Run("SuccessfulOneClickUnsubscribe", func(t *testing.T) {
 		// The "email" and "uid" are path parameters. "List-Unsubscribe" is
 		// parsed from the body.
@@ -511,12 +844,97 @@ func TestParseApiRequest(t *testing.T) {
 			Body:        "List-Unsubscribe=One-Click",
 		}
 
-		result, err := parseApiRequest(req)
+		result, err := parse(req)
 
 		assert.NilError(t, err)
 		assert.DeepEqual(t, result, &eventOperation{
-			Unsubscribe, "mbland@acm.org", uuid.MustParse(uidStr), true,
+			Unsubscribe,
+			"mbland@acm.org",
+			uuid.MustParse(uidStr),
+			true,
+			"",
+			nil,
+			nil,
+			nil,
+			false,
+		})
+	})
+}
+
+func TestParseApiRequestWithVerifyTokens(t *testing.T) {
+	now := time.Date(2023, time.September, 18, 12, 0, 0, 0, time.UTC)
+	keys := testVerifyTokenKeys
+	const email = "mbland@acm.org"
+
+	verifyRequest := func(uid string) *apiRequest {
+		return &apiRequest{
+			RawPath: ops.ApiPrefixVerify + email + "/" + uid,
+			Params:  map[string]string{"email": email, "uid": uid},
+		}
+	}
+	sign := func(email string, expires time.Time) string {
+		return keys.Sign(&ops.VerifyToken{
+			Email: email, Uid: testValidUid, Expires: expires,
 		})
+	}
+
+	t.Run("SucceedsWithValidToken", func(t *testing.T) {
+		req := verifyRequest(sign(email, now.Add(time.Hour)))
+
+		result, err := parseApiRequest(req, keys, now)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, &eventOperation{
+			Type: Verify, Email: email, Uid: testValidUid,
+		}, result)
+	})
+
+	t.Run("MarksExpiredToken", func(t *testing.T) {
+		req := verifyRequest(sign(email, now))
+
+		result, err := parseApiRequest(req, keys, now)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, &eventOperation{
+			Type: Verify, Email: email, Uid: testValidUid, Expired: true,
+		}, result)
+	})
+
+	t.Run("FailsIfTokenMissing", func(t *testing.T) {
+		var parseError *ParseError
+
+		req := verifyRequest(testValidUidStr)
+
+		result, err := parseApiRequest(req, keys, now)
+
+		assert.Assert(t, is.Nil(result))
+		assert.Assert(t, errors.As(err, &parseError))
+		assert.Equal(t, Verify, parseError.Type)
+		assert.ErrorContains(t, err, "invalid uid parameter")
+		assert.ErrorContains(t, err, "invalid verify token: wrong format")
+	})
+
+	t.Run("FailsIfTokenForAnotherAddress", func(t *testing.T) {
+		req := verifyRequest(sign("foo@bar.com", now.Add(time.Hour)))
+
+		result, err := parseApiRequest(req, keys, now)
+
+		assert.Assert(t, is.Nil(result))
+		assert.ErrorContains(t, err, "token is for foo@bar.com")
+	})
+
+	t.Run("UnsubscribeStillUsesUid", func(t *testing.T) {
+		req := &apiRequest{
+			RawPath: ops.ApiPrefixUnsubscribe + email + "/" + testValidUidStr,
+			Params: map[string]string{
+				"email": email, "uid": testValidUidStr,
+			},
+		}
+
+		result, err := parseApiRequest(req, keys, now)
+
+		assert.NilError(t, err)
+		assert.Equal(t, testValidUid, result.Uid)
 	})
 }
 
@@ -626,7 +1044,21 @@ func TestParseEmailSubject(t *testing.T) {
 		result, err := parseEmailSubject(email + " " + uidStr)
 
 		assert.NilError(t, err)
-		assert.DeepEqual(t, &parsedSubject{email, uid}, result)
+		assert.DeepEqual(t, &parsedSubject{email, uid, ""}, result)
+	})
+
+	t.Run("SuccessWithList", func(t *testing.T) {
+		result, err := parseEmailSubject(email + " " + uidStr + " updates")
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, &parsedSubject{email, uid, "updates"}, result)
+	})
+
+	t.Run("InvalidList", func(t *testing.T) {
+		result, err := parseEmailSubject(email + " " + uidStr + " Updates!")
+
+		assert.DeepEqual(t, nilSubject, result)
+		assert.Assert(t, testutils.ErrorIs(err, ops.ErrInvalidListName))
 	})
 }
 
@@ -664,7 +1096,11 @@ func TestParseMailtoEvent(t *testing.T) {
 
 		assert.NilError(t, err)
 		assert.DeepEqual(
-			t, &eventOperation{Unsubscribe, email, uid, true}, result,
+			t,
+			&eventOperation{
+				Unsubscribe, email, uid, true, "", nil, nil, nil, false,
+			},
+			result,
 		)
 	})
 }
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	awsevents "github.com/aws/aws-lambda-go/events"
+	"github.com/mbland/elistman/agent"
+)
+
+// ScheduledEventDetailType is the detail-type of events produced by
+// EventBridge schedules.
+const ScheduledEventDetailType = "Scheduled Event"
+
+type scheduledHandler struct {
+	Agent agent.SubscriptionAgent
+	Lists listAgents
+	Log   *log.Logger
+}
+
+// HandleEvent sends any scheduled messages that are due for every list.
+//
+// Like snsHandler.HandleEvent, it only logs errors. The schedule will fire
+// again soon enough, and returning an error would only cause Lambda to retry
+// the same event.
+//
+// If the deadline approaches while sending to one list, HandleEvent doesn't try
+// to send to any of the remaining lists. Their scheduled messages remain for
+// the next scheduled event.
+func (h *scheduledHandler) HandleEvent(
+	ctx context.Context, e *awsevents.EventBridgeEvent,
+) {
+	if e.DetailType != ScheduledEventDetailType {
+		const errFmt = "unexpected EventBridge event: %s: %s"
+		h.Log.Printf(errFmt, e.DetailType, string(e.Detail))
+		return
+	}
+
+	names := h.Lists.names()
+	if h.sendScheduled(ctx, "", h.Agent) {
+		h.logSkippedLists(names)
+		return
+	}
+
+	for i, name := range names {
+		if h.sendScheduled(ctx, name, h.Lists[name]) {
+			h.logSkippedLists(names[i+1:])
+			return
+		}
+	}
+}
+
+// sendScheduled returns true if the deadline approached before it could send
+// every scheduled message that's due.
+func (h *scheduledHandler) sendScheduled(
+	ctx context.Context, list string, a agent.SubscriptionAgent,
+) (deadlineReached bool) {
+	numSent, err := a.SendScheduled(ctx)
+	prefix := "scheduled sends"
+
+	if list != "" {
+		prefix += " [" + list + "]"
+	}
+
+	if err != nil {
+		const errFmt = "%s failed after sending to %d: %s"
+		h.Log.Printf(errFmt, prefix, numSent, err)
+		deadlineReached = errors.Is(err, agent.ErrSendDeadlineApproaching)
+	} else if numSent != 0 {
+		h.Log.Printf("%s: num sent: %d", prefix, numSent)
+	}
+	return
+}
+
+func (h *scheduledHandler) logSkippedLists(names []string) {
+	if len(names) != 0 {
+		const msgFmt = "scheduled sends: deadline approaching, " +
+			"skipped lists until the next scheduled event: %s"
+		h.Log.Printf(msgFmt, strings.Join(names, ", "))
+	}
+}
@@ -0,0 +1,147 @@
+//go:build small_tests || all_tests
+
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	awsevents "github.com/aws/aws-lambda-go/events"
+	"github.com/mbland/elistman/agent"
+	"github.com/mbland/elistman/testutils"
+	"gotest.tools/assert"
+)
+
+func TestScheduledHandler(t *testing.T) {
+	setup := func() (
+		*scheduledHandler, *testAgent, *testutils.Logs, context.Context,
+	) {
+		logs, logger := testutils.NewLogs()
+		agent := &testAgent{}
+		ctx := context.Background()
+		return &scheduledHandler{agent, listAgents{}, logger}, agent, logs, ctx
+	}
+
+	deadlineErr := agent.ErrSendDeadlineApproaching
+	scheduledEvent := &awsevents.EventBridgeEvent{
+		DetailType: ScheduledEventDetailType,
+		Source:     "aws.events",
+		Detail:     json.RawMessage("{}"),
+	}
+
+	t.Run("SendsScheduledMessages", func(t *testing.T) {
+		handler, agent, logs, ctx := setup()
+		agent.NumSent = 27
+
+		handler.HandleEvent(ctx, scheduledEvent)
+
+		expectedCalls := []testAgentCalls{{Method: "SendScheduled"}}
+		assert.DeepEqual(t, expectedCalls, agent.Calls)
+		logs.AssertContains(t, "scheduled sends: num sent: 27")
+	})
+
+	t.Run("LogsNothingIfNothingSent", func(t *testing.T) {
+		handler, agent, logs, ctx := setup()
+
+		handler.HandleEvent(ctx, scheduledEvent)
+
+		expectedCalls := []testAgentCalls{{Method: "SendScheduled"}}
+		assert.DeepEqual(t, expectedCalls, agent.Calls)
+		assert.Equal(t, "", logs.Builder.String())
+	})
+
+	t.Run("LogsErrors", func(t *testing.T) {
+		handler, agent, logs, ctx := setup()
+		agent.NumSent = 1
+		agent.Error = errors.New("SendScheduled failed")
+
+		handler.HandleEvent(ctx, scheduledEvent)
+
+		logs.AssertContains(
+			t,
+			"scheduled sends failed after sending to 1: SendScheduled failed",
+		)
+	})
+
+	t.Run("SendsScheduledMessagesForEveryList", func(t *testing.T) {
+		handler, agent, logs, ctx := setup()
+		fooAgent := &testAgent{NumSent: 2}
+		barAgent := &testAgent{NumSent: 3}
+		handler.Lists = listAgents{"foo": fooAgent, "bar": barAgent}
+
+		handler.HandleEvent(ctx, scheduledEvent)
+
+		expectedCalls := []testAgentCalls{{Method: "SendScheduled"}}
+		assert.DeepEqual(t, expectedCalls, agent.Calls)
+		assert.DeepEqual(t, expectedCalls, fooAgent.Calls)
+		assert.DeepEqual(t, expectedCalls, barAgent.Calls)
+		logs.AssertContains(t, "scheduled sends [bar]: num sent: 3")
+		logs.AssertContains(t, "scheduled sends [foo]: num sent: 2")
+	})
+
+	t.Run("StopsSendingToListsOnceDeadlineApproaches", func(t *testing.T) {
+		handler, agent, logs, ctx := setup()
+		barAgent := &testAgent{NumSent: 3}
+		bazAgent := &testAgent{}
+		fooAgent := &testAgent{}
+		handler.Lists = listAgents{
+			"bar": barAgent, "baz": bazAgent, "foo": fooAgent,
+		}
+		barAgent.Error = fmt.Errorf("scheduled message due: %w", deadlineErr)
+
+		handler.HandleEvent(ctx, scheduledEvent)
+
+		expectedCalls := []testAgentCalls{{Method: "SendScheduled"}}
+		assert.DeepEqual(t, expectedCalls, agent.Calls)
+		assert.DeepEqual(t, expectedCalls, barAgent.Calls)
+		assert.Equal(t, 0, len(bazAgent.Calls))
+		assert.Equal(t, 0, len(fooAgent.Calls))
+		logs.AssertContains(
+			t, "scheduled sends [bar] failed after sending to 3: ",
+		)
+		logs.AssertContains(
+			t,
+			"scheduled sends: deadline approaching, "+
+				"skipped lists until the next scheduled event: baz, foo",
+		)
+	})
+
+	t.Run("SkipsAllListsIfDeadlineApproachesForDefaultList",
+		func(t *testing.T) {
+			handler, agent, logs, ctx := setup()
+			fooAgent := &testAgent{}
+			handler.Lists = listAgents{"foo": fooAgent}
+			agent.Error = deadlineErr
+
+			handler.HandleEvent(ctx, scheduledEvent)
+
+			assert.Equal(t, 0, len(fooAgent.Calls))
+			logs.AssertContains(
+				t,
+				"scheduled sends: deadline approaching, "+
+					"skipped lists until the next scheduled event: foo",
+			)
+		},
+	)
+
+	t.Run("IgnoresUnexpectedEventBridgeEvents", func(t *testing.T) {
+		handler, agent, logs, ctx := setup()
+		event := &awsevents.EventBridgeEvent{
+			DetailType: "EC2 Instance State-change Notification",
+			Detail:     json.RawMessage(`{"state": "running"}`),
+		}
+
+		handler.HandleEvent(ctx, event)
+
+		assert.Equal(t, 0, len(agent.Calls))
+		logs.AssertContains(
+			t,
+			"unexpected EventBridge event: "+
+				"EC2 Instance State-change Notification: "+
+				`{"state": "running"}`,
+		)
+	})
+}
@@ -4,17 +4,24 @@ import (
 	"context"
 	"encoding/json"
 	"log"
+	"net/mail"
 	"strings"
 
 	awsevents "github.com/aws/aws-lambda-go/events"
 	"github.com/mbland/elistman/agent"
+	"github.com/mbland/elistman/db"
 	"github.com/mbland/elistman/events"
 	"github.com/mbland/elistman/ops"
 )
 
+// snsHandler handles SES event notifications received via SNS.
+//
+// Senders maps the sender addresses of named lists to their agents. Events for
+// messages from any other sender go to Agent, which serves the default list.
 type snsHandler struct {
-	Agent agent.SubscriptionAgent
-	Log   *log.Logger
+	Agent   agent.SubscriptionAgent
+	Senders listAgents
+	Log     *log.Logger
 }
 
 // https://docs.aws.amazon.com/ses/latest/dg/event-publishing-retrieving-sns-contents.html
@@ -36,12 +43,26 @@ func (h *snsHandler) parseSesEvent(message string) (
 	event := &events.SesEventRecord{}
 	if err = json.Unmarshal([]byte(message), event); err == nil {
 		handler = &sesEventHandler{
-			Event: event, Details: message, Agent: h.Agent, Log: h.Log,
+			Event:   event,
+			Details: message,
+			Agent:   h.agentForSender(event.Mail.CommonHeaders.From),
+			Log:     h.Log,
 		}
 	}
 	return
 }
 
+func (h *snsHandler) agentForSender(froms []string) agent.SubscriptionAgent {
+	if len(froms) != 1 {
+		return h.Agent
+	} else if sender, err := mail.ParseAddress(froms[0]); err != nil {
+		return h.Agent
+	} else if a, ok := h.Senders[sender.Address]; ok {
+		return a
+	}
+	return h.Agent
+}
+
 type sesEventHandler struct {
 	Event   *events.SesEventRecord
 	Details string
@@ -51,6 +72,8 @@ type sesEventHandler struct {
 
 func (evh *sesEventHandler) HandleEvent(ctx context.Context) {
 	event := evh.Event
+	ctx = agent.WithAuditInfo(ctx, db.AuditSourceSns, event.Mail.MessageID)
+
 	switch evh.Event.EventType {
 	case "Bounce":
 		evh.handleBounceEvent(ctx)
@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	awsevents "github.com/aws/aws-lambda-go/events"
+	"github.com/mbland/elistman/db"
 	"github.com/mbland/elistman/ops"
 	"github.com/mbland/elistman/testutils"
 	"gotest.tools/assert"
@@ -26,7 +27,8 @@ func newSnsHandlerFixture() *snsHandlerFixture {
 	agent := &testAgent{}
 	ctx := context.Background()
 
-	return &snsHandlerFixture{agent, logs, &snsHandler{agent, logger}, ctx}
+	handler := &snsHandler{agent, listAgents{}, logger}
+	return &snsHandlerFixture{agent, logs, handler, ctx}
 }
 
 // This and other test messages adapted from:
@@ -335,6 +337,8 @@ func TestHandleBounceEvent(t *testing.T) {
 		assertRecipientRemoved(
 			t, f.agent, "Remove", "recipient@example.com", reasonBounce,
 		)
+		assert.Equal(t, db.AuditSourceSns, f.agent.AuditSource)
+		assert.Equal(t, "EXAMPLE7c191be45", f.agent.RequestId)
 	})
 }
 
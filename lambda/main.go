@@ -5,14 +5,17 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"net/smtp"
 	"os"
 	"time"
 
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
 	"github.com/aws/aws-sdk-go-v2/service/ses"
 	"github.com/aws/aws-sdk-go-v2/service/sesv2"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/mbland/elistman/agent"
 	"github.com/mbland/elistman/db"
 	"github.com/mbland/elistman/email"
@@ -31,68 +34,220 @@ func buildHandler() (h *handler.Handler, err error) {
 	}
 
 	sesv2Client := sesv2.NewFromConfig(cfg)
-	throttle, err := email.NewSesThrottle(
-		context.Background(),
-		sesv2Client,
-		opts.MaxBulkSendCapacity,
-		time.Sleep,
-		time.Now,
-		time.Minute, // Could be configurable one day.
-	)
+	var mailer email.Mailer
 
-	if err != nil {
+	if mailer, err = newMailer(cfg, opts, sesv2Client); err != nil {
 		return
 	}
 
 	suppressor := &email.SesSuppressor{Client: sesv2Client}
 	logger := log.Default()
+	var database listDatabase
+	var forList func(list string) listDatabase
+
+	database, forList, err = newDatabase(cfg, opts, logger)
+	if err != nil {
+		return
+	}
+
+	defaultAgent := &agent.ProdAgent{
+		SenderAddress: senderAddress(
+			opts.SenderName, opts.SenderUserName, opts.EmailDomainName,
+		),
+		EmailSiteTitle:  opts.EmailSiteTitle,
+		EmailDomainName: opts.EmailDomainName,
+		UnsubscribeEmail: opts.UnsubscribeUserName +
+			"@" + opts.EmailDomainName,
+		UnsubscribeUrl: fmt.Sprintf(
+			"https://%s/%s", opts.EmailDomainName, opts.UnsubscribeFormPath,
+		),
+		ApiBaseUrl: fmt.Sprintf(
+			"https://%s/%s", opts.ApiDomainName, opts.ApiMappingKey,
+		),
+		VerifyResendCooldown: opts.VerifyResendCooldown,
+		MaxVerifyEmails:      opts.MaxVerifyEmails,
+		TombstonePolicy:      opts.TombstonePolicy,
+		ErasureSalt:          opts.ErasureSalt,
+		VerifyTokenKeys:      opts.VerifyTokenKeys,
+		NewUid:               uuid.NewRandom,
+		CurrentTime:          time.Now,
+		Db:                   database,
+		Checkpoints:          database,
+		Campaigns:            database,
+		Schedules:            database,
+		Audit:                database,
+		Tombstones:           database,
+		Erasures:             database,
+		Welcome:              database,
+		Validator: &email.ProdAddressValidator{
+			Suppressor: suppressor,
+			Resolver:   net.DefaultResolver,
+		},
+		Mailer:     mailer,
+		Suppressor: suppressor,
+		Log:        logger,
+	}
 
 	h, err = handler.NewHandler(
 		opts.EmailDomainName,
+		"https://"+opts.EmailDomainName,
 		opts.EmailSiteTitle,
-		&agent.ProdAgent{
-			SenderAddress: fmt.Sprintf(
-				"%s <%s@%s>",
-				opts.SenderName,
-				opts.SenderUserName,
-				opts.EmailDomainName,
-			),
-			EmailSiteTitle:  opts.EmailSiteTitle,
-			EmailDomainName: opts.EmailDomainName,
-			UnsubscribeEmail: opts.UnsubscribeUserName +
-				"@" + opts.EmailDomainName,
-			UnsubscribeUrl: fmt.Sprintf(
-				"https://%s/%s", opts.EmailDomainName, opts.UnsubscribeFormPath,
-			),
-			ApiBaseUrl: fmt.Sprintf(
-				"https://%s/%s", opts.ApiDomainName, opts.ApiMappingKey,
-			),
-			NewUid:      uuid.NewUUID,
-			CurrentTime: time.Now,
-			Db:          db.NewDynamoDb(cfg, opts.SubscribersTableName),
-			Validator: &email.ProdAddressValidator{
-				Suppressor: suppressor,
-				Resolver:   net.DefaultResolver,
-			},
-			Mailer: &email.SesMailer{
-				Client:    sesv2Client,
-				ConfigSet: opts.ConfigurationSet,
-				Throttle:  throttle,
-			},
-			Suppressor: suppressor,
-			Log:        logger,
-		},
+		defaultAgent,
 		opts.RedirectPaths,
+		opts.Topics,
 		handler.ResponseTemplate,
+		!opts.SkipLinkConfirmation,
+		opts.VerifyTokenKeys,
 		opts.UnsubscribeUserName,
 		&email.SesBouncer{
 			Client: ses.NewFromConfig(cfg),
 		},
 		logger,
 	)
+
+	for i := 0; err == nil && i != len(opts.Lists); i++ {
+		err = h.AddList(newList(&opts.Lists[i], opts, defaultAgent, forList))
+	}
 	return
 }
 
+// listDatabase comprises every interface that ProdAgent requires of the
+// database for a list.
+type listDatabase interface {
+	db.Database
+	db.CheckpointStore
+	db.CampaignStore
+	db.ScheduleStore
+	db.AuditLog
+	db.TombstoneStore
+	db.ErasureStore
+	db.WelcomeStore
+}
+
+// postgresExpiryInterval is how often db.PostgresDb.RunExpiryJob deletes
+// expired pending subscribers. Could be configurable one day.
+const postgresExpiryInterval = time.Hour
+
+// newDatabase returns the database for the default list selected by
+// opts.Database, and a function returning the database for a named list.
+//
+// For handler.DatabasePostgres, it starts the job that expires pending
+// subscribers in the background.
+func newDatabase(
+	cfg aws.Config, opts *handler.Options, logger *log.Logger,
+) (database listDatabase, forList func(string) listDatabase, err error) {
+	if opts.Database != handler.DatabasePostgres {
+		dynDb := db.NewDynamoDb(cfg, opts.SubscribersTableName)
+		forList = func(list string) listDatabase { return dynDb.ForList(list) }
+		return dynDb, forList, nil
+	}
+
+	ctx := context.Background()
+	var pool *pgxpool.Pool
+
+	if pool, err = pgxpool.New(ctx, opts.PostgresUrl); err != nil {
+		return nil, nil, fmt.Errorf("failed to configure PostgreSQL: %w", err)
+	}
+	pgDb := db.NewPostgresDb(pool, opts.SubscribersTableName)
+	go pgDb.RunExpiryJob(ctx, postgresExpiryInterval, logger)
+
+	forList = func(list string) listDatabase { return pgDb.ForList(list) }
+	return pgDb, forList, nil
+}
+
+func senderAddress(name, userName, domainName string) string {
+	return fmt.Sprintf("%s <%s@%s>", name, userName, domainName)
+}
+
+// newList creates a handler.List whose agent is a copy of defaultAgent that
+// uses the list's own settings and subscriber data.
+func newList(
+	listOpts *handler.ListOptions,
+	opts *handler.Options,
+	defaultAgent *agent.ProdAgent,
+	forList func(list string) listDatabase,
+) *handler.List {
+	listAgent := *defaultAgent
+	listDb := forList(listOpts.Name)
+
+	listAgent.List = listOpts.Name
+	listAgent.SenderAddress = senderAddress(
+		listOpts.SenderName, listOpts.SenderUserName, opts.EmailDomainName,
+	)
+	listAgent.EmailSiteTitle = listOpts.SiteTitle
+	listAgent.Db = listDb
+	listAgent.Checkpoints = listDb
+	listAgent.Campaigns = listDb
+	listAgent.Schedules = listDb
+	listAgent.Audit = listDb
+	listAgent.Tombstones = listDb
+	listAgent.Erasures = listDb
+	listAgent.Welcome = listDb
+
+	return &handler.List{
+		Name:          listOpts.Name,
+		SiteTitle:     listOpts.SiteTitle,
+		SenderAddress: listAgent.SenderAddress,
+		Agent:         &listAgent,
+		Paths:         listOpts.RedirectPaths,
+		Topics:        listOpts.Topics,
+	}
+}
+
+func newMailer(
+	cfg aws.Config, opts *handler.Options, sesv2Client *sesv2.Client,
+) (email.Mailer, error) {
+	if opts.Mailer == handler.MailerSmtp {
+		return newSmtpMailer(cfg, opts)
+	}
+
+	throttle, err := email.NewSesThrottle(
+		context.Background(),
+		sesv2Client,
+		opts.MaxBulkSendCapacity,
+		time.Sleep,
+		time.Now,
+		time.Minute, // Could be configurable one day.
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &email.SesMailer{
+		Client:    sesv2Client,
+		ConfigSet: opts.ConfigurationSet,
+		Throttle:  throttle,
+	}, nil
+}
+
+// newSmtpMailer returns an email.SmtpMailer, retrieving the password from
+// AWS Secrets Manager if opts.Smtp specifies a username.
+func newSmtpMailer(
+	cfg aws.Config, opts *handler.Options,
+) (*email.SmtpMailer, error) {
+	mailer := &email.SmtpMailer{
+		Addr:      opts.Smtp.Addr,
+		Sender:    opts.SenderUserName + "@" + opts.EmailDomainName,
+		LocalName: opts.EmailDomainName,
+	}
+
+	if opts.Smtp.Username != "" {
+		password, err := ops.GetSecretString(
+			context.Background(),
+			secretsmanager.NewFromConfig(cfg),
+			opts.Smtp.PasswordSecret,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get SMTP password: %w", err)
+		}
+
+		// If Addr fails to parse, host will be empty. SmtpMailer.Send will
+		// report the error.
+		host, _, _ := net.SplitHostPort(opts.Smtp.Addr)
+		mailer.Auth = smtp.PlainAuth("", opts.Smtp.Username, password, host)
+	}
+	return mailer, nil
+}
+
 func main() {
 	// Disable standard logger flags. The CloudWatch logs show that the Lambda
 	// runtime already adds a timestamp at the beginning of every log line
@@ -1,27 +1,69 @@
 package ops
 
 import (
+	"fmt"
 	"net/url"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/mbland/elistman/types"
 )
 
 const (
 	ApiPrefixSubscribe   = "/subscribe"
 	ApiPrefixVerify      = "/verify/"
 	ApiPrefixUnsubscribe = "/unsubscribe/"
+	ApiPrefixTopics      = "/topics/"
 )
 
-func VerifyUrl(apiBaseUrl, emailAddr string, uid uuid.UUID) string {
-	return makeApiUrl(apiBaseUrl, ApiPrefixVerify, emailAddr, uid)
+// VerifyUrl, UnsubscribeUrl, and TopicsUrl return API URLs for a subscriber to
+// a list.
+//
+// If list is empty, the URLs refer to the default list, e.g.:
+//
+//	https://api.example.com/email/verify/EMAIL/UID
+//
+// Otherwise the list name follows the operation:
+//
+//	https://api.example.com/email/verify/LIST/EMAIL/UID
+//
+// If keys isn't empty, VerifyUrl replaces the UID with a VerifyToken signed by
+// keys that expires at the expires argument. Otherwise it ignores expires.
+func VerifyUrl(
+	apiBaseUrl, list, emailAddr string,
+	uid uuid.UUID,
+	keys VerifyTokenKeys,
+	expires time.Time,
+) string {
+	id := uid.String()
+
+	if len(keys) != 0 {
+		id = keys.Sign(&VerifyToken{emailAddr, uid, expires})
+	}
+	return makeApiUrl(apiBaseUrl, ApiPrefixVerify, list, emailAddr, id)
+}
+
+func UnsubscribeUrl(apiBaseUrl, list, emailAddr string, uid uuid.UUID) string {
+	return makeApiUrl(
+		apiBaseUrl, ApiPrefixUnsubscribe, list, emailAddr, uid.String(),
+	)
 }
 
-func UnsubscribeUrl(apiBaseUrl, emailAddr string, uid uuid.UUID) string {
-	return makeApiUrl(apiBaseUrl, ApiPrefixUnsubscribe, emailAddr, uid)
+func TopicsUrl(apiBaseUrl, list, emailAddr string, uid uuid.UUID) string {
+	return makeApiUrl(
+		apiBaseUrl, ApiPrefixTopics, list, emailAddr, uid.String(),
+	)
 }
 
-func UnsubscribeMailto(unsubEmail, emailAddr string, uid uuid.UUID) string {
+// UnsubscribeMailto returns a mailto: URL for unsubscribing from a list.
+//
+// The subject is of the form "EMAIL UID", or "EMAIL UID LIST" if list isn't
+// empty.
+func UnsubscribeMailto(
+	unsubEmail, list, emailAddr string, uid uuid.UUID,
+) string {
 	sb := strings.Builder{}
 	sb.WriteString("mailto:")
 	sb.WriteString(unsubEmail)
@@ -29,15 +71,44 @@ func UnsubscribeMailto(unsubEmail, emailAddr string, uid uuid.UUID) string {
 	sb.WriteString(url.QueryEscape(emailAddr))
 	sb.WriteString("%20")
 	sb.WriteString(uid.String())
+
+	if list != "" {
+		sb.WriteString("%20")
+		sb.WriteString(list)
+	}
 	return sb.String()
 }
 
-func makeApiUrl(baseUrl, opPrefix, emailAddr string, uid uuid.UUID) string {
+func makeApiUrl(baseUrl, opPrefix, list, emailAddr, id string) string {
 	sb := strings.Builder{}
 	sb.WriteString(strings.TrimSuffix(baseUrl, "/"))
 	sb.WriteString(opPrefix)
+
+	if list != "" {
+		sb.WriteString(list)
+		sb.WriteString("/")
+	}
 	sb.WriteString(url.PathEscape(emailAddr))
 	sb.WriteString("/")
-	sb.WriteString(uid.String())
+	sb.WriteString(id)
 	return sb.String()
 }
+
+// ErrInvalidListName indicates that a list name doesn't match listNameRegexp.
+const ErrInvalidListName = types.SentinelError("invalid list name")
+
+// List names appear in URL paths, mailto: subjects, and database keys, so they
+// may only contain lowercase letters, digits, and hyphens.
+var listNameRegexp = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+const maxListNameLength = 64
+
+// ValidateListName returns ErrInvalidListName if name isn't a valid list name.
+//
+// The empty string refers to the default list, and isn't a valid list name.
+func ValidateListName(name string) error {
+	if len(name) > maxListNameLength || !listNameRegexp.MatchString(name) {
+		return fmt.Errorf("%w: %q", ErrInvalidListName, name)
+	}
+	return nil
+}
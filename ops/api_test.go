@@ -5,9 +5,12 @@ package ops
 import (
 	"net/mail"
 	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/mbland/elistman/testdata"
+	tu "github.com/mbland/elistman/testutils"
 	"gotest.tools/assert"
 )
 
@@ -71,13 +74,37 @@ func TestApiEndpoints(t *testing.T) {
 
 	t.Run("VerifyUrl", func(t *testing.T) {
 		assert.Equal(
-			t, expectedUrl(ApiPrefixVerify), VerifyUrl(baseUrl, email, uid),
+			t,
+			expectedUrl(ApiPrefixVerify),
+			VerifyUrl(baseUrl, "", email, uid, nil, time.Time{}),
 		)
 	})
 
 	t.Run("VerifyUrlTrimsBaseUrlTrailingSlash", func(t *testing.T) {
 		assert.Equal(
-			t, expectedUrl(ApiPrefixVerify), VerifyUrl(baseUrl+"/", email, uid),
+			t,
+			expectedUrl(ApiPrefixVerify),
+			VerifyUrl(baseUrl+"/", "", email, uid, nil, time.Time{}),
+		)
+	})
+
+	t.Run("VerifyUrlWithList", func(t *testing.T) {
+		assert.Equal(
+			t,
+			expectedUrl(ApiPrefixVerify+"news/"),
+			VerifyUrl(baseUrl, "news", email, uid, nil, time.Time{}),
+		)
+	})
+
+	t.Run("VerifyUrlWithToken", func(t *testing.T) {
+		expires := time.Date(2023, time.September, 18, 12, 0, 0, 0, time.UTC)
+		token := testVerifyTokenKeys.Sign(&VerifyToken{email, uid, expires})
+		expected := baseUrl + ApiPrefixVerify + uriEncodedEmail + "/" + token
+
+		assert.Equal(
+			t,
+			expected,
+			VerifyUrl(baseUrl, "", email, uid, testVerifyTokenKeys, expires),
 		)
 	})
 
@@ -85,7 +112,28 @@ func TestApiEndpoints(t *testing.T) {
 		assert.Equal(
 			t,
 			expectedUrl(ApiPrefixUnsubscribe),
-			UnsubscribeUrl(baseUrl, email, uid))
+			UnsubscribeUrl(baseUrl, "", email, uid))
+	})
+
+	t.Run("UnsubscribeUrlWithList", func(t *testing.T) {
+		assert.Equal(
+			t,
+			expectedUrl(ApiPrefixUnsubscribe+"news/"),
+			UnsubscribeUrl(baseUrl, "news", email, uid))
+	})
+
+	t.Run("TopicsUrl", func(t *testing.T) {
+		assert.Equal(
+			t,
+			expectedUrl(ApiPrefixTopics),
+			TopicsUrl(baseUrl, "", email, uid))
+	})
+
+	t.Run("TopicsUrlWithList", func(t *testing.T) {
+		assert.Equal(
+			t,
+			expectedUrl(ApiPrefixTopics+"news/"),
+			TopicsUrl(baseUrl, "news", email, uid))
 	})
 
 	t.Run("UnsubscribeMailto", func(t *testing.T) {
@@ -93,6 +141,36 @@ func TestApiEndpoints(t *testing.T) {
 		const expected = "mailto:" + unsubEmail +
 			"?subject=" + queryEncodedEmail + "%20" + uidStr
 
-		assert.Equal(t, expected, UnsubscribeMailto(unsubEmail, email, uid))
+		assert.Equal(
+			t, expected, UnsubscribeMailto(unsubEmail, "", email, uid),
+		)
+	})
+
+	t.Run("UnsubscribeMailtoWithList", func(t *testing.T) {
+		const unsubEmail = "unsubscribe@foo.com"
+		const expected = "mailto:" + unsubEmail +
+			"?subject=" + queryEncodedEmail + "%20" + uidStr + "%20news"
+
+		assert.Equal(
+			t, expected, UnsubscribeMailto(unsubEmail, "news", email, uid),
+		)
+	})
+}
+
+func TestValidateListName(t *testing.T) {
+	t.Run("Succeeds", func(t *testing.T) {
+		assert.NilError(t, ValidateListName("news"))
+		assert.NilError(t, ValidateListName("weekly-news-2"))
+	})
+
+	t.Run("FailsIfInvalid", func(t *testing.T) {
+		for _, name := range []string{
+			"", "News", "news/weekly", "-news", "news-", "news--weekly",
+			strings.Repeat("n", maxListNameLength+1),
+		} {
+			err := ValidateListName(name)
+
+			assert.Assert(t, tu.ErrorIs(err, ErrInvalidListName), name)
+		}
 	})
 }
@@ -7,6 +7,7 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
 	"github.com/aws/smithy-go"
 )
 
@@ -39,3 +40,31 @@ func MustLoadDefaultAwsConfig() (cfg aws.Config) {
 	}
 	return
 }
+
+// SecretsManagerApi is the subset of the secretsmanager.Client API that
+// GetSecretString uses.
+type SecretsManagerApi interface {
+	GetSecretValue(
+		context.Context,
+		*secretsmanager.GetSecretValueInput,
+		...func(*secretsmanager.Options),
+	) (*secretsmanager.GetSecretValueOutput, error)
+}
+
+// GetSecretString returns the string value of the AWS Secrets Manager secret
+// with the specified name or ARN.
+func GetSecretString(
+	ctx context.Context, client SecretsManagerApi, secretId string,
+) (value string, err error) {
+	input := &secretsmanager.GetSecretValueInput{SecretId: aws.String(secretId)}
+	var output *secretsmanager.GetSecretValueOutput
+
+	if output, err = client.GetSecretValue(ctx, input); err != nil {
+		err = AwsError("failed to get secret "+secretId, err)
+	} else if output.SecretString == nil {
+		err = fmt.Errorf("secret %s has no string value", secretId)
+	} else {
+		value = *output.SecretString
+	}
+	return
+}
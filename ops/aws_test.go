@@ -3,11 +3,14 @@
 package ops
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"testing"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
 	"github.com/aws/smithy-go"
 	"github.com/mbland/elistman/testutils"
 	"gotest.tools/assert"
@@ -113,3 +116,64 @@ func TestLoadDefaultAwsConfig(t *testing.T) {
 		_ = MustLoadDefaultAwsConfig()
 	})
 }
+
+type testSecretsManager struct {
+	input  *secretsmanager.GetSecretValueInput
+	output *secretsmanager.GetSecretValueOutput
+	err    error
+}
+
+func (sm *testSecretsManager) GetSecretValue(
+	_ context.Context,
+	input *secretsmanager.GetSecretValueInput,
+	_ ...func(*secretsmanager.Options),
+) (*secretsmanager.GetSecretValueOutput, error) {
+	sm.input = input
+	return sm.output, sm.err
+}
+
+func TestGetSecretString(t *testing.T) {
+	const secretId = "elistman-smtp-password"
+	ctx := context.Background()
+
+	t.Run("Succeeds", func(t *testing.T) {
+		client := &testSecretsManager{
+			output: &secretsmanager.GetSecretValueOutput{
+				SecretString: aws.String("secret"),
+			},
+		}
+
+		value, err := GetSecretString(ctx, client, secretId)
+
+		assert.NilError(t, err)
+		assert.Equal(t, "secret", value)
+		assert.Equal(t, secretId, aws.ToString(client.input.SecretId))
+	})
+
+	t.Run("FailsIfSecretHasNoStringValue", func(t *testing.T) {
+		client := &testSecretsManager{
+			output: &secretsmanager.GetSecretValueOutput{
+				SecretBinary: []byte("secret"),
+			},
+		}
+
+		value, err := GetSecretString(ctx, client, secretId)
+
+		assert.Equal(t, "", value)
+		assert.Error(t, err, "secret "+secretId+" has no string value")
+	})
+
+	t.Run("FailsIfGetSecretValueFails", func(t *testing.T) {
+		client := &testSecretsManager{
+			err: &smithy.GenericAPIError{
+				Message: "service unavailable", Fault: smithy.FaultServer,
+			},
+		}
+
+		value, err := GetSecretString(ctx, client, secretId)
+
+		assert.Equal(t, "", value)
+		assert.ErrorContains(t, err, "failed to get secret "+secretId+": ")
+		assert.Assert(t, testutils.ErrorIs(err, ErrExternal))
+	})
+}
@@ -14,11 +14,13 @@ func _() {
 	_ = x[Subscribed-3]
 	_ = x[NotSubscribed-4]
 	_ = x[Unsubscribed-5]
+	_ = x[VerifyLinkExpired-6]
+	_ = x[TopicsUpdated-7]
 }
 
-const _OperationResult_name = "InvalidAlreadySubscribedVerifyLinkSentSubscribedNotSubscribedUnsubscribed"
+const _OperationResult_name = "InvalidAlreadySubscribedVerifyLinkSentSubscribedNotSubscribedUnsubscribedVerifyLinkExpiredTopicsUpdated"
 
-var _OperationResult_index = [...]uint8{0, 7, 24, 38, 48, 61, 73}
+var _OperationResult_index = [...]uint8{0, 7, 24, 38, 48, 61, 73, 90, 103}
 
 func (i OperationResult) String() string {
 	if i < 0 || i >= OperationResult(len(_OperationResult_index)-1) {
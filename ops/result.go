@@ -10,4 +10,6 @@ const (
 	Subscribed
 	NotSubscribed
 	Unsubscribed
+	VerifyLinkExpired
+	TopicsUpdated
 )
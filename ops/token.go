@@ -0,0 +1,169 @@
+package ops
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mbland/elistman/types"
+)
+
+// VerifyToken identifies a pending subscriber in a verification link, and
+// determines how long the link remains valid.
+type VerifyToken struct {
+	Email   string
+	Uid     uuid.UUID
+	Expires time.Time
+}
+
+// Expired returns true if now is at or after t.Expires.
+func (t *VerifyToken) Expired(now time.Time) bool {
+	return !now.Before(t.Expires)
+}
+
+// VerifyTokenKey is a secret key for signing and checking VerifyTokens.
+//
+// Each signed token contains the Id of the key that signed it.
+type VerifyTokenKey struct {
+	Id     string
+	Secret []byte
+}
+
+// VerifyTokenKeys sign and check VerifyTokens using HMAC-SHA256.
+//
+// The first key signs every new token. Every key may check a token, so long as
+// the token contains its Id. To rotate keys, add a new key at the front, then
+// remove the old key once every token it signed has expired.
+//
+// A signed token has the form "ID.PAYLOAD.SIGNATURE", where PAYLOAD and
+// SIGNATURE use unpadded base64url encoding, so it's safe to use as a URL path
+// segment without escaping. PAYLOAD contains the token's UID, expiration time,
+// and email address, in that order.
+type VerifyTokenKeys []VerifyTokenKey
+
+// ErrInvalidVerifyToken indicates that VerifyTokenKeys.Parse couldn't parse a
+// signed token, or that its signature didn't match.
+const ErrInvalidVerifyToken = types.SentinelError("invalid verify token")
+
+// ErrInvalidVerifyTokenKeys indicates that ParseVerifyTokenKeys couldn't parse
+// a list of VerifyTokenKeys.
+const ErrInvalidVerifyTokenKeys = types.SentinelError(
+	"invalid verify token keys",
+)
+
+// Key IDs appear in every token, so keep them short, and keep them from
+// containing the '.' separator.
+var verifyTokenKeyIdRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]{1,16}$`)
+
+// minVerifyTokenSecretLength is the minimum number of bytes in a
+// VerifyTokenKey.Secret, matching the size of the HMAC-SHA256 output.
+const minVerifyTokenSecretLength = sha256.Size
+
+// uidLength and expiresLength are the sizes of the fixed length fields at the
+// beginning of a signed token's payload.
+const (
+	uidLength     = len(uuid.UUID{})
+	expiresLength = 8
+)
+
+// ParseVerifyTokenKeys parses a comma separated list of "ID:SECRET" pairs.
+//
+// Each ID may contain only letters, digits, hyphens, and underscores, and must
+// be unique. Each SECRET must be at least 32 bytes long.
+func ParseVerifyTokenKeys(value string) (keys VerifyTokenKeys, err error) {
+	ids := map[string]bool{}
+
+	for _, pair := range strings.Split(value, ",") {
+		id, secret, _ := strings.Cut(strings.TrimSpace(pair), ":")
+
+		if !verifyTokenKeyIdRegexp.MatchString(id) {
+			const errFmt = "%w: invalid key ID: %q"
+			return nil, fmt.Errorf(errFmt, ErrInvalidVerifyTokenKeys, id)
+		} else if ids[id] {
+			const errFmt = "%w: duplicate key ID: %s"
+			return nil, fmt.Errorf(errFmt, ErrInvalidVerifyTokenKeys, id)
+		} else if len(secret) < minVerifyTokenSecretLength {
+			const errFmt = "%w: secret for key %s is %d bytes, minimum is %d"
+			return nil, fmt.Errorf(
+				errFmt,
+				ErrInvalidVerifyTokenKeys,
+				id,
+				len(secret),
+				minVerifyTokenSecretLength,
+			)
+		}
+		ids[id] = true
+		keys = append(keys, VerifyTokenKey{id, []byte(secret)})
+	}
+	return
+}
+
+// Sign returns token signed by the first of keys.
+//
+// keys must not be empty.
+func (keys VerifyTokenKeys) Sign(token *VerifyToken) string {
+	key := &keys[0]
+	payload := make([]byte, uidLength+expiresLength, 64)
+	copy(payload, token.Uid[:])
+	expires := uint64(token.Expires.Unix())
+	binary.BigEndian.PutUint64(payload[uidLength:], expires)
+	payload = append(payload, token.Email...)
+
+	signed := key.Id + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + key.signature(signed)
+}
+
+// Parse returns the VerifyToken from a signed token if one of keys signed it.
+//
+// Otherwise it returns ErrInvalidVerifyToken. It doesn't check whether the
+// token has expired; see VerifyToken.Expired.
+func (keys VerifyTokenKeys) Parse(signed string) (*VerifyToken, error) {
+	parts := strings.Split(signed, ".")
+	var key *VerifyTokenKey
+	var payload []byte
+	var err error
+
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: wrong format", ErrInvalidVerifyToken)
+	} else if key = keys.find(parts[0]); key == nil {
+		const errFmt = "%w: unknown key ID: %s"
+		return nil, fmt.Errorf(errFmt, ErrInvalidVerifyToken, parts[0])
+	} else if !hmac.Equal(
+		[]byte(parts[2]), []byte(key.signature(parts[0]+"."+parts[1])),
+	) {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidVerifyToken)
+	} else if payload, err = base64.RawURLEncoding.DecodeString(
+		parts[1],
+	); err != nil || len(payload) <= uidLength+expiresLength {
+		// This should only happen if a key was used to sign something else.
+		return nil, fmt.Errorf("%w: bad payload", ErrInvalidVerifyToken)
+	}
+
+	expires := binary.BigEndian.Uint64(payload[uidLength:])
+	return &VerifyToken{
+		Email:   string(payload[uidLength+expiresLength:]),
+		Uid:     uuid.UUID(payload[:uidLength]),
+		Expires: time.Unix(int64(expires), 0).UTC(),
+	}, nil
+}
+
+func (keys VerifyTokenKeys) find(id string) *VerifyTokenKey {
+	for i := range keys {
+		if keys[i].Id == id {
+			return &keys[i]
+		}
+	}
+	return nil
+}
+
+func (key *VerifyTokenKey) signature(signed string) string {
+	mac := hmac.New(sha256.New, key.Secret)
+	mac.Write([]byte(signed))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,147 @@
+//go:build small_tests || all_tests
+
+package ops
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	tu "github.com/mbland/elistman/testutils"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+var testVerifyTokenKeys = VerifyTokenKeys{
+	{"new", []byte(strings.Repeat("n", minVerifyTokenSecretLength))},
+	{"old", []byte(strings.Repeat("o", minVerifyTokenSecretLength))},
+}
+
+func TestVerifyTokenExpired(t *testing.T) {
+	expires := time.Date(2023, time.September, 18, 12, 0, 0, 0, time.UTC)
+	token := &VerifyToken{email, uid, expires}
+
+	assert.Assert(t, !token.Expired(expires.Add(-time.Second)))
+	assert.Assert(t, token.Expired(expires))
+	assert.Assert(t, token.Expired(expires.Add(time.Second)))
+}
+
+func TestParseVerifyTokenKeys(t *testing.T) {
+	secret := strings.Repeat("s", minVerifyTokenSecretLength)
+
+	t.Run("Succeeds", func(t *testing.T) {
+		keys, err := ParseVerifyTokenKeys(
+			"2023-09:" + secret + ", 2023_08:" + secret + "x",
+		)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, VerifyTokenKeys{
+			{"2023-09", []byte(secret)}, {"2023_08", []byte(secret + "x")},
+		}, keys)
+	})
+
+	t.Run("FailsIfKeyIdInvalid", func(t *testing.T) {
+		for _, value := range []string{
+			"", ":" + secret, "v.1:" + secret, secret,
+			strings.Repeat("k", 17) + ":" + secret,
+		} {
+			keys, err := ParseVerifyTokenKeys(value)
+
+			assert.Assert(t, is.Nil(keys), value)
+			assert.Assert(t, tu.ErrorIs(err, ErrInvalidVerifyTokenKeys), value)
+			assert.ErrorContains(t, err, "invalid key ID", value)
+		}
+	})
+
+	t.Run("FailsIfKeyIdDuplicated", func(t *testing.T) {
+		keys, err := ParseVerifyTokenKeys("v1:" + secret + ",v1:" + secret)
+
+		assert.Assert(t, is.Nil(keys))
+		assert.Assert(t, tu.ErrorIs(err, ErrInvalidVerifyTokenKeys))
+		assert.ErrorContains(t, err, "duplicate key ID: v1")
+	})
+
+	t.Run("FailsIfSecretTooShort", func(t *testing.T) {
+		keys, err := ParseVerifyTokenKeys("v1:" + secret[1:])
+
+		assert.Assert(t, is.Nil(keys))
+		assert.Assert(t, tu.ErrorIs(err, ErrInvalidVerifyTokenKeys))
+		assert.ErrorContains(t, err, "secret for key v1 is 31 bytes")
+	})
+}
+
+func TestVerifyTokenKeys(t *testing.T) {
+	expires := time.Date(2023, time.September, 18, 12, 0, 0, 0, time.UTC)
+	token := &VerifyToken{email, uid, expires}
+
+	t.Run("SignsWithFirstKeyAndParses", func(t *testing.T) {
+		signed := testVerifyTokenKeys.Sign(token)
+
+		assert.Assert(t, strings.HasPrefix(signed, "new."), signed)
+		parsed, err := testVerifyTokenKeys.Parse(signed)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, token, parsed)
+	})
+
+	t.Run("ParsesTokenSignedByRotatedKey", func(t *testing.T) {
+		signed := testVerifyTokenKeys[1:].Sign(token)
+
+		parsed, err := testVerifyTokenKeys.Parse(signed)
+
+		assert.NilError(t, err)
+		assert.DeepEqual(t, token, parsed)
+	})
+
+	t.Run("SignedTokenIsUnescapedInUrls", func(t *testing.T) {
+		signed := testVerifyTokenKeys.Sign(token)
+
+		url := VerifyUrl(baseUrl, "", email, uid, testVerifyTokenKeys, expires)
+
+		assert.Assert(t, strings.HasSuffix(url, "/"+signed), url)
+	})
+
+	t.Run("ParseFails", func(t *testing.T) {
+		signed := testVerifyTokenKeys.Sign(token)
+		keyId, rest, _ := strings.Cut(signed, ".")
+		payload, signature, _ := strings.Cut(rest, ".")
+		otherPayload := strings.Split(
+			testVerifyTokenKeys.Sign(&VerifyToken{"x" + email, uid, expires}),
+			".",
+		)[1]
+		badKey := VerifyTokenKeys{
+			{"new", []byte(strings.Repeat("x", minVerifyTokenSecretLength))},
+		}
+
+		for _, tc := range []struct{ name, signed, errMsg string }{
+			{"WrongFormat", keyId + "." + payload, "wrong format"},
+			{"WrongFormatUid", uidStr, "wrong format"},
+			{"UnknownKeyId", "bogus." + rest, "unknown key ID: bogus"},
+			{
+				"AlteredPayload",
+				keyId + "." + otherPayload + "." + signature,
+				"bad signature",
+			},
+			{"WrongKey", badKey.Sign(token), "bad signature"},
+			{
+				"BadPayload",
+				testVerifyTokenKeys[:1].signedForTest("AAAA"),
+				"bad payload",
+			},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				parsed, err := testVerifyTokenKeys.Parse(tc.signed)
+
+				assert.Assert(t, is.Nil(parsed))
+				assert.Assert(t, tu.ErrorIs(err, ErrInvalidVerifyToken))
+				assert.ErrorContains(t, err, tc.errMsg)
+			})
+		}
+	})
+}
+
+// signedForTest signs an arbitrary payload with the first key.
+func (keys VerifyTokenKeys) signedForTest(payload string) string {
+	signed := keys[0].Id + "." + payload
+	return signed + "." + keys[0].signature(signed)
+}
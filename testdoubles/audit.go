@@ -0,0 +1,61 @@
+package testdoubles
+
+import (
+	"context"
+
+	"github.com/mbland/elistman/db"
+)
+
+type AuditLog struct {
+	Events    []*db.AuditEvent
+	PutErr    error
+	GetErr    error
+	DeleteErr error
+}
+
+func NewAuditLog() *AuditLog {
+	return &AuditLog{Events: make([]*db.AuditEvent, 0, 10)}
+}
+
+func (al *AuditLog) PutAuditEvent(
+	_ context.Context, event *db.AuditEvent,
+) error {
+	if al.PutErr != nil {
+		return al.PutErr
+	}
+	eventCopy := *event
+	al.Events = append(al.Events, &eventCopy)
+	return nil
+}
+
+func (al *AuditLog) GetAuditEvents(
+	_ context.Context, email string,
+) (events []*db.AuditEvent, err error) {
+	if err = al.GetErr; err != nil {
+		return
+	}
+	events = make([]*db.AuditEvent, 0, len(al.Events))
+
+	for _, e := range al.Events {
+		if e.Email == email {
+			eventCopy := *e
+			events = append(events, &eventCopy)
+		}
+	}
+	return
+}
+
+func (al *AuditLog) DeleteAuditEvents(_ context.Context, email string) error {
+	if al.DeleteErr != nil {
+		return al.DeleteErr
+	}
+	events := make([]*db.AuditEvent, 0, len(al.Events))
+
+	for _, e := range al.Events {
+		if e.Email != email {
+			events = append(events, e)
+		}
+	}
+	al.Events = events
+	return nil
+}
@@ -0,0 +1,60 @@
+package testdoubles
+
+import (
+	"context"
+
+	"github.com/mbland/elistman/db"
+)
+
+type CampaignStore struct {
+	Campaigns map[string]*db.Campaign
+	GetErr    error
+	PutErr    error
+	ListErr   error
+}
+
+func NewCampaignStore() *CampaignStore {
+	return &CampaignStore{Campaigns: make(map[string]*db.Campaign, 10)}
+}
+
+func (cs *CampaignStore) GetCampaign(
+	_ context.Context, id string,
+) (campaign *db.Campaign, err error) {
+	if err = cs.GetErr; err != nil {
+		return
+	} else if saved, ok := cs.Campaigns[id]; !ok {
+		err = db.ErrCampaignNotFound
+	} else {
+		// Return a copy so the caller can't update the stored campaign without
+		// calling PutCampaign.
+		campaignCopy := *saved
+		campaign = &campaignCopy
+	}
+	return
+}
+
+func (cs *CampaignStore) PutCampaign(
+	_ context.Context, campaign *db.Campaign,
+) error {
+	if cs.PutErr != nil {
+		return cs.PutErr
+	}
+	campaignCopy := *campaign
+	cs.Campaigns[campaign.Id] = &campaignCopy
+	return nil
+}
+
+func (cs *CampaignStore) ListCampaigns(
+	_ context.Context,
+) (campaigns []*db.Campaign, err error) {
+	if err = cs.ListErr; err != nil {
+		return
+	}
+	campaigns = make([]*db.Campaign, 0, len(cs.Campaigns))
+
+	for _, c := range cs.Campaigns {
+		campaignCopy := *c
+		campaigns = append(campaigns, &campaignCopy)
+	}
+	return
+}
@@ -0,0 +1,48 @@
+package testdoubles
+
+import (
+	"context"
+
+	"github.com/mbland/elistman/db"
+)
+
+type CheckpointStore struct {
+	Checkpoints map[string]*db.SendCheckpoint
+	GetErr      error
+	PutErr      error
+	NumPuts     int
+}
+
+func NewCheckpointStore() *CheckpointStore {
+	return &CheckpointStore{
+		Checkpoints: make(map[string]*db.SendCheckpoint, 10),
+	}
+}
+
+func (cs *CheckpointStore) GetCheckpoint(
+	_ context.Context, campaignId string,
+) (cp *db.SendCheckpoint, err error) {
+	if err = cs.GetErr; err != nil {
+		return
+	} else if saved, ok := cs.Checkpoints[campaignId]; !ok {
+		err = db.ErrCheckpointNotFound
+	} else {
+		// Return a copy so the caller can't update the stored checkpoint
+		// without calling PutCheckpoint.
+		cpCopy := *saved
+		cp = &cpCopy
+	}
+	return
+}
+
+func (cs *CheckpointStore) PutCheckpoint(
+	_ context.Context, cp *db.SendCheckpoint,
+) error {
+	if cs.PutErr != nil {
+		return cs.PutErr
+	}
+	cpCopy := *cp
+	cs.Checkpoints[cp.CampaignId] = &cpCopy
+	cs.NumPuts++
+	return nil
+}
@@ -2,7 +2,10 @@ package testdoubles
 
 import (
 	"context"
+	"slices"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/mbland/elistman/db"
 )
 
@@ -13,7 +16,12 @@ type Database struct {
 	SimulateDelErr      func(emailAddress string) error
 	SimulateCountErr    func(emailAddress string) error
 	SimulateProcSubsErr func(emailAddress string) error
+	SimulateMarkErr     func(emailAddress string) error
+	SimulateReceivedErr func(emailAddress string) error
+	SimulateRotateErr   func(emailAddress string) error
+	SimulateResendErr   func(emailAddress string) error
 	Index               map[string]*db.Subscriber
+	Receipts            map[string][]string
 }
 
 func NewDatabase() *Database {
@@ -27,7 +35,12 @@ func NewDatabase() *Database {
 		SimulateDelErr:      simulateNilError,
 		SimulateCountErr:    simulateNilError,
 		SimulateProcSubsErr: simulateNilError,
+		SimulateMarkErr:     simulateNilError,
+		SimulateReceivedErr: simulateNilError,
+		SimulateRotateErr:   simulateNilError,
+		SimulateResendErr:   simulateNilError,
 		Index:               make(map[string]*db.Subscriber, 10),
+		Receipts:            make(map[string][]string, 10),
 	}
 }
 
@@ -100,11 +113,37 @@ func (dbase *Database) CountSubscribers(
 }
 
 func (dbase *Database) ProcessSubscribers(
-	_ context.Context, status db.SubscriberStatus, sp db.SubscriberProcessor,
+	ctx context.Context, status db.SubscriberStatus, sp db.SubscriberProcessor,
 ) error {
+	return dbase.ProcessSubscribersFrom(ctx, status, nil, sp)
+}
+
+func (dbase *Database) ProcessSubscribersFrom(
+	ctx context.Context,
+	status db.SubscriberStatus,
+	startKey *db.ScanKey,
+	sp db.SubscriberProcessor,
+) error {
+	return dbase.ProcessTaggedSubscribersFrom(ctx, status, nil, startKey, sp)
+}
+
+func (dbase *Database) ProcessTaggedSubscribersFrom(
+	_ context.Context,
+	status db.SubscriberStatus,
+	filter *db.TagFilter,
+	startKey *db.ScanKey,
+	sp db.SubscriberProcessor,
+) error {
+	started := startKey == nil
+
 	for _, sub := range dbase.Subscribers {
 		if sub.Status != status {
 			continue
+		} else if !started {
+			started = sub.Email == startKey.Email
+			continue
+		} else if !filter.Matches(sub.Tags) {
+			continue
 		}
 
 		err := dbase.SimulateProcSubsErr(sub.Email)
@@ -114,3 +153,87 @@ func (dbase *Database) ProcessSubscribers(
 	}
 	return nil
 }
+
+func (dbase *Database) MarkReceived(
+	_ context.Context, email, campaignId string,
+) error {
+	if err := dbase.SimulateMarkErr(email); err != nil {
+		return err
+	}
+	dbase.Receipts[email] = append(dbase.Receipts[email], campaignId)
+	return nil
+}
+
+func (dbase *Database) HasReceived(
+	_ context.Context, email, campaignId string,
+) (bool, error) {
+	if err := dbase.SimulateReceivedErr(email); err != nil {
+		return false, err
+	}
+	return slices.Contains(dbase.Receipts[email], campaignId), nil
+}
+
+func (dbase *Database) DeleteReceipts(_ context.Context, email string) error {
+	if err := dbase.SimulateDelErr(email); err != nil {
+		return err
+	}
+	delete(dbase.Receipts, email)
+	return nil
+}
+
+func (dbase *Database) RotateUid(
+	_ context.Context, email string, uid uuid.UUID, expires time.Time,
+) error {
+	if err := dbase.SimulateRotateErr(email); err != nil {
+		return err
+	}
+
+	sub, ok := dbase.Index[email]
+	if !ok {
+		return db.ErrSubscriberNotFound
+	}
+
+	// Replace the original Subscriber with an updated copy. Tests often Put
+	// shared test data like db.TestSubscribers, which we mustn't modify.
+	updated := *sub
+	updated.PreviousUid = sub.Uid
+	updated.PreviousUidExpires = expires
+	updated.Uid = uid
+
+	for i, s := range dbase.Subscribers {
+		if s == sub {
+			dbase.Subscribers[i] = &updated
+		}
+	}
+	dbase.Index[email] = &updated
+	return nil
+}
+
+func (dbase *Database) RecordVerifySent(
+	_ context.Context, sub *db.Subscriber, prevCount int,
+) error {
+	if err := dbase.SimulateResendErr(sub.Email); err != nil {
+		return err
+	}
+
+	stored, ok := dbase.Index[sub.Email]
+	if !ok || stored.Status != db.SubscriberPending ||
+		stored.VerifySentCount != prevCount {
+		return db.ErrVerifySentChanged
+	}
+
+	// As in RotateUid, replace the original Subscriber with an updated copy.
+	updated := *stored
+	updated.VerifySentCount = sub.VerifySentCount
+	updated.VerifySentAt = sub.VerifySentAt
+	updated.Timestamp = sub.Timestamp
+	updated.Tags = sub.Tags
+
+	for i, s := range dbase.Subscribers {
+		if s == stored {
+			dbase.Subscribers[i] = &updated
+		}
+	}
+	dbase.Index[sub.Email] = &updated
+	return nil
+}
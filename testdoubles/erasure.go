@@ -0,0 +1,44 @@
+package testdoubles
+
+import (
+	"context"
+	"maps"
+	"slices"
+)
+
+type ErasureStore struct {
+	Hashes  map[string]bool
+	PutErr  error
+	GetErr  error
+	ListErr error
+}
+
+func NewErasureStore() *ErasureStore {
+	return &ErasureStore{Hashes: make(map[string]bool, 10)}
+}
+
+func (es *ErasureStore) PutErasure(_ context.Context, hash string) error {
+	if es.PutErr != nil {
+		return es.PutErr
+	}
+	es.Hashes[hash] = true
+	return nil
+}
+
+func (es *ErasureStore) IsErased(
+	_ context.Context, hash string,
+) (erased bool, err error) {
+	if err = es.GetErr; err == nil {
+		erased = es.Hashes[hash]
+	}
+	return
+}
+
+func (es *ErasureStore) ListErasures(
+	_ context.Context,
+) (hashes []string, err error) {
+	if err = es.ListErr; err == nil {
+		hashes = slices.Collect(maps.Keys(es.Hashes))
+	}
+	return
+}
@@ -0,0 +1,91 @@
+package testdoubles
+
+import (
+	"context"
+	"time"
+
+	"github.com/mbland/elistman/db"
+)
+
+type ScheduleStore struct {
+	Messages   map[string]*db.ScheduledMessage
+	PutErr     error
+	GetDueErr  error
+	ClaimErr   error
+	ReleaseErr error
+	DeleteErr  error
+}
+
+func NewScheduleStore() *ScheduleStore {
+	return &ScheduleStore{
+		Messages: make(map[string]*db.ScheduledMessage, 10),
+	}
+}
+
+func (ss *ScheduleStore) PutScheduledMessage(
+	_ context.Context, msg *db.ScheduledMessage,
+) error {
+	if ss.PutErr != nil {
+		return ss.PutErr
+	}
+	msgCopy := *msg
+	ss.Messages[msg.Id] = &msgCopy
+	return nil
+}
+
+func (ss *ScheduleStore) GetDueMessages(
+	_ context.Context, now time.Time,
+) (due []*db.ScheduledMessage, err error) {
+	if err = ss.GetDueErr; err != nil {
+		return
+	}
+	due = make([]*db.ScheduledMessage, 0, len(ss.Messages))
+
+	for _, msg := range ss.Messages {
+		if !msg.SendAt.After(now) {
+			msgCopy := *msg
+			due = append(due, &msgCopy)
+		}
+	}
+	return
+}
+
+func (ss *ScheduleStore) ClaimScheduledMessage(
+	_ context.Context, id string, now, until time.Time,
+) error {
+	if ss.ClaimErr != nil {
+		return ss.ClaimErr
+	}
+	msg, ok := ss.Messages[id]
+	if !ok || msg.ClaimedUntil.After(now) {
+		return db.ErrScheduledMessageClaimed
+	}
+	msgCopy := *msg
+	msgCopy.ClaimedUntil = until
+	ss.Messages[id] = &msgCopy
+	return nil
+}
+
+func (ss *ScheduleStore) ReleaseScheduledMessage(
+	_ context.Context, id string, until time.Time,
+) error {
+	if ss.ReleaseErr != nil {
+		return ss.ReleaseErr
+	}
+	if msg, ok := ss.Messages[id]; ok && msg.ClaimedUntil.Equal(until) {
+		msgCopy := *msg
+		msgCopy.ClaimedUntil = time.Time{}
+		ss.Messages[id] = &msgCopy
+	}
+	return nil
+}
+
+func (ss *ScheduleStore) DeleteScheduledMessage(
+	_ context.Context, id string,
+) error {
+	if ss.DeleteErr != nil {
+		return ss.DeleteErr
+	}
+	delete(ss.Messages, id)
+	return nil
+}
@@ -24,7 +24,7 @@ func (s *Suppressor) IsSuppressed(
 	if err = s.Errors[address]; err != nil {
 		return
 	}
-	ok = s.Addresses[address] == ops.RemoveReasonNil
+	_, ok = s.Addresses[address]
 	return
 }
 
@@ -0,0 +1,68 @@
+package testdoubles
+
+import (
+	"context"
+
+	"github.com/mbland/elistman/db"
+)
+
+type TombstoneStore struct {
+	Tombstones map[string]*db.Tombstone
+	GetErr     error
+	PutErr     error
+	DeleteErr  error
+	ListErr    error
+}
+
+func NewTombstoneStore() *TombstoneStore {
+	return &TombstoneStore{Tombstones: make(map[string]*db.Tombstone, 10)}
+}
+
+func (ts *TombstoneStore) GetTombstone(
+	_ context.Context, email string,
+) (tombstone *db.Tombstone, err error) {
+	if err = ts.GetErr; err != nil {
+		return
+	} else if saved, ok := ts.Tombstones[email]; !ok {
+		err = db.ErrTombstoneNotFound
+	} else {
+		tombstoneCopy := *saved
+		tombstone = &tombstoneCopy
+	}
+	return
+}
+
+func (ts *TombstoneStore) PutTombstone(
+	_ context.Context, tombstone *db.Tombstone,
+) error {
+	if ts.PutErr != nil {
+		return ts.PutErr
+	}
+	tombstoneCopy := *tombstone
+	ts.Tombstones[tombstone.Email] = &tombstoneCopy
+	return nil
+}
+
+func (ts *TombstoneStore) DeleteTombstone(
+	_ context.Context, email string,
+) error {
+	if ts.DeleteErr != nil {
+		return ts.DeleteErr
+	}
+	delete(ts.Tombstones, email)
+	return nil
+}
+
+func (ts *TombstoneStore) ListTombstones(
+	_ context.Context,
+) (tombstones []*db.Tombstone, err error) {
+	if err = ts.ListErr; err != nil {
+		return
+	}
+	tombstones = make([]*db.Tombstone, 0, len(ts.Tombstones))
+	for _, saved := range ts.Tombstones {
+		tombstoneCopy := *saved
+		tombstones = append(tombstones, &tombstoneCopy)
+	}
+	return
+}
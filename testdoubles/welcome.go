@@ -0,0 +1,52 @@
+package testdoubles
+
+import (
+	"context"
+
+	"github.com/mbland/elistman/db"
+	"github.com/mbland/elistman/email"
+)
+
+type WelcomeStore struct {
+	Message   *email.Message
+	GetErr    error
+	PutErr    error
+	DeleteErr error
+}
+
+func NewWelcomeStore() *WelcomeStore {
+	return &WelcomeStore{}
+}
+
+func (ws *WelcomeStore) GetWelcomeMessage(
+	_ context.Context,
+) (msg *email.Message, err error) {
+	if err = ws.GetErr; err != nil {
+		return
+	} else if ws.Message == nil {
+		err = db.ErrWelcomeMessageNotFound
+	} else {
+		msgCopy := *ws.Message
+		msg = &msgCopy
+	}
+	return
+}
+
+func (ws *WelcomeStore) PutWelcomeMessage(
+	_ context.Context, msg *email.Message,
+) error {
+	if ws.PutErr != nil {
+		return ws.PutErr
+	}
+	msgCopy := *msg
+	ws.Message = &msgCopy
+	return nil
+}
+
+func (ws *WelcomeStore) DeleteWelcomeMessage(_ context.Context) error {
+	if ws.DeleteErr != nil {
+		return ws.DeleteErr
+	}
+	ws.Message = nil
+	return nil
+}
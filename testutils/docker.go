@@ -27,11 +27,21 @@ func PullDockerImage(dbImage string) error {
 	return nil
 }
 
+// LaunchDockerContainer runs dbImage in a new container, mapping localEndpoint
+// to containerPort.
+//
+// dockerArgs are additional arguments for "docker run", such as "-e" options
+// setting environment variables within the container.
 func LaunchDockerContainer(
-	service string, localEndpoint BaseEndpoint, containerPort int, dbImage string,
+	service string,
+	localEndpoint BaseEndpoint,
+	containerPort int,
+	dbImage string,
+	dockerArgs ...string,
 ) (cleanup func() error, err error) {
 	portMap := fmt.Sprintf("%s:%d", localEndpoint, containerPort)
-	cmd := exec.Command("docker", "run", "-d", "-p", portMap, dbImage)
+	args := append([]string{"run", "-d", "-p", portMap}, dockerArgs...)
+	cmd := exec.Command("docker", append(args, dbImage)...)
 	var output []byte
 
 	if err = PullDockerImage(dbImage); err != nil {